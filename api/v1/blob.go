@@ -30,6 +30,18 @@ be the binary data to store. The response should have the following structure:
 GET requests can be used to retrieve a binary blobs with a specific ID. Binary blobs
 can be updated by sending a PUT request and removed by sending a DELETE request.
 
+Large binary blobs are stored in chunks and are streamed when they are sent
+or retrieved. The chunks of a large binary blob which is sent are written to
+disk while the request body is read. A large binary blob which is updated or
+removed while it is retrieved is sent completely in the version which existed
+when the request started. Writes to a partition are blocked while a large
+binary blob is sent to it - other partitions are not affected.
+
+The chunks of a large binary blob which was sent when the server stopped are
+freed when the partition is used again. The chunks of a large binary blob
+which was updated or removed while it was retrieved are only freed once the
+retrieval has finished. They are not freed if the server stops before that.
+
 
 Cluster control endpoint
 
@@ -404,6 +416,30 @@ selected nodes to/from the given (existing) group.
 /queryresult/<rid>/csv
 
 The csv endpoint returns the search result as CSV string.
+
+
+Snapshot endpoint
+
+/snapshot
+
+The snapshot endpoint creates a consistent copy of the datastore while the
+server is running. Snapshots are stored in the configured snapshot directory.
+A GET request returns a list of all existing snapshots:
+
+	[ <snapshot name1>, <snapshot name2>, ... ]
+
+A new snapshot is created by sending a POST request. Write operations are
+blocked while the snapshot is taken. The response has the following structure:
+
+	{
+		name : <Name of the new snapshot>
+	}
+
+/snapshot/<name>
+
+A POST request creates a snapshot with a specific name. A snapshot can be
+restored with the restore command of the eliasdb executable while the server
+is not running.
 */
 package v1
 
@@ -411,10 +447,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"sync"
 
 	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/hash"
 	"github.com/krotik/eliasdb/storage"
 )
 
@@ -423,6 +462,45 @@ StorageSuffixBlob is the suffix for binary blob storage
 */
 const StorageSuffixBlob = ".blob"
 
+/*
+BlobLargeObjectThreshold is the size in bytes from which binary blobs are
+stored as large objects. Large objects are stored in chunks and are streamed
+when they are written or read.
+*/
+var BlobLargeObjectThreshold = 1024 * 1024
+
+/*
+RootIDBlobLargeObjects is the root ID which holds the location of the HTree
+which contains the IDs of all binary blobs which are stored as large objects
+*/
+const RootIDBlobLargeObjects = 2
+
+/*
+BlobFlushInterval is the number of chunks after which the chunks of a large
+binary blob are written to disk while it is sent.
+*/
+var BlobFlushInterval = 16
+
+/*
+blobPartition holds the lock of a binary blob partition - writers hold the
+lock for the whole operation, readers only while opening a large object and
+while closing it again
+*/
+type blobPartition struct {
+	lock      *sync.RWMutex // Lock of the partition
+	reclaimed bool          // Flag if interrupted writes were reclaimed
+}
+
+/*
+blobPartitions holds the binary blob partitions which were used
+*/
+var blobPartitions = make(map[storage.Manager]*blobPartition)
+
+/*
+blobPartitionsLock protects the map of binary blob partitions
+*/
+var blobPartitionsLock = &sync.Mutex{}
+
 /*
 EndpointBlob is the blob endpoint URL (rooted). Handles everything under blob/...
 */
@@ -465,13 +543,54 @@ func (be *blobEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 	sm := api.GS.StorageManager(resources[0]+StorageSuffixBlob, false)
 
 	if sm != nil {
+		var lr *storage.LargeObjectReader
+
+		blobLock, err := blobPartitionLock(sm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		blobLock.RLock()
+
+		large, err := isLargeBlob(sm, loc)
+
+		if err == nil && large {
+			lr, err = storage.OpenLargeObject(sm, loc)
 
-		res, err = sm.FetchCached(loc)
+		} else if err == nil {
+			res, err = sm.FetchCached(loc)
 
-		if sme, ok := err.(*storage.ManagerError); ok && sme.Type == storage.ErrNotInCache {
-			err = sm.Fetch(loc, &ret)
-		} else if err == nil && res != nil {
-			ret = res.([]byte)
+			if sme, ok := err.(*storage.ManagerError); ok && sme.Type == storage.ErrNotInCache {
+				err = sm.Fetch(loc, &ret)
+			} else if err == nil && res != nil {
+				ret = res.([]byte)
+			}
+		}
+
+		blobLock.RUnlock()
+
+		if err != nil && large {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Large blobs are streamed chunk by chunk - the chunks cannot be
+		// freed until the reader has been closed
+
+		if lr != nil {
+			w.Header().Set("content-type", "application/octet-stream")
+			w.Header().Set("content-length", fmt.Sprint(lr.Size()))
+			io.Copy(w, lr)
+
+			blobLock.Lock()
+			defer blobLock.Unlock()
+
+			if lr.Close() == nil {
+				sm.Flush()
+			}
+
+			return
 		}
 	}
 
@@ -481,11 +600,238 @@ func (be *blobEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 	w.Write(ret)
 }
 
+/*
+blobPartitionLock returns the lock of a binary blob partition. Large binary
+blobs which were written when the server stopped are reclaimed when the lock
+of their partition is requested for the first time.
+*/
+func blobPartitionLock(sm storage.Manager) (*sync.RWMutex, error) {
+	var err error
+
+	blobPartitionsLock.Lock()
+
+	p, ok := blobPartitions[sm]
+	if !ok {
+		p = &blobPartition{&sync.RWMutex{}, false}
+		blobPartitions[sm] = p
+	}
+
+	blobPartitionsLock.Unlock()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.reclaimed {
+		if err = reclaimLargeBlobs(sm); err == nil {
+			err = sm.Flush()
+		} else {
+			sm.Rollback()
+		}
+
+		p.reclaimed = err == nil
+	}
+
+	return p.lock, err
+}
+
+/*
+reclaimLargeBlobs frees the chunks of all interrupted writes of large binary
+blobs. Large binary blobs which were created by an interrupted write are
+removed.
+*/
+func reclaimLargeBlobs(sm storage.Manager) error {
+	var locs []uint64
+
+	tree, err := largeBlobIndex(sm, false)
+	if tree == nil || err != nil {
+		return err
+	}
+
+	it := hash.NewHTreeIterator(tree)
+
+	for it.HasNext() {
+		key, _ := it.Next()
+
+		if loc, err := strconv.ParseUint(string(key), 10, 64); err == nil {
+			locs = append(locs, loc)
+		}
+	}
+
+	if it.LastError != nil {
+		return it.LastError
+	}
+
+	for _, loc := range locs {
+		var objLocs []uint64
+
+		reclaimed, err := storage.ReclaimLargeObject(sm, loc)
+
+		if err == nil && reclaimed {
+
+			// Large objects without chunks were created by an interrupted write
+
+			if objLocs, err = storage.LargeObjectLocations(sm, loc); err == nil && len(objLocs) == 1 {
+				if err = storage.FreeLargeObject(sm, loc); err == nil {
+					err = removeLargeBlob(sm, loc)
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+largeBlobIndex returns the HTree which contains the IDs of all binary blobs
+which are stored as large objects. Returns nil if the HTree does not exist and
+the create flag is not set.
+*/
+func largeBlobIndex(sm storage.Manager, create bool) (*hash.HTree, error) {
+	var tree *hash.HTree
+	var err error
+
+	if loc := sm.Root(RootIDBlobLargeObjects); loc != 0 {
+		tree, err = hash.LoadHTree(sm, loc)
+
+	} else if create {
+		if tree, err = hash.NewHTree(sm); err == nil {
+			sm.SetRoot(RootIDBlobLargeObjects, tree.Location())
+		}
+	}
+
+	return tree, err
+}
+
+/*
+isLargeBlob checks if a binary blob is stored as a large object.
+*/
+func isLargeBlob(sm storage.Manager, loc uint64) (bool, error) {
+
+	tree, err := largeBlobIndex(sm, false)
+	if tree == nil || err != nil {
+		return false, err
+	}
+
+	return tree.Exists([]byte(fmt.Sprint(loc)))
+}
+
+/*
+addLargeBlob records that a binary blob is stored as a large object.
+*/
+func addLargeBlob(sm storage.Manager, loc uint64) error {
+
+	tree, err := largeBlobIndex(sm, true)
+	if err == nil {
+		_, err = tree.Put([]byte(fmt.Sprint(loc)), true)
+	}
+
+	return err
+}
+
+/*
+removeLargeBlob removes a binary blob from the large object index.
+*/
+func removeLargeBlob(sm storage.Manager, loc uint64) error {
+
+	tree, err := largeBlobIndex(sm, false)
+	if tree != nil && err == nil {
+		_, err = tree.Remove([]byte(fmt.Sprint(loc)))
+	}
+
+	return err
+}
+
+/*
+readBlob reads the body of a request. Returns the whole body if it is smaller
+than BlobLargeObjectThreshold. Otherwise the data which was read so far is
+returned together with a flag that the body has not been read completely.
+*/
+func readBlob(r *http.Request) ([]byte, bool, error) {
+	var buf bytes.Buffer
+
+	n, err := buf.ReadFrom(io.LimitReader(r.Body, int64(BlobLargeObjectThreshold)))
+
+	return buf.Bytes(), n == int64(BlobLargeObjectThreshold), err
+}
+
+/*
+writeLargeBlob streams a request body into a large object writer. The chunks
+are written to disk while the body is read. The write is aborted if the body
+cannot be written completely - a created large object is removed again. The
+storage manager has been flushed or rolled back if an error is returned.
+*/
+func writeLargeBlob(sm storage.Manager, lw *storage.LargeObjectWriter, created bool,
+	start []byte, r *http.Request) error {
+
+	lw.SetFlushInterval(BlobFlushInterval)
+
+	_, err := lw.Write(start)
+	if err == nil {
+		_, err = io.Copy(lw, r.Body)
+	}
+
+	if err == nil {
+		err = lw.Close()
+	}
+
+	if err != nil {
+
+		// Flushed chunks cannot be rolled back and must be freed
+
+		aerr := lw.Abort()
+
+		if aerr == nil && created {
+			if aerr = storage.FreeLargeObject(sm, lw.Location()); aerr == nil {
+				aerr = removeLargeBlob(sm, lw.Location())
+			}
+		}
+
+		if aerr == nil {
+			aerr = sm.Flush()
+		}
+
+		if aerr != nil {
+			sm.Rollback()
+		}
+	}
+
+	return err
+}
+
+/*
+convertToLargeBlob turns a binary blob into a large object. The existing data
+becomes the only chunk of the large object so it is kept if a following write
+is interrupted.
+*/
+func convertToLargeBlob(sm storage.Manager, loc uint64) error {
+	var data []byte
+
+	err := sm.Fetch(loc, &data)
+
+	if err == nil {
+		var chunk uint64
+
+		if chunk, err = sm.Insert(data); err == nil {
+			header := &storage.LargeObjectHeader{Size: uint64(len(data)), Chunks: []uint64{chunk}}
+
+			if err = sm.Update(loc, header); err == nil {
+				err = addLargeBlob(sm, loc)
+			}
+		}
+	}
+
+	return err
+}
+
 /*
 HandlePOST handles a REST call to store new binary data.
 */
 func (be *blobEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
-	var buf bytes.Buffer
+	var loc uint64
 
 	// Check parameters
 
@@ -495,13 +841,48 @@ func (be *blobEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resou
 
 	sm := api.GS.StorageManager(resources[0]+StorageSuffixBlob, true)
 
-	// Use a memory buffer to read send data
+	// Read the start of the send data before the partition is locked - large
+	// blobs are streamed into a large object
+
+	data, large, err := readBlob(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	blobLock, err := blobPartitionLock(sm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	blobLock.Lock()
+	defer blobLock.Unlock()
+
+	if !large {
+		loc, err = sm.Insert(data)
 
-	buf.ReadFrom(r.Body)
+	} else {
+		var lw *storage.LargeObjectWriter
 
-	loc, err := sm.Insert(buf.Bytes())
+		if lw, err = storage.CreateLargeObject(sm); err == nil {
+			loc = lw.Location()
+
+			// The blob is indexed before its data is written so an
+			// interrupted write can be reclaimed
+
+			if err = addLargeBlob(sm, loc); err == nil {
+				if err = writeLargeBlob(sm, lw, true, data, r); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+	}
 
 	if err != nil {
+		sm.Rollback()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -522,7 +903,6 @@ func (be *blobEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resou
 HandlePUT handles a REST call to update existing binary data.
 */
 func (be *blobEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
-	var buf bytes.Buffer
 
 	// Check parameters
 
@@ -540,12 +920,58 @@ func (be *blobEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resour
 	sm := api.GS.StorageManager(resources[0]+StorageSuffixBlob, false)
 
 	if sm != nil {
+		var isLarge bool
+		var lw *storage.LargeObjectWriter
+
+		// Read the start of the send data before the partition is locked -
+		// large blobs are streamed into a large object
+
+		data, large, err := readBlob(r)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		// Use a memory buffer to read send data
+		blobLock, err := blobPartitionLock(sm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		blobLock.Lock()
+		defer blobLock.Unlock()
+
+		isLarge, err = isLargeBlob(sm, loc)
+
+		if err == nil {
+
+			if isLarge {
+
+				// Existing large blobs stay large objects
+
+				lw, err = storage.RewriteLargeObject(sm, loc)
+
+			} else if !large {
+				err = sm.Update(loc, data)
+
+			} else if err = convertToLargeBlob(sm, loc); err == nil {
+
+				// Turn the existing blob into a large object
+
+				lw, err = storage.RewriteLargeObject(sm, loc)
+			}
+		}
 
-		buf.ReadFrom(r.Body)
+		if err == nil && lw != nil {
+			if err = writeLargeBlob(sm, lw, false, data, r); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 
-		if err := sm.Update(loc, buf.Bytes()); err != nil {
+		if err != nil {
+			sm.Rollback()
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -576,7 +1002,32 @@ func (be *blobEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, res
 
 	if sm != nil {
 
-		if err := sm.Free(loc); err != nil {
+		blobLock, err := blobPartitionLock(sm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		blobLock.Lock()
+		defer blobLock.Unlock()
+
+		large, err := isLargeBlob(sm, loc)
+
+		if err == nil && large {
+
+			// Remove all chunks of a large blob - chunks which are currently
+			// sent are freed once the sending has finished
+
+			if err = storage.FreeLargeObject(sm, loc); err == nil {
+				err = removeLargeBlob(sm, loc)
+			}
+
+		} else if err == nil {
+			err = sm.Free(loc)
+		}
+
+		if err != nil {
+			sm.Rollback()
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -11,7 +11,9 @@
 package v1
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"testing"
 
 	"github.com/krotik/eliasdb/storage"
@@ -174,3 +176,204 @@ func TestBlob(t *testing.T) {
 		return
 	}
 }
+
+func TestBlobLargeObject(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointBlob + "largepart/"
+
+	oldThreshold := BlobLargeObjectThreshold
+	oldChunkSize := storage.LargeObjectChunkSize
+	BlobLargeObjectThreshold = 4
+	storage.LargeObjectChunkSize = 3
+	defer func() {
+		BlobLargeObjectThreshold = oldThreshold
+		storage.LargeObjectChunkSize = oldChunkSize
+	}()
+
+	st, _, res := sendTestRequest(queryURL, "POST", []byte("large blob data"))
+
+	if st != "200 OK" || res != `
+{
+  "id": 1
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	msm := gmMSM.StorageManager("largepart"+StorageSuffixBlob, false).(*storage.MemoryStorageManager)
+
+	// The blob data and the large object index are stored
+
+	if len(msm.Data) != 8 {
+		t.Error("Unexpected number of stored objects:", len(msm.Data))
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"1", "GET", nil)
+
+	if st != "200 OK" || res != "large blob data" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Small blobs are stored as they are
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte("abc"))
+
+	if st != "200 OK" || res != `
+{
+  "id": 9
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Update the large blob and turn the small blob into a large blob
+
+	st, _, res = sendTestRequest(queryURL+"1", "PUT", []byte("abc"))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"9", "PUT", []byte("another large blob"))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"1", "GET", nil)
+
+	if st != "200 OK" || res != "abc" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"9", "GET", nil)
+
+	if st != "200 OK" || res != "another large blob" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if len(msm.Data) != 11 {
+		t.Error("Unexpected number of stored objects:", len(msm.Data))
+		return
+	}
+
+	// Removing large blobs removes all their chunks
+
+	sendTestRequest(queryURL+"1", "DELETE", nil)
+	sendTestRequest(queryURL+"9", "DELETE", nil)
+
+	// Only the root of the large object index remains
+
+	if len(msm.Data) != 1 {
+		t.Error("Unexpected number of stored objects:", len(msm.Data))
+		return
+	}
+
+	// Chunks of a large blob which is currently sent are freed once the
+	// sending has finished
+
+	_, _, res = sendTestRequest(queryURL, "POST", []byte("large blob data"))
+
+	var ret map[string]uint64
+	json.Unmarshal([]byte(res), &ret)
+	loc := ret["id"]
+
+	lr, err := storage.OpenLargeObject(msm, loc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sendTestRequest(queryURL+fmt.Sprint(loc), "DELETE", nil)
+
+	if res, err := ioutil.ReadAll(lr); string(res) != "large blob data" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	if len(msm.Data) != 6 {
+		t.Error("Unexpected number of stored objects:", len(msm.Data))
+		return
+	}
+
+	lr.Close()
+
+	if len(msm.Data) != 1 {
+		t.Error("Unexpected number of stored objects:", len(msm.Data))
+		return
+	}
+
+	msm.AccessMap[msm.LocCount] = storage.AccessInsertError
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte("large blob data"))
+
+	if st != "500 Internal Server Error" || res != "Record is already in-use (<memory> - )" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	delete(msm.AccessMap, msm.LocCount)
+
+	// Chunks which were already flushed are freed if a large blob cannot
+	// be written completely
+
+	oldInterval := BlobFlushInterval
+	BlobFlushInterval = 1
+	defer func() {
+		BlobFlushInterval = oldInterval
+	}()
+
+	errLoc := msm.LocCount + 4
+	msm.AccessMap[errLoc] = storage.AccessInsertError
+
+	st, _, res = sendTestRequest(queryURL, "POST", []byte("large blob data"))
+
+	if st != "500 Internal Server Error" || len(msm.Data) != 1 {
+		t.Error("Unexpected response:", st, res, len(msm.Data))
+		return
+	}
+
+	delete(msm.AccessMap, errLoc)
+
+	// Interrupted writes are reclaimed when the partition is used again
+
+	_, _, res = sendTestRequest(queryURL, "POST", []byte("large blob data"))
+
+	json.Unmarshal([]byte(res), &ret)
+	loc = ret["id"]
+
+	lw, _ := storage.RewriteLargeObject(msm, loc)
+	lw.SetFlushInterval(1)
+	lw.Write([]byte("interrupted rewrite"))
+
+	lw, _ = storage.CreateLargeObject(msm)
+	lw.SetFlushInterval(1)
+	lw.Write([]byte("interrupted create"))
+	addLargeBlob(msm, lw.Location())
+
+	blobPartitionsLock.Lock()
+	delete(blobPartitions, msm)
+	blobPartitionsLock.Unlock()
+
+	st, _, res = sendTestRequest(queryURL+fmt.Sprint(loc), "GET", nil)
+
+	if st != "200 OK" || res != "large blob data" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if len(msm.Data) != 8 {
+		t.Error("Unexpected number of stored objects:", len(msm.Data))
+		return
+	}
+
+	if ok, err := isLargeBlob(msm, lw.Location()); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+}
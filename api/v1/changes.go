@@ -0,0 +1,205 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+EndpointChanges is the change log endpoint URL (rooted). Handles everything under changes/...
+*/
+const EndpointChanges = api.APIRoot + APIv1 + "/changes/"
+
+/*
+ChangesDefaultLimit is the default number of change sets which are returned
+*/
+var ChangesDefaultLimit = 100
+
+/*
+ChangesMaxWait is the maximum time in seconds a request waits for new change sets
+*/
+var ChangesMaxWait = 60
+
+/*
+ChangesEndpointInst creates a new endpoint handler.
+*/
+func ChangesEndpointInst() api.RestEndpointHandler {
+	return &changesEndpoint{}
+}
+
+/*
+Handler object for change log operations.
+*/
+type changesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles REST calls to read change sets from the change log.
+*/
+func (ce *changesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 0, 0, "") {
+		return
+	}
+
+	// Get query parameters
+
+	from, ok := queryParamPosNum(w, r, "from")
+	if !ok {
+		return
+	}
+
+	limit, ok := queryParamPosNum(w, r, "limit")
+	if !ok {
+		return
+	} else if limit < 1 {
+		limit = ChangesDefaultLimit
+	}
+
+	wait, ok := queryParamPosNum(w, r, "wait")
+	if !ok {
+		return
+	} else if wait > ChangesMaxWait {
+		wait = ChangesMaxWait
+	}
+
+	if from < 0 {
+		from = 0
+	}
+
+	// Check if values should be returned with their value types
+
+	typed := stringutil.IsTrueValue(r.URL.Query().Get("typed"))
+
+	it, err := api.GM.ChangeLogIterator(uint64(from))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+
+	// Wait for new change sets if requested
+
+	if wait > 0 && !it.HasNext() {
+		api.GM.WaitForChanges(it.Sequence(), time.Duration(wait)*time.Second)
+	}
+
+	changes := []*graph.ChangeSet{}
+
+	for len(changes) < limit && it.HasNext() {
+
+		cs := it.Next()
+
+		if cs == nil {
+			if err := it.Error(); err != nil {
+				http.Error(w, err.Error(), http.StatusGone)
+				return
+			}
+			break
+		}
+
+		for _, c := range cs.Changes {
+			if c.Data != nil {
+				c.Data = encodeItemData(c.Data, typed)
+			}
+		}
+
+		changes = append(changes, cs)
+	}
+
+	first, last, err := api.GM.ChangeLogRange()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	e := json.NewEncoder(w)
+	e.Encode(map[string]interface{}{
+		"first":   first,
+		"last":    last,
+		"next":    it.Sequence(),
+		"changes": changes,
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ce *changesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/changes"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Read change sets from the change log.",
+			"description": "The changes endpoint returns the change sets of committed transactions in the order " +
+				"of their sequence numbers. A consumer can resume reading with the returned next sequence number. " +
+				"The change log needs to be enabled.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "from",
+					"in":          "query",
+					"description": "Sequence number of the first returned change set (default: first retained change set).",
+					"required":    false,
+					"type":        "integer",
+				},
+				{
+					"name":        "limit",
+					"in":          "query",
+					"description": "Maximum number of returned change sets.",
+					"required":    false,
+					"type":        "integer",
+				},
+				{
+					"name":        "wait",
+					"in":          "query",
+					"description": "Number of seconds to wait for a new change set if there is none (long polling).",
+					"required":    false,
+					"type":        "integer",
+				},
+				{
+					"name":        "typed",
+					"in":          "query",
+					"description": "Return values with their value types (see the graph endpoint).",
+					"required":    false,
+					"type":        "boolean",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The range of retained sequence numbers (first, last), the next sequence number and a list of change sets.",
+				},
+				"410": map[string]interface{}{
+					"description": "The requested change set is no longer retained.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+}
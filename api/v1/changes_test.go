@@ -0,0 +1,170 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestChanges(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointChanges
+
+	oldGM := api.GM
+	api.GM = graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("changestest"))
+
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "changes": [],
+  "first": 0,
+  "last": 0,
+  "next": 1
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.GM.EnableChangeLog(2)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "Person")
+	node.SetAttr("born", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	api.GM.StoreNode("main", node)
+
+	node.SetAttr("name", "Anne")
+	node.SetAttr("born", time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC))
+	api.GM.StoreNode("main", node)
+
+	api.GM.RemoveNode("main", "123", "Person")
+
+	st, _, res = sendTestRequest(queryURL+"?from=2&limit=1", "GET", nil)
+
+	var ret map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &ret); st != "200 OK" || err != nil {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	if ret["first"] != 2.0 || ret["last"] != 3.0 || ret["next"] != 3.0 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	changes := ret["changes"].([]interface{})
+	change := changes[0].(map[string]interface{})["changes"].([]interface{})[0].(map[string]interface{})
+
+	if len(changes) != 1 || changes[0].(map[string]interface{})["sequence"] != 2.0 ||
+		change["op"] != "update" || change["item"] != "node" || change["part"] != "main" ||
+		change["key"] != "123" || change["kind"] != "Person" || fmt.Sprint(change["attrs"]) != "[born name]" {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	// Values are only encoded with their value types if requested
+
+	if born, _ := json.Marshal(change["data"].(map[string]interface{})["born"]); string(born) !=
+		`"2020-01-02T03:04:06Z"` {
+		t.Error("Unexpected response:", string(born))
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?from=2&limit=1&typed=true", "GET", nil)
+
+	if err := json.Unmarshal([]byte(res), &ret); st != "200 OK" || err != nil {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	changes = ret["changes"].([]interface{})
+	change = changes[0].(map[string]interface{})["changes"].([]interface{})[0].(map[string]interface{})
+
+	if born, _ := json.Marshal(change["data"].(map[string]interface{})["born"]); string(born) !=
+		`{"$timestamp":"2020-01-02T03:04:06Z"}` {
+		t.Error("Unexpected response:", string(born))
+		return
+	}
+
+	// Wait for new change sets
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		api.GM.StoreNode("main", node)
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"?from=4&wait=5", "GET", nil)
+
+	if err := json.Unmarshal([]byte(res), &ret); st != "200 OK" || err != nil ||
+		ret["next"] != 5.0 || len(ret["changes"].([]interface{})) != 1 {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?from=5&wait=1", "GET", nil)
+
+	if err := json.Unmarshal([]byte(res), &ret); st != "200 OK" || err != nil ||
+		ret["next"] != 5.0 || len(ret["changes"].([]interface{})) != 0 {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	// Test error cases
+
+	st, _, res = sendTestRequest(queryURL+"?from=1", "GET", nil)
+
+	if st != "410 Gone" || res != "GraphError: Invalid data (Change set 1 is no longer "+
+		"in the change log - first retained change set: 3)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?from=a", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid parameter value: from should be a positive integer number" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?limit=a", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid parameter value: limit should be a positive integer number" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?wait=a", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid parameter value: wait should be a positive integer number" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid resource specification:" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
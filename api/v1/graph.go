@@ -16,7 +16,9 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"time"
 
+	"github.com/krotik/common/stringutil"
 	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/data"
@@ -57,6 +59,20 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		return
 	}
 
+	// Get asof parameter; zero if not set
+
+	asof, ok := queryParamTime(w, r, "asof")
+	if !ok {
+		return
+	} else if !asof.IsZero() && len(resources) != 4 {
+		http.Error(w, "Parameter asof is only supported when requesting a single node or edge", http.StatusBadRequest)
+		return
+	}
+
+	// Check if values should be returned with their value types
+
+	typed := stringutil.IsTrueValue(r.URL.Query().Get("typed"))
+
 	if len(resources) == 3 {
 
 		// Iterate over a list of nodes
@@ -137,7 +153,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 					return
 				}
 
-				data = append(data, node.Data())
+				data = append(data, encodeItemData(node.Data(), typed))
 			}
 
 			// Set total count header
@@ -164,7 +180,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 
 		if resources[1] == "n" {
 
-			node, err := api.GM.FetchNode(resources[0], resources[3], resources[2])
+			node, err := fetchNode(resources[0], resources[3], resources[2], asof)
 
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -174,11 +190,11 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				return
 			}
 
-			data = node.Data()
+			data = encodeItemData(node.Data(), typed)
 
 		} else {
 
-			edge, err := api.GM.FetchEdge(resources[0], resources[3], resources[2])
+			edge, err := fetchEdge(resources[0], resources[3], resources[2], asof)
 
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -188,7 +204,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				return
 			}
 
-			data = edge.Data()
+			data = encodeItemData(edge.Data(), typed)
 		}
 
 		// Write data
@@ -229,8 +245,8 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				for i, n := range nodes {
 					e := edges[i]
 
-					dataNodes = append(dataNodes, n.Data())
-					dataEdges = append(dataEdges, e.Data())
+					dataNodes = append(dataNodes, encodeItemData(n.Data(), typed))
+					dataEdges = append(dataEdges, encodeItemData(e.Data(), typed))
 				}
 			}
 
@@ -313,8 +329,16 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Check if values are given with their value types
+
+	typed := stringutil.IsTrueValue(r.URL.Query().Get("typed"))
+
 	dec := json.NewDecoder(r.Body)
 
+	if typed {
+		dec.UseNumber()
+	}
+
 	if len(resources) == 1 {
 
 		// No explicit type given - expecting a graph
@@ -356,7 +380,7 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 		// Store nodes in transaction
 
 		for _, ndata := range nDataList {
-			node := data.NewGraphNodeFromMap(ndata)
+			node := data.NewGraphNodeFromMap(decodeItemData(ndata, typed))
 
 			if err := transFuncNode(trans, resources[0], node); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -370,7 +394,7 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 		// Store edges in transaction
 
 		for _, edata := range eDataList {
-			edge := data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(edata))
+			edge := data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(decodeItemData(edata, typed)))
 
 			if err := transFuncEdge(trans, resources[0], edge); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -444,6 +468,27 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 		},
 	}
 
+	asofParam := []map[string]interface{}{
+		{
+			"name":        "asof",
+			"in":          "query",
+			"description": "Return the node or edge as it was at the given time (RFC 3339). Requires a history retention for the kind.",
+			"required":    false,
+			"type":        "string",
+			"format":      "date-time",
+		},
+	}
+
+	typedParam := []map[string]interface{}{
+		{
+			"name":        "typed",
+			"in":          "query",
+			"description": "Send and receive values with their value types - whole numbers are integers, numbers with a decimal point are floats, timestamps are objects with a $timestamp key and binary data objects with a $bytes key.",
+			"required":    false,
+			"type":        "boolean",
+		},
+	}
+
 	keyParam := []map[string]interface{}{
 		{
 			"name":        "key",
@@ -531,7 +576,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(partitionParams, graphPost...),
+			"parameters": append(append(partitionParams, graphPost...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "No data is returned when data is created.",
@@ -550,7 +595,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(partitionParams, graphPost...),
+			"parameters": append(append(partitionParams, graphPost...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "No data is returned when data is created.",
@@ -569,7 +614,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(partitionParams, graphPost...),
+			"parameters": append(append(partitionParams, graphPost...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "No data is returned when data is created.",
@@ -593,7 +638,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(append(partitionParams, entityParams...), entitiesPost...),
+			"parameters": append(append(append(partitionParams, entityParams...), entitiesPost...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "No data is returned when data is created.",
@@ -612,7 +657,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(append(partitionParams, entityParams...), entitiesPost...),
+			"parameters": append(append(append(partitionParams, entityParams...), entitiesPost...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "No data is returned when data is created.",
@@ -631,7 +676,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(append(partitionParams, entityParams...), entitiesPost...),
+			"parameters": append(append(append(partitionParams, entityParams...), entitiesPost...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "No data is returned when data is created.",
@@ -652,7 +697,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(defaultParams, optionalQueryParams...),
+			"parameters": append(append(defaultParams, optionalQueryParams...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "The return data is a list of objects",
@@ -678,7 +723,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(append(defaultParams, keyParam...), optionalQueryParams...),
+			"parameters": append(append(append(append(defaultParams, keyParam...), optionalQueryParams...), asofParam...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "The return data is a single object",
@@ -701,7 +746,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(append(defaultParams, keyParam...), travParam...),
+			"parameters": append(append(append(defaultParams, keyParam...), travParam...), typedParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "The return data are two lists containing traversed nodes and edges. " +
@@ -723,6 +768,52 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 	}
 }
 
+/*
+fetchNode fetches a single node. The node is fetched as it was at a given
+time if the time is not zero (see graph.Manager.FetchNodeAsOf).
+*/
+func fetchNode(part string, key string, kind string, asof time.Time) (data.Node, error) {
+	if asof.IsZero() {
+		return api.GM.FetchNode(part, key, kind)
+	}
+	return api.GM.FetchNodeAsOf(part, key, kind, asof)
+}
+
+/*
+fetchEdge fetches a single edge. The edge is fetched as it was at a given
+time if the time is not zero (see graph.Manager.FetchEdgeAsOf).
+*/
+func fetchEdge(part string, key string, kind string, asof time.Time) (data.Edge, error) {
+	if asof.IsZero() {
+		return api.GM.FetchEdge(part, key, kind)
+	}
+	return api.GM.FetchEdgeAsOf(part, key, kind, asof)
+}
+
+/*
+encodeItemData prepares the data of a node or edge for a JSON response. If
+the typed flag is set the value types of all attributes are preserved (see
+data.EncodeJSONValue).
+*/
+func encodeItemData(itemData map[string]interface{}, typed bool) map[string]interface{} {
+	if !typed {
+		return itemData
+	}
+	return data.EncodeJSONData(itemData)
+}
+
+/*
+decodeItemData prepares the data of a node or edge from a JSON request. If
+the typed flag is set the value types of all attributes are restored (see
+data.DecodeJSONValue).
+*/
+func decodeItemData(itemData map[string]interface{}, typed bool) map[string]interface{} {
+	if !typed {
+		return itemData
+	}
+	return data.DecodeJSONData(itemData)
+}
+
 // Comparator object to sort traversal results
 
 type traversalResultComparator struct {
@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/krotik/common/datautil"
 	"github.com/krotik/eliasdb/api"
@@ -105,6 +106,147 @@ func TestNestedStorage(t *testing.T) {
 	}
 }
 
+func TestTypedValueStorage(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	st, _, res := sendTestRequest(queryURL+"main/n?typed=true", "POST", []byte(`
+[{
+	"key":"typedtest",
+	"kind":"Test",
+	"int":9007199254740993,
+	"float":2.0,
+	"time":{"$timestamp":"2020-01-02T03:04:05Z"},
+	"bytes":{"$bytes":"YWJj"}
+}]
+`[1:]))
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	n, err := api.GM.FetchNode("main", "typedtest", "Test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprintf("%T %T %T %T", n.Attr("int"), n.Attr("float"),
+		n.Attr("time"), n.Attr("bytes")); res != "int64 float64 time.Time []uint8" {
+		t.Error("Unexpected types:", res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Test/typedtest?typed=true", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "bytes": {
+    "$bytes": "YWJj"
+  },
+  "float": 2.0,
+  "int": 9007199254740993,
+  "key": "typedtest",
+  "kind": "Test",
+  "time": {
+    "$timestamp": "2020-01-02T03:04:05Z"
+  }
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Without the typed parameter values are returned as plain JSON
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Test/typedtest", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "bytes": "YWJj",
+  "float": 2,
+  "int": 9007199254740993,
+  "key": "typedtest",
+  "kind": "Test",
+  "time": "2020-01-02T03:04:05Z"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+func TestGraphQueryAsOf(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	if err := api.GM.SetHistoryRetention("History", &graph.HistoryRetention{}); err != nil {
+		t.Error(err)
+		return
+	}
+	defer api.GM.RemoveHistoryRetention("History")
+
+	for _, name := range []string{"foo", "bar"} {
+		st, _, res := sendTestRequest(queryURL+"main/n", "POST",
+			[]byte(fmt.Sprintf(`[{"key":"histtest","kind":"History","name":"%v"}]`, name)))
+
+		if st != "200 OK" {
+			t.Error("Unexpected response:", st, res)
+			return
+		}
+	}
+
+	versions, err := api.GM.FetchNodeVersions("main", "histtest", "History")
+	if err != nil || len(versions) != 2 {
+		t.Error("Unexpected result:", versions, err)
+		return
+	}
+
+	asof := versions[0].Timestamp.Format(time.RFC3339Nano)
+
+	st, _, res := sendTestRequest(queryURL+"/main/n/History/histtest?asof="+asof, "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "key": "histtest",
+  "kind": "History",
+  "name": "foo"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/History/histtest", "GET", nil)
+
+	if st != "200 OK" || res != `
+{
+  "key": "histtest",
+  "kind": "History",
+  "name": "bar"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/History/histtest?asof=2000-01-01T00:00:00Z", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Unknown partition or node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/History/histtest?asof=foo", "GET", nil)
+
+	if st != "400 Bad Request" || res != "Invalid parameter value: asof should be a RFC 3339 timestamp" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/History?asof="+asof, "GET", nil)
+
+	if st != "400 Bad Request" || res != "Parameter asof is only supported when requesting a single node or edge" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
 func TestGraphQuery(t *testing.T) {
 	queryURL := "http://localhost" + TESTPORT + EndpointGraph
 
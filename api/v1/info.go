@@ -14,8 +14,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/storage"
 )
 
 /*
@@ -66,6 +69,76 @@ func (ie *infoEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 			data["node_attrs"] = na
 			data["node_edges"] = api.GM.NodeEdges(resources[1])
 			data["edge_attrs"] = ea
+
+		} else if resources[0] == "storage" {
+
+			// Storage statistics are requested
+
+			stats, err := api.GM.StorageStats()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			total := storage.NewManagerStats("")
+			datastores := []map[string]interface{}{}
+
+			for _, s := range stats {
+				ds := map[string]interface{}{
+					"partition": s.Partition,
+					"kind":      s.Kind,
+					"type":      s.Type,
+				}
+
+				if s.Data != nil {
+					ds["data"] = storageStatsMap(s.Data)
+				}
+
+				if s.Index != nil {
+					ds["index"] = storageStatsMap(s.Index)
+				}
+
+				total.Add(s.Total())
+				datastores = append(datastores, ds)
+			}
+
+			data["datastores"] = datastores
+			data["total"] = storageStatsMap(total)
+
+		} else if resources[0] == "trees" {
+
+			// Tree statistics are requested
+
+			largest := 10
+
+			if l := r.URL.Query().Get("largest"); l != "" {
+				var err error
+
+				if largest, err = strconv.Atoi(l); err != nil || largest < 0 {
+					http.Error(w, fmt.Sprint("Invalid parameter value for largest: ", l),
+						http.StatusBadRequest)
+					return
+				}
+			}
+
+			stats, err := api.GM.TreeStats(largest)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			trees := []map[string]interface{}{}
+
+			for _, s := range stats {
+				trees = append(trees, treeStatsMap(s))
+			}
+
+			data["trees"] = trees
+
+		} else {
+
+			http.Error(w, fmt.Sprint("Unknown info resource ", resources[0]), http.StatusBadRequest)
+			return
 		}
 
 	} else {
@@ -93,6 +166,19 @@ func (ie *infoEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 		}
 
 		data["edge_counts"] = ecs
+
+		// Cache statistics are only available for disk storage
+
+		if cs, maxSize, err := api.GM.CacheStats(); err == nil {
+			data["cache"] = map[string]interface{}{
+				"objects":   cs.Objects,
+				"size":      cs.Size,
+				"max_size":  maxSize,
+				"hits":      cs.Hits,
+				"misses":    cs.Misses,
+				"evictions": cs.Evictions,
+			}
+		}
 	}
 
 	// Write data
@@ -103,6 +189,76 @@ func (ie *infoEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resour
 	ret.Encode(data)
 }
 
+/*
+storageStatsMap returns a JSON representation of storage manager statistics.
+*/
+func storageStatsMap(ms *storage.ManagerStats) map[string]interface{} {
+	return map[string]interface{}{
+		"records":         ms.Records,
+		"free_locations":  ms.FreeLocations,
+		"free_slots":      ms.FreeSlots,
+		"data_bytes":      ms.DataBytes,
+		"allocated_bytes": ms.AllocatedBytes,
+		"free_bytes":      ms.FreeBytes,
+		"file_bytes":      ms.FileBytes,
+		"fragmentation":   ms.Fragmentation(),
+		"pages":           ms.Pages,
+		"cache": map[string]interface{}{
+			"objects":   ms.Cache.Objects,
+			"size":      ms.Cache.Size,
+			"hits":      ms.Cache.Hits,
+			"misses":    ms.Cache.Misses,
+			"evictions": ms.Cache.Evictions,
+		},
+	}
+}
+
+/*
+treeStatsMap returns a JSON representation of tree statistics.
+*/
+func treeStatsMap(ts *graph.TreeStats) map[string]interface{} {
+	var depths []map[string]interface{}
+
+	for i := range ts.Stats.Pages {
+		depths = append(depths, map[string]interface{}{
+			"depth":    i,
+			"pages":    ts.Stats.Pages[i],
+			"buckets":  ts.Stats.Buckets[i],
+			"elements": ts.Stats.Elements[i],
+		})
+	}
+
+	bucketSizes := make(map[string]int)
+	for size, count := range ts.Stats.BucketSizes {
+		bucketSizes[strconv.Itoa(size)] = count
+	}
+
+	largest := []map[string]interface{}{}
+	for _, b := range ts.Stats.LargestBuckets {
+		largest = append(largest, map[string]interface{}{
+			"location": b.Location,
+			"depth":    b.Depth,
+			"size":     b.Size,
+		})
+	}
+
+	return map[string]interface{}{
+		"partition":       ts.Partition,
+		"kind":            ts.Kind,
+		"type":            ts.Type,
+		"tree":            ts.Tree,
+		"seed":            ts.Stats.Options.Seed,
+		"max_depth":       ts.Stats.Options.MaxDepth,
+		"pages":           ts.Stats.TotalPages(),
+		"buckets":         ts.Stats.TotalBuckets(),
+		"elements":        ts.Stats.TotalElements(),
+		"fill_factor":     ts.Stats.FillFactor(),
+		"depths":          depths,
+		"bucket_sizes":    bucketSizes,
+		"largest_buckets": largest,
+	}
+}
+
 /*
 SwaggerDefs is used to describe the endpoint in swagger.
 */
@@ -111,7 +267,7 @@ func (ie *infoEndpoint) SwaggerDefs(s map[string]interface{}) {
 	s["paths"].(map[string]interface{})["/v1/info"] = map[string]interface{}{
 		"get": map[string]interface{}{
 			"summary":     "Return general datastore information.",
-			"description": "The info endpoint returns general database information such as known node kinds, known attributes, etc.",
+			"description": "The info endpoint returns general database information such as known node kinds, known attributes, etc. For disk storage the statistics of the object cache are included.",
 			"produces": []string{
 				"text/plain",
 				"application/json",
@@ -130,6 +286,59 @@ func (ie *infoEndpoint) SwaggerDefs(s map[string]interface{}) {
 		},
 	}
 
+	s["paths"].(map[string]interface{})["/v1/info/storage"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return statistics of all datastores.",
+			"description": "The info storage endpoint returns statistics of the datastores which hold the nodes and edges of each partition and kind such as the number of records, file sizes, free space and cache statistics. The combined statistics of all datastores are returned as total.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A key-value map.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/info/trees"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return statistics of all HTrees.",
+			"description": "The info trees endpoint returns the shape of the HTrees which hold the nodes, edges and indices of each partition and kind such as the number of pages, buckets and elements per tree level, bucket fill factors and the largest collision buckets.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "largest",
+					"in":          "query",
+					"description": "Number of largest buckets which should be returned for each tree (default 10).",
+					"required":    false,
+					"type":        "integer",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A key-value map.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
 	s["paths"].(map[string]interface{})["/v1/info/kind/{kind}"] = map[string]interface{}{
 		"get": map[string]interface{}{
 			"summary":     "Return information on a given node or edge kind.",
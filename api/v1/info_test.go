@@ -10,7 +10,19 @@
 
 package v1
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
 
 func TestInfoQuery(t *testing.T) {
 	queryURL := "http://localhost" + TESTPORT + EndpointInfoQuery
@@ -88,3 +100,211 @@ func TestInfoQuery(t *testing.T) {
 		return
 	}
 }
+
+func TestInfoQueryCacheStats(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointInfoQuery
+
+	dbDir := "infotestdb"
+
+	dgs, err := graphstorage.NewDiskGraphStorageWithOptions(dbDir, false,
+		storage.DiskStorageManagerOptions{Read: storage.ReadOptions{CacheMaxBytes: 100000}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	oldGM := api.GM
+	api.GM = graph.NewGraphManager(dgs)
+
+	defer func() {
+		api.GM = oldGM
+		dgs.Close()
+		os.RemoveAll(dbDir)
+	}()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var data map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &data); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cache, ok := data["cache"].(map[string]interface{})
+	if !ok || cache["max_size"] != float64(100000) || cache["hits"] != float64(0) {
+		t.Error("Unexpected response:", res)
+		return
+	}
+}
+
+func TestInfoQueryStorageStats(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointInfoQuery + "storage"
+
+	dbDir := "infotestdb2"
+
+	dgs, err := graphstorage.NewDiskGraphStorage(dbDir, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	oldGM := api.GM
+	api.GM = graph.NewGraphManager(dgs)
+
+	defer func() {
+		api.GM = oldGM
+		dgs.Close()
+		os.RemoveAll(dbDir)
+	}()
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mykind")
+	api.GM.StoreNode("main", node)
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var ret map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &ret); err != nil {
+		t.Error(err)
+		return
+	}
+
+	datastores, ok := ret["datastores"].([]interface{})
+	if !ok || len(datastores) != 1 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	ds := datastores[0].(map[string]interface{})
+	if ds["partition"] != "main" || ds["kind"] != "mykind" || ds["type"] != "node" ||
+		ds["data"].(map[string]interface{})["records"].(float64) == 0 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	total := ret["total"].(map[string]interface{})
+	if total["records"].(float64) < 2 || total["file_bytes"].(float64) == 0 ||
+		total["pages"].(map[string]interface{})["data"].(float64) < 2 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	// Errors are reported
+
+	api.GM = graph.NewGraphManager(&statsErrorStorage{dgs})
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "500 Internal Server Error" || res != "GraphError: Failed to access graph storage component (testerror)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	queryURL = "http://localhost" + TESTPORT + EndpointInfoQuery + "foo"
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "400 Bad Request" || res != "Unknown info resource foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
+
+/*
+statsErrorStorage is a graph storage whose storage managers fail to report
+statistics.
+*/
+type statsErrorStorage struct {
+	graphstorage.Storage
+}
+
+func (ses *statsErrorStorage) StorageManager(smname string, create bool) storage.Manager {
+	if sm := ses.Storage.StorageManager(smname, create); sm != nil {
+		return &statsErrorManager{sm}
+	}
+	return nil
+}
+
+type statsErrorManager struct {
+	storage.Manager
+}
+
+func (sem *statsErrorManager) Stats() (*storage.ManagerStats, error) {
+	return nil, errors.New("testerror")
+}
+
+func TestInfoTreesQuery(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointInfoQuery + "trees"
+
+	mgs := graphstorage.NewMemoryGraphStorage("infotrees")
+
+	oldGM := api.GM
+	api.GM = graph.NewGraphManager(mgs)
+
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	api.GM.SetKindTreeOptions("mykind", &hash.HTreeOptions{Seed: 7, MaxDepth: 4})
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mykind")
+	node.SetAttr("name", "test")
+	api.GM.StoreNode("main", node)
+
+	st, _, res := sendTestRequest(queryURL+"?largest=1", "GET", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var ret map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &ret); err != nil {
+		t.Error(err)
+		return
+	}
+
+	trees, ok := ret["trees"].([]interface{})
+	if !ok || len(trees) != 3 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	tr := trees[0].(map[string]interface{})
+	if tr["partition"] != "main" || tr["kind"] != "mykind" || tr["type"] != "node" ||
+		tr["tree"] != "attrs" || tr["seed"] != float64(7) || tr["max_depth"] != float64(4) ||
+		tr["elements"] != float64(1) || len(tr["depths"].([]interface{})) != 6 ||
+		len(tr["largest_buckets"].([]interface{})) != 1 ||
+		tr["bucket_sizes"].(map[string]interface{})["1"] != float64(1) {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?largest=foo", "GET", nil)
+	if st != "400 Bad Request" || res != "Invalid parameter value for largest: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Errors are reported
+
+	msm := mgs.StorageManager("mainmykind"+graph.StorageSuffixNodes, false).(*storage.MemoryStorageManager)
+	msm.AccessMap[msm.Root(graph.RootIDNodeHTree)] = storage.AccessCacheAndFetchError
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "500 Internal Server Error" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
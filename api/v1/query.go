@@ -130,8 +130,23 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 			return
 		}
 
+		gm := api.GM
+
+		// Run the query on a consistent version of the graph if requested
+
+		if r.URL.Query().Get("snapshot") != "" {
+			rv, err := api.GM.ReadView()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer rv.Release()
+
+			gm = rv.Manager
+		}
+
 		res, err = eql.RunQuery(stringutil.CreateDisplayString(part)+" query",
-			part, query, api.GM)
+			part, query, gm)
 
 		if err == nil {
 			sres := &APISearchResult{res, nil}
@@ -350,6 +365,13 @@ func (eq *queryEndpoint) SwaggerDefs(s map[string]interface{}) {
 					"type":        "number",
 					"format":      "integer",
 				},
+				{
+					"name":        "snapshot",
+					"in":          "query",
+					"description": "Run the query on a consistent version of the graph which is not affected by concurrent writes if set to any value.",
+					"required":    false,
+					"type":        "string",
+				},
 			},
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
@@ -11,7 +11,14 @@
 package v1
 
 import (
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
 )
 
 func TestQueryPagination(t *testing.T) {
@@ -595,3 +602,52 @@ func TestGroupingInfo(t *testing.T) {
 		return
 	}
 }
+
+func TestQuerySnapshot(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointQuery
+
+	// Memory storage does not support read views
+
+	st, _, res := sendTestRequest(queryURL+"main?q=get+Song&snapshot=1", "GET", nil)
+	if st != "400 Bad Request" ||
+		res != "GraphError: Failed to access graph storage component (Graph storage does not support read views)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	dbDir := "querytestdb"
+
+	dgs, err := graphstorage.NewDiskGraphStorage(dbDir, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	oldGM := api.GM
+	api.GM = graph.NewGraphManager(dgs)
+
+	defer func() {
+		api.GM = oldGM
+		dgs.Close()
+		os.RemoveAll(dbDir)
+	}()
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "Song")
+	node.SetAttr("name", "MySong")
+	api.GM.StoreNode("main", node)
+
+	st, _, res = sendTestRequest(queryURL+"main?q=get+Song&snapshot=1", "GET", nil)
+	if st != "200 OK" || !strings.Contains(res, "MySong") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The read view was released
+
+	if res := dgs.(*graphstorage.DiskGraphStorage).OldVersions(); res != 0 {
+		t.Error("Unexpected old versions:", res)
+		return
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/krotik/eliasdb/api"
 )
@@ -38,6 +39,7 @@ V1EndpointMap is a map of urls to endpoints for version 1 of the API
 */
 var V1EndpointMap = map[string]api.RestEndpointInst{
 	EndpointBlob:                 BlobEndpointInst,
+	EndpointChanges:              ChangesEndpointInst,
 	EndpointClusterQuery:         ClusterEndpointInst,
 	EndpointEql:                  EqlEndpointInst,
 	EndpointGraph:                GraphEndpointInst,
@@ -49,6 +51,8 @@ var V1EndpointMap = map[string]api.RestEndpointInst{
 	EndpointInfoQuery:            InfoEndpointInst,
 	EndpointQuery:                QueryEndpointInst,
 	EndpointQueryResult:          QueryResultEndpointInst,
+	EndpointSchema:               SchemaEndpointInst,
+	EndpointSnapshot:             SnapshotEndpointInst,
 	EndpointECALInternal:         ECALEndpointInst,
 	EndpointECALSock:             ECALSockEndpointInst,
 }
@@ -98,3 +102,25 @@ func queryParamPosNum(w http.ResponseWriter, r *http.Request, param string) (int
 
 	return num, true
 }
+
+/*
+Extract a timestamp (RFC 3339) from a query parameter. Returns a zero time
+and true if the parameter was not given.
+*/
+func queryParamTime(w http.ResponseWriter, r *http.Request, param string) (time.Time, bool) {
+
+	val := r.URL.Query().Get(param)
+
+	if val == "" {
+		return time.Time{}, true
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, val)
+
+	if err != nil {
+		http.Error(w, "Invalid parameter value: "+param+" should be a RFC 3339 timestamp", http.StatusBadRequest)
+		return time.Time{}, false
+	}
+
+	return t, true
+}
@@ -0,0 +1,241 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+)
+
+/*
+EndpointSchema is the schema endpoint URL (rooted). Handles everything under schema/...
+*/
+const EndpointSchema = api.APIRoot + APIv1 + "/schema/"
+
+/*
+SchemaEndpointInst creates a new endpoint handler.
+*/
+func SchemaEndpointInst() api.RestEndpointHandler {
+	return &schemaEndpoint{}
+}
+
+/*
+Handler object for schema operations.
+*/
+type schemaEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles REST calls to retrieve one or all schemas.
+*/
+func (se *schemaEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ret interface{}
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	if len(resources) == 0 || resources[0] == "" {
+
+		// Return all schemas
+
+		schemas := []*graph.Schema{}
+
+		for _, kind := range api.GM.SchemaKinds() {
+			schema, err := api.GM.Schema(kind)
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if schema != nil {
+				schemas = append(schemas, schema)
+			}
+		}
+
+		ret = schemas
+
+	} else {
+
+		schema, err := api.GM.Schema(resources[0])
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if schema == nil {
+			http.Error(w, fmt.Sprint("Unknown schema for kind ", resources[0]), http.StatusBadRequest)
+			return
+		}
+
+		ret = schema
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	e := json.NewEncoder(w)
+	e.Encode(ret)
+}
+
+/*
+HandlePUT handles a REST call to set the schema of a kind.
+*/
+func (se *schemaEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+	var schema graph.Schema
+
+	if !checkResources(w, resources, 1, 1, "Need a kind") {
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		http.Error(w, "Could not decode request body as schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if schema.Kind == "" {
+		schema.Kind = resources[0]
+	} else if schema.Kind != resources[0] {
+		http.Error(w, fmt.Sprintf("Schema kind %v does not match requested kind %v",
+			schema.Kind, resources[0]), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.GM.SetSchema(&schema); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+/*
+HandleDELETE handles a REST call to remove the schema of a kind.
+*/
+func (se *schemaEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	if !checkResources(w, resources, 1, 1, "Need a kind") {
+		return
+	}
+
+	ok, err := api.GM.RemoveSchema(resources[0])
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(w, fmt.Sprint("Unknown schema for kind ", resources[0]), http.StatusBadRequest)
+		return
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *schemaEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	kindParams := []map[string]interface{}{
+		{
+			"name":        "kind",
+			"in":          "path",
+			"description": "Node or edge kind of the schema.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	schemaData := []map[string]interface{}{
+		{
+			"name":        "schema",
+			"in":          "body",
+			"description": "Schema with attributes (name -> type / required flag), edge specs (spec -> max number of edges) and a strict flag.",
+			"required":    true,
+			"schema": map[string]interface{}{
+				"type": "object",
+			},
+		},
+	}
+
+	errorResponse := map[string]interface{}{
+		"description": "Error response",
+		"schema": map[string]interface{}{
+			"$ref": "#/definitions/Error",
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/schema"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all schemas.",
+			"description": "The schema endpoint returns the schemas of all node and edge kinds which have a schema.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of schemas.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/schema/{kind}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the schema of a kind.",
+			"description": "Return the schema of a given node or edge kind.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": kindParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The requested schema.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"put": map[string]interface{}{
+			"summary":     "Set the schema of a kind.",
+			"description": "Set the schema of a given node or edge kind. Nodes and edges which are stored afterwards are validated against the schema.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": append(schemaData, kindParams...),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The operation was successful.",
+				},
+				"default": errorResponse,
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove the schema of a kind.",
+			"description": "Remove the schema of a given node or edge kind.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": kindParams,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The operation was successful.",
+				},
+				"default": errorResponse,
+			},
+		},
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestSchema(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSchema
+
+	oldGM := api.GM
+	api.GM = graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("schematest"))
+
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Person", "PUT", []byte(`{
+  "attrs": {
+    "name": { "type": "string", "required": true }
+  },
+  "edges": {
+    "owner:Owns:owned:Car": { "max": 1 }
+  }
+}`))
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Person", "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "kind": "Person",
+  "attrs": {
+    "name": {
+      "type": "string",
+      "required": true
+    }
+  },
+  "edges": {
+    "owner:Owns:owned:Car": {
+      "max": 1
+    }
+  },
+  "strict": false
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != `
+[
+  {
+    "kind": "Person",
+    "attrs": {
+      "name": {
+        "type": "string",
+        "required": true
+      }
+    },
+    "edges": {
+      "owner:Owns:owned:Car": {
+        "max": 1
+      }
+    },
+    "strict": false
+  }
+]`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The schema is enforced
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "Person")
+
+	if err := api.GM.StoreNode("main", node); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	// Test error cases
+
+	st, _, res = sendTestRequest(queryURL+"Car", "GET", nil)
+	if st != "400 Bad Request" || res != "Unknown schema for kind Car" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Car", "PUT", []byte("{"))
+	if st != "400 Bad Request" || res != "Could not decode request body as schema: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Car", "PUT", []byte(`{"kind": "Person"}`))
+	if st != "400 Bad Request" || res != "Schema kind Person does not match requested kind Car" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"Car", "PUT", []byte(`{"attrs": {"name": {"type": "foo"}}}`))
+	if st != "400 Bad Request" ||
+		res != "GraphError: Invalid data (Schema of kind Car contains unknown type foo for attribute name)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", nil)
+	if st != "400 Bad Request" || res != "Need a kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Remove the schema
+
+	st, _, res = sendTestRequest(queryURL+"Car", "DELETE", nil)
+	if st != "400 Bad Request" || res != "Unknown schema for kind Car" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	api.GM.SetSchema(&graph.Schema{Kind: "Car"})
+
+	st, _, res = sendTestRequest(queryURL+"Car", "DELETE", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	graphstorage.MgsRetFlushMain = errors.New("testerror")
+
+	st, _, res = sendTestRequest(queryURL+"Person", "DELETE", nil)
+
+	graphstorage.MgsRetFlushMain = nil
+
+	if st != "500 Internal Server Error" || res != "GraphError: Failed to flush changes (testerror)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The memory storage does not roll back the main database
+
+	st, _, res = sendTestRequest(queryURL+"Person", "GET", nil)
+	if st != "400 Bad Request" || res != "Unknown schema for kind Person" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if err := api.GM.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+/*
+EndpointSnapshot is the snapshot endpoint URL (rooted). Handles everything under snapshot/...
+*/
+const EndpointSnapshot = api.APIRoot + APIv1 + "/snapshot/"
+
+/*
+SnapshotDir is the directory which contains all snapshots
+*/
+var SnapshotDir = "snapshots"
+
+/*
+SnapshotEndpointInst creates a new endpoint handler.
+*/
+func SnapshotEndpointInst() api.RestEndpointHandler {
+	return &snapshotEndpoint{}
+}
+
+/*
+Handler object for snapshot operations.
+*/
+type snapshotEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles REST calls to list all existing snapshots.
+*/
+func (se *snapshotEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	ret := []string{}
+
+	if !checkResources(w, resources, 0, 0, "") {
+		return
+	}
+
+	files, _ := ioutil.ReadDir(SnapshotDir)
+
+	for _, f := range files {
+		manifest := filepath.Join(SnapshotDir, f.Name(), graphstorage.FilenameSnapshotManifest)
+
+		if ok, _ := fileutil.PathExists(manifest); ok && f.IsDir() {
+			ret = append(ret, f.Name())
+		}
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	e := json.NewEncoder(w)
+	e.Encode(ret)
+}
+
+/*
+HandlePOST handles a REST call to create a new snapshot.
+*/
+func (se *snapshotEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	name := fmt.Sprint("snapshot-", time.Now().Format("20060102-150405"))
+
+	if len(resources) == 1 {
+		name = resources[0]
+
+		if name == "" || strings.HasPrefix(name, ".") || name != filepath.Base(name) {
+			http.Error(w, fmt.Sprint("Invalid snapshot name: ", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := api.GM.Snapshot(filepath.Join(SnapshotDir, name)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(map[string]interface{}{
+		"name": name,
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *snapshotEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/snapshot"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return a list of all existing snapshots.",
+			"description": "The snapshot endpoint returns the names of all snapshots in the snapshot directory.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of snapshot names.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"post": map[string]interface{}{
+			"summary":     "Create a new snapshot.",
+			"description": "Create a consistent copy of the datastore in the snapshot directory. Write operations are blocked while the snapshot is taken.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The name of the new snapshot.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/snapshot/{name}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Create a new snapshot with a given name.",
+			"description": "Create a consistent copy of the datastore in the snapshot directory. Write operations are blocked while the snapshot is taken.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "name",
+					"in":          "path",
+					"description": "Name of the new snapshot.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The name of the new snapshot.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestSnapshot(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSnapshot
+
+	snapshotDir := "snapshottest"
+	dbDir := "snapshottestdb"
+
+	oldSnapshotDir := SnapshotDir
+	SnapshotDir = snapshotDir
+
+	defer func() {
+		SnapshotDir = oldSnapshotDir
+		os.RemoveAll(snapshotDir)
+		os.RemoveAll(dbDir)
+	}()
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The test graph is memory only
+
+	st, _, res = sendTestRequest(queryURL, "POST", nil)
+	if st != "500 Internal Server Error" ||
+		res != "GraphError: Failed to access graph storage component (Graph storage does not support snapshots)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo/bar", "POST", nil)
+	if st != "400 Bad Request" || res != "Invalid resource specification: bar" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+".hidden", "POST", nil)
+	if st != "400 Bad Request" || res != "Invalid snapshot name: .hidden" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Use a disk storage
+
+	dgs, err := graphstorage.NewDiskGraphStorage(dbDir, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	oldGM := api.GM
+	api.GM = graph.NewGraphManager(dgs)
+
+	defer func() {
+		api.GM = oldGM
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"mysnapshot", "POST", nil)
+	if st != "200 OK" || res != `
+{
+  "name": "mysnapshot"
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if err := graphstorage.VerifySnapshot(snapshotDir + "/mysnapshot"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"mysnapshot", "POST", nil)
+	if st != "500 Internal Server Error" ||
+		res != "GraphError: Could not write graph information (Snapshot directory already exists: snapshottest/mysnapshot)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	os.MkdirAll(snapshotDir+"/foo", 0770)
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || !strings.HasPrefix(res, `[
+  "mysnapshot",
+  "snapshot-`) || strings.Contains(res, "foo") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,594 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package btree provides a B+tree implementation to provide ordered key-value
+storage functionality for a StorageManager.
+
+The B+tree stores keys in byte order. Values are only stored in the leaves
+of the tree. All leaves are linked with each other which allows efficient
+range scans and ordered iteration. It is not possible to store nil values.
+
+As the tree grows nodes which contain more than MaxNodeKeys keys are split
+and the tree grows from the root. The root node of a tree always stays at the
+same storage location. Leaves which become empty are removed from the tree.
+The tree does not merge nodes which are only partially filled.
+
+Iterator
+
+Entries in the B+tree can be iterated in key order by using a BTreeIterator.
+An iterator can be limited to a range of keys. The tree may change behind the
+iterator's back. The iterator reads the entries of one leaf at a time. Once all
+entries of a leaf were returned the iterator continues with the first key which
+follows the last returned key.
+
+Store
+
+A Store is an ordered key-value store (see storage/kv) which keeps all its
+data in a single B+tree. It is used by the btree storage backend. The tree of
+a Store is kept in a PageFile which stores all nodes in fixed-size pages of a
+single file and reuses freed pages. All changes of a batch are written in one
+flush which is protected by a journal file.
+*/
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+MaxNodeKeys is the maximum number of keys a node can contain before it is split
+*/
+const MaxNodeKeys = 64
+
+/*
+BTree data structure
+*/
+type BTree struct {
+	Root  *btreeNode  // Root node of the BTree
+	mutex *sync.Mutex // Mutex to protect tree operations
+}
+
+/*
+btreeNode data structure - this object models the
+BTree storage structure on disk
+*/
+type btreeNode struct {
+	loc uint64          // Storage location of this node (not persisted)
+	sm  storage.Manager // StorageManager instance which stores the tree data (not persisted)
+
+	Leaf     bool          // Flag if this node is a leaf
+	Keys     [][]byte      // Stored keys (separator keys for inner nodes)
+	Values   []interface{} // Stored values (only used for leaves)
+	Children []uint64      // Storage locations of children (only used for inner nodes)
+	Prev     uint64        // Storage location of the previous leaf (only used for leaves)
+	Next     uint64        // Storage location of the next leaf (only used for leaves)
+}
+
+/*
+NewBTree creates a new BTree.
+*/
+func NewBTree(sm storage.Manager) (*BTree, error) {
+
+	root := &btreeNode{0, sm, true, [][]byte{}, []interface{}{}, nil, 0, 0}
+
+	loc, err := sm.Insert(root)
+	if err != nil {
+		return nil, err
+	}
+
+	root.loc = loc
+
+	return &BTree{root, &sync.Mutex{}}, nil
+}
+
+/*
+LoadBTree fetches a BTree from storage.
+*/
+func LoadBTree(sm storage.Manager, loc uint64) (*BTree, error) {
+
+	root, err := fetchNode(sm, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BTree{root, &sync.Mutex{}}, nil
+}
+
+/*
+fetchNode fetches a BTree node from the storage.
+*/
+func fetchNode(sm storage.Manager, loc uint64) (*btreeNode, error) {
+	var node *btreeNode
+
+	if obj, _ := sm.FetchCached(loc); obj == nil {
+		var res btreeNode
+		if err := sm.Fetch(loc, &res); err != nil {
+			return nil, err
+		}
+		node = &res
+	} else {
+		node = obj.(*btreeNode)
+	}
+
+	node.loc = loc
+	node.sm = sm
+
+	return node, nil
+}
+
+/*
+Location returns the BTree location on disk.
+*/
+func (t *BTree) Location() uint64 {
+	return t.Root.loc
+}
+
+/*
+StorageManager returns the storage manager which stores the BTree.
+*/
+func (t *BTree) StorageManager() storage.Manager {
+	return t.Root.sm
+}
+
+/*
+Get gets a value for a given key.
+*/
+func (t *BTree) Get(key []byte) (interface{}, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	leaf, err := t.Root.findLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if i, ok := leaf.search(key); ok {
+		return leaf.Values[i], nil
+	}
+
+	return nil, nil
+}
+
+/*
+Exists checks if an element exists.
+*/
+func (t *BTree) Exists(key []byte) (bool, error) {
+	res, err := t.Get(key)
+	return res != nil, err
+}
+
+/*
+Put adds or updates a new key / value pair. Returns the old value if the key
+existed before. Storing a nil value is equivalent to removing the key.
+*/
+func (t *BTree) Put(key []byte, value interface{}) (interface{}, error) {
+
+	if value == nil {
+		return t.Remove(key)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	old, splitKey, right, err := t.Root.put(key, value)
+	if err != nil || right == nil {
+		return old, err
+	}
+
+	// The root node was split - move its content into a new node and make
+	// the root an inner node with the two halves as children
+
+	left := &btreeNode{0, t.Root.sm, t.Root.Leaf, t.Root.Keys, t.Root.Values,
+		t.Root.Children, 0, 0}
+
+	if left.Leaf {
+		left.Next = right.loc
+	}
+
+	if left.loc, err = t.Root.sm.Insert(left); err != nil {
+		return old, err
+	}
+
+	if right.Leaf {
+		right.Prev = left.loc
+		if err = right.update(); err != nil {
+			return old, err
+		}
+	}
+
+	t.Root.Leaf = false
+	t.Root.Keys = [][]byte{splitKey}
+	t.Root.Values = nil
+	t.Root.Children = []uint64{left.loc, right.loc}
+	t.Root.Prev = 0
+	t.Root.Next = 0
+
+	return old, t.Root.update()
+}
+
+/*
+Remove removes a key / value pair. Returns the removed value.
+*/
+func (t *BTree) Remove(key []byte) (interface{}, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	old, empty, err := t.Root.remove(key, true)
+	if err != nil || old == nil {
+		return old, err
+	}
+
+	if empty {
+
+		// All children of the root were removed
+
+		t.Root.Leaf = true
+		t.Root.Keys = [][]byte{}
+		t.Root.Values = []interface{}{}
+		t.Root.Children = nil
+
+		return old, t.Root.update()
+	}
+
+	// Collapse the root as long as it has only a single child
+
+	for !t.Root.Leaf && len(t.Root.Children) == 1 {
+
+		child, err := fetchNode(t.Root.sm, t.Root.Children[0])
+		if err != nil {
+			return old, err
+		}
+
+		t.Root.Leaf = child.Leaf
+		t.Root.Keys = child.Keys
+		t.Root.Values = child.Values
+		t.Root.Children = child.Children
+
+		if err = t.Root.update(); err != nil {
+			return old, err
+		}
+
+		if err = t.Root.sm.Free(child.loc); err != nil {
+			return old, err
+		}
+	}
+
+	return old, nil
+}
+
+/*
+String returns a string representation of this tree.
+*/
+func (t *BTree) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return fmt.Sprintf("BTree: %v (%v)\n%v", t.Root.sm.Name(), t.Root.loc, t.Root.String())
+}
+
+/*
+update writes the node to the storage.
+*/
+func (n *btreeNode) update() error {
+	return n.sm.Update(n.loc, n)
+}
+
+/*
+search returns the position of a given key in a leaf and if the key exists.
+*/
+func (n *btreeNode) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.Keys), func(i int) bool {
+		return bytes.Compare(n.Keys[i], key) >= 0
+	})
+	return i, i < len(n.Keys) && bytes.Equal(n.Keys[i], key)
+}
+
+/*
+childIndex returns the index of the child of an inner node which contains a
+given key.
+*/
+func (n *btreeNode) childIndex(key []byte) int {
+	return sort.Search(len(n.Keys), func(i int) bool {
+		return bytes.Compare(n.Keys[i], key) > 0
+	})
+}
+
+/*
+findLeaf returns the leaf which contains a given key.
+*/
+func (n *btreeNode) findLeaf(key []byte) (*btreeNode, error) {
+	var err error
+
+	node := n
+
+	for !node.Leaf {
+		if node, err = fetchNode(n.sm, node.Children[node.childIndex(key)]); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+/*
+put adds or updates a key / value pair in the subtree of this node. Returns
+the old value and if the node was split the first key of the new node and the
+new node.
+*/
+func (n *btreeNode) put(key []byte, value interface{}) (interface{}, []byte, *btreeNode, error) {
+
+	if n.Leaf {
+		i, ok := n.search(key)
+
+		if ok {
+			old := n.Values[i]
+			n.Values[i] = value
+			return old, nil, nil, n.update()
+		}
+
+		n.Keys = append(n.Keys, nil)
+		copy(n.Keys[i+1:], n.Keys[i:])
+		n.Keys[i] = key
+
+		n.Values = append(n.Values, nil)
+		copy(n.Values[i+1:], n.Values[i:])
+		n.Values[i] = value
+
+		if len(n.Keys) <= MaxNodeKeys {
+			return nil, nil, nil, n.update()
+		}
+
+		splitKey, right, err := n.splitLeaf()
+
+		return nil, splitKey, right, err
+	}
+
+	ci := n.childIndex(key)
+
+	child, err := fetchNode(n.sm, n.Children[ci])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	old, childSplitKey, childRight, err := child.put(key, value)
+	if err != nil || childRight == nil {
+		return old, nil, nil, err
+	}
+
+	// Add the new child
+
+	n.Keys = append(n.Keys, nil)
+	copy(n.Keys[ci+1:], n.Keys[ci:])
+	n.Keys[ci] = childSplitKey
+
+	n.Children = append(n.Children, 0)
+	copy(n.Children[ci+2:], n.Children[ci+1:])
+	n.Children[ci+1] = childRight.loc
+
+	if len(n.Keys) <= MaxNodeKeys {
+		return old, nil, nil, n.update()
+	}
+
+	splitKey, right, err := n.splitInner()
+
+	return old, splitKey, right, err
+}
+
+/*
+splitLeaf splits a leaf into two halves. The second half is stored in a new leaf.
+*/
+func (n *btreeNode) splitLeaf() ([]byte, *btreeNode, error) {
+	var err error
+
+	mid := len(n.Keys) / 2
+
+	right := &btreeNode{0, n.sm, true,
+		append([][]byte{}, n.Keys[mid:]...),
+		append([]interface{}{}, n.Values[mid:]...),
+		nil, n.loc, n.Next}
+
+	if right.loc, err = n.sm.Insert(right); err != nil {
+		return nil, nil, err
+	}
+
+	// Link the new leaf with its neighbours
+
+	if n.Next != 0 {
+		next, err := fetchNode(n.sm, n.Next)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		next.Prev = right.loc
+
+		if err = next.update(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	n.Keys = append([][]byte{}, n.Keys[:mid]...)
+	n.Values = append([]interface{}{}, n.Values[:mid]...)
+	n.Next = right.loc
+
+	return right.Keys[0], right, n.update()
+}
+
+/*
+splitInner splits an inner node into two halves. The second half is stored in a
+new node. The middle key is moved up to the parent.
+*/
+func (n *btreeNode) splitInner() ([]byte, *btreeNode, error) {
+	var err error
+
+	mid := len(n.Keys) / 2
+	splitKey := n.Keys[mid]
+
+	right := &btreeNode{0, n.sm, false,
+		append([][]byte{}, n.Keys[mid+1:]...), nil,
+		append([]uint64{}, n.Children[mid+1:]...), 0, 0}
+
+	if right.loc, err = n.sm.Insert(right); err != nil {
+		return nil, nil, err
+	}
+
+	n.Keys = append([][]byte{}, n.Keys[:mid]...)
+	n.Children = append([]uint64{}, n.Children[:mid+1]...)
+
+	return splitKey, right, n.update()
+}
+
+/*
+remove removes a key from the subtree of this node. Returns the removed value
+and if the node became empty and was removed from the storage. The root node
+is never removed.
+*/
+func (n *btreeNode) remove(key []byte, isRoot bool) (interface{}, bool, error) {
+
+	if n.Leaf {
+		i, ok := n.search(key)
+		if !ok {
+			return nil, false, nil
+		}
+
+		old := n.Values[i]
+
+		n.Keys = append(n.Keys[:i], n.Keys[i+1:]...)
+		n.Values = append(n.Values[:i], n.Values[i+1:]...)
+
+		if len(n.Keys) > 0 || isRoot {
+			return old, false, n.update()
+		}
+
+		return old, true, n.removeLeaf()
+	}
+
+	ci := n.childIndex(key)
+
+	child, err := fetchNode(n.sm, n.Children[ci])
+	if err != nil {
+		return nil, false, err
+	}
+
+	old, empty, err := child.remove(key, false)
+	if err != nil || !empty {
+		return old, false, err
+	}
+
+	// Remove the empty child and its separator key
+
+	n.Children = append(n.Children[:ci], n.Children[ci+1:]...)
+
+	if ci > 0 {
+		n.Keys = append(n.Keys[:ci-1], n.Keys[ci:]...)
+	} else if len(n.Keys) > 0 {
+		n.Keys = n.Keys[1:]
+	}
+
+	if len(n.Children) > 0 {
+		return old, false, n.update()
+	}
+
+	if isRoot {
+		return old, true, nil
+	}
+
+	return old, true, n.sm.Free(n.loc)
+}
+
+/*
+removeLeaf unlinks an empty leaf from its neighbours and frees it.
+*/
+func (n *btreeNode) removeLeaf() error {
+
+	if n.Prev != 0 {
+		prev, err := fetchNode(n.sm, n.Prev)
+		if err != nil {
+			return err
+		}
+
+		prev.Next = n.Next
+
+		if err = prev.update(); err != nil {
+			return err
+		}
+	}
+
+	if n.Next != 0 {
+		next, err := fetchNode(n.sm, n.Next)
+		if err != nil {
+			return err
+		}
+
+		next.Prev = n.Prev
+
+		if err = next.update(); err != nil {
+			return err
+		}
+	}
+
+	return n.sm.Free(n.loc)
+}
+
+/*
+String returns a string representation of this node and all its children.
+*/
+func (n *btreeNode) String() string {
+	var buf bytes.Buffer
+
+	n.writeString(&buf, 0)
+
+	return buf.String()
+}
+
+/*
+writeString writes a string representation of this node and all its children
+to a given buffer.
+*/
+func (n *btreeNode) writeString(buf *bytes.Buffer, indent int) {
+
+	fmt.Fprintf(buf, "%*s", indent*4, "")
+
+	if n.Leaf {
+		fmt.Fprintf(buf, "Leaf (%v)", n.loc)
+
+		for i, k := range n.Keys {
+			fmt.Fprintf(buf, " %q:%v", k, n.Values[i])
+		}
+
+		buf.WriteString("\n")
+
+		return
+	}
+
+	fmt.Fprintf(buf, "Node (%v)", n.loc)
+
+	for _, k := range n.Keys {
+		fmt.Fprintf(buf, " %q", k)
+	}
+
+	buf.WriteString("\n")
+
+	for _, loc := range n.Children {
+
+		child, err := fetchNode(n.sm, loc)
+		if err != nil {
+			fmt.Fprintf(buf, "%*sError: %v\n", (indent+1)*4, "", err)
+			continue
+		}
+
+		child.writeString(buf, indent+1)
+	}
+}
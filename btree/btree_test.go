@@ -0,0 +1,466 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package btree
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+const DBDIR = "btreetest"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	// Setup
+	if res, _ := fileutil.PathExists(DBDIR); res {
+		os.RemoveAll(DBDIR)
+	}
+
+	err := os.Mkdir(DBDIR, 0770)
+	if err != nil {
+		fmt.Print("Could not create test directory:", err.Error())
+		os.Exit(1)
+	}
+
+	// Run the tests
+	res := m.Run()
+
+	// Teardown
+	err = os.RemoveAll(DBDIR)
+	if err != nil {
+		fmt.Print("Could not remove test directory:", err.Error())
+	}
+
+	os.Exit(res)
+}
+
+func TestBTreeSerialization(t *testing.T) {
+	sm := storage.NewDiskStorageManager(DBDIR+"/test1", false, false, false, false)
+
+	btree, err := NewBTree(sm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc := btree.Location()
+
+	if btree.StorageManager() != sm {
+		t.Error("Unexpected storage manager:", btree.StorageManager())
+		return
+	}
+
+	for i := 0; i < 1000; i++ {
+		btree.Put([]byte(fmt.Sprintf("key%04d", i)), fmt.Sprint("value", i))
+	}
+
+	sm.Close()
+
+	sm2 := storage.NewDiskStorageManager(DBDIR+"/test1", false, false, false, false)
+
+	btree2, err := LoadBTree(sm2, loc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := btree2.Get([]byte("key0500")); res != "value500" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	it := NewBTreeIterator(btree2)
+
+	for i := 0; i < 1000; i++ {
+		if k, v := it.Next(); string(k) != fmt.Sprintf("key%04d", i) || v != fmt.Sprint("value", i) {
+			t.Error("Unexpected result:", string(k), v)
+			return
+		}
+	}
+
+	if it.HasNext() || it.LastError != nil {
+		t.Error("Unexpected iterator state:", it.LastError)
+		return
+	}
+
+	sm2.Close()
+
+	if _, err := LoadBTree(storage.NewMemoryStorageManager("testsm"), 5000); err == nil {
+		t.Error("Loading a tree from an invalid location should fail")
+		return
+	}
+}
+
+func TestBTree(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	sm.AccessMap[1] = storage.AccessInsertError
+
+	_, err := NewBTree(sm)
+	if sfe, ok := err.(*file.StorageFileError); !ok || sfe.Type != file.ErrAlreadyInUse {
+		t.Error("Unexpected new tree result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, 1)
+
+	btree, err := NewBTree(sm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Insert keys in random order and compare the tree with a map
+
+	data := make(map[string]string)
+
+	for _, i := range rand.Perm(2000) {
+		key := fmt.Sprintf("%06d", i*3)
+		data[key] = fmt.Sprint("value", i)
+
+		if old, err := btree.Put([]byte(key), data[key]); old != nil || err != nil {
+			t.Error("Unexpected result:", old, err)
+			return
+		}
+	}
+
+	if old, err := btree.Put([]byte("000003"), "newvalue"); old != "value1" || err != nil {
+		t.Error("Unexpected result:", old, err)
+		return
+	}
+
+	data["000003"] = "newvalue"
+
+	if !checkTree(t, btree, data) {
+		return
+	}
+
+	if res, err := btree.Get([]byte("000004")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Exists([]byte("000006")); !res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Exists([]byte("000007")); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Remove keys in random order
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+
+	for i, j := range rand.Perm(len(keys)) {
+		key := keys[j]
+
+		if old, err := btree.Remove([]byte(key)); old != data[key] || err != nil {
+			t.Error("Unexpected result:", old, err)
+			return
+		}
+
+		delete(data, key)
+
+		if i%250 == 0 && !checkTree(t, btree, data) {
+			return
+		}
+	}
+
+	if old, err := btree.Remove([]byte("000003")); old != nil || err != nil {
+		t.Error("Unexpected result:", old, err)
+		return
+	}
+
+	// All nodes except the root should have been freed
+
+	if len(sm.Data) != 1 {
+		t.Error("Unexpected number of stored nodes:", len(sm.Data))
+		return
+	}
+
+	if res := btree.String(); res != "BTree: testsm (1)\nLeaf (1)\n" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Putting a nil value removes the key
+
+	btree.Put([]byte("a"), "b")
+
+	if old, err := btree.Put([]byte("a"), nil); old != "b" || err != nil {
+		t.Error("Unexpected result:", old, err)
+		return
+	}
+
+	if res, err := btree.Get([]byte("a")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
+
+func TestBTreeString(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	btree, _ := NewBTree(sm)
+
+	for i := 0; i < MaxNodeKeys+1; i++ {
+		btree.Put([]byte(fmt.Sprintf("%03d", i)), i)
+	}
+
+	res := btree.String()
+
+	if !strings.HasPrefix(res, `BTree: testsm (1)
+Node (1) "032"
+    Leaf (3) "000":0 "001":1`) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	sm.AccessMap[2] = storage.AccessCacheAndFetchError
+
+	if res := btree.String(); !strings.Contains(res, "    Error: Slot not found") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestBTreeErrors(t *testing.T) {
+	var err error
+
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	btree, _ := NewBTree(sm)
+
+	for i := 0; i < 500; i++ {
+		btree.Put([]byte(fmt.Sprintf("%03d", i)), i)
+	}
+
+	// Fetch errors on the leaves
+
+	for loc := range sm.Data {
+		if loc != btree.Location() {
+			sm.AccessMap[loc] = storage.AccessCacheAndFetchError
+		}
+	}
+
+	if _, err := btree.Get([]byte("001")); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := btree.Put([]byte("001"), 1); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := btree.Remove([]byte("001")); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	it := NewBTreeIterator(btree)
+
+	if it.HasNext() || it.LastError == nil {
+		t.Error("Unexpected iterator state:", it.LastError)
+		return
+	}
+
+	sm.AccessMap = make(map[uint64]int)
+
+	// Insert errors during a split
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	for i := 500; i < 1000; i++ {
+		if _, err = btree.Put([]byte(fmt.Sprintf("%03d", i)), i); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		t.Error("Insert error expected")
+		return
+	}
+
+	// Update errors
+
+	sm.AccessMap = make(map[uint64]int)
+	sm.AccessMap[btree.Location()] = storage.AccessUpdateError
+
+	small, _ := NewBTree(sm)
+	sm.AccessMap[small.Location()] = storage.AccessUpdateError
+
+	if _, err := small.Put([]byte("a"), 1); err == nil {
+		t.Error("Update error expected")
+		return
+	}
+}
+
+func TestBTreeConcurrency(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	btree, _ := NewBTree(sm)
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 500; i++ {
+				key := []byte(fmt.Sprintf("%v-%04d", g, i))
+
+				btree.Put(key, i)
+
+				if i%2 == 0 {
+					btree.Remove(key)
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	data := make(map[string]string)
+	for g := 0; g < 4; g++ {
+		for i := 1; i < 500; i += 2 {
+			data[fmt.Sprintf("%v-%04d", g, i)] = ""
+		}
+	}
+
+	it := NewBTreeIterator(btree)
+	count := 0
+
+	for it.HasNext() {
+		k, _ := it.Next()
+		if _, ok := data[string(k)]; !ok {
+			t.Error("Unexpected key:", string(k))
+			return
+		}
+		count++
+	}
+
+	if count != len(data) {
+		t.Error("Unexpected count:", count)
+	}
+}
+
+/*
+checkTree checks the content and the structure of a tree.
+*/
+func checkTree(t *testing.T, btree *BTree, data map[string]string) bool {
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if res, err := btree.Get([]byte(k)); res != data[k] || err != nil {
+			t.Error("Unexpected result:", k, res, err)
+			return false
+		}
+	}
+
+	// Check the order of all keys
+
+	it := NewBTreeIterator(btree)
+
+	for _, k := range keys {
+		key, value := it.Next()
+
+		if string(key) != k || value != data[k] {
+			t.Error("Unexpected iterator result:", string(key), value, "expected:", k)
+			return false
+		}
+	}
+
+	if it.HasNext() || it.LastError != nil {
+		t.Error("Unexpected iterator state:", it.LastError)
+		return false
+	}
+
+	// Check that all leaves have the same depth and that all keys of a node
+	// are within the bounds given by its parent
+
+	depth := -1
+
+	var checkNode func(n *btreeNode, level int, lower []byte, upper []byte) bool
+
+	checkNode = func(n *btreeNode, level int, lower []byte, upper []byte) bool {
+
+		for _, k := range n.Keys {
+			if (lower != nil && string(k) < string(lower)) || (upper != nil && string(k) >= string(upper)) {
+				t.Error("Key out of bounds:", string(k), string(lower), string(upper))
+				return false
+			}
+		}
+
+		if n.Leaf {
+			if depth == -1 {
+				depth = level
+			} else if depth != level {
+				t.Error("Unexpected leaf depth:", level, depth)
+				return false
+			}
+			return true
+		}
+
+		if len(n.Children) != len(n.Keys)+1 {
+			t.Error("Unexpected number of children:", len(n.Children), len(n.Keys))
+			return false
+		}
+
+		for i, loc := range n.Children {
+			child, err := fetchNode(n.sm, loc)
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			clower, cupper := lower, upper
+			if i > 0 {
+				clower = n.Keys[i-1]
+			}
+			if i < len(n.Keys) {
+				cupper = n.Keys[i]
+			}
+
+			if !checkNode(child, level+1, clower, cupper) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return checkNode(btree.Root, 0, nil, nil)
+}
@@ -0,0 +1,143 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package btree
+
+import (
+	"bytes"
+	"errors"
+)
+
+/*
+ErrNoMoreItems is assigned to LastError when Next() is called and there are no
+more items to iterate.
+*/
+var ErrNoMoreItems = errors.New("No more items to iterate")
+
+/*
+BTreeIterator data structure
+*/
+type BTreeIterator struct {
+	tree      *BTree        // Tree to iterate
+	from      []byte        // First key of the iterated range (inclusive)
+	to        []byte        // Last key of the iterated range (exclusive)
+	lastKey   []byte        // Last key which was read from the tree
+	keys      [][]byte      // Buffered keys of the current leaf
+	values    []interface{} // Buffered values of the current leaf
+	done      bool          // Flag if the end of the range was reached
+	LastError error         // Last encountered error
+}
+
+/*
+NewBTreeIterator creates a new BTreeIterator which iterates all keys of a tree
+in ascending order.
+*/
+func NewBTreeIterator(tree *BTree) *BTreeIterator {
+	return NewBTreeRangeIterator(tree, nil, nil)
+}
+
+/*
+NewBTreeRangeIterator creates a new BTreeIterator which iterates all keys of a
+tree in ascending order which are in the range [from, to). A nil value for
+from or to means that the range is not limited on that side.
+*/
+func NewBTreeRangeIterator(tree *BTree, from []byte, to []byte) *BTreeIterator {
+	it := &BTreeIterator{tree, from, to, nil, nil, nil, false, nil}
+
+	it.fill()
+
+	return it
+}
+
+/*
+HasNext returns if there is a next key / value pair.
+*/
+func (it *BTreeIterator) HasNext() bool {
+	return len(it.keys) > 0
+}
+
+/*
+Next returns the next key / value pair.
+*/
+func (it *BTreeIterator) Next() ([]byte, interface{}) {
+
+	if len(it.keys) == 0 {
+		if it.LastError == nil {
+			it.LastError = ErrNoMoreItems
+		}
+		return nil, nil
+	}
+
+	key := it.keys[0]
+	value := it.values[0]
+
+	it.keys = it.keys[1:]
+	it.values = it.values[1:]
+
+	if len(it.keys) == 0 {
+		it.fill()
+	}
+
+	return key, value
+}
+
+/*
+fill buffers the next key / value pairs of the iterated range. All buffered
+pairs come from a single leaf. The tree might have changed significantly after
+the last call. The leaf which contains the next key is looked up from the root.
+*/
+func (it *BTreeIterator) fill() {
+
+	if it.done {
+		return
+	}
+
+	it.tree.mutex.Lock()
+	defer it.tree.mutex.Unlock()
+
+	start := it.from
+	if it.lastKey != nil {
+		start = it.lastKey
+	}
+
+	leaf, err := it.tree.Root.findLeaf(start)
+
+	for err == nil {
+		for i, k := range leaf.Keys {
+
+			if it.lastKey != nil && bytes.Compare(k, it.lastKey) <= 0 {
+				continue
+			} else if it.from != nil && bytes.Compare(k, it.from) < 0 {
+				continue
+			} else if it.to != nil && bytes.Compare(k, it.to) >= 0 {
+				it.done = true
+				break
+			}
+
+			it.keys = append(it.keys, k)
+			it.values = append(it.values, leaf.Values[i])
+		}
+
+		if len(it.keys) > 0 {
+			it.lastKey = it.keys[len(it.keys)-1]
+			return
+		} else if it.done || leaf.Next == 0 {
+			it.done = true
+			return
+		}
+
+		leaf, err = fetchNode(leaf.sm, leaf.Next)
+	}
+
+	// There was a serious error terminate the iterator
+
+	it.LastError = err
+	it.done = true
+}
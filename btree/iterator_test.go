@@ -0,0 +1,123 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package btree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestIterator(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	btree, _ := NewBTree(sm)
+
+	// Test empty tree
+
+	it := NewBTreeIterator(btree)
+
+	if it.HasNext() {
+		t.Error("Empty tree should have no items")
+		return
+	}
+
+	if k, v := it.Next(); k != nil || v != nil || it.LastError != ErrNoMoreItems {
+		t.Error("Unexpected result:", k, v, it.LastError)
+		return
+	}
+
+	for i := 0; i < 300; i++ {
+		btree.Put([]byte(fmt.Sprintf("%04d", i*2)), i*2)
+	}
+
+	checkRange := func(from []byte, to []byte, first int, last int) bool {
+
+		it := NewBTreeRangeIterator(btree, from, to)
+
+		for i := first; i <= last; i += 2 {
+			if k, v := it.Next(); string(k) != fmt.Sprintf("%04d", i) || v != i {
+				t.Error("Unexpected result:", string(k), v, "expected:", i)
+				return false
+			}
+		}
+
+		if it.HasNext() || it.LastError != nil {
+			t.Error("Unexpected iterator state:", it.LastError)
+			return false
+		}
+
+		return true
+	}
+
+	if !checkRange(nil, nil, 0, 598) {
+		return
+	}
+
+	if !checkRange([]byte("0100"), []byte("0200"), 100, 198) {
+		return
+	}
+
+	if !checkRange([]byte("0101"), []byte("0201"), 102, 200) {
+		return
+	}
+
+	if !checkRange(nil, []byte("0010"), 0, 8) {
+		return
+	}
+
+	if !checkRange([]byte("0590"), nil, 590, 598) {
+		return
+	}
+
+	if !checkRange([]byte("0700"), nil, 0, -1) {
+		return
+	}
+
+	if !checkRange([]byte("0200"), []byte("0100"), 0, -1) {
+		return
+	}
+
+	// Change the tree while iterating
+
+	it = NewBTreeIterator(btree)
+
+	for i := 0; i < 100; i += 2 {
+		if k, _ := it.Next(); string(k) != fmt.Sprintf("%04d", i) {
+			t.Error("Unexpected result:", string(k))
+			return
+		}
+	}
+
+	// Remove keys which were not yet iterated and add new keys - the rest of
+	// the current leaf was already buffered by the iterator
+
+	for i := 400; i < 600; i += 2 {
+		btree.Remove([]byte(fmt.Sprintf("%04d", i)))
+		btree.Put([]byte(fmt.Sprintf("%04d", i+1)), i+1)
+	}
+
+	btree.Put([]byte("0001"), 1)
+
+	var res []int
+
+	for it.HasNext() {
+		_, v := it.Next()
+		res = append(res, v.(int))
+	}
+
+	if it.LastError != nil || len(res) != 250 || res[0] != 100 || res[149] != 398 ||
+		res[150] != 401 || res[249] != 599 {
+		t.Error("Unexpected result:", res, it.LastError)
+		return
+	}
+}
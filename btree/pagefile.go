@@ -0,0 +1,707 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+File layout of a PageFile:
+
+	Page 0    - Header (magic, version, page size, page count, free list head, roots)
+	Page 1..n - Data pages (next page, used bytes, payload) or free pages
+
+An object is stored in a chain of data pages. The location of an object is the
+number of its first page. Free pages are linked with each other and reused
+before the file grows.
+
+All changed pages of a flush are first written to a journal file which is
+synced before the pages are written to the page file. A complete journal is
+replayed when the page file is opened. An incomplete journal is discarded.
+*/
+const (
+	pageFileMagic      = "EBPF"
+	pageFileVersion    = 1
+	pageHeaderSize     = 12
+	pageFileRootOffset = 40
+)
+
+/*
+PageSize is the size of a single page of a PageFile
+*/
+const PageSize = 4096
+
+/*
+PageFileRoots is the number of root values of a PageFile
+*/
+const PageFileRoots = 32
+
+/*
+File suffixes of a PageFile
+*/
+const (
+	FileSuffixPages   = "pages"
+	FileSuffixJournal = "journal"
+)
+
+/*
+ErrCorrupted is returned if a page file contains invalid data.
+*/
+var ErrCorrupted = errors.New("Page file is corrupted")
+
+/*
+pageFileHeader is the state which is stored in the header page.
+*/
+type pageFileHeader struct {
+	count uint64                // Number of pages in the file (including the header)
+	free  uint64                // First free page (0 if there are no free pages)
+	roots [PageFileRoots]uint64 // Root values
+}
+
+/*
+PageFile is a storage.Manager which stores objects in the fixed-size pages of a
+single file. Changes are kept in memory until they are flushed.
+*/
+type PageFile struct {
+	name      string                 // Name of the page file
+	file      *os.File               // Page file
+	header    pageFileHeader         // Current header
+	committed pageFileHeader         // Header of the last flush
+	dirty     map[uint64][]byte      // Changed pages which have not been flushed
+	cache     map[uint64]interface{} // Cache of stored objects
+	cacheSize int                    // Maximum number of cached objects
+	mutex     *sync.Mutex            // Mutex to protect the page file
+}
+
+/*
+NewPageFile opens or creates a page file. The given name is the base name of
+the page file and its journal. At most cacheSize objects are cached.
+*/
+func NewPageFile(name string, cacheSize int) (*PageFile, error) {
+
+	file, err := os.OpenFile(fmt.Sprintf("%v.%v", name, FileSuffixPages), os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+
+	pf := &PageFile{name, file, pageFileHeader{}, pageFileHeader{}, make(map[uint64][]byte),
+		make(map[uint64]interface{}), cacheSize, &sync.Mutex{}}
+
+	if err = pf.open(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return pf, nil
+}
+
+/*
+open replays the journal and reads the header of the page file or
+initialises a new page file.
+*/
+func (pf *PageFile) open() error {
+
+	if err := pf.replayJournal(); err != nil {
+		return err
+	}
+
+	info, err := pf.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		pf.header.count = 1
+		pf.dirty[0] = nil
+
+		return pf.flush()
+	}
+
+	page := make([]byte, PageSize)
+
+	if _, err = pf.file.ReadAt(page, 0); err != nil {
+		return ErrCorrupted
+	}
+
+	if string(page[:4]) != pageFileMagic {
+		return ErrCorrupted
+	} else if v := binary.BigEndian.Uint32(page[4:]); v != pageFileVersion {
+		return fmt.Errorf("Unsupported page file version: %v", v)
+	} else if s := binary.BigEndian.Uint32(page[8:]); s != PageSize {
+		return fmt.Errorf("Unsupported page size: %v", s)
+	}
+
+	pf.header.count = binary.BigEndian.Uint64(page[16:])
+	pf.header.free = binary.BigEndian.Uint64(page[24:])
+
+	for i := range pf.header.roots {
+		pf.header.roots[i] = binary.BigEndian.Uint64(page[pageFileRootOffset+i*8:])
+	}
+
+	if pf.header.count == 0 || uint64(info.Size()) < pf.header.count*PageSize {
+		return ErrCorrupted
+	}
+
+	pf.committed = pf.header
+
+	return nil
+}
+
+/*
+Name returns the name of the PageFile instance.
+*/
+func (pf *PageFile) Name() string {
+	return pf.name
+}
+
+/*
+Root returns a root value.
+*/
+func (pf *PageFile) Root(root int) uint64 {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	return pf.header.roots[root]
+}
+
+/*
+SetRoot writes a root value.
+*/
+func (pf *PageFile) SetRoot(root int, val uint64) {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	pf.header.roots[root] = val
+	pf.dirty[0] = nil
+}
+
+/*
+Insert inserts an object and return its storage location.
+*/
+func (pf *PageFile) Insert(o interface{}) (uint64, error) {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	data, err := encodeObject(o)
+	if err != nil {
+		return 0, err
+	}
+
+	loc, err := pf.allocPage()
+	if err == nil {
+		if err = pf.writeChain([]uint64{loc}, data); err == nil {
+			pf.cacheObject(loc, o)
+		}
+	}
+
+	return loc, err
+}
+
+/*
+Update updates a storage location.
+*/
+func (pf *PageFile) Update(loc uint64, o interface{}) error {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	data, err := encodeObject(o)
+	if err != nil {
+		return err
+	}
+
+	chain, err := pf.readChain(loc, nil)
+	if err == nil {
+		if err = pf.writeChain(chain, data); err == nil {
+			pf.cacheObject(loc, o)
+		}
+	}
+
+	return err
+}
+
+/*
+Free frees a storage location.
+*/
+func (pf *PageFile) Free(loc uint64) error {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	chain, err := pf.readChain(loc, nil)
+	if err != nil {
+		return err
+	}
+
+	delete(pf.cache, loc)
+
+	for _, id := range chain {
+		pf.freePage(id)
+	}
+
+	return nil
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container.
+*/
+func (pf *PageFile) Fetch(loc uint64, o interface{}) error {
+	var buf bytes.Buffer
+
+	pf.mutex.Lock()
+	_, err := pf.readChain(loc, &buf)
+	pf.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return gob.NewDecoder(&buf).Decode(o)
+}
+
+/*
+FetchCached fetches an object from a cache and returns its reference.
+Returns a storage.ErrNotInCache error if the entry is not in the cache.
+*/
+func (pf *PageFile) FetchCached(loc uint64) (interface{}, error) {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	if o, ok := pf.cache[loc]; ok {
+		return o, nil
+	}
+
+	return nil, storage.NewStorageManagerError(storage.ErrNotInCache, "", pf.name)
+}
+
+/*
+Flush writes all pending changes to disk.
+*/
+func (pf *PageFile) Flush() error {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	return pf.flush()
+}
+
+/*
+Rollback cancels all pending changes which have not yet been written to disk.
+*/
+func (pf *PageFile) Rollback() error {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	pf.header = pf.committed
+	pf.dirty = make(map[uint64][]byte)
+	pf.cache = make(map[uint64]interface{})
+
+	return nil
+}
+
+/*
+Compact releases all free pages at the end of the page file. Objects are not
+moved - free pages between stored objects are kept in the free list.
+*/
+func (pf *PageFile) Compact() error {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	var free []uint64
+
+	for id := pf.header.free; id != 0; {
+		page, err := pf.readPage(id)
+		if err != nil {
+			return err
+		}
+
+		free = append(free, id)
+		id = binary.BigEndian.Uint64(page)
+	}
+
+	sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+
+	for len(free) > 0 && free[len(free)-1] == pf.header.count-1 {
+		free = free[:len(free)-1]
+		delete(pf.dirty, pf.header.count-1)
+		pf.header.count--
+	}
+
+	// Rebuild the free list with the remaining free pages
+
+	pf.header.free = 0
+
+	for i := len(free) - 1; i >= 0; i-- {
+		pf.freePage(free[i])
+	}
+
+	pf.dirty[0] = nil
+
+	if err := pf.flush(); err != nil {
+		return err
+	}
+
+	return pf.file.Truncate(int64(pf.header.count * PageSize))
+}
+
+/*
+Close the StorageManager and write all pending changes to disk.
+*/
+func (pf *PageFile) Close() error {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	if err := pf.flush(); err != nil {
+		return err
+	}
+
+	return pf.file.Close()
+}
+
+/*
+encodeObject encodes an object which should be stored.
+*/
+func encodeObject(o interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(o); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/*
+cacheObject adds an object to the cache. A random entry is removed if the
+cache is full.
+*/
+func (pf *PageFile) cacheObject(loc uint64, o interface{}) {
+
+	if _, ok := pf.cache[loc]; !ok && len(pf.cache) >= pf.cacheSize {
+		for k := range pf.cache {
+			delete(pf.cache, k)
+			break
+		}
+	}
+
+	if pf.cacheSize > 0 {
+		pf.cache[loc] = o
+	}
+}
+
+/*
+readPage reads a page. Changed pages are read from memory.
+*/
+func (pf *PageFile) readPage(id uint64) ([]byte, error) {
+
+	if id == 0 || id >= pf.header.count {
+		return nil, storage.NewStorageManagerError(storage.ErrSlotNotFound,
+			fmt.Sprintf("Location:%v", id), pf.name)
+	}
+
+	if page, ok := pf.dirty[id]; ok {
+		return page, nil
+	}
+
+	page := make([]byte, PageSize)
+
+	if _, err := pf.file.ReadAt(page, int64(id*PageSize)); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+/*
+readChain reads the chain of pages which stores an object. Returns the page
+numbers of the chain. The payload is written to the given buffer if it is not nil.
+*/
+func (pf *PageFile) readChain(loc uint64, buf *bytes.Buffer) ([]uint64, error) {
+	var chain []uint64
+
+	if loc == 0 {
+		return nil, storage.NewStorageManagerError(storage.ErrSlotNotFound,
+			fmt.Sprintf("Location:%v", loc), pf.name)
+	}
+
+	for id := loc; id != 0; {
+		page, err := pf.readPage(id)
+		if err != nil {
+			return nil, err
+		}
+
+		used := binary.BigEndian.Uint32(page[8:])
+
+		if used > PageSize-pageHeaderSize || uint64(len(chain)) >= pf.header.count {
+			return nil, ErrCorrupted
+		}
+
+		if buf != nil {
+			buf.Write(page[pageHeaderSize : pageHeaderSize+used])
+		}
+
+		chain = append(chain, id)
+		id = binary.BigEndian.Uint64(page)
+	}
+
+	return chain, nil
+}
+
+/*
+writeChain writes the payload of an object to a chain of pages. Additional
+pages are allocated if the chain is too short and pages which are not needed
+are freed.
+*/
+func (pf *PageFile) writeChain(chain []uint64, data []byte) error {
+	var err error
+
+	payloadSize := PageSize - pageHeaderSize
+	pages := (len(data) + payloadSize - 1) / payloadSize
+
+	if pages == 0 {
+		pages = 1
+	}
+
+	for len(chain) < pages {
+		var id uint64
+
+		if id, err = pf.allocPage(); err != nil {
+			return err
+		}
+
+		chain = append(chain, id)
+	}
+
+	for _, id := range chain[pages:] {
+		pf.freePage(id)
+	}
+
+	for i, id := range chain[:pages] {
+		var next uint64
+
+		if i < pages-1 {
+			next = chain[i+1]
+		}
+
+		page := make([]byte, PageSize)
+		used := copy(page[pageHeaderSize:], data)
+		data = data[used:]
+
+		binary.BigEndian.PutUint64(page, next)
+		binary.BigEndian.PutUint32(page[8:], uint32(used))
+
+		pf.dirty[id] = page
+	}
+
+	return nil
+}
+
+/*
+allocPage allocates a page. Free pages are reused before the file grows.
+*/
+func (pf *PageFile) allocPage() (uint64, error) {
+
+	pf.dirty[0] = nil
+
+	if id := pf.header.free; id != 0 {
+		page, err := pf.readPage(id)
+		if err != nil {
+			return 0, err
+		}
+
+		pf.header.free = binary.BigEndian.Uint64(page)
+
+		return id, nil
+	}
+
+	pf.header.count++
+
+	return pf.header.count - 1, nil
+}
+
+/*
+freePage adds a page to the free list.
+*/
+func (pf *PageFile) freePage(id uint64) {
+	page := make([]byte, PageSize)
+
+	binary.BigEndian.PutUint64(page, pf.header.free)
+
+	pf.header.free = id
+	pf.dirty[id] = page
+	pf.dirty[0] = nil
+}
+
+/*
+headerPage returns the header page for the current header.
+*/
+func (pf *PageFile) headerPage() []byte {
+	page := make([]byte, PageSize)
+
+	copy(page, pageFileMagic)
+	binary.BigEndian.PutUint32(page[4:], pageFileVersion)
+	binary.BigEndian.PutUint32(page[8:], PageSize)
+	binary.BigEndian.PutUint64(page[16:], pf.header.count)
+	binary.BigEndian.PutUint64(page[24:], pf.header.free)
+
+	for i, r := range pf.header.roots {
+		binary.BigEndian.PutUint64(page[pageFileRootOffset+i*8:], r)
+	}
+
+	return page
+}
+
+/*
+flush writes all changed pages first to the journal and then to the page file.
+*/
+func (pf *PageFile) flush() error {
+
+	if len(pf.dirty) == 0 {
+		return nil
+	}
+
+	ids, err := pf.writeJournal()
+	if err != nil {
+		return err
+	}
+
+	// Write all pages to the page file
+
+	for _, id := range ids {
+		if _, err := pf.file.WriteAt(pf.dirty[id], int64(id*PageSize)); err != nil {
+			return err
+		}
+	}
+
+	if err := pf.file.Sync(); err != nil {
+		return err
+	}
+
+	pf.committed = pf.header
+	pf.dirty = make(map[uint64][]byte)
+
+	return os.Remove(pf.journalName())
+}
+
+/*
+writeJournal writes all changed pages and the header page to the journal and
+syncs it. Each entry is the page number followed by the page. The journal ends
+with the number of entries and a checksum. Returns the written page numbers.
+*/
+func (pf *PageFile) writeJournal() ([]uint64, error) {
+	var idbuf [8]byte
+
+	pf.dirty[0] = pf.headerPage()
+
+	ids := make([]uint64, 0, len(pf.dirty))
+	for id := range pf.dirty {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(ids)*(PageSize+8)+12))
+
+	for _, id := range ids {
+		binary.BigEndian.PutUint64(idbuf[:], id)
+		buf.Write(idbuf[:])
+		buf.Write(pf.dirty[id])
+	}
+
+	binary.BigEndian.PutUint64(idbuf[:], uint64(len(ids)))
+	buf.Write(idbuf[:])
+	binary.BigEndian.PutUint32(idbuf[:], crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(idbuf[:4])
+
+	journal, err := os.OpenFile(pf.journalName(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = journal.Write(buf.Bytes()); err == nil {
+		err = journal.Sync()
+	}
+
+	if cerr := journal.Close(); err == nil {
+		err = cerr
+	}
+
+	return ids, err
+}
+
+/*
+journalName returns the name of the journal file.
+*/
+func (pf *PageFile) journalName() string {
+	return fmt.Sprintf("%v.%v", pf.name, FileSuffixJournal)
+}
+
+/*
+replayJournal writes all pages of a complete journal to the page file and
+removes the journal.
+*/
+func (pf *PageFile) replayJournal() error {
+
+	journal, err := os.Open(pf.journalName())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+
+	_, err = io.Copy(&data, journal)
+	journal.Close()
+
+	if err != nil {
+		return err
+	}
+
+	entrySize := PageSize + 8
+	j := data.Bytes()
+
+	// Check that the journal is complete
+
+	if len(j) >= 12 {
+		count := binary.BigEndian.Uint64(j[len(j)-12:])
+		checksum := binary.BigEndian.Uint32(j[len(j)-4:])
+
+		if uint64(len(j)-12) == count*uint64(entrySize) &&
+			crc32.ChecksumIEEE(j[:len(j)-4]) == checksum {
+
+			for i := 0; i < int(count); i++ {
+				entry := j[i*entrySize : (i+1)*entrySize]
+				id := binary.BigEndian.Uint64(entry)
+
+				if _, err := pf.file.WriteAt(entry[8:], int64(id*PageSize)); err != nil {
+					return err
+				}
+			}
+
+			if err := pf.file.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return os.Remove(pf.journalName())
+}
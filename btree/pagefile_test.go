@@ -0,0 +1,240 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package btree
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestPageFile(t *testing.T) {
+	var res string
+
+	name := DBDIR + "/pagefile1"
+
+	pf, err := NewPageFile(name, 10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var _ storage.Manager = pf
+
+	if pf.Name() != name {
+		t.Error("Unexpected name:", pf.Name())
+		return
+	}
+
+	loc1, err := pf.Insert("test1")
+	if err != nil || loc1 != 1 {
+		t.Error("Unexpected result:", loc1, err)
+		return
+	}
+
+	// Big objects are stored in several pages
+
+	big := strings.Repeat("x", 3*PageSize)
+
+	loc2, err := pf.Insert(big)
+	if err != nil || loc2 != 2 || pf.header.count != 6 {
+		t.Error("Unexpected result:", loc2, pf.header.count, err)
+		return
+	}
+
+	pf.SetRoot(RootIDStoreTree, loc2)
+
+	if err := pf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if obj, err := pf.FetchCached(loc2); err != nil || obj.(string) != big {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := pf.FetchCached(loc2 + 1); err.(*storage.ManagerError).Type != storage.ErrNotInCache {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Shrinking an object frees its pages which are then reused
+
+	if err := pf.Update(loc2, "small"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := pf.Fetch(loc2, &res); err != nil || res != "small" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	loc3, err := pf.Insert("test3")
+	if err != nil || loc3 == 6 || pf.header.count != 6 {
+		t.Error("Unexpected result:", loc3, pf.header.count, err)
+		return
+	}
+
+	// Pending changes can be rolled back
+
+	if err := pf.Rollback(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := pf.Fetch(loc2, &res); err != nil || res != big {
+		t.Error("Unexpected result:", len(res), err)
+		return
+	}
+
+	if err := pf.Free(loc1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := pf.Fetch(0, &res); err.(*storage.ManagerError).Type != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := pf.Fetch(100, &res); err.(*storage.ManagerError).Type != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := pf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Data is persisted
+
+	if pf, err = NewPageFile(name, 10); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := pf.Root(RootIDStoreTree); res != loc2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := pf.Fetch(loc2, &res); err != nil || res != big {
+		t.Error("Unexpected result:", len(res), err)
+		return
+	}
+
+	if pf.header.free != loc1 {
+		t.Error("Unexpected free list:", pf.header.free)
+		return
+	}
+
+	// Compaction releases free pages at the end of the file
+
+	if err := pf.Free(loc2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := pf.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if info, _ := os.Stat(name + ".pages"); pf.header.count != 1 || info.Size() != PageSize {
+		t.Error("Unexpected result:", pf.header.count, info.Size())
+		return
+	}
+
+	pf.Close()
+}
+
+func TestPageFileJournal(t *testing.T) {
+	var res string
+
+	name := DBDIR + "/pagefile2"
+
+	pf, err := NewPageFile(name, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc, _ := pf.Insert("test1")
+	pf.Flush()
+
+	// Simulate a flush which was interrupted after the journal was written
+
+	pf.Update(loc, "test2")
+
+	if _, err := pf.writeJournal(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	journal := pf.journalName()
+	data, _ := ioutil.ReadFile(journal)
+
+	pf.file.Close()
+
+	// A complete journal is replayed
+
+	if pf, err = NewPageFile(name, 0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := pf.Fetch(loc, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	pf.Close()
+
+	// An incomplete journal is discarded
+
+	pf, _ = NewPageFile(name, 0)
+	pf.Update(loc, "test3")
+	pf.Close()
+
+	ioutil.WriteFile(journal, data[:len(data)-1], 0660)
+
+	if pf, err = NewPageFile(name, 0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := pf.Fetch(loc, &res); err != nil || res != "test3" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Error("Journal should have been removed:", err)
+		return
+	}
+
+	pf.Close()
+
+	// Invalid files cannot be opened
+
+	ioutil.WriteFile(DBDIR+"/pagefile3.pages", []byte("invalid"), 0660)
+
+	if _, err := NewPageFile(DBDIR+"/pagefile3", 0); err != ErrCorrupted {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
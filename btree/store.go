@@ -0,0 +1,239 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package btree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/krotik/eliasdb/storage/kv"
+)
+
+/*
+RootIDStoreTree is the root ID which holds the location of the BTree of a Store
+*/
+const RootIDStoreTree = 2
+
+/*
+StoreCacheSize is the maximum number of tree nodes which are cached by a Store
+*/
+var StoreCacheSize = 10000
+
+/*
+Store is a key-value store (kv.Store) which keeps all its data in a BTree. The
+tree is stored in a PageFile - it does not use the slotting storage of a
+DiskStorageManager. All changes of a batch are written in a single flush of
+the PageFile.
+*/
+type Store struct {
+	name  string        // Name of the store
+	sm    *PageFile     // StorageManager which stores the tree
+	tree  *BTree        // Tree which holds all data (nil if closed)
+	mutex *sync.RWMutex // Mutex to protect the tree
+}
+
+/*
+OpenStore opens or creates a Store. The given name is the base name of all
+files of the Store. Changes of incomplete batches are discarded.
+*/
+func OpenStore(name string) (*Store, error) {
+
+	if _, err := os.Stat(filepath.Dir(name)); err != nil {
+		return nil, err
+	}
+
+	pf, err := NewPageFile(name, StoreCacheSize)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{name, pf, nil, &sync.RWMutex{}}
+
+	if err := s.loadTree(); err != nil {
+		s.sm.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+/*
+loadTree loads the tree of the Store or creates a new tree if the Store is empty.
+*/
+func (s *Store) loadTree() error {
+	var err error
+
+	if loc := s.sm.Root(RootIDStoreTree); loc != 0 {
+		s.tree, err = LoadBTree(s.sm, loc)
+		return err
+	}
+
+	if s.tree, err = NewBTree(s.sm); err == nil {
+		s.sm.SetRoot(RootIDStoreTree, s.tree.Location())
+		err = s.sm.Flush()
+	}
+
+	return err
+}
+
+/*
+Name returns the name of this Store.
+*/
+func (s *Store) Name() string {
+	return s.name
+}
+
+/*
+Get returns the value of a given key. Returns nil if the key does not exist.
+*/
+func (s *Store) Get(key []byte) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.tree == nil {
+		return nil, kv.ErrClosed
+	}
+
+	val, err := s.tree.Get(key)
+	if val == nil || err != nil {
+		return nil, err
+	}
+
+	return storedValue(val), nil
+}
+
+/*
+Scan calls a given function for all keys in the range [start, end) in byte
+order. A nil end means that there is no upper limit. The function must not
+write to the Store.
+*/
+func (s *Store) Scan(start []byte, end []byte, fn func(key []byte, value []byte) bool) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.tree == nil {
+		return kv.ErrClosed
+	}
+
+	it := NewBTreeRangeIterator(s.tree, start, end)
+
+	for it.HasNext() {
+		if key, val := it.Next(); !fn(key, storedValue(val)) {
+			break
+		}
+	}
+
+	return it.LastError
+}
+
+/*
+storedValue returns the byte slice of a value which was read from the tree.
+Empty values might be decoded as nil slices.
+*/
+func storedValue(val interface{}) []byte {
+	if ret := val.([]byte); ret != nil {
+		return ret
+	}
+	return []byte{}
+}
+
+/*
+Write atomically applies all operations of a given batch. Readers never see
+a partially applied batch. If an error occurs then all changes of the batch
+are rolled back.
+*/
+func (s *Store) Write(batch *kv.Batch) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tree == nil {
+		return kv.ErrClosed
+	} else if batch.Len() == 0 {
+		return nil
+	}
+
+	err := batch.Apply(func(key []byte, value []byte) error {
+		var err error
+
+		if value == nil {
+			_, err = s.tree.Remove(key)
+		} else {
+			_, err = s.tree.Put(key, value)
+		}
+
+		return err
+	})
+
+	if err == nil {
+		err = s.sm.Flush()
+	}
+
+	if err != nil {
+		err = s.rollback(err)
+	}
+
+	return err
+}
+
+/*
+rollback discards all changes which have not been flushed and reloads the last
+written version of the tree. Returns the given error which caused the rollback.
+*/
+func (s *Store) rollback(err error) error {
+
+	rerr := s.sm.Rollback()
+
+	if rerr == nil {
+		var tree *BTree
+
+		if tree, rerr = LoadBTree(s.sm, s.tree.Location()); rerr == nil {
+			s.tree = tree
+		}
+	}
+
+	if rerr != nil {
+		return fmt.Errorf("%v (rollback failed: %v)", err, rerr)
+	}
+
+	return err
+}
+
+/*
+Compact releases unused space at the end of the file of the Store.
+*/
+func (s *Store) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tree == nil {
+		return kv.ErrClosed
+	}
+
+	return s.sm.Compact()
+}
+
+/*
+Close closes the Store.
+*/
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tree == nil {
+		return kv.ErrClosed
+	}
+
+	s.tree = nil
+
+	return s.sm.Close()
+}
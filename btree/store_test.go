@@ -0,0 +1,396 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage/kv"
+)
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", i))
+}
+
+func scanKeys(s kv.Store, start []byte, end []byte) ([]string, error) {
+	var res []string
+
+	err := s.Scan(start, end, func(key []byte, value []byte) bool {
+		res = append(res, string(key))
+		return true
+	})
+
+	return res, err
+}
+
+func TestStore(t *testing.T) {
+	name := DBDIR + "/store1"
+
+	s, err := OpenStore(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var _ kv.Store = s
+
+	if s.Name() != name {
+		t.Error("Unexpected name:", s.Name())
+		return
+	}
+
+	if res, err := s.Get([]byte("foo")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := scanKeys(s, nil, nil); len(res) != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := s.Write(kv.NewBatch()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Insert enough keys in random order to build a tree with several levels
+
+	b := kv.NewBatch()
+	for i := 0; i < 10000; i++ {
+		k := (i * 7919) % 10000
+		b.Put(testKey(k), []byte(fmt.Sprint("val", k)))
+
+		if b.Len() == 500 {
+			if err := s.Write(b); err != nil {
+				t.Error(err)
+				return
+			}
+			b = kv.NewBatch()
+		}
+	}
+
+	for _, i := range []int{0, 1, 4711, 9999} {
+		if res, err := s.Get(testKey(i)); string(res) != fmt.Sprint("val", i) || err != nil {
+			t.Error("Unexpected result:", string(res), err)
+			return
+		}
+	}
+
+	if res, err := s.Get([]byte("key")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Empty values can be stored
+
+	b = kv.NewBatch()
+	b.Put([]byte("empty"), []byte{})
+	s.Write(b)
+
+	if res, err := s.Get([]byte("empty")); res == nil || len(res) != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Scan ranges
+
+	res, err := scanKeys(s, testKey(0), nil)
+	if err != nil || len(res) != 10000 {
+		t.Error("Unexpected result:", len(res), err)
+		return
+	}
+
+	for i, k := range res {
+		if k != string(testKey(i)) {
+			t.Error("Unexpected key order:", i, k)
+			return
+		}
+	}
+
+	if res, err := scanKeys(s, testKey(4000), testKey(4003)); err != nil ||
+		fmt.Sprint(res) != "[key004000 key004001 key004002]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := scanKeys(s, []byte("key00999"), kv.PrefixEnd([]byte("key00999"))); err != nil ||
+		len(res) != 10 || res[0] != "key009990" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	count := 0
+	s.Scan(nil, nil, func(key []byte, value []byte) bool {
+		count++
+		return count < 5
+	})
+
+	if count != 5 {
+		t.Error("Unexpected count:", count)
+		return
+	}
+
+	// Update and delete keys in one batch
+
+	b = kv.NewBatch()
+	b.Put(testKey(5), []byte("newval"))
+	for i := 100; i < 9000; i++ {
+		b.Delete(testKey(i))
+	}
+	b.Delete([]byte("unknown"))
+	b.Delete([]byte("empty"))
+	b.Put(testKey(200), []byte("back"))
+
+	if err := s.Write(b); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := s.Get(testKey(5)); string(res) != "newval" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	if res, err := s.Get(testKey(4711)); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, _ := scanKeys(s, nil, nil); len(res) != 1101 || res[100] != "key000200" {
+		t.Error("Unexpected result:", len(res))
+		return
+	}
+
+	// Data is persisted
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := s.Close(); err != kv.ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := s.Get(testKey(1)); err != kv.ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := s.Scan(nil, nil, nil); err != kv.ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := s.Write(b); err != kv.ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := s.Compact(); err != kv.ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	s, err = OpenStore(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := scanKeys(s, nil, nil); len(res) != 1101 {
+		t.Error("Unexpected result:", len(res))
+		return
+	}
+
+	// Compaction keeps all data
+
+	if err := s.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := s.Get(testKey(5)); string(res) != "newval" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	if res, _ := scanKeys(s, nil, nil); len(res) != 1101 {
+		t.Error("Unexpected result:", len(res))
+		return
+	}
+
+	// Deleting all keys results in an empty tree
+
+	b = kv.NewBatch()
+	s.Scan(nil, nil, func(key []byte, value []byte) bool {
+		b.Delete(key)
+		return true
+	})
+	b.Put([]byte("x"), []byte("y"))
+	b.Delete([]byte("x"))
+
+	if err := s.Write(b); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := scanKeys(s, nil, nil); len(res) != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	s.Close()
+
+	if _, err := OpenStore(DBDIR + "/nonexisting/store"); err == nil {
+		t.Error("Opening a store in a non-existing directory should fail")
+		return
+	}
+}
+
+func TestStoreBigValues(t *testing.T) {
+	s, err := OpenStore(DBDIR + "/store2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	b := kv.NewBatch()
+	for i := 0; i < 20; i++ {
+		b.Put(testKey(i), []byte(strings.Repeat(fmt.Sprint(i%10), 10000)))
+	}
+	b.Put(testKey(20), []byte(strings.Repeat("x", 100000)))
+
+	if err := s.Write(b); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 20; i++ {
+		if res, _ := s.Get(testKey(i)); len(res) != 10000 || res[0] != byte('0'+i%10) {
+			t.Error("Unexpected result:", i, len(res))
+			return
+		}
+	}
+
+	if res, _ := s.Get(testKey(20)); len(res) != 100000 {
+		t.Error("Unexpected result:", len(res))
+		return
+	}
+}
+
+func TestStoreRollback(t *testing.T) {
+	name := DBDIR + "/store3"
+
+	s, err := OpenStore(name)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	b := kv.NewBatch()
+	b.Put([]byte("a"), []byte("1"))
+	s.Write(b)
+
+	// Changes of a batch which could not be written are discarded
+
+	for i := 0; i < 200; i++ {
+		s.tree.Put(testKey(i), []byte("v"))
+	}
+	s.tree.Remove([]byte("a"))
+
+	testErr := errors.New("testerror")
+
+	if err := s.rollback(testErr); err != testErr {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res, _ := scanKeys(s, nil, nil); fmt.Sprint(res) != "[a]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	b = kv.NewBatch()
+	b.Put([]byte("b"), []byte("2"))
+
+	if err := s.Write(b); err != nil {
+		t.Error(err)
+		return
+	}
+
+	s.Close()
+
+	if s, err = OpenStore(name); err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	if res, _ := scanKeys(s, nil, nil); fmt.Sprint(res) != "[a b]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestStoreConcurrent(t *testing.T) {
+	s, err := OpenStore(DBDIR + "/store4")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer s.Close()
+
+	done := make(chan error)
+
+	go func() {
+		for i := 0; i < 50; i++ {
+			b := kv.NewBatch()
+			for j := 0; j < 20; j++ {
+				b.Put(testKey(i*20+j), []byte("v"))
+			}
+			if err := s.Write(b); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// Readers always see complete batches
+
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			running = false
+		default:
+			if res, err := scanKeys(s, nil, nil); err != nil || len(res)%20 != 0 {
+				t.Error("Unexpected result:", len(res), err)
+				return
+			}
+		}
+	}
+
+	if res, _ := scanKeys(s, nil, nil); len(res) != 1000 {
+		t.Error("Unexpected result:", len(res))
+		return
+	}
+}
@@ -44,6 +44,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -51,12 +52,17 @@ import (
 
 	"github.com/krotik/common/errorutil"
 	"github.com/krotik/common/fileutil"
+	"github.com/krotik/common/lockutil"
 	"github.com/krotik/common/termutil"
 	"github.com/krotik/eliasdb/api"
+	"github.com/krotik/eliasdb/btree"
 	"github.com/krotik/eliasdb/config"
 	"github.com/krotik/eliasdb/console"
 	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/graphstorage"
 	"github.com/krotik/eliasdb/server"
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 func main() {
@@ -77,7 +83,11 @@ func main() {
 		fmt.Println()
 		fmt.Println("Available commands:")
 		fmt.Println()
+		fmt.Println("    check     Check the consistency of the datastore (offline)")
+		fmt.Println("    compact   Release unused space in the datastore (offline)")
 		fmt.Println("    console   EliasDB server console")
+		fmt.Println("    rekey     Change the encryption key of the datastore (offline)")
+		fmt.Println("    restore   Restore the datastore from a snapshot (offline)")
 		fmt.Println("    server    Start EliasDB server")
 		fmt.Println()
 		fmt.Println(fmt.Sprintf("Use %s <command> -help for more information about a given command.", os.Args[0]))
@@ -98,6 +108,18 @@ func main() {
 		} else if arg == "console" {
 			config.LoadConfigFile(config.DefaultConfigFile)
 			RunCliConsole()
+		} else if arg == "check" {
+			config.LoadConfigFile(config.DefaultConfigFile)
+			RunCheck()
+		} else if arg == "compact" {
+			config.LoadConfigFile(config.DefaultConfigFile)
+			RunCompact()
+		} else if arg == "rekey" {
+			config.LoadConfigFile(config.DefaultConfigFile)
+			RunRekey()
+		} else if arg == "restore" {
+			config.LoadConfigFile(config.DefaultConfigFile)
+			RunRestore()
 		} else {
 			flag.Usage()
 		}
@@ -266,6 +288,367 @@ func RunCliConsole() {
 	}
 }
 
+/*
+RunRekey rewrites all datastore files with a new encryption key. The server
+must not be running while the datastore is rewritten. An interrupted rekey is
+resumed by running it again with the same keys.
+*/
+func RunRekey() {
+	var err error
+	var oldKey, newKey []byte
+
+	oldKeyStr := flag.String("old-key", config.StorageKey(), "Current hex encoded encryption key (empty for no encryption)")
+	newKeyStr := flag.String("new-key", "", "New hex encoded encryption key (empty for no encryption)")
+
+	showHelp := flag.Bool("help", false, "Show this help message")
+
+	flag.Usage = func() {
+		fmt.Println()
+		fmt.Println(fmt.Sprintf("Usage of %s rekey [options]", os.Args[0]))
+		fmt.Println()
+		flag.PrintDefaults()
+		fmt.Println()
+	}
+
+	flag.CommandLine.Parse(os.Args[2:])
+
+	if *showHelp {
+		flag.Usage()
+		return
+	}
+
+	if err = checkDiskBackend("Encryption"); err == nil {
+		if oldKey, err = file.ParseEncryptionKey(*oldKeyStr); err == nil {
+			if newKey, err = file.ParseEncryptionKey(*newKeyStr); err == nil {
+				var lf *lockutil.LockFile
+
+				if lf, err = lockDatastore(); err == nil {
+					loc := config.Str(config.LocationDatastore)
+
+					fmt.Println("Rewriting datastore in:", loc)
+
+					if err = graphstorage.RekeyDiskGraphStorage(loc, oldKey, newKey); err == nil {
+						fmt.Println("Done - update the encryption key in the config or environment")
+					} else {
+						fmt.Println("Rekey failed - run it again with the same keys to resume it")
+					}
+
+					lf.Finish()
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+/*
+RunCheck checks the consistency of all datastore files and optionally repairs
+them. The server must not be running while the datastore is checked.
+*/
+func RunCheck() {
+	var err error
+	var key []byte
+	var gs graphstorage.Storage
+
+	repair := flag.Bool("repair", false, "Try to repair found problems")
+
+	showHelp := flag.Bool("help", false, "Show this help message")
+
+	flag.Usage = func() {
+		fmt.Println()
+		fmt.Println(fmt.Sprintf("Usage of %s check [options]", os.Args[0]))
+		fmt.Println()
+		flag.PrintDefaults()
+		fmt.Println()
+	}
+
+	flag.CommandLine.Parse(os.Args[2:])
+
+	if *showHelp {
+		flag.Usage()
+		return
+	}
+
+	// The btree storage backend has no consistency check
+
+	if err = checkDiskBackend("The consistency check"); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	loc := config.Str(config.LocationDatastore)
+
+	if ok, _ := fileutil.PathExists(loc); !ok {
+		err = fmt.Errorf("Datastore does not exist: %v", loc)
+
+	} else if key, err = file.ParseEncryptionKey(config.StorageKey()); err == nil {
+
+		fmt.Println("Checking datastore in:", loc)
+
+		gs, err = graphstorage.NewDiskGraphStorageWithOptions(loc, !*repair,
+			storage.DiskStorageManagerOptions{EncryptionKey: key})
+
+		if err == nil {
+			var results []*storage.CheckResult
+
+			results, err = graph.NewGraphManager(gs).Check(*repair)
+
+			problems := 0
+
+			for _, res := range results {
+				for _, p := range res.Problems {
+					fmt.Println(fmt.Sprintf("%v: %v", res.Name, p))
+				}
+
+				if res.Repaired {
+					fmt.Println(fmt.Sprintf("%v: Repaired", res.Name))
+				}
+
+				problems += len(res.Problems)
+			}
+
+			fmt.Println(fmt.Sprintf("Checked %v datastores - found %v problems",
+				len(results), problems))
+
+			if cerr := gs.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+/*
+RunCompact rewrites all datastore files without unused space. The server must
+not be running while the datastore is compacted.
+*/
+func RunCompact() {
+	var err error
+	var key []byte
+
+	showHelp := flag.Bool("help", false, "Show this help message")
+
+	flag.Usage = func() {
+		fmt.Println()
+		fmt.Println(fmt.Sprintf("Usage of %s compact [options]", os.Args[0]))
+		fmt.Println()
+		flag.PrintDefaults()
+		fmt.Println()
+	}
+
+	flag.CommandLine.Parse(os.Args[2:])
+
+	if *showHelp {
+		flag.Usage()
+		return
+	}
+
+	loc := config.Str(config.LocationDatastore)
+
+	if ok, _ := fileutil.PathExists(loc); !ok {
+		err = fmt.Errorf("Datastore does not exist: %v", loc)
+
+	} else if key, err = file.ParseEncryptionKey(config.StorageKey()); err == nil {
+		oldSize := datastoreSize(loc)
+
+		fmt.Println("Compacting datastore in:", loc)
+
+		if config.Str(config.StorageBackend) == "btree" {
+			err = compactBTreeStore(filepath.Join(loc, graphstorage.FilenameKVStore))
+
+		} else if err = checkDiskBackend("Compaction"); err == nil {
+			err = graphstorage.CompactDiskGraphStorage(loc,
+				storage.DiskStorageManagerOptions{EncryptionKey: key})
+		}
+
+		if err == nil {
+			fmt.Println(fmt.Sprintf("Done - datastore size reduced from %v to %v bytes",
+				oldSize, datastoreSize(loc)))
+		}
+	}
+
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+/*
+compactBTreeStore releases unused space at the end of the page file of the
+btree storage backend. The page file has no lockfile of its own so the
+lockfile of the server is taken while the file is compacted.
+*/
+func compactBTreeStore(name string) error {
+	if ok, _ := fileutil.PathExists(name + "." + btree.FileSuffixPages); !ok {
+		return fmt.Errorf("Datastore does not exist: %v", name)
+	}
+
+	lf, err := lockDatastore()
+	if err != nil {
+		return err
+	}
+	defer lf.Finish()
+
+	store, err := btree.OpenStore(name)
+	if err != nil {
+		return err
+	}
+
+	if err = store.Compact(); err != nil {
+		store.Close()
+		return err
+	}
+
+	return store.Close()
+}
+
+/*
+RunRestore replaces the datastore with a snapshot. The snapshot is verified
+before the datastore is replaced. Archived transactions can be replayed on top
+of the snapshot to restore the datastore at a given point in time. The server
+must not be running while the datastore is restored.
+*/
+func RunRestore() {
+	var err error
+	var key []byte
+	var snapshotTime int64
+
+	snapshot := flag.String("snapshot", "", "Name of a snapshot in the snapshot directory or path to a snapshot")
+	replay := flag.Bool("replay", false, "Replay archived transactions after restoring the snapshot")
+	until := flag.String("until", "", "Only replay transactions up to this time (RFC3339 format, default: all)")
+
+	showHelp := flag.Bool("help", false, "Show this help message")
+
+	flag.Usage = func() {
+		fmt.Println()
+		fmt.Println(fmt.Sprintf("Usage of %s restore [options]", os.Args[0]))
+		fmt.Println()
+		flag.PrintDefaults()
+		fmt.Println()
+	}
+
+	flag.CommandLine.Parse(os.Args[2:])
+
+	if *showHelp || *snapshot == "" {
+		flag.Usage()
+		return
+	}
+
+	dir := *snapshot
+
+	if ok, _ := fileutil.PathExists(dir); !ok {
+		dir = filepath.Join(config.Str(config.LocationSnapshots), *snapshot)
+	}
+
+	loc := config.Str(config.LocationDatastore)
+	archive := config.Str(config.LocationLogArchive)
+	untilTime := int64(math.MaxInt64)
+
+	if *replay && archive == "" {
+		err = fmt.Errorf("No transaction log archive configured (%v)", config.LocationLogArchive)
+
+	} else if *replay && *until != "" {
+		var t time.Time
+
+		if t, err = time.Parse(time.RFC3339Nano, *until); err == nil {
+			untilTime = t.UnixNano()
+		}
+	}
+
+	if err == nil {
+		key, err = file.ParseEncryptionKey(config.StorageKey())
+	}
+
+	if err == nil {
+		fmt.Println("Verifying snapshot:", dir)
+
+		err = graphstorage.VerifySnapshot(dir)
+	}
+
+	// Only transactions which were committed after the snapshot was taken
+	// are replayed
+
+	if err == nil && *replay {
+		if snapshotTime, err = graphstorage.SnapshotTime(dir); err == nil && untilTime < snapshotTime {
+			err = fmt.Errorf("Cannot replay until %v - the snapshot was taken at %v", *until,
+				time.Unix(0, snapshotTime).Format(time.RFC3339Nano))
+		}
+	}
+
+	if err == nil {
+		fmt.Println("Restoring datastore in:", loc)
+
+		err = graphstorage.RestoreDiskGraphStorage(dir, loc)
+	}
+
+	if err == nil && *replay {
+		var count int
+
+		fmt.Println("Replaying archived transactions from:", archive)
+
+		if count, err = graphstorage.ReplayDiskGraphStorage(loc, key, archive,
+			snapshotTime, untilTime); err == nil {
+			fmt.Println(fmt.Sprintf("Replayed %v transactions", count))
+		}
+	}
+
+	if err == nil {
+		fmt.Println("Done")
+	} else {
+		fmt.Println(err.Error())
+	}
+}
+
+/*
+checkDiskBackend returns an error if the datastore does not use the disk
+storage backend which is required by a given feature.
+*/
+func checkDiskBackend(feature string) error {
+	if backend := config.Str(config.StorageBackend); backend == "btree" {
+		return fmt.Errorf("%v is not supported by the btree storage backend", feature)
+	} else if backend != "disk" {
+		return fmt.Errorf("Unknown storage backend: %v", backend)
+	}
+
+	return nil
+}
+
+/*
+lockDatastore takes the lockfile of the server. The lockfile cannot be taken
+while the server is running.
+*/
+func lockDatastore() (*lockutil.LockFile, error) {
+	lf := lockutil.NewLockFile(config.Str(config.LockFile), time.Duration(2)*time.Second)
+
+	if err := lf.Start(); err != nil {
+		return nil, fmt.Errorf("Could not take lockfile %v - the server may be running: %v",
+			config.Str(config.LockFile), err)
+	}
+
+	return lf, nil
+}
+
+/*
+datastoreSize returns the size of all files in the datastore directory.
+*/
+func datastoreSize(loc string) int64 {
+	var size int64
+
+	filepath.Walk(loc, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
+
 /*
 getHostPortFromConfig gets the host and port from the config file or the
 default config.
@@ -294,6 +677,7 @@ func handleServerCommandLine(gm *graph.Manager) bool {
 	var ecalConsole *bool
 
 	importDb := flag.String("import", "", "Import a database from a zip file")
+	bulkImport := flag.Bool("bulk-import", false, "Use the bulk loader for nodes when importing (partitions must be empty)")
 	exportDb := flag.String("export", "", "Export the current database to a zip file")
 
 	if config.Bool(config.EnableECALScripts) {
@@ -335,7 +719,11 @@ func handleServerCommandLine(gm *graph.Manager) bool {
 					fmt.Println(fmt.Sprintf("Importing %s to partition %s", file.Name, part))
 
 					if in, err = file.Open(); err == nil {
-						err = graph.ImportPartition(in, part, gm)
+						if *bulkImport {
+							err = graph.BulkImportPartition(in, part, gm)
+						} else {
+							err = graph.ImportPartition(in, part, gm)
+						}
 					}
 
 					if err != nil {
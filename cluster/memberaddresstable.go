@@ -29,6 +29,11 @@ func init() {
 
 	gob.Register(&translationRec{})
 	gob.Register(&transferRec{})
+
+	// Make sure we can use the relevant types with the binary codec
+
+	storage.RegisterCodecType(&translationRec{})
+	storage.RegisterCodecType(&transferRec{})
 }
 
 /*
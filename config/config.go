@@ -12,6 +12,7 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"path"
 	"strconv"
 
@@ -42,6 +43,8 @@ const (
 	LocationWebFolder        = "LocationWebFolder"
 	LocationUserDB           = "LocationUserDB"
 	LocationAccessDB         = "LocationAccessDB"
+	LocationSnapshots        = "LocationSnapshots"
+	LocationLogArchive       = "LocationLogArchive"
 	HTTPSCertificate         = "HTTPSCertificate"
 	HTTPSKey                 = "HTTPSKey"
 	LockFile                 = "LockFile"
@@ -68,8 +71,29 @@ const (
 	ECALLogFile              = "ECALLogFile"
 	ECALDebugServerHost      = "ECALDebugServerHost"
 	ECALDebugServerPort      = "ECALDebugServerPort"
+	StorageCompression       = "StorageCompression"
+	StorageEncryptionKey     = "StorageEncryptionKey"
+	StorageChecksums         = "StorageChecksums"
+	StorageMemoryMapped      = "StorageMemoryMapped"
+	StorageCacheMaxBytes     = "StorageCacheMaxBytes"
+	StorageCodec             = "StorageCodec"
+	StorageSyncMode          = "StorageSyncMode"
+	StorageSyncWindowMs      = "StorageSyncWindowMs"
+	StorageSyncWindowBytes   = "StorageSyncWindowBytes"
+	StorageBackend           = "StorageBackend"
+	EnableReplica            = "EnableReplica"
+	ReplicaRefreshMs         = "ReplicaRefreshMs"
+	EnableChangeLog          = "EnableChangeLog"
+	ChangeLogMaxEntries      = "ChangeLogMaxEntries"
 )
 
+/*
+EnvStorageEncryptionKey is an environment variable which overrides the
+StorageEncryptionKey config value. This allows a key to be supplied without
+writing it to the config file.
+*/
+const EnvStorageEncryptionKey = "ELIASDB_STORAGE_ENCRYPTION_KEY"
+
 /*
 DefaultConfig is the defaut configuration
 */
@@ -88,6 +112,8 @@ var DefaultConfig = map[string]interface{}{
 	LocationWebFolder:        "web",
 	LocationUserDB:           "users.db",
 	LocationAccessDB:         "access.db",
+	LocationSnapshots:        "snapshots",
+	LocationLogArchive:       "",
 	HTTPSHost:                "127.0.0.1",
 	HTTPSPort:                "9090",
 	CookieMaxAgeSeconds:      "86400",
@@ -106,6 +132,20 @@ var DefaultConfig = map[string]interface{}{
 	ECALLogFile:              "",
 	ECALDebugServerHost:      "127.0.0.1",
 	ECALDebugServerPort:      "33274",
+	StorageCompression:       "none",
+	StorageEncryptionKey:     "",
+	StorageChecksums:         false,
+	StorageMemoryMapped:      false,
+	StorageCacheMaxBytes:     0,
+	StorageCodec:             "gob",
+	StorageSyncMode:          "always",
+	StorageSyncWindowMs:      10,
+	StorageSyncWindowBytes:   0,
+	StorageBackend:           "disk",
+	EnableReplica:            false,
+	ReplicaRefreshMs:         1000,
+	EnableChangeLog:          false,
+	ChangeLogMaxEntries:      100000,
 }
 
 /*
@@ -171,6 +211,17 @@ func Bool(key string) bool {
 	return ret
 }
 
+/*
+StorageKey returns the storage encryption key either from the environment
+or from the config.
+*/
+func StorageKey() string {
+	if key := os.Getenv(EnvStorageEncryptionKey); key != "" {
+		return key
+	}
+	return Str(StorageEncryptionKey)
+}
+
 /*
 WebPath returns a path relative to the web directory.
 */
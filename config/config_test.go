@@ -59,6 +59,21 @@ func TestConfig(t *testing.T) {
 		return
 	}
 
+	Config[StorageEncryptionKey] = "abc"
+
+	if res := StorageKey(); res != "abc" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	os.Setenv(EnvStorageEncryptionKey, "def")
+	defer os.Unsetenv(EnvStorageEncryptionKey)
+
+	if res := StorageKey(); res != "def" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
 	if res := WebPath("123", "456"); res != "web/123/456" {
 		t.Error("Unexpected result:", res)
 		return
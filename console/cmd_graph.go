@@ -11,6 +11,7 @@
 package console
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"sort"
@@ -92,6 +93,214 @@ func (c *CmdInfo) Run(args []string, capi CommandConsoleAPI) error {
 	return err
 }
 
+// Command: storage
+// ================
+
+/*
+CommandStorage is a command name.
+*/
+const CommandStorage = "storage"
+
+/*
+CmdStorage returns storage statistics of the datastore.
+*/
+type CmdStorage struct {
+}
+
+/*
+Name returns the command name (as it should be typed)
+*/
+func (c *CmdStorage) Name() string {
+	return CommandStorage
+}
+
+/*
+ShortDescription returns a short description of the command (single line)
+*/
+func (c *CmdStorage) ShortDescription() string {
+	return "Returns storage statistics of the datastore."
+}
+
+/*
+LongDescription returns an extensive description of the command (can be multiple lines)
+*/
+func (c *CmdStorage) LongDescription() string {
+	return "Returns storage statistics for each partition and kind such as the number of records, used and free bytes, file sizes and the fragmentation of the datastore files. Sizes include the index of each kind."
+}
+
+/*
+Run executes the command.
+*/
+func (c *CmdStorage) Run(args []string, capi CommandConsoleAPI) error {
+
+	res, err := capi.Req(v1.EndpointInfoQuery+"storage", "GET", nil)
+
+	if err == nil {
+		var data = res.(map[string]interface{})
+		var tab []string
+		var totalRecords, totalData, totalFree, totalFile float64
+
+		// Returns the sum of a statistics value over the data and the index
+
+		sum := func(ds map[string]interface{}, key string) float64 {
+			var ret float64
+			for _, s := range []string{"data", "index"} {
+				if stats, ok := ds[s].(map[string]interface{}); ok {
+					ret += stats[key].(float64)
+				}
+			}
+			return ret
+		}
+
+		fragmentation := func(free float64, data float64) string {
+			if free+data == 0 {
+				return fmt.Sprintf("%6.1f%%", 0.0)
+			}
+			return fmt.Sprintf("%6.1f%%", free/(free+data)*100)
+		}
+
+		tab = append(tab, "Partition", "Kind", "Type", "Records", "Data bytes",
+			"Free bytes", "File bytes", "Fragmentation")
+
+		for _, d := range data["datastores"].([]interface{}) {
+			ds := d.(map[string]interface{})
+
+			var records float64
+			if stats, ok := ds["data"].(map[string]interface{}); ok {
+				records = stats["records"].(float64)
+			}
+
+			allocated, free, file := sum(ds, "allocated_bytes"), sum(ds, "free_bytes"), sum(ds, "file_bytes")
+
+			tab = append(tab, fmt.Sprint(ds["partition"]), fmt.Sprint(ds["kind"]), fmt.Sprint(ds["type"]),
+				fmt.Sprintf("%10v", records), fmt.Sprintf("%12v", allocated),
+				fmt.Sprintf("%12v", free), fmt.Sprintf("%12v", file), fragmentation(free, allocated))
+
+			totalRecords += records
+			totalData += allocated
+			totalFree += free
+			totalFile += file
+		}
+
+		tab = append(tab, "Total", "", "", fmt.Sprintf("%10v", totalRecords), fmt.Sprintf("%12v", totalData),
+			fmt.Sprintf("%12v", totalFree), fmt.Sprintf("%12v", totalFile), fragmentation(totalFree, totalData))
+
+		capi.ExportBuffer().WriteString(stringutil.PrintCSVTable(tab, 8))
+
+		fmt.Fprint(capi.Out(), stringutil.PrintGraphicStringTable(tab, 8, 1,
+			stringutil.SingleLineTable))
+	}
+
+	return err
+}
+
+// Command: schema
+// ===============
+
+/*
+CommandSchema is a command name.
+*/
+const CommandSchema = "schema"
+
+/*
+CmdSchema displays, sets or removes schemas of node and edge kinds.
+*/
+type CmdSchema struct {
+}
+
+/*
+Name returns the command name (as it should be typed)
+*/
+func (c *CmdSchema) Name() string {
+	return CommandSchema
+}
+
+/*
+ShortDescription returns a short description of the command (single line)
+*/
+func (c *CmdSchema) ShortDescription() string {
+	return "Displays, sets or removes schemas of kinds."
+}
+
+/*
+LongDescription returns an extensive description of the command (can be multiple lines)
+*/
+func (c *CmdSchema) LongDescription() string {
+	return "Displays all schemas if no kind is given. Displays the schema of a kind if only a kind is given. Sets the schema of a kind if a kind and a JSON object are given. Removes the schema of a kind if a kind and the keyword remove are given."
+}
+
+/*
+Run executes the command.
+*/
+func (c *CmdSchema) Run(args []string, capi CommandConsoleAPI) error {
+
+	if len(args) == 0 {
+
+		res, err := capi.Req(v1.EndpointSchema, "GET", nil)
+
+		if err == nil {
+			var tab []string
+
+			tab = append(tab, "Kind", "Attributes", "Edges", "Strict")
+
+			for _, s := range res.([]interface{}) {
+				schema := s.(map[string]interface{})
+
+				attrs, _ := schema["attrs"].(map[string]interface{})
+				edges, _ := schema["edges"].(map[string]interface{})
+
+				tab = append(tab, fmt.Sprint(schema["kind"]),
+					strings.Join(stringutil.MapKeys(attrs), ", "),
+					strings.Join(stringutil.MapKeys(edges), ", "),
+					fmt.Sprint(schema["strict"]))
+			}
+
+			capi.ExportBuffer().WriteString(stringutil.PrintCSVTable(tab, 4))
+
+			fmt.Fprint(capi.Out(), stringutil.PrintGraphicStringTable(tab, 4, 1,
+				stringutil.SingleLineTable))
+		}
+
+		return err
+	}
+
+	kind := args[0]
+
+	if len(args) == 1 {
+
+		res, err := capi.Req(v1.EndpointSchema+kind, "GET", nil)
+
+		if err == nil {
+			var out []byte
+
+			if out, err = json.MarshalIndent(res, "", "  "); err == nil {
+				fmt.Fprintln(capi.Out(), string(out))
+			}
+		}
+
+		return err
+	}
+
+	if len(args) == 2 && args[1] == "remove" {
+
+		_, err := capi.Req(v1.EndpointSchema+kind, "DELETE", nil)
+
+		if err == nil {
+			fmt.Fprintln(capi.Out(), fmt.Sprintf("Removed schema of kind: %v", kind))
+		}
+
+		return err
+	}
+
+	_, err := capi.Req(v1.EndpointSchema+kind, "PUT", []byte(strings.Join(args[1:], " ")))
+
+	if err == nil {
+		fmt.Fprintln(capi.Out(), fmt.Sprintf("Set schema of kind: %v", kind))
+	}
+
+	return err
+}
+
 // Command: part
 // =============
 
@@ -236,3 +445,57 @@ func (c *CmdFind) Run(args []string, capi CommandConsoleAPI) error {
 
 	return nil
 }
+
+// Command: snapshot
+// =================
+
+/*
+CommandSnapshot is a command name.
+*/
+const CommandSnapshot = "snapshot"
+
+/*
+CmdSnapshot creates a snapshot of the datastore.
+*/
+type CmdSnapshot struct {
+}
+
+/*
+Name returns the command name (as it should be typed)
+*/
+func (c *CmdSnapshot) Name() string {
+	return CommandSnapshot
+}
+
+/*
+ShortDescription returns a short description of the command (single line)
+*/
+func (c *CmdSnapshot) ShortDescription() string {
+	return "Creates a snapshot of the datastore."
+}
+
+/*
+LongDescription returns an extensive description of the command (can be multiple lines)
+*/
+func (c *CmdSnapshot) LongDescription() string {
+	return "Creates a snapshot of the datastore on the server. An optional snapshot name can be given. Write operations are blocked while the snapshot is taken."
+}
+
+/*
+Run executes the command.
+*/
+func (c *CmdSnapshot) Run(args []string, capi CommandConsoleAPI) error {
+
+	if len(args) > 1 {
+		return fmt.Errorf("Please specify only a snapshot name")
+	}
+
+	res, err := capi.Req(v1.EndpointSnapshot+strings.Join(args, ""), "POST", nil)
+
+	if err == nil {
+		fmt.Fprintln(capi.Out(), fmt.Sprintf("Created snapshot: %v",
+			res.(map[string]interface{})["name"]))
+	}
+
+	return err
+}
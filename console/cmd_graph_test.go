@@ -12,9 +12,12 @@ package console
 
 import (
 	"bytes"
+	"strconv"
 	"testing"
 
+	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/config"
+	"github.com/krotik/eliasdb/graph/data"
 )
 
 func TestGraphCommands(t *testing.T) {
@@ -86,6 +89,96 @@ Login as user elias
 
 	out.Reset()
 
+	if ok, err := c.Run(`schema Label {"attrs": {"name": {"type": "string", "required": true}}, "strict": true}`); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if ok, err := c.Run(`schema Studio {"edges": {"owner:Owns:owned:Song": {"max": 2}}}`); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+Set schema of kind: Label
+Set schema of kind: Studio
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	out.Reset()
+
+	if ok, err := c.Run("schema"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+┌───────┬───────────┬──────────────────────┬───────┐
+│Kind   │Attributes │Edges                 │Strict │
+├───────┼───────────┼──────────────────────┼───────┤
+│Label  │name       │                      │true   │
+│Studio │           │owner:Owns:owned:Song │false  │
+└───────┴───────────┴──────────────────────┴───────┘
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	out.Reset()
+
+	if ok, err := c.Run("schema Label"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+{
+  "attrs": {
+    "name": {
+      "required": true,
+      "type": "string"
+    }
+  },
+  "kind": "Label",
+  "strict": true
+}
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	out.Reset()
+
+	if ok, err := c.Run("schema Label remove"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	if res := out.String(); res != `
+Removed schema of kind: Label
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if ok, err := c.Run("schema Label"); ok || err == nil || err.Error() !=
+		"GET request to /db/v1/schema/Label failed: Unknown schema for kind Label" {
+		t.Error(ok, err)
+		return
+	}
+
+	if ok, err := c.Run("schema Label {"); ok || err == nil || err.Error() !=
+		"PUT request to /db/v1/schema/Label failed: Could not decode request body as schema: unexpected EOF" {
+		t.Error(ok, err)
+		return
+	}
+
+	c.Run("schema Studio remove")
+
+	out.Reset()
+
 	if ok, err := c.Run("part"); !ok || err != nil {
 		t.Error(ok, err)
 		return
@@ -199,4 +292,87 @@ Kind      Producer
 
 	out.Reset()
 
+	if ok, err := c.Run("snapshot a b"); ok || err == nil || err.Error() != "Please specify only a snapshot name" {
+		t.Error(ok, err)
+		return
+	}
+
+	// The test datastore is memory only
+
+	if ok, err := c.Run("snapshot"); ok || err == nil || err.Error() !=
+		"POST request to /db/v1/snapshot/ failed: GraphError: Failed to access graph storage component (Graph storage does not support snapshots)" {
+		t.Error(ok, err)
+		return
+	}
+}
+
+func TestStorageCommand(t *testing.T) {
+	var out bytes.Buffer
+
+	ResetDB()
+
+	// Use a small graph - the number of records of larger graphs depends on
+	// the order in which the attributes of nodes are written
+
+	for i := 0; i < 3; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", strconv.Itoa(i))
+		node.SetAttr("kind", "Song")
+		api.GM.StoreNode("main", node)
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "Producer")
+	api.GM.StoreNode("second", node)
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "abc")
+	edge.SetAttr("kind", "Wrote")
+	edge.SetAttr(data.EdgeEnd1Key, "0")
+	edge.SetAttr(data.EdgeEnd1Kind, "Song")
+	edge.SetAttr(data.EdgeEnd1Role, "Author")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "1")
+	edge.SetAttr(data.EdgeEnd2Kind, "Song")
+	edge.SetAttr(data.EdgeEnd2Role, "Song")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+	api.GM.StoreEdge("main", edge)
+
+	config.Config[config.EnableAccessControl] = true
+	defer func() {
+		config.Config[config.EnableAccessControl] = false
+	}()
+
+	credGiver.Reset()
+	credGiver.UserQueue = []string{"elias"}
+	credGiver.PassQueue = []string{"elias"}
+
+	c := NewConsole("http://localhost"+TESTPORT, &out, credGiver.GetCredentials,
+		func() string { return "***pass***" },
+		func(args []string, e *bytes.Buffer) error {
+			return nil
+		})
+
+	if ok, err := c.Run("storage"); !ok || err != nil {
+		t.Error(ok, err)
+		return
+	}
+
+	// The test datastore is memory only and reports only the number of records
+
+	if res := out.String(); res != `
+Login as user elias
+┌──────────┬─────────┬─────┬───────────┬─────────────┬─────────────┬─────────────┬──────────────┐
+│Partition │Kind     │Type │Records    │Data bytes   │Free bytes   │File bytes   │Fragmentation │
+├──────────┼─────────┼─────┼───────────┼─────────────┼─────────────┼─────────────┼──────────────┤
+│main      │Song     │node │         6 │           0 │           0 │           0 │   0.0%       │
+│main      │Wrote    │edge │        10 │           0 │           0 │           0 │   0.0%       │
+│second    │Producer │node │         3 │           0 │           0 │           0 │   0.0%       │
+│Total     │         │     │        19 │           0 │           0 │           0 │   0.0%       │
+└──────────┴─────────┴─────┴───────────┴─────────────┴─────────────┴─────────────┴──────────────┘
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
 }
@@ -63,8 +63,11 @@ func NewConsole(url string, out io.Writer, getCredentials func() (string, string
 	}
 
 	cmdMap[CommandInfo] = &CmdInfo{}
+	cmdMap[CommandStorage] = &CmdStorage{}
+	cmdMap[CommandSchema] = &CmdSchema{}
 	cmdMap[CommandPart] = &CmdPart{}
 	cmdMap[CommandFind] = &CmdFind{}
+	cmdMap[CommandSnapshot] = &CmdSnapshot{}
 
 	// Add export if we got an export function
 
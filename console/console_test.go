@@ -341,6 +341,9 @@ Log out the current user.
 Changes the password of a user.
 Displays or sets the current partition.
 Revokes permissions to a resource for a group.
+Displays all schemas if no kind is given. Displays the schema of a kind if only a kind is given. Sets the schema of a kind if a kind and a JSON object are given. Removes the schema of a kind if a kind and the keyword remove are given.
+Creates a snapshot of the datastore on the server. An optional snapshot name can be given. Write operations are blocked while the snapshot is taken.
+Returns storage statistics for each partition and kind such as the number of records, used and free bytes, file sizes and the fragmentation of the datastore files. Sizes include the index of each kind.
 Adds a user to the system.
 Removes a user from the system.
 Returns a table of all users and their groups.
@@ -467,13 +470,16 @@ Writer, 1
 	}
 
 	if res := out.String(); res != `
-Command Description
-export  Exports the last output.
-find    Do a full-text search of the database.
-help    Display descriptions for all available commands.
-info    Returns general database information.
-part    Displays or sets the current partition.
-ver     Displays server version information.
+Command  Description
+export   Exports the last output.
+find     Do a full-text search of the database.
+help     Display descriptions for all available commands.
+info     Returns general database information.
+part     Displays or sets the current partition.
+schema   Displays, sets or removes schemas of kinds.
+snapshot Creates a snapshot of the datastore.
+storage  Returns storage statistics of the datastore.
+ver      Displays server version information.
 `[1:] {
 		t.Error("Unexpected result:", res)
 		return
@@ -487,13 +493,16 @@ ver     Displays server version information.
 	}
 
 	if res := out.String(); res != `
-Command Description
-export  Exports the last output.
-find    Do a full-text search of the database.
-help    Display descriptions for all available commands.
-info    Returns general database information.
-part    Displays or sets the current partition.
-ver     Displays server version information.
+Command  Description
+export   Exports the last output.
+find     Do a full-text search of the database.
+help     Display descriptions for all available commands.
+info     Returns general database information.
+part     Displays or sets the current partition.
+schema   Displays, sets or removes schemas of kinds.
+snapshot Creates a snapshot of the datastore.
+storage  Returns storage statistics of the datastore.
+ver      Displays server version information.
 `[1:] {
 		t.Error("Unexpected result:", res)
 		return
@@ -581,6 +590,9 @@ logout     Log out the current user.
 newpass    Changes the password of a user.
 part       Displays or sets the current partition.
 revokeperm Revokes permissions to a resource for a group.
+schema     Displays, sets or removes schemas of kinds.
+snapshot   Creates a snapshot of the datastore.
+storage    Returns storage statistics of the datastore.
 useradd    Adds a user to the system.
 userdel    Removes a user from the system.
 users      Returns a list of all users.
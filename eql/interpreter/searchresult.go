@@ -413,22 +413,11 @@ func (c SearchResultRowComparator) Less(i, j int) bool {
 	c1 := c.Data[i][c.Column]
 	c2 := c.Data[j][c.Column]
 
-	num1, err := strconv.ParseFloat(fmt.Sprint(c1), 64)
-	if err == nil {
-		num2, err := strconv.ParseFloat(fmt.Sprint(c2), 64)
-		if err == nil {
-			if c.Ascening {
-				return num1 < num2
-			}
-			return num1 > num2
-		}
-	}
-
 	if c.Ascening {
-		return fmt.Sprintf("%v", c1) < fmt.Sprintf("%v", c2)
+		return compare(c1, c2) < 0
 	}
 
-	return fmt.Sprintf("%v", c1) > fmt.Sprintf("%v", c2)
+	return compare(c1, c2) > 0
 }
 
 func (c SearchResultRowComparator) Swap(i, j int) {
@@ -259,6 +259,12 @@ func toBool(res interface{}) bool {
 
 func equals(res1 interface{}, res2 interface{}) bool {
 
+	// Compare typed values (e.g. timestamps or integers)
+
+	if res, ok := data.CompareValues(typedValue(res1, res2), typedValue(res2, res1)); ok {
+		return res == 0
+	}
+
 	// Try to convert the string into a number
 
 	num1, err := strconv.ParseFloat(fmt.Sprint(res1), 64)
@@ -272,6 +278,52 @@ func equals(res1 interface{}, res2 interface{}) bool {
 	return fmt.Sprintf("%v", res1) == fmt.Sprintf("%v", res2)
 }
 
+/*
+compare compares two values. Typed values are compared by their type (see
+data.CompareValues), values which can be parsed as numbers are compared
+numerically and all other values are compared by a simple string ordering.
+Returns -1, 0 or 1.
+*/
+func compare(res1 interface{}, res2 interface{}) int {
+
+	if res, ok := data.CompareValues(typedValue(res1, res2), typedValue(res2, res1)); ok {
+		return res
+	}
+
+	// Try to convert the values into numbers
+
+	num1, err := strconv.ParseFloat(fmt.Sprint(res1), 64)
+	if err == nil {
+		num2, err := strconv.ParseFloat(fmt.Sprint(res2), 64)
+		if err == nil {
+			if num1 < num2 {
+				return -1
+			} else if num1 > num2 {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	// Do a simple string ordering
+
+	return strings.Compare(fmt.Sprint(res1), fmt.Sprint(res2))
+}
+
+/*
+typedValue converts a string value (e.g. a constant in a query) into an
+integer if it is compared to an integer value. This allows comparisons of
+large integers without loss of precision.
+*/
+func typedValue(val interface{}, other interface{}) interface{} {
+	if s, ok := val.(string); ok && data.ValueType(other) == data.ValueTypeInt {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	}
+	return val
+}
+
 // Where runtime
 // =============
 
@@ -444,16 +496,7 @@ func lessThanRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parser.R
 CondEval evaluates this condition runtime element.
 */
 func (rt *lessThanRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 < res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) < fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return compare(res1, res2) < 0 })
 }
 
 /*
@@ -474,16 +517,7 @@ func lessThanEqualsRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) pa
 CondEval evaluates this condition runtime element.
 */
 func (rt *lessThanEqualsRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 <= res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) <= fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return compare(res1, res2) <= 0 })
 }
 
 /*
@@ -504,16 +538,7 @@ func greaterThanRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode) parse
 CondEval evaluates this condition runtime element.
 */
 func (rt *greaterThanRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 > res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) > fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return compare(res1, res2) > 0 })
 }
 
 /*
@@ -534,16 +559,7 @@ func greaterThanEqualsRuntimeInst(rtp *eqlRuntimeProvider, node *parser.ASTNode)
 CondEval evaluates this condition runtime element.
 */
 func (rt *greaterThanEqualsRuntime) CondEval(node data.Node, edge data.Edge) (interface{}, error) {
-	ret, err := rt.numOp(node, edge, func(res1 float64, res2 float64) interface{} { return res1 >= res2 })
-
-	if err != nil {
-
-		// Do a simple string ordering
-
-		ret, err = rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return fmt.Sprint(res1) >= fmt.Sprint(res2) })
-	}
-
-	return ret, err
+	return rt.valOp(node, edge, func(res1 interface{}, res2 interface{}) interface{} { return compare(res1, res2) >= 0 })
 }
 
 /*
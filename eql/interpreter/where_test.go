@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/krotik/eliasdb/eql/parser"
 	"github.com/krotik/eliasdb/graph"
@@ -122,6 +123,80 @@ Data: 1:n:key, 1:func:objget()
 
 }
 
+func TestTypedValueQueries(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for i, ts := range []time.Time{
+		time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC),
+	} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "event")
+		node.SetAttr("start", ts)
+		node.SetAttr("count", int64(9007199254740992+i))
+		gm.StoreNode("main", node)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Timestamps are compared chronologically - also against RFC 3339 strings
+
+	if err := runSearch("get event where start > '2020-01-01T00:00:00Z' show key, start", `
+Labels: Event Key, Start
+Format: auto, auto
+Data: 1:n:key, 1:n:start
+0, 2020-03-01 00:00:00 +0000 UTC
+2, 2020-01-15 12:00:00 +0000 UTC
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Results are ordered by value type
+
+	ast, err := parser.ParseWithRuntime("test", "get event show key, start with ordering(ascending start)", rt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := ast.Runtime.Eval()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(res.(*SearchResult).Data); res != "[[1 2019-12-01 00:00:00 +0000 UTC] [2 2020-01-15 12:00:00 +0000 UTC] [0 2020-03-01 00:00:00 +0000 UTC]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := runSearch("get event where start = '2019-12-01T00:00:00Z' show key, start", `
+Labels: Event Key, Start
+Format: auto, auto
+Data: 1:n:key, 1:n:start
+1, 2019-12-01 00:00:00 +0000 UTC
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Large integers are compared without loss of precision
+
+	if err := runSearch("get event where count >= 9007199254740993 show key", `
+Labels: Event Key
+Format: auto
+Data: 1:n:key
+1
+2
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
 func TestWhere(t *testing.T) {
 	gm, _ := simpleGraph()
 	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
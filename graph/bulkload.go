@@ -0,0 +1,341 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+/*
+nodeBulkLoader data structure
+*/
+type nodeBulkLoader struct {
+	attrLoader *hash.HTreeBulkLoader // Bulk loader for the attribute list tree
+	valLoader  *hash.HTreeBulkLoader // Bulk loader for the attribute value tree
+	attrTree   *hash.HTree           // Attribute list tree
+	valTree    *hash.HTree           // Attribute value tree
+	indexTree  *hash.HTree           // Index tree of the node kind
+}
+
+/*
+BulkLoadNodes loads a list of nodes into a partition which does not contain
+any nodes of the given kinds yet. The node storage is built bottom-up by a
+HTree bulk loader which is much faster than storing nodes one by one. Nodes
+with the same key overwrite each other. Rules are informed with EventNodeStore
+about every node before anything is written and with EventNodeCreated after
+all nodes have been loaded. Unique constraints, history retentions and the
+change log are maintained like for nodes which are stored one by one.
+*/
+func (gm *Manager) BulkLoadNodes(part string, nodes []data.Node) error {
+	var kinds []string
+
+	// Inform rules about all nodes before anything is written - rules can
+	// reject nodes (e.g. schema validation) or handle them
+
+	trans := newInternalGraphTrans(gm)
+	trans.subtrans = true
+
+	nodeKinds := make(map[string][]data.Node)
+	nodePos := make(map[string]map[string]int)
+
+	for _, node := range nodes {
+
+		if err := gm.gr.graphEvent(trans, EventNodeStore, part, node); err == ErrEventHandled {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := gm.checkNode(node); err != nil {
+			return err
+		}
+
+		// Group the nodes by kind - later nodes with the same key replace
+		// earlier ones
+
+		kind := node.Kind()
+
+		if _, ok := nodeKinds[kind]; !ok {
+			kinds = append(kinds, kind)
+			nodePos[kind] = make(map[string]int)
+		}
+
+		if pos, ok := nodePos[kind][node.Key()]; ok {
+			nodeKinds[kind][pos] = node
+			continue
+		}
+
+		nodePos[kind][node.Key()] = len(nodeKinds[kind])
+		nodeKinds[kind] = append(nodeKinds[kind], node)
+	}
+
+	// Changes of event handlers are written even if the load fails
+
+	if err := trans.Commit(); err != nil {
+		return err
+	}
+
+	err := gm.bulkLoadNodesWithLock(part, kinds, nodeKinds, trans)
+
+	if derr := gm.waitDurable(); err == nil {
+		err = derr
+	}
+
+	return err
+}
+
+/*
+bulkLoadNodesWithLock loads the nodes of all given kinds while holding the
+writer lock and executes the rules for the loaded nodes afterwards.
+*/
+func (gm *Manager) bulkLoadNodesWithLock(part string, kinds []string,
+	nodeKinds map[string][]data.Node, trans *baseTrans) error {
+
+	// Take writer lock
+
+	gm.mutex.Lock()
+
+	trans.timestamp = time.Now()
+
+	err := gm.bulkLoadNodes(part, kinds, nodeKinds, trans)
+
+	if err != nil {
+
+		// Try to rollback all changes
+
+		gm.gs.RollbackMain()
+		gm.rollbackChangeLog()
+
+		for _, kind := range kinds {
+			gm.rollbackNodeIndex(part, kind)
+			gm.rollbackNodeUnique(part, kind)
+			gm.rollbackNodeHistory(part, kind)
+			gm.rollbackNodeStorage(part, kind)
+		}
+
+		trans.changes = nil
+
+		gm.mutex.Unlock()
+
+		return err
+	}
+
+	// Flush changes - the change log is flushed before the node storages so
+	// no loaded node is ever missing from the change log
+
+	gm.gs.FlushMain()
+	gm.flushChangeLog()
+
+	for _, kind := range kinds {
+		gm.flushNodeIndex(part, kind)
+		gm.flushNodeUnique(part, kind)
+		gm.flushNodeHistory(part, kind)
+		gm.flushNodeStorage(part, kind)
+	}
+
+	gm.mutex.Unlock()
+
+	// Execute rules
+
+	var oldnode data.Node
+
+	for _, kind := range kinds {
+		for _, node := range nodeKinds[kind] {
+			if err := gm.gr.graphEvent(trans, EventNodeCreated, part, node, oldnode); err != nil && err != ErrEventHandled {
+				return err
+			}
+		}
+	}
+
+	return trans.Commit()
+}
+
+/*
+bulkLoadNodes checks the unique constraints of all nodes and that the
+partition does not contain nodes of the given kinds yet. The nodes are then
+written with bulk loaders. The versions and changes of the loaded nodes are
+recorded with the given transaction and the change set is written. It is
+assumed that the caller holds the writer lock before calling the function.
+*/
+func (gm *Manager) bulkLoadNodes(part string, kinds []string, nodeKinds map[string][]data.Node,
+	trans *baseTrans) error {
+
+	// Check unique constraints before any node is written - loaded nodes
+	// must not conflict with each other
+
+	for _, kind := range kinds {
+		if err := gm.checkUniqueNodes(part, kind, nodeKinds[kind]); err != nil {
+			return err
+		}
+	}
+
+	// Create bulk loaders for all kinds - this fails if the partition
+	// contains already nodes of a kind
+
+	loaders := make(map[string]*nodeBulkLoader)
+
+	for _, kind := range kinds {
+
+		iht, err := gm.getNodeIndexHTree(part, kind, true)
+		if err != nil {
+			return err
+		}
+
+		attht, valht, err := gm.getNodeStorageHTree(part, kind, true)
+		if err != nil {
+			return err
+		}
+
+		attLoader, err := hash.NewHTreeBulkLoader(attht)
+		if err == nil {
+			var valLoader *hash.HTreeBulkLoader
+
+			if valLoader, err = hash.NewHTreeBulkLoader(valht); err == nil {
+				loaders[kind] = &nodeBulkLoader{attLoader, valLoader, attht, valht, iht}
+			}
+		}
+
+		if err != nil {
+			return &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: fmt.Sprintf("Partition %v contains already nodes of kind %v", part, kind),
+			}
+		}
+	}
+
+	// Write the unique constraint lookup entries
+
+	for _, kind := range kinds {
+		for _, node := range nodeKinds[kind] {
+			if err := gm.claimUniqueKeys(part, node, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, kind := range kinds {
+		var attrKeys, valKeys [][]byte
+		var attrValues, valValues []interface{}
+
+		loader := loaders[kind]
+
+		for _, node := range nodeKinds[kind] {
+
+			keyAttrPrefix := PrefixNSAttr + node.Key()
+
+			attrList := make([]string, 0, len(node.Data()))
+
+			for attr, val := range node.Data() {
+
+				// Ignore filtered attributes
+
+				if nodeAttributeFilter(attr) {
+					continue
+				}
+
+				encattr := gm.nm.Encode32(attr, true)
+
+				attrList = append(attrList, encattr)
+
+				storeval, err := gm.writeAttrValue(loader.valTree, val)
+				if err != nil {
+					return err
+				}
+
+				valKeys = append(valKeys, []byte(keyAttrPrefix+encattr))
+				valValues = append(valValues, storeval)
+			}
+
+			attrKeys = append(attrKeys, []byte(PrefixNSAttrs+node.Key()))
+			attrValues = append(attrValues, attrList)
+		}
+
+		// Write the trees in hash order
+
+		writeTree := func(bl *hash.HTreeBulkLoader, tree *hash.HTree, keys [][]byte, values []interface{}) error {
+			tree.SortByHash(keys, values)
+
+			for i, key := range keys {
+				if err := bl.Add(key, values[i]); err != nil {
+					return err
+				}
+			}
+
+			return bl.Finish()
+		}
+
+		if err := writeTree(loader.attrLoader, loader.attrTree, attrKeys, attrValues); err != nil {
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+
+		if err := writeTree(loader.valLoader, loader.valTree, valKeys, valValues); err != nil {
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+
+		// Index the nodes
+
+		if loader.indexTree != nil {
+			im := util.NewIndexManager(loader.indexTree)
+
+			for _, node := range nodeKinds[kind] {
+				if err := im.Index(node.Key(), node.IndexMap()); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Record the versions and changes of the loaded nodes
+
+		history := gm.readHistoryRetention(kind) != nil
+		changeLog, _ := gm.readChangeLogSettings()
+
+		for _, node := range nodeKinds[kind] {
+
+			if history {
+				if err := gm.recordNodeVersion(part, node.Key(), kind, trans.id,
+					trans.timestamp, loader.attrTree, loader.valTree); err != nil {
+					return err
+				}
+			}
+
+			if changeLog {
+				gm.addNodeChange(trans, part, node.Key(), kind, node, nil)
+			}
+		}
+
+		// Update the node count
+
+		currentCount := gm.NodeCount(kind)
+		if err := gm.writeNodeCount(kind, currentCount+uint64(len(nodeKinds[kind])), false); err != nil {
+			return err
+		}
+	}
+
+	// Append all changes to the change log
+
+	sequence, err := gm.writeChangeSet(trans)
+	if err != nil {
+		return err
+	}
+
+	trans.changes = nil
+
+	if sequence != 0 {
+		gm.markDurable(sequence)
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestBulkImportPartition(t *testing.T) {
+
+	// Create test data
+
+	var buf bytes.Buffer
+
+	buf.WriteString("{\n  \"nodes\" : [\n")
+
+	for i := 0; i < 1000; i++ {
+		kind := "Person"
+		if i%3 == 0 {
+			kind = "Company"
+		}
+		fmt.Fprintf(&buf, "    { \"key\" : \"%v\", \"kind\" : \"%v\", \"name\" : \"name %v\", \"num\" : %v },\n",
+			i, kind, i, i)
+	}
+
+	// A node with an existing key replaces the previous node
+
+	buf.WriteString("    { \"key\" : \"1\", \"kind\" : \"Person\", \"name\" : \"new name\" }\n")
+	buf.WriteString("  ],\n  \"edges\" : [\n")
+	buf.WriteString("    { \"key\" : \"e1\", \"kind\" : \"Employment\", " +
+		"\"end1key\" : \"1\", \"end1kind\" : \"Person\", \"end1role\" : \"employee\", \"end1cascading\" : false, " +
+		"\"end2key\" : \"3\", \"end2kind\" : \"Company\", \"end2role\" : \"employer\", \"end2cascading\" : false }\n")
+	buf.WriteString("  ]\n}")
+
+	importData := buf.String()
+
+	// Import the data normally and with the bulk loader
+
+	gs1 := graphstorage.NewMemoryGraphStorage("test1")
+	gm1 := NewGraphManager(gs1)
+
+	if err := ImportPartition(bytes.NewBufferString(importData), "main", gm1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gs2 := graphstorage.NewMemoryGraphStorage("test2")
+	gm2 := NewGraphManager(gs2)
+
+	if err := BulkImportPartition(bytes.NewBufferString(importData), "main", gm2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Both graphs should be the same
+
+	var out1, out2 bytes.Buffer
+
+	ExportPartition(&out1, "main", gm1)
+	ExportPartition(&out2, "main", gm2)
+
+	if res1, res2 := SortDump(out1.String()), SortDump(out2.String()); res1 != res2 {
+		t.Error("Unexpected result:", res2, "expected:", res1)
+		return
+	}
+
+	if res := gm2.NodeCount("Person"); res != 666 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm2.NodeCount("Company"); res != 334 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(gm2.Partitions(), gm2.NodeKinds(), gm2.NodeAttrs("Person"),
+		gm2.NodeEdges("Person")); res != "[main] [Company Person] [key kind name num] [employee:Employment:employer:Company]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, err := gm2.FetchNode("main", "1", "Person"); err != nil || res.Attr("name") != "new name" || res.Attr("num") != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	_, edges, err := gm2.TraverseMulti("main", "3", "Company", ":::", false)
+	if err != nil || len(edges) != 1 || edges[0].Key() != "e1" {
+		t.Error("Unexpected result:", edges, err)
+		return
+	}
+
+	// Check that the index was written
+
+	idx, _ := gm2.NodeIndexQuery("main", "Person")
+
+	if res, err := idx.LookupValue("name", "name 500"); fmt.Sprint(res) != "[500]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Check the integrity of the loaded data
+
+	res, err := gm2.Check(false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, r := range res {
+		if len(r.Problems) > 0 {
+			t.Error("Unexpected problems:", r.Name, r.Problems)
+			return
+		}
+	}
+
+	// The loaded data can be modified normally
+
+	if err := gm2.StoreNode("main", data.NewGraphNodeFromMap(map[string]interface{}{
+		"key":  "2000",
+		"kind": "Person",
+	})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := gm2.RemoveNode("main", "2", "Person"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm2.NodeCount("Person"); res != 666 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Bulk loading into a partition which contains already nodes of a kind
+	// is not possible
+
+	err = BulkImportPartition(bytes.NewBufferString(importData), "main", gm2)
+	if err == nil || err.Error() != "GraphError: Invalid data (Partition main contains already nodes of kind Company)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Other partitions are still empty
+
+	if err := BulkImportPartition(bytes.NewBufferString(importData), "second", gm2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm2.NodeCount("Person"); res != 1332 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestBulkLoadNodesErrors(t *testing.T) {
+	gs := graphstorage.NewMemoryGraphStorage("test")
+	gm := NewGraphManager(gs)
+
+	// Invalid data
+
+	err := BulkImportPartition(bytes.NewBufferString("{"), "main", gm)
+	if err == nil || err.Error() != "Could not decode file content as object with list of nodes and edges: unexpected EOF" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	err = gm.BulkLoadNodes("main", []data.Node{data.NewGraphNodeFromMap(map[string]interface{}{
+		"key": "123",
+	})})
+	if err == nil || err.Error() != "GraphError: Invalid data (Node is missing a kind value)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	err = gm.BulkLoadNodes("mai n", []data.Node{data.NewGraphNodeFromMap(map[string]interface{}{
+		"key":  "123",
+		"kind": "test",
+	})})
+	if err == nil || err.Error() != "GraphError: Invalid data (Partition name mai n is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Storage errors while loading
+
+	var nodes []data.Node
+
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, data.NewGraphNodeFromMap(map[string]interface{}{
+			"key":  fmt.Sprint(i),
+			"kind": "test",
+			"name": fmt.Sprint("name", i),
+		}))
+	}
+
+	gm.getNodeStorageHTree("main", "test", true)
+
+	msm := gs.StorageManager("main"+"test"+StorageSuffixNodes, false).(*storage.MemoryStorageManager)
+	msm.AccessMap[msm.LocCount+3] = storage.AccessInsertError
+
+	err = gm.BulkLoadNodes("main", nodes)
+	if err == nil || err.(*util.GraphError).Type != util.ErrWriting {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := gm.NodeCount("test"); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestBulkLoadNodesRules(t *testing.T) {
+	gs := graphstorage.NewMemoryGraphStorage("test")
+	gm := NewGraphManager(gs)
+
+	newNode := func(key string, name interface{}) data.Node {
+		node := data.NewGraphNodeFromMap(map[string]interface{}{
+			"key":  key,
+			"kind": "Person",
+		})
+		if name != nil {
+			node.SetAttr("name", name)
+		}
+		return node
+	}
+
+	// Nodes are validated before anything is written
+
+	gm.SetSchema(&Schema{Kind: "Person", Attrs: map[string]*SchemaAttr{
+		"name": {SchemaTypeString, true}}})
+
+	err := gm.BulkLoadNodes("main", []data.Node{newNode("1", "Anne"), newNode("2", nil)})
+	if err == nil || err.Error() != "GraphError: Graph rule error (GraphError: Schema violation "+
+		"(Node 2 (Person): Required attribute name is missing))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := gm.NodeCount("Person"); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Loaded nodes have a version and are added to the change log
+
+	gm.SetHistoryRetention("Person", &HistoryRetention{})
+	gm.EnableChangeLog(0)
+
+	if err := gm.BulkLoadNodes("main", []data.Node{newNode("1", "Anne"), newNode("2", "Hans")}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := gm.FetchNodeVersions("main", "2", "Person"); len(res) != 1 ||
+		res[0].Data["name"] != "Hans" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := changeSetsString(gm, 1); res != "1: store node main/Person/1 Anne, store node main/Person/2 Hans" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
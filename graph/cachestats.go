@@ -0,0 +1,37 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+CacheStats returns the combined statistics of all caches of the graph storage
+and the memory budget in bytes which is shared by the caches (0 if there is no
+limit). Returns an error if the graph storage does not cache objects (e.g.
+memory only storage).
+*/
+func (gm *Manager) CacheStats() (storage.CacheStats, int64, error) {
+
+	cs, ok := gm.gs.(graphstorage.CacheStatsStorage)
+	if !ok {
+		return storage.CacheStats{}, 0, &util.GraphError{Type: util.ErrAccessComponent,
+			Detail: "Graph storage does not support cache statistics"}
+	}
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return cs.CacheStats(), cs.CacheMaxSize(), nil
+}
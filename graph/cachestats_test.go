@@ -0,0 +1,68 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestCacheStats(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorageWithOptions(GraphManagerTestDBDir9, false,
+		storage.DiskStorageManagerOptions{Read: storage.ReadOptions{CacheMaxBytes: 100000}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mykind")
+	node.SetAttr("name", "Node1")
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := gm.FetchNode("main", "123", "mykind"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, maxSize, err := gm.CacheStats()
+	if err != nil || maxSize != 100000 || stats.Objects == 0 || stats.Size == 0 ||
+		stats.Hits == 0 {
+		t.Error("Unexpected result:", stats, maxSize, err)
+		return
+	}
+
+	dgs.Close()
+
+	// Memory storage does not cache objects
+
+	gm = NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if _, _, err := gm.CacheStats(); err == nil ||
+		err.Error() != "GraphError: Failed to access graph storage component (Graph storage does not support cache statistics)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
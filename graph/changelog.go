@@ -0,0 +1,613 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+Operations of changes in the change log
+*/
+const (
+	ChangeOpStore  = "store"  // A new node or edge was stored
+	ChangeOpUpdate = "update" // An existing node or edge was updated
+	ChangeOpRemove = "remove" // A node or edge was removed
+)
+
+/*
+Item types of changes in the change log
+*/
+const (
+	ChangeItemNode = "node" // The change affects a node
+	ChangeItemEdge = "edge" // The change affects an edge
+)
+
+/*
+Change is a single change of a node or edge. A change lists the names of all
+attributes which were changed. The data of a change holds the values of the
+changed attributes after the change (all values before a removal). Removed
+attributes and large values (see LargeValueThreshold) are not part of the data.
+Large values are only listed by their attribute name - they can be read with
+NodeAttrReader.
+*/
+type Change struct {
+	Op    string                 `json:"op"`    // Operation of the change (ChangeOp...)
+	Part  string                 `json:"part"`  // Partition of the item
+	Item  string                 `json:"item"`  // Item type of the change (ChangeItem...)
+	Kind  string                 `json:"kind"`  // Kind of the item
+	Key   string                 `json:"key"`   // Key of the item
+	Attrs []string               `json:"attrs"` // Names of all changed attributes
+	Large []string               `json:"large"` // Names of changed attributes which have a large value
+	Data  map[string]interface{} `json:"data"`  // Values of the changed attributes which are not large
+}
+
+/*
+ChangeSet holds all changes of a single commit.
+*/
+type ChangeSet struct {
+	Sequence  uint64    `json:"sequence"`    // Sequence number of the change set
+	Timestamp time.Time `json:"timestamp"`   // Commit timestamp
+	TransID   string    `json:"transaction"` // ID of the committed transaction
+	Changes   []*Change `json:"changes"`     // Changes of the commit in the order they were written
+}
+
+/*
+changeLogInfo holds the range of retained change sets.
+*/
+type changeLogInfo struct {
+	First uint64 // First retained sequence number
+	Last  uint64 // Last sequence number
+}
+
+/*
+changeLogEntry is a single stored change set.
+*/
+type changeLogEntry struct {
+	Timestamp int64     // Commit timestamp in nanoseconds since the epoch
+	TransID   string    // ID of the committed transaction
+	Changes   []*Change // Changes of the commit
+}
+
+func init() {
+
+	// Make sure we can use the relevant types in a gob operation
+
+	gob.Register(&changeLogInfo{})
+	gob.Register(&changeLogEntry{})
+
+	// Make sure we can use the relevant types with the binary codec
+
+	storage.RegisterCodecType(&changeLogInfo{})
+	storage.RegisterCodecType(&changeLogEntry{})
+	storage.RegisterCodecType(&Change{})
+}
+
+/*
+EnableChangeLog enables the change log. Every following commit appends all
+its changes as a change set with a new sequence number to the log. The log
+keeps at most a given number of change sets (0 for no limit).
+*/
+func (gm *Manager) EnableChangeLog(maxEntries int) error {
+
+	if maxEntries < 0 {
+		return &util.GraphError{Type: util.ErrInvalidData,
+			Detail: "Maximum number of change log entries cannot be negative"}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.gs.MainDB()[MainDBChangeLog] = strconv.Itoa(maxEntries)
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+DisableChangeLog disables the change log. Already stored change sets can
+still be read and sequence numbers continue once the change log is enabled
+again. Returns if the change log was enabled.
+*/
+func (gm *Manager) DisableChangeLog() (bool, error) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if _, ok := gm.gs.MainDB()[MainDBChangeLog]; !ok {
+		return false, nil
+	}
+
+	delete(gm.gs.MainDB(), MainDBChangeLog)
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return false, &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return true, nil
+}
+
+/*
+ChangeLogEnabled returns if the change log is enabled and the maximum number
+of change sets which are kept.
+*/
+func (gm *Manager) ChangeLogEnabled() (bool, int) {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return gm.readChangeLogSettings()
+}
+
+/*
+ChangeLogRange returns the first and the last sequence number of the
+retained change sets. Change sets which are not yet durable according to the
+sync policy of the graph storage are not included. Change sets whose sync
+failed stay excluded. Returns 0 for both if the change log is empty.
+*/
+func (gm *Manager) ChangeLogRange() (uint64, uint64, error) {
+
+	clt, err := gm.getChangeLogHTree(false)
+	if err != nil || clt == nil {
+		return 0, 0, err
+	}
+
+	// Take reader lock
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	info, err := readChangeLogInfo(clt)
+	if err != nil || info == nil {
+		return 0, 0, err
+	}
+
+	if pending := gm.pendingChangeSet(); pending != 0 && info.Last >= pending {
+		if info.Last = pending - 1; info.Last < info.First {
+			info.Last = info.First - 1
+		}
+	}
+
+	return info.First, info.Last, nil
+}
+
+/*
+WaitForChanges waits until the change set with a given sequence number has
+been written or until a given timeout has passed. Returns if the change set
+is available.
+*/
+func (gm *Manager) WaitForChanges(sequence uint64, timeout time.Duration) bool {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+
+		// Get the notification channel before checking the log so no
+		// change set can be missed
+
+		ch := gm.changes.wait()
+		dch := gm.durableNotify()
+
+		if _, last, err := gm.ChangeLogRange(); err != nil || last >= sequence {
+
+			// Errors are reported when the change set is read
+
+			return true
+		}
+
+		select {
+		case <-ch:
+		case <-dch:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+/*
+ChangeLogIterator returns an iterator over the change log which starts at a
+given sequence number. The iteration starts with the first retained change
+set if the given sequence number is 0. The iterator also returns change sets
+which are written after its creation. Returns an error if the change set with
+the given sequence number is no longer retained.
+*/
+func (gm *Manager) ChangeLogIterator(sequence uint64) (*ChangeLogIterator, error) {
+
+	first, _, err := gm.ChangeLogRange()
+	if err != nil {
+		return nil, err
+	}
+
+	if sequence == 0 {
+		if sequence = first; sequence == 0 {
+			sequence = 1
+		}
+	} else if sequence < first {
+		return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprintf(
+			"Change set %v is no longer in the change log - first retained change set: %v",
+			sequence, first)}
+	}
+
+	return &ChangeLogIterator{gm, sequence, nil}, nil
+}
+
+/*
+ChangeLogIterator can be used to iterate over the change sets of the change
+log in the order of their sequence numbers.
+*/
+type ChangeLogIterator struct {
+	gm        *Manager // GraphManager which created the iterator
+	next      uint64   // Sequence number of the next change set
+	LastError error    // Last encountered error
+}
+
+/*
+Next returns the next change set. Sets the LastError attribute if an error
+occurs. Returns nil if the next change set is not yet durable. This includes
+change sets whose sync failed.
+*/
+func (it *ChangeLogIterator) Next() *ChangeSet {
+
+	clt, err := it.gm.getChangeLogHTree(false)
+	if err != nil {
+		it.LastError = err
+		return nil
+	} else if clt == nil {
+		return nil
+	}
+
+	// Take reader lock
+
+	it.gm.mutex.RLock()
+	defer it.gm.mutex.RUnlock()
+
+	// Change sets which are not yet durable are not returned
+
+	if pending := it.gm.pendingChangeSet(); pending != 0 && it.next >= pending {
+		return nil
+	}
+
+	obj, err := clt.Get(changeLogKey(it.next))
+	if err != nil {
+		it.LastError = &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		return nil
+
+	} else if obj == nil {
+
+		// The change set was either removed or has not been written yet
+
+		if info, err := readChangeLogInfo(clt); err != nil {
+			it.LastError = err
+		} else if info != nil && it.next < info.First {
+			it.LastError = &util.GraphError{Type: util.ErrReading, Detail: fmt.Sprintf(
+				"Change set %v is no longer in the change log", it.next)}
+		}
+
+		return nil
+	}
+
+	entry := obj.(*changeLogEntry)
+
+	// Copy the changes so the stored change set cannot be modified
+
+	changes := make([]*Change, len(entry.Changes))
+
+	for i, c := range entry.Changes {
+		change := *c
+		change.Attrs = append([]string(nil), c.Attrs...)
+		change.Large = append([]string(nil), c.Large...)
+		if c.Data != nil {
+			change.Data = make(map[string]interface{}, len(c.Data))
+			for k, v := range c.Data {
+				change.Data[k] = v
+			}
+		}
+		changes[i] = &change
+	}
+
+	ret := &ChangeSet{it.next, time.Unix(0, entry.Timestamp).UTC(), entry.TransID, changes}
+
+	it.next++
+
+	return ret
+}
+
+/*
+HasNext returns if there is a next change set.
+*/
+func (it *ChangeLogIterator) HasNext() bool {
+	_, last, err := it.gm.ChangeLogRange()
+	return err == nil && it.next <= last
+}
+
+/*
+Sequence returns the sequence number of the next change set. The number can
+be used to continue the iteration later.
+*/
+func (it *ChangeLogIterator) Sequence() uint64 {
+	return it.next
+}
+
+/*
+Error returns the last encountered error.
+*/
+func (it *ChangeLogIterator) Error() error {
+	return it.LastError
+}
+
+/*
+readChangeLogSettings reads the change log settings from the MainDB.
+*/
+func (gm *Manager) readChangeLogSettings() (bool, int) {
+
+	val, ok := gm.gs.MainDB()[MainDBChangeLog]
+	if !ok {
+		return false, 0
+	}
+
+	maxEntries, _ := strconv.Atoi(val)
+
+	return true, maxEntries
+}
+
+/*
+addNodeChange adds the change of a node to the changes of a transaction if
+the change log is enabled. The given node is the node which was written (nil
+if the node was removed) and the old node holds the old values of all
+written attributes (nil if the node was inserted). It is assumed that the
+caller holds the writer lock.
+*/
+func (gm *Manager) addNodeChange(trans *baseTrans, part string, key string, kind string,
+	node data.Node, oldnode data.Node) {
+	gm.addChange(trans, part, ChangeItemNode, key, kind, node, oldnode)
+}
+
+/*
+addEdgeChange adds the change of an edge to the changes of a transaction if
+the change log is enabled (see addNodeChange).
+*/
+func (gm *Manager) addEdgeChange(trans *baseTrans, part string, key string, kind string,
+	edge data.Edge, oldedge data.Edge) {
+	gm.addChange(trans, part, ChangeItemEdge, key, kind, edge, oldedge)
+}
+
+/*
+addChange adds the change of a node or edge to the changes of a transaction.
+Only changed attributes are recorded and large values are not copied into the
+change.
+*/
+func (gm *Manager) addChange(trans *baseTrans, part string, item string, key string, kind string,
+	node data.Node, oldnode data.Node) {
+
+	if ok, _ := gm.readChangeLogSettings(); !ok {
+		return
+	}
+
+	change := &Change{ChangeOpUpdate, part, item, kind, key, nil, nil, make(map[string]interface{})}
+
+	var newData, oldData map[string]interface{}
+
+	if node == nil {
+		change.Op = ChangeOpRemove
+		newData = oldnode.Data()
+	} else {
+		newData = node.Data()
+		if oldnode == nil {
+			change.Op = ChangeOpStore
+		} else {
+			oldData = oldnode.Data()
+		}
+	}
+
+	addAttr := func(attr string, val interface{}) {
+		change.Attrs = append(change.Attrs, attr)
+
+		if val == nil {
+			return
+		} else if isLargeAttrValue(val) {
+			change.Large = append(change.Large, attr)
+		} else {
+			change.Data[attr] = val
+		}
+	}
+
+	for attr, val := range newData {
+
+		if attr == data.NodeKey || attr == data.NodeKind {
+			continue
+		}
+
+		if oldval, ok := oldData[attr]; ok &&
+			reflect.DeepEqual(data.NormalizeValue(oldval), data.NormalizeValue(val)) {
+			continue
+		}
+
+		addAttr(attr, val)
+	}
+
+	// Attributes which are only in the old data were removed
+
+	if change.Op == ChangeOpUpdate {
+		for attr := range oldData {
+			if _, ok := newData[attr]; !ok && attr != data.NodeKey && attr != data.NodeKind {
+				addAttr(attr, nil)
+			}
+		}
+	}
+
+	// Ensure the output is deterministic
+
+	sort.Strings(change.Attrs)
+	sort.Strings(change.Large)
+
+	trans.changes = append(trans.changes, change)
+}
+
+/*
+writeChangeSet appends a change set with the changes of a given transaction
+to the change log and removes all change sets which exceed the maximum
+number of entries. Returns the sequence number of the written change set
+(0 if nothing was written). It is assumed that the caller holds the writer
+lock.
+*/
+func (gm *Manager) writeChangeSet(trans *baseTrans) (uint64, error) {
+
+	ok, maxEntries := gm.readChangeLogSettings()
+	if !ok || len(trans.changes) == 0 {
+		return 0, nil
+	}
+
+	clt, err := gm.getChangeLogHTree(true)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := readChangeLogInfo(clt)
+	if err != nil {
+		return 0, err
+	} else if info == nil {
+		info = &changeLogInfo{1, 0}
+	}
+
+	info.Last++
+
+	entry := &changeLogEntry{trans.timestamp.UnixNano(), trans.id, trans.changes}
+
+	if _, err := clt.Put(changeLogKey(info.Last), entry); err != nil {
+		return 0, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	for maxEntries > 0 && info.Last-info.First+1 > uint64(maxEntries) {
+
+		if _, err := clt.Remove(changeLogKey(info.First)); err != nil {
+			return 0, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+
+		info.First++
+	}
+
+	if _, err := clt.Put([]byte(PrefixCLInfo), info); err != nil {
+		return 0, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return info.Last, nil
+}
+
+/*
+readChangeLogInfo reads the range of retained change sets. Returns nil if
+the change log is empty.
+*/
+func readChangeLogInfo(clt *hash.HTree) (*changeLogInfo, error) {
+
+	obj, err := clt.Get([]byte(PrefixCLInfo))
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	} else if obj == nil {
+		return nil, nil
+	}
+
+	info := *obj.(*changeLogInfo)
+
+	return &info, nil
+}
+
+/*
+changeLogKey returns the key of a single change set in the change log.
+*/
+func changeLogKey(sequence uint64) []byte {
+	return []byte(PrefixCLEntry + strconv.FormatUint(sequence, 10))
+}
+
+/*
+pendingChangeSet returns the first change set which is not yet durable
+according to the graph storage (0 if all change sets are durable). The state
+is kept by the graph storage so it is shared by all GraphManagers which use it.
+*/
+func (gm *Manager) pendingChangeSet() uint64 {
+	if ds, ok := gm.gs.(graphstorage.DurableStorage); ok {
+		return ds.PendingTag()
+	}
+	return 0
+}
+
+/*
+durableNotify returns a channel which is closed once more change sets may be
+durable (nil if the graph storage writes every commit directly to disk).
+*/
+func (gm *Manager) durableNotify() <-chan struct{} {
+	if ds, ok := gm.gs.(graphstorage.DurableStorage); ok {
+		return ds.DurableNotify()
+	}
+	return nil
+}
+
+/*
+markDurable tags all commits up to a given change set with its sequence number
+so the graph storage can tell once they are durable. Must be called after the
+change set and all its changes were committed.
+*/
+func (gm *Manager) markDurable(sequence uint64) {
+	if ds, ok := gm.gs.(graphstorage.DurableStorage); ok {
+		ds.TagDurable(sequence)
+	}
+}
+
+/*
+changeNotifier notifies waiting consumers once change sets may have become
+durable.
+*/
+type changeNotifier struct {
+	mutex *sync.Mutex   // Mutex to protect the notification channel
+	ch    chan struct{} // Channel which is closed once change sets may have become durable
+}
+
+/*
+newChangeNotifier creates a new changeNotifier.
+*/
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{&sync.Mutex{}, make(chan struct{})}
+}
+
+/*
+wait returns a channel which is closed once change sets may have become
+durable.
+*/
+func (cn *changeNotifier) wait() <-chan struct{} {
+	cn.mutex.Lock()
+	defer cn.mutex.Unlock()
+
+	return cn.ch
+}
+
+/*
+notify notifies all waiting consumers.
+*/
+func (cn *changeNotifier) notify() {
+	cn.mutex.Lock()
+	defer cn.mutex.Unlock()
+
+	close(cn.ch)
+	cn.ch = make(chan struct{})
+}
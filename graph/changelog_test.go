@@ -0,0 +1,571 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+func changeSetsString(gm *Manager, from uint64) (string, error) {
+	var ret []string
+
+	it, err := gm.ChangeLogIterator(from)
+	if err != nil {
+		return "", err
+	}
+
+	for it.HasNext() {
+		cs := it.Next()
+		if cs == nil {
+			return "", it.Error()
+		}
+
+		if cs.TransID == "" || cs.Timestamp.IsZero() {
+			return "", fmt.Errorf("Change set without transaction ID or timestamp: %v", cs)
+		}
+
+		var changes []string
+		for _, c := range cs.Changes {
+			changes = append(changes, fmt.Sprintf("%v %v %v/%v/%v %v", c.Op, c.Item,
+				c.Part, c.Kind, c.Key, c.Data["name"]))
+		}
+
+		ret = append(ret, fmt.Sprintf("%v: %v", cs.Sequence, strings.Join(changes, ", ")))
+	}
+
+	return strings.Join(ret, "\n"), it.Error()
+}
+
+func TestChangeLog(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	// Commits are not recorded if the change log is disabled
+
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne"}))
+
+	if first, last, err := gm.ChangeLogRange(); first != 0 || last != 0 || err != nil {
+		t.Error("Unexpected result:", first, last, err)
+		return
+	}
+
+	if ok, _ := gm.ChangeLogEnabled(); ok {
+		t.Error("Change log should be disabled")
+		return
+	}
+
+	if err := gm.EnableChangeLog(-1); err == nil || err.Error() !=
+		"GraphError: Invalid data (Maximum number of change log entries cannot be negative)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.EnableChangeLog(0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ok, maxEntries := gm.ChangeLogEnabled(); !ok || maxEntries != 0 {
+		t.Error("Unexpected result:", ok, maxEntries)
+		return
+	}
+
+	// Record direct operations and transactions
+
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne", "age": 30}))
+	gm.UpdateNode("test", newHistoryTestNode("1", map[string]interface{}{"name": "Anna"}))
+	gm.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anna"}))
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newHistoryTestNode("2", map[string]interface{}{"name": "Hans"}))
+	trans.StoreEdge("main", newHistoryTestEdge("a", "1", "2", 2001))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	transID := trans.ID()
+
+	// Failed transactions are not recorded
+
+	trans = NewGraphTrans(gm)
+	trans.StoreNode("main", newHistoryTestNode("3", map[string]interface{}{"name": "Heidi"}))
+	trans.StoreEdge("main", newHistoryTestEdge("b", "1", "4", 2001))
+
+	if err := trans.Commit(); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	// Changes of rules are recorded with the change which caused them
+
+	gm.StoreEdge("main", newHistoryTestEdge("b", "1", "2", 2002))
+	gm.RemoveEdge("main", "b", "Knows")
+
+	if _, err := gm.RemoveNode("main", "1", "Person"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := changeSetsString(gm, 0); err != nil || res != `
+1: update node main/Person/1 <nil>
+2: store node test/Person/1 Anna
+3: update node main/Person/1 Anna
+4: store node main/Person/2 Hans, store edge main/Knows/a <nil>
+5: store edge main/Knows/b <nil>
+6: remove edge main/Knows/b <nil>
+7: remove node main/Person/1 Anna, remove edge main/Knows/a <nil>`[1:] {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	it, err := gm.ChangeLogIterator(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Changes contain only the changed attributes
+
+	if cs := it.Next(); cs.Sequence != 1 || fmt.Sprint(cs.Changes[0].Attrs) != "[age]" ||
+		fmt.Sprint(cs.Changes[0].Data) != "map[age:30]" {
+		t.Error("Unexpected result:", cs.Changes[0])
+		return
+	}
+
+	it.Next()
+	cs := it.Next()
+
+	if cs.Sequence != 3 || fmt.Sprint(cs.Changes[0].Attrs) != "[name]" ||
+		fmt.Sprint(cs.Changes[0].Data) != "map[name:Anna]" || it.Sequence() != 4 {
+		t.Error("Unexpected result:", cs, it.Sequence())
+		return
+	}
+
+	// Returned change sets cannot modify the change log
+
+	cs.Changes[0].Data["name"] = "foo"
+
+	if cs := it.Next(); cs.Sequence != 4 || cs.TransID != transID || cs.Changes[1].Data["since"] != 2001 {
+		t.Error("Unexpected result:", cs)
+		return
+	}
+
+	if res, _ := changeSetsString(gm, 3); !strings.HasPrefix(res, "3: update node main/Person/1 Anna\n") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Iterators continue with change sets which are written later
+
+	it, _ = gm.ChangeLogIterator(8)
+
+	if it.HasNext() || it.Next() != nil || it.Error() != nil {
+		t.Error("Unexpected iterator state")
+		return
+	}
+
+	if gm.WaitForChanges(8, time.Millisecond) {
+		t.Error("No change set should be available")
+		return
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		gm.StoreNode("main", newHistoryTestNode("5", map[string]interface{}{"name": "Lisa"}))
+	}()
+
+	if !gm.WaitForChanges(8, 5*time.Second) || !gm.WaitForChanges(7, 0) {
+		t.Error("Change set should be available")
+		return
+	}
+
+	if !it.HasNext() {
+		t.Error("Iterator should have a next change set")
+		return
+	}
+
+	if cs := it.Next(); cs.Sequence != 8 || cs.Changes[0].Key != "5" {
+		t.Error("Unexpected result:", cs)
+		return
+	}
+
+	// Old change sets are removed once the maximum number of entries is exceeded
+
+	it, _ = gm.ChangeLogIterator(0)
+
+	gm.EnableChangeLog(2)
+	gm.StoreNode("main", newHistoryTestNode("6", map[string]interface{}{"name": "Tom"}))
+
+	if first, last, err := gm.ChangeLogRange(); first != 8 || last != 9 || err != nil {
+		t.Error("Unexpected result:", first, last, err)
+		return
+	}
+
+	if _, err := gm.ChangeLogIterator(7); err == nil || err.Error() != "GraphError: Invalid data "+
+		"(Change set 7 is no longer in the change log - first retained change set: 8)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if cs := it.Next(); cs != nil || it.Error() == nil || it.Error().Error() !=
+		"GraphError: Could not read graph information (Change set 1 is no longer in the change log)" {
+		t.Error("Unexpected result:", cs, it.Error())
+		return
+	}
+
+	if res, err := changeSetsString(gm, 0); err != nil || res != `
+8: store node main/Person/5 Lisa
+9: store node main/Person/6 Tom`[1:] {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Disabling the change log keeps the change sets
+
+	if ok, err := gm.DisableChangeLog(); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := gm.DisableChangeLog(); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	gm.StoreNode("main", newHistoryTestNode("7", map[string]interface{}{"name": "Paul"}))
+
+	if first, last, err := gm.ChangeLogRange(); first != 8 || last != 9 || err != nil {
+		t.Error("Unexpected result:", first, last, err)
+		return
+	}
+
+	// Test error cases
+
+	graphstorage.MgsRetFlushMain = errors.New("testerror")
+
+	err1 := gm.EnableChangeLog(0)
+	gm.gs.MainDB()[MainDBChangeLog] = "0"
+	_, err2 := gm.DisableChangeLog()
+
+	graphstorage.MgsRetFlushMain = nil
+
+	if err1 == nil || err1.Error() != "GraphError: Failed to flush changes (testerror)" ||
+		err2 == nil || err2.Error() != "GraphError: Failed to flush changes (testerror)" {
+		t.Error("Unexpected result:", err1, err2)
+		return
+	}
+}
+
+func TestChangeLogChangedAttrs(t *testing.T) {
+	oldThreshold := LargeValueThreshold
+	LargeValueThreshold = 10
+	defer func() {
+		LargeValueThreshold = oldThreshold
+	}()
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	gm.EnableChangeLog(0)
+
+	text := strings.Repeat("large text ", 10)
+
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne", "age": 30, "text": text}))
+	gm.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"age": 31}))
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne", "text": text + "!"}))
+	gm.RemoveNode("main", "1", "Person")
+
+	var res []string
+
+	for it, _ := gm.ChangeLogIterator(0); it.HasNext(); {
+		c := it.Next().Changes[0]
+		res = append(res, fmt.Sprintf("%v %v %v %v", c.Op, c.Attrs, c.Large, c.Data))
+	}
+
+	// Large values are only listed and removed attributes have no value
+
+	if strings.Join(res, "\n") != `
+store [age name text] [text] map[age:30 name:Anne]
+update [age] [] map[age:31]
+update [age text] [text] map[]
+remove [name text] [text] map[name:Anne]`[1:] {
+		t.Error("Unexpected result:", strings.Join(res, "\n"))
+		return
+	}
+}
+
+func TestChangeLogDiskStorage(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorageWithOptions(GraphManagerTestDBDir19, false,
+		storage.DiskStorageManagerOptions{Format: storage.FormatOptions{Codec: storage.CodecBinary}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	gm.EnableChangeLog(0)
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne", "born": ts}))
+	gm.StoreNode("main", newHistoryTestNode("2", map[string]interface{}{"name": "Hans"}))
+
+	// A failed transaction is rolled back including the change log
+
+	trans := NewGraphTrans(gm)
+	trans.StoreEdge("main", newHistoryTestEdge("a", "1", "3", 2001))
+
+	if err := trans.Commit(); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	trans = NewGraphTrans(gm)
+	trans.StoreEdge("main", newHistoryTestEdge("a", "1", "2", 2001))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := gm.Check(false); err != nil || len(res) == 0 || res[len(res)-1].Name != GraphManagerTestDBDir19+"/"+StorageChangeLog {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dgs.Close()
+
+	// The change log is kept when the storage is opened again
+
+	dgs, err = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir19, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm = NewGraphManager(dgs)
+
+	if res, err := changeSetsString(gm, 0); err != nil || res != `
+1: store node main/Person/1 Anne
+2: store node main/Person/2 Hans
+3: store edge main/Knows/a <nil>`[1:] {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	it, _ := gm.ChangeLogIterator(1)
+
+	if cs := it.Next(); cs.Changes[0].Data["born"] != ts {
+		t.Error("Unexpected result:", cs.Changes[0].Data)
+		return
+	}
+}
+
+func TestChangeLogGroupCommit(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	syncer, _ := file.NewLogSyncer(file.SyncPolicy{Mode: file.SyncGroup, WindowTime: time.Hour})
+	defer syncer.Close()
+
+	dgs, err := graphstorage.NewDiskGraphStorageWithOptions(GraphManagerTestDBDir21, false,
+		storage.DiskStorageManagerOptions{Log: storage.LogOptions{SyncPolicy: syncer.Policy(), Syncer: syncer}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm := NewGraphManager(dgs)
+
+	gm.EnableChangeLog(0)
+
+	// Change sets are neither visible nor notified before they are durable
+
+	woken := make(chan bool)
+
+	go func() {
+		woken <- gm.WaitForChanges(1, time.Hour)
+	}()
+
+	done := make(chan error)
+
+	go func() {
+		done <- gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne"}))
+	}()
+
+	select {
+	case <-woken:
+		t.Error("Consumer was notified before the change set was durable")
+		return
+	case <-done:
+		t.Error("StoreNode returned before its changes were synced")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if first, last, err := gm.ChangeLogRange(); first != 1 || last != 0 || err != nil {
+		t.Error("Unexpected result:", first, last, err)
+		return
+	}
+
+	if it, _ := gm.ChangeLogIterator(1); it.HasNext() || it.Next() != nil {
+		t.Error("Change set should not be visible")
+		return
+	}
+
+	// A second GraphManager on the same storage sees the same state
+
+	gm2 := NewGraphManager(dgs)
+
+	if first, last, err := gm2.ChangeLogRange(); first != 1 || last != 0 || err != nil {
+		t.Error("Unexpected result:", first, last, err)
+		return
+	}
+
+	if err := syncer.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := <-done; err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !<-woken {
+		t.Error("Consumer should have been notified")
+		return
+	}
+
+	if res, err := changeSetsString(gm, 0); err != nil || res != "1: store node main/Person/1 Anne" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if first, last, err := gm2.ChangeLogRange(); first != 1 || last != 1 || err != nil {
+		t.Error("Unexpected result:", first, last, err)
+		return
+	}
+}
+
+type failingSyncStorage struct {
+	graphstorage.Storage
+	err     error
+	pending uint64
+}
+
+func (fs *failingSyncStorage) WaitDurable() error {
+	return fs.err
+}
+
+func (fs *failingSyncStorage) TagDurable(tag uint64) {
+	if fs.err != nil && fs.pending == 0 {
+		fs.pending = tag
+	}
+}
+
+func (fs *failingSyncStorage) PendingTag() uint64 {
+	return fs.pending
+}
+
+func (fs *failingSyncStorage) DurableNotify() <-chan struct{} {
+	return nil
+}
+
+func TestChangeLogErrors(t *testing.T) {
+	fs := &failingSyncStorage{graphstorage.NewMemoryGraphStorage("mystorage"), nil, 0}
+	gm := NewGraphManager(fs)
+
+	gm.EnableChangeLog(0)
+
+	// Changes which were written are in the change log even if a rule fails
+
+	gm.SetGraphRule(&TestRule{false, true, false, []int{EventNodeCreated, EventNodeDeleted,
+		EventEdgeCreated, EventEdgeDeleted}})
+
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne"}))
+	gm.StoreNode("main", newHistoryTestNode("2", map[string]interface{}{"name": "Hans"}))
+	gm.StoreEdge("main", newHistoryTestEdge("a", "1", "2", 2001))
+
+	if _, err := gm.RemoveEdge("main", "a", "Knows"); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "2", "Person"); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	if res, err := changeSetsString(gm, 0); err != nil || res != `
+1: store node main/Person/1 Anne
+2: store node main/Person/2 Hans
+3: store edge main/Knows/a <nil>
+4: remove edge main/Knows/a <nil>
+5: remove node main/Person/2 Hans`[1:] {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Change sets stay hidden once a sync failed
+
+	fs.err = errors.New("testerror")
+
+	if err := gm.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Annie"})); err == nil ||
+		err.Error() != "testerror" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, last, err := gm.ChangeLogRange(); last != 5 || err != nil {
+		t.Error("Unexpected result:", last, err)
+		return
+	}
+
+	if it, _ := gm.ChangeLogIterator(6); it.Next() != nil || it.Error() != nil {
+		t.Error("Change set should not be visible")
+		return
+	}
+
+	fs.err = nil
+
+	gm.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anna"}))
+
+	if _, last, err := gm.ChangeLogRange(); last != 5 || err != nil {
+		t.Error("Unexpected result:", last, err)
+		return
+	}
+
+	// The state is kept by the storage and not by the GraphManager
+
+	if _, last, err := NewGraphManager(fs).ChangeLogRange(); last != 5 || err != nil {
+		t.Error("Unexpected result:", last, err)
+		return
+	}
+}
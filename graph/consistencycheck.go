@@ -0,0 +1,204 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+Check checks the consistency of all datastores which hold graph data. Each
+datastore is checked by its storage manager (page lists and slot tables). After
+that all HTrees of the datastore are walked. Every stored object must belong
+to exactly one HTree (large attribute values belong to the HTree which
+references them). Objects which are not part of any HTree are reported as
+orphaned. If the repair flag is set then the storage managers try to repair
+their data and orphaned objects are freed (only if all HTrees could be walked
+without problems). Datastores which do not support consistency checks (e.g.
+memory only storage) are skipped.
+*/
+func (gm *Manager) Check(repair bool) ([]*storage.CheckResult, error) {
+	var ret []*storage.CheckResult
+
+	// Take exclusive access to the graph storage
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	check := func(name string, roots ...int) error {
+		res, err := gm.checkStorage(name, repair, roots...)
+		if res != nil {
+			ret = append(ret, res)
+		}
+		return err
+	}
+
+	for _, part := range gm.Partitions() {
+
+		for _, kind := range gm.NodeKinds() {
+
+			if err := check(part+kind+StorageSuffixNodes,
+				RootIDNodeHTree, RootIDNodeHTreeSecond); err != nil {
+				return ret, err
+			}
+
+			if err := check(part+kind+StorageSuffixNodesIndex, RootIDNodeHTree); err != nil {
+				return ret, err
+			}
+
+			if err := check(part+kind+StorageSuffixNodesUnique, RootIDNodeHTree); err != nil {
+				return ret, err
+			}
+
+			if err := check(part+kind+StorageSuffixNodesHistory, RootIDNodeHTree); err != nil {
+				return ret, err
+			}
+		}
+
+		for _, kind := range gm.EdgeKinds() {
+
+			if err := check(part+kind+StorageSuffixEdges, RootIDNodeHTree); err != nil {
+				return ret, err
+			}
+
+			if err := check(part+kind+StorageSuffixEdgesIndex, RootIDNodeHTree); err != nil {
+				return ret, err
+			}
+
+			if err := check(part+kind+StorageSuffixEdgesHistory, RootIDNodeHTree); err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	if err := check(StorageChangeLog, RootIDNodeHTree); err != nil {
+		return ret, err
+	}
+
+	return ret, nil
+}
+
+/*
+checkStorage checks a single datastore which contains HTrees at the given roots.
+*/
+func (gm *Manager) checkStorage(name string, repair bool, roots ...int) (*storage.CheckResult, error) {
+
+	sm := gm.gs.StorageManager(name, false)
+	if sm == nil {
+		return nil, nil
+	}
+
+	checker, ok := sm.(storage.Checker)
+	if !ok {
+		return nil, nil
+	}
+
+	res, err := checker.Check(repair)
+	if err != nil {
+		return res, &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+	}
+
+	used := make(map[uint64]bool)
+	for _, loc := range res.Locations {
+		used[loc] = true
+	}
+
+	treeProblems := 0
+	reachable := make(map[uint64]bool)
+
+	addProblem := func(format string, a ...interface{}) {
+		res.Problems = append(res.Problems, fmt.Sprintf(format, a...))
+		treeProblems++
+	}
+
+	for _, root := range roots {
+		loc := sm.Root(root)
+
+		if loc == 0 {
+			continue
+		} else if !used[loc] {
+			addProblem("HTree root %v points to an unused location %v", root, loc)
+			continue
+		}
+
+		tree, err := hash.LoadHTree(sm, loc)
+		if err != nil {
+			addProblem("HTree root %v could not be loaded: %v", root, err)
+			continue
+		}
+
+		locs, problems := tree.Check()
+
+		for _, p := range problems {
+			addProblem("%v", p)
+		}
+
+		for _, l := range locs {
+			if reachable[l] {
+				addProblem("HTree node %v is part of more than one HTree", l)
+			} else if !used[l] {
+				addProblem("HTree node %v is stored in an unused location", l)
+			}
+
+			reachable[l] = true
+		}
+
+		// Large objects of attribute values belong to the HTree which
+		// references them
+
+		locs, err = largeValueLocations(tree)
+		if err != nil {
+			addProblem("Large values of HTree root %v could not be read: %v", root, err)
+		}
+
+		for _, l := range locs {
+			if reachable[l] {
+				addProblem("Large value object %v is referenced more than once", l)
+			} else if !used[l] {
+				addProblem("Large value object %v is stored in an unused location", l)
+			}
+
+			reachable[l] = true
+		}
+	}
+
+	var orphaned []uint64
+
+	for _, loc := range res.Locations {
+		if !reachable[loc] {
+			res.Problems = append(res.Problems,
+				fmt.Sprintf("Stored object %v is not part of any HTree", loc))
+			orphaned = append(orphaned, loc)
+		}
+	}
+
+	// Orphaned objects are only removed if all trees are intact
+
+	if repair && len(orphaned) > 0 && treeProblems == 0 {
+		res.Repaired = true
+
+		for _, loc := range orphaned {
+			if err := sm.Free(loc); err != nil {
+				return res, &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+			}
+		}
+
+		if err := sm.Flush(); err != nil {
+			return res, &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,151 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestCheck(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir7, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	for i := 0; i < 20; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "mykind")
+		node.SetAttr("name", fmt.Sprint("Node", i))
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	edge := data.NewGraphEdge()
+
+	edge.SetAttr("key", "abc")
+	edge.SetAttr("kind", "myedge")
+
+	edge.SetAttr(data.EdgeEnd1Key, "1")
+	edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd1Role, "node1")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+
+	edge.SetAttr(data.EdgeEnd2Key, "2")
+	edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd2Role, "node2")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	if err := gm.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := gm.Check(false)
+	if err != nil || len(res) != 4 {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	for _, r := range res {
+		if len(r.Problems) != 0 || r.Repaired || len(r.Locations) == 0 {
+			t.Error("Unexpected check result:", r)
+			return
+		}
+	}
+
+	// Store an object which is not part of any HTree
+
+	sm := dgs.StorageManager("mainmykind"+StorageSuffixNodes, false)
+
+	loc, err := sm.Insert("orphan")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = gm.Check(false)
+	if err != nil || res[0].Name != GraphManagerTestDBDir7+"/mainmykind.nodes" ||
+		fmt.Sprint(res[0].Problems) != fmt.Sprintf("[Stored object %v is not part of any HTree]", loc) {
+		t.Error("Unexpected check result:", res[0], err)
+		return
+	}
+
+	res, err = gm.Check(true)
+	if err != nil || !res[0].Repaired {
+		t.Error("Unexpected check result:", res[0], err)
+		return
+	}
+
+	res, err = gm.Check(false)
+	if err != nil || len(res[0].Problems) != 0 {
+		t.Error("Unexpected check result:", res[0], err)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", "5", "mykind"); err != nil || n.Attr("name") != "Node5" {
+		t.Error("Unexpected fetch result:", n, err)
+		return
+	}
+
+	// Break the root of the HTree
+
+	root := sm.Root(RootIDNodeHTreeSecond)
+	sm.SetRoot(RootIDNodeHTreeSecond, 1)
+
+	res, err = gm.Check(true)
+	if err != nil || res[0].Repaired ||
+		res[0].Problems[0] != "HTree root 3 points to an unused location 1" {
+		t.Error("Unexpected check result:", res[0], err)
+		return
+	}
+
+	sm.SetRoot(RootIDNodeHTreeSecond, root)
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Memory only storage cannot be checked
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm = NewGraphManager(mgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "mykind")
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = gm.Check(false)
+	if err != nil || len(res) != 0 {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+}
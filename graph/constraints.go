@@ -0,0 +1,553 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+/*
+UniqueConstraint models a unique constraint on one or more attributes of a
+node kind. No two nodes of the kind in a partition can have the same values
+for all attributes of a constraint. Nodes which do not have all attributes
+of a constraint are not constrained.
+*/
+type UniqueConstraint struct {
+	Name  string   `json:"name"`  // Name of the constraint
+	Attrs []string `json:"attrs"` // Attributes which are constrained
+}
+
+/*
+lookupKey returns the key of a node in the lookup tree of this constraint.
+Values of different types (e.g. the string "1" and the number 1) never have
+the same key. Returns false if the node does not have all attributes of the
+constraint.
+*/
+func (c *UniqueConstraint) lookupKey(node data.Node) (string, bool) {
+	var buf bytes.Buffer
+
+	buf.WriteString(c.Name)
+
+	for _, attr := range c.Attrs {
+		val := node.Attr(attr)
+		if val == nil {
+			return "", false
+		}
+
+		// Prefix each value with its type and length so composite values
+		// cannot be confused
+
+		sval := lookupValue(val)
+		buf.WriteString(fmt.Sprintf("\x00%v:%v:%v", data.ValueType(val), len(sval), sval))
+	}
+
+	return buf.String(), true
+}
+
+/*
+lookupValue returns a normalized string representation of an attribute value.
+Equal values have the same representation independent of how they were decoded.
+*/
+func lookupValue(val interface{}) string {
+
+	switch v := val.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	}
+
+	if vtype := data.ValueType(val); vtype == data.ValueTypeList || vtype == data.ValueTypeMap {
+		if res, err := json.Marshal(val); err == nil {
+			return string(res)
+		}
+	}
+
+	return fmt.Sprint(val)
+}
+
+/*
+conflictError returns an error which describes a conflict between a given node
+and an existing node.
+*/
+func (c *UniqueConstraint) conflictError(node data.Node, other interface{}) error {
+	vals := make([]string, 0, len(c.Attrs))
+
+	for _, attr := range c.Attrs {
+		vals = append(vals, fmt.Sprintf("%v=%v", attr, node.Attr(attr)))
+	}
+
+	return &util.GraphError{Type: util.ErrUnique, Detail: fmt.Sprintf(
+		"Node %v (%v) conflicts with node %v on unique constraint %v (%v)",
+		node.Key(), node.Kind(), other, c.Name, strings.Join(vals, ", "))}
+}
+
+/*
+AddUniqueConstraint adds a unique constraint to a node kind in a partition.
+Existing nodes are added to the lookup of the constraint. An error is returned
+if existing nodes already violate the constraint.
+*/
+func (gm *Manager) AddUniqueConstraint(part string, kind string, constraint *UniqueConstraint) error {
+
+	invalidError := func(detail string) error {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: detail}
+	}
+
+	if !stringutil.IsAlphaNumeric(constraint.Name) {
+		return invalidError(fmt.Sprintf(
+			"Unique constraint name %v is not alphanumeric - can only contain [a-zA-Z0-9_]", constraint.Name))
+	}
+
+	if len(constraint.Attrs) == 0 {
+		return invalidError(fmt.Sprintf("Unique constraint %v has no attributes", constraint.Name))
+	}
+
+	for _, attr := range constraint.Attrs {
+		if attr == "" {
+			return invalidError(fmt.Sprintf("Unique constraint %v contains an empty attribute name",
+				constraint.Name))
+		}
+	}
+
+	// Get the HTrees which store the nodes and the lookup
+
+	attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil {
+		return err
+	}
+
+	uht, err := gm.getNodeUniqueHTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	// Take writer lock
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	constraints, err := gm.readUniqueConstraints(part, kind)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range constraints {
+		if c.Name == constraint.Name {
+			return invalidError(fmt.Sprintf("Unique constraint %v already exists for kind %v in partition %v",
+				constraint.Name, kind, part))
+		}
+	}
+
+	// Collect the lookup entries of all existing nodes before anything is written
+
+	entries := make(map[string]string)
+
+	if attht != nil {
+		it := hash.NewHTreeIterator(attht)
+
+		for it.HasNext() {
+			k, _ := it.Next()
+
+			if it.LastError != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+			} else if !strings.HasPrefix(string(k), PrefixNSAttrs) {
+				continue
+			}
+
+			node, err := gm.readNode(string(k[len(PrefixNSAttrs):]), kind, constraint.Attrs, attht, valht)
+			if err != nil {
+				return err
+			} else if node == nil {
+				continue
+			}
+
+			if ukey, ok := constraint.lookupKey(node); ok {
+				if other, ok := entries[ukey]; ok {
+					return constraint.conflictError(node, other)
+				}
+				entries[ukey] = node.Key()
+			}
+		}
+	}
+
+	for ukey, key := range entries {
+		if _, err := uht.Put([]byte(ukey), key); err != nil {
+			gm.rollbackNodeUnique(part, kind)
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+	}
+
+	if err := gm.writeUniqueConstraints(part, kind, append(constraints, constraint)); err != nil {
+		return err
+	}
+
+	return gm.flushNodeUnique(part, kind)
+}
+
+/*
+RemoveUniqueConstraint removes a unique constraint from a node kind in a
+partition. Returns if a constraint was removed.
+*/
+func (gm *Manager) RemoveUniqueConstraint(part string, kind string, name string) (bool, error) {
+
+	// Get the HTree which stores the lookup
+
+	uht, err := gm.getNodeUniqueHTree(part, kind, false)
+	if err != nil {
+		return false, err
+	}
+
+	// Take writer lock
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	constraints, err := gm.readUniqueConstraints(part, kind)
+	if err != nil {
+		return false, err
+	}
+
+	for i, c := range constraints {
+
+		if c.Name != name {
+			continue
+		}
+
+		// Remove all lookup entries of the constraint
+
+		if uht != nil {
+			var keys [][]byte
+
+			it := hash.NewHTreeIterator(uht)
+
+			for it.HasNext() {
+				if k, _ := it.Next(); strings.HasPrefix(string(k), name+"\x00") {
+					keys = append(keys, k)
+				}
+			}
+
+			if it.LastError != nil {
+				return false, &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+			}
+
+			for _, k := range keys {
+				if _, err := uht.Remove(k); err != nil {
+					gm.rollbackNodeUnique(part, kind)
+					return false, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+				}
+			}
+		}
+
+		if err := gm.writeUniqueConstraints(part, kind,
+			append(constraints[:i:i], constraints[i+1:]...)); err != nil {
+			return false, err
+		}
+
+		return true, gm.flushNodeUnique(part, kind)
+	}
+
+	return false, nil
+}
+
+/*
+UniqueConstraints returns all unique constraints of a node kind in a partition.
+*/
+func (gm *Manager) UniqueConstraints(part string, kind string) ([]*UniqueConstraint, error) {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return gm.readUniqueConstraints(part, kind)
+}
+
+/*
+FetchNodeByUnique fetches a node by the values of a unique constraint. The
+values must be given in the order of the constraint attributes and must have
+the type of the stored values. Returns nil if no node holds the given values.
+*/
+func (gm *Manager) FetchNodeByUnique(part string, kind string, name string,
+	values ...interface{}) (data.Node, error) {
+
+	// Get the HTrees which store the node and the lookup
+
+	attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || attht == nil || valht == nil {
+		return nil, err
+	}
+
+	uht, err := gm.getNodeUniqueHTree(part, kind, false)
+	if err != nil || uht == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	constraints, err := gm.readUniqueConstraints(part, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range constraints {
+
+		if c.Name != name {
+			continue
+		}
+
+		if len(values) != len(c.Attrs) {
+			return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprintf(
+				"Unique constraint %v requires %v values", name, len(c.Attrs))}
+		}
+
+		node := data.NewGraphNode()
+		for i, attr := range c.Attrs {
+			node.SetAttr(attr, values[i])
+		}
+
+		ukey, ok := c.lookupKey(node)
+		if !ok {
+			return nil, nil
+		}
+
+		key, err := uht.Get([]byte(ukey))
+		if err != nil {
+			return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		} else if key == nil {
+			return nil, nil
+		}
+
+		return gm.readNode(key.(string), kind, nil, attht, valht)
+	}
+
+	return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprintf(
+		"Unknown unique constraint %v for kind %v in partition %v", name, kind, part)}
+}
+
+/*
+readUniqueConstraints reads the unique constraints of a node kind in a
+partition from the MainDB.
+*/
+func (gm *Manager) readUniqueConstraints(part string, kind string) ([]*UniqueConstraint, error) {
+	var constraints []*UniqueConstraint
+
+	val, ok := gm.gs.MainDB()[MainDBUniqueConstraints+part+"#"+kind]
+	if !ok {
+		return nil, nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &constraints); err != nil {
+		return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprintf(
+			"Could not read unique constraints of kind %v in partition %v: %v", kind, part, err.Error())}
+	}
+
+	return constraints, nil
+}
+
+/*
+writeUniqueConstraints writes the unique constraints of a node kind in a
+partition to the MainDB.
+*/
+func (gm *Manager) writeUniqueConstraints(part string, kind string, constraints []*UniqueConstraint) error {
+
+	if len(constraints) == 0 {
+		delete(gm.gs.MainDB(), MainDBUniqueConstraints+part+"#"+kind)
+
+	} else {
+
+		val, err := json.Marshal(constraints)
+		if err != nil {
+			return &util.GraphError{Type: util.ErrInvalidData, Detail: err.Error()}
+		}
+
+		gm.gs.MainDB()[MainDBUniqueConstraints+part+"#"+kind] = string(val)
+	}
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+checkUniqueNode checks if a node can be written without violating a unique
+constraint of its kind. Returns the stored node and the node as it will be
+stored after the write if the kind has unique constraints. It is assumed that
+the caller holds the writer lock.
+*/
+func (gm *Manager) checkUniqueNode(part string, node data.Node, onlyUpdate bool,
+	attrTree *hash.HTree, valTree *hash.HTree) (data.Node, data.Node, error) {
+
+	constraints, err := gm.readUniqueConstraints(part, node.Kind())
+	if err != nil || len(constraints) == 0 {
+		return nil, nil, err
+	}
+
+	oldnode, err := gm.readNode(node.Key(), node.Kind(), nil, attrTree, valTree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// An update might only contain some attributes
+
+	if onlyUpdate && oldnode != nil {
+		node = data.NodeMerge(oldnode, node)
+	}
+
+	return oldnode, node, gm.claimUniqueKeys(part, node, false)
+}
+
+/*
+checkUniqueNodes checks if a list of new nodes of a kind can be written
+together without violating a unique constraint of the kind. The nodes must
+have different keys. It is assumed that the caller holds the writer lock.
+*/
+func (gm *Manager) checkUniqueNodes(part string, kind string, nodes []data.Node) error {
+
+	constraints, err := gm.readUniqueConstraints(part, kind)
+	if err != nil || len(constraints) == 0 {
+		return err
+	}
+
+	for _, c := range constraints {
+		entries := make(map[string]string)
+
+		for _, node := range nodes {
+			if ukey, ok := c.lookupKey(node); ok {
+				if other, ok := entries[ukey]; ok {
+					return c.conflictError(node, other)
+				}
+				entries[ukey] = node.Key()
+			}
+		}
+	}
+
+	// The nodes must not conflict with existing lookup entries either
+
+	for _, node := range nodes {
+		if err := gm.claimUniqueKeys(part, node, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+updateUniqueKeys replaces the lookup entries of an old node with the lookup
+entries of a new node. Either node can be nil. It is assumed that the caller
+holds the writer lock.
+*/
+func (gm *Manager) updateUniqueKeys(part string, oldnode data.Node, node data.Node) error {
+
+	if oldnode != nil {
+		if err := gm.releaseUniqueKeys(part, oldnode); err != nil {
+			return err
+		}
+	}
+
+	if node != nil {
+		return gm.claimUniqueKeys(part, node, true)
+	}
+
+	return nil
+}
+
+/*
+releaseUniqueKeys removes the lookup entries of a node. It is assumed that
+the caller holds the writer lock.
+*/
+func (gm *Manager) releaseUniqueKeys(part string, node data.Node) error {
+
+	constraints, err := gm.readUniqueConstraints(part, node.Kind())
+	if err != nil || len(constraints) == 0 {
+		return err
+	}
+
+	uht, err := gm.getNodeUniqueHTree(part, node.Kind(), true)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range constraints {
+
+		ukey, ok := c.lookupKey(node)
+		if !ok {
+			continue
+		}
+
+		key, err := uht.Get([]byte(ukey))
+		if err != nil {
+			return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		}
+
+		// Only remove the entry if it belongs to the node
+
+		if key == node.Key() {
+			if _, err := uht.Remove([]byte(ukey)); err != nil {
+				return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+claimUniqueKeys checks that no other node holds the values of the unique
+constraints of a given node. The lookup entries of the node are written if
+the write flag is set. It is assumed that the caller holds the writer lock.
+*/
+func (gm *Manager) claimUniqueKeys(part string, node data.Node, write bool) error {
+
+	constraints, err := gm.readUniqueConstraints(part, node.Kind())
+	if err != nil || len(constraints) == 0 {
+		return err
+	}
+
+	uht, err := gm.getNodeUniqueHTree(part, node.Kind(), true)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range constraints {
+
+		ukey, ok := c.lookupKey(node)
+		if !ok {
+			continue
+		}
+
+		key, err := uht.Get([]byte(ukey))
+		if err != nil {
+			return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		} else if key != nil && key != node.Key() {
+			return c.conflictError(node, key)
+		}
+
+		if write && key == nil {
+			if _, err := uht.Put([]byte(ukey), node.Key()); err != nil {
+				return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,521 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+func newUniqueTestNode(key string, attrs map[string]interface{}) data.Node {
+	node := data.NewGraphNode()
+	node.SetAttr("key", key)
+	node.SetAttr("kind", "Person")
+	for k, v := range attrs {
+		node.SetAttr(k, v)
+	}
+	return node
+}
+
+func TestUniqueConstraints(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	for _, test := range []struct {
+		part       string
+		constraint *UniqueConstraint
+		err        string
+	}{
+		{"main", &UniqueConstraint{"my email", []string{"email"}},
+			"GraphError: Invalid data (Unique constraint name my email is not alphanumeric - can only contain [a-zA-Z0-9_])"},
+		{"main", &UniqueConstraint{"email", nil},
+			"GraphError: Invalid data (Unique constraint email has no attributes)"},
+		{"main", &UniqueConstraint{"email", []string{""}},
+			"GraphError: Invalid data (Unique constraint email contains an empty attribute name)"},
+		{"my part", &UniqueConstraint{"email", []string{"email"}},
+			"GraphError: Invalid data (Partition name my part is not alphanumeric - can only contain [a-zA-Z0-9_])"},
+	} {
+		if err := gm.AddUniqueConstraint(test.part, "Person", test.constraint); err == nil || err.Error() != test.err {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	// Existing nodes which violate a constraint prevent the constraint
+
+	gm.StoreNode("main", newUniqueTestNode("1", map[string]interface{}{"email": "a@b.c", "first": "Anne"}))
+	gm.StoreNode("main", newUniqueTestNode("2", map[string]interface{}{"email": "a@b.c", "first": "Hans"}))
+
+	if err := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"email", []string{"email"}}); err == nil ||
+		(err.Error() != "GraphError: Unique constraint violation (Node 1 (Person) conflicts with node 2 on unique constraint email (email=a@b.c))" &&
+			err.Error() != "GraphError: Unique constraint violation (Node 2 (Person) conflicts with node 1 on unique constraint email (email=a@b.c))") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res, err := gm.UniqueConstraints("main", "Person"); len(res) != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	gm.StoreNode("main", newUniqueTestNode("2", map[string]interface{}{"email": "x@y.z", "first": "Hans"}))
+
+	if err := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"email", []string{"email"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"email", []string{"email"}}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unique constraint email already exists for kind Person in partition main)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"name", []string{"first", "last"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := gm.UniqueConstraints("main", "Person"); err != nil || len(res) != 2 ||
+		res[0].Name != "email" || fmt.Sprint(res[1].Attrs) != "[first last]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Existing nodes can be found through the lookup
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "x@y.z"); err != nil || n.Key() != "2" ||
+		n.Attr("first") != "Hans" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "foo"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if _, err := gm.FetchNodeByUnique("main", "Person", "name", "Hans"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unique constraint name requires 2 values)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := gm.FetchNodeByUnique("main", "Person", "foo", "Hans"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown unique constraint foo for kind Person in partition main)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Violating nodes are rejected
+
+	if err := gm.StoreNode("main", newUniqueTestNode("3", map[string]interface{}{"email": "x@y.z"})); err == nil ||
+		err.Error() != "GraphError: Unique constraint violation (Node 3 (Person) conflicts with node 2 on unique constraint email (email=x@y.z))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.UpdateNode("main", newUniqueTestNode("1", map[string]interface{}{"email": "x@y.z"})); err == nil ||
+		err.Error() != "GraphError: Unique constraint violation (Node 1 (Person) conflicts with node 2 on unique constraint email (email=x@y.z))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "1", "Person"); n.Attr("email") != "a@b.c" || gm.NodeCount("Person") != 2 {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Storing a node with its own values is fine
+
+	if err := gm.UpdateNode("main", newUniqueTestNode("2", map[string]interface{}{"email": "x@y.z", "age": 42})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Composite constraints need all attributes - nodes without all attributes are not constrained
+
+	if err := gm.UpdateNode("main", newUniqueTestNode("1", map[string]interface{}{"last": "Meyer"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUniqueTestNode("3", map[string]interface{}{"first": "Anne"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.UpdateNode("main", newUniqueTestNode("3", map[string]interface{}{"last": "Meyer"})); err == nil ||
+		err.Error() != "GraphError: Unique constraint violation (Node 3 (Person) conflicts with node 1 on unique constraint name (first=Anne, last=Meyer))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.UpdateNode("main", newUniqueTestNode("3", map[string]interface{}{"last": "Schmidt"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "name", "Anne", "Schmidt"); err != nil || n.Key() != "3" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Changed or removed values are released
+
+	if err := gm.UpdateNode("main", newUniqueTestNode("1", map[string]interface{}{"email": "new@b.c"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "a@b.c"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "2", "Person"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUniqueTestNode("4", map[string]interface{}{"email": "x@y.z"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Other partitions are not constrained
+
+	if err := gm.StoreNode("test", newUniqueTestNode("5", map[string]interface{}{"email": "x@y.z"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Constraints are checked in transactions
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newUniqueTestNode("6", map[string]interface{}{"email": "x@y.z"}))
+
+	if err := trans.Commit(); err == nil ||
+		err.Error() != "GraphError: Unique constraint violation (Node 6 (Person) conflicts with node 4 on unique constraint email (email=x@y.z))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Remove constraints
+
+	if ok, err := gm.RemoveUniqueConstraint("main", "Person", "email"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := gm.RemoveUniqueConstraint("main", "Person", "email"); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUniqueTestNode("6", map[string]interface{}{"email": "x@y.z"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "name", "Anne", "Schmidt"); err != nil || n.Key() != "3" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// The lookup is part of the tree statistics
+
+	stats, err := gm.TreeStats(0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	found := false
+	for _, s := range stats {
+		if s.Partition == "main" && s.Tree == TreeStatsUnique {
+			if s.Stats.TotalElements() != 2 {
+				t.Error("Unexpected result:", s.Stats)
+				return
+			}
+			found = true
+		}
+	}
+
+	if !found {
+		t.Error("Unique constraint lookup tree missing in statistics")
+		return
+	}
+
+	// Test flushing errors
+
+	graphstorage.MgsRetFlushMain = errors.New("testerror")
+
+	err1 := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"age", []string{"age"}})
+	_, err2 := gm.RemoveUniqueConstraint("main", "Person", "name")
+
+	graphstorage.MgsRetFlushMain = nil
+
+	if err1 == nil || err1.Error() != "GraphError: Failed to flush changes (testerror)" ||
+		err2 == nil || err2.Error() != "GraphError: Failed to flush changes (testerror)" {
+		t.Error("Unexpected result:", err1, err2)
+		return
+	}
+
+	// Invalid constraints in the MainDB are reported and fail any write of the kind
+
+	mgs.MainDB()[MainDBUniqueConstraints+"main#Person"] = "["
+
+	readErr := "GraphError: Invalid data (Could not read unique constraints of kind Person " +
+		"in partition main: unexpected end of JSON input)"
+
+	if res, err := gm.UniqueConstraints("main", "Person"); res != nil || err == nil || err.Error() != readErr {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUniqueTestNode("5", map[string]interface{}{"email": "e@f.g"})); err == nil ||
+		err.Error() != readErr {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.BulkLoadNodes("main", []data.Node{
+		newUniqueTestNode("5", map[string]interface{}{"email": "e@f.g"})}); err == nil || err.Error() != readErr {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestUniqueConstraintsTypedValues(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	if err := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"id", []string{"id"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Values of different types do not conflict
+
+	for i, val := range []interface{}{"1", 1, 1.5, "1.5", []byte("ab"), []string{"a", "b"},
+		[]string{"a b"}, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)} {
+
+		if err := gm.StoreNode("main", newUniqueTestNode(fmt.Sprint(i), map[string]interface{}{"id": val})); err != nil {
+			t.Error(i, err)
+			return
+		}
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "id", "1"); err != nil || n.Key() != "0" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "id", 1); err != nil || n.Key() != "1" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "id", []string{"a b"}); err != nil || n.Key() != "6" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Equal values conflict even if they have a different representation
+
+	for _, val := range []interface{}{int64(1), json.Number("1"), "1",
+		time.Date(2020, 1, 2, 5, 4, 5, 0, time.FixedZone("CEST", 2*60*60))} {
+
+		if err := gm.StoreNode("main", newUniqueTestNode("x", map[string]interface{}{"id": val})); err == nil ||
+			err.(*util.GraphError).Type != util.ErrUnique {
+			t.Error("Unexpected result:", val, err)
+			return
+		}
+	}
+}
+
+func TestUniqueConstraintsBulkLoad(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	if err := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"email", []string{"email"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Bulk loaded nodes must not conflict with each other
+
+	err := gm.BulkLoadNodes("main", []data.Node{
+		newUniqueTestNode("1", map[string]interface{}{"email": "a@b.c"}),
+		newUniqueTestNode("2", map[string]interface{}{"email": "x@y.z"}),
+		newUniqueTestNode("3", map[string]interface{}{"email": "a@b.c"}),
+	})
+
+	if err == nil || err.Error() != "GraphError: Unique constraint violation "+
+		"(Node 3 (Person) conflicts with node 1 on unique constraint email (email=a@b.c))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := gm.NodeCount("Person"); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "a@b.c"); n != nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// A node which replaces an earlier node with the same key keeps its values
+
+	err = gm.BulkLoadNodes("main", []data.Node{
+		newUniqueTestNode("1", map[string]interface{}{"email": "a@b.c"}),
+		newUniqueTestNode("1", map[string]interface{}{"email": "x@y.z"}),
+		newUniqueTestNode("2", map[string]interface{}{"email": "a@b.c"}),
+	})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "a@b.c"); err != nil || n.Key() != "2" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Loaded nodes are checked when nodes are stored normally
+
+	if err := gm.StoreNode("main", newUniqueTestNode("3", map[string]interface{}{"email": "x@y.z"})); err == nil ||
+		err.(*util.GraphError).Type != util.ErrUnique {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestUniqueConstraintsTrans(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	// Use disk storage since violating transactions are rolled back
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir16, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm := NewGraphManager(dgs)
+
+	if err := gm.AddUniqueConstraint("main", "Person", &UniqueConstraint{"email", []string{"email"}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.StoreNode("main", newUniqueTestNode("1", map[string]interface{}{"email": "a@b.c"}))
+	gm.StoreNode("main", newUniqueTestNode("2", map[string]interface{}{"email": "x@y.z"}))
+
+	// Two new nodes with the same values
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newUniqueTestNode("3", map[string]interface{}{"email": "new@b.c"}))
+	trans.StoreNode("main", newUniqueTestNode("4", map[string]interface{}{"email": "new@b.c"}))
+	trans.UpdateNode("main", newUniqueTestNode("1", map[string]interface{}{"email": "other@b.c"}))
+
+	if err := trans.Commit(); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	// The whole transaction was rolled back
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "new@b.c"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "a@b.c"); err != nil || n.Key() != "1" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if res := gm.NodeCount("Person"); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Values can be swapped inside a transaction
+
+	trans = NewGraphTrans(gm)
+	trans.UpdateNode("main", newUniqueTestNode("1", map[string]interface{}{"email": "x@y.z"}))
+	trans.UpdateNode("main", newUniqueTestNode("2", map[string]interface{}{"email": "a@b.c"}))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "x@y.z"); err != nil || n.Key() != "1" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Removed nodes release their values inside a transaction
+
+	trans = NewGraphTrans(gm)
+	trans.RemoveNode("main", "1", "Person")
+	trans.StoreNode("main", newUniqueTestNode("3", map[string]interface{}{"email": "x@y.z"}))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := gm.FetchNodeByUnique("main", "Person", "email", "x@y.z"); err != nil || n.Key() != "3" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Invalid constraints in the MainDB fail the transaction
+
+	dgs.MainDB()[MainDBUniqueConstraints+"main#Person"] = "["
+
+	trans = NewGraphTrans(gm)
+	trans.StoreNode("main", newUniqueTestNode("5", map[string]interface{}{"email": "e@f.g"}))
+
+	if err := trans.Commit(); err == nil || !strings.Contains(err.Error(),
+		"Could not read unique constraints of kind Person in partition main") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", "5", "Person"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if res := gm.NodeCount("Person"); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
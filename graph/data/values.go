@@ -0,0 +1,350 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+
+	// Register all value types which can be stored inside an interface{}
+
+	gob.Register(time.Time{})
+	gob.Register(make([]interface{}, 0))
+	gob.Register(make(map[string]interface{}))
+}
+
+/*
+Value types of node attributes
+*/
+const (
+	ValueTypeInt       = "int64"     // Whole numbers (all Go integer types)
+	ValueTypeFloat     = "float64"   // Floating point numbers
+	ValueTypeBool      = "bool"      // Boolean values
+	ValueTypeString    = "string"    // Strings
+	ValueTypeTimestamp = "timestamp" // Points in time (time.Time)
+	ValueTypeBytes     = "bytes"     // Binary data ([]byte)
+	ValueTypeList      = "list"      // Lists of values
+	ValueTypeMap       = "map"       // Maps from strings to values
+	ValueTypeAny       = "any"       // Values of different types
+)
+
+/*
+JSON keys of objects which hold values which cannot be represented in plain
+JSON (see EncodeJSONValue)
+*/
+const (
+	JSONTypeTimestamp = "$timestamp" // Timestamp as RFC 3339 string
+	JSONTypeBytes     = "$bytes"     // Binary data as base64 encoded string
+)
+
+/*
+ValueType returns the value type of a given value. Returns ValueTypeAny if
+the value has none of the known types.
+*/
+func ValueType(val interface{}) string {
+
+	switch val.(type) {
+	case string:
+		return ValueTypeString
+	case bool:
+		return ValueTypeBool
+	case time.Time:
+		return ValueTypeTimestamp
+	case []byte:
+		return ValueTypeBytes
+	case json.Number:
+		if _, err := val.(json.Number).Int64(); err == nil {
+			return ValueTypeInt
+		}
+		return ValueTypeFloat
+	}
+
+	if val == nil {
+		return ValueTypeAny
+	}
+
+	switch reflect.TypeOf(val).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ValueTypeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if reflect.ValueOf(val).Uint() > math.MaxInt64 {
+			return ValueTypeFloat
+		}
+		return ValueTypeInt
+	case reflect.Float32, reflect.Float64:
+		return ValueTypeFloat
+	case reflect.Slice, reflect.Array:
+		return ValueTypeList
+	case reflect.Map:
+		return ValueTypeMap
+	}
+
+	return ValueTypeAny
+}
+
+/*
+NormalizeValue converts a given value into the canonical Go type of its value
+type: all integers become int64, all floating point numbers become float64,
+lists become []interface{} and maps become map[string]interface{}. Unsigned
+integers which do not fit into an int64 become float64 (like JSON numbers).
+Values which have none of the known types are returned unchanged.
+*/
+func NormalizeValue(val interface{}) interface{} {
+
+	switch v := val.(type) {
+	case string, bool, int64, float64, time.Time, []byte:
+		return val
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+
+	if val == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(val)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return float64(u)
+		}
+		return int64(u)
+
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+
+	case reflect.Slice, reflect.Array:
+		ret := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			ret[i] = NormalizeValue(rv.Index(i).Interface())
+		}
+		return ret
+
+	case reflect.Map:
+		ret := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			ret[fmt.Sprint(k.Interface())] = NormalizeValue(rv.MapIndex(k).Interface())
+		}
+		return ret
+	}
+
+	return val
+}
+
+/*
+CompareValues compares two values by their value type. Numbers are compared
+numerically (integers without loss of precision) and timestamps chronologically
+- a timestamp can also be compared to a RFC 3339 string. Returns -1, 0 or 1
+and true if the values could be compared. Returns false if the values do
+not have comparable types.
+*/
+func CompareValues(val1 interface{}, val2 interface{}) (int, bool) {
+
+	t1 := ValueType(val1)
+	t2 := ValueType(val2)
+
+	// Timestamps
+
+	toTime := func(val interface{}, t string) (time.Time, bool) {
+		if t == ValueTypeTimestamp {
+			return val.(time.Time), true
+		} else if t == ValueTypeString {
+			ts, err := time.Parse(time.RFC3339Nano, val.(string))
+			return ts, err == nil
+		}
+		return time.Time{}, false
+	}
+
+	if t1 == ValueTypeTimestamp || t2 == ValueTypeTimestamp {
+		ts1, ok1 := toTime(val1, t1)
+		ts2, ok2 := toTime(val2, t2)
+
+		if !ok1 || !ok2 {
+			return 0, false
+		} else if ts1.Before(ts2) {
+			return -1, true
+		} else if ts1.After(ts2) {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	// Numbers
+
+	if t1 == ValueTypeInt && t2 == ValueTypeInt {
+		n1 := NormalizeValue(val1).(int64)
+		n2 := NormalizeValue(val2).(int64)
+
+		if n1 < n2 {
+			return -1, true
+		} else if n1 > n2 {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	toFloat := func(val interface{}) float64 {
+		if i, ok := val.(int64); ok {
+			return float64(i)
+		}
+		return val.(float64)
+	}
+
+	if (t1 == ValueTypeInt || t1 == ValueTypeFloat) && (t2 == ValueTypeInt || t2 == ValueTypeFloat) {
+		n1 := toFloat(NormalizeValue(val1))
+		n2 := toFloat(NormalizeValue(val2))
+
+		if n1 < n2 {
+			return -1, true
+		} else if n1 > n2 {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	// Binary data
+
+	if t1 == ValueTypeBytes && t2 == ValueTypeBytes {
+		return bytes.Compare(val1.([]byte), val2.([]byte)), true
+	}
+
+	return 0, false
+}
+
+/*
+EncodeJSONValue converts a value into a value which can be encoded as JSON
+without losing its value type. Timestamps and binary data are converted into
+objects with a single JSONTypeTimestamp or JSONTypeBytes key and whole
+floating point numbers keep a decimal point so they are not mistaken for
+integers.
+*/
+func EncodeJSONValue(val interface{}) interface{} {
+
+	switch v := NormalizeValue(val).(type) {
+	case time.Time:
+		return map[string]interface{}{JSONTypeTimestamp: v.Format(time.RFC3339Nano)}
+
+	case []byte:
+		return map[string]interface{}{JSONTypeBytes: base64.StdEncoding.EncodeToString(v)}
+
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e21 {
+			return json.Number(strconv.FormatFloat(v, 'f', 1, 64))
+		}
+		return v
+
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, lv := range v {
+			ret[i] = EncodeJSONValue(lv)
+		}
+		return ret
+
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for k, mv := range v {
+			ret[k] = EncodeJSONValue(mv)
+		}
+		return ret
+
+	default:
+		return v
+	}
+}
+
+/*
+DecodeJSONValue converts a value which was decoded from JSON (ideally with
+a decoder which uses json.Number for numbers) back into a typed value. This
+is the reverse operation of EncodeJSONValue. Numbers without decimal point
+or exponent become int64 values.
+*/
+func DecodeJSONValue(val interface{}) interface{} {
+
+	switch v := val.(type) {
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+		}
+		f, _ := v.Float64()
+		return f
+
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, lv := range v {
+			ret[i] = DecodeJSONValue(lv)
+		}
+		return ret
+
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if s, ok := v[JSONTypeTimestamp].(string); ok {
+				if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					return ts
+				}
+			} else if s, ok := v[JSONTypeBytes].(string); ok {
+				if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+					return b
+				}
+			}
+		}
+
+		ret := make(map[string]interface{}, len(v))
+		for k, mv := range v {
+			ret[k] = DecodeJSONValue(mv)
+		}
+		return ret
+	}
+
+	return val
+}
+
+/*
+EncodeJSONData converts the data of a node with EncodeJSONValue.
+*/
+func EncodeJSONData(data map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		ret[k] = EncodeJSONValue(v)
+	}
+	return ret
+}
+
+/*
+DecodeJSONData converts the data of a node with DecodeJSONValue.
+*/
+func DecodeJSONData(data map[string]interface{}) map[string]interface{} {
+	for k, v := range data {
+		data[k] = DecodeJSONValue(v)
+	}
+	return data
+}
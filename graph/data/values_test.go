@@ -0,0 +1,268 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValueType(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	for _, test := range []struct {
+		val interface{}
+		res string
+	}{
+		{"a", ValueTypeString},
+		{true, ValueTypeBool},
+		{5, ValueTypeInt},
+		{int8(5), ValueTypeInt},
+		{uint32(5), ValueTypeInt},
+		{uint64(math.MaxInt64), ValueTypeInt},
+		{uint64(math.MaxUint64), ValueTypeFloat},
+		{int64(5), ValueTypeInt},
+		{json.Number("5"), ValueTypeInt},
+		{float32(1.5), ValueTypeFloat},
+		{1.5, ValueTypeFloat},
+		{json.Number("1.5"), ValueTypeFloat},
+		{ts, ValueTypeTimestamp},
+		{[]byte("abc"), ValueTypeBytes},
+		{[]string{"a"}, ValueTypeList},
+		{[]interface{}{1, "a"}, ValueTypeList},
+		{map[string]int{"a": 1}, ValueTypeMap},
+		{nil, ValueTypeAny},
+		{struct{}{}, ValueTypeAny},
+	} {
+		if res := ValueType(test.val); res != test.res {
+			t.Error("Unexpected result for", test.val, ":", res)
+			return
+		}
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+
+	if res := NormalizeValue(int32(5)); res != int64(5) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NormalizeValue(uint8(5)); res != int64(5) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Unsigned integers which do not fit into an int64 must not overflow
+
+	if res := NormalizeValue(uint64(math.MaxInt64)); res != int64(math.MaxInt64) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NormalizeValue(uint64(math.MaxUint64)); res != float64(math.MaxUint64) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NormalizeValue(float32(1.5)); res != 1.5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NormalizeValue(json.Number("7")); res != int64(7) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NormalizeValue(json.Number("7.5")); res != 7.5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	res := NormalizeValue(map[int][]int{1: {2, 3}})
+
+	if !reflect.DeepEqual(res, map[string]interface{}{"1": []interface{}{int64(2), int64(3)}}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NormalizeValue(nil); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	s := struct{}{}
+
+	if res := NormalizeValue(s); res != s {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	ts1 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts2 := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, test := range []struct {
+		val1 interface{}
+		val2 interface{}
+		res  int
+		ok   bool
+	}{
+		{ts1, ts2, -1, true},
+		{ts2, ts1, 1, true},
+		{ts1, ts1, 0, true},
+		{ts1, "2020-01-02T03:04:05Z", 0, true},
+		{"2019-01-02T03:04:05Z", ts1, -1, true},
+		{ts1, "foo", 0, false},
+		{ts1, 5, 0, false},
+		{int64(9007199254740993), int64(9007199254740992), 1, true},
+		{5, int8(5), 0, true},
+		{4, 5, -1, true},
+		{5, 4.5, 1, true},
+		{4.5, 5, -1, true},
+		{4.0, 4, 0, true},
+		{uint64(math.MaxUint64), int64(math.MaxInt64), 1, true},
+		{uint(5), -1, 1, true},
+		{[]byte("a"), []byte("b"), -1, true},
+		{[]byte("a"), []byte("a"), 0, true},
+		{"a", "b", 0, false},
+		{"5", 5, 0, false},
+		{true, true, 0, false},
+	} {
+		res, ok := CompareValues(test.val1, test.val2)
+
+		if res != test.res || ok != test.ok {
+			t.Error("Unexpected result for", test.val1, test.val2, ":", res, ok)
+			return
+		}
+	}
+}
+
+func TestJSONValues(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	nodeData := map[string]interface{}{
+		"key":   "123",
+		"int":   42,
+		"big":   int64(9007199254740993),
+		"float": 42.0,
+		"frac":  1.5,
+		"bool":  true,
+		"ts":    ts,
+		"bytes": []byte("abc"),
+		"list":  []interface{}{1, 2.0, ts},
+		"map": map[string]interface{}{
+			"a": 1,
+			"b": []byte{0},
+		},
+	}
+
+	enc := EncodeJSONData(nodeData)
+
+	if nodeData["ts"] != ts {
+		t.Error("Original data should not be changed")
+		return
+	}
+
+	jsonBytes, err := json.Marshal(enc)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := string(jsonBytes); res != `{"big":9007199254740993,"bool":true,"bytes":{"$bytes":"YWJj"},`+
+		`"float":42.0,"frac":1.5,"int":42,"key":"123",`+
+		`"list":[1,2.0,{"$timestamp":"2020-01-02T03:04:05.000000006Z"}],`+
+		`"map":{"a":1,"b":{"$bytes":"AA=="}},"ts":{"$timestamp":"2020-01-02T03:04:05.000000006Z"}}` {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	var dec map[string]interface{}
+
+	d := json.NewDecoder(bytes.NewBuffer(jsonBytes))
+	d.UseNumber()
+
+	if err := d.Decode(&dec); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dec = DecodeJSONData(dec)
+
+	if !reflect.DeepEqual(dec, NormalizeValue(nodeData)) {
+		t.Error("Unexpected result:", dec)
+		return
+	}
+
+	// Objects which only look similar to typed values are kept
+
+	res := DecodeJSONValue(map[string]interface{}{JSONTypeTimestamp: "foo"})
+
+	if fmt.Sprint(res) != "map[$timestamp:foo]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	res = DecodeJSONValue(map[string]interface{}{JSONTypeBytes: "*", "a": "b"})
+
+	if fmt.Sprint(res) != "map[$bytes:* a:b]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Numbers which are too big for an int64 become floats
+
+	if res := DecodeJSONValue(json.Number("92233720368547758070")); res != 92233720368547758070.0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestGobValues(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	nodeData := map[string]interface{}{
+		"int":   int64(42),
+		"float": 42.0,
+		"ts":    ts,
+		"bytes": []byte("abc"),
+		"list":  []interface{}{int64(1), ts},
+		"map":   map[string]interface{}{"a": true},
+	}
+
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(nodeData); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res map[string]interface{}
+
+	if err := gob.NewDecoder(&buf).Decode(&res); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(res, nodeData) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -23,7 +23,9 @@ Node iterator
 
 All available node keys in a partition of a given kind can be iterated by using
 a NodeKeyIterator. The manager can produce these with the NodeKeyIterator()
-function.
+function. The NodeKeyIterators() function splits the keys of a kind into
+several iterators which can be used in parallel. The position of an iterator
+can be saved with a cursor token and resumed with NodeKeyIteratorFromCursor().
 
 Fulltext search
 
@@ -49,6 +51,29 @@ Rules trigger on global graph events. The rules SystemRuleDeleteNodeEdges and
 SystemRuleUpdateNodeStats are automatically loaded when a new Manager is created.
 See the code for further details.
 
+Schemas
+
+Each node or edge kind can have an optional schema which is stored in the
+main database. A schema declares required attributes, attribute types, allowed
+edge specs and the maximum number of edges per spec. The system rule
+SystemRuleValidateSchema, which is also loaded automatically, rejects nodes and
+edges which violate the schema of their kind.
+
+Unique constraints
+
+Nodes of a kind in a partition can have unique constraints on one or more
+attributes. The attribute values of all constrained nodes are kept in a
+lookup tree. Transactions which would store two nodes with the same values
+are rolled back. Nodes which do not have all attributes of a constraint are
+not constrained.
+
+History
+
+Node and edge kinds can have a history retention. Each commit which changes a
+node or edge of such a kind stores a version of the item with the commit
+timestamp and the transaction ID. Nodes and edges can then be fetched as of a
+given time. The retention limits the number and the age of kept versions.
+
 Graph databases
 
 A graph manager handles the graph storage and provides the API for
@@ -77,6 +102,10 @@ Each node kind database stores:
 	PrefixNSAttr + node key + attr num -> value
 	(attribute value of a certain node)
 
+	Large string or byte slice values (see LargeValueThreshold) are stored
+	as chunked large objects in the same datastore. The HTree holds only a
+	reference to the large object.
+
 	PrefixNSSpecs + node key -> map[spec]<empty string>
 	(a lookup for available specs for a certain node)
 
@@ -97,6 +126,43 @@ Index database
 
 The text index managed by util/indexmanager.go. IndexQuery provides access to
 the full text search index.
+
+Unique constraint database
+
+Each node kind with unique constraints has a lookup database which stores:
+
+	constraint name + encoded attribute values -> node key
+	(the node which holds the values of a unique constraint)
+
+History database
+
+Each node or edge kind with a history retention has a history database which
+stores:
+
+	PrefixHSInfo + item key -> historyInfo{first version, last version}
+	(the range of retained versions of a certain node or edge)
+
+	PrefixHSVersion + item key + 0x00 + version -> historyEntry
+	(a single version of a certain node or edge)
+
+Change log database
+
+If the change log is enabled then all committed changes are stored in a single
+change log database:
+
+	PrefixCLInfo -> changeLogInfo{first sequence number, last sequence number}
+	(the range of retained change sets)
+
+	PrefixCLEntry + sequence number -> changeLogEntry
+	(all changes of a single commit)
+
+The change log is flushed together with the main database before the node and
+edge databases. If the process ends while a commit is flushed then the change
+log may contain changes which are missing in the node and edge databases. These
+changes should be treated as rolled back - consumers can compare change sets
+with the current state of the changed nodes and edges. Consumers which wait
+for new change sets are only notified once a commit is durable according to
+the sync policy of the graph storage.
 */
 package graph
 
@@ -160,6 +226,33 @@ MainDBEdgeCount is the MainDB entry key for an edge count
 */
 const MainDBEdgeCount = MainDBEntryPrefix + "ecnt"
 
+/*
+MainDBTreeOptions is the MainDB entry key for the HTree options of a kind
+*/
+const MainDBTreeOptions = MainDBEntryPrefix + "topt"
+
+/*
+MainDBSchema is the MainDB entry key for the schema of a kind
+*/
+const MainDBSchema = MainDBEntryPrefix + "schm"
+
+/*
+MainDBUniqueConstraints is the MainDB entry key for the unique constraints of
+a kind in a partition
+*/
+const MainDBUniqueConstraints = MainDBEntryPrefix + "uniq"
+
+/*
+MainDBHistoryRetention is the MainDB entry key for the history retention of
+a kind
+*/
+const MainDBHistoryRetention = MainDBEntryPrefix + "hist"
+
+/*
+MainDBChangeLog is the MainDB entry key for the change log settings
+*/
+const MainDBChangeLog = MainDBEntryPrefix + "clog"
+
 // Root IDs for StorageManagers
 // ============================
 
@@ -186,6 +279,16 @@ StorageSuffixNodesIndex is the suffix for a node index
 */
 const StorageSuffixNodesIndex = ".nodeidx"
 
+/*
+StorageSuffixNodesUnique is the suffix for a node unique constraint lookup
+*/
+const StorageSuffixNodesUnique = ".nodeuniq"
+
+/*
+StorageSuffixNodesHistory is the suffix for a node history
+*/
+const StorageSuffixNodesHistory = ".nodehist"
+
 /*
 StorageSuffixEdges is the suffix for an edge storage
 */
@@ -196,6 +299,17 @@ StorageSuffixEdgesIndex is the suffix for an edge index
 */
 const StorageSuffixEdgesIndex = ".edgeidx"
 
+/*
+StorageSuffixEdgesHistory is the suffix for an edge history
+*/
+const StorageSuffixEdgesHistory = ".edgehist"
+
+/*
+StorageChangeLog is the name of the change log storage - node and edge
+storages always have a suffix so the name cannot clash with them
+*/
+const StorageChangeLog = "changelog"
+
 // PREFIXES for Node storage
 // =========================
 
@@ -223,6 +337,32 @@ PrefixNSEdge is the prefix for storing a link from a node (and a spec) to an edg
 */
 const PrefixNSEdge = "\x04"
 
+// PREFIXES for History storage
+// ============================
+
+/*
+PrefixHSInfo is the prefix for storing the range of retained versions of an item
+*/
+const PrefixHSInfo = "\x01"
+
+/*
+PrefixHSVersion is the prefix for storing a single version of an item
+*/
+const PrefixHSVersion = "\x02"
+
+// PREFIXES for Change log storage
+// ===============================
+
+/*
+PrefixCLInfo is the prefix for storing the range of retained change sets
+*/
+const PrefixCLInfo = "\x01"
+
+/*
+PrefixCLEntry is the prefix for storing a single change set
+*/
+const PrefixCLEntry = "\x02"
+
 // Graph events
 //=============
 
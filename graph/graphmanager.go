@@ -31,6 +31,7 @@ type Manager struct {
 	mapCache     map[string]map[string]string // Cache which caches maps stored in the main database
 	mutex        *sync.RWMutex                // Mutex to protect atomic graph operations
 	storageMutex *sync.Mutex                  // Special mutex for storage object access
+	changes      *changeNotifier              // Notifier for new change log entries
 }
 
 /*
@@ -41,6 +42,7 @@ func NewGraphManager(gs graphstorage.Storage) *Manager {
 
 	gm.SetGraphRule(&SystemRuleDeleteNodeEdges{})
 	gm.SetGraphRule(&SystemRuleUpdateNodeStats{})
+	gm.SetGraphRule(&SystemRuleValidateSchema{})
 
 	return gm
 }
@@ -77,7 +79,7 @@ func createGraphManager(gs graphstorage.Storage) *Manager {
 
 	gm := &Manager{gs, &graphRulesManager{nil, make(map[string]Rule),
 		make(map[int]map[string]Rule)}, util.NewNamesManager(mdb),
-		make(map[string]map[string]string), &sync.RWMutex{}, &sync.Mutex{}}
+		make(map[string]map[string]string), &sync.RWMutex{}, &sync.Mutex{}, newChangeNotifier()}
 
 	gm.gr.gm = gm
 
@@ -157,6 +159,14 @@ func (gm *Manager) NodeAttrs(kind string) []string {
 	return gm.mainStringList(MainDBNodeAttrs + kind)
 }
 
+/*
+NodeAttrTypes returns the value types of all known node attributes for a
+given node kind (see data.ValueType).
+*/
+func (gm *Manager) NodeAttrTypes(kind string) map[string]string {
+	return gm.mainTypeMap(MainDBNodeAttrs + kind)
+}
+
 /*
 NodeEdges returns all possible node edge specs for a given node kind.
 */
@@ -171,6 +181,31 @@ func (gm *Manager) EdgeAttrs(kind string) []string {
 	return gm.mainStringList(MainDBEdgeAttrs + kind)
 }
 
+/*
+EdgeAttrTypes returns the value types of all known edge attributes for a
+given edge kind (see data.ValueType).
+*/
+func (gm *Manager) EdgeAttrTypes(kind string) map[string]string {
+	return gm.mainTypeMap(MainDBEdgeAttrs + kind)
+}
+
+/*
+mainTypeMap returns a copy of an attribute map in the MainDB. Attributes
+without a recorded value type have the type data.ValueTypeAny.
+*/
+func (gm *Manager) mainTypeMap(name string) map[string]string {
+	ret := make(map[string]string)
+
+	for attr, vtype := range gm.getMainDBMap(name) {
+		if vtype == "" {
+			vtype = data.ValueTypeAny
+		}
+		ret[attr] = vtype
+	}
+
+	return ret
+}
+
 /*
 mainStringList return a list in the MainDB.
 */
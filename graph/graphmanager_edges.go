@@ -16,10 +16,12 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/util"
 	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
 )
 
 /*
@@ -41,6 +43,11 @@ func init() {
 	gob.Register(make(map[string]string))
 	gob.Register(make(map[string]*edgeTargetInfo))
 	gob.Register(&edgeTargetInfo{})
+
+	// Make sure we can use the relevant types with the binary codec
+
+	storage.RegisterCodecType(make(map[string]*edgeTargetInfo))
+	storage.RegisterCodecType(&edgeTargetInfo{})
 }
 
 /*
@@ -293,6 +300,49 @@ func (gm *Manager) Traverse(part string, key string, kind string,
 	return nodes, edges, nil
 }
 
+/*
+countEdges counts the edges of a node which follow a given full edge spec.
+An edge with the given key is not counted. The count is taken from the edge
+index of the node without reading any edges.
+*/
+func (gm *Manager) countEdges(part string, key string, kind string,
+	spec string, exclude string) (int, error) {
+
+	_, tree, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || tree == nil {
+		return 0, err
+	}
+
+	// Take reader lock
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	sspec := strings.Split(spec, ":")
+	if len(sspec) != 4 || !IsFullSpec(spec) {
+		return 0, &util.GraphError{Type: util.ErrInvalidData, Detail: "Invalid spec: " + spec}
+	}
+
+	encspec := gm.nm.Encode16(sspec[0], false) + gm.nm.Encode16(sspec[1], false) +
+		gm.nm.Encode16(sspec[2], false) + gm.nm.Encode16(sspec[3], false)
+
+	obj, err := tree.Get([]byte(PrefixNSEdge + key + encspec))
+	if err != nil {
+		return 0, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	} else if obj == nil {
+		return 0, nil
+	}
+
+	targetMap := obj.(map[string]*edgeTargetInfo)
+
+	count := len(targetMap)
+	if _, ok := targetMap[exclude]; ok {
+		count--
+	}
+
+	return count, nil
+}
+
 /*
 FetchEdge fetches a single edge from a partition of the graph.
 */
@@ -343,103 +393,119 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 	}
 
 	if err = trans.Commit(); err == nil {
+		err = gm.storeEdge(part, edge)
 
-		// Check if the edge can be stored
+		// Changes of event handlers are written even if the operation failed
 
-		if err := gm.checkEdge(edge); err != nil {
-			return err
+		if derr := gm.waitDurable(); err == nil {
+			err = derr
 		}
+	}
 
-		// Get the HTrees which stores the edges and the edge index
+	return err
+}
 
-		iht, err := gm.getEdgeIndexHTree(part, edge.Kind(), true)
-		if err != nil {
-			return err
-		}
+/*
+storeEdge stores a single edge in a partition of the graph.
+*/
+func (gm *Manager) storeEdge(part string, edge data.Edge) error {
 
-		edgeht, err := gm.getEdgeStorageHTree(part, edge.Kind(), true)
-		if err != nil {
-			return err
-		}
+	// Check if the edge can be stored
 
-		// Get the HTrees which stores the edge endpoints and make sure the endpoints
-		// do exist
+	if err := gm.checkEdge(edge); err != nil {
+		return err
+	}
 
-		end1nodeht, end1ht, err := gm.getNodeStorageHTree(part, edge.End1Kind(), false)
+	// Get the HTrees which stores the edges and the edge index
 
-		if err != nil {
-			return err
-		} else if end1ht == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: "Can't store edge to non-existing node kind: " + edge.End1Kind(),
-			}
-		} else if end1, err := end1nodeht.Get([]byte(PrefixNSAttrs + edge.End1Key())); err != nil || end1 == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End1Key(), edge.End1Kind()),
-			}
-		}
+	iht, err := gm.getEdgeIndexHTree(part, edge.Kind(), true)
+	if err != nil {
+		return err
+	}
 
-		end2nodeht, end2ht, err := gm.getNodeStorageHTree(part, edge.End2Kind(), false)
+	edgeht, err := gm.getEdgeStorageHTree(part, edge.Kind(), true)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		} else if end2ht == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: "Can't store edge to non-existing node kind: " + edge.End2Kind(),
-			}
-		} else if end2, err := end2nodeht.Get([]byte(PrefixNSAttrs + edge.End2Key())); err != nil || end2 == nil {
-			return &util.GraphError{
-				Type:   util.ErrInvalidData,
-				Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End2Key(), edge.End2Kind()),
-			}
-		}
+	// Get the HTrees which stores the edge endpoints and make sure the endpoints
+	// do exist
 
-		// Take writer lock
+	end1nodeht, end1ht, err := gm.getNodeStorageHTree(part, edge.End1Kind(), false)
 
-		gm.mutex.Lock()
-		defer gm.mutex.Unlock()
+	if err != nil {
+		return err
+	} else if end1ht == nil {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "Can't store edge to non-existing node kind: " + edge.End1Kind(),
+		}
+	} else if end1, err := end1nodeht.Get([]byte(PrefixNSAttrs + edge.End1Key())); err != nil || end1 == nil {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End1Key(), edge.End1Kind()),
+		}
+	}
 
-		// Write edge to the datastore
+	end2nodeht, end2ht, err := gm.getNodeStorageHTree(part, edge.End2Kind(), false)
 
-		oldedge, err := gm.writeEdge(edge, edgeht, end1ht, end2ht)
-		if err != nil {
-			return err
+	if err != nil {
+		return err
+	} else if end2ht == nil {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "Can't store edge to non-existing node kind: " + edge.End2Kind(),
+		}
+	} else if end2, err := end2nodeht.Get([]byte(PrefixNSAttrs + edge.End2Key())); err != nil || end2 == nil {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Can't find edge endpoint: %s (%s)", edge.End2Key(), edge.End2Kind()),
 		}
+	}
 
-		// Increase edge count if the edge was inserted and write the changes
-		// to the index.
+	// Take writer lock
 
-		if oldedge == nil {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
 
-			// Increase edge count
+	// Write edge to the datastore
 
-			currentCount := gm.EdgeCount(edge.Kind())
-			if err := gm.writeEdgeCount(edge.Kind(), currentCount+1, true); err != nil {
-				return err
-			}
+	oldedge, err := gm.writeEdge(edge, edgeht, end1ht, end2ht)
+	if err != nil {
+		return err
+	}
+
+	// The transaction which executes the rules also identifies the new
+	// version of the edge and adds the change to the change log
+
+	trans := newInternalGraphTrans(gm)
+	trans.subtrans = true
+	trans.timestamp = time.Now()
+
+	if err := gm.recordEdgeVersion(part, edge.Key(), edge.Kind(), trans.id,
+		trans.timestamp, edgeht); err != nil {
+		return err
+	}
 
-			// Write edge data to the index
+	gm.addEdgeChange(trans, part, edge.Key(), edge.Kind(), edge, oldedge)
 
-			if iht != nil {
+	// Increase edge count if the edge was inserted and write the changes
+	// to the index.
 
-				if err := util.NewIndexManager(iht).Index(edge.Key(), edge.IndexMap()); err != nil {
+	if oldedge == nil {
 
-					// The edge was written at this point and the model is
-					// consistent only the index is missing entries
+		// Increase edge count
 
-					return err
-				}
-			}
+		currentCount := gm.EdgeCount(edge.Kind())
+		if err := gm.writeEdgeCount(edge.Kind(), currentCount+1, true); err != nil {
+			return err
+		}
 
-		} else if iht != nil {
+		// Write edge data to the index
 
-			err := util.NewIndexManager(iht).Reindex(edge.Key(), edge.IndexMap(),
-				oldedge.IndexMap())
+		if iht != nil {
 
-			if err != nil {
+			if err := util.NewIndexManager(iht).Index(edge.Key(), edge.IndexMap()); err != nil {
 
 				// The edge was written at this point and the model is
 				// consistent only the index is missing entries
@@ -448,41 +514,61 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 			}
 		}
 
-		defer func() {
+	} else if iht != nil {
 
-			// Flush changes - errors only reported on the actual node storage flush
+		err := util.NewIndexManager(iht).Reindex(edge.Key(), edge.IndexMap(),
+			oldedge.IndexMap())
 
-			gm.gs.FlushMain()
+		if err != nil {
 
-			gm.flushEdgeIndex(part, edge.Kind())
+			// The edge was written at this point and the model is
+			// consistent only the index is missing entries
 
-			gm.flushNodeStorage(part, edge.End1Kind())
+			return err
+		}
+	}
 
-			gm.flushNodeStorage(part, edge.End2Kind())
+	defer func() {
 
-			gm.flushEdgeStorage(part, edge.Kind())
-		}()
+		// Flush changes - errors only reported on the actual node storage flush
 
-		// Execute rules
+		gm.gs.FlushMain()
 
-		trans := newInternalGraphTrans(gm)
-		trans.subtrans = true
+		gm.flushEdgeIndex(part, edge.Kind())
 
-		var event int
-		if oldedge == nil {
-			event = EventEdgeCreated
-		} else {
-			event = EventEdgeUpdated
-		}
+		gm.flushEdgeHistory(part, edge.Kind())
 
-		if err := gm.gr.graphEvent(trans, event, part, edge, oldedge); err != nil && err != ErrEventHandled {
-			return err
-		} else if err := trans.Commit(); err != nil {
-			return err
+		gm.flushNodeStorage(part, edge.End1Kind())
+
+		gm.flushNodeStorage(part, edge.End2Kind())
+
+		gm.flushEdgeStorage(part, edge.Kind())
+	}()
+
+	// Execute rules
+
+	var event int
+	if oldedge == nil {
+		event = EventEdgeCreated
+	} else {
+		event = EventEdgeUpdated
+	}
+
+	if err := gm.gr.graphEvent(trans, event, part, edge, oldedge); err != nil && err != ErrEventHandled {
+
+		// The edge is flushed anyway so its change must be written as well
+
+		if cerr := trans.commitChanges(); cerr != nil {
+			return cerr
 		}
+
+		return err
+
+	} else if err := trans.Commit(); err != nil {
+		return err
 	}
 
-	return err
+	return nil
 }
 
 /*
@@ -635,100 +721,136 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 		return nil, err
 	}
 
-	err = trans.Commit()
+	if err = trans.Commit(); err != nil {
+		return nil, err
+	}
+
+	edge, err := gm.removeEdge(part, key, kind)
 
-	if err == nil {
+	// Changes of event handlers are written even if the operation failed
 
-		// Get the HTrees which stores the edges and the edge index
+	if derr := gm.waitDurable(); err == nil {
+		err = derr
+	}
+
+	return edge, err
+}
 
-		iht, err := gm.getEdgeIndexHTree(part, kind, true)
+/*
+removeEdge removes a single edge from a partition of the graph.
+*/
+func (gm *Manager) removeEdge(part string, key string, kind string) (data.Edge, error) {
+
+	// Get the HTrees which stores the edges and the edge index
+
+	iht, err := gm.getEdgeIndexHTree(part, kind, true)
+	if err != nil {
+		return nil, err
+	}
+
+	edgeht, err := gm.getEdgeStorageHTree(part, kind, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Take writer lock
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	// Delete the node from the datastore
+
+	node, err := gm.deleteNode(key, kind, edgeht, edgeht)
+	edge := data.NewGraphEdgeFromNode(node)
+	if err != nil {
+		return edge, err
+	}
+
+	if node != nil {
+
+		// Get the HTrees which stores the edge endpoints
+
+		_, end1ht, err := gm.getNodeStorageHTree(part, edge.End1Kind(), false)
 		if err != nil {
-			return nil, err
+			return edge, err
 		}
 
-		edgeht, err := gm.getEdgeStorageHTree(part, kind, true)
+		_, end2ht, err := gm.getNodeStorageHTree(part, edge.End2Kind(), false)
 		if err != nil {
-			return nil, err
+			return edge, err
 		}
 
-		// Take writer lock
-
-		gm.mutex.Lock()
-		defer gm.mutex.Unlock()
-
-		// Delete the node from the datastore
+		// Delete edge info from node storage
 
-		node, err := gm.deleteNode(key, kind, edgeht, edgeht)
-		edge := data.NewGraphEdgeFromNode(node)
-		if err != nil {
+		if err := gm.deleteEdge(edge, end1ht, end2ht); err != nil {
 			return edge, err
 		}
 
-		if node != nil {
+		// The transaction which executes the rules also identifies the
+		// removal of the edge and adds the change to the change log
 
-			// Get the HTrees which stores the edge endpoints
+		trans := newInternalGraphTrans(gm)
+		trans.subtrans = true
+		trans.timestamp = time.Now()
 
-			_, end1ht, err := gm.getNodeStorageHTree(part, edge.End1Kind(), false)
-			if err != nil {
-				return edge, err
-			}
+		if err := gm.recordEdgeVersion(part, key, kind, trans.id,
+			trans.timestamp, edgeht); err != nil {
+			return edge, err
+		}
+
+		gm.addEdgeChange(trans, part, key, kind, nil, edge)
 
-			_, end2ht, err := gm.getNodeStorageHTree(part, edge.End2Kind(), false)
+		if iht != nil {
+			err := util.NewIndexManager(iht).Deindex(key, edge.IndexMap())
 			if err != nil {
 				return edge, err
 			}
+		}
 
-			// Delete edge info from node storage
+		// Decrease edge count
 
-			if err := gm.deleteEdge(edge, end1ht, end2ht); err != nil {
-				return edge, err
-			}
-
-			if iht != nil {
-				err := util.NewIndexManager(iht).Deindex(key, edge.IndexMap())
-				if err != nil {
-					return edge, err
-				}
-			}
+		currentCount := gm.EdgeCount(edge.Kind())
+		if err := gm.writeEdgeCount(edge.Kind(), currentCount-1, true); err != nil {
+			return edge, err
+		}
 
-			// Decrease edge count
+		defer func() {
 
-			currentCount := gm.EdgeCount(edge.Kind())
-			if err := gm.writeEdgeCount(edge.Kind(), currentCount-1, true); err != nil {
-				return edge, err
-			}
+			// Flush changes - errors only reported on the actual node storage flush
 
-			defer func() {
+			gm.gs.FlushMain()
 
-				// Flush changes - errors only reported on the actual node storage flush
+			gm.flushEdgeIndex(part, edge.Kind())
 
-				gm.gs.FlushMain()
+			gm.flushEdgeHistory(part, edge.Kind())
 
-				gm.flushEdgeIndex(part, edge.Kind())
+			gm.flushNodeStorage(part, edge.End1Kind())
 
-				gm.flushNodeStorage(part, edge.End1Kind())
+			gm.flushNodeStorage(part, edge.End2Kind())
 
-				gm.flushNodeStorage(part, edge.End2Kind())
+			gm.flushEdgeStorage(part, edge.Kind())
+		}()
 
-				gm.flushEdgeStorage(part, edge.Kind())
-			}()
+		// Execute rules
 
-			// Execute rules
+		if err := gm.gr.graphEvent(trans, EventEdgeDeleted, part, edge); err != nil && err != ErrEventHandled {
 
-			trans := newInternalGraphTrans(gm)
-			trans.subtrans = true
+			// The removal is flushed anyway so its change must be written as well
 
-			if err := gm.gr.graphEvent(trans, EventEdgeDeleted, part, edge); err != nil && err != ErrEventHandled {
-				return edge, err
-			} else if err := trans.Commit(); err != nil {
-				return edge, err
+			if cerr := trans.commitChanges(); cerr != nil {
+				return edge, cerr
 			}
 
-			return edge, nil
+			return edge, err
+
+		} else if err := trans.Commit(); err != nil {
+			return edge, err
 		}
+
+		return edge, nil
 	}
 
-	return nil, err
+	return nil, nil
 }
 
 /*
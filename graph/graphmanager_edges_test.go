@@ -13,6 +13,7 @@ package graph
 import (
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"testing"
 
@@ -1309,3 +1310,97 @@ func TestEdgeOperations(t *testing.T) {
 		return
 	}
 }
+
+func TestGraphStorageBinaryCodec(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	os.MkdirAll(GraphManagerTestDBDir9, 0770)
+
+	dgs, err := graphstorage.NewDiskGraphStorageWithOptions(GraphManagerTestDBDir9+"/codec", false,
+		storage.DiskStorageManagerOptions{Format: storage.FormatOptions{Codec: storage.CodecBinary}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	node1 := data.NewGraphNode()
+	node1.SetAttr("key", "123")
+	node1.SetAttr("kind", "mykind")
+	node1.SetAttr("name", "Node1 with some text")
+	node1.SetAttr("nested", map[string]interface{}{"a": []interface{}{1, "b"}})
+
+	node2 := data.NewGraphNode()
+	node2.SetAttr("key", "456")
+	node2.SetAttr("kind", "mykind")
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "abc")
+	edge.SetAttr("kind", "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, node1.Key())
+	edge.SetAttr(data.EdgeEnd1Kind, node1.Kind())
+	edge.SetAttr(data.EdgeEnd1Role, "node1")
+	edge.SetAttr(data.EdgeEnd1Cascading, true)
+	edge.SetAttr(data.EdgeEnd2Key, node2.Key())
+	edge.SetAttr(data.EdgeEnd2Kind, node2.Kind())
+	edge.SetAttr(data.EdgeEnd2Role, "node2")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	if err := gm.StoreNode("main", node1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", node2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.Close()
+
+	// Reopen the storage - all objects are read with the binary codec
+
+	dgs, _ = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir9+"/codec", false)
+	gm = NewGraphManager(dgs)
+
+	if n, err := gm.FetchNode("main", "123", "mykind"); err != nil ||
+		fmt.Sprint(n.Attr("nested")) != "map[a:[1 b]]" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if nodes, _, err := gm.TraverseMulti("main", "123", "mykind", ":::", true); err != nil ||
+		len(nodes) != 1 || nodes[0].Key() != "456" {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	iq, _ := gm.NodeIndexQuery("main", "mykind")
+
+	if res, err := iq.LookupPhrase("name", "some text"); err != nil || fmt.Sprint(res) != "[123]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "123", "mykind"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The removal was cascaded over the edge
+
+	if n, err := gm.FetchNode("main", "456", "mykind"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	dgs.Close()
+}
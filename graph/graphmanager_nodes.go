@@ -13,6 +13,7 @@ package graph
 import (
 	"encoding/binary"
 	"encoding/gob"
+	"time"
 
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/util"
@@ -49,15 +50,59 @@ func (gm *Manager) NodeKeyIterator(part string, kind string) (*NodeKeyIterator,
 		return nil, err
 	}
 
-	it := hash.NewHTreeIterator(tree)
-	if it.LastError != nil {
+	return newNodeKeyIterator(gm, hash.NewHTreeRangeIterator(tree, hash.SplitHashRange(1)[0]))
+}
+
+/*
+NodeKeyIterators returns a number of iterators which together iterate all node
+keys of a certain kind. Each iterator covers a distinct part of the node
+storage. The iterators can be used in parallel.
+*/
+func (gm *Manager) NodeKeyIterators(part string, kind string, parts int) ([]*NodeKeyIterator, error) {
+	var ret []*NodeKeyIterator
+
+	// Get the HTrees which stores the node
+
+	tree, _, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	for _, r := range hash.SplitHashRange(parts) {
+
+		it, err := newNodeKeyIterator(gm, hash.NewHTreeRangeIterator(tree, r))
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, it)
+	}
+
+	return ret, nil
+}
+
+/*
+NodeKeyIteratorFromCursor returns an iterator which continues the iteration of
+node keys from the cursor of a previous iterator (see NodeKeyIterator.Cursor).
+*/
+func (gm *Manager) NodeKeyIteratorFromCursor(part string, kind string, cursor string) (*NodeKeyIterator, error) {
+
+	// Get the HTrees which stores the node
+
+	tree, _, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	it, err := hash.NewHTreeRangeIteratorFromCursor(tree, cursor)
+	if err != nil {
 		return nil, &util.GraphError{
-			Type:   util.ErrReading,
-			Detail: it.LastError.Error(),
+			Type:   util.ErrInvalidData,
+			Detail: err.Error(),
 		}
 	}
 
-	return &NodeKeyIterator{gm, it, nil}, nil
+	return newNodeKeyIterator(gm, it)
 }
 
 /*
@@ -120,6 +165,10 @@ func (gm *Manager) readNode(key string, kind string, attrs []string,
 		}
 
 		if val != nil {
+			if val, err = gm.readAttrValue(valTree, val); err != nil {
+				return err
+			}
+
 			if node == nil {
 				node = data.NewGraphNode()
 			}
@@ -198,6 +247,12 @@ func (gm *Manager) StoreNode(part string, node data.Node) error {
 
 	if err = trans.Commit(); err == nil {
 		err = gm.storeOrUpdateNode(part, node, false)
+
+		// Changes of event handlers are written even if the operation failed
+
+		if derr := gm.waitDurable(); err == nil {
+			err = derr
+		}
 	}
 
 	return err
@@ -222,6 +277,12 @@ func (gm *Manager) UpdateNode(part string, node data.Node) error {
 
 	if err = trans.Commit(); err == nil {
 		err = gm.storeOrUpdateNode(part, node, true)
+
+		// Changes of event handlers are written even if the operation failed
+
+		if derr := gm.waitDurable(); err == nil {
+			err = derr
+		}
 	}
 
 	return err
@@ -255,6 +316,13 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 	gm.mutex.Lock()
 	defer gm.mutex.Unlock()
 
+	// Check unique constraints before anything is written
+
+	uniqueOld, uniqueNew, err := gm.checkUniqueNode(part, node, onlyUpdate, attht, valht)
+	if err != nil {
+		return err
+	}
+
 	// Write the node to the datastore
 
 	oldnode, err := gm.writeNode(node, onlyUpdate, attht, valht, nodeAttributeFilter)
@@ -262,6 +330,26 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 		return err
 	}
 
+	if uniqueNew != nil {
+		if err := gm.updateUniqueKeys(part, uniqueOld, uniqueNew); err != nil {
+			return err
+		}
+	}
+
+	// The transaction which executes the rules also identifies the new
+	// version of the node and adds the change to the change log
+
+	trans := newInternalGraphTrans(gm)
+	trans.subtrans = true
+	trans.timestamp = time.Now()
+
+	if err := gm.recordNodeVersion(part, node.Key(), node.Kind(), trans.id,
+		trans.timestamp, attht, valht); err != nil {
+		return err
+	}
+
+	gm.addNodeChange(trans, part, node.Key(), node.Kind(), node, oldnode)
+
 	// Increase node count if the node was inserted and write the changes
 	// to the index.
 
@@ -304,15 +392,16 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 		gm.flushNodeIndex(part, node.Kind())
 
+		gm.flushNodeUnique(part, node.Kind())
+
+		gm.flushNodeHistory(part, node.Kind())
+
 		gm.flushNodeStorage(part, node.Kind())
 
 	}()
 
 	// Execute rules
 
-	trans := newInternalGraphTrans(gm)
-	trans.subtrans = true
-
 	var event int
 	if oldnode == nil {
 		event = EventNodeCreated
@@ -321,7 +410,15 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 	}
 
 	if err := gm.gr.graphEvent(trans, event, part, node, oldnode); err != nil && err != ErrEventHandled {
+
+		// The node is flushed anyway so its change must be written as well
+
+		if cerr := trans.commitChanges(); cerr != nil {
+			return cerr
+		}
+
 		return err
+
 	} else if err := trans.Commit(); err != nil {
 		return err
 	}
@@ -369,13 +466,23 @@ func (gm *Manager) writeNode(node data.Node, onlyUpdate bool, attrTree *hash.HTr
 
 		attrList = append(attrList, encattr)
 
-		// Store the value in the datastore
+		// Store the value in the datastore - large values are written as
+		// large objects
 
-		oldval, err := valTree.Put([]byte(keyAttrPrefix+encattr), val)
+		storeval, err := gm.writeAttrValue(valTree, val)
+		if err != nil {
+			return nil, err
+		}
+
+		oldval, err := valTree.Put([]byte(keyAttrPrefix+encattr), storeval)
 		if err != nil {
 			return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
 		}
 
+		if oldval, err = gm.releaseAttrValue(valTree, oldval); err != nil {
+			return nil, err
+		}
+
 		// Build up old node
 
 		if oldval != nil {
@@ -462,6 +569,10 @@ func (gm *Manager) writeNode(node data.Node, onlyUpdate bool, attrTree *hash.HTr
 					return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
 				}
 
+				if oldval, err = gm.releaseAttrValue(valTree, oldval); err != nil {
+					return nil, err
+				}
+
 				oldnode.SetAttr(gm.nm.Decode32(encattrold), oldval)
 			}
 		}
@@ -488,79 +599,121 @@ func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node,
 		return nil, err
 	}
 
-	err = trans.Commit()
+	if err = trans.Commit(); err != nil {
+		return nil, err
+	}
 
-	if err == nil {
+	node, err := gm.removeNode(part, key, kind)
 
-		// Get the HTree which stores the node index and node kind
+	// Changes of event handlers are written even if the operation failed
 
-		iht, err := gm.getNodeIndexHTree(part, kind, false)
-		if err != nil {
-			return nil, err
-		}
+	if derr := gm.waitDurable(); err == nil {
+		err = derr
+	}
 
-		attTree, valTree, err := gm.getNodeStorageHTree(part, kind, false)
-		if err != nil || attTree == nil || valTree == nil {
-			return nil, err
+	return node, err
+}
+
+/*
+removeNode removes a single node from a partition of the graph.
+*/
+func (gm *Manager) removeNode(part string, key string, kind string) (data.Node, error) {
+
+	// Get the HTree which stores the node index and node kind
+
+	iht, err := gm.getNodeIndexHTree(part, kind, false)
+	if err != nil {
+		return nil, err
+	}
+
+	attTree, valTree, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || attTree == nil || valTree == nil {
+		return nil, err
+	}
+
+	// Take writer lock
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	// Delete the node from the datastore
+
+	node, err := gm.deleteNode(key, kind, attTree, valTree)
+	if err != nil {
+		return node, err
+	}
+
+	// Update the index
+
+	if node != nil {
+
+		if iht != nil {
+			err := util.NewIndexManager(iht).Deindex(key, node.IndexMap())
+			if err != nil {
+				return node, err
+			}
 		}
 
-		// Take writer lock
+		if err := gm.updateUniqueKeys(part, node, nil); err != nil {
+			return node, err
+		}
 
-		gm.mutex.Lock()
-		defer gm.mutex.Unlock()
+		// The transaction which executes the rules also identifies the
+		// removal of the node and adds the change to the change log
 
-		// Delete the node from the datastore
+		trans := newInternalGraphTrans(gm)
+		trans.subtrans = true
+		trans.timestamp = time.Now()
 
-		node, err := gm.deleteNode(key, kind, attTree, valTree)
-		if err != nil {
+		if err := gm.recordNodeVersion(part, key, kind, trans.id,
+			trans.timestamp, attTree, valTree); err != nil {
 			return node, err
 		}
 
-		// Update the index
+		gm.addNodeChange(trans, part, key, kind, nil, node)
 
-		if node != nil {
+		// Decrease the node count
 
-			if iht != nil {
-				err := util.NewIndexManager(iht).Deindex(key, node.IndexMap())
-				if err != nil {
-					return node, err
-				}
-			}
+		currentCount := gm.NodeCount(kind)
+		if err := gm.writeNodeCount(kind, currentCount-1, true); err != nil {
+			return node, err
+		}
 
-			// Decrease the node count
+		defer func() {
 
-			currentCount := gm.NodeCount(kind)
-			if err := gm.writeNodeCount(kind, currentCount-1, true); err != nil {
-				return node, err
-			}
+			// Flush changes
 
-			defer func() {
+			gm.gs.FlushMain()
 
-				// Flush changes
+			gm.flushNodeIndex(part, kind)
 
-				gm.gs.FlushMain()
+			gm.flushNodeUnique(part, kind)
 
-				gm.flushNodeIndex(part, kind)
+			gm.flushNodeHistory(part, kind)
 
-				gm.flushNodeStorage(part, kind)
-			}()
+			gm.flushNodeStorage(part, kind)
+		}()
 
-			// Execute rules
+		// Execute rules
 
-			trans := newInternalGraphTrans(gm)
-			trans.subtrans = true
+		if err := gm.gr.graphEvent(trans, EventNodeDeleted, part, node); err != nil && err != ErrEventHandled {
 
-			if err := gm.gr.graphEvent(trans, EventNodeDeleted, part, node); err != nil && err != ErrEventHandled {
-				return node, err
-			} else if err := trans.Commit(); err != nil {
-				return node, err
+			// The removal is flushed anyway so its change must be written as well
+
+			if cerr := trans.commitChanges(); cerr != nil {
+				return node, cerr
 			}
 
-			return node, nil
+			return node, err
+
+		} else if err := trans.Commit(); err != nil {
+			return node, err
 		}
+
+		return node, nil
 	}
 
-	return nil, err
+	return nil, nil
 }
 
 /*
@@ -602,6 +755,10 @@ func (gm *Manager) deleteNode(key string, kind string, attrTree *hash.HTree,
 			return node, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
 		}
 
+		if val, err = gm.releaseAttrValue(valTree, val); err != nil {
+			return node, err
+		}
+
 		node.SetAttr(attr, val)
 	}
 
@@ -683,7 +683,7 @@ func TestGraphManagerDiskStorage(t *testing.T) {
 
 	sm := dgs.StorageManager("my1", true)
 
-	htree, err := gm.getHTree(sm, RootIDNodeHTree)
+	htree, err := gm.getHTree(sm, RootIDNodeHTree, "")
 	if err != nil {
 		t.Error(err)
 		return
@@ -723,7 +723,7 @@ func TestGraphManagerDiskStorage(t *testing.T) {
 
 	sm2 := dgs2.StorageManager("my1", true)
 
-	htree2, err := gm.getHTree(sm2, RootIDNodeHTree)
+	htree2, err := gm.getHTree(sm2, RootIDNodeHTree, "")
 	if err != nil {
 		t.Error(err)
 		return
@@ -742,7 +742,7 @@ func TestGraphManagerDiskStorage(t *testing.T) {
 
 	msm.AccessMap[1] = storage.AccessInsertError
 
-	_, err = gm.getHTree(msm, RootIDNodeHTree)
+	_, err = gm.getHTree(msm, RootIDNodeHTree, "")
 
 	if err.(*util.GraphError).Type != util.ErrAccessComponent {
 		t.Error(err)
@@ -755,7 +755,7 @@ func TestGraphManagerDiskStorage(t *testing.T) {
 
 	msm.AccessMap[2] = storage.AccessInsertError
 
-	_, err = gm.getHTree(msm, RootIDNodeHTree)
+	_, err = gm.getHTree(msm, RootIDNodeHTree, "")
 
 	if err.(*util.GraphError).Type != util.ErrAccessComponent {
 		t.Error(err)
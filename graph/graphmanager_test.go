@@ -32,10 +32,30 @@ const GraphManagerTestDBDir3 = "gmtest3"
 const GraphManagerTestDBDir4 = "gmtest4"
 const GraphManagerTestDBDir5 = "gmtest5"
 const GraphManagerTestDBDir6 = "gmtest6"
+const GraphManagerTestDBDir7 = "gmtest7"
+const GraphManagerTestDBDir8 = "gmtest8"
+const GraphManagerTestDBDir9 = "gmtest9"
+const GraphManagerTestDBDir10 = "gmtest10"
+const GraphManagerTestDBDir11 = "gmtest11"
+const GraphManagerTestDBDir12 = "gmtest12"
+const GraphManagerTestDBDir13 = "gmtest13"
+const GraphManagerTestDBDir14 = "gmtest14"
+const GraphManagerTestDBDir15 = "gmtest15"
+const GraphManagerTestDBDir16 = "gmtest16"
+const GraphManagerTestDBDir17 = "gmtest17"
+const GraphManagerTestDBDir18 = "gmtest18"
+const GraphManagerTestDBDir19 = "gmtest19"
+const GraphManagerTestDBDir20 = "gmtest20"
+const GraphManagerTestDBDir21 = "gmtest21"
 
 var DBDIRS = []string{GraphManagerTestDBDir1, GraphManagerTestDBDir2,
 	GraphManagerTestDBDir3, GraphManagerTestDBDir4, GraphManagerTestDBDir5,
-	GraphManagerTestDBDir6}
+	GraphManagerTestDBDir6, GraphManagerTestDBDir7, GraphManagerTestDBDir8,
+	GraphManagerTestDBDir9, GraphManagerTestDBDir10, GraphManagerTestDBDir11,
+	GraphManagerTestDBDir12, GraphManagerTestDBDir13, GraphManagerTestDBDir14,
+	GraphManagerTestDBDir15, GraphManagerTestDBDir16, GraphManagerTestDBDir17,
+	GraphManagerTestDBDir18, GraphManagerTestDBDir19, GraphManagerTestDBDir20,
+	GraphManagerTestDBDir21}
 
 const InvlaidFileName = "**" + "\x00"
 
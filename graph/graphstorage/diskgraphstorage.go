@@ -12,19 +12,24 @@
 Package graphstorage contains classes which model storage objects for graph data.
 
 There are two main storage objects: DiskGraphStorage which provides disk storage
-and MemoryGraphStorage which provides memory-only storage.
+and MemoryGraphStorage which provides memory-only storage. KVGraphStorage keeps
+all data in an ordered key-value store instead of EliasDB's own storage files.
+A DiskGraphStorage can also be opened as a read-only replica of a DiskGraphStorage
+which is written by another process.
 */
 package graphstorage
 
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 
-	"github.com/krotik/common/datautil"
 	"github.com/krotik/common/fileutil"
 	"github.com/krotik/eliasdb/graph/util"
 	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 /*
@@ -32,22 +37,76 @@ FilenameNameDB is the filename for the name storage file
 */
 var FilenameNameDB = "names.pm"
 
+/*
+FilenameRekeyManifest is the filename for the manifest of an unfinished rekey
+*/
+var FilenameRekeyManifest = "rekey.manifest"
+
 /*
 DiskGraphStorage data structure
 */
 type DiskGraphStorage struct {
-	name            string                        // Name of the graph storage
-	readonly        bool                          // Flag for readonly mode
-	mainDB          *datautil.PersistentStringMap // Database storing names
-	storagemanagers map[string]storage.Manager    // Map of StorageManagers
+	name            string                            // Name of the graph storage
+	readonly        bool                              // Flag for readonly mode
+	mainDB          *mainDatabase                     // Database storing names
+	storagemanagers map[string]storage.Manager        // Map of StorageManagers
+	options         storage.DiskStorageManagerOptions // Options for new StorageManagers
+	mainArchive     *os.File                          // Archive of the main database
+	mainArchived    map[string]string                 // Last archived version of the main database
+	cacheBudget     *storage.CacheBudget              // Memory budget shared by all caches
+	syncer          *file.LogSyncer                   // Syncer for all transaction logs (nil if every commit is synced)
+	versions        *storage.VersionTracker           // Tracker for the versions of all StorageManagers
+	mutex           *sync.Mutex                       // Mutex to protect the map of StorageManagers
+	tags            []*durableTag                     // Tagged transactions which may not yet be on disk
+	tagMutex        *sync.Mutex                       // Mutex to protect the list of tagged transactions
+}
+
+/*
+durableTag is a tag of all transactions which were committed before a given
+syncer mark.
+*/
+type durableTag struct {
+	tag  uint64 // Tag of the transactions
+	mark uint64 // Syncer mark which covers the transactions
 }
 
 /*
 NewDiskGraphStorage creates a new DiskGraphStorage instance.
 */
 func NewDiskGraphStorage(name string, readonly bool) (Storage, error) {
+	return NewDiskGraphStorageWithOptions(name, readonly, storage.DiskStorageManagerOptions{})
+}
+
+/*
+NewDiskGraphStorageWithOptions creates a new DiskGraphStorage instance. The given
+options are used for all StorageManagers of this DiskGraphStorage. The caches
+of all StorageManagers share the memory budget given by options.Read.CacheMaxBytes.
+The transaction logs of all StorageManagers are written to disk according to
+options.Log.SyncPolicy. If options.Replica is set then the files of an existing
+DiskGraphStorage are only read while another process writes them (see Refresh).
+Options which cannot be used together are rejected.
+*/
+func NewDiskGraphStorageWithOptions(name string, readonly bool,
+	options storage.DiskStorageManagerOptions) (Storage, error) {
+
+	if err := options.Validate(); err != nil {
+		return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	// A replica is always readonly and cannot create a new graph storage
+
+	if options.Replica {
+		readonly = true
 
-	dgs := &DiskGraphStorage{name, readonly, nil, make(map[string]storage.Manager)}
+		if res, _ := fileutil.PathExists(name + "/" + FilenameNameDB); !res {
+			return nil, &util.GraphError{Type: util.ErrOpening,
+				Detail: fmt.Sprint("Cannot open replica of non-existing graph storage ", name)}
+		}
+	}
+
+	dgs := &DiskGraphStorage{name, readonly, nil, make(map[string]storage.Manager),
+		options, nil, nil, storage.NewCacheBudget(options.Read.CacheMaxBytes), nil,
+		storage.NewVersionTracker(), &sync.Mutex{}, nil, &sync.Mutex{}}
 
 	// Load the graph storage if the storage directory already exists if not try to create it
 
@@ -58,7 +117,7 @@ func NewDiskGraphStorage(name string, readonly bool) (Storage, error) {
 
 		// Create the graph storage files
 
-		mainDB, err := datautil.NewPersistentStringMap(name + "/" + FilenameNameDB)
+		mainDB, err := newMainDatabase(name+"/"+FilenameNameDB, options.EncryptionKey)
 		if err != nil {
 			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
 		}
@@ -67,16 +126,49 @@ func NewDiskGraphStorage(name string, readonly bool) (Storage, error) {
 
 	} else {
 
+		// The files of an interrupted rekey use different keys
+
+		if res, _ := fileutil.PathExists(name + "/" + FilenameRekeyManifest); res {
+			return nil, &util.GraphError{Type: util.ErrOpening,
+				Detail: fmt.Sprint("Rekey of graph storage was interrupted and must be resumed ", name)}
+		}
+
 		// Load graph storage files
 
-		mainDB, err := datautil.LoadPersistentStringMap(name + "/" + FilenameNameDB)
-		if err != nil {
+		mainDB, err := loadMainDatabase(name+"/"+FilenameNameDB, options.EncryptionKey,
+			options.Replica)
+
+		if err == ErrMainDBUnencrypted {
+			return nil, &util.GraphError{Type: util.ErrOpening,
+				Detail: fmt.Sprint("Graph storage is not encrypted - use rekey to encrypt it ", name)}
+		} else if err != nil {
 			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
 		}
 
 		dgs.mainDB = mainDB
 	}
 
+	// Archive all changes if requested
+
+	if options.Log.Archive != "" && !readonly {
+		if err := dgs.openMainDBArchive(); err != nil {
+			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+		}
+	}
+
+	// Share a single syncer for all transaction logs if commits should not
+	// be synced individually
+
+	if options.Log.Syncer == nil && options.Log.SyncPolicy.Mode != file.SyncAlways && !readonly {
+		syncer, err := file.NewLogSyncer(options.Log.SyncPolicy)
+		if err != nil {
+			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+		}
+
+		dgs.syncer = syncer
+		dgs.options.Log.Syncer = syncer
+	}
+
 	return dgs, nil
 }
 
@@ -105,7 +197,7 @@ func (dgs *DiskGraphStorage) RollbackMain() error {
 		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot rollback main db"}
 	}
 
-	mainDB, err := datautil.LoadPersistentStringMap(dgs.name + "/" + FilenameNameDB)
+	mainDB, err := loadMainDatabase(dgs.name+"/"+FilenameNameDB, dgs.options.EncryptionKey, false)
 	if err != nil {
 		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
 	}
@@ -126,17 +218,29 @@ func (dgs *DiskGraphStorage) FlushMain() error {
 		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot flush main db"}
 	}
 
-	if err := dgs.mainDB.Flush(); err != nil {
+	if err := dgs.flushMainDB(); err != nil {
 		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
 	}
 	return nil
 }
 
+/*
+flushMainDB writes the main database to disk and archives it if necessary.
+*/
+func (dgs *DiskGraphStorage) flushMainDB() error {
+	if err := dgs.mainDB.Flush(); err != nil {
+		return err
+	}
+	return dgs.archiveMainDB()
+}
+
 /*
 StorageManager gets a storage manager with a certain name. A non-existing
 StorageManager is created automatically if the create flag is set to true.
 */
 func (dgs *DiskGraphStorage) StorageManager(smname string, create bool) storage.Manager {
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
 
 	sm, ok := dgs.storagemanagers[smname]
 
@@ -145,10 +249,16 @@ func (dgs *DiskGraphStorage) StorageManager(smname string, create bool) storage.
 	// Create storage manager object either if we may create or if the
 	// database already exists
 
-	if !ok && (create || storage.DataFileExist(filename)) {
-		dsm := storage.NewDiskStorageManager(dgs.name+"/"+smname, dgs.readonly, false, false, false)
-		sm = storage.NewCachedDiskStorageManager(dsm, 100000)
-		dgs.storagemanagers[smname] = sm
+	if !ok {
+		exists := storage.DataFileExist(filename)
+
+		if (create && !dgs.options.Replica) || exists {
+			dsm := storage.NewDiskStorageManagerWithOptions(dgs.name+"/"+smname, dgs.readonly,
+				false, false, false, dgs.options)
+			cdsm := storage.NewCachedDiskStorageManagerWithBudget(dsm, 100000, dgs.cacheBudget)
+			sm = storage.NewVersionedStorageManager(cdsm, dgs.versions, !exists)
+			dgs.storagemanagers[smname] = sm
+		}
 	}
 
 	return sm
@@ -165,11 +275,14 @@ func (dgs *DiskGraphStorage) FlushAll() error {
 
 	var errors []string
 
-	err := dgs.mainDB.Flush()
+	err := dgs.flushMainDB()
 	if err != nil {
 		errors = append(errors, err.Error())
 	}
 
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
 	for _, sm := range dgs.storagemanagers {
 		err := sm.Flush()
 		if err != nil {
@@ -193,11 +306,17 @@ func (dgs *DiskGraphStorage) Close() error {
 
 	var errors []string
 
-	err := dgs.mainDB.Flush()
-	if err != nil {
-		errors = append(errors, err.Error())
+	// The main database of a replica belongs to the writing process
+
+	if !dgs.options.Replica {
+		if err := dgs.flushMainDB(); err != nil {
+			errors = append(errors, err.Error())
+		}
 	}
 
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
 	for _, sm := range dgs.storagemanagers {
 		err := sm.Close()
 		if err != nil {
@@ -205,6 +324,16 @@ func (dgs *DiskGraphStorage) Close() error {
 		}
 	}
 
+	if dgs.mainArchive != nil {
+		dgs.mainArchive.Close()
+	}
+
+	if dgs.syncer != nil {
+		if err := dgs.syncer.Close(); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
 	if len(errors) > 0 {
 		details := fmt.Sprint(dgs.name, " :", strings.Join(errors, "; "))
 
@@ -213,3 +342,190 @@ func (dgs *DiskGraphStorage) Close() error {
 
 	return nil
 }
+
+/*
+CacheStats returns the combined statistics of the caches of all StorageManagers.
+*/
+func (dgs *DiskGraphStorage) CacheStats() storage.CacheStats {
+	var stats storage.CacheStats
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	for _, sm := range dgs.storagemanagers {
+		if vsm, ok := sm.(*storage.VersionedStorageManager); ok {
+			stats.Add(vsm.CacheStats())
+		}
+	}
+
+	return stats
+}
+
+/*
+CacheMaxSize returns the memory budget in bytes which is shared by the caches
+of all StorageManagers (0 if there is no limit).
+*/
+func (dgs *DiskGraphStorage) CacheMaxSize() int64 {
+	return dgs.cacheBudget.MaxSize()
+}
+
+/*
+WaitDurable blocks until all committed transactions have been written to disk.
+The call only blocks if transaction logs are synced in groups.
+*/
+func (dgs *DiskGraphStorage) WaitDurable() error {
+	if dgs.options.Log.Syncer == nil {
+		return nil
+	}
+
+	if err := dgs.options.Log.Syncer.Wait(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+TagDurable tags all transactions which were committed before the call with a
+given tag. Nothing is tagged if every transaction is synced when it is
+committed.
+*/
+func (dgs *DiskGraphStorage) TagDurable(tag uint64) {
+	if dgs.options.Log.Syncer == nil {
+		return
+	}
+
+	dgs.tagMutex.Lock()
+	defer dgs.tagMutex.Unlock()
+
+	dgs.tags = append(dgs.tags, &durableTag{tag, dgs.options.Log.Syncer.Mark()})
+}
+
+/*
+PendingTag returns the lowest tag whose transactions have not yet been synced
+(0 if all tagged transactions are synced). Tags stay pending once a sync has
+failed.
+*/
+func (dgs *DiskGraphStorage) PendingTag() uint64 {
+	dgs.tagMutex.Lock()
+	defer dgs.tagMutex.Unlock()
+
+	for len(dgs.tags) > 0 {
+		if !dgs.options.Log.Syncer.Synced(dgs.tags[0].mark) {
+			return dgs.tags[0].tag
+		}
+
+		dgs.tags = dgs.tags[1:]
+	}
+
+	return 0
+}
+
+/*
+DurableNotify returns a channel which is closed once the next sync of the
+transaction logs has finished. Returns nil if every transaction is synced when
+it is committed.
+*/
+func (dgs *DiskGraphStorage) DurableNotify() <-chan struct{} {
+	if dgs.options.Log.Syncer == nil {
+		return nil
+	}
+
+	return dgs.options.Log.Syncer.SyncNotify()
+}
+
+/*
+RekeyDiskGraphStorage rewrites all files of a DiskGraphStorage (the main
+database and all StorageManager files) using a new encryption key. The
+DiskGraphStorage must not be open while its files are being rewritten. A nil
+key means no encryption. All rewritten files are recorded in a manifest file
+so an interrupted rekey is resumed by calling this function again with the
+same keys.
+*/
+func RekeyDiskGraphStorage(name string, oldKey []byte, newKey []byte) error {
+	suffix := fmt.Sprintf(".%v.0", storage.FileSuffixPhysicalSlots)
+
+	m, err := file.OpenRewriteManifest(filepath.Join(name, FilenameRekeyManifest))
+	if err != nil {
+		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	// Reading the main database first ensures that the old key is correct
+	// before any file is rewritten. The main database is written last - if
+	// a previous rekey was interrupted after it was written then it can
+	// only be read with the new key.
+
+	mainDB, err := loadMainDatabase(filepath.Join(name, FilenameNameDB), oldKey, true)
+	if err != nil && m.Exists() {
+		mainDB, err = loadMainDatabase(filepath.Join(name, FilenameNameDB), newKey, true)
+	}
+	if err != nil {
+		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	files, err := filepath.Glob(filepath.Join(name, "*"+suffix))
+	if err != nil {
+		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	for _, f := range files {
+		if err := storage.RekeyDiskStorageManagerWithManifest(strings.TrimSuffix(f, suffix),
+			oldKey, newKey, m); err != nil {
+
+			return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+		}
+	}
+
+	mainDB.key = newKey
+
+	if err := mainDB.Flush(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	if err := m.Remove(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+CompactDiskGraphStorage compacts all StorageManager files of a DiskGraphStorage.
+The stored data is rewritten without any gaps and unused space is released.
+The DiskGraphStorage must not be in use while its files are being compacted.
+*/
+func CompactDiskGraphStorage(name string, options storage.DiskStorageManagerOptions) error {
+	suffix := fmt.Sprintf(".%v.0", storage.FileSuffixPhysicalSlots)
+
+	// The files of a replica are owned by another process
+
+	if options.Replica {
+		return &util.GraphError{Type: util.ErrOpening,
+			Detail: fmt.Sprint("Cannot compact a replica of graph storage ", name)}
+	}
+
+	files, err := filepath.Glob(filepath.Join(name, "*"+suffix))
+	if err != nil {
+		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	gs, err := NewDiskGraphStorageWithOptions(name, false, options)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		sm := gs.StorageManager(strings.TrimSuffix(filepath.Base(f), suffix), false)
+
+		if compactor, ok := sm.(storage.Compactor); ok {
+
+			if err := compactor.Compact(); err != nil {
+				gs.Close()
+
+				return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+			}
+		}
+	}
+
+	return gs.Close()
+}
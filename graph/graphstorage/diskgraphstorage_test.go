@@ -11,21 +11,37 @@
 package graphstorage
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
-	"github.com/krotik/common/datautil"
 	"github.com/krotik/common/fileutil"
 	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 const diskGraphStorageTestDBDir = "diskgraphstoragetest1"
 const diskGraphStorageTestDBDir2 = "diskgraphstoragetest2"
-
-var dbdirs = []string{diskGraphStorageTestDBDir, diskGraphStorageTestDBDir2}
+const diskGraphStorageTestDBDir3 = "diskgraphstoragetest3"
+const diskGraphStorageTestDBDir4 = "diskgraphstoragetest4"
+const diskGraphStorageTestDBDir5 = "diskgraphstoragetest5"
+const diskGraphStorageTestDBDir6 = "diskgraphstoragetest6"
+const diskGraphStorageTestSnapshotDir = "diskgraphstoragesnapshot"
+const diskGraphStorageTestSnapshotDir2 = "diskgraphstoragesnapshot2"
+const diskGraphStorageTestArchiveDir = "diskgraphstoragearchive"
+const kvGraphStorageTestDBDir = "kvgraphstoragetest"
+const replicaTestDBDir = "replicatest"
+
+var dbdirs = []string{diskGraphStorageTestDBDir, diskGraphStorageTestDBDir2,
+	diskGraphStorageTestDBDir3, diskGraphStorageTestDBDir4, diskGraphStorageTestDBDir5, diskGraphStorageTestDBDir6,
+	diskGraphStorageTestSnapshotDir, diskGraphStorageTestSnapshotDir2,
+	diskGraphStorageTestArchiveDir, kvGraphStorageTestDBDir, replicaTestDBDir}
 
 const invalidFileName = "**" + "\x00"
 
@@ -59,6 +75,350 @@ func TestMain(m *testing.M) {
 	os.Exit(res)
 }
 
+func TestDiskGraphStorageOptions(t *testing.T) {
+	dgs, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir2, false,
+		storage.DiskStorageManagerOptions{
+			Format: storage.FormatOptions{Compression: storage.CompressionFlate, Codec: storage.CodecBinary},
+			Read:   storage.ReadOptions{CacheMaxBytes: 1500}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm := dgs.StorageManager("test1", true)
+
+	dsm := sm.(*storage.VersionedStorageManager).DiskStorageManager()
+	if res := dsm.Compression(); res != storage.CompressionFlate {
+		t.Error("Unexpected compression mode:", res)
+		return
+	}
+
+	if res := dsm.Codec(); res != storage.CodecBinary {
+		t.Error("Unexpected codec:", res)
+		return
+	}
+
+	// All caches share the same memory budget
+
+	css := dgs.(CacheStatsStorage)
+
+	if res := css.CacheMaxSize(); res != 1500 {
+		t.Error("Unexpected cache max size:", res)
+		return
+	}
+
+	sm.Insert(strings.Repeat("x", 1000))
+	dgs.StorageManager("test2", true).Insert(strings.Repeat("y", 1000))
+
+	if res := css.CacheStats(); res.Objects != 1 || res.Evictions != 1 ||
+		res.Size > 1500 {
+		t.Error("Unexpected cache stats:", res)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	os.RemoveAll(diskGraphStorageTestDBDir2)
+}
+
+func TestDiskGraphStorageSyncPolicy(t *testing.T) {
+	var res string
+
+	dgs, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir6, false,
+		storage.DiskStorageManagerOptions{Log: storage.LogOptions{SyncPolicy: file.SyncPolicy{Mode: file.SyncGroup}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// All StorageManagers share the same syncer
+
+	syncer := dgs.(*DiskGraphStorage).syncer
+
+	if syncer == nil || syncer.Policy().Mode != file.SyncGroup {
+		t.Error("Unexpected syncer:", syncer)
+		return
+	}
+
+	sm := dgs.StorageManager("test1", true)
+	loc, _ := sm.Insert("test1")
+
+	if err := sm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ch := dgs.(DurableStorage).DurableNotify()
+
+	dgs.(DurableStorage).TagDurable(1)
+
+	if err := dgs.(DurableStorage).WaitDurable(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case <-ch:
+	default:
+		t.Error("Sync was not notified")
+		return
+	}
+
+	if res := dgs.(DurableStorage).PendingTag(); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	sm.Update(loc, "test2")
+	sm.Flush()
+
+	dgs.(DurableStorage).TagDurable(2)
+
+	if res := dgs.(DurableStorage).PendingTag(); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Readonly storages and storages which sync every commit have no syncer
+
+	dgs, _ = NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir6, true,
+		storage.DiskStorageManagerOptions{Log: storage.LogOptions{SyncPolicy: file.SyncPolicy{Mode: file.SyncGroup}}})
+
+	if syncer := dgs.(*DiskGraphStorage).syncer; syncer != nil {
+		t.Error("Unexpected syncer:", syncer)
+		return
+	}
+
+	if err := dgs.StorageManager("test1", false).Fetch(loc, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dgs.(DurableStorage).WaitDurable(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.(DurableStorage).TagDurable(3)
+
+	if res := dgs.(DurableStorage).PendingTag(); res != 0 || dgs.(DurableStorage).DurableNotify() != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dgs.Close()
+
+	if _, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir6, false,
+		storage.DiskStorageManagerOptions{Log: storage.LogOptions{SyncPolicy: file.SyncPolicy{Mode: 5}}}); err == nil ||
+		err.Error() != "GraphError: Failed to open graph storage (Unknown sync mode)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestDiskGraphStorageRekey(t *testing.T) {
+	var res string
+
+	key, _ := file.ParseEncryptionKey("000102030405060708090a0b0c0d0e0f")
+
+	dgs, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir2, false,
+		storage.DiskStorageManagerOptions{EncryptionKey: key})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc1, _ := dgs.StorageManager("test1", true).Insert("test1")
+	loc2, _ := dgs.StorageManager("test2", true).Insert("test2")
+
+	dgs.MainDB()["mykind"] = "mysecret"
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The main database is encrypted as well
+
+	if res, _ := ioutil.ReadFile(diskGraphStorageTestDBDir2 + "/" + FilenameNameDB); bytes.Contains(res, []byte("mysecret")) {
+		t.Error("Main database should be encrypted")
+		return
+	}
+
+	if _, err := NewDiskGraphStorage(diskGraphStorageTestDBDir2, false); err == nil ||
+		err.Error() != "GraphError: Failed to open graph storage (Main database is encrypted)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir2, false,
+		storage.DiskStorageManagerOptions{EncryptionKey: key[:15]}); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Failed to open graph storage (Invalid encryption key") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := RekeyDiskGraphStorage(diskGraphStorageTestDBDir2, key, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := ioutil.ReadFile(diskGraphStorageTestDBDir2 + "/" + FilenameNameDB); !bytes.Contains(res, []byte("mysecret")) {
+		t.Error("Main database should not be encrypted")
+		return
+	}
+
+	dgs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir2, false)
+
+	if res := dgs.MainDB()["mykind"]; res != "mysecret" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := dgs.StorageManager("test1", false).Fetch(loc1, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dgs.StorageManager("test2", false).Fetch(loc2, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dgs.Close()
+
+	// An unencrypted graph storage cannot be opened with a key
+
+	if _, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir2, false,
+		storage.DiskStorageManagerOptions{EncryptionKey: key}); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Failed to open graph storage (Graph storage is not encrypted - use rekey") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := RekeyDiskGraphStorage(diskGraphStorageTestDBDir2, key, nil); err == nil {
+		t.Error("Rekeying with the wrong key should fail")
+		return
+	}
+
+	// Simulate a rekey which was interrupted after the first StorageManager
+	// was rewritten
+
+	m, _ := file.OpenRewriteManifest(diskGraphStorageTestDBDir2 + "/" + FilenameRekeyManifest)
+
+	if err := storage.RekeyDiskStorageManagerWithManifest(diskGraphStorageTestDBDir2+"/test1",
+		nil, key, m); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir2, false,
+		storage.DiskStorageManagerOptions{EncryptionKey: key}); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Failed to open graph storage (Rekey of graph storage was interrupted") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Resuming only rewrites the remaining files
+
+	if err := RekeyDiskGraphStorage(diskGraphStorageTestDBDir2, nil, key); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := fileutil.PathExists(diskGraphStorageTestDBDir2 + "/" + FilenameRekeyManifest); res {
+		t.Error("Rekey manifest should have been removed")
+		return
+	}
+
+	dgs, err = NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir2, false,
+		storage.DiskStorageManagerOptions{EncryptionKey: key})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dgs.StorageManager("test1", false).Fetch(loc1, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dgs.StorageManager("test2", false).Fetch(loc2, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dgs.Close()
+
+	os.RemoveAll(diskGraphStorageTestDBDir2)
+}
+
+func TestDiskGraphStorageCompact(t *testing.T) {
+	var res string
+	var locs []uint64
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir3, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm := dgs.StorageManager("test1", true)
+
+	for i := 0; i < 100; i++ {
+		loc, _ := sm.Insert(fmt.Sprint("test", i, strings.Repeat("x", 1000)))
+		locs = append(locs, loc)
+	}
+
+	for _, loc := range locs[1:] {
+		sm.Free(loc)
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stat, _ := os.Stat(diskGraphStorageTestDBDir3 + "/test1.db.0")
+	oldSize := stat.Size()
+
+	if err := CompactDiskGraphStorage(diskGraphStorageTestDBDir3,
+		storage.DiskStorageManagerOptions{Replica: true}); err == nil ||
+		!strings.Contains(err.Error(), "Cannot compact a replica") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := CompactDiskGraphStorage(diskGraphStorageTestDBDir3,
+		storage.DiskStorageManagerOptions{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stat, _ := os.Stat(diskGraphStorageTestDBDir3 + "/test1.db.0"); stat.Size() >= oldSize {
+		t.Error("Unexpected file size:", oldSize, stat.Size())
+		return
+	}
+
+	dgs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir3, false)
+
+	if err := dgs.StorageManager("test1", false).Fetch(locs[0], &res); err != nil ||
+		res != fmt.Sprint("test0", strings.Repeat("x", 1000)) {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dgs.Close()
+}
+
 func TestDiskGraphStorage(t *testing.T) {
 	dgsnew, err := NewDiskGraphStorage(diskGraphStorageTestDBDir, false)
 	if err != nil {
@@ -187,9 +547,9 @@ func TestDiskGraphStorageErrors(t *testing.T) {
 	FilenameNameDB = old
 
 	dgs := &DiskGraphStorage{invalidFileName, false, nil,
-		make(map[string]storage.Manager)}
-	pm, _ := datautil.NewPersistentStringMap(invalidFileName)
-	dgs.mainDB = pm
+		make(map[string]storage.Manager), storage.DiskStorageManagerOptions{}, nil, nil, nil, nil,
+		storage.NewVersionTracker(), &sync.Mutex{}, nil, &sync.Mutex{}}
+	dgs.mainDB = &mainDatabase{invalidFileName, nil, make(map[string]string)}
 
 	msm := storage.NewMemoryStorageManager("test")
 	dgs.storagemanagers["test"] = msm
@@ -0,0 +1,252 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/kv"
+)
+
+/*
+FilenameKVStore is the filename for a key-value store file
+*/
+var FilenameKVStore = "datastore.kv"
+
+/*
+kvMainDBPrefix is the key prefix of all entries of the main database. Storage
+manager names never start with a zero byte.
+*/
+var kvMainDBPrefix = []byte("\x00main\x00")
+
+/*
+KVGraphStorage data structure
+*/
+type KVGraphStorage struct {
+	name            string                     // Name of the graph storage
+	store           kv.Store                   // Key-value store which holds all data
+	readonly        bool                       // Flag for readonly mode
+	codec           int                        // Codec for new StorageManagers
+	mainDB          map[string]string          // Database storing names
+	mainStored      map[string]string          // Last flushed version of the main database
+	storagemanagers map[string]storage.Manager // Map of StorageManagers
+	mutex           *sync.Mutex                // Mutex to protect the map of StorageManagers
+}
+
+/*
+NewKVGraphStorage creates a new KVGraphStorage instance which keeps the main
+database and all StorageManagers in a given ordered key-value store. New
+StorageManagers serialize their objects with the given codec. The store is
+closed when the graph storage is closed.
+*/
+func NewKVGraphStorage(name string, store kv.Store, readonly bool, codec int) (Storage, error) {
+
+	kgs := &KVGraphStorage{name, store, readonly, codec, nil, nil,
+		make(map[string]storage.Manager), &sync.Mutex{}}
+
+	if err := kgs.loadMainDB(); err != nil {
+		return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	return kgs, nil
+}
+
+/*
+loadMainDB loads the main database from the store.
+*/
+func (kgs *KVGraphStorage) loadMainDB() error {
+	mainDB := make(map[string]string)
+	mainStored := make(map[string]string)
+
+	err := kgs.store.Scan(kvMainDBPrefix, kv.PrefixEnd(kvMainDBPrefix),
+		func(key []byte, value []byte) bool {
+			k := string(key[len(kvMainDBPrefix):])
+			mainDB[k] = string(value)
+			mainStored[k] = string(value)
+			return true
+		})
+
+	if err == nil {
+		kgs.mainDB = mainDB
+		kgs.mainStored = mainStored
+	}
+
+	return err
+}
+
+/*
+Name returns the name of the KVGraphStorage instance.
+*/
+func (kgs *KVGraphStorage) Name() string {
+	return kgs.name
+}
+
+/*
+MainDB returns the main database.
+*/
+func (kgs *KVGraphStorage) MainDB() map[string]string {
+	return kgs.mainDB
+}
+
+/*
+RollbackMain rollback the main database.
+*/
+func (kgs *KVGraphStorage) RollbackMain() error {
+
+	// Fail operation when readonly
+
+	if kgs.readonly {
+		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot rollback main db"}
+	}
+
+	if err := kgs.loadMainDB(); err != nil {
+		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+FlushMain writes the main database to the storage.
+*/
+func (kgs *KVGraphStorage) FlushMain() error {
+
+	// Fail operation when readonly
+
+	if kgs.readonly {
+		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot flush main db"}
+	}
+
+	if err := kgs.flushMainDB(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+flushMainDB writes all changed entries of the main database to the store.
+*/
+func (kgs *KVGraphStorage) flushMainDB() error {
+	batch := kv.NewBatch()
+
+	for k, v := range kgs.mainDB {
+		if sv, ok := kgs.mainStored[k]; !ok || sv != v {
+			batch.Put(kgs.mainDBKey(k), []byte(v))
+		}
+	}
+
+	for k := range kgs.mainStored {
+		if _, ok := kgs.mainDB[k]; !ok {
+			batch.Delete(kgs.mainDBKey(k))
+		}
+	}
+
+	if err := kgs.store.Write(batch); err != nil {
+		return err
+	}
+
+	kgs.mainStored = make(map[string]string, len(kgs.mainDB))
+	for k, v := range kgs.mainDB {
+		kgs.mainStored[k] = v
+	}
+
+	return nil
+}
+
+/*
+mainDBKey returns the store key of an entry of the main database.
+*/
+func (kgs *KVGraphStorage) mainDBKey(key string) []byte {
+	return append(append([]byte(nil), kvMainDBPrefix...), key...)
+}
+
+/*
+StorageManager gets a storage manager with a certain name. A non-existing
+StorageManager is created automatically if the create flag is set to true and
+the storage is not readonly.
+*/
+func (kgs *KVGraphStorage) StorageManager(smname string, create bool) storage.Manager {
+	kgs.mutex.Lock()
+	defer kgs.mutex.Unlock()
+
+	sm, ok := kgs.storagemanagers[smname]
+
+	if !ok && ((create && !kgs.readonly) || storage.KVStorageManagerExists(smname, kgs.store)) {
+		sm = storage.NewKVStorageManager(smname, kgs.store, kgs.readonly, kgs.codec)
+		kgs.storagemanagers[smname] = sm
+	}
+
+	return sm
+}
+
+/*
+FlushAll writes all pending changes to the storage.
+*/
+func (kgs *KVGraphStorage) FlushAll() error {
+
+	if kgs.readonly {
+		return nil
+	}
+
+	var errors []string
+
+	err := kgs.flushMainDB()
+	if err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	kgs.mutex.Lock()
+	defer kgs.mutex.Unlock()
+
+	for _, sm := range kgs.storagemanagers {
+		err := sm.Flush()
+		if err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	if len(errors) > 0 {
+		details := fmt.Sprint(kgs.name, " :", strings.Join(errors, "; "))
+
+		return &util.GraphError{Type: util.ErrFlushing, Detail: details}
+	}
+
+	return nil
+}
+
+/*
+Close closes the storage.
+*/
+func (kgs *KVGraphStorage) Close() error {
+
+	var errors []string
+
+	if err := kgs.FlushAll(); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	if err := kgs.store.Close(); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	if len(errors) > 0 {
+		details := fmt.Sprint(kgs.name, " :", strings.Join(errors, "; "))
+
+		return &util.GraphError{Type: util.ErrClosing, Detail: details}
+	}
+
+	return nil
+}
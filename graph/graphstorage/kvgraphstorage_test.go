@@ -0,0 +1,197 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/btree"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestKVGraphStorage(t *testing.T) {
+	os.RemoveAll(kvGraphStorageTestDBDir)
+	os.MkdirAll(kvGraphStorageTestDBDir, 0770)
+
+	storeName := kvGraphStorageTestDBDir + "/store.kv"
+
+	store, err := btree.OpenStore(storeName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	kgs, err := NewKVGraphStorage("kvtest", store, false, storage.CodecBinary)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if kgs.Name() != "kvtest" || len(kgs.MainDB()) != 0 {
+		t.Error("Unexpected graph storage state")
+		return
+	}
+
+	// Main database
+
+	kgs.MainDB()["test1"] = "a"
+	kgs.MainDB()["test2"] = "b"
+
+	if err := kgs.FlushMain(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	kgs.MainDB()["test1"] = "c"
+	delete(kgs.MainDB(), "test2")
+
+	if err := kgs.RollbackMain(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(kgs.MainDB()); res != "map[test1:a test2:b]" {
+		t.Error("Unexpected main db:", res)
+		return
+	}
+
+	kgs.MainDB()["test1"] = "c"
+	delete(kgs.MainDB(), "test2")
+
+	// Storage managers are usable by HTrees
+
+	if sm := kgs.StorageManager("test", false); sm != nil {
+		t.Error("Storage manager should not exist")
+		return
+	}
+
+	sm := kgs.StorageManager("test", true)
+
+	if kgs.StorageManager("test", false) != sm {
+		t.Error("Unexpected storage manager")
+		return
+	}
+
+	htree, err := hash.NewHTree(sm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 1000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), fmt.Sprint("val", i))
+	}
+
+	sm.SetRoot(storage.RootIDVersion+1, htree.Location())
+
+	if err := kgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// All data is persisted
+
+	if store, err = btree.OpenStore(storeName); err != nil {
+		t.Error(err)
+		return
+	}
+
+	kgs, err = NewKVGraphStorage("kvtest", store, true, storage.CodecGob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(kgs.MainDB()); res != "map[test1:c]" {
+		t.Error("Unexpected main db:", res)
+		return
+	}
+
+	if kgs.StorageManager("test2", true) != nil {
+		t.Error("Storage manager should not exist")
+		return
+	}
+
+	sm = kgs.StorageManager("test", false)
+
+	htree, err = hash.LoadHTree(sm, sm.Root(storage.RootIDVersion+1))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := htree.Get([]byte("key999")); res != "val999" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Readonly storage cannot be changed
+
+	if err := kgs.FlushMain(); err.(*util.GraphError).Type != util.ErrReadOnly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kgs.RollbackMain(); err.(*util.GraphError).Type != util.ErrReadOnly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kgs.FlushAll(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := sm.Insert("test"); err != storage.ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Errors of the store are reported
+
+	if _, err := NewKVGraphStorage("kvtest", store, false, storage.CodecGob); err == nil ||
+		err.(*util.GraphError).Type != util.ErrOpening {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	store, _ = btree.OpenStore(storeName)
+	kgs, _ = NewKVGraphStorage("kvtest", store, false, storage.CodecGob)
+	kgs.StorageManager("test", false).SetRoot(5, 5)
+	kgs.MainDB()["test3"] = "d"
+	store.Close()
+
+	if err := kgs.FlushMain(); err.(*util.GraphError).Type != util.ErrFlushing {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kgs.RollbackMain(); err.(*util.GraphError).Type != util.ErrOpening {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := kgs.Close(); err == nil || err.(*util.GraphError).Type != util.ErrClosing ||
+		err.Error() != "GraphError: Failed to close graph storage (kvtest :"+
+			"GraphError: Failed to flush changes (kvtest :Store is closed; Store is closed); Store is closed)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
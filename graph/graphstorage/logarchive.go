@@ -0,0 +1,276 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+/*
+MainDBArchiveSuffix is the file suffix for the archive of the main database
+*/
+const MainDBArchiveSuffix = "archive"
+
+/*
+mainDBArchiveEntryHeaderSize is the size of the header of an archived version
+of the main database (archive time and length of the data)
+*/
+const mainDBArchiveEntryHeaderSize = 16
+
+/*
+openMainDBArchive opens the archive of the main database for appending.
+*/
+func (dgs *DiskGraphStorage) openMainDBArchive() error {
+
+	if err := os.MkdirAll(dgs.options.Log.Archive, 0770); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(mainDBArchiveName(dgs.options.Log.Archive),
+		os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		return err
+	}
+
+	// Discard an incomplete version at the end of the archive
+
+	offset, last, err := readMainDBArchive(f, math.MaxInt64, dgs.options.EncryptionKey)
+
+	if err == nil {
+		err = f.Truncate(offset)
+	}
+
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	dgs.mainArchive = f
+	dgs.mainArchived = last
+
+	return nil
+}
+
+/*
+archiveMainDB appends the current version of the main database to its archive
+if it has changed since it was last archived.
+*/
+func (dgs *DiskGraphStorage) archiveMainDB() error {
+
+	if dgs.mainArchive == nil || mapsEqual(dgs.mainArchived, dgs.mainDB.Data) {
+		return nil
+	}
+
+	// Archived versions are encrypted like the main database itself
+
+	data, err := encodeMainDBData(dgs.mainDB.Data, dgs.options.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	entry := append(make([]byte, mainDBArchiveEntryHeaderSize), data...)
+
+	binary.LittleEndian.PutUint64(entry, uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint64(entry[8:],
+		uint64(len(entry)-mainDBArchiveEntryHeaderSize))
+
+	if _, err := dgs.mainArchive.Write(entry); err != nil {
+		return err
+	}
+
+	if err := dgs.mainArchive.Sync(); err != nil {
+		return err
+	}
+
+	dgs.mainArchived = make(map[string]string, len(dgs.mainDB.Data))
+
+	for k, v := range dgs.mainDB.Data {
+		dgs.mainArchived[k] = v
+	}
+
+	return nil
+}
+
+/*
+ReplayDiskGraphStorage replays all archived transactions of a DiskGraphStorage
+which were committed after a given time and before or at another given time
+(Unix time in nanoseconds). The archived transactions are read from the given
+archive directory. A restored snapshot together with all transactions which
+were archived since the snapshot was taken (see SnapshotTime) can be used to
+reconstruct a DiskGraphStorage at any point in time after the snapshot. The
+main database is replaced with its latest archived version in the replayed
+time range. The DiskGraphStorage must not be open while the transactions are
+replayed. Returns the number of replayed transactions.
+*/
+func ReplayDiskGraphStorage(name string, key []byte, archive string, from int64, until int64) (int, error) {
+	var count int
+
+	// A snapshot cannot be moved back in time
+
+	if until < from {
+		return 0, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprintf("Replay time %v is before the snapshot time %v",
+				time.Unix(0, until).UTC().Format(time.RFC3339Nano),
+				time.Unix(0, from).UTC().Format(time.RFC3339Nano))}
+	}
+
+	// Storage managers which were created after the snapshot was taken
+	// are only found in the archive
+
+	suffix := fmt.Sprintf(".%v.%v", storage.FileSuffixPhysicalSlots, file.LogArchiveSuffix)
+
+	files, err := filepath.Glob(filepath.Join(archive, "*"+suffix))
+	if err != nil {
+		return 0, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	for _, f := range files {
+		smname := strings.TrimSuffix(filepath.Base(f), suffix)
+
+		c, err := storage.ReplayDiskStorageManager(filepath.Join(name, smname), key, archive, from, until)
+		if err != nil {
+			return count, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+
+		count += c
+	}
+
+	// Restore the last version of the main database
+
+	f, err := os.Open(mainDBArchiveName(archive))
+	if os.IsNotExist(err) {
+		return count, nil
+	} else if err != nil {
+		return count, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+	defer f.Close()
+
+	// The main database of the snapshot is only replaced if there is a
+	// newer archived version
+
+	fromOffset, _, err := readMainDBArchive(f, from, key)
+	if err != nil {
+		return count, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	offset, data, err := readMainDBArchive(f, until, key)
+	if err != nil {
+		return count, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	if data != nil && offset > fromOffset {
+		mainDB := &mainDatabase{filepath.Join(name, FilenameNameDB), key, data}
+
+		if err := mainDB.Flush(); err != nil {
+			return count, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+	}
+
+	return count, nil
+}
+
+/*
+mainDBArchiveName returns the name of the archive of the main database.
+*/
+func mainDBArchiveName(archive string) string {
+	return filepath.Join(archive, fmt.Sprintf("%v.%v", FilenameNameDB, MainDBArchiveSuffix))
+}
+
+/*
+readMainDBArchive reads the latest complete version of the main database from
+an archive which was archived before or at a given time. Returns the offset
+after the read version and its data (nil if no version was found). Archived
+versions are decrypted with the given key (nil if they are not encrypted).
+*/
+func readMainDBArchive(f *os.File, until int64, key []byte) (int64, map[string]string, error) {
+	var offset, last, lastLength int64
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	header := make([]byte, mainDBArchiveEntryHeaderSize)
+
+	for offset+mainDBArchiveEntryHeaderSize <= stat.Size() {
+
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return 0, nil, err
+		}
+
+		ts := int64(binary.LittleEndian.Uint64(header))
+		length := int64(binary.LittleEndian.Uint64(header[8:]))
+
+		if ts > until || length < 0 ||
+			offset+mainDBArchiveEntryHeaderSize+length > stat.Size() {
+			break
+		}
+
+		last = offset
+		lastLength = length
+		offset += mainDBArchiveEntryHeaderSize + length
+	}
+
+	if offset == 0 {
+		return 0, nil, nil
+	}
+
+	entry := make([]byte, lastLength)
+
+	if _, err := f.ReadAt(entry, last+mainDBArchiveEntryHeaderSize); err != nil {
+		return 0, nil, err
+	}
+
+	if entry, err = decryptMainDBData(entry, key); err != nil {
+		return 0, nil, err
+	}
+
+	data := make(map[string]string)
+
+	err = gob.NewDecoder(bytes.NewReader(entry)).Decode(&data)
+
+	return offset, data, err
+}
+
+/*
+mapsEqual checks if two string maps have the same content.
+*/
+func mapsEqual(m1 map[string]string, m2 map[string]string) bool {
+
+	if m1 == nil || len(m1) != len(m2) {
+		return false
+	}
+
+	for k, v := range m1 {
+		if v2, ok := m2[k]; !ok || v != v2 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,156 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestDiskGraphStorageReplay(t *testing.T) {
+	var res string
+
+	options := storage.DiskStorageManagerOptions{Log: storage.LogOptions{Archive: diskGraphStorageTestArchiveDir}}
+
+	dgs, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir5, false, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc, _ := dgs.StorageManager("test1", true).Insert("test0")
+	dgs.MainDB()["test"] = "0"
+
+	if err := dgs.FlushAll(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	beforeSnapshot := time.Now().UnixNano()
+
+	dgs.StorageManager("test1", false).Update(loc, "test1")
+	dgs.MainDB()["test"] = "1"
+
+	if err := dgs.(*DiskGraphStorage).Snapshot(diskGraphStorageTestSnapshotDir2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	snapshotTime, err := SnapshotTime(diskGraphStorageTestSnapshotDir2)
+	if err != nil || snapshotTime < beforeSnapshot {
+		t.Error("Unexpected snapshot time:", snapshotTime, err)
+		return
+	}
+
+	afterSnapshot := time.Now().UnixNano()
+
+	dgs.StorageManager("test1", false).Update(loc, "test2")
+	loc2, _ := dgs.StorageManager("test2", true).Insert("test")
+	dgs.MainDB()["test"] = "2"
+
+	if err := dgs.FlushAll(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	pointInTime := time.Now().UnixNano()
+
+	dgs.StorageManager("test1", false).Update(loc, "test3")
+	dgs.MainDB()["test"] = "3"
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkReplay := func(until int64, expectedCount bool, expected string, expectedTest2 bool) {
+
+		if err := RestoreDiskGraphStorage(diskGraphStorageTestSnapshotDir2,
+			diskGraphStorageTestDBDir5); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Only transactions after the snapshot are replayed
+
+		if count, err := ReplayDiskGraphStorage(diskGraphStorageTestDBDir5, nil,
+			diskGraphStorageTestArchiveDir, snapshotTime, until); err != nil || (count > 0) != expectedCount {
+			t.Error("Unexpected replay result:", count, err)
+			return
+		}
+
+		dgs, _ := NewDiskGraphStorage(diskGraphStorageTestDBDir5, true)
+		defer dgs.Close()
+
+		if err := dgs.StorageManager("test1", false).Fetch(loc, &res); err != nil ||
+			res != "test"+expected {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+
+		if res := dgs.MainDB()["test"]; res != expected {
+			t.Error("Unexpected main db value:", res)
+			return
+		}
+
+		if sm := dgs.StorageManager("test2", false); (sm != nil) != expectedTest2 {
+			t.Error("Unexpected storage manager:", sm)
+			return
+		} else if sm != nil {
+			if err := sm.Fetch(loc2, &res); err != nil || res != "test" {
+				t.Error("Unexpected result:", res, err)
+			}
+		}
+	}
+
+	checkReplay(afterSnapshot, false, "1", false)
+	checkReplay(pointInTime, true, "2", true)
+	checkReplay(math.MaxInt64, true, "3", true)
+
+	// A snapshot cannot be replayed to a time before it was taken
+
+	if _, err := ReplayDiskGraphStorage(diskGraphStorageTestDBDir5, nil,
+		diskGraphStorageTestArchiveDir, snapshotTime, beforeSnapshot); err == nil ||
+		!strings.Contains(err.Error(), "is before the snapshot time") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Only the main database is archived again if it has changed
+
+	stat, _ := os.Stat(mainDBArchiveName(diskGraphStorageTestArchiveDir))
+
+	dgs, _ = NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir5, false, options)
+	dgs.FlushMain()
+	dgs.Close()
+
+	if stat2, _ := os.Stat(mainDBArchiveName(diskGraphStorageTestArchiveDir)); stat2.Size() != stat.Size() {
+		t.Error("Unchanged main db should not be archived")
+		return
+	}
+
+	if _, err := ReplayDiskGraphStorage(diskGraphStorageTestDBDir5, []byte("0123456789abcdef"),
+		diskGraphStorageTestArchiveDir, 0, math.MaxInt64); err == nil {
+		t.Error("Replaying with the wrong key should fail")
+		return
+	}
+
+	if _, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir5, false,
+		storage.DiskStorageManagerOptions{Log: storage.LogOptions{Archive: invalidFileName}}); err == nil {
+		t.Error("Invalid archive directory should cause an error")
+		return
+	}
+}
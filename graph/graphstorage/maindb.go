@@ -0,0 +1,149 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+/*
+ErrMainDBEncrypted is returned if an encrypted main database is read without a key.
+*/
+var ErrMainDBEncrypted = errors.New("Main database is encrypted")
+
+/*
+ErrMainDBUnencrypted is returned if an unencrypted main database is read with a key.
+*/
+var ErrMainDBUnencrypted = errors.New("Main database is not encrypted")
+
+/*
+MainDBHeaderEncrypted is the magic number to identify an encrypted main database
+*/
+var MainDBHeaderEncrypted = []byte{0x66, 0x4d}
+
+/*
+mainDatabase is the main database of a DiskGraphStorage which holds names,
+kinds, schemas and constraint definitions. It is stored in a single file which
+is encrypted like all other files of the DiskGraphStorage if an encryption key
+is given.
+*/
+type mainDatabase struct {
+	filename string            // File of the main database
+	key      []byte            // Encryption key (nil for no encryption)
+	Data     map[string]string // Data of the main database
+}
+
+/*
+newMainDatabase creates a new main database.
+*/
+func newMainDatabase(filename string, key []byte) (*mainDatabase, error) {
+	mdb := &mainDatabase{filename, key, make(map[string]string)}
+	return mdb, mdb.Flush()
+}
+
+/*
+loadMainDatabase loads a main database from a file. A non-existing file is
+created unless the strict flag is set. Data which cannot be decoded is ignored
+unless the strict flag is set. An encrypted file which cannot be decrypted
+is always an error. An unencrypted file cannot be loaded with a key since the
+other files of the graph storage would still be unencrypted.
+*/
+func loadMainDatabase(filename string, key []byte, strict bool) (*mainDatabase, error) {
+	var data []byte
+	var err error
+
+	if strict {
+		data, err = ioutil.ReadFile(filename)
+	} else {
+		var f *os.File
+
+		if f, err = os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0660); err == nil {
+			data, err = ioutil.ReadAll(f)
+			f.Close()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	mdb := &mainDatabase{filename, key, make(map[string]string)}
+
+	if len(data) > 0 {
+		if data, err = decryptMainDBData(data, key); err != nil {
+			return nil, err
+		}
+
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&mdb.Data); err != nil && strict {
+			return nil, err
+		}
+	}
+
+	return mdb, nil
+}
+
+/*
+Flush writes the contents of the main database to disk.
+*/
+func (mdb *mainDatabase) Flush() error {
+	data, err := encodeMainDBData(mdb.Data, mdb.key)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(mdb.filename, data, 0660)
+}
+
+/*
+encodeMainDBData encodes the data of a main database. The encoded data is
+encrypted if a key is given.
+*/
+func encodeMainDBData(data map[string]string, key []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return buf.Bytes(), nil
+	}
+
+	sealed, err := file.EncryptData(key, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, MainDBHeaderEncrypted...), sealed...), nil
+}
+
+/*
+decryptMainDBData decrypts encoded main database data if it is encrypted.
+*/
+func decryptMainDBData(data []byte, key []byte) ([]byte, error) {
+
+	if !bytes.HasPrefix(data, MainDBHeaderEncrypted) {
+		if key != nil {
+			return nil, ErrMainDBUnencrypted
+		}
+		return data, nil
+	} else if key == nil {
+		return nil, ErrMainDBEncrypted
+	}
+
+	return file.DecryptData(key, data[len(MainDBHeaderEncrypted):])
+}
@@ -0,0 +1,104 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
+)
+
+func TestMainDatabase(t *testing.T) {
+	key, _ := file.ParseEncryptionKey("000102030405060708090a0b0c0d0e0f")
+
+	os.Mkdir(diskGraphStorageTestDBDir6, 0770)
+	defer os.RemoveAll(diskGraphStorageTestDBDir6)
+
+	filename := diskGraphStorageTestDBDir6 + "/" + FilenameNameDB
+
+	// An unencrypted main database cannot be loaded with a key
+
+	mdb, _ := newMainDatabase(filename, nil)
+	mdb.Data["test"] = "mysecret"
+	mdb.Flush()
+
+	if _, err := loadMainDatabase(filename, key, true); err != ErrMainDBUnencrypted {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	mdb.key = key
+	mdb.Flush()
+
+	if res, _ := ioutil.ReadFile(filename); !bytes.HasPrefix(res, MainDBHeaderEncrypted) ||
+		bytes.Contains(res, []byte("mysecret")) {
+		t.Error("Main database should be encrypted:", res)
+		return
+	}
+
+	if mdb, err := loadMainDatabase(filename, key, false); err != nil || mdb.Data["test"] != "mysecret" {
+		t.Error("Unexpected result:", mdb, err)
+		return
+	}
+
+	if _, err := loadMainDatabase(filename, nil, false); err != ErrMainDBEncrypted {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := loadMainDatabase(filename, []byte("0123456789abcdef"), false); err != file.ErrDecrypt {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := loadMainDatabase(filename+"x", key, true); !os.IsNotExist(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Archived versions of the main database are encrypted as well
+
+	options := storage.DiskStorageManagerOptions{EncryptionKey: key,
+		Log: storage.LogOptions{Archive: diskGraphStorageTestDBDir6 + "/archive"}}
+
+	dgs, err := NewDiskGraphStorageWithOptions(diskGraphStorageTestDBDir6, false, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.MainDB()["test"] = "mysecret2"
+	dgs.Close()
+
+	if res, _ := ioutil.ReadFile(mainDBArchiveName(options.Log.Archive)); len(res) == 0 ||
+		bytes.Contains(res, []byte("mysecret")) {
+		t.Error("Main database archive should be encrypted:", res)
+		return
+	}
+
+	f, _ := os.Open(mainDBArchiveName(options.Log.Archive))
+	defer f.Close()
+
+	if _, data, err := readMainDBArchive(f, math.MaxInt64, key); err != nil || data["test"] != "mysecret2" {
+		t.Error("Unexpected result:", data, err)
+		return
+	}
+
+	if _, _, err := readMainDBArchive(f, math.MaxInt64, nil); err != ErrMainDBEncrypted {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"sync"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+ReadView returns a read-only Storage which shows the data of this
+DiskGraphStorage as it was when the view was opened. Changes which are
+written while the view is open are not visible to the view. Old versions of
+changed objects are kept in memory until the view is closed. No changes must
+be written while the view is opened.
+*/
+func (dgs *DiskGraphStorage) ReadView() (Storage, error) {
+
+	mainDB := make(map[string]string, len(dgs.mainDB.Data))
+	for k, v := range dgs.mainDB.Data {
+		mainDB[k] = v
+	}
+
+	return &diskGraphStorageView{dgs, dgs.versions.OpenSnapshot(), mainDB, &sync.Once{}}, nil
+}
+
+/*
+diskGraphStorageView is a read-only view of a DiskGraphStorage.
+*/
+type diskGraphStorageView struct {
+	dgs     *DiskGraphStorage // Viewed graph storage
+	version uint64            // Snapshot version of this view
+	mainDB  map[string]string // Copy of the main database
+	release *sync.Once        // Release of the snapshot
+}
+
+/*
+Name returns the name of the viewed graph storage.
+*/
+func (v *diskGraphStorageView) Name() string {
+	return v.dgs.name
+}
+
+/*
+MainDB returns a copy of the main database as it was when the view was opened.
+*/
+func (v *diskGraphStorageView) MainDB() map[string]string {
+	return v.mainDB
+}
+
+/*
+RollbackMain is not supported by a read view.
+*/
+func (v *diskGraphStorageView) RollbackMain() error {
+	return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot rollback main db of a read view"}
+}
+
+/*
+FlushMain is not supported by a read view.
+*/
+func (v *diskGraphStorageView) FlushMain() error {
+	return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot flush main db of a read view"}
+}
+
+/*
+FlushAll does nothing for a read view.
+*/
+func (v *diskGraphStorageView) FlushAll() error {
+	return nil
+}
+
+/*
+StorageManager gets a read-only view of a storage manager with a certain name.
+Storage managers which did not exist when the view was opened are not
+available. A read view never creates new storage managers.
+*/
+func (v *diskGraphStorageView) StorageManager(smname string, create bool) storage.Manager {
+
+	if vsm, ok := v.dgs.StorageManager(smname, false).(*storage.VersionedStorageManager); ok {
+		return vsm.View(v.version)
+	}
+
+	return nil
+}
+
+/*
+Close releases the view.
+*/
+func (v *diskGraphStorageView) Close() error {
+	v.release.Do(func() {
+		v.dgs.versions.ReleaseSnapshot(v.version)
+	})
+	return nil
+}
+
+/*
+OldVersions returns the number of old object versions which are currently kept
+for open read views.
+*/
+func (dgs *DiskGraphStorage) OldVersions() int {
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	var count int
+
+	for _, sm := range dgs.storagemanagers {
+		if vsm, ok := sm.(*storage.VersionedStorageManager); ok {
+			count += vsm.OldVersions()
+		}
+	}
+
+	return count
+}
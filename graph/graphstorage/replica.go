@@ -0,0 +1,63 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+Refresh discards all cached data of a replica and reads all changes which were
+committed by the process which writes the graph storage. Committed changes
+which have not yet been written to the data files are read from the
+transaction logs of the writing process. The main database is read again.
+If the main database cannot be read (e.g. because it is being written) then
+the previous version is kept and an error is returned.
+*/
+func (dgs *DiskGraphStorage) Refresh() error {
+
+	if !dgs.options.Replica {
+		return &util.GraphError{Type: util.ErrAccessComponent,
+			Detail: "Graph storage is not a replica"}
+	}
+
+	mainDB, err := loadMainDatabase(dgs.name+"/"+FilenameNameDB, dgs.options.EncryptionKey, true)
+	if err != nil {
+		return &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	dgs.mainDB = mainDB
+
+	var errors []string
+
+	dgs.mutex.Lock()
+	defer dgs.mutex.Unlock()
+
+	for _, sm := range dgs.storagemanagers {
+		if r, ok := sm.(storage.Refresher); ok {
+			if err := r.Refresh(); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		details := fmt.Sprint(dgs.name, " :", strings.Join(errors, "; "))
+
+		return &util.GraphError{Type: util.ErrOpening, Detail: details}
+	}
+
+	return nil
+}
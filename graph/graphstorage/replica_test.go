@@ -0,0 +1,165 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestDiskGraphStorageReplica(t *testing.T) {
+	var res string
+
+	replicaOptions := storage.DiskStorageManagerOptions{Replica: true}
+
+	if _, err := NewDiskGraphStorageWithOptions(replicaTestDBDir, false, replicaOptions); err == nil ||
+		err.Error() != "GraphError: Failed to open graph storage (Cannot open replica of non-existing graph storage replicatest)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dgs, err := NewDiskGraphStorage(replicaTestDBDir, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm := dgs.StorageManager("test1", true)
+
+	loc, err := sm.Insert("foo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.MainDB()["test1"] = "bar"
+
+	if err := dgs.FlushAll(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Open a replica while the graph storage is in use
+
+	replica, err := NewDiskGraphStorageWithOptions(replicaTestDBDir, false, replicaOptions)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if replica.MainDB()["test1"] != "bar" {
+		t.Error("Unexpected main db:", replica.MainDB())
+		return
+	}
+
+	rsm := replica.StorageManager("test1", true)
+
+	if err := rsm.Fetch(loc, &res); err != nil || res != "foo" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := rsm.Insert("foo"); err != storage.ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// A replica cannot create new storage managers
+
+	if sm := replica.StorageManager("test2", true); sm != nil {
+		t.Error("Unexpected storage manager:", sm)
+		return
+	}
+
+	// Changes of the writing process are visible after a refresh
+
+	if err := sm.Update(loc, "foo2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc2, err := dgs.StorageManager("test2", true).Insert("foo3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.MainDB()["test1"] = "bar2"
+
+	if err := dgs.FlushAll(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := replica.(RefreshStorage).Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if replica.MainDB()["test1"] != "bar2" {
+		t.Error("Unexpected main db:", replica.MainDB())
+		return
+	}
+
+	if err := rsm.Fetch(loc, &res); err != nil || res != "foo2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := replica.StorageManager("test2", false).Fetch(loc2, &res); err != nil || res != "foo3" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// The previous main db is kept if the main db cannot be read
+
+	mainDB, _ := ioutil.ReadFile(replicaTestDBDir + "/" + FilenameNameDB)
+	ioutil.WriteFile(replicaTestDBDir+"/"+FilenameNameDB, mainDB[:len(mainDB)/2], 0660)
+
+	if err := replica.(RefreshStorage).Refresh(); err == nil {
+		t.Error("Refresh should fail if the main db cannot be read")
+		return
+	}
+
+	if replica.MainDB()["test1"] != "bar2" {
+		t.Error("Unexpected main db:", replica.MainDB())
+		return
+	}
+
+	// Closing a replica does not write the main db
+
+	replica.MainDB()["test1"] = "bar3"
+
+	if err := replica.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := ioutil.ReadFile(replicaTestDBDir + "/" + FilenameNameDB); len(res) != len(mainDB)/2 {
+		t.Error("Main db was written by replica")
+		return
+	}
+
+	// Only replicas can be refreshed
+
+	if err := dgs.(RefreshStorage).Refresh(); err == nil ||
+		err.Error() != "GraphError: Failed to access graph storage component (Graph storage is not a replica)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
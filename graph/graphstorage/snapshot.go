@@ -0,0 +1,323 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krotik/common/fileutil"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+FilenameSnapshotManifest is the filename of the manifest of a snapshot. The
+manifest contains the SHA256 checksums of all files in the snapshot and the
+time when the snapshot was taken.
+*/
+var FilenameSnapshotManifest = "snapshot.sha256"
+
+/*
+snapshotTimePrefix is the prefix of the manifest line which holds the time
+when the snapshot was taken (Unix time in nanoseconds)
+*/
+const snapshotTimePrefix = "# time "
+
+/*
+Snapshot writes all pending changes and copies all files of the DiskGraphStorage
+into a given directory. The directory must not exist. The copy contains a manifest
+with checksums of all files. The caller must ensure that no changes are written
+while the snapshot is taken (e.g. graph.Manager.Snapshot).
+*/
+func (dgs *DiskGraphStorage) Snapshot(dir string) error {
+
+	if res, _ := fileutil.PathExists(dir); res {
+		return &util.GraphError{Type: util.ErrWriting,
+			Detail: fmt.Sprint("Snapshot directory already exists: ", dir)}
+	}
+
+	if err := dgs.FlushAll(); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(dgs.name)
+	if err != nil {
+		return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	var manifest []string
+
+	lockSuffix := "." + storage.FileSiffixLockfile
+
+	for _, f := range files {
+
+		// Lockfiles are not part of the data
+
+		if f.IsDir() || strings.HasSuffix(f.Name(), lockSuffix) {
+			continue
+		}
+
+		sum, err := copySnapshotFile(filepath.Join(dgs.name, f.Name()),
+			filepath.Join(dir, f.Name()))
+
+		if err != nil {
+			os.RemoveAll(dir)
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+
+		manifest = append(manifest, fmt.Sprintf("%v  %v\n", sum, f.Name()))
+	}
+
+	// All transactions which are committed after this time are not part of
+	// the snapshot
+
+	manifest = append([]string{fmt.Sprintf("%v%v\n", snapshotTimePrefix,
+		time.Now().UnixNano())}, manifest...)
+
+	err = ioutil.WriteFile(filepath.Join(dir, FilenameSnapshotManifest),
+		[]byte(strings.Join(manifest, "")), 0660)
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+VerifySnapshot checks that all files of a snapshot match the checksums in the
+snapshot manifest.
+*/
+func VerifySnapshot(dir string) error {
+
+	files, _, err := readSnapshotManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range sortedKeys(files) {
+
+		sum, err := fileChecksum(filepath.Join(dir, name))
+		if err != nil {
+			return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		}
+
+		if sum != files[name] {
+			return &util.GraphError{Type: util.ErrInvalidData,
+				Detail: fmt.Sprint("Checksum mismatch for snapshot file: ", name)}
+		}
+	}
+
+	return nil
+}
+
+/*
+RestoreDiskGraphStorage replaces the files of a DiskGraphStorage with the files
+of a snapshot. The snapshot is verified before and after it is copied. The
+DiskGraphStorage must not be open while it is restored.
+*/
+func RestoreDiskGraphStorage(snapshot string, name string) error {
+
+	if err := VerifySnapshot(snapshot); err != nil {
+		return err
+	}
+
+	files, _, _ := readSnapshotManifest(snapshot)
+
+	// Copy the snapshot next to the datastore
+
+	tmpName := name + ".restore"
+	oldName := name + ".old"
+
+	for _, n := range []string{tmpName, oldName} {
+		if err := os.RemoveAll(n); err != nil {
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+	}
+
+	if err := os.MkdirAll(tmpName, 0770); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	for _, f := range sortedKeys(files) {
+		sum, err := copySnapshotFile(filepath.Join(snapshot, f), filepath.Join(tmpName, f))
+
+		if err == nil && sum != files[f] {
+			err = fmt.Errorf("Checksum mismatch for restored file: %v", f)
+		}
+
+		if err != nil {
+			os.RemoveAll(tmpName)
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+	}
+
+	// Replace the datastore - the old datastore is only removed once the
+	// restored datastore is in place
+
+	if res, _ := fileutil.PathExists(name); res {
+		if err := os.Rename(name, oldName); err != nil {
+			os.RemoveAll(tmpName)
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+	}
+
+	if err := os.Rename(tmpName, name); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	if err := os.RemoveAll(oldName); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+SnapshotTime returns the time when a snapshot was taken (Unix time in
+nanoseconds). Returns 0 if the snapshot manifest does not record the time.
+*/
+func SnapshotTime(dir string) (int64, error) {
+	_, ts, err := readSnapshotManifest(dir)
+	return ts, err
+}
+
+/*
+readSnapshotManifest reads the manifest of a snapshot. Returns a map of
+filenames to checksums and the time when the snapshot was taken.
+*/
+func readSnapshotManifest(dir string) (map[string]string, int64, error) {
+	var ts int64
+
+	f, err := os.Open(filepath.Join(dir, FilenameSnapshotManifest))
+	if err != nil {
+		return nil, 0, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+	defer f.Close()
+
+	files := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, snapshotTimePrefix) {
+			if ts, err = strconv.ParseInt(strings.TrimPrefix(line, snapshotTimePrefix), 10, 64); err != nil {
+				return nil, 0, &util.GraphError{Type: util.ErrInvalidData,
+					Detail: fmt.Sprint("Invalid snapshot manifest entry: ", line)}
+			}
+			continue
+		}
+
+		fields := strings.SplitN(line, "  ", 2)
+
+		if len(fields) != 2 || fields[1] != filepath.Base(fields[1]) ||
+			fields[1] == FilenameSnapshotManifest {
+
+			return nil, 0, &util.GraphError{Type: util.ErrInvalidData,
+				Detail: fmt.Sprint("Invalid snapshot manifest entry: ", line)}
+		}
+
+		files[fields[1]] = fields[0]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	if _, ok := files[FilenameNameDB]; !ok {
+		return nil, 0, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Snapshot does not contain a main database: ", dir)}
+	}
+
+	return files, ts, nil
+}
+
+/*
+copySnapshotFile copies a single file and returns the checksum of the
+copied data.
+*/
+func copySnapshotFile(src string, dst string) (string, error) {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	if _, err = io.Copy(io.MultiWriter(out, h), in); err == nil {
+		err = out.Sync()
+	}
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+/*
+fileChecksum calculates the checksum of a file.
+*/
+func fileChecksum(name string) (string, error) {
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+/*
+sortedKeys returns the sorted keys of a string map.
+*/
+func sortedKeys(m map[string]string) []string {
+	var ret []string
+
+	for k := range m {
+		ret = append(ret, k)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
@@ -0,0 +1,142 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/krotik/common/fileutil"
+)
+
+func TestDiskGraphStorageSnapshot(t *testing.T) {
+	var res string
+
+	snapshot := diskGraphStorageTestSnapshotDir + "/snap1"
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir4, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm := dgs.StorageManager("test1", true)
+
+	loc, _ := sm.Insert("test1")
+	dgs.MainDB()["foo"] = "bar"
+
+	if err := dgs.(SnapshotStorage).Snapshot(snapshot); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dgs.(SnapshotStorage).Snapshot(snapshot); err == nil ||
+		!strings.Contains(err.Error(), "Snapshot directory already exists") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Lockfiles are not part of a snapshot
+
+	if res, _ := fileutil.PathExists(snapshot + "/test1.lck"); res {
+		t.Error("Lockfile should not be part of the snapshot")
+		return
+	}
+
+	// Change the datastore after the snapshot was taken
+
+	sm.Update(loc, "test2")
+	dgs.MainDB()["foo"] = "bar2"
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := VerifySnapshot(snapshot); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := RestoreDiskGraphStorage(snapshot, diskGraphStorageTestDBDir4); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir4, false)
+
+	if err := dgs.StorageManager("test1", false).Fetch(loc, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := dgs.MainDB()["foo"]; res != "bar" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dgs.Close()
+
+	if res, _ := fileutil.PathExists(diskGraphStorageTestDBDir4 + ".old"); res {
+		t.Error("Old datastore should have been removed")
+		return
+	}
+
+	// Corrupted snapshots are not restored
+
+	ioutil.WriteFile(snapshot+"/test1.db.0", []byte("foo"), 0660)
+
+	if err := RestoreDiskGraphStorage(snapshot, diskGraphStorageTestDBDir4); err == nil ||
+		err.Error() != "GraphError: Invalid data (Checksum mismatch for snapshot file: test1.db.0)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(snapshot+"/"+FilenameSnapshotManifest, []byte("123  ../foo\n"), 0660)
+
+	if err := VerifySnapshot(snapshot); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid snapshot manifest entry: 123  ../foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(snapshot+"/"+FilenameSnapshotManifest, []byte("# time foo\n"), 0660)
+
+	if _, err := SnapshotTime(snapshot); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid snapshot manifest entry: # time foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(snapshot+"/"+FilenameSnapshotManifest, []byte("123  foo\n"), 0660)
+
+	if err := VerifySnapshot(snapshot); err == nil ||
+		!strings.Contains(err.Error(), "Snapshot does not contain a main database") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	os.Remove(snapshot + "/" + FilenameSnapshotManifest)
+
+	if err := VerifySnapshot(snapshot); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Memory storage does not support snapshots
+
+	if _, ok := NewMemoryGraphStorage("mem").(SnapshotStorage); ok {
+		t.Error("Memory storage should not support snapshots")
+		return
+	}
+}
@@ -54,3 +54,96 @@ type Storage interface {
 	*/
 	Close() error
 }
+
+/*
+SnapshotStorage is a Storage which can create a consistent copy of its files
+while it is in use.
+*/
+type SnapshotStorage interface {
+
+	/*
+		Snapshot writes all pending changes and copies all storage files into
+		a given directory. No changes must be written while the snapshot is
+		taken.
+	*/
+	Snapshot(dir string) error
+}
+
+/*
+CacheStatsStorage is a Storage which caches stored objects and can report
+statistics of its caches.
+*/
+type CacheStatsStorage interface {
+
+	/*
+		CacheStats returns the combined statistics of all caches.
+	*/
+	CacheStats() storage.CacheStats
+
+	/*
+		CacheMaxSize returns the memory budget in bytes which is shared by
+		all caches (0 if there is no limit).
+	*/
+	CacheMaxSize() int64
+}
+
+/*
+DurableStorage is a Storage which may delay writing committed transactions to
+disk.
+*/
+type DurableStorage interface {
+
+	/*
+		WaitDurable blocks until all transactions which were committed before
+		the call have been written to disk.
+	*/
+	WaitDurable() error
+
+	/*
+		TagDurable tags all transactions which were committed before the call
+		with a given tag. Tags must be given in increasing order.
+	*/
+	TagDurable(tag uint64)
+
+	/*
+		PendingTag returns the lowest tag whose transactions have not yet been
+		written to disk (0 if all tagged transactions are on disk). Tags stay
+		pending once writing to disk has failed.
+	*/
+	PendingTag() uint64
+
+	/*
+		DurableNotify returns a channel which is closed once transactions have
+		been written to disk. Returns nil if every transaction is written to
+		disk when it is committed.
+	*/
+	DurableNotify() <-chan struct{}
+}
+
+/*
+ReadViewStorage is a Storage which can provide read-only views of a consistent
+version of its data while changes are being written.
+*/
+type ReadViewStorage interface {
+
+	/*
+		ReadView returns a read-only Storage which shows the data as it was
+		when the view was opened. No changes must be written while the view
+		is opened. The view must be closed once it is no longer needed.
+	*/
+	ReadView() (Storage, error)
+}
+
+/*
+RefreshStorage is a Storage which reads files that are written by another
+process and which can pick up the changes of that process.
+*/
+type RefreshStorage interface {
+
+	/*
+		Refresh discards all cached data and reads all changes which were
+		committed by the writing process. No other operations must run
+		while the storage is refreshed.
+	*/
+	Refresh() error
+}
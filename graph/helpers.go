@@ -19,6 +19,7 @@ import (
 
 	"github.com/krotik/common/stringutil"
 	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
 	"github.com/krotik/eliasdb/graph/util"
 	"github.com/krotik/eliasdb/hash"
 	"github.com/krotik/eliasdb/storage"
@@ -215,12 +216,12 @@ func (gm *Manager) getNodeStorageHTree(part string, kind string,
 		return nil, nil, nil
 	}
 
-	attrTree, err := gm.getHTree(gs, RootIDNodeHTree)
+	attrTree, err := gm.getHTree(gs, RootIDNodeHTree, kind)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	valTree, err := gm.getHTree(gs, RootIDNodeHTreeSecond)
+	valTree, err := gm.getHTree(gs, RootIDNodeHTreeSecond, kind)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -273,7 +274,7 @@ func (gm *Manager) getEdgeStorageHTree(part string, kind string, create bool) (*
 		return nil, nil
 	}
 
-	return gm.getHTree(gs, RootIDNodeHTree)
+	return gm.getHTree(gs, RootIDNodeHTree, kind)
 }
 
 /*
@@ -283,6 +284,21 @@ func (gm *Manager) getNodeIndexHTree(part string, kind string, create bool) (*ha
 	return gm.getIndexHTree(part, kind, create, "Node", StorageSuffixNodesIndex)
 }
 
+/*
+getNodeUniqueHTree gets a HTree which can be used to look up unique attribute
+values of nodes.
+*/
+func (gm *Manager) getNodeUniqueHTree(part string, kind string, create bool) (*hash.HTree, error) {
+	return gm.getIndexHTree(part, kind, create, "Node", StorageSuffixNodesUnique)
+}
+
+/*
+getNodeHistoryHTree gets a HTree which can be used to store versions of nodes.
+*/
+func (gm *Manager) getNodeHistoryHTree(part string, kind string, create bool) (*hash.HTree, error) {
+	return gm.getIndexHTree(part, kind, create, "Node", StorageSuffixNodesHistory)
+}
+
 /*
 getEdgeIndexHTree gets a HTree which can be used to index edges.
 */
@@ -290,6 +306,29 @@ func (gm *Manager) getEdgeIndexHTree(part string, kind string, create bool) (*ha
 	return gm.getIndexHTree(part, kind, create, "Edge", StorageSuffixEdgesIndex)
 }
 
+/*
+getEdgeHistoryHTree gets a HTree which can be used to store versions of edges.
+*/
+func (gm *Manager) getEdgeHistoryHTree(part string, kind string, create bool) (*hash.HTree, error) {
+	return gm.getIndexHTree(part, kind, create, "Edge", StorageSuffixEdgesHistory)
+}
+
+/*
+getChangeLogHTree gets the HTree which stores the change log.
+*/
+func (gm *Manager) getChangeLogHTree(create bool) (*hash.HTree, error) {
+
+	gm.storageMutex.Lock()
+	defer gm.storageMutex.Unlock()
+
+	gs := gm.gs.StorageManager(StorageChangeLog, create)
+	if gs == nil {
+		return nil, nil
+	}
+
+	return gm.getHTree(gs, RootIDNodeHTree, "")
+}
+
 /*
 getIndexHTree gets a HTree which can be used to index items.
 */
@@ -318,7 +357,7 @@ func (gm *Manager) getIndexHTree(part string, kind string, create bool, name str
 		return nil, nil
 	}
 
-	return gm.getHTree(gs, RootIDNodeHTree)
+	return gm.getHTree(gs, RootIDNodeHTree, kind)
 }
 
 /*
@@ -345,6 +384,30 @@ func (gm *Manager) flushNodeIndex(part string, kind string) error {
 	return nil
 }
 
+/*
+flushNodeUnique flushes a node unique constraint lookup.
+*/
+func (gm *Manager) flushNodeUnique(part string, kind string) error {
+	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesUnique, false); sm != nil {
+		if err := sm.Flush(); err != nil {
+			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+/*
+flushNodeHistory flushes a node history.
+*/
+func (gm *Manager) flushNodeHistory(part string, kind string) error {
+	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesHistory, false); sm != nil {
+		if err := sm.Flush(); err != nil {
+			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
 /*
 flushEdgeStorage flushes an edge storage.
 */
@@ -369,6 +432,49 @@ func (gm *Manager) flushEdgeIndex(part string, kind string) error {
 	return nil
 }
 
+/*
+flushEdgeHistory flushes an edge history.
+*/
+func (gm *Manager) flushEdgeHistory(part string, kind string) error {
+	if sm := gm.gs.StorageManager(part+kind+StorageSuffixEdgesHistory, false); sm != nil {
+		if err := sm.Flush(); err != nil {
+			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+/*
+flushChangeLog flushes the change log.
+*/
+func (gm *Manager) flushChangeLog() error {
+	if sm := gm.gs.StorageManager(StorageChangeLog, false); sm != nil {
+		if err := sm.Flush(); err != nil {
+			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+/*
+waitDurable blocks until all committed changes have been written to disk
+according to the sync policy of the graph storage. Consumers of the change log
+are notified about all change sets which were written before the call. It must
+not be called while holding the writer lock so concurrent writers can share a
+single sync.
+*/
+func (gm *Manager) waitDurable() error {
+	if ds, ok := gm.gs.(graphstorage.DurableStorage); ok {
+		if err := ds.WaitDurable(); err != nil {
+			return err
+		}
+	}
+
+	gm.changes.notify()
+
+	return nil
+}
+
 /*
 rollbackNodeStorage rollbacks a node storage.
 */
@@ -393,6 +499,30 @@ func (gm *Manager) rollbackNodeIndex(part string, kind string) error {
 	return nil
 }
 
+/*
+rollbackNodeUnique rollbacks a node unique constraint lookup.
+*/
+func (gm *Manager) rollbackNodeUnique(part string, kind string) error {
+	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesUnique, false); sm != nil {
+		if err := sm.Rollback(); err != nil {
+			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+/*
+rollbackNodeHistory rollbacks a node history.
+*/
+func (gm *Manager) rollbackNodeHistory(part string, kind string) error {
+	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesHistory, false); sm != nil {
+		if err := sm.Rollback(); err != nil {
+			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
 /*
 rollbackEdgeStorage rollbacks an edge storage.
 */
@@ -417,11 +547,36 @@ func (gm *Manager) rollbackEdgeIndex(part string, kind string) error {
 	return nil
 }
 
+/*
+rollbackEdgeHistory rollbacks an edge history.
+*/
+func (gm *Manager) rollbackEdgeHistory(part string, kind string) error {
+	if sm := gm.gs.StorageManager(part+kind+StorageSuffixEdgesHistory, false); sm != nil {
+		if err := sm.Rollback(); err != nil {
+			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+/*
+rollbackChangeLog rollbacks the change log.
+*/
+func (gm *Manager) rollbackChangeLog() error {
+	if sm := gm.gs.StorageManager(StorageChangeLog, false); sm != nil {
+		if err := sm.Rollback(); err != nil {
+			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
 /*
 getHTree creates or loads a HTree from a given StorageManager. HTrees are not cached
-since the creation shouldn't have too much overhead.
+since the creation shouldn't have too much overhead. New HTrees are created with
+the tree options of the given kind.
 */
-func (gm *Manager) getHTree(sm storage.Manager, slot int) (*hash.HTree, error) {
+func (gm *Manager) getHTree(sm storage.Manager, slot int, kind string) (*hash.HTree, error) {
 	var htree *hash.HTree
 	var err error
 
@@ -431,7 +586,11 @@ func (gm *Manager) getHTree(sm storage.Manager, slot int) (*hash.HTree, error) {
 
 		// Create a new HTree and store its location
 
-		htree, err = hash.NewHTree(sm)
+		if opts := gm.readTreeOptions(kind); opts != nil {
+			htree, err = hash.NewCustomHTree(sm, *opts)
+		} else {
+			htree, err = hash.NewHTree(sm)
+		}
 
 		if err != nil {
 			err = &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
@@ -0,0 +1,420 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+HistoryRetention models the history retention of a node or edge kind. Each
+commit which changes a node or edge of the kind stores a new version of the
+item. Versions are removed once they exceed the limits of the retention - the
+latest version of an item is always kept.
+*/
+type HistoryRetention struct {
+	MaxVersions int   `json:"maxversions"` // Maximum number of versions per item (0 for no limit)
+	MaxAge      int64 `json:"maxage"`      // Maximum age of versions in seconds (0 for no limit)
+}
+
+/*
+Version is a single version of a node or edge.
+*/
+type Version struct {
+	Version   uint64                 `json:"version"`     // Number of the version
+	Timestamp time.Time              `json:"timestamp"`   // Commit timestamp of the version
+	TransID   string                 `json:"transaction"` // ID of the transaction which committed the version
+	Removed   bool                   `json:"removed"`     // Flag if the item was removed in this version
+	Data      map[string]interface{} `json:"data"`        // Data of the item (nil if the item was removed)
+}
+
+/*
+historyInfo holds the range of retained versions of an item.
+*/
+type historyInfo struct {
+	First uint64 // First retained version
+	Last  uint64 // Last version
+}
+
+/*
+historyEntry is a single stored version of an item.
+*/
+type historyEntry struct {
+	Timestamp int64                  // Commit timestamp in nanoseconds since the epoch
+	TransID   string                 // ID of the committing transaction
+	Removed   bool                   // Flag if the item was removed
+	Data      map[string]interface{} // Data of the item
+}
+
+func init() {
+
+	// Make sure we can use the relevant types in a gob operation
+
+	gob.Register(&historyInfo{})
+	gob.Register(&historyEntry{})
+
+	// Make sure we can use the relevant types with the binary codec
+
+	storage.RegisterCodecType(&historyInfo{})
+	storage.RegisterCodecType(&historyEntry{})
+}
+
+/*
+SetHistoryRetention sets the history retention of a node or edge kind. Only
+commits after this call store versions.
+*/
+func (gm *Manager) SetHistoryRetention(kind string, retention *HistoryRetention) error {
+
+	if retention.MaxVersions < 0 || retention.MaxAge < 0 {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprintf(
+			"History retention of kind %v cannot have negative limits", kind)}
+	}
+
+	val, err := json.Marshal(retention)
+	if err != nil {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: err.Error()}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.gs.MainDB()[MainDBHistoryRetention+kind] = string(val)
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+RemoveHistoryRetention removes the history retention of a kind. Already
+stored versions can still be queried. Returns if a retention was removed.
+*/
+func (gm *Manager) RemoveHistoryRetention(kind string) (bool, error) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if _, ok := gm.gs.MainDB()[MainDBHistoryRetention+kind]; !ok {
+		return false, nil
+	}
+
+	delete(gm.gs.MainDB(), MainDBHistoryRetention+kind)
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return false, &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return true, nil
+}
+
+/*
+HistoryRetention returns the history retention of a kind. Returns nil if the
+kind has no history retention.
+*/
+func (gm *Manager) HistoryRetention(kind string) *HistoryRetention {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return gm.readHistoryRetention(kind)
+}
+
+/*
+FetchNodeVersions fetches all retained versions of a node in a partition. The
+versions are ordered from oldest to newest.
+*/
+func (gm *Manager) FetchNodeVersions(part string, key string, kind string) ([]*Version, error) {
+
+	hht, err := gm.getNodeHistoryHTree(part, kind, false)
+	if err != nil || hht == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return readVersions(hht, key)
+}
+
+/*
+FetchNodeAsOf fetches a node as it was at a given time. Returns nil if the
+node did not exist at the given time or if no version of the node was retained.
+*/
+func (gm *Manager) FetchNodeAsOf(part string, key string, kind string, asof time.Time) (data.Node, error) {
+
+	versions, err := gm.FetchNodeVersions(part, key, kind)
+
+	if v := versionAsOf(versions, asof); v != nil {
+		return data.NewGraphNodeFromMap(v.Data), err
+	}
+
+	return nil, err
+}
+
+/*
+FetchEdgeVersions fetches all retained versions of an edge in a partition. The
+versions are ordered from oldest to newest.
+*/
+func (gm *Manager) FetchEdgeVersions(part string, key string, kind string) ([]*Version, error) {
+
+	hht, err := gm.getEdgeHistoryHTree(part, kind, false)
+	if err != nil || hht == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return readVersions(hht, key)
+}
+
+/*
+FetchEdgeAsOf fetches an edge as it was at a given time. Returns nil if the
+edge did not exist at the given time or if no version of the edge was retained.
+*/
+func (gm *Manager) FetchEdgeAsOf(part string, key string, kind string, asof time.Time) (data.Edge, error) {
+
+	versions, err := gm.FetchEdgeVersions(part, key, kind)
+
+	if v := versionAsOf(versions, asof); v != nil {
+		return data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(v.Data)), err
+	}
+
+	return nil, err
+}
+
+/*
+readHistoryRetention reads the history retention of a kind from the MainDB.
+*/
+func (gm *Manager) readHistoryRetention(kind string) *HistoryRetention {
+	var retention HistoryRetention
+
+	val, ok := gm.gs.MainDB()[MainDBHistoryRetention+kind]
+	if !ok {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &retention); err != nil {
+		return nil
+	}
+
+	return &retention
+}
+
+/*
+recordNodeVersion stores the current state of a node as a new version if the
+kind of the node has a history retention. A node which is no longer stored is
+recorded as removed. It is assumed that the caller holds the writer lock.
+*/
+func (gm *Manager) recordNodeVersion(part string, key string, kind string, transID string,
+	timestamp time.Time, attrTree *hash.HTree, valTree *hash.HTree) error {
+
+	retention := gm.readHistoryRetention(kind)
+	if retention == nil {
+		return nil
+	}
+
+	node, err := gm.readNode(key, kind, nil, attrTree, valTree)
+	if err != nil {
+		return err
+	}
+
+	hht, err := gm.getNodeHistoryHTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	return writeVersion(hht, retention, key, transID, timestamp, node)
+}
+
+/*
+recordEdgeVersion stores the current state of an edge as a new version if the
+kind of the edge has a history retention. An edge which is no longer stored is
+recorded as removed. It is assumed that the caller holds the writer lock.
+*/
+func (gm *Manager) recordEdgeVersion(part string, key string, kind string, transID string,
+	timestamp time.Time, edgeTree *hash.HTree) error {
+
+	retention := gm.readHistoryRetention(kind)
+	if retention == nil {
+		return nil
+	}
+
+	node, err := gm.readNode(key, kind, nil, edgeTree, edgeTree)
+	if err != nil {
+		return err
+	}
+
+	hht, err := gm.getEdgeHistoryHTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	return writeVersion(hht, retention, key, transID, timestamp, node)
+}
+
+/*
+writeVersion writes a new version of an item to a history tree and removes
+all versions which exceed the limits of a given retention. A nil item is
+recorded as removed.
+*/
+func writeVersion(hht *hash.HTree, retention *HistoryRetention, key string, transID string,
+	timestamp time.Time, item data.Node) error {
+
+	info, err := readHistoryInfo(hht, key)
+	if err != nil {
+		return err
+	} else if info == nil {
+		info = &historyInfo{1, 0}
+	}
+
+	entry := &historyEntry{timestamp.UnixNano(), transID, item == nil, nil}
+	if item != nil {
+		entry.Data = item.Data()
+	}
+
+	info.Last++
+
+	if _, err := hht.Put(versionKey(key, info.Last), entry); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	// Remove versions which exceed the retention - a version which is
+	// older than the maximum age is still needed while the following
+	// version is younger
+
+	cutoff := timestamp.Add(-time.Duration(retention.MaxAge) * time.Second).UnixNano()
+
+	for info.First < info.Last {
+
+		expired := retention.MaxVersions > 0 && info.Last-info.First+1 > uint64(retention.MaxVersions)
+
+		if !expired && retention.MaxAge > 0 {
+			next, err := hht.Get(versionKey(key, info.First+1))
+			if err != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+			}
+
+			expired = next != nil && next.(*historyEntry).Timestamp <= cutoff
+		}
+
+		if !expired {
+			break
+		}
+
+		if _, err := hht.Remove(versionKey(key, info.First)); err != nil {
+			return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		}
+
+		info.First++
+	}
+
+	if _, err := hht.Put([]byte(PrefixHSInfo+key), info); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+readVersions reads all retained versions of an item from a history tree.
+*/
+func readVersions(hht *hash.HTree, key string) ([]*Version, error) {
+	var ret []*Version
+
+	info, err := readHistoryInfo(hht, key)
+	if err != nil || info == nil {
+		return nil, err
+	}
+
+	for v := info.First; v <= info.Last; v++ {
+
+		obj, err := hht.Get(versionKey(key, v))
+		if err != nil {
+			return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		} else if obj == nil {
+			continue
+		}
+
+		entry := obj.(*historyEntry)
+
+		// Copy the data so the stored version cannot be modified
+
+		var itemData map[string]interface{}
+
+		if entry.Data != nil {
+			itemData = make(map[string]interface{}, len(entry.Data))
+			for k, v := range entry.Data {
+				itemData[k] = v
+			}
+		}
+
+		ret = append(ret, &Version{v, time.Unix(0, entry.Timestamp).UTC(),
+			entry.TransID, entry.Removed, itemData})
+	}
+
+	return ret, nil
+}
+
+/*
+readHistoryInfo reads the range of retained versions of an item. Returns nil
+if the item has no versions.
+*/
+func readHistoryInfo(hht *hash.HTree, key string) (*historyInfo, error) {
+
+	obj, err := hht.Get([]byte(PrefixHSInfo + key))
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	} else if obj == nil {
+		return nil, nil
+	}
+
+	info := *obj.(*historyInfo)
+
+	return &info, nil
+}
+
+/*
+versionKey returns the key of a single version of an item in a history tree.
+*/
+func versionKey(key string, version uint64) []byte {
+	return []byte(fmt.Sprintf("%v%v\x00%v", PrefixHSVersion, key, version))
+}
+
+/*
+versionAsOf returns the version which was current at a given time. Returns
+nil if there was no such version or if the item was removed at the given time.
+*/
+func versionAsOf(versions []*Version, asof time.Time) *Version {
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if v := versions[i]; !v.Timestamp.After(asof) {
+			if v.Removed {
+				return nil
+			}
+			return v
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,448 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func newHistoryTestNode(key string, attrs map[string]interface{}) data.Node {
+	node := data.NewGraphNode()
+	node.SetAttr("key", key)
+	node.SetAttr("kind", "Person")
+	for k, v := range attrs {
+		node.SetAttr(k, v)
+	}
+	return node
+}
+
+func newHistoryTestEdge(key string, end1 string, end2 string, since int) data.Edge {
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", key)
+	edge.SetAttr("kind", "Knows")
+	edge.SetAttr("since", since)
+
+	edge.SetAttr(data.EdgeEnd1Key, end1)
+	edge.SetAttr(data.EdgeEnd1Kind, "Person")
+	edge.SetAttr(data.EdgeEnd1Role, "friend")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+
+	edge.SetAttr(data.EdgeEnd2Key, end2)
+	edge.SetAttr(data.EdgeEnd2Kind, "Person")
+	edge.SetAttr(data.EdgeEnd2Role, "friend")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	return edge
+}
+
+func versionsString(versions []*Version) string {
+	var ret []string
+
+	for _, v := range versions {
+		if v.TransID == "" || v.Timestamp.IsZero() {
+			return "Version without transaction ID or timestamp"
+		}
+		ret = append(ret, fmt.Sprintf("%v:%v:%v", v.Version, v.Removed, v.Data["name"]))
+	}
+
+	return fmt.Sprint(ret)
+}
+
+func TestHistory(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	// Nodes without a history retention have no versions
+
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne"}))
+
+	if res, err := gm.FetchNodeVersions("main", "1", "Person"); err != nil || res != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := gm.HistoryRetention("Person"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.SetHistoryRetention("Person", &HistoryRetention{MaxVersions: -1}); err == nil ||
+		err.Error() != "GraphError: Invalid data (History retention of kind Person cannot have negative limits)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetHistoryRetention("Person", &HistoryRetention{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm.HistoryRetention("Person"); res == nil || res.MaxVersions != 0 || res.MaxAge != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Each write stores a version
+
+	if err := gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne", "age": 30})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anna"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	trans := NewGraphTrans(gm)
+	trans.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Annie"}))
+	trans.StoreNode("main", newHistoryTestNode("2", map[string]interface{}{"name": "Hans"}))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "1", "Person"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	versions, err := gm.FetchNodeVersions("main", "1", "Person")
+
+	if res := versionsString(versions); err != nil ||
+		res != "[1:false:Anne 2:false:Anna 3:false:Annie 4:true:<nil>]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Updates store the full node
+
+	if res := versions[1].Data["age"]; res != 30 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// All nodes of a transaction share the transaction ID and timestamp
+
+	versions2, _ := gm.FetchNodeVersions("main", "2", "Person")
+
+	if versions2[0].TransID != versions[2].TransID || versions2[0].Timestamp != versions[2].Timestamp ||
+		versions[1].TransID == versions[2].TransID {
+		t.Error("Unexpected result:", versions2[0], versions[2])
+		return
+	}
+
+	// Fetch the node as of the time of each version
+
+	for i, name := range []interface{}{"Anne", "Anna", "Annie"} {
+		node, err := gm.FetchNodeAsOf("main", "1", "Person", versions[i].Timestamp)
+
+		if err != nil || node == nil || node.Attr("name") != name {
+			t.Error("Unexpected result:", node, err)
+			return
+		}
+	}
+
+	if node, err := gm.FetchNodeAsOf("main", "1", "Person", versions[3].Timestamp); err != nil || node != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	if node, err := gm.FetchNodeAsOf("main", "1", "Person",
+		versions[0].Timestamp.Add(-time.Nanosecond)); err != nil || node != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// Returned versions cannot modify the history
+
+	versions[0].Data["name"] = "foo"
+
+	if versions, _ := gm.FetchNodeVersions("main", "1", "Person"); versions[0].Data["name"] != "Anne" {
+		t.Error("Unexpected result:", versions[0].Data)
+		return
+	}
+
+	// Unknown nodes and kinds
+
+	if res, err := gm.FetchNodeVersions("main", "3", "Person"); err != nil || res != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := gm.FetchNodeAsOf("main", "1", "Car", time.Now()); err != nil || res != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := gm.FetchNodeVersions("my part", "1", "Person"); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	// Removing the retention keeps the versions but stops recording
+
+	if ok, err := gm.RemoveHistoryRetention("Person"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := gm.RemoveHistoryRetention("Person"); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	gm.StoreNode("main", newHistoryTestNode("2", map[string]interface{}{"name": "Hansi"}))
+
+	if versions, _ := gm.FetchNodeVersions("main", "2", "Person"); versionsString(versions) != "[1:false:Hans]" {
+		t.Error("Unexpected result:", versionsString(versions))
+		return
+	}
+
+	if res, _ := gm.TreeStats(0); len(res) != 4 || res[3].Tree != TreeStatsHistory {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Test error cases
+
+	mgs.MainDB()[MainDBHistoryRetention+"Person"] = "{"
+
+	if res := gm.HistoryRetention("Person"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	graphstorage.MgsRetFlushMain = errors.New("testerror")
+
+	err1 := gm.SetHistoryRetention("Person", &HistoryRetention{})
+	_, err2 := gm.RemoveHistoryRetention("Person")
+
+	graphstorage.MgsRetFlushMain = nil
+
+	if err1 == nil || err1.Error() != "GraphError: Failed to flush changes (testerror)" ||
+		err2 == nil || err2.Error() != "GraphError: Failed to flush changes (testerror)" {
+		t.Error("Unexpected result:", err1, err2)
+		return
+	}
+}
+
+func TestHistoryEdges(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	gm.StoreNode("main", newHistoryTestNode("1", nil))
+	gm.StoreNode("main", newHistoryTestNode("2", nil))
+
+	if err := gm.SetHistoryRetention("Knows", &HistoryRetention{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", newHistoryTestEdge("a", "1", "2", 2001)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	trans := NewGraphTrans(gm)
+	trans.StoreEdge("main", newHistoryTestEdge("a", "1", "2", 2002))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	trans = NewGraphTrans(gm)
+	trans.RemoveEdge("main", "a", "Knows")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.StoreEdge("main", newHistoryTestEdge("a", "1", "2", 2003))
+
+	if _, err := gm.RemoveEdge("main", "a", "Knows"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	versions, err := gm.FetchEdgeVersions("main", "a", "Knows")
+	if err != nil || len(versions) != 5 {
+		t.Error("Unexpected result:", versions, err)
+		return
+	}
+
+	for i, since := range []interface{}{2001, 2002, nil, 2003, nil} {
+		edge, err := gm.FetchEdgeAsOf("main", "a", "Knows", versions[i].Timestamp)
+
+		if err != nil || (since == nil && edge != nil) ||
+			(since != nil && (edge == nil || edge.Attr("since") != since || edge.End1Key() != "1")) {
+			t.Error("Unexpected result:", i, edge, err)
+			return
+		}
+	}
+
+	if res, err := gm.FetchEdgeVersions("main", "a", "Likes"); err != nil || res != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Edges which were removed by a cascading node removal are recorded
+
+	gm.StoreEdge("main", newHistoryTestEdge("b", "1", "2", 2004))
+
+	if _, err := gm.RemoveNode("main", "1", "Person"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if versions, _ := gm.FetchEdgeVersions("main", "b", "Knows"); len(versions) != 2 || !versions[1].Removed {
+		t.Error("Unexpected result:", versions)
+		return
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	gm.SetHistoryRetention("Person", &HistoryRetention{MaxVersions: 2})
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": name}))
+	}
+
+	if versions, _ := gm.FetchNodeVersions("main", "1", "Person"); versionsString(versions) != "[3:false:c 4:false:d]" {
+		t.Error("Unexpected result:", versionsString(versions))
+		return
+	}
+
+	// Versions which are older than the maximum age are removed once a
+	// younger version is current at the cutoff
+
+	hht, err := gm.getNodeHistoryHTree("main", "Person", true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	retention := &HistoryRetention{MaxAge: 60}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if err := writeVersion(hht, retention, "2", fmt.Sprint(i), start.Add(time.Duration(i*45)*time.Second),
+			newHistoryTestNode("2", map[string]interface{}{"name": name})); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	versions, err := readVersions(hht, "2")
+
+	if res := versionsString(versions); err != nil || res != "[2:false:b 3:false:c 4:false:d]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// The version which was current at the cutoff is kept
+
+	if res := versionAsOf(versions, start.Add(135*time.Second-60*time.Second)); res == nil || res.Data["name"] != "b" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The version which was current at the cutoff and the latest version
+	// are always kept
+
+	if err := writeVersion(hht, retention, "2", "4", start.Add(time.Hour), nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if versions, _ := readVersions(hht, "2"); versionsString(versions) != "[4:false:d 5:true:<nil>]" {
+		t.Error("Unexpected result:", versionsString(versions))
+		return
+	}
+}
+
+func TestHistoryTrans(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	// Use disk storage since failed transactions are rolled back
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir18, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm := NewGraphManager(dgs)
+
+	gm.SetHistoryRetention("Person", &HistoryRetention{})
+	gm.SetHistoryRetention("Knows", &HistoryRetention{})
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	gm.StoreNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anne", "born": ts}))
+	gm.StoreNode("main", newHistoryTestNode("2", map[string]interface{}{"name": "Hans"}))
+
+	// A failed transaction does not store versions
+
+	trans := NewGraphTrans(gm)
+	trans.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anna"}))
+	trans.StoreEdge("main", newHistoryTestEdge("a", "1", "3", 2001))
+
+	if err := trans.Commit(); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	versions, err := gm.FetchNodeVersions("main", "1", "Person")
+
+	if res := versionsString(versions); err != nil || res != "[1:false:Anne]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Typed values are kept in the disk storage
+
+	if res := versions[0].Data["born"]; res != ts {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	trans = NewGraphTrans(gm)
+	trans.UpdateNode("main", newHistoryTestNode("1", map[string]interface{}{"name": "Anna"}))
+	trans.StoreEdge("main", newHistoryTestEdge("a", "1", "2", 2001))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	versions, _ = gm.FetchNodeVersions("main", "1", "Person")
+	edgeVersions, _ := gm.FetchEdgeVersions("main", "a", "Knows")
+
+	if res := versionsString(versions); res != "[1:false:Anne 2:false:Anna]" ||
+		len(edgeVersions) != 1 || edgeVersions[0].TransID != versions[1].TransID {
+		t.Error("Unexpected result:", res, edgeVersions)
+		return
+	}
+}
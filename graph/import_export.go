@@ -27,6 +27,9 @@ ExportPartition dumps the contents of a partition to an io.Writer in JSON format
 		nodes : [ { <attr> : <value> }, ... ]
 		edges : [ { <attr> : <value> }, ... ]
 	}
+
+Values are written with data.EncodeJSONValue so their value types can be
+restored by ImportPartition.
 */
 func ExportPartition(out io.Writer, part string, gm *Manager) error {
 
@@ -34,14 +37,14 @@ func ExportPartition(out io.Writer, part string, gm *Manager) error {
 
 	edgeKeys := make(map[string]string)
 
-	writeData := func(data map[string]interface{}) {
+	writeData := func(itemData map[string]interface{}) {
 
 		nk := 0
-		for k, v := range data {
+		for k, v := range itemData {
 
 			// JSON encode value - ignore values which cannot be JSON encoded
 
-			jv, err := json.Marshal(v)
+			jv, err := json.Marshal(data.EncodeJSONValue(v))
 
 			// Encoding errors result in a null value
 
@@ -52,7 +55,7 @@ func ExportPartition(out io.Writer, part string, gm *Manager) error {
 			// Write out the node attributes
 
 			fmt.Fprintf(out, "      \"%s\" : %s", k, jv)
-			if nk < len(data)-1 {
+			if nk < len(itemData)-1 {
 				fmt.Fprint(out, ",")
 			}
 			fmt.Fprint(out, "\n")
@@ -213,16 +216,11 @@ The following format is expected:
 */
 func ImportPartition(in io.Reader, part string, gm *Manager) error {
 
-	dec := json.NewDecoder(in)
-	gdata := make(map[string][]map[string]interface{})
-
-	if err := dec.Decode(&gdata); err != nil {
-		return fmt.Errorf("Could not decode file content as object with list of nodes and edges: %s", err.Error())
+	nDataList, eDataList, err := decodePartition(in)
+	if err != nil {
+		return err
 	}
 
-	nDataList := gdata["nodes"]
-	eDataList := gdata["edges"]
-
 	// Create a transaction
 
 	trans := NewGraphTrans(gm)
@@ -237,6 +235,67 @@ func ImportPartition(in io.Reader, part string, gm *Manager) error {
 		}
 	}
 
+	return importEdges(trans, part, eDataList)
+}
+
+/*
+BulkImportPartition imports the JSON contents of an io.Reader into a given
+partition using the bulk loader for nodes (see Manager.BulkLoadNodes). The
+partition must not contain any nodes of the imported kinds. Edges are imported
+in a normal transaction once all nodes have been loaded. The same format as
+for ImportPartition is expected.
+*/
+func BulkImportPartition(in io.Reader, part string, gm *Manager) error {
+
+	nDataList, eDataList, err := decodePartition(in)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]data.Node, 0, len(nDataList))
+
+	for _, ndata := range nDataList {
+		nodes = append(nodes, data.NewGraphNodeFromMap(ndata))
+	}
+
+	if err := gm.BulkLoadNodes(part, nodes); err != nil {
+		return err
+	}
+
+	return importEdges(NewGraphTrans(gm), part, eDataList)
+}
+
+/*
+decodePartition decodes the JSON contents of an io.Reader into a list of nodes
+and a list of edges. Values are decoded with data.DecodeJSONValue.
+*/
+func decodePartition(in io.Reader) ([]map[string]interface{}, []map[string]interface{}, error) {
+
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+
+	gdata := make(map[string][]map[string]interface{})
+
+	if err := dec.Decode(&gdata); err != nil {
+		return nil, nil, fmt.Errorf("Could not decode file content as object with list of nodes and edges: %s", err.Error())
+	}
+
+	// Restore the value types of all attributes
+
+	for _, list := range [][]map[string]interface{}{gdata["nodes"], gdata["edges"]} {
+		for _, d := range list {
+			data.DecodeJSONData(d)
+		}
+	}
+
+	return gdata["nodes"], gdata["edges"], nil
+}
+
+/*
+importEdges stores a list of edges in a given transaction and commits it.
+*/
+func importEdges(trans Trans, part string, eDataList []map[string]interface{}) error {
+
 	// Store edges in transaction
 
 	for _, edata := range eDataList {
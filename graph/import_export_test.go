@@ -12,8 +12,10 @@ package graph
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/graphstorage"
@@ -398,3 +400,94 @@ func TestImportExportError(t *testing.T) {
 	}
 
 }
+
+func TestImportExportTypedValues(t *testing.T) {
+
+	// Use disk storage so the values go through the gob encoding
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir17, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm := NewGraphManager(dgs)
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "Event")
+	node.SetAttr("count", int64(9007199254740993))
+	node.SetAttr("ratio", 2.0)
+	node.SetAttr("start", ts)
+	node.SetAttr("blob", []byte("abc"))
+	node.SetAttr("tags", []interface{}{int64(1), "a"})
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res bytes.Buffer
+
+	if err := ExportPartition(&res, "main", gm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := res.String(); !strings.Contains(res, `"count" : 9007199254740993`) ||
+		!strings.Contains(res, `"ratio" : 2.0`) ||
+		!strings.Contains(res, `"start" : {"$timestamp":"2020-01-02T03:04:05.000000006Z"}`) ||
+		!strings.Contains(res, `"blob" : {"$bytes":"YWJj"}`) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm2 := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if err := ImportPartition(&res, "main", gm2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, gm := range []*Manager{gm, gm2} {
+		n, err := gm.FetchNode("main", "1", "Event")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if res := n.Attr("count"); res != int64(9007199254740993) {
+			t.Error("Unexpected result:", res)
+			return
+		}
+
+		if res := n.Attr("ratio"); res != 2.0 {
+			t.Error("Unexpected result:", res)
+			return
+		}
+
+		if res := n.Attr("start"); res != ts {
+			t.Error("Unexpected result:", res)
+			return
+		}
+
+		if res, ok := n.Attr("blob").([]byte); !ok || string(res) != "abc" {
+			t.Error("Unexpected result:", res)
+			return
+		}
+
+		if res, ok := n.Attr("tags").([]interface{}); !ok || len(res) != 2 || res[0] != int64(1) {
+			t.Error("Unexpected result:", res)
+			return
+		}
+
+		if res := fmt.Sprint(gm.NodeAttrTypes("Event")); res != "map[blob:bytes count:int64 "+
+			"key:string kind:string ratio:float64 start:timestamp tags:list]" {
+			t.Error("Unexpected result:", res)
+			return
+		}
+	}
+}
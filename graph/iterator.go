@@ -17,11 +17,26 @@ import (
 
 /*
 NodeKeyIterator can be used to iterate node keys of a certain node kind.
+The position of the iterator can be saved with a cursor token.
 */
 type NodeKeyIterator struct {
-	gm        *Manager            // GraphManager which created the iterator
-	it        *hash.HTreeIterator // Internal HTree iterator
-	LastError error               // Last encountered error
+	gm        *Manager                 // GraphManager which created the iterator
+	it        *hash.HTreeRangeIterator // Internal HTree iterator
+	LastError error                    // Last encountered error
+}
+
+/*
+newNodeKeyIterator creates a new NodeKeyIterator from a given HTree iterator.
+*/
+func newNodeKeyIterator(gm *Manager, it *hash.HTreeRangeIterator) (*NodeKeyIterator, error) {
+	if it.LastError != nil {
+		return nil, &util.GraphError{
+			Type:   util.ErrReading,
+			Detail: it.LastError.Error(),
+		}
+	}
+
+	return &NodeKeyIterator{gm, it, nil}, nil
 }
 
 /*
@@ -36,7 +51,7 @@ func (it *NodeKeyIterator) Next() string {
 
 	k, _ := it.it.Next()
 
-	if it.it.LastError != nil {
+	if it.it.LastError != nil && it.it.LastError != hash.ErrNoMoreItems {
 		it.LastError = &util.GraphError{Type: util.ErrReading, Detail: it.it.LastError.Error()}
 		return ""
 	} else if len(k) == 0 {
@@ -59,3 +74,18 @@ Error returns the last encountered error.
 func (it *NodeKeyIterator) Error() error {
 	return it.LastError
 }
+
+/*
+Cursor returns an opaque token which describes the position of the iterator.
+The token can be used with the NodeKeyIteratorFromCursor function of the
+GraphManager to continue the iteration after the last returned key.
+*/
+func (it *NodeKeyIterator) Cursor() string {
+
+	// Take reader lock
+
+	it.gm.mutex.RLock()
+	defer it.gm.mutex.RUnlock()
+
+	return it.it.Cursor()
+}
@@ -11,6 +11,9 @@
 package graph
 
 import (
+	"fmt"
+	"sort"
+	"sync"
 	"testing"
 
 	"github.com/krotik/eliasdb/graph/data"
@@ -143,3 +146,119 @@ func TestNodeKeyIterator(t *testing.T) {
 		return
 	}
 }
+
+func TestNodeKeyIteratorParallelAndResume(t *testing.T) {
+
+	mgs := graphstorage.NewMemoryGraphStorage("iterator test")
+
+	gm := newGraphManagerNoRules(mgs)
+
+	var expected []string
+
+	for i := 0; i < 500; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "mykind")
+
+		gm.StoreNode("main", node)
+
+		expected = append(expected, fmt.Sprint(i))
+	}
+
+	sort.Strings(expected)
+
+	// Iterate the keys in parallel
+
+	its, err := gm.NodeKeyIterators("main", "mykind", 4)
+	if err != nil || len(its) != 4 {
+		t.Error("Unexpected result:", its, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var keys []string
+
+	for _, it := range its {
+		wg.Add(1)
+
+		go func(it *NodeKeyIterator) {
+			defer wg.Done()
+
+			for it.HasNext() {
+				key := it.Next()
+
+				mutex.Lock()
+				keys = append(keys, key)
+				mutex.Unlock()
+			}
+		}(it)
+	}
+
+	wg.Wait()
+
+	sort.Strings(keys)
+
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	// Resume an iteration from a cursor
+
+	ni, _ := gm.NodeKeyIterator("main", "mykind")
+
+	keys = nil
+
+	for i := 0; i < 200; i++ {
+		keys = append(keys, ni.Next())
+	}
+
+	cursor := ni.Cursor()
+
+	ni, err = gm.NodeKeyIteratorFromCursor("main", "mykind", cursor)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for ni.HasNext() {
+		keys = append(keys, ni.Next())
+	}
+
+	sort.Strings(keys)
+
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	// Test error cases
+
+	if ni, err := gm.NodeKeyIteratorFromCursor("main", "mykind", "abc"); ni != nil || err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid cursor token)" {
+		t.Error("Unexpected result:", ni, err)
+		return
+	}
+
+	if ni, err := gm.NodeKeyIteratorFromCursor("main", "unknown", cursor); ni != nil || err != nil {
+		t.Error("Unexpected result:", ni, err)
+		return
+	}
+
+	if its, err := gm.NodeKeyIterators("main", "unknown", 4); its != nil || err != nil {
+		t.Error("Unexpected result:", its, err)
+		return
+	}
+
+	msm := mgs.StorageManager("main"+"mykind"+StorageSuffixNodes, false)
+
+	msm.(*storage.MemoryStorageManager).AccessMap[1] = storage.AccessCacheAndFetchError
+
+	if its, err := gm.NodeKeyIterators("main", "mykind", 4); its != nil || err == nil {
+		t.Error("Unexpected result:", its, err)
+		return
+	}
+
+	delete(msm.(*storage.MemoryStorageManager).AccessMap, 1)
+}
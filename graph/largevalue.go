@@ -0,0 +1,273 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+LargeValueThreshold is the size in bytes from which string and byte slice
+attribute values are written as large objects. Large objects are stored in
+chunks next to the HTree which holds the attribute values and can be streamed
+with NodeAttrReader.
+*/
+var LargeValueThreshold = 64 * 1024
+
+/*
+largeValue is stored in a HTree instead of an attribute value which was
+written as a large object.
+*/
+type largeValue struct {
+	Loc      uint64 // Storage location of the large object
+	IsString bool   // Flag if the value is a string (otherwise it is a byte slice)
+}
+
+func init() {
+
+	// Make sure we can use the relevant types in a gob operation
+
+	gob.Register(&largeValue{})
+
+	// Make sure we can use the relevant types with the binary codec
+
+	storage.RegisterCodecType(&largeValue{})
+}
+
+/*
+writeAttrValue returns the value which should be stored in a HTree for a given
+attribute value. Large values are written as large objects to the storage
+manager of the HTree.
+*/
+func (gm *Manager) writeAttrValue(tree *hash.HTree, val interface{}) (interface{}, error) {
+	var lv *largeValue
+	var lw *storage.LargeObjectWriter
+	var err error
+
+	switch v := val.(type) {
+
+	case string:
+		if len(v) < LargeValueThreshold {
+			return val, nil
+		}
+
+		if lw, err = storage.CreateLargeObject(tree.StorageManager()); err == nil {
+			lv = &largeValue{lw.Location(), true}
+			_, err = io.WriteString(lw, v)
+		}
+
+	case []byte:
+		if len(v) < LargeValueThreshold {
+			return val, nil
+		}
+
+		if lw, err = storage.CreateLargeObject(tree.StorageManager()); err == nil {
+			lv = &largeValue{lw.Location(), false}
+			_, err = lw.Write(v)
+		}
+
+	default:
+		return val, nil
+	}
+
+	if err == nil {
+		err = lw.Close()
+	}
+
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return lv, nil
+}
+
+/*
+isLargeAttrValue checks if a given attribute value is written as a large object.
+*/
+func isLargeAttrValue(val interface{}) bool {
+
+	switch v := val.(type) {
+	case string:
+		return len(v) >= LargeValueThreshold
+	case []byte:
+		return len(v) >= LargeValueThreshold
+	}
+
+	return false
+}
+
+/*
+readAttrValue returns the attribute value for a value which was stored in a
+HTree. Large values are read completely from their large object.
+*/
+func (gm *Manager) readAttrValue(tree *hash.HTree, val interface{}) (interface{}, error) {
+
+	lv, ok := val.(*largeValue)
+	if !ok {
+		return val, nil
+	}
+
+	lr, err := storage.OpenLargeObject(tree.StorageManager(), lv.Loc)
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+	defer lr.Close()
+
+	res, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	if lv.IsString {
+		return string(res), nil
+	}
+
+	return res, nil
+}
+
+/*
+releaseAttrValue returns the attribute value for a value which was removed from
+a HTree. The large object of a large value is freed.
+*/
+func (gm *Manager) releaseAttrValue(tree *hash.HTree, val interface{}) (interface{}, error) {
+
+	lv, ok := val.(*largeValue)
+	if !ok {
+		return val, nil
+	}
+
+	res, err := gm.readAttrValue(tree, val)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.FreeLargeObject(tree.StorageManager(), lv.Loc); err != nil {
+		return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return res, nil
+}
+
+/*
+largeValueLocations returns the storage locations of all large objects which
+are referenced from a given HTree.
+*/
+func largeValueLocations(tree *hash.HTree) ([]uint64, error) {
+	var ret []uint64
+
+	it := hash.NewHTreeIterator(tree)
+
+	for it.HasNext() {
+		_, val := it.Next()
+
+		if lv, ok := val.(*largeValue); ok {
+			locs, err := storage.LargeObjectLocations(tree.StorageManager(), lv.Loc)
+			if err != nil {
+				return ret, err
+			}
+			ret = append(ret, locs...)
+		}
+	}
+
+	return ret, it.LastError
+}
+
+/*
+NodeAttrReader returns a reader for a single attribute value of a node. Values
+which were written as large objects are streamed from the datastore. Other
+values are returned in their string representation. Returns nil if the node or
+the attribute does not exist. The reader always returns the value which the
+attribute had when the reader was created - even if the node is updated or
+removed in the meantime. The reader must be closed.
+*/
+func (gm *Manager) NodeAttrReader(part string, key string, kind string,
+	attr string) (io.ReadCloser, error) {
+
+	// Get the HTrees which stores the node
+
+	_, valht, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || valht == nil {
+		return nil, err
+	}
+
+	encattr := gm.nm.Encode32(attr, false)
+	if encattr == "" {
+		return nil, nil
+	}
+
+	// Take reader lock
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	val, err := valht.Get([]byte(PrefixNSAttr + key + encattr))
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	switch v := val.(type) {
+
+	case nil:
+		return nil, nil
+
+	case *largeValue:
+		lr, err := storage.OpenLargeObject(valht.StorageManager(), v.Loc)
+		if err != nil {
+			return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		}
+
+		return &largeValueReader{lr, valht.StorageManager(), gm.mutex}, nil
+
+	case []byte:
+		return ioutil.NopCloser(bytes.NewReader(v)), nil
+	}
+
+	return ioutil.NopCloser(bytes.NewReader([]byte(fmt.Sprint(val)))), nil
+}
+
+/*
+largeValueReader is a reader for a large value. The large object of the value
+is pinned until the reader is closed.
+*/
+type largeValueReader struct {
+	*storage.LargeObjectReader
+	sm    storage.Manager // StorageManager which stores the large object
+	mutex *sync.RWMutex   // Mutex of the graph manager
+}
+
+/*
+Close closes the reader. Chunks of the large object which were released while
+the reader was open are freed.
+*/
+func (lr *largeValueReader) Close() error {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+
+	err := lr.LargeObjectReader.Close()
+	if err == nil {
+		err = lr.sm.Flush()
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestLargeValues(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	oldThreshold := LargeValueThreshold
+	oldChunkSize := storage.LargeObjectChunkSize
+	LargeValueThreshold = 10
+	storage.LargeObjectChunkSize = 4
+	defer func() {
+		LargeValueThreshold = oldThreshold
+		storage.LargeObjectChunkSize = oldChunkSize
+	}()
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir14, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	text := strings.Repeat("large text ", 10)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mykind")
+	node.SetAttr("name", "small")
+	node.SetAttr("text", text)
+	node.SetAttr("data", []byte("some large binary data"))
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkNoProblems := func() bool {
+		res, err := gm.Check(false)
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+
+		for _, r := range res {
+			if len(r.Problems) > 0 {
+				t.Error("Unexpected problems:", r.Name, r.Problems)
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if !checkNoProblems() {
+		return
+	}
+
+	// Large values are read completely when fetching a node
+
+	fnode, err := gm.FetchNode("main", "123", "mykind")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fnode.Attr("text") != text || string(fnode.Attr("data").([]byte)) != "some large binary data" ||
+		fnode.Attr("name") != "small" {
+		t.Error("Unexpected result:", fnode)
+		return
+	}
+
+	// The full text index contains large values
+
+	iq, _ := gm.NodeIndexQuery("main", "mykind")
+	if res, _ := iq.LookupWord("text", "large"); len(res) != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Large values can be streamed
+
+	r, err := gm.NodeAttrReader("main", "123", "mykind", "text")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := ioutil.ReadAll(r); string(res) != text || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	if err := r.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	r, _ = gm.NodeAttrReader("main", "123", "mykind", "name")
+
+	if res, err := ioutil.ReadAll(r); string(res) != "small" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	r.Close()
+
+	r, _ = gm.NodeAttrReader("main", "123", "mykind", "data")
+
+	if res, err := ioutil.ReadAll(r); string(res) != "some large binary data" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	r.Close()
+
+	if r, err := gm.NodeAttrReader("main", "123", "mykind", "foo"); r != nil || err != nil {
+		t.Error("Unexpected result:", r, err)
+		return
+	}
+
+	if r, err := gm.NodeAttrReader("main", "456", "mykind", "text"); r != nil || err != nil {
+		t.Error("Unexpected result:", r, err)
+		return
+	}
+
+	if r, err := gm.NodeAttrReader("main", "123", "otherkind", "text"); r != nil || err != nil {
+		t.Error("Unexpected result:", r, err)
+		return
+	}
+
+	// Updates return the old large values and free their storage - a reader
+	// which is open during the update still returns the old value
+
+	r, _ = gm.NodeAttrReader("main", "123", "mykind", "text")
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mykind")
+	node.SetAttr("text", "now small")
+
+	var oldNode data.Node
+
+	gm.SetGraphRule(&largeValueTestRule{&oldNode})
+
+	if err := gm.UpdateNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if oldNode == nil || oldNode.Attr("text") != text {
+		t.Error("Unexpected old node:", oldNode)
+		return
+	}
+
+	if res, err := ioutil.ReadAll(r); string(res) != text || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	if err := r.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !checkNoProblems() {
+		return
+	}
+
+	// Storing the node removes the data attribute
+
+	node.SetAttr("text", text+text)
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fnode, _ := gm.FetchNode("main", "123", "mykind"); fnode.Attr("text") != text+text ||
+		fnode.Attr("data") != nil {
+		t.Error("Unexpected result:", fnode)
+		return
+	}
+
+	if !checkNoProblems() {
+		return
+	}
+
+	// Removing the node frees all large values
+
+	if rnode, err := gm.RemoveNode("main", "123", "mykind"); err != nil || rnode.Attr("text") != text+text {
+		t.Error("Unexpected result:", rnode, err)
+		return
+	}
+
+	if !checkNoProblems() {
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+type largeValueTestRule struct {
+	oldNode *data.Node
+}
+
+func (r *largeValueTestRule) Name() string {
+	return "largevaluetestrule"
+}
+
+func (r *largeValueTestRule) Handles() []int {
+	return []int{EventNodeUpdated}
+}
+
+func (r *largeValueTestRule) Handle(gm *Manager, trans Trans, event int, ed ...interface{}) error {
+	*r.oldNode = ed[2].(data.Node)
+	return nil
+}
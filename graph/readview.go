@@ -0,0 +1,64 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+ReadView is a read-only graph manager which reads a consistent version of the
+graph. Transactions which are committed while the view is open are not visible
+to the view and the view does not block them. A view must be released once it
+is no longer needed.
+*/
+type ReadView struct {
+	*Manager
+}
+
+/*
+ReadView opens a read-only view of the current version of the graph. Read
+operations on the view (e.g. EQL queries) do not block write operations on
+this graph manager and vice versa. Returns an error if the graph storage does
+not support read views (e.g. memory only storage).
+*/
+func (gm *Manager) ReadView() (*ReadView, error) {
+
+	rvs, ok := gm.gs.(graphstorage.ReadViewStorage)
+	if !ok {
+		return nil, &util.GraphError{Type: util.ErrAccessComponent,
+			Detail: "Graph storage does not support read views"}
+	}
+
+	// Open the view between write operations
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	gm.storageMutex.Lock()
+	defer gm.storageMutex.Unlock()
+
+	gs, err := rvs.ReadView()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReadView{createGraphManager(gs)}, nil
+}
+
+/*
+Release releases the view. Old versions of graph data which were kept for the
+view are discarded.
+*/
+func (rv *ReadView) Release() error {
+	return rv.gs.Close()
+}
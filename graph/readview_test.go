@@ -0,0 +1,253 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestReadView(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	os.RemoveAll(GraphManagerTestDBDir11)
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir11, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	trans := NewGraphTrans(gm)
+
+	for i := 0; i < 10; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "mykind")
+		node.SetAttr("name", fmt.Sprint("Node", i))
+		trans.StoreNode("main", node)
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	rv, err := gm.ReadView()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Change the graph while the view is open
+
+	trans = NewGraphTrans(gm)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "mykind")
+	node.SetAttr("name", "Node1 changed")
+	trans.StoreNode("main", node)
+
+	trans.RemoveNode("main", "2", "mykind")
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "new")
+	node.SetAttr("kind", "mykind")
+	trans.StoreNode("main", node)
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "new")
+	node.SetAttr("kind", "newkind")
+	trans.StoreNode("main", node)
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The view still sees the old version of the graph
+
+	if n, err := rv.FetchNode("main", "1", "mykind"); err != nil || n.Attr("name") != "Node1" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := rv.FetchNode("main", "2", "mykind"); err != nil || n == nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := rv.FetchNode("main", "new", "mykind"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := rv.FetchNode("main", "new", "newkind"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if res := rv.NodeCount("mykind"); res != 10 {
+		t.Error("Unexpected node count:", res)
+		return
+	}
+
+	it, _ := rv.NodeKeyIterator("main", "mykind")
+
+	count := 0
+	for it.HasNext() {
+		it.Next()
+		count++
+	}
+
+	if count != 10 || it.Error() != nil {
+		t.Error("Unexpected iteration result:", count, it.Error())
+		return
+	}
+
+	// The graph manager sees the new version
+
+	if n, err := gm.FetchNode("main", "1", "mykind"); err != nil || n.Attr("name") != "Node1 changed" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if res := gm.NodeCount("mykind"); res != 10 {
+		t.Error("Unexpected node count:", res)
+		return
+	}
+
+	// Views cannot be changed
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "mykind")
+
+	if err := rv.StoreNode("main", node); err == nil {
+		t.Error("Storing a node in a read view should fail")
+		return
+	}
+
+	if err := rv.Release(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := dgs.(*graphstorage.DiskGraphStorage).OldVersions(); res != 0 {
+		t.Error("Unexpected old versions:", res)
+		return
+	}
+
+	dgs.Close()
+
+	// Memory storage does not support read views
+
+	gm = NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if _, err := gm.ReadView(); err == nil ||
+		err.Error() != "GraphError: Failed to access graph storage component (Graph storage does not support read views)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestReadViewConcurrent(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	os.MkdirAll(GraphManagerTestDBDir11, 0770)
+	os.RemoveAll(GraphManagerTestDBDir11 + "/concurrent")
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir11+"/concurrent", false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	storeNodes := func(name string) error {
+		trans := NewGraphTrans(gm)
+
+		for i := 0; i < 50; i++ {
+			node := data.NewGraphNode()
+			node.SetAttr("key", fmt.Sprint(i))
+			node.SetAttr("kind", "mykind")
+			node.SetAttr("name", name)
+			trans.StoreNode("main", node)
+		}
+
+		return trans.Commit()
+	}
+
+	storeNodes("v0")
+
+	// Readers always see all nodes of a single version
+
+	var wg sync.WaitGroup
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				rv, err := gm.ReadView()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				var name string
+
+				for i := 0; i < 50; i++ {
+					n, err := rv.FetchNode("main", fmt.Sprint(i), "mykind")
+					if err != nil || n == nil {
+						t.Error("Unexpected result:", n, err)
+						return
+					}
+
+					if name == "" {
+						name = n.Attr("name").(string)
+					} else if n.Attr("name") != name {
+						t.Error("Inconsistent view:", name, n.Attr("name"))
+						return
+					}
+				}
+
+				rv.Release()
+			}
+		}()
+	}
+
+	for v := 1; v < 10; v++ {
+		if err := storeNodes(fmt.Sprint("v", v)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	wg.Wait()
+
+	dgs.Close()
+}
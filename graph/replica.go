@@ -0,0 +1,41 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+Refresh reads all changes which another process has written to the graph
+storage. The graph storage must have been opened as a replica (see
+graphstorage.RefreshStorage). All read operations are blocked while the
+graph storage is refreshed. Changes which are written after the refresh
+become visible with the next refresh. Read operations which need data that
+the writing process has written to its data files since the refresh fail
+until the next refresh.
+*/
+func (gm *Manager) Refresh() error {
+
+	rs, ok := gm.gs.(graphstorage.RefreshStorage)
+	if !ok {
+		return &util.GraphError{Type: util.ErrAccessComponent,
+			Detail: "Graph storage does not support refreshing"}
+	}
+
+	// Block all read and write operations
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	return rs.Refresh()
+}
@@ -0,0 +1,126 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestReplica(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir13, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	storeNode := func(key string, name string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "mykind")
+		node.SetAttr("name", name)
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storeNode("123", "Node1")
+
+	rgs, err := graphstorage.NewDiskGraphStorageWithOptions(GraphManagerTestDBDir13, false,
+		storage.DiskStorageManagerOptions{Replica: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rgm := NewGraphManager(rgs)
+
+	if n, err := rgm.FetchNode("main", "123", "mykind"); err != nil || n == nil || n.Attr("name") != "Node1" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Changes are visible after a refresh
+
+	storeNode("123", "Node2")
+
+	for i := 0; i < 20; i++ {
+		storeNode(fmt.Sprint(1000+i), "Node")
+	}
+
+	if err := rgm.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := rgm.FetchNode("main", "123", "mykind"); err != nil || n == nil || n.Attr("name") != "Node2" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if c := rgm.NodeCount("mykind"); c != 21 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+
+	if res, err := rgm.Check(false); err != nil || len(res) != 2 || len(res[0].Problems) != 0 {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	// A replica is readonly
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "456")
+	node.SetAttr("kind", "mykind")
+
+	if err := rgm.StoreNode("main", node); err == nil {
+		t.Error("Storing a node in a replica should fail")
+		return
+	}
+
+	if err := rgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.Refresh(); err == nil || err.Error() !=
+		"GraphError: Failed to access graph storage component (Graph storage is not a replica)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Memory storage cannot be refreshed
+
+	mgm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if err := mgm.Refresh(); err == nil || err.Error() !=
+		"GraphError: Failed to access graph storage component (Graph storage does not support refreshing)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -103,7 +103,7 @@ func (gr *graphRulesManager) graphEvent(trans Trans, event int, data ...interfac
 Clone a given graph manager and insert a new RWMutex.
 */
 func (gr *graphRulesManager) cloneGraphManager() *Manager {
-	return &Manager{gr.gm.gs, gr, gr.gm.nm, gr.gm.mapCache, &sync.RWMutex{}, &sync.Mutex{}}
+	return &Manager{gr.gm.gs, gr, gr.gm.nm, gr.gm.mapCache, &sync.RWMutex{}, &sync.Mutex{}, gr.gm.changes}
 }
 
 /*
@@ -248,7 +248,8 @@ func (r *SystemRuleDeleteNodeEdges) Handle(gm *Manager, trans Trans, event int,
 
 /*
 SystemRuleUpdateNodeStats is a system rule to update info entries such as
-known node or edge kinds in the MainDB.
+known node or edge kinds in the MainDB. The value type of each known attribute
+is recorded as well (see data.ValueType).
 */
 type SystemRuleUpdateNodeStats struct {
 }
@@ -328,11 +329,11 @@ func (r *SystemRuleUpdateNodeStats) Handle(gm *Manager, trans Trans, event int,
 
 	if attrs != nil {
 
-		// Update stored node attributes
+		// Update stored node attributes and their value types
 
-		for attr := range node.Data() {
-			if _, ok := attrs[attr]; !ok {
-				attrs[attr] = ""
+		for attr, val := range node.Data() {
+			if vtype := mergeValueTypes(attrs[attr], data.ValueType(val)); vtype != attrs[attr] {
+				attrs[attr] = vtype
 				storeAttrs = true
 			}
 		}
@@ -346,3 +347,20 @@ func (r *SystemRuleUpdateNodeStats) Handle(gm *Manager, trans Trans, event int,
 
 	return nil
 }
+
+/*
+mergeValueTypes merges a known value type of an attribute with a newly seen
+value type. Integers and floating point numbers merge into floating point
+numbers - all other different types merge into data.ValueTypeAny.
+*/
+func mergeValueTypes(known string, seen string) string {
+
+	if known == "" || known == seen {
+		return seen
+	} else if (known == data.ValueTypeInt || known == data.ValueTypeFloat) &&
+		(seen == data.ValueTypeInt || seen == data.ValueTypeFloat) {
+		return data.ValueTypeFloat
+	}
+
+	return data.ValueTypeAny
+}
@@ -448,7 +448,7 @@ func TestRulesErrors(t *testing.T) {
 	// Check that the test rule was added
 
 	if rules := fmt.Sprint(gm.GraphRules()); rules !=
-		"[system.deletenodeedges system.updatenodestats testrule]" {
+		"[system.deletenodeedges system.updatenodestats system.validateschema testrule]" {
 		t.Error("unexpected graph rule list:", rules)
 		return
 	}
@@ -736,7 +736,7 @@ func TestRulesErrorsPreChange(t *testing.T) {
 	// Check that the test rule was added
 
 	if rules := fmt.Sprint(gm.GraphRules()); rules !=
-		"[system.deletenodeedges system.updatenodestats testrule]" {
+		"[system.deletenodeedges system.updatenodestats system.validateschema testrule]" {
 		t.Error("unexpected graph rule list:", rules)
 		return
 	}
@@ -796,3 +796,51 @@ func TestRulesErrorsPreChange(t *testing.T) {
 		return
 	}
 }
+
+func TestRulesAttrTypes(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	storeNode := func(key string, attrs map[string]interface{}) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "mykind")
+		for k, v := range attrs {
+			node.SetAttr(k, v)
+		}
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+		}
+	}
+
+	storeNode("1", map[string]interface{}{"a": 1, "b": 1, "c": true})
+
+	if res := fmt.Sprint(gm.NodeAttrTypes("mykind")); res != "map[a:int64 b:int64 c:bool key:string kind:string]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Numbers of different types become floats - values of other
+	// different types become any
+
+	storeNode("2", map[string]interface{}{"a": 1.5, "b": "x", "c": false})
+	storeNode("3", map[string]interface{}{"a": 2})
+
+	if res := fmt.Sprint(gm.NodeAttrTypes("mykind")); res != "map[a:float64 b:any c:bool key:string kind:string]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(gm.NodeAttrs("mykind")); res != "[a b c key kind]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Existing attribute maps without value types report any
+
+	gm.getMainDBMap(MainDBNodeAttrs + "mykind")["a"] = ""
+
+	if res := gm.NodeAttrTypes("mykind")["a"]; res != data.ValueTypeAny {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
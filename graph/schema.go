@@ -0,0 +1,406 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+Attribute types which can be used in a schema. Except for SchemaTypeNumber
+(integers and floating point numbers) these are the value types of the data
+package (see data.ValueType).
+*/
+const (
+	SchemaTypeAny       = data.ValueTypeAny
+	SchemaTypeString    = data.ValueTypeString
+	SchemaTypeNumber    = "number"
+	SchemaTypeInt       = data.ValueTypeInt
+	SchemaTypeFloat     = data.ValueTypeFloat
+	SchemaTypeBool      = data.ValueTypeBool
+	SchemaTypeTimestamp = data.ValueTypeTimestamp
+	SchemaTypeBytes     = data.ValueTypeBytes
+	SchemaTypeList      = data.ValueTypeList
+	SchemaTypeMap       = data.ValueTypeMap
+)
+
+/*
+Schema models an optional schema of a node or edge kind. Nodes and edges of
+a kind with a schema are validated by the system rule SystemRuleValidateSchema
+whenever they are stored or updated.
+*/
+type Schema struct {
+	Kind   string                 `json:"kind"`            // Kind which is described by this schema
+	Attrs  map[string]*SchemaAttr `json:"attrs,omitempty"` // Known attributes of the kind
+	Edges  map[string]*SchemaEdge `json:"edges,omitempty"` // Allowed edge specs of a node kind (all specs are allowed if empty)
+	Strict bool                   `json:"strict"`          // Flag if only known attributes are allowed
+}
+
+/*
+SchemaAttr models an attribute of a schema.
+*/
+type SchemaAttr struct {
+	Type     string `json:"type,omitempty"` // Type of the attribute value (any type if empty)
+	Required bool   `json:"required"`       // Flag if the attribute is required
+}
+
+/*
+SchemaEdge models an allowed edge spec of a schema. Edge specs have the form
+<own role>:<edge kind>:<other role>:<other node kind>.
+*/
+type SchemaEdge struct {
+	Max int `json:"max"` // Maximum number of edges with this spec per node (0 for no limit)
+}
+
+/*
+Validate checks if the schema itself is valid.
+*/
+func (s *Schema) Validate() error {
+
+	schemaError := func(detail string) error {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: detail}
+	}
+
+	if !stringutil.IsAlphaNumeric(s.Kind) {
+		return schemaError(fmt.Sprintf(
+			"Schema kind %v is not alphanumeric - can only contain [a-zA-Z0-9_]", s.Kind))
+	}
+
+	for name, attr := range s.Attrs {
+
+		if name == "" || attr == nil {
+			return schemaError(fmt.Sprintf("Schema of kind %v contains an invalid attribute", s.Kind))
+		}
+
+		switch attr.Type {
+		case "", SchemaTypeAny, SchemaTypeString, SchemaTypeNumber, SchemaTypeInt, SchemaTypeFloat,
+			SchemaTypeBool, SchemaTypeTimestamp, SchemaTypeBytes, SchemaTypeList, SchemaTypeMap:
+		default:
+			return schemaError(fmt.Sprintf("Schema of kind %v contains unknown type %v for attribute %v",
+				s.Kind, attr.Type, name))
+		}
+	}
+
+	for spec, edge := range s.Edges {
+
+		if !IsFullSpec(spec) || edge == nil || edge.Max < 0 {
+			return schemaError(fmt.Sprintf("Schema of kind %v contains an invalid edge spec %v", s.Kind, spec))
+		}
+	}
+
+	return nil
+}
+
+/*
+validateItem checks the attributes of a node or edge against this schema.
+*/
+func (s *Schema) validateItem(node data.Node, name string, systemAttr func(attr string) bool) error {
+
+	schemaError := func(detail string) error {
+		return &util.GraphError{Type: util.ErrSchema, Detail: fmt.Sprintf("%v %v (%v): %v",
+			name, node.Key(), node.Kind(), detail)}
+	}
+
+	// Check required attributes and types
+
+	attrs := make([]string, 0, len(s.Attrs))
+	for attr := range s.Attrs {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	for _, attr := range attrs {
+		sattr := s.Attrs[attr]
+		val := node.Attr(attr)
+
+		if val == nil {
+			if sattr.Required {
+				return schemaError(fmt.Sprintf("Required attribute %v is missing", attr))
+			}
+			continue
+		}
+
+		if !schemaTypeMatches(sattr.Type, val) {
+			return schemaError(fmt.Sprintf("Attribute %v must be of type %v", attr, sattr.Type))
+		}
+	}
+
+	// Check for unknown attributes
+
+	if s.Strict {
+		for attr := range node.Data() {
+			if _, ok := s.Attrs[attr]; !ok && !systemAttr(attr) {
+				return schemaError(fmt.Sprintf("Attribute %v is not defined in the schema", attr))
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+schemaTypeMatches checks if a given value has a given schema type.
+*/
+func schemaTypeMatches(stype string, val interface{}) bool {
+	vtype := data.ValueType(val)
+
+	switch stype {
+	case "", SchemaTypeAny:
+		return true
+
+	case SchemaTypeNumber:
+		return vtype == SchemaTypeInt || vtype == SchemaTypeFloat
+
+	case SchemaTypeFloat:
+
+		// Whole numbers are also valid floating point numbers
+
+		return vtype == SchemaTypeFloat || vtype == SchemaTypeInt
+	}
+
+	return vtype == stype
+}
+
+/*
+SetSchema sets the schema of a kind. Only nodes and edges which are stored or
+updated after this call are validated against the schema.
+*/
+func (gm *Manager) SetSchema(schema *Schema) error {
+
+	if err := schema.Validate(); err != nil {
+		return err
+	}
+
+	val, err := json.Marshal(schema)
+	if err != nil {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: err.Error()}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.gs.MainDB()[MainDBSchema+schema.Kind] = string(val)
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+RemoveSchema removes the schema of a kind. Returns if a schema was removed.
+*/
+func (gm *Manager) RemoveSchema(kind string) (bool, error) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if _, ok := gm.gs.MainDB()[MainDBSchema+kind]; !ok {
+		return false, nil
+	}
+
+	delete(gm.gs.MainDB(), MainDBSchema+kind)
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return false, &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return true, nil
+}
+
+/*
+Schema returns the schema of a kind. Returns nil if the kind has no schema.
+*/
+func (gm *Manager) Schema(kind string) (*Schema, error) {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return gm.readSchema(kind)
+}
+
+/*
+SchemaKinds returns all kinds which have a schema.
+*/
+func (gm *Manager) SchemaKinds() []string {
+	var ret []string
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	for k := range gm.gs.MainDB() {
+		if strings.HasPrefix(k, MainDBSchema) {
+			ret = append(ret, k[len(MainDBSchema):])
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+readSchema reads the schema of a kind from the MainDB. Returns nil if the
+kind has no schema.
+*/
+func (gm *Manager) readSchema(kind string) (*Schema, error) {
+	var schema Schema
+
+	val, ok := gm.gs.MainDB()[MainDBSchema+kind]
+	if !ok {
+		return nil, nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &schema); err != nil {
+		return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprintf(
+			"Could not read schema of kind %v: %v", kind, err.Error())}
+	}
+
+	return &schema, nil
+}
+
+// System rule SystemRuleValidateSchema
+// ====================================
+
+/*
+SystemRuleValidateSchema is a system rule to validate nodes and edges against
+the schema of their kind. Nodes and edges are checked before they are stored
+through the graph manager and after they were written inside a transaction
+so a violating transaction is rolled back. Edges are also checked against the
+allowed edge specs and cardinality limits in the schemas of their endpoints.
+*/
+type SystemRuleValidateSchema struct {
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *SystemRuleValidateSchema) Name() string {
+	return "system.validateschema"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *SystemRuleValidateSchema) Handles() []int {
+	return []int{EventNodeStore, EventNodeUpdate, EventNodeCreated, EventNodeUpdated,
+		EventEdgeStore, EventEdgeCreated, EventEdgeUpdated}
+}
+
+/*
+Handle handles an event.
+*/
+func (r *SystemRuleValidateSchema) Handle(gm *Manager, trans Trans, event int, ed ...interface{}) error {
+	part := ed[0].(string)
+
+	if event == EventEdgeStore || event == EventEdgeCreated || event == EventEdgeUpdated {
+		return r.validateEdge(gm, part, ed[1].(data.Edge))
+	}
+
+	node := ed[1].(data.Node)
+
+	schema, err := gm.readSchema(node.Kind())
+	if err != nil || schema == nil {
+		return err
+	}
+
+	if event != EventNodeStore {
+
+		// Validate the node as it is (or will be) in the datastore - an
+		// update might only contain some attributes
+
+		stored, err := gm.FetchNode(part, node.Key(), node.Kind())
+		if err != nil {
+			return err
+		}
+
+		if event == EventNodeUpdate && stored != nil {
+			node = data.NodeMerge(stored, node)
+		} else if event != EventNodeUpdate && stored != nil {
+			node = stored
+		}
+	}
+
+	return schema.validateItem(node, "Node", func(attr string) bool {
+		return attr == data.NodeKey || attr == data.NodeKind
+	})
+}
+
+/*
+validateEdge validates an edge against the schema of its kind and the schemas
+of its endpoints.
+*/
+func (r *SystemRuleValidateSchema) validateEdge(gm *Manager, part string, edge data.Edge) error {
+
+	schema, err := gm.readSchema(edge.Kind())
+	if err != nil {
+		return err
+	}
+
+	if schema != nil {
+
+		err := schema.validateItem(edge, "Edge", func(attr string) bool {
+			return attr == data.NodeKey || attr == data.NodeKind || attr == data.EdgeEnd1Key ||
+				attr == data.EdgeEnd1Kind || attr == data.EdgeEnd1Role ||
+				attr == data.EdgeEnd1Cascading || attr == data.EdgeEnd1CascadingLast ||
+				attr == data.EdgeEnd2Key || attr == data.EdgeEnd2Kind || attr == data.EdgeEnd2Role ||
+				attr == data.EdgeEnd2Cascading || attr == data.EdgeEnd2CascadingLast
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, end := range [][2]string{{edge.End1Key(), edge.End1Kind()}, {edge.End2Key(), edge.End2Kind()}} {
+
+		schema, err := gm.readSchema(end[1])
+		if err != nil {
+			return err
+		} else if schema == nil || len(schema.Edges) == 0 {
+			continue
+		}
+
+		spec := edge.Spec(end[0])
+
+		sedge, ok := schema.Edges[spec]
+		if !ok {
+			return &util.GraphError{Type: util.ErrSchema, Detail: fmt.Sprintf(
+				"Edge %v (%v): Spec %v is not allowed for node %v (%v)",
+				edge.Key(), edge.Kind(), spec, end[0], end[1])}
+		}
+
+		if sedge.Max == 0 {
+			continue
+		}
+
+		// Count all other edges with the same spec
+
+		count, err := gm.countEdges(part, end[0], end[1], spec, edge.Key())
+		if err != nil {
+			return err
+		}
+
+		if count+1 > sedge.Max {
+			return &util.GraphError{Type: util.ErrSchema, Detail: fmt.Sprintf(
+				"Edge %v (%v): Node %v (%v) can have at most %v edges with spec %v",
+				edge.Key(), edge.Kind(), end[0], end[1], sedge.Max, spec)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestSchemaManagement(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	for _, test := range []struct {
+		schema *Schema
+		err    string
+	}{
+		{&Schema{Kind: "my kind"},
+			"GraphError: Invalid data (Schema kind my kind is not alphanumeric - can only contain [a-zA-Z0-9_])"},
+		{&Schema{Kind: "Person", Attrs: map[string]*SchemaAttr{"": {}}},
+			"GraphError: Invalid data (Schema of kind Person contains an invalid attribute)"},
+		{&Schema{Kind: "Person", Attrs: map[string]*SchemaAttr{"name": {Type: "foo"}}},
+			"GraphError: Invalid data (Schema of kind Person contains unknown type foo for attribute name)"},
+		{&Schema{Kind: "Person", Edges: map[string]*SchemaEdge{"a:b:c": {}}},
+			"GraphError: Invalid data (Schema of kind Person contains an invalid edge spec a:b:c)"},
+		{&Schema{Kind: "Person", Edges: map[string]*SchemaEdge{"a:b:c:d": {-1}}},
+			"GraphError: Invalid data (Schema of kind Person contains an invalid edge spec a:b:c:d)"},
+	} {
+		if err := gm.SetSchema(test.schema); err == nil || err.Error() != test.err {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	if err := gm.SetSchema(&Schema{Kind: "Person", Attrs: map[string]*SchemaAttr{
+		"name": {SchemaTypeString, true}}}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.SetSchema(&Schema{Kind: "Address"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm.SchemaKinds(); fmt.Sprint(res) != "[Address Person]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, err := gm.Schema("Person"); err != nil || res == nil ||
+		res.Attrs["name"].Type != SchemaTypeString || !res.Attrs["name"].Required {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := gm.Schema("Company"); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if ok, err := gm.RemoveSchema("Address"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := gm.RemoveSchema("Address"); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	// Invalid schemas in the MainDB are reported and fail any write of the kind
+
+	mgs.MainDB()[MainDBSchema+"Company"] = "{"
+
+	if res, err := gm.Schema("Company"); res != nil || err == nil || err.Error() !=
+		"GraphError: Invalid data (Could not read schema of kind Company: unexpected end of JSON input)" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "Company")
+
+	if err := gm.StoreNode("main", node); err == nil || err.Error() !=
+		"GraphError: Graph rule error (GraphError: Invalid data (Could not read schema of kind Company: unexpected end of JSON input))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(mgs.MainDB(), MainDBSchema+"Company")
+
+	// Test flushing errors
+
+	graphstorage.MgsRetFlushMain = errors.New("testerror")
+
+	err1 := gm.SetSchema(&Schema{Kind: "Address"})
+	_, err2 := gm.RemoveSchema("Person")
+
+	graphstorage.MgsRetFlushMain = nil
+
+	if err1 == nil || err1.Error() != "GraphError: Failed to flush changes (testerror)" ||
+		err2 == nil || err2.Error() != "GraphError: Failed to flush changes (testerror)" {
+		t.Error("Unexpected result:", err1, err2)
+		return
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	// Use disk storage since violating transactions are rolled back
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir15, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm := NewGraphManager(dgs)
+
+	gm.SetSchema(&Schema{
+		Kind: "Person",
+		Attrs: map[string]*SchemaAttr{
+			"name":    {SchemaTypeString, true},
+			"age":     {SchemaTypeNumber, false},
+			"active":  {SchemaTypeBool, false},
+			"tags":    {SchemaTypeList, false},
+			"props":   {SchemaTypeMap, false},
+			"comment": {SchemaTypeAny, false},
+		},
+		Edges: map[string]*SchemaEdge{
+			"owner:Owns:owned:Car":       {1},
+			"friend:Knows:friend:Person": {0},
+		},
+		Strict: true,
+	})
+
+	gm.SetSchema(&Schema{
+		Kind: "Owns",
+		Attrs: map[string]*SchemaAttr{
+			"since": {SchemaTypeNumber, true},
+		},
+	})
+
+	newNode := func(key string, kind string, attrs map[string]interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", kind)
+		for k, v := range attrs {
+			node.SetAttr(k, v)
+		}
+		return node
+	}
+
+	newEdge := func(key string, kind string, end1 string, end1kind string, role1 string,
+		end2 string, end2kind string, role2 string, attrs map[string]interface{}) data.Edge {
+
+		edge := data.NewGraphEdgeFromNode(newNode(key, kind, attrs))
+		edge.SetAttr(data.EdgeEnd1Key, end1)
+		edge.SetAttr(data.EdgeEnd1Kind, end1kind)
+		edge.SetAttr(data.EdgeEnd1Role, role1)
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, end2)
+		edge.SetAttr(data.EdgeEnd2Kind, end2kind)
+		edge.SetAttr(data.EdgeEnd2Role, role2)
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	// Check node validation through the graph manager
+
+	for _, test := range []struct {
+		attrs map[string]interface{}
+		err   string
+	}{
+		{map[string]interface{}{"age": 5},
+			"Node 1 (Person): Required attribute name is missing"},
+		{map[string]interface{}{"name": 5},
+			"Node 1 (Person): Attribute name must be of type string"},
+		{map[string]interface{}{"name": "Hans", "age": "5"},
+			"Node 1 (Person): Attribute age must be of type number"},
+		{map[string]interface{}{"name": "Hans", "active": "yes"},
+			"Node 1 (Person): Attribute active must be of type bool"},
+		{map[string]interface{}{"name": "Hans", "tags": "a"},
+			"Node 1 (Person): Attribute tags must be of type list"},
+		{map[string]interface{}{"name": "Hans", "props": "a"},
+			"Node 1 (Person): Attribute props must be of type map"},
+		{map[string]interface{}{"name": "Hans", "email": "a"},
+			"Node 1 (Person): Attribute email is not defined in the schema"},
+	} {
+		err := gm.StoreNode("main", newNode("1", "Person", test.attrs))
+		if err == nil || err.Error() !=
+			"GraphError: Graph rule error (GraphError: Schema violation ("+test.err+"))" {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	if res := gm.NodeCount("Person"); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.StoreNode("main", newNode("1", "Person", map[string]interface{}{
+		"name": "Hans", "age": 42, "active": true, "tags": []string{"a"},
+		"props": map[string]interface{}{"a": 1}, "comment": 1.5,
+	})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Updates are validated against the merged node
+
+	if err := gm.UpdateNode("main", newNode("1", "Person", map[string]interface{}{"age": 43.5})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.UpdateNode("main", newNode("1", "Person", map[string]interface{}{"age": "old"})); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "1", "Person"); n.Attr("age") != 43.5 {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Kinds without a schema are not validated
+
+	if err := gm.StoreNode("main", newNode("1", "Car", map[string]interface{}{"foo": "bar"})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.StoreNode("main", newNode("2", "Car", nil))
+
+	// Check validation in transactions - violating transactions are rolled back
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("2", "Person", map[string]interface{}{"name": "Anne"}))
+	trans.StoreNode("main", newNode("3", "Person", map[string]interface{}{"age": 5}))
+
+	if err := trans.Commit(); err == nil || err.Error() !=
+		"GraphError: Graph rule error (GraphError: Schema violation (Node 3 (Person): Required attribute name is missing))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "2", "Person"); n != nil {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	trans = NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("2", "Person", map[string]interface{}{"name": "Anne"}))
+	trans.UpdateNode("main", newNode("1", "Person", map[string]interface{}{"active": false}))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Check edge validation
+
+	for _, test := range []struct {
+		edge data.Edge
+		err  string
+	}{
+		{newEdge("e1", "Owns", "1", "Person", "owner", "1", "Car", "owned", nil),
+			"Edge e1 (Owns): Required attribute since is missing"},
+		{newEdge("e1", "Likes", "1", "Person", "owner", "1", "Car", "owned", nil),
+			"Edge e1 (Likes): Spec owner:Likes:owned:Car is not allowed for node 1 (Person)"},
+		{newEdge("e1", "Likes", "2", "Car", "owned", "1", "Person", "owner", nil),
+			"Edge e1 (Likes): Spec owner:Likes:owned:Car is not allowed for node 1 (Person)"},
+	} {
+		err := gm.StoreEdge("main", test.edge)
+		if err == nil || err.Error() !=
+			"GraphError: Graph rule error (GraphError: Schema violation ("+test.err+"))" {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e1", "Owns", "1", "Person", "owner", "1", "Car", "owned",
+		map[string]interface{}{"since": 2010})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Storing the same edge again does not exceed the cardinality
+
+	if err := gm.StoreEdge("main", newEdge("e1", "Owns", "1", "Person", "owner", "1", "Car", "owned",
+		map[string]interface{}{"since": 2011})); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e2", "Owns", "1", "Person", "owner", "2", "Car", "owned",
+		map[string]interface{}{"since": 2012})); err == nil || err.Error() !=
+		"GraphError: Graph rule error (GraphError: Schema violation (Edge e2 (Owns): "+
+			"Node 1 (Person) can have at most 1 edges with spec owner:Owns:owned:Car))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Cardinality is also checked in transactions
+
+	trans = NewGraphTrans(gm)
+	trans.StoreEdge("main", newEdge("e2", "Owns", "2", "Person", "owner", "2", "Car", "owned",
+		map[string]interface{}{"since": 2012}))
+	trans.StoreEdge("main", newEdge("e3", "Owns", "2", "Person", "owner", "1", "Car", "owned",
+		map[string]interface{}{"since": 2012}))
+
+	if err := trans.Commit(); err == nil {
+		t.Error("Error expected")
+		return
+	}
+
+	if res := gm.EdgeCount("Owns"); res != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Edges without cardinality limit
+
+	for i := 0; i < 3; i++ {
+		if err := gm.StoreEdge("main", newEdge(fmt.Sprint("k", i), "Knows", "1", "Person", "friend",
+			"2", "Person", "friend", nil)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,39 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+)
+
+/*
+Snapshot creates a consistent copy of all graph data in a given directory while
+the graph storage is in use. Write operations are blocked while the snapshot is
+taken - read operations can continue. The snapshot can be restored with
+graphstorage.RestoreDiskGraphStorage. Returns an error if the graph storage
+does not support snapshots (e.g. memory only storage).
+*/
+func (gm *Manager) Snapshot(dir string) error {
+
+	ss, ok := gm.gs.(graphstorage.SnapshotStorage)
+	if !ok {
+		return &util.GraphError{Type: util.ErrAccessComponent,
+			Detail: "Graph storage does not support snapshots"}
+	}
+
+	// Block all write operations
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	return ss.Snapshot(dir)
+}
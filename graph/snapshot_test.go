@@ -0,0 +1,89 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"os"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+)
+
+func TestSnapshot(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	snapshot := GraphManagerTestDBDir8 + "/snapshot"
+
+	os.MkdirAll(GraphManagerTestDBDir8, 0770)
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir8+"/db", false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "mykind")
+	node.SetAttr("name", "Node1")
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.Snapshot(snapshot); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "123", "mykind"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.Close()
+
+	if err := graphstorage.RestoreDiskGraphStorage(snapshot, GraphManagerTestDBDir8+"/db"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs, _ = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir8+"/db", false)
+	gm = NewGraphManager(dgs)
+
+	if n, err := gm.FetchNode("main", "123", "mykind"); err != nil || n == nil || n.Attr("name") != "Node1" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if res, err := gm.Check(false); err != nil || len(res) != 2 || len(res[0].Problems) != 0 {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	dgs.Close()
+
+	// Memory storage does not support snapshots
+
+	gm = NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if err := gm.Snapshot(snapshot); err == nil ||
+		err.Error() != "GraphError: Failed to access graph storage component (Graph storage does not support snapshots)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+Types of graph data in storage statistics
+*/
+const (
+	StorageStatsNodes = "node"
+	StorageStatsEdges = "edge"
+)
+
+/*
+StorageStats contains the statistics of the datastores which hold the nodes or
+edges of a kind in a partition.
+*/
+type StorageStats struct {
+	Partition string                // Partition of the graph data
+	Kind      string                // Node or edge kind
+	Type      string                // Type of the graph data (node or edge)
+	Data      *storage.ManagerStats // Statistics of the datastore for the graph data (nil if it does not exist)
+	Index     *storage.ManagerStats // Statistics of the datastore for the index (nil if it does not exist)
+}
+
+/*
+Total returns the combined statistics of the data and the index datastore.
+*/
+func (ss *StorageStats) Total() *storage.ManagerStats {
+	total := storage.NewManagerStats(ss.Partition + ss.Kind)
+
+	for _, ms := range []*storage.ManagerStats{ss.Data, ss.Index} {
+		if ms != nil {
+			total.Add(ms)
+		}
+	}
+
+	return total
+}
+
+/*
+StorageStats returns the statistics of all datastores which hold graph data.
+The statistics are grouped by partition and kind. Datastores which do not
+report statistics are skipped.
+*/
+func (gm *Manager) StorageStats() ([]*StorageStats, error) {
+	var ret []*StorageStats
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	add := func(part string, kind string, stype string, dataName string, indexName string) error {
+		data, err := gm.storageManagerStats(dataName)
+		if err != nil {
+			return err
+		}
+
+		index, err := gm.storageManagerStats(indexName)
+		if err != nil {
+			return err
+		}
+
+		if data != nil || index != nil {
+			ret = append(ret, &StorageStats{part, kind, stype, data, index})
+		}
+
+		return nil
+	}
+
+	for _, part := range gm.Partitions() {
+
+		for _, kind := range gm.NodeKinds() {
+			if err := add(part, kind, StorageStatsNodes, part+kind+StorageSuffixNodes,
+				part+kind+StorageSuffixNodesIndex); err != nil {
+				return ret, err
+			}
+		}
+
+		for _, kind := range gm.EdgeKinds() {
+			if err := add(part, kind, StorageStatsEdges, part+kind+StorageSuffixEdges,
+				part+kind+StorageSuffixEdgesIndex); err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+storageManagerStats returns the statistics of a single datastore. Returns nil
+if the datastore does not exist or does not report statistics.
+*/
+func (gm *Manager) storageManagerStats(name string) (*storage.ManagerStats, error) {
+
+	sm := gm.gs.StorageManager(name, false)
+	if sm == nil {
+		return nil, nil
+	}
+
+	sr, ok := sm.(storage.StatsReporter)
+	if !ok {
+		return nil, nil
+	}
+
+	stats, err := sr.Stats()
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,172 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/storage"
+)
+
+/*
+statsErrorStorage is a graph storage whose storage managers fail to report
+statistics.
+*/
+type statsErrorStorage struct {
+	graphstorage.Storage
+}
+
+func (ses *statsErrorStorage) StorageManager(smname string, create bool) storage.Manager {
+	if sm := ses.Storage.StorageManager(smname, create); sm != nil {
+		return &statsErrorManager{sm}
+	}
+	return nil
+}
+
+/*
+noStatsStorage is a graph storage whose storage managers do not report
+statistics.
+*/
+type noStatsStorage struct {
+	graphstorage.Storage
+}
+
+func (nss *noStatsStorage) StorageManager(smname string, create bool) storage.Manager {
+	if sm := nss.Storage.StorageManager(smname, create); sm != nil {
+		return struct{ storage.Manager }{sm}
+	}
+	return nil
+}
+
+type statsErrorManager struct {
+	storage.Manager
+}
+
+func (sem *statsErrorManager) Stats() (*storage.ManagerStats, error) {
+	return nil, errors.New("testerror")
+}
+
+func TestStorageStats(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir12, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	for i := 0; i < 10; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "mykind")
+		node.SetAttr("name", fmt.Sprint("Node", i))
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, "1")
+	edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd1Role, "node")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "2")
+	edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd2Role, "node")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	if err := gm.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, err := gm.StorageStats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res []string
+	for _, s := range stats {
+		res = append(res, fmt.Sprint(s.Partition, ":", s.Kind, ":", s.Type))
+	}
+
+	if fmt.Sprint(res) != "[main:mykind:node main:myedge:edge]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if stats[0].Data.Records == 0 || stats[0].Index.Records == 0 ||
+		stats[0].Data.FileBytes == 0 || stats[0].Data.Cache.Objects == 0 {
+		t.Error("Unexpected stats:", stats[0].Data, stats[0].Index)
+		return
+	}
+
+	total := stats[0].Total()
+	if total.Records != stats[0].Data.Records+stats[0].Index.Records {
+		t.Error("Unexpected total:", total)
+		return
+	}
+
+	if stats[1].Data.Records == 0 {
+		t.Error("Unexpected stats:", stats[1].Data)
+		return
+	}
+
+	dgs.Close()
+
+	// Memory storage reports only the number of records
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm = NewGraphManager(mgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "mykind")
+	gm.StoreNode("main", node)
+
+	if stats, err = gm.StorageStats(); err != nil || len(stats) != 1 ||
+		stats[0].Data.Records == 0 || stats[0].Index == nil || stats[0].Data.FileBytes != 0 {
+		t.Error("Unexpected result:", stats, err)
+		return
+	}
+
+	// Errors are reported
+
+	gm = NewGraphManager(&statsErrorStorage{mgs})
+
+	if _, err := gm.StorageStats(); err == nil || err.(*util.GraphError).Type != util.ErrAccessComponent {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Storage managers which do not report statistics are skipped
+
+	gm = NewGraphManager(&noStatsStorage{mgs})
+
+	if stats, err = gm.StorageStats(); err != nil || len(stats) != 0 {
+		t.Error("Unexpected result:", stats, err)
+		return
+	}
+}
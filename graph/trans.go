@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/krotik/common/errorutil"
 	"github.com/krotik/eliasdb/graph/data"
@@ -149,7 +150,7 @@ func newInternalGraphTrans(gm *Manager) *baseTrans {
 	idCounter++
 
 	return &baseTrans{fmt.Sprint(idCounter), gm, false, make(map[string]data.Node), make(map[string]data.Node),
-		make(map[string]data.Edge), make(map[string]data.Edge)}
+		make(map[string]data.Edge), make(map[string]data.Edge), time.Time{}, nil}
 }
 
 /*
@@ -162,7 +163,7 @@ var idCounterLock = &sync.Mutex{}
 baseTrans is the main data structure for a graph transaction
 */
 type baseTrans struct {
-	id       string   // Unique transaction ID - not used by EliasDB
+	id       string   // Unique transaction ID - stored with versions of nodes and edges
 	gm       *Manager // Graph manager which created this transaction
 	subtrans bool     // Flag if the transaction is a subtransaction
 
@@ -170,6 +171,9 @@ type baseTrans struct {
 	removeNodes map[string]data.Node // Nodes which should be removed
 	storeEdges  map[string]data.Edge // Edges which should be stored
 	removeEdges map[string]data.Edge // Edges which should be removed
+
+	timestamp time.Time // Commit timestamp which is stored with versions of nodes and edges
+	changes   []*Change // Written changes which are appended to the change log
 }
 
 /*
@@ -210,28 +214,79 @@ func (gt *baseTrans) String() string {
 Commit writes the transaction to the graph database. An automatic rollback is done if
 any non-fatal error occurs. Failed transactions cannot be committed again.
 Serious write errors which may corrupt the database will cause a panic.
+Commit returns once the transaction has been written to disk according to the
+sync policy of the graph storage.
 */
 func (gt *baseTrans) Commit() error {
 
-	// Take writer lock if we are not in a subtransaction
+	// Subtransactions are committed by their parent transaction
+
+	if gt.subtrans {
+		return gt.commit()
+	}
 
-	if !gt.subtrans {
-		gt.gm.mutex.Lock()
-		defer gt.gm.mutex.Unlock()
+	if err := gt.commitWithLock(); err != nil {
+		return err
 	}
 
-	// Return if there is nothing to do
+	// Wait for the transaction logs outside of the writer lock so concurrent
+	// transactions can share a single sync
+
+	return gt.gm.waitDurable()
+}
+
+/*
+commitChanges discards all pending operations of the transaction and only
+appends its recorded changes to the change log. This is used if a rule fails
+after a node or edge was written - the written data is flushed by the caller
+and must not be missing from the change log. It is assumed that the caller
+holds the writer lock.
+*/
+func (gt *baseTrans) commitChanges() error {
+
+	gt.storeNodes = make(map[string]data.Node)
+	gt.removeNodes = make(map[string]data.Node)
+	gt.storeEdges = make(map[string]data.Edge)
+	gt.removeEdges = make(map[string]data.Edge)
+
+	return gt.commit()
+}
+
+/*
+commitWithLock commits the transaction while holding the writer lock.
+*/
+func (gt *baseTrans) commitWithLock() error {
+	gt.gm.mutex.Lock()
+	defer gt.gm.mutex.Unlock()
+
+	return gt.commit()
+}
+
+/*
+commit writes the transaction to the graph database.
+*/
+func (gt *baseTrans) commit() error {
 
-	if gt.IsEmpty() {
+	// Return if there is nothing to do - changes which were written outside
+	// of the transaction still need to be added to the change log
+
+	if gt.IsEmpty() && len(gt.changes) == 0 {
 		return nil
 	}
 
+	if gt.timestamp.IsZero() {
+		gt.timestamp = time.Now()
+	}
+
 	doRollback := func(nodePartsAndKinds map[string]string,
 		edgePartsAndKinds map[string]string) {
 
-		// Rollback main database
+		// Rollback main database and change log
 
 		gt.gm.gs.RollbackMain()
+		gt.gm.rollbackChangeLog()
+
+		gt.changes = nil
 
 		// Rollback node storages
 
@@ -239,6 +294,8 @@ func (gt *baseTrans) Commit() error {
 			partAndKind := strings.Split(kkey, "#")
 
 			gt.gm.rollbackNodeIndex(partAndKind[0], partAndKind[1])
+			gt.gm.rollbackNodeUnique(partAndKind[0], partAndKind[1])
+			gt.gm.rollbackNodeHistory(partAndKind[0], partAndKind[1])
 			gt.gm.rollbackNodeStorage(partAndKind[0], partAndKind[1])
 		}
 
@@ -252,6 +309,7 @@ func (gt *baseTrans) Commit() error {
 				partAndKind := strings.Split(kkey, "#")
 
 				gt.gm.rollbackEdgeIndex(partAndKind[0], partAndKind[1])
+				gt.gm.rollbackEdgeHistory(partAndKind[0], partAndKind[1])
 				gt.gm.rollbackEdgeStorage(partAndKind[0], partAndKind[1])
 			}
 		}
@@ -282,6 +340,14 @@ func (gt *baseTrans) Commit() error {
 		}
 	}
 
+	// Append all changes to the change log
+
+	sequence, err := gt.gm.writeChangeSet(gt)
+	if err != nil {
+		doRollback(nodePartsAndKinds, edgePartsAndKinds)
+		return err
+	}
+
 	// Flush changes - panic instead of error reporting since the database
 	// may be inconsistent
 
@@ -291,13 +357,19 @@ func (gt *baseTrans) Commit() error {
 		}
 	}
 
+	// The change log is flushed before the node and edge storages so no
+	// written change is ever missing from the change log
+
 	panicIfError(gt.gm.gs.FlushMain())
+	panicIfError(gt.gm.flushChangeLog())
 
 	for kkey := range nodePartsAndKinds {
 
 		partAndKind := strings.Split(kkey, "#")
 
 		panicIfError(gt.gm.flushNodeIndex(partAndKind[0], partAndKind[1]))
+		panicIfError(gt.gm.flushNodeUnique(partAndKind[0], partAndKind[1]))
+		panicIfError(gt.gm.flushNodeHistory(partAndKind[0], partAndKind[1]))
 		panicIfError(gt.gm.flushNodeStorage(partAndKind[0], partAndKind[1]))
 	}
 
@@ -306,9 +378,18 @@ func (gt *baseTrans) Commit() error {
 		partAndKind := strings.Split(kkey, "#")
 
 		panicIfError(gt.gm.flushEdgeIndex(partAndKind[0], partAndKind[1]))
+		panicIfError(gt.gm.flushEdgeHistory(partAndKind[0], partAndKind[1]))
 		panicIfError(gt.gm.flushEdgeStorage(partAndKind[0], partAndKind[1]))
 	}
 
+	// Consumers are notified once the changes are durable (see waitDurable)
+
+	gt.changes = nil
+
+	if sequence != 0 {
+		gt.gm.markDurable(sequence)
+	}
+
 	return nil
 }
 
@@ -317,6 +398,12 @@ commitNodes tries to commit all transaction nodes.
 */
 func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKinds map[string]string) error {
 
+	// Nodes whose unique constraint values need to be claimed once the
+	// values of all changed nodes have been released
+
+	var uniqueParts []string
+	var uniqueNodes []data.Node
+
 	// First insert nodes
 
 	for tkey, node := range gt.storeNodes {
@@ -348,6 +435,20 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 			return err
 		}
 
+		if err := gt.gm.updateUniqueKeys(part, oldnode, nil); err != nil {
+			return err
+		}
+
+		uniqueParts = append(uniqueParts, part)
+		uniqueNodes = append(uniqueNodes, node)
+
+		if err := gt.gm.recordNodeVersion(part, node.Key(), node.Kind(), gt.id,
+			gt.timestamp, attht, valht); err != nil {
+			return err
+		}
+
+		gt.gm.addNodeChange(gt, part, node.Key(), node.Kind(), node, oldnode)
+
 		// Increase node count if the node was inserted and write the changes
 		// to the index.
 
@@ -446,6 +547,17 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 				}
 			}
 
+			if err := gt.gm.updateUniqueKeys(part, oldnode, nil); err != nil {
+				return err
+			}
+
+			if err := gt.gm.recordNodeVersion(part, node.Key(), node.Kind(), gt.id,
+				gt.timestamp, attTree, valTree); err != nil {
+				return err
+			}
+
+			gt.gm.addNodeChange(gt, part, node.Key(), node.Kind(), nil, oldnode)
+
 			// Decrease the node count
 
 			currentCount := gt.gm.NodeCount(node.Kind())
@@ -461,6 +573,15 @@ func (gt *baseTrans) commitNodes(nodePartsAndKinds map[string]string, edgePartsA
 		delete(gt.removeNodes, tkey)
 	}
 
+	// Claim the unique constraint values of all stored nodes - a violation
+	// causes a rollback of the whole transaction
+
+	for i, node := range uniqueNodes {
+		if err := gt.gm.updateUniqueKeys(uniqueParts[i], nil, node); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -536,6 +657,13 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 			return err
 		}
 
+		if err := gt.gm.recordEdgeVersion(part, edge.Key(), edge.Kind(), gt.id,
+			gt.timestamp, edgeht); err != nil {
+			return err
+		}
+
+		gt.gm.addEdgeChange(gt, part, edge.Key(), edge.Kind(), edge, oldedge)
+
 		// Increase edge count if the edge was inserted and write the changes
 		// to the index.
 
@@ -643,6 +771,13 @@ func (gt *baseTrans) commitEdges(nodePartsAndKinds map[string]string, edgePartsA
 				return err
 			}
 
+			if err := gt.gm.recordEdgeVersion(part, edge.Key(), edge.Kind(), gt.id,
+				gt.timestamp, edgeht); err != nil {
+				return err
+			}
+
+			gt.gm.addEdgeChange(gt, part, edge.Key(), edge.Kind(), nil, oldedge)
+
 			if iht != nil {
 
 				err := util.NewIndexManager(iht).Deindex(edge.Key(), oldedge.IndexMap())
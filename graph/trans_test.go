@@ -16,10 +16,12 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/krotik/eliasdb/graph/data"
 	"github.com/krotik/eliasdb/graph/graphstorage"
 	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 /*
@@ -502,6 +504,154 @@ func TestNormalTrans(t *testing.T) {
 	dgs.Close()
 }
 
+func TestGroupCommitTrans(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorageWithOptions(GraphManagerTestDBDir10, false,
+		storage.DiskStorageManagerOptions{Log: storage.LogOptions{SyncPolicy: file.SyncPolicy{Mode: file.SyncGroup,
+			WindowTime: 5 * time.Millisecond}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	// Concurrent transactions share the syncs of their transaction logs
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			node := data.NewGraphNode()
+			node.SetAttr("key", fmt.Sprint(i))
+			node.SetAttr("kind", "mykind")
+
+			trans := NewGraphTrans(gm)
+			trans.StoreNode("main", node)
+
+			if err := trans.Commit(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs, _ = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir10, false)
+	gm = NewGraphManager(dgs)
+
+	if res := gm.NodeCount("mykind"); res != 20 {
+		t.Error("Unexpected node count:", res)
+		return
+	}
+
+	dgs.Close()
+}
+
+func TestGroupCommitDirect(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	syncer, _ := file.NewLogSyncer(file.SyncPolicy{Mode: file.SyncGroup, WindowTime: time.Hour})
+	defer syncer.Close()
+
+	dgs, err := graphstorage.NewDiskGraphStorageWithOptions(GraphManagerTestDBDir20, false,
+		storage.DiskStorageManagerOptions{Log: storage.LogOptions{SyncPolicy: syncer.Policy(), Syncer: syncer}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	gm := NewGraphManager(dgs)
+
+	// Operations outside of a transaction only return once they have been
+	// written to disk
+
+	checkWaits := func(name string, op func() error) {
+		done := make(chan error)
+
+		go func() {
+			done <- op()
+		}()
+
+		select {
+		case err := <-done:
+			t.Error(name, "returned before its changes were synced:", err)
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if err := syncer.Sync(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := <-done; err != nil {
+			t.Error(name, err)
+		}
+	}
+
+	node1 := data.NewGraphNode()
+	node1.SetAttr("key", "1")
+	node1.SetAttr("kind", "mykind")
+
+	node2 := data.NewGraphNode()
+	node2.SetAttr("key", "2")
+	node2.SetAttr("kind", "mykind")
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "1")
+	edge.SetAttr("kind", "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, "1")
+	edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd1Role, "node")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "2")
+	edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd2Role, "node")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	checkWaits("StoreNode", func() error {
+		return gm.StoreNode("main", node1)
+	})
+
+	checkWaits("UpdateNode", func() error {
+		return gm.UpdateNode("main", node1)
+	})
+
+	checkWaits("StoreNode", func() error {
+		return gm.StoreNode("main", node2)
+	})
+
+	checkWaits("StoreEdge", func() error {
+		return gm.StoreEdge("main", edge)
+	})
+
+	checkWaits("RemoveEdge", func() error {
+		_, err := gm.RemoveEdge("main", "1", "myedge")
+		return err
+	})
+
+	checkWaits("RemoveNode", func() error {
+		_, err := gm.RemoveNode("main", "1", "mykind")
+		return err
+	})
+}
+
 func TestTransBuilding(t *testing.T) {
 	node1 := data.NewGraphNode()
 	node1.SetAttr("key", "123")
@@ -0,0 +1,200 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/krotik/common/stringutil"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+)
+
+/*
+Names of the trees in tree statistics
+*/
+const (
+	TreeStatsAttrs   = "attrs"   // Tree which holds the attribute lists of nodes
+	TreeStatsValues  = "values"  // Tree which holds the attribute values of nodes
+	TreeStatsEdges   = "edges"   // Tree which holds edges
+	TreeStatsIndex   = "index"   // Tree which holds the full text index
+	TreeStatsUnique  = "unique"  // Tree which holds the unique constraint lookup
+	TreeStatsHistory = "history" // Tree which holds the versions of nodes or edges
+)
+
+/*
+TreeStats contains the statistics of a single HTree which holds graph data.
+*/
+type TreeStats struct {
+	Partition string           // Partition of the graph data
+	Kind      string           // Node or edge kind
+	Type      string           // Type of the graph data (node or edge)
+	Tree      string           // Name of the tree
+	Stats     *hash.HTreeStats // Statistics of the tree
+}
+
+/*
+TreeStats returns the statistics of all HTrees which hold graph data. This
+includes the trees which store nodes and edges as well as the index trees. The
+statistics of each tree include the given number of largest buckets. Trees
+which do not exist are skipped.
+*/
+func (gm *Manager) TreeStats(largest int) ([]*TreeStats, error) {
+	var ret []*TreeStats
+
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	add := func(part string, kind string, stype string, smname string, slot int, tree string) error {
+
+		sm := gm.gs.StorageManager(smname, false)
+		if sm == nil {
+			return nil
+		}
+
+		loc := sm.Root(slot)
+		if loc == 0 {
+			return nil
+		}
+
+		htree, err := hash.LoadHTree(sm, loc)
+
+		if err == nil {
+			var stats *hash.HTreeStats
+
+			if stats, err = htree.Stats(largest); err == nil {
+				ret = append(ret, &TreeStats{part, kind, stype, tree, stats})
+				return nil
+			}
+		}
+
+		return &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+	}
+
+	for _, part := range gm.Partitions() {
+
+		for _, kind := range gm.NodeKinds() {
+			for _, t := range []struct {
+				smname string
+				slot   int
+				tree   string
+			}{
+				{part + kind + StorageSuffixNodes, RootIDNodeHTree, TreeStatsAttrs},
+				{part + kind + StorageSuffixNodes, RootIDNodeHTreeSecond, TreeStatsValues},
+				{part + kind + StorageSuffixNodesIndex, RootIDNodeHTree, TreeStatsIndex},
+				{part + kind + StorageSuffixNodesUnique, RootIDNodeHTree, TreeStatsUnique},
+				{part + kind + StorageSuffixNodesHistory, RootIDNodeHTree, TreeStatsHistory},
+			} {
+				if err := add(part, kind, StorageStatsNodes, t.smname, t.slot, t.tree); err != nil {
+					return ret, err
+				}
+			}
+		}
+
+		for _, kind := range gm.EdgeKinds() {
+			if err := add(part, kind, StorageStatsEdges, part+kind+StorageSuffixEdges,
+				RootIDNodeHTree, TreeStatsEdges); err != nil {
+				return ret, err
+			}
+
+			if err := add(part, kind, StorageStatsEdges, part+kind+StorageSuffixEdgesIndex,
+				RootIDNodeHTree, TreeStatsIndex); err != nil {
+				return ret, err
+			}
+
+			if err := add(part, kind, StorageStatsEdges, part+kind+StorageSuffixEdgesHistory,
+				RootIDNodeHTree, TreeStatsHistory); err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+SetKindTreeOptions sets the options for HTrees which are created for a given
+kind. The options can set a custom seed for the hash function and more tree
+levels for very large kinds. The options are only used for trees which are
+created after this call - existing trees keep their options. The options apply
+to node and edge storage as well as index trees of the kind in all partitions.
+Setting nil options restores the default.
+*/
+func (gm *Manager) SetKindTreeOptions(kind string, opts *hash.HTreeOptions) error {
+
+	if !stringutil.IsAlphaNumeric(kind) {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Kind %v is not alphanumeric - can only contain [a-zA-Z0-9_]", kind),
+		}
+	}
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.storageMutex.Lock()
+	defer gm.storageMutex.Unlock()
+
+	if opts == nil {
+		delete(gm.gs.MainDB(), MainDBTreeOptions+kind)
+
+	} else {
+
+		if opts.MaxDepth < hash.MaxTreeDepth || opts.MaxDepth > hash.MaxCustomTreeDepth {
+			return &util.GraphError{Type: util.ErrInvalidData, Detail: hash.ErrInvalidTreeDepth.Error()}
+		}
+
+		optstr := make([]byte, 5)
+
+		binary.LittleEndian.PutUint32(optstr, opts.Seed)
+		optstr[4] = opts.MaxDepth
+
+		gm.gs.MainDB()[MainDBTreeOptions+kind] = string(optstr)
+	}
+
+	if err := gm.gs.FlushMain(); err != nil {
+		return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+KindTreeOptions returns the options for HTrees which are created for a given
+kind. Returns nil if the kind uses default trees.
+*/
+func (gm *Manager) KindTreeOptions(kind string) *hash.HTreeOptions {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	gm.storageMutex.Lock()
+	defer gm.storageMutex.Unlock()
+
+	return gm.readTreeOptions(kind)
+}
+
+/*
+readTreeOptions reads the tree options of a given kind from the MainDB.
+Returns nil if the kind uses default trees.
+*/
+func (gm *Manager) readTreeOptions(kind string) *hash.HTreeOptions {
+
+	optstr, ok := gm.gs.MainDB()[MainDBTreeOptions+kind]
+	if !ok || len(optstr) != 5 {
+		return nil
+	}
+
+	return &hash.HTreeOptions{
+		Seed:     binary.LittleEndian.Uint32([]byte(optstr)),
+		MaxDepth: optstr[4],
+	}
+}
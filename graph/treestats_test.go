@@ -0,0 +1,157 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/graph/util"
+	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestTreeStatsAndOptions(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	if err := gm.SetKindTreeOptions("my kind", nil); err == nil ||
+		err.Error() != "GraphError: Invalid data (Kind my kind is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetKindTreeOptions("mykind", &hash.HTreeOptions{Seed: 1, MaxDepth: 8}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Tree depth must be between 3 and 7)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetKindTreeOptions("mykind", &hash.HTreeOptions{Seed: 99, MaxDepth: 5}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm.KindTreeOptions("mykind"); res == nil || res.Seed != 99 || res.MaxDepth != 5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.KindTreeOptions("myedge"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "mykind")
+		node.SetAttr("name", fmt.Sprint("Node", i))
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr("key", "e1")
+	edge.SetAttr("kind", "myedge")
+	edge.SetAttr(data.EdgeEnd1Key, "1")
+	edge.SetAttr(data.EdgeEnd1Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd1Role, "node")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "2")
+	edge.SetAttr(data.EdgeEnd2Kind, "mykind")
+	edge.SetAttr(data.EdgeEnd2Role, "node")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	if err := gm.StoreEdge("main", edge); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, err := gm.TreeStats(2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res []string
+	for _, s := range stats {
+		res = append(res, fmt.Sprint(s.Partition, ":", s.Kind, ":", s.Type, ":", s.Tree,
+			":", s.Stats.Options.MaxDepth))
+	}
+
+	if fmt.Sprint(res) != "[main:mykind:node:attrs:5 main:mykind:node:values:5 main:mykind:node:index:5 "+
+		"main:myedge:edge:edges:3 main:myedge:edge:index:3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if stats[0].Stats.TotalElements() != 100 || stats[1].Stats.TotalElements() != 104 ||
+		len(stats[0].Stats.LargestBuckets) != 2 || stats[3].Stats.TotalElements() != 9 {
+		t.Error("Unexpected result:", stats[0].Stats, stats[1].Stats, stats[3].Stats)
+		return
+	}
+
+	// Stored nodes can be read from custom trees
+
+	if n, err := gm.FetchNode("main", "42", "mykind"); err != nil || n.Attr("name") != "Node42" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Existing trees keep their options
+
+	if err := gm.SetKindTreeOptions("mykind", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm.KindTreeOptions("mykind"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", "42", "mykind"); err != nil || n.Attr("name") != "Node42" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	// Errors are reported
+
+	msm := mgs.StorageManager("main"+"mykind"+StorageSuffixNodes, false).(*storage.MemoryStorageManager)
+	msm.AccessMap[msm.Root(RootIDNodeHTree)] = storage.AccessCacheAndFetchError
+
+	if _, err := gm.TreeStats(2); err == nil || err.(*util.GraphError).Type != util.ErrAccessComponent {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(msm.AccessMap, msm.Root(RootIDNodeHTree))
+
+	// Flushing errors are reported
+
+	graphstorage.MgsRetFlushMain = errors.New("testerror")
+
+	err = gm.SetKindTreeOptions("mykind", nil)
+
+	graphstorage.MgsRetFlushMain = nil
+
+	if err == nil || err.Error() != "GraphError: Failed to flush changes (testerror)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
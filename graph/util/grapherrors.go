@@ -87,4 +87,6 @@ var (
 	ErrReading     = errors.New("Could not read graph information")
 	ErrWriting     = errors.New("Could not write graph information")
 	ErrRule        = errors.New("Graph rule error")
+	ErrSchema      = errors.New("Schema violation")
+	ErrUnique      = errors.New("Unique constraint violation")
 )
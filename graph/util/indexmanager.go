@@ -24,6 +24,7 @@ import (
 	"github.com/krotik/common/sortutil"
 	"github.com/krotik/common/stringutil"
 	"github.com/krotik/eliasdb/hash"
+	"github.com/krotik/eliasdb/storage"
 )
 
 /*
@@ -65,6 +66,7 @@ func init() {
 	// Make sure we can use indexEntry in a gob operation
 
 	gob.Register(&indexEntry{})
+	storage.RegisterCodecType(&indexEntry{})
 }
 
 /*
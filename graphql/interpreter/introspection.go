@@ -15,6 +15,7 @@ import (
 	"strings"
 
 	"github.com/krotik/common/lang/graphql/parser"
+	"github.com/krotik/eliasdb/graph/data"
 )
 
 /*
@@ -320,6 +321,8 @@ func (rt *selectionSetRuntime) GetEliasDBTypesIntrospection() interface{} {
 
 		fields := make([]interface{}, 0)
 
+		attrTypes := rt.rtp.gm.NodeAttrTypes(kind)
+
 		for _, attr := range rt.rtp.gm.NodeAttrs(kind) {
 
 			fields = append(fields, map[string]interface{}{
@@ -328,7 +331,7 @@ func (rt *selectionSetRuntime) GetEliasDBTypesIntrospection() interface{} {
 				"args":        []interface{}{},
 				"type": map[string]interface{}{
 					"kind":   "SCALAR",
-					"name":   "String",
+					"name":   scalarTypeName(attrTypes[attr]),
 					"ofType": nil,
 				},
 				"isDeprecated":      false,
@@ -445,6 +448,26 @@ func (rt *selectionSetRuntime) GetEliasDBTypesIntrospection() interface{} {
 		})
 	}
 
+	res = append(res, map[string]interface{}{
+		"kind":          "SCALAR",
+		"name":          "Timestamp",
+		"description":   "The `Timestamp` scalar type represents a point in time as RFC 3339 string.",
+		"fields":        nil,
+		"inputFields":   nil,
+		"interfaces":    nil,
+		"enumValues":    nil,
+		"possibleTypes": nil,
+	}, map[string]interface{}{
+		"kind":          "SCALAR",
+		"name":          "Bytes",
+		"description":   "The `Bytes` scalar type represents binary data as base64 encoded string.",
+		"fields":        nil,
+		"inputFields":   nil,
+		"interfaces":    nil,
+		"enumValues":    nil,
+		"possibleTypes": nil,
+	})
+
 	res = append(res, map[string]interface{}{
 		"kind":          "INPUT_OBJECT",
 		"name":          "NodeTemplate",
@@ -459,6 +482,27 @@ func (rt *selectionSetRuntime) GetEliasDBTypesIntrospection() interface{} {
 	return res
 }
 
+/*
+scalarTypeName returns the name of the GraphQL scalar type for a given value
+type of a node attribute. Lists, maps and attributes with values of different
+types are represented as strings.
+*/
+func scalarTypeName(valueType string) string {
+	switch valueType {
+	case data.ValueTypeInt:
+		return "Int"
+	case data.ValueTypeFloat:
+		return "Float"
+	case data.ValueTypeBool:
+		return "Boolean"
+	case data.ValueTypeTimestamp:
+		return "Timestamp"
+	case data.ValueTypeBytes:
+		return "Bytes"
+	}
+	return "String"
+}
+
 /*
 GetStandardTypesIntrospection returns the standard types.
 */
@@ -13,8 +13,12 @@ package interpreter
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/krotik/common/lang/graphql/parser"
+	"github.com/krotik/eliasdb/graph"
+	"github.com/krotik/eliasdb/graph/data"
+	"github.com/krotik/eliasdb/graph/graphstorage"
 )
 
 func TestIntrospection(t *testing.T) {
@@ -265,3 +269,56 @@ fragment TypeRef on __Type {
 		return
 	}
 }
+
+func TestIntrospectionAttrTypes(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "Event")
+	node.SetAttr("count", 5)
+	node.SetAttr("ratio", 0.5)
+	node.SetAttr("active", true)
+	node.SetAttr("start", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	node.SetAttr("blob", []byte("abc"))
+	node.SetAttr("tags", []string{"a", "b"})
+	gm.StoreNode("main", node)
+
+	res, err := runQuery("test", "main", map[string]interface{}{
+		"operationName": nil,
+		"query": `
+{
+  __schema {
+    types {
+      name
+      fields {
+        name
+        type { name }
+      }
+    }
+  }
+}`,
+	}, gm, nil, false)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fieldTypes := make(map[string]interface{})
+
+	for _, typ := range res["data"].(map[string]interface{})["__schema"].(map[string]interface{})["types"].([]interface{}) {
+		if typ.(map[string]interface{})["name"] == "EventNode" {
+			for _, f := range typ.(map[string]interface{})["fields"].([]interface{}) {
+				fm := f.(map[string]interface{})
+				fieldTypes[fmt.Sprint(fm["name"])] = fm["type"].(map[string]interface{})["name"]
+			}
+		}
+	}
+
+	if res := fmt.Sprint(fieldTypes); res != "map[active:Boolean blob:Bytes count:Int "+
+		"key:String kind:String ratio:Float start:Timestamp tags:String]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,265 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"errors"
+	"sort"
+)
+
+/*
+ErrNotSorted is returned by the bulk loader if keys are not added in the order
+of their hash code.
+*/
+var ErrNotSorted = errors.New("Keys are not sorted by hash code")
+
+/*
+ErrTreeNotEmpty is returned if a bulk loader is created for a tree which
+already contains data.
+*/
+var ErrTreeNotEmpty = errors.New("Tree is not empty")
+
+/*
+HashKey returns the hash code of a given key in a tree with default options.
+The hash code determines the position of the key in the tree.
+*/
+func HashKey(key []byte) uint32 {
+	hash, _ := MurMurHashData(key, 0, len(key)-1, DefaultSeed)
+	return hash
+}
+
+/*
+SortByHash sorts a list of keys and a list of corresponding values by the hash
+codes of the keys in this tree. Keys with the same hash code keep their
+relative order.
+*/
+func (t *HTree) SortByHash(keys [][]byte, values []interface{}) {
+	sort.Stable(&hashSorter{t, keys, values, nil})
+}
+
+/*
+hashSorter data structure to sort keys and values by hash code
+*/
+type hashSorter struct {
+	tree   *HTree        // Tree which determines the hash codes
+	keys   [][]byte      // Keys to sort
+	values []interface{} // Values to sort
+	hashes []uint64      // Cached hash codes of the keys
+}
+
+/*
+Len returns the number of keys.
+*/
+func (s *hashSorter) Len() int {
+	return len(s.keys)
+}
+
+/*
+Less compares the hash codes of two keys.
+*/
+func (s *hashSorter) Less(i, j int) bool {
+	if s.hashes == nil {
+		s.hashes = make([]uint64, len(s.keys))
+		for k, key := range s.keys {
+			s.hashes[k] = s.tree.HashCode(key)
+		}
+	}
+	return s.hashes[i] < s.hashes[j]
+}
+
+/*
+Swap swaps two keys and their values.
+*/
+func (s *hashSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+	if s.hashes != nil {
+		s.hashes[i], s.hashes[j] = s.hashes[j], s.hashes[i]
+	}
+}
+
+/*
+HTreeBulkLoader data structure
+*/
+type HTreeBulkLoader struct {
+	tree     *HTree        // Tree which is loaded
+	hashByte uint32        // Hash code byte of the currently collected root child
+	lastHash uint64        // Hash code of the last added key
+	keys     [][]byte      // Collected keys for the current root child
+	values   []interface{} // Collected values for the current root child
+	count    int           // Number of added keys
+}
+
+/*
+NewHTreeBulkLoader creates a new bulk loader for an empty HTree. The bulk
+loader expects key / value pairs in the order of the hash codes of the keys
+(see HTree.SortByHash). Pages and buckets are built bottom-up and each
+node is written exactly once. Only the root page is updated when Finish is
+called. The tree must not be modified by other means until Finish was called.
+*/
+func NewHTreeBulkLoader(tree *HTree) (*HTreeBulkLoader, error) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	if !tree.Root.IsEmpty() {
+		return nil, ErrTreeNotEmpty
+	}
+
+	return &HTreeBulkLoader{tree, 0, 0, nil, nil, 0}, nil
+}
+
+/*
+Add adds a new key / value pair. Adding the same key more than once will
+overwrite the previous value. Adding a nil value is ignored.
+*/
+func (bl *HTreeBulkLoader) Add(key []byte, value interface{}) error {
+
+	if key == nil || value == nil {
+		return nil
+	}
+
+	hash := bl.tree.HashCode(key)
+
+	if bl.count > 0 && hash < bl.lastHash {
+		return ErrNotSorted
+	}
+
+	hashByte := bl.tree.Root.hashKey(key)
+
+	if len(bl.keys) > 0 && hashByte != bl.hashByte {
+
+		// All keys for the current child of the root page have been
+		// collected - write them out
+
+		if err := bl.flush(); err != nil {
+			return err
+		}
+	}
+
+	bl.hashByte = hashByte
+	bl.lastHash = hash
+	bl.keys = append(bl.keys, key)
+	bl.values = append(bl.values, value)
+	bl.count++
+
+	return nil
+}
+
+/*
+Finish writes all remaining key / value pairs and updates the root page of
+the tree.
+*/
+func (bl *HTreeBulkLoader) Finish() error {
+
+	if len(bl.keys) > 0 {
+		if err := bl.flush(); err != nil {
+			return err
+		}
+	}
+
+	bl.tree.mutex.Lock()
+	defer bl.tree.mutex.Unlock()
+
+	return bl.tree.Root.sm.Update(bl.tree.Root.loc, bl.tree.Root.htreeNode)
+}
+
+/*
+flush writes all collected key / value pairs as a child of the root page.
+*/
+func (bl *HTreeBulkLoader) flush() error {
+	root := bl.tree.Root
+
+	keys, values := uniqueKeys(bl.keys, bl.values)
+
+	loc, err := bl.writeNode(1, keys, values)
+	if err != nil {
+		return err
+	}
+
+	root.Children[bl.hashByte] = loc
+
+	bl.keys = nil
+	bl.values = nil
+
+	return nil
+}
+
+/*
+writeNode writes a list of key / value pairs as a subtree with a given depth.
+All children of a page are written before the page itself. Returns the
+storage location of the written node.
+*/
+func (bl *HTreeBulkLoader) writeNode(depth byte, keys [][]byte, values []interface{}) (uint64, error) {
+	sm := bl.tree.Root.sm
+
+	bucket := newHTreeBucket(bl.tree, depth)
+
+	if len(keys) <= MaxBucketElements || bucket.IsLeaf() {
+
+		// All pairs fit into a single bucket
+
+		for i, key := range keys {
+			bucket.Put(key, values[i])
+		}
+
+		return sm.Insert(bucket.htreeNode)
+	}
+
+	page := newHTreePage(bl.tree, depth)
+
+	// Keys are sorted by hash code so all keys for a child are next to
+	// each other
+
+	start := 0
+
+	for start < len(keys) {
+		hash := page.hashKey(keys[start])
+
+		end := start + 1
+		for end < len(keys) && page.hashKey(keys[end]) == hash {
+			end++
+		}
+
+		loc, err := bl.writeNode(depth+1, keys[start:end], values[start:end])
+		if err != nil {
+			return 0, err
+		}
+
+		page.Children[hash] = loc
+
+		start = end
+	}
+
+	return sm.Insert(page.htreeNode)
+}
+
+/*
+uniqueKeys removes duplicate keys from a list of key / value pairs. The last
+value of a duplicate key is kept at the position of the first occurrence.
+*/
+func uniqueKeys(keys [][]byte, values []interface{}) ([][]byte, []interface{}) {
+	pos := make(map[string]int, len(keys))
+	retKeys := make([][]byte, 0, len(keys))
+	retValues := make([]interface{}, 0, len(keys))
+
+	for i, key := range keys {
+		if p, ok := pos[string(key)]; ok {
+			retValues[p] = values[i]
+			continue
+		}
+
+		pos[string(key)] = len(retKeys)
+		retKeys = append(retKeys, key)
+		retValues = append(retValues, values[i])
+	}
+
+	return retKeys, retValues
+}
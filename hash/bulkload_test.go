@@ -0,0 +1,205 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestBulkLoad(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	tree, _ := NewHTree(sm)
+
+	var keys [][]byte
+	var values []interface{}
+
+	for i := 0; i < 10000; i++ {
+		keys = append(keys, []byte(fmt.Sprint("key", i)))
+		values = append(values, fmt.Sprint("value", i))
+	}
+
+	// Add a duplicate key - the last value should win
+
+	keys = append(keys, []byte("key5"))
+	values = append(values, "newvalue5")
+
+	tree.SortByHash(keys, values)
+
+	for i := 1; i < len(keys); i++ {
+		if HashKey(keys[i-1]) > HashKey(keys[i]) {
+			t.Error("Keys are not sorted:", string(keys[i-1]), string(keys[i]))
+			return
+		}
+	}
+
+	bl, err := NewHTreeBulkLoader(tree)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, key := range keys {
+		if err := bl.Add(key, values[i]); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := bl.Add(keys[0], nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := bl.Finish(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Check the tree
+
+	locs, problems := tree.Check()
+	if len(problems) != 0 || len(locs) != len(sm.Data) {
+		t.Error("Unexpected check result:", len(locs), len(sm.Data), problems)
+		return
+	}
+
+	for i := 0; i < 10000; i++ {
+		expected := fmt.Sprint("value", i)
+		if i == 5 {
+			expected = "newvalue5"
+		}
+
+		if res, err := tree.Get([]byte(fmt.Sprint("key", i))); res != expected || err != nil {
+			t.Error("Unexpected result:", res, err, "expected:", expected)
+			return
+		}
+	}
+
+	it := NewHTreeIterator(tree)
+	count := 0
+
+	for it.HasNext() {
+		it.Next()
+		count++
+	}
+
+	if count != 10000 || it.LastError != nil {
+		t.Error("Unexpected iterator result:", count, it.LastError)
+		return
+	}
+
+	// Compare the number of nodes with a tree which was build with single
+	// put operations
+
+	sm2 := storage.NewMemoryStorageManager("testsm2")
+
+	tree2, _ := NewHTree(sm2)
+
+	for i := 0; i < 10000; i++ {
+		tree2.Put([]byte(fmt.Sprint("key", i)), fmt.Sprint("value", i))
+	}
+
+	if len(sm.Data) != len(sm2.Data) {
+		t.Error("Unexpected number of nodes:", len(sm.Data), len(sm2.Data))
+		return
+	}
+
+	// The loaded tree can be used normally
+
+	if old, err := tree.Put([]byte("key5"), "value5"); old != "newvalue5" || err != nil {
+		t.Error("Unexpected result:", old, err)
+		return
+	}
+
+	if old, err := tree.Remove([]byte("key6")); old != "value6" || err != nil {
+		t.Error("Unexpected result:", old, err)
+		return
+	}
+
+	// Bulk loading is only possible for empty trees
+
+	if _, err := NewHTreeBulkLoader(tree); err != ErrTreeNotEmpty {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+	var err error
+
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	tree, _ := NewHTree(sm)
+
+	bl, _ := NewHTreeBulkLoader(tree)
+
+	key1, key2 := []byte("testkey1"), []byte("otherkey1")
+	if HashKey(key1) < HashKey(key2) {
+		key1, key2 = key2, key1
+	}
+
+	bl.Add(key1, "test")
+
+	if err := bl.Add(key2, "test"); err != ErrNotSorted {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Insert error when writing nodes
+
+	var keys [][]byte
+	var values []interface{}
+
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, []byte(fmt.Sprint("key", i)))
+		values = append(values, i)
+	}
+
+	tree.SortByHash(keys, values)
+
+	bl, _ = NewHTreeBulkLoader(tree)
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	for i, key := range keys {
+		if err = bl.Add(key, values[i]); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = bl.Finish()
+	}
+
+	if err == nil {
+		t.Error("Insert error expected")
+		return
+	}
+
+	sm.AccessMap = make(map[uint64]int)
+
+	// Update error when writing the root
+
+	bl, _ = NewHTreeBulkLoader(tree)
+
+	bl.Add([]byte("test"), "test")
+
+	sm.AccessMap[tree.Location()] = storage.AccessUpdateError
+
+	if err := bl.Finish(); err == nil {
+		t.Error("Update error expected")
+		return
+	}
+}
@@ -30,6 +30,24 @@ Entries in the HTree can be iterated by using an HTreeIterator. The HTree may
 change behind the iterator's back. The iterator will try to cope with best
 effort and only report an error as a last resort.
 
+An HTreeRangeIterator iterates only keys with a hash code in a given range.
+Keys are returned in the order of their hash code and the position of the
+iterator can be saved as a cursor token. This allows parallel and resumable
+scans of a tree.
+
+Custom trees
+
+A custom tree can be created with a custom seed for the hash function and up
+to MaxCustomTreeDepth non-leaf levels. Custom trees hash the complete key and
+use a 64 bit hash code if they have more than MaxTreeDepth non-leaf levels.
+The options of a tree are stored in its root page. The shape of a tree can be
+analysed with HTree.Stats.
+
+Bulk loading
+
+An empty HTree can be filled with an HTreeBulkLoader. The loader expects keys
+in the order of their hash codes and writes every page and bucket only once.
+
 Hash function
 
 The HTree uses an implementation of Austin Appleby's MurmurHash3 (32bit) function
@@ -68,12 +86,40 @@ is converted into a page except leaf buckets which grow indefinitely
 */
 const MaxBucketElements = 8
 
+/*
+MaxCustomTreeDepth is the maximum number of non-leaf levels of a custom tree.
+Custom trees with more than MaxTreeDepth non-leaf levels use a 64 bit hash code.
+*/
+const MaxCustomTreeDepth = 7
+
+/*
+DefaultSeed is the seed of the hash function of a default tree
+*/
+const DefaultSeed = 42
+
+/*
+ErrInvalidTreeDepth is returned if a custom tree should be created with an
+unsupported depth.
+*/
+var ErrInvalidTreeDepth = fmt.Errorf("Tree depth must be between %v and %v",
+	MaxTreeDepth, MaxCustomTreeDepth)
+
 /*
 HTree data structure
 */
 type HTree struct {
-	Root  *htreePage  // Root page of the HTree
-	mutex *sync.Mutex // Mutex to protect tree operations
+	Root     *htreePage  // Root page of the HTree
+	mutex    *sync.Mutex // Mutex to protect tree operations
+	seed     uint32      // Seed of the hash function (only used for custom trees)
+	maxDepth byte        // Maximum number of non-leaf levels (0 for a default tree)
+}
+
+/*
+HTreeOptions data structure - options for a custom tree
+*/
+type HTreeOptions struct {
+	Seed     uint32 // Seed of the hash function
+	MaxDepth byte   // Maximum number of non-leaf levels
 }
 
 /*
@@ -90,6 +136,8 @@ type htreeNode struct {
 	Keys       [][]byte      // Stored keys (only used for buckets)
 	Values     []interface{} // Stored values (only used for buckets)
 	BucketSize byte          // Bucket size (only used for buckets)
+	Seed       uint32        // Seed of the hash function (only used for the root of custom trees)
+	MaxDepth   byte          // Maximum number of non-leaf levels (only used for the root of custom trees)
 }
 
 /*
@@ -108,6 +156,8 @@ func (n *htreeNode) fetchNode(loc uint64) (*htreeNode, error) {
 		node = obj.(*htreeNode)
 	}
 
+	node.tree = n.tree
+
 	return node, nil
 }
 
@@ -138,6 +188,37 @@ func NewHTree(sm storage.Manager) (*HTree, error) {
 	return tree, nil
 }
 
+/*
+NewCustomHTree creates a new HTree with a custom seed for the hash function and
+a custom number of non-leaf levels. Custom trees hash the complete key while
+default trees ignore the last byte of a key. Trees with more levels can hold
+more keys before buckets grow beyond MaxBucketElements.
+*/
+func NewCustomHTree(sm storage.Manager, opts HTreeOptions) (*HTree, error) {
+
+	if opts.MaxDepth < MaxTreeDepth || opts.MaxDepth > MaxCustomTreeDepth {
+		return nil, ErrInvalidTreeDepth
+	}
+
+	tree := &HTree{seed: opts.Seed, maxDepth: opts.MaxDepth}
+
+	tree.Root = newHTreePage(tree, 0)
+	tree.Root.Seed = opts.Seed
+	tree.Root.MaxDepth = opts.MaxDepth
+
+	loc, err := sm.Insert(tree.Root.htreeNode)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Root.loc = loc
+	tree.Root.sm = sm
+
+	tree.mutex = &sync.Mutex{}
+
+	return tree, nil
+}
+
 /*
 LoadHTree fetches a HTree from storage
 */
@@ -155,14 +236,18 @@ func LoadHTree(sm storage.Manager, loc uint64) (*HTree, error) {
 		if err := sm.Fetch(loc, &res); err != nil {
 			return nil, err
 		}
-		tree = &HTree{&htreePage{&res}, nil}
+		tree = &HTree{Root: &htreePage{&res}}
 	} else {
-		tree = &HTree{&htreePage{obj.(*htreeNode)}, nil}
+		tree = &HTree{Root: &htreePage{obj.(*htreeNode)}}
 	}
 
+	tree.Root.tree = tree
 	tree.Root.loc = loc
 	tree.Root.sm = sm
 
+	tree.seed = tree.Root.Seed
+	tree.maxDepth = tree.Root.MaxDepth
+
 	tree.mutex = &sync.Mutex{}
 
 	return tree, nil
@@ -175,6 +260,63 @@ func (t *HTree) Location() uint64 {
 	return t.Root.loc
 }
 
+/*
+StorageManager returns the storage manager which stores the HTree.
+*/
+func (t *HTree) StorageManager() storage.Manager {
+	return t.Root.sm
+}
+
+/*
+Options returns the seed of the hash function and the maximum number of
+non-leaf levels of this tree.
+*/
+func (t *HTree) Options() HTreeOptions {
+	if t.maxDepth == 0 {
+		return HTreeOptions{DefaultSeed, MaxTreeDepth}
+	}
+	return HTreeOptions{t.seed, t.maxDepth}
+}
+
+/*
+MaxDepth returns the maximum number of non-leaf levels of this tree.
+*/
+func (t *HTree) MaxDepth() byte {
+	if t == nil || t.maxDepth == 0 {
+		return MaxTreeDepth
+	}
+	return t.maxDepth
+}
+
+/*
+HashCode returns the hash code of a given key in this tree. The most
+significant byte of the hash code determines the child of the root page. Each
+following byte determines the child on the next level. Default trees use only
+the upper 32 bits (see HashKey).
+*/
+func (t *HTree) HashCode(key []byte) uint64 {
+
+	if t == nil || t.maxDepth == 0 {
+		return uint64(HashKey(key)) << 32
+	}
+
+	// Hash the complete key - the data is padded since the hash function
+	// requires at least one more byte than the given size
+
+	data := make([]byte, len(key)+1)
+	copy(data, key)
+
+	h1, _ := MurMurHashData(data, 0, len(key), int(t.seed))
+
+	if t.maxDepth <= MaxTreeDepth {
+		return uint64(h1) << 32
+	}
+
+	h2, _ := MurMurHashData(data, 0, len(key), int(t.seed+1))
+
+	return uint64(h1)<<32 | uint64(h2)
+}
+
 /*
 Get gets a value for a given key.
 */
@@ -233,6 +375,63 @@ func (t *HTree) Remove(key []byte) (interface{}, error) {
 	return t.Root.Remove(key)
 }
 
+/*
+Check walks all pages and buckets of the tree and returns their storage
+locations. Nodes which cannot be fetched, nodes with an unexpected depth and
+nodes which are referenced more than once are reported as problems.
+*/
+func (t *HTree) Check() ([]uint64, []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	seen := map[uint64]bool{t.Root.loc: true}
+
+	return t.Root.check(seen, []uint64{t.Root.loc}, nil)
+}
+
+/*
+check checks all children of a page node.
+*/
+func (n *htreeNode) check(seen map[uint64]bool, locs []uint64, problems []string) ([]uint64, []string) {
+
+	for _, loc := range n.Children {
+
+		if loc == 0 {
+			continue
+		}
+
+		if seen[loc] {
+			problems = append(problems, fmt.Sprintf(
+				"HTree node %v is referenced more than once", loc))
+			continue
+		}
+
+		seen[loc] = true
+		locs = append(locs, loc)
+
+		child, err := n.fetchNode(loc)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"HTree node %v (child of %v) could not be fetched: %v", loc, n.loc, err))
+			continue
+		}
+
+		if child.Depth != n.Depth+1 {
+			problems = append(problems, fmt.Sprintf(
+				"HTree node %v has unexpected depth %v (expected %v)", loc, child.Depth, n.Depth+1))
+		}
+
+		if child.Children != nil {
+			child.loc = loc
+			child.sm = n.sm
+
+			locs, problems = child.check(seen, locs, problems)
+		}
+	}
+
+	return locs, problems
+}
+
 /*
 String returns a string representation of this tree.
 */
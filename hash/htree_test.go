@@ -60,6 +60,11 @@ func TestHTreeSerialization(t *testing.T) {
 
 	loc := htree.Location()
 
+	if htree.StorageManager() != sm {
+		t.Error("Unexpected storage manager:", htree.StorageManager())
+		return
+	}
+
 	htree.Put([]byte("test"), "testvalue1")
 
 	sm.Close()
@@ -180,3 +185,66 @@ func TestHTree(t *testing.T) {
 		return
 	}
 }
+
+func TestHTreeCheck(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	tree, err := NewHTree(sm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 1000; i++ {
+		tree.Put([]byte(fmt.Sprint("key", i)), fmt.Sprint("value", i))
+	}
+
+	locs, problems := tree.Check()
+	if len(problems) != 0 || len(locs) != len(sm.Data) || locs[0] != tree.Location() {
+		t.Error("Unexpected check result:", len(locs), len(sm.Data), problems)
+		return
+	}
+
+	// Find two children of the root page
+
+	var first, second int
+
+	for i, c := range tree.Root.Children {
+		if c != 0 {
+			if first == 0 {
+				first = i
+			} else {
+				second = i
+				break
+			}
+		}
+	}
+
+	// Simulate a fetch error and a node which is referenced twice
+
+	sm.AccessMap[tree.Root.Children[first]] = storage.AccessCacheAndFetchError
+
+	oldChild := tree.Root.Children[second]
+	tree.Root.Children[second] = tree.Root.Children[first]
+
+	_, problems = tree.Check()
+	if fmt.Sprint(problems) != fmt.Sprintf("[HTree node %v (child of %v) could not be fetched: "+
+		"Slot not found (testsm - Location:%v) HTree node %v is referenced more than once]",
+		tree.Root.Children[first], tree.Location(), tree.Root.Children[first], tree.Root.Children[first]) {
+		t.Error("Unexpected check result:", problems)
+		return
+	}
+
+	delete(sm.AccessMap, tree.Root.Children[first])
+	tree.Root.Children[second] = oldChild
+
+	// Simulate a node with a wrong depth
+
+	sm.Data[oldChild].(*htreeNode).Depth = 5
+
+	_, problems = tree.Check()
+	if len(problems) == 0 || problems[0] != fmt.Sprintf("HTree node %v has unexpected depth 5 (expected 1)", oldChild) {
+		t.Error("Unexpected check result:", problems)
+		return
+	}
+}
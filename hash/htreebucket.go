@@ -30,7 +30,7 @@ htreeBucket creates a new bucket for the HTree.
 func newHTreeBucket(tree *HTree, depth byte) *htreeBucket {
 	return &htreeBucket{&htreeNode{tree, 0, nil, depth, nil,
 		make([][]byte, MaxBucketElements),
-		make([]interface{}, MaxBucketElements), 0}}
+		make([]interface{}, MaxBucketElements), 0, 0, 0}}
 }
 
 /*
@@ -44,7 +44,7 @@ func (b *htreeBucket) Size() byte {
 IsLeaf returns if this bucket is a leaf node.
 */
 func (b *htreeBucket) IsLeaf() bool {
-	return b.Depth == MaxTreeDepth+1
+	return b.Depth == b.tree.MaxDepth()+1
 }
 
 /*
@@ -26,7 +26,7 @@ type htreePage struct {
 newHTreePage creates a new page for the HTree.
 */
 func newHTreePage(tree *HTree, depth byte) *htreePage {
-	return &htreePage{&htreeNode{tree, 0, nil, depth, make([]uint64, MaxPageChildren), nil, nil, 0}}
+	return &htreePage{&htreeNode{tree, 0, nil, depth, make([]uint64, MaxPageChildren), nil, nil, 0, 0, 0}}
 }
 
 /*
@@ -201,7 +201,7 @@ func (p *htreePage) Put(key []byte, value interface{}) (interface{}, error) {
 
 	// If the bucket is too full create a new directory
 
-	if p.Depth == MaxTreeDepth {
+	if p.Depth == p.tree.MaxDepth() {
 		panic("Max depth of HTree exceeded")
 	}
 
@@ -373,21 +373,11 @@ func (p *htreePage) String() string {
 hashKey calculates the hash code for a given key.
 */
 func (p *htreePage) hashKey(key []byte) uint32 {
-	var hash, hashMask uint32
 
-	// Calculate mask depending on page depth
-	// 0 masks out most significant bits while 2 masks out least significant bits
+	// Move the bits of the page level to the least significant position
+	// 0 uses the most significant bits while lower levels use less significant bits
 
-	hashMask = (MaxPageChildren - 1) << ((MaxTreeDepth - p.Depth) * PageLevelBits)
+	shift := 56 - uint(p.Depth)*PageLevelBits
 
-	// Calculate hash and apply mask
-
-	hash, _ = MurMurHashData(key, 0, len(key)-1, 42)
-	hash = hash & hashMask
-
-	// Move the bytes to the least significant position
-
-	hash = hash >> ((MaxTreeDepth - p.Depth) * PageLevelBits)
-
-	return hash % MaxPageChildren
+	return uint32(p.tree.HashCode(key)>>shift) % MaxPageChildren
 }
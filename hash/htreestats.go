@@ -0,0 +1,200 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+/*
+BucketInfo data structure - information about a single bucket
+*/
+type BucketInfo struct {
+	Location uint64 // Storage location of the bucket
+	Depth    byte   // Depth of the bucket
+	Size     int    // Number of elements in the bucket
+}
+
+/*
+HTreeStats data structure - statistics about the shape of a tree
+*/
+type HTreeStats struct {
+	Options        HTreeOptions  // Options of the tree
+	Pages          []int         // Number of pages per depth
+	Buckets        []int         // Number of buckets per depth
+	Elements       []int         // Number of elements per depth
+	BucketSizes    map[int]int   // Number of buckets per bucket size
+	LargestBuckets []*BucketInfo // Largest buckets of the tree (largest first)
+}
+
+/*
+TotalPages returns the total number of pages.
+*/
+func (s *HTreeStats) TotalPages() int {
+	return sum(s.Pages)
+}
+
+/*
+TotalBuckets returns the total number of buckets.
+*/
+func (s *HTreeStats) TotalBuckets() int {
+	return sum(s.Buckets)
+}
+
+/*
+TotalElements returns the total number of elements.
+*/
+func (s *HTreeStats) TotalElements() int {
+	return sum(s.Elements)
+}
+
+/*
+FillFactor returns the average number of elements per bucket in relation to
+MaxBucketElements. Values above 1 indicate leaf buckets which grew beyond
+MaxBucketElements because of hash collisions.
+*/
+func (s *HTreeStats) FillFactor() float64 {
+	if s.TotalBuckets() == 0 {
+		return 0
+	}
+	return float64(s.TotalElements()) / float64(s.TotalBuckets()*MaxBucketElements)
+}
+
+/*
+String returns a string representation of the statistics.
+*/
+func (s *HTreeStats) String() string {
+	buf := new(bytes.Buffer)
+
+	buf.WriteString(fmt.Sprintf("HTree stats (seed: %v, max depth: %v)\n",
+		s.Options.Seed, s.Options.MaxDepth))
+	buf.WriteString(fmt.Sprintf("  Pages: %v Buckets: %v Elements: %v Fill factor: %.2f\n",
+		s.TotalPages(), s.TotalBuckets(), s.TotalElements(), s.FillFactor()))
+
+	for i := range s.Pages {
+		buf.WriteString(fmt.Sprintf("  Depth %v: %v pages, %v buckets, %v elements\n",
+			i, s.Pages[i], s.Buckets[i], s.Elements[i]))
+	}
+
+	for _, b := range s.LargestBuckets {
+		buf.WriteString(fmt.Sprintf("  Bucket %v (depth: %v): %v elements\n",
+			b.Location, b.Depth, b.Size))
+	}
+
+	return buf.String()
+}
+
+/*
+sum returns the sum of all values of a list.
+*/
+func sum(l []int) int {
+	var ret int
+	for _, v := range l {
+		ret += v
+	}
+	return ret
+}
+
+/*
+Stats walks all pages and buckets of the tree and returns statistics about the
+shape of the tree. The statistics include the given number of largest buckets.
+*/
+func (t *HTree) Stats(largest int) (*HTreeStats, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	levels := int(t.MaxDepth()) + 2
+
+	stats := &HTreeStats{t.Options(), make([]int, levels), make([]int, levels),
+		make([]int, levels), make(map[int]int), nil}
+
+	stats.Pages[0] = 1
+
+	if err := t.Root.stats(stats, largest); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+/*
+stats collects statistics for all children of a page node.
+*/
+func (n *htreeNode) stats(stats *HTreeStats, largest int) error {
+
+	for _, loc := range n.Children {
+
+		if loc == 0 {
+			continue
+		}
+
+		child, err := n.fetchNode(loc)
+		if err != nil {
+			return err
+		}
+
+		if int(child.Depth) >= len(stats.Pages) {
+			return fmt.Errorf("HTree node %v has unexpected depth %v", loc, child.Depth)
+		}
+
+		if child.Children != nil {
+			child.loc = loc
+			child.sm = n.sm
+
+			stats.Pages[child.Depth]++
+
+			if err := child.stats(stats, largest); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// The size of leaf buckets with many elements might exceed the
+		// range of the bucket size counter
+
+		size := int(child.BucketSize)
+		if len(child.Keys) > MaxBucketElements {
+			size = len(child.Keys)
+		}
+
+		stats.Buckets[child.Depth]++
+		stats.Elements[child.Depth] += size
+		stats.BucketSizes[size]++
+
+		// Keep a sorted list of the largest buckets
+
+		if largest > 0 {
+			lb := stats.LargestBuckets
+
+			if len(lb) < largest || lb[len(lb)-1].Size < size {
+
+				i := sort.Search(len(lb), func(i int) bool {
+					return lb[i].Size < size
+				})
+
+				lb = append(lb, nil)
+				copy(lb[i+1:], lb[i:])
+				lb[i] = &BucketInfo{loc, child.Depth, size}
+
+				if len(lb) > largest {
+					lb = lb[:largest]
+				}
+
+				stats.LargestBuckets = lb
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestHTreeStats(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	tree, _ := NewHTree(sm)
+
+	stats, err := tree.Stats(3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := stats.String(); res != `HTree stats (seed: 42, max depth: 3)
+  Pages: 1 Buckets: 0 Elements: 0 Fill factor: 0.00
+  Depth 0: 1 pages, 0 buckets, 0 elements
+  Depth 1: 0 pages, 0 buckets, 0 elements
+  Depth 2: 0 pages, 0 buckets, 0 elements
+  Depth 3: 0 pages, 0 buckets, 0 elements
+  Depth 4: 0 pages, 0 buckets, 0 elements
+` {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Keys which differ only in their last character end up in the same
+	// leaf bucket of a default tree
+
+	for i := 0; i < 300; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%03d", i)), i)
+	}
+
+	if stats, err = tree.Stats(3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats.TotalPages() != len(sm.Data)-stats.TotalBuckets() || stats.TotalElements() != 300 {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	if len(stats.LargestBuckets) != 3 || stats.LargestBuckets[0].Size != 10 ||
+		stats.LargestBuckets[0].Depth != 4 || stats.Buckets[4] != 30 || stats.BucketSizes[10] != 30 {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	if f := stats.FillFactor(); f != 1.25 {
+		t.Error("Unexpected result:", f)
+		return
+	}
+
+	// Errors are reported
+
+	for _, c := range tree.Root.Children {
+		if c != 0 {
+			sm.AccessMap[c] = storage.AccessCacheAndFetchError
+		}
+	}
+
+	if _, err := tree.Stats(3); err == nil {
+		t.Error("Error expected")
+		return
+	}
+}
+
+func TestCustomHTree(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	if _, err := NewCustomHTree(sm, HTreeOptions{1, 2}); err != ErrInvalidTreeDepth {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewCustomHTree(sm, HTreeOptions{1, 8}); err != ErrInvalidTreeDepth {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sm.AccessMap[1] = storage.AccessInsertError
+
+	if _, err := NewCustomHTree(sm, HTreeOptions{1, 3}); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, 1)
+
+	tree, err := NewCustomHTree(sm, HTreeOptions{123, 5})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Custom trees hash the complete key
+
+	for i := 0; i < 300; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%03d", i)), i)
+	}
+
+	// Reload the tree and check the contents
+
+	tree2, err := LoadHTree(sm, tree.Location())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := tree2.Options(); res.Seed != 123 || res.MaxDepth != 5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for i := 0; i < 300; i++ {
+		if res, err := tree2.Get([]byte(fmt.Sprintf("key%03d", i))); res != i || err != nil {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	stats, _ := tree2.Stats(1)
+
+	if stats.Options.Seed != 123 || len(stats.Pages) != 7 || stats.LargestBuckets[0].Size > MaxBucketElements ||
+		stats.TotalElements() != 300 {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	if !strings.HasPrefix(stats.String(), "HTree stats (seed: 123, max depth: 5)") {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	locs, problems := tree2.Check()
+	if len(problems) != 0 || len(locs) != len(sm.Data) {
+		t.Error("Unexpected check result:", len(locs), len(sm.Data), problems)
+		return
+	}
+
+	// Range iterators and bulk loaders use the hash code of the tree
+
+	it := NewHTreeRangeIterator(tree2, SplitHashRange(1)[0])
+
+	count := 0
+	var last uint64
+
+	for it.HasNext() {
+		key, _ := it.Next()
+
+		if h := tree2.HashCode(key); h < last {
+			t.Error("Unexpected order:", string(key))
+			return
+		} else {
+			last = h
+		}
+
+		count++
+	}
+
+	if count != 300 {
+		t.Error("Unexpected result:", count)
+		return
+	}
+
+	for i := 0; i < 300; i += 2 {
+		tree2.Remove([]byte(fmt.Sprintf("key%03d", i)))
+	}
+
+	it = NewHTreeRangeIterator(tree2, SplitHashRange(1)[0])
+
+	count = 0
+	for it.HasNext() {
+		it.Next()
+		count++
+	}
+
+	if count != 150 {
+		t.Error("Unexpected result:", count)
+		return
+	}
+
+	tree3, _ := NewCustomHTree(sm, HTreeOptions{5, 7})
+
+	var keys [][]byte
+	var values []interface{}
+
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, []byte(fmt.Sprint("key", i)))
+		values = append(values, i)
+	}
+
+	tree3.SortByHash(keys, values)
+
+	bl, _ := NewHTreeBulkLoader(tree3)
+
+	for i, key := range keys {
+		if err := bl.Add(key, values[i]); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	bl.Finish()
+
+	for i := 0; i < 1000; i++ {
+		if res, err := tree3.Get([]byte(fmt.Sprint("key", i))); res != i || err != nil {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,368 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+ErrInvalidCursor is returned if a cursor token cannot be decoded.
+*/
+var ErrInvalidCursor = errors.New("Invalid cursor token")
+
+/*
+HashRange models an inclusive range of hash codes.
+*/
+type HashRange struct {
+	From uint32 // First hash code of the range
+	To   uint32 // Last hash code of the range
+}
+
+/*
+SplitHashRange splits the complete range of hash codes into a given number of
+ranges of roughly equal size.
+*/
+func SplitHashRange(parts int) []HashRange {
+	var ret []HashRange
+
+	if parts < 1 {
+		parts = 1
+	}
+
+	size := (uint64(math.MaxUint32) + 1) / uint64(parts)
+	from := uint64(0)
+
+	for i := 0; i < parts; i++ {
+		to := from + size - 1
+		if i == parts-1 {
+			to = math.MaxUint32
+		}
+
+		ret = append(ret, HashRange{uint32(from), uint32(to)})
+
+		from = to + 1
+	}
+
+	return ret
+}
+
+/*
+HTreeRangeIterator data structure
+*/
+type HTreeRangeIterator struct {
+	tree      *HTree        // Tree to iterate
+	from      uint32        // First hash code of the iterated range
+	to        uint32        // Last hash code of the iterated range
+	started   bool          // Flag if a key was read from the tree
+	lastHash  uint64        // Hash code of the last key which was read from the tree
+	lastKey   []byte        // Last key which was read from the tree
+	retHash   uint64        // Hash code of the last key which was returned
+	retKey    []byte        // Last key which was returned
+	keys      [][]byte      // Buffered keys of the current bucket
+	values    []interface{} // Buffered values of the current bucket
+	done      bool          // Flag if the end of the range was reached
+	LastError error         // Last encountered error
+}
+
+/*
+NewHTreeRangeIterator creates a new HTreeRangeIterator which iterates all keys
+of a tree with a hash code in a given range. The range applies to the upper 32
+bits of the hash code (see HTree.HashCode). In contrast to the HTreeIterator
+keys are returned in the order of their hash code (keys with the same hash code
+are returned in byte order). The position of the iterator can be saved with a
+cursor token at any time.
+*/
+func NewHTreeRangeIterator(tree *HTree, r HashRange) *HTreeRangeIterator {
+	it := &HTreeRangeIterator{tree: tree, from: r.From, to: r.To}
+
+	it.fill()
+
+	return it
+}
+
+/*
+NewHTreeRangeIteratorFromCursor creates a new HTreeRangeIterator which
+continues after the position of a given cursor token.
+*/
+func NewHTreeRangeIteratorFromCursor(tree *HTree, cursor string) (*HTreeRangeIterator, error) {
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) < 17 || data[0] > 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	it := &HTreeRangeIterator{tree: tree}
+
+	it.from = binary.BigEndian.Uint32(data[1:])
+	it.to = binary.BigEndian.Uint32(data[5:])
+
+	if data[0] == 2 {
+
+		// The iterator was already exhausted
+
+		it.done = true
+
+	} else if data[0] == 1 {
+		it.started = true
+		it.lastHash = binary.BigEndian.Uint64(data[9:])
+		it.lastKey = data[17:]
+		it.retHash = it.lastHash
+		it.retKey = it.lastKey
+	}
+
+	it.fill()
+
+	return it, nil
+}
+
+/*
+Cursor returns an opaque token which describes the position of the iterator.
+The token can be used to continue the iteration after the last returned key.
+*/
+func (it *HTreeRangeIterator) Cursor() string {
+	var state byte
+
+	if it.retKey != nil {
+		state = 1
+	}
+
+	if it.done && len(it.keys) == 0 && it.LastError == nil {
+		state = 2
+	}
+
+	data := make([]byte, 17, 17+len(it.retKey))
+
+	data[0] = state
+	binary.BigEndian.PutUint32(data[1:], it.from)
+	binary.BigEndian.PutUint32(data[5:], it.to)
+	binary.BigEndian.PutUint64(data[9:], it.retHash)
+	data = append(data, it.retKey...)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+/*
+HasNext returns if there is a next key / value pair.
+*/
+func (it *HTreeRangeIterator) HasNext() bool {
+	return len(it.keys) > 0
+}
+
+/*
+Next returns the next key / value pair.
+*/
+func (it *HTreeRangeIterator) Next() ([]byte, interface{}) {
+
+	if len(it.keys) == 0 {
+		if it.LastError == nil {
+			it.LastError = ErrNoMoreItems
+		}
+		return nil, nil
+	}
+
+	key := it.keys[0]
+	value := it.values[0]
+
+	it.keys = it.keys[1:]
+	it.values = it.values[1:]
+
+	it.retHash = it.tree.HashCode(key)
+	it.retKey = key
+
+	if len(it.keys) == 0 {
+		it.fill()
+	}
+
+	return key, value
+}
+
+/*
+fill buffers the next key / value pairs of the iterated range. All buffered
+pairs come from a single bucket. The tree might have changed significantly
+after the last call. The bucket which contains the next key is looked up from
+the root.
+*/
+func (it *HTreeRangeIterator) fill() {
+
+	if it.done {
+		return
+	}
+
+	it.tree.mutex.Lock()
+	defer it.tree.mutex.Unlock()
+
+	// Fetch the root page from storage - the tree might have been changed
+	// through another HTree object
+
+	root, err := it.tree.Root.fetchNode(it.tree.Root.loc)
+
+	found := false
+
+	if err == nil {
+		root.loc = it.tree.Root.loc
+		root.sm = it.tree.Root.sm
+
+		found, err = it.seek(root, 0, true)
+	}
+
+	if err != nil {
+
+		// There was a serious error terminate the iterator
+
+		it.LastError = err
+		it.done = true
+
+	} else if !found {
+		it.done = true
+
+	} else {
+		it.lastHash = it.tree.HashCode(it.keys[len(it.keys)-1])
+		it.lastKey = it.keys[len(it.keys)-1]
+		it.started = true
+	}
+}
+
+/*
+seek searches the children of a given page for the first bucket which contains
+keys after the current iterator position. The prefix is the hash code prefix
+of the page. The bound flag indicates if the page is on the path to the current
+position. Returns if keys were found.
+*/
+func (it *HTreeRangeIterator) seek(page *htreeNode, prefix uint64, bound bool) (bool, error) {
+
+	startHash := uint64(it.from) << 32
+	if it.started {
+		startHash = it.lastHash
+	}
+
+	shift := 56 - uint(page.Depth)*PageLevelBits
+
+	start := 0
+	if bound {
+		start = int((startHash >> shift) % MaxPageChildren)
+	}
+
+	for i := start; i < MaxPageChildren; i++ {
+
+		childPrefix := prefix | uint64(i)<<shift
+
+		if childPrefix>>32 > uint64(it.to) {
+
+			// All remaining children are outside of the range
+
+			return false, nil
+		}
+
+		loc := page.Children[i]
+
+		if loc == 0 {
+			continue
+		}
+
+		node, err := page.fetchNode(loc)
+		if err != nil {
+			return false, err
+		}
+
+		if node.Children != nil {
+
+			node.loc = loc
+			node.sm = page.sm
+
+			if found, err := it.seek(node, childPrefix, bound && i == start); found || err != nil {
+				return found, err
+			}
+
+			continue
+		}
+
+		if it.collect(node) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+collect buffers all key / value pairs of a bucket which are after the current
+iterator position and within the iterated range. Returns if keys were found.
+*/
+func (it *HTreeRangeIterator) collect(bucket *htreeNode) bool {
+	var keys [][]byte
+	var values []interface{}
+
+	for i := 0; i < int(bucket.BucketSize); i++ {
+		key := bucket.Keys[i]
+		hash := it.tree.HashCode(key)
+
+		if uint32(hash>>32) < it.from || uint32(hash>>32) > it.to {
+			continue
+		}
+
+		if it.started && (hash < it.lastHash ||
+			(hash == it.lastHash && bytes.Compare(key, it.lastKey) <= 0)) {
+			continue
+		}
+
+		keys = append(keys, key)
+		values = append(values, bucket.Values[i])
+	}
+
+	if len(keys) == 0 {
+		return false
+	}
+
+	// Sort the keys by hash code and key
+
+	sorter := &hashSorter{it.tree, keys, values, nil}
+	sort.Sort(&keySorter{sorter})
+
+	it.keys = keys
+	it.values = values
+
+	return true
+}
+
+/*
+keySorter data structure to sort keys by hash code and byte order
+*/
+type keySorter struct {
+	*hashSorter
+}
+
+/*
+Less compares the hash codes of two keys and the keys themselves if the hash
+codes are equal.
+*/
+func (s *keySorter) Less(i, j int) bool {
+	if s.hashSorter.Less(i, j) {
+		return true
+	} else if s.hashSorter.Less(j, i) {
+		return false
+	}
+	return bytes.Compare(s.keys[i], s.keys[j]) < 0
+}
+
+/*
+Return a string representation of the iterator.
+*/
+func (it *HTreeRangeIterator) String() string {
+	return fmt.Sprintf("HTree Range Iterator (tree: %v)\n  range: %08X - %08X\n  next: %v\n",
+		it.tree.Root.Location(), it.from, it.to, it.keys)
+}
@@ -0,0 +1,326 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage"
+)
+
+func TestSplitHashRange(t *testing.T) {
+
+	if res := fmt.Sprint(SplitHashRange(0)); res != "[{0 4294967295}]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(SplitHashRange(4)); res !=
+		"[{0 1073741823} {1073741824 2147483647} {2147483648 3221225471} {3221225472 4294967295}]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := SplitHashRange(7); res[6].To != math.MaxUint32 || res[1].From != res[0].To+1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestHTreeRangeIterator(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	tree, _ := NewHTree(sm)
+
+	// Test empty tree
+
+	it := NewHTreeRangeIterator(tree, SplitHashRange(1)[0])
+
+	if it.HasNext() {
+		t.Error("Empty tree should have no items")
+		return
+	}
+
+	if k, v := it.Next(); k != nil || v != nil || it.LastError != ErrNoMoreItems {
+		t.Error("Unexpected result:", k, v, it.LastError)
+		return
+	}
+
+	// Keys which differ only in their last character have the same hash code
+
+	for i := 0; i < 5000; i++ {
+		tree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	// Iterate the whole tree
+
+	checkOrder := func(it *HTreeRangeIterator, seen map[string]bool) bool {
+		var lastKey []byte
+
+		for it.HasNext() {
+			key, value := it.Next()
+
+			if fmt.Sprint("key", value) != string(key) {
+				t.Error("Unexpected value:", string(key), value)
+				return false
+			}
+
+			if seen[string(key)] {
+				t.Error("Key was seen before:", string(key))
+				return false
+			}
+
+			seen[string(key)] = true
+
+			if lastKey != nil && (HashKey(lastKey) > HashKey(key) ||
+				(HashKey(lastKey) == HashKey(key) && bytes.Compare(lastKey, key) >= 0)) {
+				t.Error("Unexpected order:", string(lastKey), string(key))
+				return false
+			}
+
+			lastKey = key
+		}
+
+		if it.LastError != nil {
+			t.Error("Unexpected error:", it.LastError)
+			return false
+		}
+
+		return true
+	}
+
+	seen := make(map[string]bool)
+
+	if !checkOrder(NewHTreeRangeIterator(tree, SplitHashRange(1)[0]), seen) || len(seen) != 5000 {
+		t.Error("Unexpected number of keys:", len(seen))
+		return
+	}
+
+	// Iterate the tree in parts
+
+	seen = make(map[string]bool)
+
+	for _, r := range SplitHashRange(7) {
+		it := NewHTreeRangeIterator(tree, r)
+
+		if !strings.HasPrefix(it.String(), "HTree Range Iterator (tree: 1)\n  range: ") {
+			t.Error("Unexpected result:", it.String())
+			return
+		}
+
+		if !checkOrder(it, seen) {
+			return
+		}
+	}
+
+	if len(seen) != 5000 {
+		t.Error("Unexpected number of keys:", len(seen))
+		return
+	}
+
+	// Test an empty range
+
+	it = NewHTreeRangeIterator(tree, HashRange{HashKey([]byte("key1")) + 1, HashKey([]byte("key1")) + 1})
+
+	if it.HasNext() {
+		t.Error("Unexpected result:", it)
+		return
+	}
+
+	// Test a range which contains only keys with the same hash code
+
+	it = NewHTreeRangeIterator(tree, HashRange{HashKey([]byte("key1")), HashKey([]byte("key1"))})
+
+	var res []string
+
+	for it.HasNext() {
+		key, _ := it.Next()
+		res = append(res, string(key))
+	}
+
+	if fmt.Sprint(res) != "[key0 key1 key2 key3 key4 key5 key6 key7 key8 key9]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestHTreeRangeIteratorCursor(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	tree, _ := NewHTree(sm)
+
+	for i := 0; i < 2000; i++ {
+		tree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	r := SplitHashRange(2)[1]
+
+	it := NewHTreeRangeIterator(tree, r)
+
+	// A cursor before the first item starts from the beginning
+
+	it2, err := NewHTreeRangeIteratorFromCursor(tree, it.Cursor())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if k1, k2 := it.keys[0], it2.keys[0]; !bytes.Equal(k1, k2) {
+		t.Error("Unexpected result:", string(k1), string(k2))
+		return
+	}
+
+	var read []string
+
+	for i := 0; i < 300; i++ {
+		key, _ := it.Next()
+		read = append(read, string(key))
+	}
+
+	cursor := it.Cursor()
+
+	var rest []string
+
+	for it.HasNext() {
+		key, _ := it.Next()
+		rest = append(rest, string(key))
+	}
+
+	// Resume the iteration from the cursor
+
+	it, err = NewHTreeRangeIteratorFromCursor(tree, cursor)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var resumed []string
+
+	for it.HasNext() {
+		key, _ := it.Next()
+		resumed = append(resumed, string(key))
+	}
+
+	if fmt.Sprint(resumed) != fmt.Sprint(rest) {
+		t.Error("Unexpected result:", resumed, "expected:", rest)
+		return
+	}
+
+	// A cursor of an exhausted iterator produces no more items
+
+	it, _ = NewHTreeRangeIteratorFromCursor(tree, it.Cursor())
+
+	if it.HasNext() {
+		t.Error("Unexpected result:", it)
+		return
+	}
+
+	// Change the tree and resume again - removed keys should not appear
+	// and new keys should appear
+
+	for _, key := range rest[:100] {
+		tree.Remove([]byte(key))
+	}
+
+	tree.Put([]byte("newkey"), 1)
+
+	it, _ = NewHTreeRangeIteratorFromCursor(tree, cursor)
+
+	count := 0
+	for it.HasNext() {
+		key, _ := it.Next()
+		count++
+
+		for _, r := range rest[:100] {
+			if r == string(key) {
+				t.Error("Removed key was returned:", r)
+				return
+			}
+		}
+
+		for _, r := range read {
+			if r == string(key) {
+				t.Error("Already read key was returned:", r)
+				return
+			}
+		}
+	}
+
+	expected := len(rest) - 100
+	if h := HashKey([]byte("newkey")); h >= r.From && h <= r.To &&
+		(h > HashKey([]byte(read[299])) ||
+			(h == HashKey([]byte(read[299])) && "newkey" > read[299])) {
+		expected++
+	}
+
+	if count != expected {
+		t.Error("Unexpected count:", count, expected)
+		return
+	}
+
+	// Test invalid cursors
+
+	if _, err := NewHTreeRangeIteratorFromCursor(tree, "abc"); err != ErrInvalidCursor {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewHTreeRangeIteratorFromCursor(tree, "!!!"); err != ErrInvalidCursor {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestHTreeRangeIteratorErrors(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	tree, _ := NewHTree(sm)
+
+	for i := 0; i < 100; i++ {
+		tree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	for loc := range sm.Data {
+		if loc != tree.Location() {
+			sm.AccessMap[loc] = storage.AccessCacheAndFetchError
+		}
+	}
+
+	it := NewHTreeRangeIterator(tree, SplitHashRange(1)[0])
+
+	if it.HasNext() || it.LastError == nil {
+		t.Error("Unexpected iterator state:", it.LastError)
+		return
+	}
+
+	// The cursor of a failed iterator allows a retry
+
+	cursor := it.Cursor()
+
+	sm.AccessMap = make(map[uint64]int)
+
+	it, _ = NewHTreeRangeIteratorFromCursor(tree, cursor)
+
+	count := 0
+	for it.HasNext() {
+		it.Next()
+		count++
+	}
+
+	if count != 100 || it.LastError != ErrNoMoreItems && it.LastError != nil {
+		t.Error("Unexpected result:", count, it.LastError)
+		return
+	}
+}
@@ -37,12 +37,15 @@ import (
 	"github.com/krotik/eliasdb/api"
 	"github.com/krotik/eliasdb/api/ac"
 	v1 "github.com/krotik/eliasdb/api/v1"
+	"github.com/krotik/eliasdb/btree"
 	"github.com/krotik/eliasdb/cluster"
 	"github.com/krotik/eliasdb/cluster/manager"
 	"github.com/krotik/eliasdb/config"
 	"github.com/krotik/eliasdb/ecal"
 	"github.com/krotik/eliasdb/graph"
 	"github.com/krotik/eliasdb/graph/graphstorage"
+	"github.com/krotik/eliasdb/storage"
+	"github.com/krotik/eliasdb/storage/file"
 )
 
 /*
@@ -105,8 +108,12 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 
 		loc := filepath.Join(basepath, config.Str(config.LocationDatastore))
 		readonly := config.Bool(config.EnableReadOnly)
+		replica := config.Bool(config.EnableReplica)
 
-		if readonly {
+		if replica {
+			print("Starting datastore (replica) in ", loc)
+			readonly = true
+		} else if readonly {
 			print("Starting datastore (readonly) in ", loc)
 		} else {
 			print("Starting datastore in ", loc)
@@ -116,11 +123,94 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 
 		ensurePath(loc)
 
-		gs, err = graphstorage.NewDiskGraphStorage(loc, readonly)
+		codec, err := storage.CodecMode(config.Str(config.StorageCodec))
 		if err != nil {
 			fatal(err)
 			return
 		}
+
+		if backend := config.Str(config.StorageBackend); backend == "btree" {
+
+			if config.StorageKey() != "" || config.Str(config.LocationLogArchive) != "" {
+				fatal("Encryption and log archives are not supported by the btree storage backend")
+				return
+			}
+
+			if replica {
+				fatal("Replicas are not supported by the btree storage backend")
+				return
+			}
+
+			print("Using btree storage backend")
+
+			store, err := btree.OpenStore(filepath.Join(loc, graphstorage.FilenameKVStore))
+			if err != nil {
+				fatal(err)
+				return
+			}
+
+			gs, err = graphstorage.NewKVGraphStorage(loc, store, readonly, codec)
+			if err != nil {
+				fatal(err)
+				return
+			}
+
+		} else if backend != "disk" {
+
+			fatal("Unknown storage backend: ", backend)
+			return
+
+		} else {
+
+			compression, err := storage.CompressionMode(config.Str(config.StorageCompression))
+			if err != nil {
+				fatal(err)
+				return
+			}
+
+			syncMode, err := file.SyncMode(config.Str(config.StorageSyncMode))
+			if err != nil {
+				fatal(err)
+				return
+			}
+
+			key, err := file.ParseEncryptionKey(config.StorageKey())
+			if err != nil {
+				fatal(err)
+				return
+			}
+
+			if key != nil {
+				print("Datastore files are encrypted")
+			}
+
+			options := storage.DiskStorageManagerOptions{
+				Format: storage.FormatOptions{Compression: compression, Codec: codec,
+					Checksums: config.Bool(config.StorageChecksums)},
+				EncryptionKey: key,
+				Read: storage.ReadOptions{MemoryMapped: config.Bool(config.StorageMemoryMapped),
+					CacheMaxBytes: config.Int(config.StorageCacheMaxBytes)},
+				Log: storage.LogOptions{SyncPolicy: file.SyncPolicy{Mode: syncMode,
+					WindowTime:  time.Duration(config.Int(config.StorageSyncWindowMs)) * time.Millisecond,
+					WindowBytes: config.Int(config.StorageSyncWindowBytes)}},
+				Replica: replica}
+
+			if replica && options.Read.MemoryMapped {
+				print("Ignoring StorageMemoryMapped setting for replica")
+				options.Read.MemoryMapped = false
+			}
+
+			if archive := config.Str(config.LocationLogArchive); archive != "" && !replica {
+				options.Log.Archive = filepath.Join(basepath, archive)
+				print("Archiving all transactions in ", options.Log.Archive)
+			}
+
+			gs, err = graphstorage.NewDiskGraphStorageWithOptions(loc, readonly, options)
+			if err != nil {
+				fatal(err)
+				return
+			}
+		}
 	}
 
 	// Check if clustering is enabled
@@ -197,6 +287,50 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 		os.RemoveAll(filepath.Join(basepath, config.Str(config.LockFile)))
 	}()
 
+	// Enable or disable the change log of a writable datastore
+
+	readonly := !config.Bool(config.MemoryOnlyStorage) &&
+		(config.Bool(config.EnableReadOnly) || config.Bool(config.EnableReplica))
+
+	if !readonly {
+
+		if config.Bool(config.EnableChangeLog) {
+
+			maxEntries := int(config.Int(config.ChangeLogMaxEntries))
+
+			print(fmt.Sprintf("Enabling change log (max entries: %v)", maxEntries))
+
+			if err := api.GM.EnableChangeLog(maxEntries); err != nil {
+				fatal(err)
+				return
+			}
+
+		} else if _, err := api.GM.DisableChangeLog(); err != nil {
+			fatal(err)
+			return
+		}
+	}
+
+	// Refresh a replica periodically with the changes of the writing process
+
+	if !config.Bool(config.MemoryOnlyStorage) && config.Bool(config.EnableReplica) {
+
+		refresh := time.Duration(config.Int(config.ReplicaRefreshMs)) * time.Millisecond
+
+		print(fmt.Sprintf("Refreshing replica every %v", refresh))
+
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+
+		go func() {
+			for range ticker.C {
+				if err := api.GM.Refresh(); err != nil {
+					print("Failed to refresh replica: ", err)
+				}
+			}
+		}()
+	}
+
 	// Create ScriptingInterpreter instance and run ECAL scripts
 
 	if config.Bool(config.EnableECALScripts) {
@@ -234,6 +368,7 @@ func StartServerWithSingleOp(singleOperation func(*graph.Manager) bool) {
 	api.APIHost = config.Str(config.HTTPSHost) + ":" + config.Str(config.HTTPSPort)
 	v1.ResultCacheMaxSize = uint64(config.Int(config.ResultCacheMaxSize))
 	v1.ResultCacheMaxAge = config.Int(config.ResultCacheMaxAgeSeconds)
+	v1.SnapshotDir = filepath.Join(basepath, config.Str(config.LocationSnapshots))
 
 	// Check if HTTPS key and certificate are in place
 
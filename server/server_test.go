@@ -307,6 +307,40 @@ func TestMainErrorCases(t *testing.T) {
 	printLog = []string{}
 	errorLog = []string{}
 
+	// Test replica of a non-existing datastore
+
+	config.Config[config.LocationDatastore] = config.DefaultConfig[config.LocationDatastore]
+	config.Config[config.EnableReplica] = true
+
+	runServer()
+
+	if len(errorLog) != 1 ||
+		!strings.Contains(errorLog[0], "Cannot open replica of non-existing graph storage") {
+		t.Error("Unexpected error:", errorLog)
+		return
+	}
+
+	printLog = []string{}
+	errorLog = []string{}
+
+	// Test replica with unsupported storage backend
+
+	config.Config[config.StorageBackend] = "btree"
+
+	runServer()
+
+	if len(errorLog) != 1 ||
+		!strings.Contains(errorLog[0], "Replicas are not supported by the btree storage backend") {
+		t.Error("Unexpected error:", errorLog)
+		return
+	}
+
+	config.Config[config.StorageBackend] = config.DefaultConfig[config.StorageBackend]
+	config.Config[config.EnableReplica] = false
+
+	printLog = []string{}
+	errorLog = []string{}
+
 	// Use memory only storage and the ignored readonly flag
 
 	config.Config[config.MemoryOnlyStorage] = true
@@ -333,6 +367,25 @@ func TestMainErrorCases(t *testing.T) {
 	printLog = []string{}
 	errorLog = []string{}
 
+	// Test invalid change log settings
+
+	config.Config[config.EnableChangeLog] = true
+	config.Config[config.ChangeLogMaxEntries] = -1
+
+	runServer()
+
+	if len(errorLog) != 1 ||
+		!strings.Contains(errorLog[0], "Maximum number of change log entries cannot be negative") {
+		t.Error("Unexpected error:", errorLog)
+		return
+	}
+
+	config.Config[config.EnableChangeLog] = config.DefaultConfig[config.EnableChangeLog]
+	config.Config[config.ChangeLogMaxEntries] = config.DefaultConfig[config.ChangeLogMaxEntries]
+
+	printLog = []string{}
+	errorLog = []string{}
+
 	// Special error when closing the store
 
 	graphstorage.MgsRetClose = errors.New("Testerror")
@@ -0,0 +1,742 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*
+Tags of the BinaryCodec - every value starts with a tag which describes how
+the following data should be read.
+*/
+const (
+	binaryTagNil    = 0x00 // No data
+	binaryTagFalse  = 0x01 // No data
+	binaryTagTrue   = 0x02 // No data
+	binaryTagInt    = 0x03 // Signed varint
+	binaryTagUint   = 0x04 // Unsigned varint
+	binaryTagFloat  = 0x05 // 8 bytes IEEE 754 (little endian)
+	binaryTagString = 0x06 // Length followed by UTF-8 data
+	binaryTagBytes  = 0x07 // Length followed by raw data
+	binaryTagArray  = 0x08 // Number of elements followed by all elements
+	binaryTagMap    = 0x09 // Number of entries followed by keys and values
+	binaryTagStruct = 0x0A // Number of fields followed by field names and values
+	binaryTagTyped  = 0x0B // Registered type name followed by a value
+)
+
+/*
+BinaryCodec is a schema-less binary codec. Every value is written as a one byte
+tag followed by its data. Integers are written as varints and structs are
+written with the names and values of their exported fields. Types which
+implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler are written
+as byte slices.
+
+Values in interfaces which do not have a default type (bool, int, uint64,
+float64, string, []byte, []interface{} and map[string]interface{}) are written
+together with the name of their type. These types must be registered with
+RegisterCodecType (similar to gob.Register).
+*/
+type BinaryCodec struct {
+}
+
+/*
+Encode writes the serialized form of a given object to a given writer.
+*/
+func (bc *BinaryCodec) Encode(w io.Writer, o interface{}) error {
+	e := &binaryEncoder{make([]byte, 0, 64), [binary.MaxVarintLen64]byte{}}
+
+	if err := e.encode(reflect.ValueOf(o)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(e.buf)
+
+	return err
+}
+
+/*
+Decode reads a serialized object from a given reader and writes it to a given
+data container. The data container must be a pointer.
+*/
+func (bc *BinaryCodec) Decode(r io.Reader, o interface{}) error {
+	v := reflect.ValueOf(o)
+
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Binary codec cannot decode into non-pointer %T", o)
+	}
+
+	br, ok := r.(binaryReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return (&binaryDecoder{br}).decode(v.Elem())
+}
+
+/*
+Registered types of the BinaryCodec
+*/
+var (
+	codecTypesLock = &sync.RWMutex{}
+	codecTypes     = make(map[string]reflect.Type)
+	codecTypeNames = make(map[reflect.Type]string)
+)
+
+/*
+Types which are used for values in interfaces if no type name was written
+*/
+var binaryDefaultTypes = map[byte]reflect.Type{
+	binaryTagFalse:  reflect.TypeOf(false),
+	binaryTagTrue:   reflect.TypeOf(false),
+	binaryTagInt:    reflect.TypeOf(int(0)),
+	binaryTagUint:   reflect.TypeOf(uint64(0)),
+	binaryTagFloat:  reflect.TypeOf(float64(0)),
+	binaryTagString: reflect.TypeOf(""),
+	binaryTagBytes:  reflect.TypeOf([]byte(nil)),
+	binaryTagArray:  reflect.TypeOf([]interface{}(nil)),
+	binaryTagMap:    reflect.TypeOf(map[string]interface{}(nil)),
+	binaryTagStruct: reflect.TypeOf(map[string]interface{}(nil)),
+}
+
+/*
+Reflection types of the binary marshaling interfaces
+*/
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+func init() {
+
+	// Register common types which are not default types
+
+	for _, v := range []interface{}{int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), float32(0), time.Time{},
+		[]string{}, []int{}, []uint64{}, []float64{}, [][]byte{},
+		map[string]string{}, map[string]int{}, map[string]float64{},
+		map[interface{}]interface{}{}} {
+
+		RegisterCodecType(v)
+	}
+}
+
+/*
+RegisterCodecType registers the type of a given value with the BinaryCodec.
+Types must be registered if their values are stored in interfaces.
+*/
+func RegisterCodecType(value interface{}) {
+	t := reflect.TypeOf(value)
+	name := codecTypeName(t)
+
+	codecTypesLock.Lock()
+	defer codecTypesLock.Unlock()
+
+	codecTypes[name] = t
+	codecTypeNames[t] = name
+}
+
+/*
+codecTypeName returns the name of a type which includes the package path of all
+named types.
+*/
+func codecTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		return "*" + codecTypeName(t.Elem())
+	} else if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+/*
+binaryEncoder writes values in the format of the BinaryCodec.
+*/
+type binaryEncoder struct {
+	buf     []byte                      // Written data
+	scratch [binary.MaxVarintLen64]byte // Buffer for varints
+}
+
+/*
+encode writes a given value.
+*/
+func (e *binaryEncoder) encode(v reflect.Value) error {
+
+	if !v.IsValid() {
+		e.buf = append(e.buf, binaryTagNil)
+		return nil
+	}
+
+	t := v.Type()
+
+	// Check if the value can serialize itself
+
+	if t.Implements(binaryMarshalerType) && (t.Kind() != reflect.Ptr || !v.IsNil()) {
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		e.writeBytes(binaryTagBytes, b)
+
+		return nil
+	}
+
+	switch t.Kind() {
+
+	case reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, binaryTagNil)
+			return nil
+		}
+		return e.encodeInterface(v.Elem())
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.buf = append(e.buf, binaryTagNil)
+			return nil
+		}
+		return e.encode(v.Elem())
+
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, binaryTagTrue)
+		} else {
+			e.buf = append(e.buf, binaryTagFalse)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := binary.PutVarint(e.scratch[:], v.Int())
+		e.buf = append(append(e.buf, binaryTagInt), e.scratch[:n]...)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.buf = append(e.buf, binaryTagUint)
+		e.writeUvarint(v.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		e.buf = append(e.buf, binaryTagFloat)
+		binary.LittleEndian.PutUint64(e.scratch[:8], math.Float64bits(v.Float()))
+		e.buf = append(e.buf, e.scratch[:8]...)
+
+	case reflect.String:
+		e.writeBytes(binaryTagString, []byte(v.String()))
+
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			e.buf = append(e.buf, binaryTagNil)
+			return nil
+		}
+
+		if t.Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeBytes(binaryTagBytes, b)
+			return nil
+		}
+
+		e.buf = append(e.buf, binaryTagArray)
+		e.writeUvarint(uint64(v.Len()))
+
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, binaryTagNil)
+			return nil
+		}
+
+		e.buf = append(e.buf, binaryTagMap)
+		e.writeUvarint(uint64(v.Len()))
+
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		var fields []int
+
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				fields = append(fields, i)
+			}
+		}
+
+		e.buf = append(e.buf, binaryTagStruct)
+		e.writeUvarint(uint64(len(fields)))
+
+		for _, i := range fields {
+			name := t.Field(i).Name
+
+			e.writeUvarint(uint64(len(name)))
+			e.buf = append(e.buf, name...)
+
+			if err := e.encode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("Binary codec cannot encode value of type %v", t)
+	}
+
+	return nil
+}
+
+/*
+encodeInterface writes a value which is stored in an interface. The name of
+the type is written as well if the value does not have a default type.
+*/
+func (e *binaryEncoder) encodeInterface(v reflect.Value) error {
+	t := v.Type()
+
+	for _, dt := range binaryDefaultTypes {
+		if t == dt {
+			return e.encode(v)
+		}
+	}
+
+	codecTypesLock.RLock()
+	name, ok := codecTypeNames[t]
+	codecTypesLock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("Binary codec type not registered: %v", t)
+	}
+
+	e.writeBytes(binaryTagTyped, []byte(name))
+
+	return e.encode(v)
+}
+
+/*
+writeBytes writes a tag followed by the length of a given byte slice and its
+content.
+*/
+func (e *binaryEncoder) writeBytes(tag byte, b []byte) {
+	e.buf = append(e.buf, tag)
+	e.writeUvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+/*
+writeUvarint writes an unsigned varint.
+*/
+func (e *binaryEncoder) writeUvarint(x uint64) {
+	n := binary.PutUvarint(e.scratch[:], x)
+	e.buf = append(e.buf, e.scratch[:n]...)
+}
+
+/*
+binaryReader is a reader which can also read single bytes.
+*/
+type binaryReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+/*
+binaryDecoder reads values in the format of the BinaryCodec.
+*/
+type binaryDecoder struct {
+	r binaryReader // Reader for serialized data
+}
+
+/*
+decode reads a value and stores it in a given settable value.
+*/
+func (d *binaryDecoder) decode(v reflect.Value) error {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	return d.decodeTagged(tag, v)
+}
+
+/*
+decodeTagged reads the data of a value with a given tag and stores it in a
+given settable value.
+*/
+func (d *binaryDecoder) decodeTagged(tag byte, v reflect.Value) error {
+	var err error
+
+	t := v.Type()
+
+	if tag == binaryTagNil {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+
+	// Check if the value can deserialize itself
+
+	if tag == binaryTagBytes && v.CanAddr() && v.Addr().Type().Implements(binaryUnmarshalerType) {
+		b, err := d.readBytes()
+		if err == nil {
+			err = v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+		}
+		return err
+	}
+
+	switch t.Kind() {
+
+	case reflect.Interface:
+		return d.decodeInterface(tag, v)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return d.decodeTagged(tag, v.Elem())
+	}
+
+	switch tag {
+
+	case binaryTagFalse, binaryTagTrue:
+		if t.Kind() != reflect.Bool {
+			return d.mismatch(tag, t)
+		}
+		v.SetBool(tag == binaryTagTrue)
+
+	case binaryTagInt, binaryTagUint:
+		var i int64
+		var u uint64
+
+		if tag == binaryTagInt {
+			i, err = binary.ReadVarint(d.r)
+			u = uint64(i)
+		} else {
+			u, err = binary.ReadUvarint(d.r)
+			i = int64(u)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			v.SetUint(u)
+		case reflect.Float32, reflect.Float64:
+			if tag == binaryTagInt {
+				v.SetFloat(float64(i))
+			} else {
+				v.SetFloat(float64(u))
+			}
+		default:
+			return d.mismatch(tag, t)
+		}
+
+	case binaryTagFloat:
+		var b [8]byte
+
+		if _, err := io.ReadFull(d.r, b[:]); err != nil {
+			return err
+		}
+
+		if t.Kind() != reflect.Float32 && t.Kind() != reflect.Float64 {
+			return d.mismatch(tag, t)
+		}
+
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b[:])))
+
+	case binaryTagString, binaryTagBytes:
+		b, err := d.readBytes()
+		if err != nil {
+			return err
+		}
+
+		if t.Kind() == reflect.String {
+			v.SetString(string(b))
+		} else if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			v.Set(reflect.ValueOf(b).Convert(t))
+		} else if t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8 {
+			reflect.Copy(v, reflect.ValueOf(b))
+		} else {
+			return d.mismatch(tag, t)
+		}
+
+	case binaryTagArray:
+		n, err := d.readLength()
+		if err != nil {
+			return err
+		}
+
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, n, n))
+		} else if t.Kind() != reflect.Array {
+			return d.mismatch(tag, t)
+		}
+
+		for i := 0; i < n; i++ {
+			if i < v.Len() {
+				err = d.decode(v.Index(i))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+	case binaryTagMap, binaryTagStruct:
+		return d.decodeMap(tag, v)
+
+	case binaryTagTyped:
+
+		// The type is given by the container
+
+		if _, err := d.readBytes(); err != nil {
+			return err
+		}
+
+		return d.decode(v)
+
+	default:
+		return fmt.Errorf("Binary codec found unknown tag: %v", tag)
+	}
+
+	return nil
+}
+
+/*
+decodeMap reads the data of a map or a struct and stores it in a given
+settable map or struct value.
+*/
+func (d *binaryDecoder) decodeMap(tag byte, v reflect.Value) error {
+	t := v.Type()
+
+	n, err := d.readLength()
+	if err != nil {
+		return err
+	}
+
+	if t.Kind() == reflect.Map {
+		v.Set(reflect.MakeMapWithSize(t, n))
+	} else if t.Kind() != reflect.Struct {
+		return d.mismatch(tag, t)
+	}
+
+	for i := 0; i < n; i++ {
+		key := reflect.New(reflect.TypeOf("")).Elem()
+
+		if tag == binaryTagStruct {
+			b, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			key.SetString(string(b))
+		}
+
+		if t.Kind() == reflect.Map {
+
+			if tag == binaryTagMap {
+				key = reflect.New(t.Key()).Elem()
+
+				if err := d.decode(key); err != nil {
+					return err
+				}
+
+			} else if !key.Type().AssignableTo(t.Key()) {
+				return d.mismatch(tag, t)
+			}
+
+			val := reflect.New(t.Elem()).Elem()
+
+			if err := d.decode(val); err != nil {
+				return err
+			}
+
+			v.SetMapIndex(key, val)
+
+			continue
+		}
+
+		// Fill the fields of a struct - unknown fields are skipped
+
+		if tag == binaryTagMap {
+			if err := d.decode(key); err != nil {
+				return err
+			}
+		}
+
+		if f, ok := t.FieldByName(key.String()); ok && f.PkgPath == "" {
+			err = d.decode(v.FieldByIndex(f.Index))
+		} else {
+			err = d.skip()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+decodeInterface reads the data of a value with a given tag and stores it in a
+given settable interface value.
+*/
+func (d *binaryDecoder) decodeInterface(tag byte, v reflect.Value) error {
+	var t reflect.Type
+
+	if tag == binaryTagTyped {
+		name, err := d.readBytes()
+		if err != nil {
+			return err
+		}
+
+		codecTypesLock.RLock()
+		rt, ok := codecTypes[string(name)]
+		codecTypesLock.RUnlock()
+
+		if !ok {
+			return fmt.Errorf("Binary codec type not registered: %s", name)
+		}
+
+		if tag, err = d.r.ReadByte(); err != nil {
+			return err
+		}
+
+		t = rt
+
+	} else if dt, ok := binaryDefaultTypes[tag]; ok {
+		t = dt
+
+	} else {
+		return fmt.Errorf("Binary codec found unknown tag: %v", tag)
+	}
+
+	if !t.AssignableTo(v.Type()) {
+		return d.mismatch(tag, v.Type())
+	}
+
+	val := reflect.New(t).Elem()
+
+	if err := d.decodeTagged(tag, val); err != nil {
+		return err
+	}
+
+	v.Set(val)
+
+	return nil
+}
+
+/*
+skip reads a value without storing it.
+*/
+func (d *binaryDecoder) skip() error {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch tag {
+
+	case binaryTagNil, binaryTagFalse, binaryTagTrue:
+
+	case binaryTagInt, binaryTagUint:
+		_, err = binary.ReadUvarint(d.r)
+
+	case binaryTagFloat:
+		_, err = io.ReadFull(d.r, make([]byte, 8))
+
+	case binaryTagString, binaryTagBytes:
+		_, err = d.readBytes()
+
+	case binaryTagArray, binaryTagMap, binaryTagStruct:
+		var n int
+
+		if n, err = d.readLength(); err == nil && tag != binaryTagArray {
+			n *= 2
+		}
+
+		for i := 0; i < n && err == nil; i++ {
+			if tag == binaryTagStruct && i%2 == 0 {
+				_, err = d.readBytes()
+			} else {
+				err = d.skip()
+			}
+		}
+
+	case binaryTagTyped:
+		if _, err = d.readBytes(); err == nil {
+			err = d.skip()
+		}
+
+	default:
+		err = fmt.Errorf("Binary codec found unknown tag: %v", tag)
+	}
+
+	return err
+}
+
+/*
+readLength reads a length or number of elements.
+*/
+func (d *binaryDecoder) readLength() (int, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every element needs at least one byte - guard against corrupted data
+
+	if l, ok := d.r.(interface{ Len() int }); ok && n > uint64(l.Len()) {
+		return 0, fmt.Errorf("Binary codec found invalid length: %v", n)
+	} else if n > math.MaxInt32 {
+		return 0, fmt.Errorf("Binary codec found invalid length: %v", n)
+	}
+
+	return int(n), nil
+}
+
+/*
+readBytes reads a byte slice which is prefixed with its length.
+*/
+func (d *binaryDecoder) readBytes() ([]byte, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, n)
+
+	_, err = io.ReadFull(d.r, b)
+
+	return b, err
+}
+
+/*
+mismatch returns an error for a value which cannot be stored in a given type.
+*/
+func (d *binaryDecoder) mismatch(tag byte, t reflect.Type) error {
+	return fmt.Errorf("Binary codec cannot decode value with tag %v into %v", tag, t)
+}
@@ -0,0 +1,216 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type binaryTestInner struct {
+	Name  string
+	Count int64
+}
+
+type binaryTestStruct struct {
+	Flag     bool
+	Small    int8
+	Big      uint64
+	Ratio    float32
+	Name     string
+	Data     []byte
+	Fixed    [3]byte
+	Numbers  [2]int
+	Keys     [][]byte
+	Values   []interface{}
+	Attrs    map[string]interface{}
+	Inner    *binaryTestInner
+	Missing  *binaryTestInner
+	Time     time.Time
+	internal string
+}
+
+func init() {
+	RegisterCodecType(&binaryTestInner{})
+}
+
+func TestBinaryCodec(t *testing.T) {
+	var buf bytes.Buffer
+	var res binaryTestStruct
+
+	bc := &BinaryCodec{}
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	obj := binaryTestStruct{true, -5, 1 << 60, 1.5, "test", []byte("abc"), [3]byte{1, 2, 3},
+		[2]int{-1, 1}, [][]byte{[]byte("a"), nil}, []interface{}{"a", 1, nil, int64(2),
+			uint64(3), 1.5, true, &binaryTestInner{"x", 1}, []string{"b"}},
+		map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"c"}},
+			"t": ts}, &binaryTestInner{"inner", 7}, nil, ts, "secret"}
+
+	if err := bc.Encode(&buf, obj); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := bc.Decode(&buf, &res); err != nil {
+		t.Error(err)
+		return
+	}
+
+	obj.internal = ""
+
+	if !reflect.DeepEqual(res, obj) {
+		t.Errorf("Unexpected result:\n%#v\n%#v", res, obj)
+		return
+	}
+
+	// Decoding into an interface restores the registered types
+
+	var ires interface{}
+
+	buf.Reset()
+	bc.Encode(&buf, []interface{}{&binaryTestInner{"x", 1}, int32(5)})
+
+	if err := bc.Decode(&buf, &ires); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if inner, ok := ires.([]interface{})[0].(*binaryTestInner); !ok || inner.Name != "x" ||
+		ires.([]interface{})[1] != int32(5) {
+		t.Error("Unexpected result:", ires)
+		return
+	}
+
+	// Structs without registered types are decoded as maps
+
+	buf.Reset()
+	bc.Encode(&buf, binaryTestInner{"x", 1})
+
+	if err := bc.Decode(&buf, &ires); err != nil ||
+		fmt.Sprint(ires) != "map[Count:1 Name:x]" {
+		t.Error("Unexpected result:", ires, err)
+		return
+	}
+
+	// Unknown fields are skipped and compatible numbers are converted
+
+	type other struct {
+		Count float64
+	}
+
+	var ores other
+
+	buf.Reset()
+	bc.Encode(&buf, obj)
+	bc.Encode(&buf, binaryTestInner{"x", 3})
+
+	if err := bc.Decode(&buf, &ores); err != nil || ores.Count != 0 {
+		t.Error("Unexpected result:", ores, err)
+		return
+	}
+
+	if err := bc.Decode(&buf, &ores); err != nil || ores.Count != 3 {
+		t.Error("Unexpected result:", ores, err)
+		return
+	}
+
+	// Readers which cannot read single bytes are supported
+
+	var sres string
+
+	buf.Reset()
+	bc.Encode(&buf, "test")
+
+	if err := bc.Decode(io.LimitReader(&buf, 100), &sres); err != nil || sres != "test" {
+		t.Error("Unexpected result:", sres, err)
+		return
+	}
+}
+
+func TestBinaryCodecErrors(t *testing.T) {
+	var buf bytes.Buffer
+	var res string
+
+	bc := &BinaryCodec{}
+
+	if err := bc.Encode(&buf, make(chan int)); err == nil ||
+		err.Error() != "Binary codec cannot encode value of type chan int" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	type unknown struct{}
+
+	if err := bc.Encode(&buf, []interface{}{unknown{}}); err == nil ||
+		err.Error() != "Binary codec type not registered: storage.unknown" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := bc.Decode(&buf, res); err == nil ||
+		err.Error() != "Binary codec cannot decode into non-pointer string" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	checkDecodeError := func(data []byte, o interface{}, expected string) {
+		t.Helper()
+
+		if err := bc.Decode(bytes.NewBuffer(data), o); err == nil || err.Error() != expected {
+			t.Error("Unexpected result:", err)
+		}
+	}
+
+	var i int
+	var b bool
+	var f float64
+	var s []string
+	var m map[string]string
+	var ires interface{}
+
+	checkDecodeError([]byte{binaryTagTrue}, &i, "Binary codec cannot decode value with tag 2 into int")
+	checkDecodeError([]byte{binaryTagInt, 0x02}, &b, "Binary codec cannot decode value with tag 3 into bool")
+	checkDecodeError([]byte{binaryTagFloat, 0, 0, 0, 0, 0, 0, 0, 0}, &i,
+		"Binary codec cannot decode value with tag 5 into int")
+	checkDecodeError([]byte{binaryTagString, 0x00}, &f, "Binary codec cannot decode value with tag 6 into float64")
+	checkDecodeError([]byte{binaryTagArray, 0x00}, &m, "Binary codec cannot decode value with tag 8 into map[string]string")
+	checkDecodeError([]byte{binaryTagMap, 0x00}, &s, "Binary codec cannot decode value with tag 9 into []string")
+	checkDecodeError([]byte{binaryTagStruct, 0x01, 0x01, 'a', binaryTagNil}, &map[int]int{},
+		"Binary codec cannot decode value with tag 10 into map[int]int")
+	checkDecodeError([]byte{0xFF}, &i, "Binary codec found unknown tag: 255")
+	checkDecodeError([]byte{0xFF}, &ires, "Binary codec found unknown tag: 255")
+	checkDecodeError([]byte{binaryTagArray, 0x01, 0xFF}, &[]interface{}{},
+		"Binary codec found unknown tag: 255")
+	checkDecodeError([]byte{binaryTagTyped, 0x03, 'f', 'o', 'o', binaryTagNil}, &ires,
+		"Binary codec type not registered: foo")
+	checkDecodeError([]byte{binaryTagTyped, 0x05, 'i', 'n', 't', '6', '4', binaryTagInt, 0x02}, &res,
+		"Binary codec cannot decode value with tag 3 into string")
+	checkDecodeError([]byte{binaryTagString, 0x10, 'a'}, &res, "Binary codec found invalid length: 16")
+	checkDecodeError([]byte{binaryTagString}, &res, "EOF")
+	checkDecodeError([]byte{}, &res, "EOF")
+
+	// Skipping of unknown fields
+
+	type empty struct{}
+
+	checkDecodeError([]byte{binaryTagStruct, 0x01, 0x01, 'a', 0xFF}, &empty{},
+		"Binary codec found unknown tag: 255")
+
+	checkDecodeError([]byte{binaryTagStruct, 0x01, 0x01, 'a', binaryTagArray, 0x01,
+		binaryTagTyped, 0x01, 'a', binaryTagStruct, 0x01, 0x01, 'b', binaryTagString, 0x05}, &empty{},
+		"Binary codec found invalid length: 5")
+}
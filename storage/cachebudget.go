@@ -0,0 +1,110 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+CacheBudget is a memory budget for cached objects which can be shared by
+several CachedDiskStorageManagers. The size of a cached object is its
+serialized size.
+*/
+type CacheBudget struct {
+	size    int64                       // Size of all cached objects in bytes (must be first for atomic access)
+	maxSize int64                       // Max size of all cached objects in bytes (0 for no limit)
+	mutex   *sync.Mutex                 // Mutex to protect the list of caches
+	caches  []*CachedDiskStorageManager // Caches which share this budget
+}
+
+/*
+NewCacheBudget creates a new memory budget with a given max size in bytes.
+A max size of 0 means that the size of the cached objects is not limited.
+*/
+func NewCacheBudget(maxSize int64) *CacheBudget {
+	return &CacheBudget{0, maxSize, &sync.Mutex{}, nil}
+}
+
+/*
+MaxSize returns the max size of all cached objects in bytes.
+*/
+func (cb *CacheBudget) MaxSize() int64 {
+	return cb.maxSize
+}
+
+/*
+Size returns the size of all cached objects in bytes.
+*/
+func (cb *CacheBudget) Size() int64 {
+	return atomic.LoadInt64(&cb.size)
+}
+
+/*
+add changes the size of all cached objects.
+*/
+func (cb *CacheBudget) add(diff int64) {
+	atomic.AddInt64(&cb.size, diff)
+}
+
+/*
+exceeded checks if the budget would be exceeded by an additional object of a
+given size.
+*/
+func (cb *CacheBudget) exceeded(size int64) bool {
+	return cb.maxSize > 0 && cb.Size()+size > cb.maxSize
+}
+
+/*
+register adds a cache to the list of caches which share this budget.
+*/
+func (cb *CacheBudget) register(cdsm *CachedDiskStorageManager) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.caches = append(cb.caches, cdsm)
+}
+
+/*
+unregister removes a cache from the list of caches which share this budget.
+*/
+func (cb *CacheBudget) unregister(cdsm *CachedDiskStorageManager) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	for i, c := range cb.caches {
+		if c == cdsm {
+			cb.caches = append(cb.caches[:i], cb.caches[i+1:]...)
+			break
+		}
+	}
+}
+
+/*
+reclaim evicts the oldest objects of all caches (except a given one) until the
+budget is no longer exceeded. Objects are evicted from the caches in turn. The
+caller must not hold the lock of any cache.
+*/
+func (cb *CacheBudget) reclaim(except *CachedDiskStorageManager) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	for evicted := true; evicted && cb.exceeded(0); {
+		evicted = false
+
+		for _, cdsm := range cb.caches {
+			if cdsm != except && cb.exceeded(0) && cdsm.evictOldest() {
+				evicted = true
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCacheBudget(t *testing.T) {
+	var ret string
+
+	budget := NewCacheBudget(3000)
+
+	if budget.MaxSize() != 3000 || budget.Size() != 0 {
+		t.Error("Unexpected budget:", budget.MaxSize(), budget.Size())
+		return
+	}
+
+	cdsm1 := NewCachedDiskStorageManagerWithBudget(
+		NewDiskStorageManager(DBDIR+"/budget1", false, false, true, true), 100, budget)
+	cdsm2 := NewCachedDiskStorageManagerWithBudget(
+		NewDiskStorageManager(DBDIR+"/budget2", false, false, true, true), 100, budget)
+
+	data := strings.Repeat("x", 1000)
+
+	loc1, _ := cdsm1.Insert(data)
+	loc2, _ := cdsm1.Insert(data)
+
+	if stats := cdsm1.CacheStats(); stats.Objects != 2 || stats.Size < 2000 ||
+		stats.Size != budget.Size() || stats.Evictions != 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// The budget is shared - a new object in the second cache evicts the
+	// oldest objects of the other caches if necessary
+
+	loc3, _ := cdsm2.Insert(data)
+
+	if stats := cdsm1.CacheStats(); stats.Objects != 1 || stats.Evictions != 1 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if _, err := cdsm1.FetchCached(loc1); err == nil {
+		t.Error("Object should have been evicted")
+		return
+	}
+
+	// Objects of the cache itself are evicted first
+
+	cdsm2.Insert(data)
+
+	if stats := cdsm2.CacheStats(); stats.Objects != 1 || stats.Evictions != 1 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if _, err := cdsm2.FetchCached(loc3); err == nil {
+		t.Error("Object should have been evicted")
+		return
+	}
+
+	// Objects which exceed the budget are not cached
+
+	loc4, _ := cdsm1.Insert(strings.Repeat("x", 4000))
+
+	if _, err := cdsm1.FetchCached(loc4); err == nil {
+		t.Error("Object should not be cached")
+		return
+	}
+
+	if obj, err := cdsm1.FetchCached(loc2); err != nil || obj != data {
+		t.Error("Unexpected result:", obj, err)
+		return
+	}
+
+	if stats := cdsm1.CacheStats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Growing objects evict other objects but never themselves
+
+	cdsm1.Update(loc2, strings.Repeat("x", 2500))
+
+	if stats := cdsm1.CacheStats(); stats.Objects != 1 || stats.Evictions != 1 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if stats := cdsm2.CacheStats(); stats.Objects != 0 || stats.Evictions != 2 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if err := cdsm1.Fetch(loc1, &ret); err != nil || ret != data {
+		t.Error("Unexpected result:", ret, err)
+		return
+	}
+
+	if stats := cdsm1.CacheStats(); stats.Objects != 1 || stats.Evictions != 2 ||
+		budget.Size() > budget.MaxSize() {
+		t.Error("Unexpected stats:", stats, budget.Size())
+		return
+	}
+
+	// Freed objects are removed from the budget
+
+	cdsm1.Free(loc1)
+	cdsm1.Free(loc2)
+
+	if stats := cdsm1.CacheStats(); stats.Objects != 0 || stats.Size != 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if budget.Size() != 0 {
+		t.Error("Unexpected budget size:", budget.Size())
+		return
+	}
+
+	var stats CacheStats
+
+	stats.Add(cdsm1.CacheStats())
+	stats.Add(cdsm2.CacheStats())
+
+	if stats.Objects != 0 || stats.Evictions != 4 || stats.Hits != 1 || stats.Misses != 3 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Closed caches release their memory and leave the budget
+
+	cdsm2.Insert(data)
+
+	if budget.Size() == 0 || len(budget.caches) != 2 {
+		t.Error("Unexpected budget:", budget.Size(), budget.caches)
+		return
+	}
+
+	cdsm2.Close()
+
+	if budget.Size() != 0 || len(budget.caches) != 1 {
+		t.Error("Unexpected budget:", budget.Size(), budget.caches)
+		return
+	}
+
+	cdsm1.Close()
+}
@@ -11,7 +11,7 @@
 /*
 Package storage contains the low-level API for data storage. Data is stored
 in slots. The interface defines methods to store, retrieve, update and delete
-a given object to and from the disk. There are 3 main implementations:
+a given object to and from the disk. There are 4 main implementations:
 
 DiskStorageManager
 
@@ -21,24 +21,69 @@ involved files and ensures exclusive access to them through a generated lock
 file. The lockfile is checked and attempting to open another instance of the
 DiskStorageManager on the same files will result in an error. The DiskStorageManager
 is also responsible for marshalling given abstract objects into a binary form which
-can be written to physical slots.
+can be written to physical slots. New datastores can optionally compress all written
+data. The compression mode is stored in the header of the physical slots file.
 
 CachedDiskStorageManager
 
 The CachedDiskStorageManager is a cache wrapper for the DiskStorageManager. Its
 purpose is to intercept calls and to maintain a cache of stored objects. The cache
-is limited in size by the number of total objects it references. Once the cache
-is full it will forget the objects which have been requested the least.
+is limited in size by the number of total objects it references and optionally by
+a memory budget (CacheBudget) which can be shared by several caches. The size of
+an object is its serialized size. Once the cache is full it will forget the
+objects which have been requested the least. If a shared memory budget is still
+exceeded then the oldest objects of the other caches are forgotten as well.
+
+VersionedStorageManager
+
+The VersionedStorageManager is a wrapper for the CachedDiskStorageManager which
+provides read-only snapshot views. While snapshots are open the stored form of
+an object is kept in memory before the object is changed for the first time
+after a snapshot was opened (copy-on-write). Readers of a snapshot see the
+objects as they were when the snapshot was opened. A VersionTracker manages
+the versions and open snapshots of a group of VersionedStorageManagers.
 
 MemoryStorageManager
 
 A storage manager which keeps all its data in memory and provides several
 error simulation facilities.
+
+Large objects
+
+Very large data can be stored as a large object in any storage manager. A large
+object is split into chunks which are stored as individual objects. A header
+object holds the locations of all chunks. Large objects are written with a
+LargeObjectWriter and read with a LargeObjectReader so the data never needs to
+be held completely in memory. Chunks are not added to the cache of a storage
+manager and a writer can flush the storage manager periodically while it
+writes.
 */
 package storage
 
 import "sync"
 
+/*
+CacheStats contains statistics of a cache.
+*/
+type CacheStats struct {
+	Objects   int    // Number of cached objects
+	Size      int64  // Serialized size of all cached objects in bytes
+	Hits      uint64 // Number of lookups which were answered from the cache
+	Misses    uint64 // Number of lookups which could not be answered from the cache
+	Evictions uint64 // Number of objects which were removed to make room for other objects
+}
+
+/*
+Add adds the values of other cache statistics.
+*/
+func (cs *CacheStats) Add(other CacheStats) {
+	cs.Objects += other.Objects
+	cs.Size += other.Size
+	cs.Hits += other.Hits
+	cs.Misses += other.Misses
+	cs.Evictions += other.Evictions
+}
+
 /*
 CachedDiskStorageManager data structure
 */
@@ -49,6 +94,8 @@ type CachedDiskStorageManager struct {
 	maxObjects         int                    // Max number of objects which should be held in the cache
 	firstentry         *cacheEntry            // Pointer to first entry in cacheEntry linked list
 	lastentry          *cacheEntry            // Pointer to last entry in cacheEntry linked list
+	budget             *CacheBudget           // Memory budget of the cache (nil if there is no budget)
+	stats              CacheStats             // Statistics of the cache
 }
 
 /*
@@ -57,6 +104,7 @@ cacheEntry data structure
 type cacheEntry struct {
 	location uint64      // Slot (logical) of the entry
 	object   interface{} // Object of the entry
+	size     int         // Serialized size of the object
 	prev     *cacheEntry // Pointer to previous entry in cacheEntry linked list
 	next     *cacheEntry // Pointer to next entry in cacheEntry linked list
 }
@@ -70,8 +118,45 @@ var entryPool = &sync.Pool{New: func() interface{} { return &cacheEntry{} }}
 NewCachedDiskStorageManager creates a new cache wrapper for a DiskStorageManger.
 */
 func NewCachedDiskStorageManager(diskstoragemanager *DiskStorageManager, maxObjects int) *CachedDiskStorageManager {
-	return &CachedDiskStorageManager{diskstoragemanager, &sync.Mutex{}, make(map[uint64]*cacheEntry),
-		maxObjects, nil, nil}
+	return NewCachedDiskStorageManagerWithBudget(diskstoragemanager, maxObjects, nil)
+}
+
+/*
+NewCachedDiskStorageManagerWithBudget creates a new cache wrapper for a
+DiskStorageManger which is additionally limited by a given memory budget.
+The same budget can be used by several caches.
+*/
+func NewCachedDiskStorageManagerWithBudget(diskstoragemanager *DiskStorageManager,
+	maxObjects int, budget *CacheBudget) *CachedDiskStorageManager {
+
+	cdsm := &CachedDiskStorageManager{diskstoragemanager, &sync.Mutex{}, make(map[uint64]*cacheEntry),
+		maxObjects, nil, nil, budget, CacheStats{}}
+
+	if budget != nil {
+		budget.register(cdsm)
+	}
+
+	return cdsm
+}
+
+/*
+DiskStorageManager returns the wrapped DiskStorageManager.
+*/
+func (cdsm *CachedDiskStorageManager) DiskStorageManager() *DiskStorageManager {
+	return cdsm.diskstoragemanager
+}
+
+/*
+CacheStats returns the current statistics of the cache.
+*/
+func (cdsm *CachedDiskStorageManager) CacheStats() CacheStats {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	ret := cdsm.stats
+	ret.Objects = len(cdsm.cache)
+
+	return ret
 }
 
 /*
@@ -102,38 +187,59 @@ func (cdsm *CachedDiskStorageManager) Insert(o interface{}) (uint64, error) {
 
 	// Cannot cache inserts since the calling code needs a location
 
-	loc, err := cdsm.diskstoragemanager.Insert(o)
+	loc, size, err := cdsm.diskstoragemanager.insert(o)
 
 	if loc != 0 && err == nil {
 
+		// Objects of other caches are evicted once the lock is released
+
+		defer cdsm.reclaimBudget()
+
 		cdsm.mutex.Lock()
 		defer cdsm.mutex.Unlock()
 
-		cdsm.addToCache(loc, o)
+		cdsm.addToCache(loc, o, size)
 	}
 
 	return loc, err
 }
 
+/*
+InsertUncached inserts an object without adding it to the cache and returns
+its storage location. This is used for objects which are unlikely to be read
+again soon (e.g. chunks of large objects).
+*/
+func (cdsm *CachedDiskStorageManager) InsertUncached(o interface{}) (uint64, error) {
+
+	loc, _, err := cdsm.diskstoragemanager.insert(o)
+
+	return loc, err
+}
+
 /*
 Update updates a storage location.
 */
 func (cdsm *CachedDiskStorageManager) Update(loc uint64, o interface{}) error {
 
+	size, err := cdsm.diskstoragemanager.update(loc, o)
+
 	// Store the update in the cache
 
+	defer cdsm.reclaimBudget()
+
 	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
 
 	if entry, ok := cdsm.cache[loc]; !ok {
-		cdsm.addToCache(loc, o)
+		cdsm.addToCache(loc, o, size)
 	} else {
 		entry.object = o
+		cdsm.resizeEntry(entry, size)
 		cdsm.llTouchEntry(entry)
+		cdsm.evictOverBudget(0, entry)
 	}
 
-	cdsm.mutex.Unlock()
-
-	return cdsm.diskstoragemanager.Update(loc, o)
+	return err
 }
 
 /*
@@ -153,6 +259,7 @@ func (cdsm *CachedDiskStorageManager) Free(loc uint64) error {
 	if entry, ok := cdsm.cache[loc]; ok {
 		delete(cdsm.cache, entry.location)
 		cdsm.llRemoveEntry(entry)
+		cdsm.resizeEntry(entry, 0)
 	}
 
 	return nil
@@ -164,18 +271,20 @@ a given data container.
 */
 func (cdsm *CachedDiskStorageManager) Fetch(loc uint64, o interface{}) error {
 
-	err := cdsm.diskstoragemanager.Fetch(loc, o)
+	size, err := cdsm.diskstoragemanager.fetch(loc, o)
 	if err != nil {
 		return err
 	}
 
+	defer cdsm.reclaimBudget()
+
 	cdsm.mutex.Lock()
 	defer cdsm.mutex.Unlock()
 
 	// Put the retrieved value into the cache
 
 	if entry, ok := cdsm.cache[loc]; !ok {
-		cdsm.addToCache(loc, o)
+		cdsm.addToCache(loc, o, size)
 	} else {
 		cdsm.llTouchEntry(entry)
 	}
@@ -193,9 +302,12 @@ func (cdsm *CachedDiskStorageManager) FetchCached(loc uint64) (interface{}, erro
 	defer cdsm.mutex.Unlock()
 
 	if entry, ok := cdsm.cache[loc]; ok {
+		cdsm.stats.Hits++
 		return entry.object, nil
 	}
 
+	cdsm.stats.Misses++
+
 	return nil, NewStorageManagerError(ErrNotInCache, "", cdsm.Name())
 }
 
@@ -210,22 +322,52 @@ func (cdsm *CachedDiskStorageManager) Rollback() error {
 
 	err := cdsm.diskstoragemanager.Rollback()
 
+	// Cache is emptied in any case
+
+	cdsm.clearCache()
+
+	return err
+}
+
+/*
+clearCache removes all objects from the cache.
+*/
+func (cdsm *CachedDiskStorageManager) clearCache() {
 	cdsm.mutex.Lock()
 	defer cdsm.mutex.Unlock()
 
-	// Cache is emptied in any case
-
 	cdsm.cache = make(map[uint64]*cacheEntry)
 	cdsm.firstentry = nil
 	cdsm.lastentry = nil
 
-	return err
+	if cdsm.budget != nil {
+		cdsm.budget.add(-cdsm.stats.Size)
+	}
+	cdsm.stats.Size = 0
 }
 
 /*
 Close the StorageManager and write all pending changes to disk.
 */
 func (cdsm *CachedDiskStorageManager) Close() error {
+
+	if cdsm.budget != nil {
+		cdsm.budget.unregister(cdsm)
+
+		// Release the memory of all cached objects
+
+		cdsm.mutex.Lock()
+
+		cdsm.cache = make(map[uint64]*cacheEntry)
+		cdsm.firstentry = nil
+		cdsm.lastentry = nil
+
+		cdsm.budget.add(-cdsm.stats.Size)
+		cdsm.stats.Size = 0
+
+		cdsm.mutex.Unlock()
+	}
+
 	return cdsm.diskstoragemanager.Close()
 }
 
@@ -239,10 +381,16 @@ func (cdsm *CachedDiskStorageManager) Flush() error {
 /*
 addToCache adds an entry to the cache.
 */
-func (cdsm *CachedDiskStorageManager) addToCache(loc uint64, o interface{}) {
+func (cdsm *CachedDiskStorageManager) addToCache(loc uint64, o interface{}, size int) {
 
 	var entry *cacheEntry
 
+	// Objects which are bigger than the whole memory budget are not cached
+
+	if cdsm.budget != nil && cdsm.budget.maxSize > 0 && int64(size) > cdsm.budget.maxSize {
+		return
+	}
+
 	// Get an entry from the pool or recycle an entry from the cacheEntry
 	// linked list if the list is full
 
@@ -252,10 +400,15 @@ func (cdsm *CachedDiskStorageManager) addToCache(loc uint64, o interface{}) {
 		entry = entryPool.Get().(*cacheEntry)
 	}
 
+	cdsm.evictOverBudget(size, nil)
+
 	// Fill the entry
 
 	entry.location = loc
 	entry.object = o
+	entry.size = 0
+
+	cdsm.resizeEntry(entry, size)
 
 	// Insert entry into the cacheEntry linked list (this will set the entries
 	// prev and next pointer)
@@ -288,9 +441,68 @@ func (cdsm *CachedDiskStorageManager) removeOldestFromCache() *cacheEntry {
 
 	delete(cdsm.cache, entry.location)
 
+	cdsm.resizeEntry(entry, 0)
+	cdsm.stats.Evictions++
+
 	return entry
 }
 
+/*
+evictOverBudget removes the oldest entries from the cache until an object of a
+given size fits into the memory budget. Only entries of this cache are removed.
+An optional given entry is kept in the cache.
+*/
+func (cdsm *CachedDiskStorageManager) evictOverBudget(size int, keep *cacheEntry) {
+
+	for cdsm.budget != nil && cdsm.budget.exceeded(int64(size)) &&
+		cdsm.firstentry != nil && cdsm.firstentry != keep {
+
+		entryPool.Put(cdsm.removeOldestFromCache())
+	}
+}
+
+/*
+evictOldest removes the oldest entry from the cache. Returns false if the cache
+is empty.
+*/
+func (cdsm *CachedDiskStorageManager) evictOldest() bool {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	if cdsm.firstentry == nil {
+		return false
+	}
+
+	entryPool.Put(cdsm.removeOldestFromCache())
+
+	return true
+}
+
+/*
+reclaimBudget evicts objects of other caches if the memory budget is still
+exceeded after the objects of this cache have been evicted. Must be called
+without holding the lock of this cache.
+*/
+func (cdsm *CachedDiskStorageManager) reclaimBudget() {
+	if cdsm.budget != nil && cdsm.budget.exceeded(0) {
+		cdsm.budget.reclaim(cdsm)
+	}
+}
+
+/*
+resizeEntry changes the recorded size of a cache entry.
+*/
+func (cdsm *CachedDiskStorageManager) resizeEntry(entry *cacheEntry, size int) {
+	diff := int64(size - entry.size)
+
+	entry.size = size
+	cdsm.stats.Size += diff
+
+	if cdsm.budget != nil {
+		cdsm.budget.add(diff)
+	}
+}
+
 /*
 llTouchEntry puts an entry to the last position of the cacheEntry linked list.
 Calling llTouchEntry on all requested items ensures that the oldest used
@@ -334,10 +546,11 @@ func (cdsm *CachedDiskStorageManager) llRemoveEntry(entry *cacheEntry) {
 
 	if entry.prev != nil {
 		entry.prev.next = entry.next
-		entry.prev = nil
 	}
 	if entry.next != nil {
 		entry.next.prev = entry.prev
-		entry.next = nil
 	}
+
+	entry.prev = nil
+	entry.next = nil
 }
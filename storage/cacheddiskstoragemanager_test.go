@@ -11,6 +11,7 @@
 package storage
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/krotik/eliasdb/storage/file"
@@ -401,3 +402,100 @@ func TestCachedDiskStorageManagerCacheManagement(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+/*
+checkCacheList checks that the cache entry list can be walked in both
+directions and contains exactly the given locations.
+*/
+func checkCacheList(cdsm *CachedDiskStorageManager, locs ...uint64) error {
+	var forward, backward []uint64
+
+	for e := cdsm.firstentry; e != nil && len(forward) <= len(locs); e = e.next {
+		forward = append(forward, e.location)
+	}
+
+	for e := cdsm.lastentry; e != nil && len(backward) <= len(locs); e = e.prev {
+		backward = append([]uint64{e.location}, backward...)
+	}
+
+	if fmt.Sprint(forward) != fmt.Sprint(locs) || fmt.Sprint(backward) != fmt.Sprint(locs) {
+		return fmt.Errorf("Unexpected list: %v (forward) %v (backward) expected: %v",
+			forward, backward, locs)
+	}
+
+	return nil
+}
+
+func TestCachedDiskStorageManagerListRemoval(t *testing.T) {
+
+	dsm := NewDiskStorageManager(DBDIR+"/ctest4", false, false, true, true)
+
+	cdsm := NewCachedDiskStorageManager(dsm, 5)
+
+	loc1, _ := cdsm.Insert("test1")
+	loc2, _ := cdsm.Insert("test2")
+	loc3, _ := cdsm.Insert("test3")
+	loc4, _ := cdsm.Insert("test4")
+
+	if err := checkCacheList(cdsm, loc1, loc2, loc3, loc4); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Removing an entry in the middle of the list must keep the links of
+	// both neighbours (the previous link of the next entry was lost before)
+
+	cdsm.Free(loc2)
+
+	if err := checkCacheList(cdsm, loc1, loc3, loc4); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Touching an entry in the middle of the list moves it to the end
+
+	var ret string
+
+	cdsm.Fetch(loc3, &ret)
+
+	if err := checkCacheList(cdsm, loc1, loc4, loc3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Removed entries do not point into the list anymore
+
+	entry := cdsm.cache[loc4]
+
+	cdsm.Free(loc4)
+
+	if entry.prev != nil || entry.next != nil {
+		t.Error("Removed entry should not be linked:", entry.prev, entry.next)
+		return
+	}
+
+	if err := checkCacheList(cdsm, loc1, loc3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Remove the first and the last entry
+
+	cdsm.Free(loc1)
+
+	if err := checkCacheList(cdsm, loc3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cdsm.Free(loc3)
+
+	if err := checkCacheList(cdsm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+	}
+}
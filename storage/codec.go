@@ -0,0 +1,119 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Codecs for stored objects
+*/
+const (
+	CodecGob    = 0 // Objects are serialized with encoding/gob
+	CodecBinary = 1 // Objects are serialized with the schema-less BinaryCodec
+)
+
+/*
+FlagsCodecMask is the mask for the codec in the header flags of the physical
+slots file.
+*/
+const FlagsCodecMask = 0x00F0
+
+/*
+flagsCodecShift is the position of the codec in the header flags of the
+physical slots file.
+*/
+const flagsCodecShift = 4
+
+/*
+ErrUnknownCodec is returned if an unknown codec is requested.
+*/
+var ErrUnknownCodec = errors.New("Unknown codec")
+
+/*
+Codec serializes objects which are stored by a DiskStorageManager.
+*/
+type Codec interface {
+
+	/*
+		Encode writes the serialized form of a given object to a given writer.
+	*/
+	Encode(w io.Writer, o interface{}) error
+
+	/*
+		Decode reads a serialized object from a given reader and writes it to
+		a given data container.
+	*/
+	Decode(r io.Reader, o interface{}) error
+}
+
+/*
+CodecModes maps codec names to codecs.
+*/
+var CodecModes = map[string]int{
+	"gob":    CodecGob,
+	"binary": CodecBinary,
+}
+
+/*
+CodecMode returns the codec for a given name.
+*/
+func CodecMode(name string) (int, error) {
+	mode, ok := CodecModes[name]
+	if !ok {
+		return 0, fmt.Errorf("%v: %v", ErrUnknownCodec, name)
+	}
+	return mode, nil
+}
+
+/*
+codecs are the implementations of all known codecs
+*/
+var codecs = map[int]Codec{
+	CodecGob:    &GobCodec{},
+	CodecBinary: &BinaryCodec{},
+}
+
+/*
+lookupCodec returns the implementation of a given codec.
+*/
+func lookupCodec(mode int) (Codec, error) {
+	codec, ok := codecs[mode]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+/*
+GobCodec is a codec which uses encoding/gob. This is the default codec.
+*/
+type GobCodec struct {
+}
+
+/*
+Encode writes the gob serialized form of a given object to a given writer.
+*/
+func (gc *GobCodec) Encode(w io.Writer, o interface{}) error {
+	return gob.NewEncoder(w).Encode(o)
+}
+
+/*
+Decode reads a gob serialized object from a given reader and writes it to a
+given data container.
+*/
+func (gc *GobCodec) Decode(r io.Reader, o interface{}) error {
+	return gob.NewDecoder(r).Decode(o)
+}
@@ -0,0 +1,156 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+
+	if mode, err := CodecMode("binary"); mode != CodecBinary || err != nil {
+		t.Error("Unexpected result:", mode, err)
+		return
+	}
+
+	if _, err := CodecMode("foo"); err == nil || err.Error() != "Unknown codec: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := lookupCodec(99); err != ErrUnknownCodec {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	for _, mode := range []int{CodecGob, CodecBinary} {
+		var buf bytes.Buffer
+		var res map[string]string
+
+		codec, _ := lookupCodec(mode)
+
+		if err := codec.Encode(&buf, map[string]string{"a": "b"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := codec.Decode(&buf, &res); err != nil || res["a"] != "b" {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+}
+
+func TestDiskStorageManagerCodec(t *testing.T) {
+	var res map[string]interface{}
+
+	obj := map[string]interface{}{"name": "test", "count": 5, "tags": []string{"a", "b"}}
+
+	dsm := NewDiskStorageManagerWithOptions(DBDIR+"/codec1", false, false, false, true,
+		DiskStorageManagerOptions{Format: FormatOptions{Codec: CodecBinary, Compression: CompressionFlate}})
+
+	if dsm.Codec() != CodecBinary {
+		t.Error("Unexpected codec:", dsm.Codec())
+		return
+	}
+
+	loc, err := dsm.Insert(obj)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Reopen the datastore - the codec is taken from the header
+
+	dsm = NewDiskStorageManager(DBDIR+"/codec1", false, false, false, true)
+
+	if dsm.Codec() != CodecBinary || dsm.Compression() != CompressionFlate {
+		t.Error("Unexpected format:", dsm.Codec(), dsm.Compression())
+		return
+	}
+
+	if err := dsm.Fetch(loc, &res); err != nil || res["name"] != "test" || res["count"] != 5 ||
+		len(res["tags"].([]string)) != 2 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Objects of unregistered types cannot be stored in interfaces
+
+	type unknown struct{ A int }
+
+	if _, err := dsm.Insert(map[string]interface{}{"a": unknown{1}}); err == nil {
+		t.Error("Unregistered type should cause an error")
+		return
+	}
+
+	if err := dsm.Update(loc, map[string]interface{}{"a": unknown{1}}); err == nil {
+		t.Error("Unregistered type should cause an error")
+		return
+	}
+
+	dsm.Close()
+
+	// Existing gob datastores keep their codec
+
+	dsm = NewDiskStorageManager(DBDIR+"/codec2", false, false, false, true)
+	loc, _ = dsm.Insert(obj)
+	dsm.Close()
+
+	dsm = NewDiskStorageManagerWithOptions(DBDIR+"/codec2", false, false, false, true,
+		DiskStorageManagerOptions{Format: FormatOptions{Codec: CodecBinary}})
+
+	if dsm.Codec() != CodecGob {
+		t.Error("Unexpected codec:", dsm.Codec())
+		return
+	}
+
+	if err := dsm.Fetch(loc, &res); err != nil || res["name"] != "test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// An unknown codec is reported on use
+
+	dsm.options.Format.Codec = 99
+
+	if _, err := dsm.Insert(obj); err != ErrUnknownCodec {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.Fetch(loc, &res); err != ErrUnknownCodec {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm.options.Format.Codec = CodecGob
+	dsm.Close()
+
+	testUnknownCodecPanic(t)
+}
+
+func testUnknownCodecPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Unknown codec did not cause a panic.")
+		}
+	}()
+
+	NewDiskStorageManagerWithOptions(DBDIR+"/codec3", false, false, false, true,
+		DiskStorageManagerOptions{Format: FormatOptions{Codec: 99}})
+}
@@ -0,0 +1,276 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/krotik/common/errorutil"
+	"github.com/krotik/eliasdb/storage/file"
+	"github.com/krotik/eliasdb/storage/paging"
+	"github.com/krotik/eliasdb/storage/slotting"
+)
+
+/*
+FileSuffixCompaction is the file ending for temporary files which are created
+during compaction
+*/
+const FileSuffixCompaction = "compact"
+
+/*
+Compactor is a storage manager which can compact its files.
+*/
+type Compactor interface {
+
+	/*
+		Compact rewrites the stored data without any gaps and releases
+		unused space.
+	*/
+	Compact() error
+}
+
+/*
+Compact rewrites all stored data into new physical slot files. The data of all
+used logical slots is copied without any gaps and the logical slots are updated
+to point to the new locations. Unused pages at the end of the logical slot files
+are removed. Storage locations stay valid. The storage manager is locked while
+the compaction is running.
+
+The new files are written next to the old ones and only replace them once all
+data was copied. The replacement itself is not atomic - it is safest to compact
+a copy of the datastore while it is not in use.
+*/
+func (bdsm *ByteDiskStorageManager) Compact() error {
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return ErrReadonly
+	}
+
+	// Continue single threaded from here on
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	if err := bdsm.flush(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%v.%v", bdsm.filename, FileSuffixPhysicalSlots)
+	freeName := fmt.Sprintf("%v.%v", bdsm.filename, FileSuffixPhysicalFreeSlots)
+	tmpName := fmt.Sprintf("%v.%v", name, FileSuffixCompaction)
+	tmpFreeName := fmt.Sprintf("%v.%v", freeName, FileSuffixCompaction)
+
+	// Copy all data into new files - the old files are not touched
+
+	locs, err := bdsm.copyPhysicalSlots(tmpName, tmpFreeName)
+	if err != nil {
+		removeStorageFiles(tmpName)
+		removeStorageFiles(tmpFreeName)
+
+		return err
+	}
+
+	// Replace the old files
+
+	if err := bdsm.physicalSlotsPager.Close(); err != nil {
+		return err
+	}
+
+	if err := bdsm.physicalFreeSlotsPager.Close(); err != nil {
+		return err
+	}
+
+	for _, n := range [][]string{{name, tmpName}, {freeName, tmpFreeName}} {
+
+		if err := removeStorageFiles(n[0]); err != nil {
+			return err
+		}
+
+		if err := renameStorageFiles(n[1], n[0]); err != nil {
+			return err
+		}
+	}
+
+	ce := errorutil.NewCompositeError()
+
+	sf, pager, err := createFileAndPager(name, BlockSizePhysicalSlots, bdsm)
+	if err != nil {
+		ce.Add(err)
+	}
+
+	bdsm.physicalSlotsSf = sf
+	bdsm.physicalSlotsPager = pager
+
+	sf, pager, err = createFileAndPager(freeName, BlockSizeFreeSlots, bdsm)
+	if err != nil {
+		ce.Add(err)
+	}
+
+	bdsm.physicalFreeSlotsSf = sf
+	bdsm.physicalFreeSlotsPager = pager
+
+	if ce.HasErrors() {
+		return ce
+	}
+
+	bdsm.physicalSlotManager = slotting.NewPhysicalSlotManager(bdsm.physicalSlotsPager,
+		bdsm.physicalFreeSlotsPager, bdsm.onlyAppend)
+
+	// Point the logical slots to the new locations
+
+	for lloc, ploc := range locs {
+		if err := bdsm.logicalSlotManager.Update(lloc, ploc); err != nil {
+			return err
+		}
+	}
+
+	if err := bdsm.logicalSlotManager.Flush(); err != nil {
+		return err
+	}
+
+	// Remove unused pages from the end of the logical slot files
+
+	if err := bdsm.logicalSlotsPager.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := bdsm.logicalSlotsPager.Truncate(); err != nil {
+		return err
+	}
+
+	_, err = bdsm.logicalFreeSlotsPager.Truncate()
+
+	return err
+}
+
+/*
+copyPhysicalSlots copies all used physical slots into new files. Returns a map
+from each logical slot to its new physical slot. Expects the mutex to be held.
+*/
+func (bdsm *ByteDiskStorageManager) copyPhysicalSlots(name string, freeName string) (map[uint64]uint64, error) {
+	var pagers []*paging.PagedStorageFile
+
+	// Remove any leftovers from a previous compaction
+
+	for _, n := range []string{name, freeName} {
+		if err := removeStorageFiles(n); err != nil {
+			return nil, err
+		}
+	}
+
+	// New files are written without transactions - on failure the files
+	// are just removed
+
+	for i, n := range []string{name, freeName} {
+		recordSize := uint32(BlockSizePhysicalSlots)
+		if i == 1 {
+			recordSize = BlockSizeFreeSlots
+		}
+
+		sf, err := file.NewStorageFileWithOptions(n, recordSize, true,
+			file.StorageFileOptions{EncryptionKey: bdsm.options.EncryptionKey,
+				Checksums: bdsm.physicalSlotsSf.Checksums()})
+		if err != nil {
+			return nil, err
+		}
+
+		pager, err := paging.NewPagedStorageFile(sf)
+		if err != nil {
+			sf.Close()
+			return nil, err
+		}
+
+		defer pager.Close()
+
+		pagers = append(pagers, pager)
+	}
+
+	psm := slotting.NewPhysicalSlotManager(pagers[0], pagers[1], bdsm.onlyAppend)
+
+	locs, err := slotting.RelocateSlots(bdsm.logicalSlotManager, bdsm.physicalSlotManager, psm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy roots and format flags
+
+	header := bdsm.physicalSlotsPager.Header()
+	newHeader := pagers[0].Header()
+
+	for i := 0; i < header.Roots(); i++ {
+		newHeader.SetRoot(i, header.Root(i))
+	}
+
+	newHeader.SetFlags(header.Flags())
+
+	if err := psm.Flush(); err != nil {
+		return nil, err
+	}
+
+	for _, pager := range pagers {
+		if err := pager.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	return locs, nil
+}
+
+/*
+Compact compacts the files of the underlying disk storage manager. The cache
+is not affected since storage locations do not change.
+*/
+func (cdsm *CachedDiskStorageManager) Compact() error {
+	return cdsm.diskstoragemanager.Compact()
+}
+
+/*
+removeStorageFiles removes all physical files of a storage file.
+*/
+func removeStorageFiles(name string) error {
+	err := os.Remove(fmt.Sprintf("%s.%s", name, file.ChecksumFileSuffix))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err := os.Remove(fmt.Sprintf("%s.%d", name, i))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+renameStorageFiles renames all physical files of a storage file.
+*/
+func renameStorageFiles(from string, to string) error {
+	err := os.Rename(fmt.Sprintf("%s.%s", from, file.ChecksumFileSuffix),
+		fmt.Sprintf("%s.%s", to, file.ChecksumFileSuffix))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err := os.Rename(fmt.Sprintf("%s.%d", from, i), fmt.Sprintf("%s.%d", to, i))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiskStorageManagerCompact(t *testing.T) {
+	var locs []uint64
+
+	name := DBDIR + "/compact1"
+
+	dsm := NewDiskStorageManagerWithOptions(name, false, false, false, true,
+		DiskStorageManagerOptions{EncryptionKey: []byte("0123456789abcdef")})
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	data := strings.Repeat("x", 1000)
+
+	for i := 0; i < 100; i++ {
+		loc, err := cdsm.Insert(fmt.Sprint(i, data))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	for _, loc := range locs[:90] {
+		if err := cdsm.Free(loc); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	dsm.SetRoot(5, 42)
+
+	// Close the datastore to write all transactions to the files
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stat, _ := os.Stat(name + ".db.0")
+	oldSize := stat.Size()
+
+	dsm = NewDiskStorageManagerWithOptions(name, false, false, false, true,
+		DiskStorageManagerOptions{EncryptionKey: []byte("0123456789abcdef")})
+	cdsm = NewCachedDiskStorageManager(dsm, 10)
+
+	var c Compactor = cdsm
+
+	if err := c.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stat, _ = os.Stat(name + ".db.0")
+	if stat.Size() == 0 || stat.Size() >= oldSize/4 {
+		t.Error("Unexpected file size:", oldSize, stat.Size())
+		return
+	}
+
+	if res, _ := os.Stat(name + ".db.compact.0"); res != nil {
+		t.Error("Temporary file was not removed")
+		return
+	}
+
+	// Check that all data is still there
+
+	if dsm.Root(5) != 42 || dsm.Root(RootIDVersion) != VERSION {
+		t.Error("Unexpected root values:", dsm.Root(5), dsm.Root(RootIDVersion))
+		return
+	}
+
+	var s string
+
+	for i, loc := range locs[90:] {
+		if err := dsm.Fetch(loc, &s); err != nil || s != fmt.Sprint(i+90, data) {
+			t.Error("Unexpected fetch result:", s, err)
+			return
+		}
+	}
+
+	res, err := dsm.Check(false)
+	if err != nil || len(res.Problems) != 0 || len(res.Locations) != 10 {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	// The storage manager can be used as usual
+
+	if err := dsm.Update(locs[95], "test"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc, err := dsm.Insert("test2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManagerWithOptions(name, true, false, false, true,
+		DiskStorageManagerOptions{EncryptionKey: []byte("0123456789abcdef")})
+
+	if err := dsm.Compact(); err != ErrReadonly {
+		t.Error("Unexpected compact result:", err)
+		return
+	}
+
+	if err := dsm.Fetch(locs[95], &s); err != nil || s != "test" {
+		t.Error("Unexpected fetch result:", s, err)
+		return
+	}
+
+	if err := dsm.Fetch(loc, &s); err != nil || s != "test2" {
+		t.Error("Unexpected fetch result:", s, err)
+		return
+	}
+
+	if err := dsm.Fetch(locs[99], &s); err != nil || s != fmt.Sprint(99, data) {
+		t.Error("Unexpected fetch result:", s, err)
+		return
+	}
+
+	res, err = dsm.Check(false)
+	if err != nil || len(res.Problems) != 0 || len(res.Locations) != 11 {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+Compression modes for stored records
+*/
+const (
+	CompressionNone  = 0 // Records are stored as they are
+	CompressionFlate = 1 // Records are compressed with DEFLATE
+)
+
+/*
+FlagsCompressionMask is the mask for the compression mode in the header flags of
+the physical slots file.
+*/
+const FlagsCompressionMask = 0x000F
+
+/*
+ErrUnknownCompression is returned if an unknown compression mode is requested.
+*/
+var ErrUnknownCompression = errors.New("Unknown compression mode")
+
+/*
+CompressionModes maps compression mode names to compression modes.
+*/
+var CompressionModes = map[string]int{
+	"none":  CompressionNone,
+	"flate": CompressionFlate,
+}
+
+/*
+CompressionMode returns the compression mode for a given name.
+*/
+func CompressionMode(name string) (int, error) {
+	mode, ok := CompressionModes[name]
+	if !ok {
+		return 0, fmt.Errorf("%v: %v", ErrUnknownCompression, name)
+	}
+	return mode, nil
+}
+
+/*
+Pool for flate writers
+*/
+var flateWriterPool = &sync.Pool{New: func() interface{} {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
+/*
+compress compresses a given byte slice with a given compression mode.
+*/
+func compress(mode int, b []byte) ([]byte, error) {
+	if mode == CompressionNone {
+		return b, nil
+	} else if mode != CompressionFlate {
+		return nil, ErrUnknownCompression
+	}
+
+	var buf bytes.Buffer
+
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+
+	w.Reset(&buf)
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/*
+decompress decompresses a given byte slice with a given compression mode
+and writes the result to a given writer.
+*/
+func decompress(mode int, b []byte, w io.Writer) error {
+	if mode == CompressionNone {
+		_, err := w.Write(b)
+		return err
+	} else if mode != CompressionFlate {
+		return ErrUnknownCompression
+	}
+
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+
+	_, err := io.Copy(w, r)
+
+	return err
+}
@@ -0,0 +1,186 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+
+	if mode, err := CompressionMode("flate"); mode != CompressionFlate || err != nil {
+		t.Error("Unexpected result:", mode, err)
+		return
+	}
+
+	if _, err := CompressionMode("foo"); err == nil || err.Error() != "Unknown compression mode: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	data := []byte(strings.Repeat("test123", 100))
+
+	for _, mode := range []int{CompressionNone, CompressionFlate} {
+		var buf bytes.Buffer
+
+		c, err := compress(mode, data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if mode == CompressionFlate && len(c) >= len(data) {
+			t.Error("Data was not compressed:", len(c))
+			return
+		}
+
+		if err := decompress(mode, c, &buf); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Error("Unexpected result:", buf.String())
+			return
+		}
+	}
+
+	if _, err := compress(99, data); err != ErrUnknownCompression {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := decompress(99, data, &bytes.Buffer{}); err != ErrUnknownCompression {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := decompress(CompressionFlate, data, &bytes.Buffer{}); err == nil {
+		t.Error("Decompressing invalid data should fail")
+		return
+	}
+}
+
+func TestDiskStorageManagerCompression(t *testing.T) {
+	var res string
+
+	longString := strings.Repeat("This is a test ", 1000)
+
+	dsm := NewDiskStorageManagerWithOptions(DBDIR+"/compress1", false, false, false, true,
+		DiskStorageManagerOptions{Format: FormatOptions{Compression: CompressionFlate}})
+
+	if dsm.Compression() != CompressionFlate {
+		t.Error("Unexpected compression mode:", dsm.Compression())
+		return
+	}
+
+	loc, err := dsm.Insert(longString)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc2, err := dsm.Insert("test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Update(loc2, "test2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != longString {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The long string should fit on a single data page
+
+	if p := dsm.physicalSlotsPager.Last(1); p != 1 {
+		t.Error("Unexpected last data page:", p)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Reopen the datastore - the compression mode is taken from the header
+
+	dsm = NewDiskStorageManager(DBDIR+"/compress1", false, false, false, true)
+
+	if dsm.Compression() != CompressionFlate {
+		t.Error("Unexpected compression mode:", dsm.Compression())
+		return
+	}
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != longString {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.Fetch(loc2, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Fetching raw bytes returns the decompressed data
+
+	expected, _ := dsm.Serialize("test2")
+	b := make([]byte, len(expected))
+	if err := dsm.ByteDiskStorageManager.Fetch(loc2, b); err != nil || !bytes.Equal(b, expected) {
+		t.Errorf("Unexpected result: %q %v", b, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Existing uncompressed datastores stay uncompressed
+
+	dsm = NewDiskStorageManager(DBDIR+"/compress2", false, false, false, true)
+	dsm.Close()
+
+	dsm = NewDiskStorageManagerWithOptions(DBDIR+"/compress2", false, false, false, true,
+		DiskStorageManagerOptions{Format: FormatOptions{Compression: CompressionFlate}})
+
+	if dsm.Compression() != CompressionNone {
+		t.Error("Unexpected compression mode:", dsm.Compression())
+		return
+	}
+
+	dsm.Close()
+
+	testUnknownCompressionPanic(t)
+}
+
+func testUnknownCompressionPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Unknown compression mode did not cause a panic.")
+		}
+	}()
+
+	NewDiskStorageManagerWithOptions(DBDIR+"/compress3", false, false, false, true,
+		DiskStorageManagerOptions{Format: FormatOptions{Compression: 99}})
+}
@@ -0,0 +1,113 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/krotik/eliasdb/storage/paging"
+	"github.com/krotik/eliasdb/storage/slotting"
+)
+
+/*
+CheckResult is the result of a consistency check of a storage manager.
+*/
+type CheckResult struct {
+	Name      string   // Filename of the checked datastore
+	Problems  []string // Found problems
+	Repaired  bool     // Flag if a repair was attempted
+	Locations []uint64 // Storage locations which are in use
+}
+
+/*
+Checker is a storage manager which can check the consistency of its data.
+*/
+type Checker interface {
+
+	/*
+		Check checks the consistency of the stored data and optionally
+		tries to repair it.
+	*/
+	Check(repair bool) (*CheckResult, error)
+}
+
+/*
+Check checks the consistency of all managed files. The page lists of all files
+and the slot tables are checked. If the repair flag is set then wrong page
+pointers are corrected, lost pages are freed and the free slot lists are
+rebuilt. The check can run while the storage manager is in use. All pending
+changes are written to disk before the check.
+*/
+func (bdsm *ByteDiskStorageManager) Check(repair bool) (*CheckResult, error) {
+	bdsm.checkFileOpen()
+
+	// Fail repair if readonly
+
+	if repair && bdsm.readonly {
+		return nil, ErrReadonly
+	}
+
+	if err := bdsm.Flush(); err != nil {
+		return nil, err
+	}
+
+	// Continue single threaded from here on
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	res := &CheckResult{bdsm.filename, nil, false, nil}
+
+	pagers := []*paging.PagedStorageFile{bdsm.physicalSlotsPager,
+		bdsm.physicalFreeSlotsPager, bdsm.logicalSlotsPager, bdsm.logicalFreeSlotsPager}
+
+	for _, pager := range pagers {
+		problems, err := paging.CheckPageLists(pager, repair)
+
+		for _, p := range problems {
+			res.Problems = append(res.Problems, fmt.Sprintf("%v: %v",
+				pager.StorageFile().Name(), p))
+		}
+
+		if err != nil {
+			return res, err
+		}
+	}
+
+	locations, problems, err := slotting.CheckSlots(bdsm.logicalSlotManager,
+		bdsm.physicalSlotManager, repair)
+
+	res.Locations = locations
+	res.Problems = append(res.Problems, problems...)
+
+	if err != nil {
+		return res, err
+	}
+
+	if repair && len(res.Problems) > 0 {
+		res.Repaired = true
+
+		for _, pager := range pagers {
+			if err := pager.Flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	return res, nil
+}
+
+/*
+Check checks the consistency of the underlying disk storage manager.
+*/
+func (cdsm *CachedDiskStorageManager) Check(repair bool) (*CheckResult, error) {
+	return cdsm.diskstoragemanager.Check(repair)
+}
@@ -0,0 +1,88 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiskStorageManagerCheck(t *testing.T) {
+	var locs []uint64
+
+	dsm := NewDiskStorageManager(DBDIR+"/check1", false, false, false, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	for i := 0; i < 10; i++ {
+		loc, err := cdsm.Insert(fmt.Sprint("test", i))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	cdsm.Free(locs[9])
+
+	var c Checker = cdsm
+
+	res, err := c.Check(false)
+	if err != nil || len(res.Problems) != 0 || res.Repaired ||
+		fmt.Sprint(res.Locations) != fmt.Sprint(locs[:9]) || res.Name != DBDIR+"/check1" {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	// Insert a physical slot which is not referenced
+
+	if _, err := dsm.physicalSlotManager.Insert([]byte("orphan"), 0, 6); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = dsm.Check(true)
+	if err != nil || len(res.Problems) != 1 || !res.Repaired {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Check the repaired datastore in readonly mode
+
+	dsm = NewDiskStorageManager(DBDIR+"/check1", true, false, false, true)
+
+	if _, err := dsm.Check(true); err != ErrReadonly {
+		t.Error("Unexpected check result:", err)
+		return
+	}
+
+	res, err = dsm.Check(false)
+	if err != nil || len(res.Problems) != 0 || len(res.Locations) != 9 {
+		t.Error("Unexpected check result:", res, err)
+		return
+	}
+
+	var s string
+
+	if err := dsm.Fetch(locs[5], &s); err != nil || s != "test5" {
+		t.Error("Unexpected fetch result:", s, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -12,7 +12,6 @@ package storage
 
 import (
 	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"io"
@@ -82,7 +81,76 @@ ErrReadonly is returned when attempting a write operation on a readonly datastor
 var ErrReadonly = errors.New("Storage is readonly")
 
 /*
-DiskStorageManager is a storage manager which can store any gob serializable datastructure.
+DiskStorageManagerOptions contains optional settings for a disk storage manager.
+The settings are grouped by the feature they belong to.
+*/
+type DiskStorageManagerOptions struct {
+	Format        FormatOptions // Format of the files of a new datastore
+	EncryptionKey []byte        // AES key to encrypt all files and transaction logs (nil for no encryption)
+	Read          ReadOptions   // Reading and caching of stored data
+	Log           LogOptions    // Syncing and archiving of transaction logs
+	Replica       bool          // Flag if the files are written by another process and should only be read (see Refresher)
+}
+
+/*
+FormatOptions contains settings which change the format of the files on disk.
+They are only applied when a new datastore is created. Existing datastores keep
+the settings which were used when they were created.
+*/
+type FormatOptions struct {
+	Compression int  // Compression mode for stored records (see CompressionNone, ...)
+	Codec       int  // Codec for stored objects (see CodecGob, ...)
+	Checksums   bool // Flag if checksums of all records should be stored
+}
+
+/*
+ReadOptions contains settings for reading and caching stored data.
+*/
+type ReadOptions struct {
+	MemoryMapped  bool  // Flag if records should be read from memory mapped files
+	CacheMaxBytes int64 // Memory budget in bytes for cached objects (0 for no limit)
+}
+
+/*
+LogOptions contains settings for syncing and archiving transaction logs.
+*/
+type LogOptions struct {
+	SyncPolicy file.SyncPolicy // Policy for a LogSyncer which is shared by all StorageManagers of a graph storage
+	Syncer     *file.LogSyncer // Syncer for all transaction logs (nil to sync every commit)
+	Archive    string          // Directory to archive all transactions (empty for no archive)
+}
+
+/*
+Validate checks that the given options can be used together.
+*/
+func (o DiskStorageManagerOptions) Validate() error {
+
+	if o.Read.MemoryMapped && o.EncryptionKey != nil {
+
+		// Encrypted records are decrypted into a copy on every read
+
+		return errors.New("Memory mapped files cannot be used with encryption")
+
+	} else if o.Read.MemoryMapped && o.Replica {
+
+		// The files of a replica change size while they are read
+
+		return errors.New("Memory mapped files cannot be used by a replica")
+
+	} else if o.Replica && (o.Log.Archive != "" || o.Log.Syncer != nil) {
+
+		// A replica never writes transaction logs
+
+		return errors.New("A replica cannot archive or sync transaction logs")
+	}
+
+	return nil
+}
+
+/*
+DiskStorageManager is a storage manager which can store any datastructure which
+can be serialized by its codec. The codec is chosen when the datastore is created
+(gob by default).
 */
 type DiskStorageManager struct {
 	*ByteDiskStorageManager
@@ -98,8 +166,19 @@ transactions.
 func NewDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 	transDisabled bool, lockfileDisabled bool) *DiskStorageManager {
 
-	return &DiskStorageManager{NewByteDiskStorageManager(filename, readonly,
-		onlyAppend, transDisabled, lockfileDisabled)}
+	return NewDiskStorageManagerWithOptions(filename, readonly, onlyAppend,
+		transDisabled, lockfileDisabled, DiskStorageManagerOptions{})
+}
+
+/*
+NewDiskStorageManagerWithOptions creates a new disk storage manager with
+additional options.
+*/
+func NewDiskStorageManagerWithOptions(filename string, readonly bool, onlyAppend bool,
+	transDisabled bool, lockfileDisabled bool, options DiskStorageManagerOptions) *DiskStorageManager {
+
+	return &DiskStorageManager{NewByteDiskStorageManagerWithOptions(filename, readonly,
+		onlyAppend, transDisabled, lockfileDisabled, options)}
 }
 
 /*
@@ -114,50 +193,94 @@ Serialize serializes an object into a byte slice.
 */
 func (dsm *DiskStorageManager) Serialize(o interface{}) ([]byte, error) {
 
+	bb, err := dsm.serialize(o)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseBuffer(bb)
+
+	return append([]byte(nil), bb.Bytes()...), nil
+}
+
+/*
+serialize serializes an object into a buffer from the buffer pool. The buffer
+must be released once it is no longer needed.
+*/
+func (dsm *DiskStorageManager) serialize(o interface{}) (*bytes.Buffer, error) {
+
+	codec, err := lookupCodec(dsm.options.Format.Codec)
+	if err != nil {
+		return nil, err
+	}
+
 	// Request a buffer from the buffer pool
 
 	bb := BufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		bb.Reset()
-		BufferPool.Put(bb)
-	}()
 
-	// Serialize the object into a gob bytes stream
+	// Serialize the object into a bytes stream
 
-	err := gob.NewEncoder(bb).Encode(o)
-	if err != nil {
+	if err := codec.Encode(bb, o); err != nil {
+		releaseBuffer(bb)
 		return nil, err
 	}
 
-	return bb.Bytes(), nil
+	return bb, nil
+}
+
+/*
+releaseBuffer returns a buffer to the buffer pool.
+*/
+func releaseBuffer(bb *bytes.Buffer) {
+	bb.Reset()
+	BufferPool.Put(bb)
 }
 
 /*
 Insert inserts an object and return its storage location.
 */
 func (dsm *DiskStorageManager) Insert(o interface{}) (uint64, error) {
+	loc, _, err := dsm.insert(o)
+	return loc, err
+}
+
+/*
+insert inserts an object and returns its storage location and its serialized size.
+*/
+func (dsm *DiskStorageManager) insert(o interface{}) (uint64, int, error) {
 
-	b, err := dsm.Serialize(o)
+	bb, err := dsm.serialize(o)
 
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	defer releaseBuffer(bb)
+
+	loc, err := dsm.ByteDiskStorageManager.Insert(bb.Bytes())
 
-	return dsm.ByteDiskStorageManager.Insert(b)
+	return loc, bb.Len(), err
 }
 
 /*
 Update updates a storage location.
 */
 func (dsm *DiskStorageManager) Update(loc uint64, o interface{}) error {
+	_, err := dsm.update(loc, o)
+	return err
+}
+
+/*
+update updates a storage location and returns the serialized size of the object.
+*/
+func (dsm *DiskStorageManager) update(loc uint64, o interface{}) (int, error) {
 
-	b, err := dsm.Serialize(o)
+	bb, err := dsm.serialize(o)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer releaseBuffer(bb)
 
-	return dsm.ByteDiskStorageManager.Update(loc, b)
+	return bb.Len(), dsm.ByteDiskStorageManager.Update(loc, bb.Bytes())
 }
 
 /*
@@ -165,6 +288,15 @@ Fetch fetches an object from a given storage location and writes it to
 a given data container.
 */
 func (dsm *DiskStorageManager) Fetch(loc uint64, o interface{}) error {
+	_, err := dsm.fetch(loc, o)
+	return err
+}
+
+/*
+fetch fetches an object from a given storage location and returns its
+serialized size.
+*/
+func (dsm *DiskStorageManager) fetch(loc uint64, o interface{}) (int, error) {
 
 	// Request a buffer from the buffer pool
 
@@ -175,12 +307,19 @@ func (dsm *DiskStorageManager) Fetch(loc uint64, o interface{}) error {
 	}()
 
 	if err := dsm.ByteDiskStorageManager.Fetch(loc, bb); err != nil {
-		return err
+		return 0, err
 	}
 
-	//  Deserialize the object from a gob bytes stream
+	size := bb.Len()
+
+	//  Deserialize the object from a bytes stream
+
+	codec, err := lookupCodec(dsm.options.Format.Codec)
+	if err != nil {
+		return size, err
+	}
 
-	return gob.NewDecoder(bb).Decode(o)
+	return size, codec.Decode(bb, o)
 }
 
 /*
@@ -193,6 +332,8 @@ type ByteDiskStorageManager struct {
 	transDisabled bool        // Flag if transactions are enabled
 	mutex         *sync.Mutex // Mutex to protect actual file operations
 
+	options DiskStorageManagerOptions // Options of this storage manager
+
 	physicalSlotsSf        *file.StorageFile        // StorageFile for physical slots
 	physicalSlotsPager     *paging.PagedStorageFile // Pager for physical slots StorageFile
 	physicalFreeSlotsSf    *file.StorageFile        // StorageFile for free physical slots
@@ -220,8 +361,26 @@ manager will not support transactions.
 func NewByteDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 	transDisabled bool, lockfileDisabled bool) *ByteDiskStorageManager {
 
+	return NewByteDiskStorageManagerWithOptions(filename, readonly, onlyAppend,
+		transDisabled, lockfileDisabled, DiskStorageManagerOptions{})
+}
+
+/*
+NewByteDiskStorageManagerWithOptions creates a new disk storage manager which
+can only store byte slices with additional options.
+*/
+func NewByteDiskStorageManagerWithOptions(filename string, readonly bool, onlyAppend bool,
+	transDisabled bool, lockfileDisabled bool, options DiskStorageManagerOptions) *ByteDiskStorageManager {
+
 	var lf *lockutil.LockFile
 
+	// A replica reads files which are owned by another process
+
+	if options.Replica {
+		readonly = true
+		lockfileDisabled = true
+	}
+
 	// Create a lockfile which is checked every 50 milliseconds
 
 	if !lockfileDisabled {
@@ -229,8 +388,12 @@ func NewByteDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 			time.Duration(50)*time.Millisecond)
 	}
 
-	bdsm := &ByteDiskStorageManager{filename, readonly, onlyAppend, transDisabled, &sync.Mutex{}, nil, nil,
-		nil, nil, nil, nil, nil, nil, nil, nil, lf}
+	if err := options.Validate(); err != nil {
+		panic(fmt.Sprintf("Could not initialize DiskStorageManager: %v", err))
+	}
+
+	bdsm := &ByteDiskStorageManager{filename, readonly, onlyAppend, transDisabled, &sync.Mutex{},
+		options, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lf}
 
 	err := initByteDiskStorageManager(bdsm)
 	if err != nil {
@@ -261,6 +424,20 @@ func (bdsm *ByteDiskStorageManager) Name() string {
 	return fmt.Sprint("ByteDiskStorageFile:", bdsm.filename)
 }
 
+/*
+Compression returns the compression mode of the stored records.
+*/
+func (bdsm *ByteDiskStorageManager) Compression() int {
+	return bdsm.options.Format.Compression
+}
+
+/*
+Codec returns the codec of the stored objects.
+*/
+func (bdsm *ByteDiskStorageManager) Codec() int {
+	return bdsm.options.Format.Codec
+}
+
 /*
 Root returns a root value.
 */
@@ -302,6 +479,13 @@ func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (uint64, error) {
 		return 0, ErrReadonly
 	}
 
+	// Compress the data if required
+
+	b, err := compress(bdsm.options.Format.Compression, o.([]byte))
+	if err != nil {
+		return 0, err
+	}
+
 	// Continue single threaded from here on
 
 	bdsm.mutex.Lock()
@@ -309,8 +493,6 @@ func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (uint64, error) {
 
 	// Store the data in a physical slot
 
-	b := o.([]byte)
-
 	ploc, err := bdsm.physicalSlotManager.Insert(b, 0, uint32(len(b)))
 	if err != nil {
 		return 0, err
@@ -338,6 +520,13 @@ func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) error {
 		return ErrReadonly
 	}
 
+	// Compress the data if required
+
+	b, err := compress(bdsm.options.Format.Compression, o.([]byte))
+	if err != nil {
+		return err
+	}
+
 	// Get the physical slot for the given logical slot
 
 	bdsm.mutex.Lock()
@@ -359,8 +548,6 @@ func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) error {
 
 	// Update the physical record
 
-	b := o.([]byte)
-
 	newPloc, err := bdsm.physicalSlotManager.Update(ploc, b, 0, uint32(len(b)))
 	if err != nil {
 		return err
@@ -399,16 +586,33 @@ func (bdsm *ByteDiskStorageManager) Fetch(loc uint64, o interface{}) error {
 
 	// Request the stored bytes
 
-	bdsm.mutex.Lock()
+	w, isWriter := o.(io.Writer)
 
-	if w, ok := o.(io.Writer); ok {
-		err = bdsm.physicalSlotManager.Fetch(ploc, w)
-	} else {
+	if !isWriter || bdsm.options.Format.Compression != CompressionNone {
 		var b bytes.Buffer
+
+		bdsm.mutex.Lock()
 		err = bdsm.physicalSlotManager.Fetch(ploc, &b)
-		copy(o.([]byte), b.Bytes())
+		bdsm.mutex.Unlock()
+
+		if err == nil {
+
+			// Decompress the data if required
+
+			if isWriter {
+				err = decompress(bdsm.options.Format.Compression, b.Bytes(), w)
+			} else {
+				var db bytes.Buffer
+				err = decompress(bdsm.options.Format.Compression, b.Bytes(), &db)
+				copy(o.([]byte), db.Bytes())
+			}
+		}
+
+		return err
 	}
 
+	bdsm.mutex.Lock()
+	err = bdsm.physicalSlotManager.Fetch(ploc, w)
 	bdsm.mutex.Unlock()
 
 	return err
@@ -478,13 +682,20 @@ func (bdsm *ByteDiskStorageManager) Flush() error {
 		return nil
 	}
 
-	ce := errorutil.NewCompositeError()
-
 	// Continue single threaded from here on
 
 	bdsm.mutex.Lock()
 	defer bdsm.mutex.Unlock()
 
+	return bdsm.flush()
+}
+
+/*
+flush writes all pending changes to disk. Expects the mutex to be held.
+*/
+func (bdsm *ByteDiskStorageManager) flush() error {
+	ce := errorutil.NewCompositeError()
+
 	// Write pending changes
 
 	if err := bdsm.physicalSlotManager.Flush(); err != nil {
@@ -748,20 +959,150 @@ func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 		bdsm.SetRoot(RootIDVersion, VERSION)
 	}
 
+	// Check the format flags - these can only be set when the datastore is created
+
+	header := bdsm.physicalSlotsPager.Header()
+
+	if version == 0 && !bdsm.readonly {
+
+		if _, err := compress(bdsm.options.Format.Compression, nil); err != nil {
+			bdsm.Close()
+
+			panic(fmt.Sprint("Cannot create datastore ", bdsm.filename, " - ", err))
+		}
+
+		if _, err := lookupCodec(bdsm.options.Format.Codec); err != nil {
+			bdsm.Close()
+
+			panic(fmt.Sprint("Cannot create datastore ", bdsm.filename, " - ", err))
+		}
+
+		header.SetFlags(header.Flags()&^(FlagsCompressionMask|FlagsCodecMask) |
+			uint16(bdsm.options.Format.Compression)&FlagsCompressionMask |
+			uint16(bdsm.options.Format.Codec<<flagsCodecShift)&FlagsCodecMask)
+	}
+
+	bdsm.options.Format.Compression = int(header.Flags() & FlagsCompressionMask)
+	bdsm.options.Format.Codec = int(header.Flags()&FlagsCodecMask) >> flagsCodecShift
+
 	return nil
 }
 
+/*
+diskStorageFiles are the files of a disk storage manager with their record sizes
+*/
+var diskStorageFiles = []struct {
+	suffix    string
+	blockSize uint32
+}{
+	{FileSuffixPhysicalSlots, BlockSizePhysicalSlots},
+	{FileSuffixPhysicalFreeSlots, BlockSizeFreeSlots},
+	{FileSuffixLogicalSlots, BlockSizeLogicalSlots},
+	{FileSuffixLogicalFreeSlots, BlockSizeFreeSlots},
+}
+
+/*
+FileSuffixRekeyManifest is the file suffix for the manifest of a disk storage
+manager whose files are being rewritten with a new key.
+*/
+const FileSuffixRekeyManifest = "rekey"
+
+/*
+RekeyDiskStorageManager rewrites all files of a closed disk storage manager
+using a new encryption key. A nil key means no encryption. An interrupted
+rekey is resumed by calling this function again with the same keys.
+*/
+func RekeyDiskStorageManager(filename string, oldKey []byte, newKey []byte) error {
+	m, err := file.OpenRewriteManifest(fmt.Sprintf("%v.%v", filename, FileSuffixRekeyManifest))
+	if err != nil {
+		return err
+	}
+
+	if err := RekeyDiskStorageManagerWithManifest(filename, oldKey, newKey, m); err != nil {
+		return err
+	}
+
+	return m.Remove()
+}
+
+/*
+RekeyDiskStorageManagerWithManifest rewrites all files of a closed disk
+storage manager using a new encryption key. Files which are already recorded
+in the given manifest are not rewritten again.
+*/
+func RekeyDiskStorageManagerWithManifest(filename string, oldKey []byte, newKey []byte,
+	m *file.RewriteManifest) error {
+
+	for _, f := range diskStorageFiles {
+		if err := m.RewriteStorageFile(fmt.Sprintf("%v.%v", filename, f.suffix),
+			f.blockSize, oldKey, newKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ReplayDiskStorageManager writes all archived transactions of a closed disk
+storage manager which were committed after a given time and before or at
+another given time (Unix time in nanoseconds) to its files. The archived
+transactions are read from the given archive directory. Returns the number of
+replayed transactions.
+*/
+func ReplayDiskStorageManager(filename string, key []byte, archive string,
+	from int64, until int64) (int, error) {
+	var count int
+
+	for _, f := range diskStorageFiles {
+		name := fmt.Sprintf("%v.%v", filename, f.suffix)
+		archiveName := file.LogArchiveName(name, archive)
+
+		// Files without any archived transactions are not touched
+
+		if res, _ := fileutil.PathExists(archiveName); !res {
+			continue
+		}
+
+		ts, err := file.LogArchiveTransactions(archiveName)
+		if err != nil {
+			return count, err
+		} else if len(ts) == 0 || ts[0] > until || ts[len(ts)-1] <= from {
+			continue
+		}
+
+		c, err := file.ReplayLogArchive(name, f.blockSize, key, archiveName, from, until)
+		if err != nil {
+			return count, err
+		}
+
+		count += c
+	}
+
+	return count, nil
+}
+
 /*
 createFileAndPager creates a storagefile and a pager.
 */
 func createFileAndPager(filename string, recordSize uint32,
 	bdsm *ByteDiskStorageManager) (*file.StorageFile, *paging.PagedStorageFile, error) {
 
-	sf, err := file.NewStorageFile(filename, recordSize, bdsm.transDisabled)
+	sf, err := file.NewStorageFileWithOptions(filename, recordSize, bdsm.transDisabled,
+		file.StorageFileOptions{EncryptionKey: bdsm.options.EncryptionKey,
+			Checksums: bdsm.options.Format.Checksums, MemoryMapped: bdsm.options.Read.MemoryMapped,
+			Syncer: bdsm.options.Log.Syncer, Replica: bdsm.options.Replica})
 	if err != nil {
 		return nil, nil, err
 	}
 
+	if bdsm.options.Log.Archive != "" && !bdsm.transDisabled && !bdsm.readonly {
+		if err := sf.EnableLogArchive(bdsm.options.Log.Archive); err != nil {
+			sf.Close()
+			return nil, nil, err
+		}
+	}
+
 	pager, err := paging.NewPagedStorageFile(sf)
 
 	return sf, pager, err
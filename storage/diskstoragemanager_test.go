@@ -13,11 +13,15 @@ package storage
 import (
 	"bytes"
 	"encoding/gob"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/krotik/common/fileutil"
 	"github.com/krotik/common/lockutil"
 	"github.com/krotik/common/testutil"
 	"github.com/krotik/eliasdb/storage/file"
@@ -596,7 +600,7 @@ const InvalidFileName = "**" + "\x00"
 func TestDiskStorageManagerInit(t *testing.T) {
 	lockfile := lockutil.NewLockFile(DBDIR+"/"+"lock0.lck", time.Duration(50)*time.Millisecond)
 	dsm := &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/" + InvalidFileName, false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lockfile}}
+		DiskStorageManagerOptions{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lockfile}}
 
 	err := initByteDiskStorageManager(dsm.ByteDiskStorageManager)
 	if err == nil {
@@ -607,7 +611,7 @@ func TestDiskStorageManagerInit(t *testing.T) {
 	testCannotInitPanic(t)
 
 	dsm = &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/test999", false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		DiskStorageManagerOptions{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
 
 	err = initByteDiskStorageManager(dsm.ByteDiskStorageManager)
 	if err != nil {
@@ -648,7 +652,7 @@ func testClosedPanic(t *testing.T, dsm *DiskStorageManager) {
 
 func testVersionCheckPanic(t *testing.T) {
 	dsm := &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/test999", false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		DiskStorageManagerOptions{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
 
 	defer func() {
 		if r := recover(); r == nil {
@@ -666,3 +670,280 @@ func checkLocation(t *testing.T, loc uint64, record uint64, offset uint16) {
 		t.Error("Unexpected location. Expected:", record, offset, "Got:", lrecord, loffset)
 	}
 }
+
+func TestDiskStorageManagerEncryption(t *testing.T) {
+	var res string
+
+	key1, _ := file.ParseEncryptionKey("000102030405060708090a0b0c0d0e0f")
+	key2, _ := file.ParseEncryptionKey("000102030405060708090a0b0c0d0e0f0001020304050607")
+
+	dsm := NewDiskStorageManagerWithOptions(DBDIR+"/encrypt1", false, false, false, true,
+		DiskStorageManagerOptions{EncryptionKey: key1})
+
+	loc, err := dsm.Insert("This is a test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := RekeyDiskStorageManager(DBDIR+"/encrypt1", key1, key2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	testWrongKeyPanic(t, key1)
+
+	dsm = NewDiskStorageManagerWithOptions(DBDIR+"/encrypt1", false, false, false, true,
+		DiskStorageManagerOptions{EncryptionKey: key2})
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != "This is a test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := RekeyDiskStorageManager(DBDIR+"/encrypt1", key1, nil); err == nil {
+		t.Error("Rekeying with the wrong key should fail")
+		return
+	}
+}
+
+func testWrongKeyPanic(t *testing.T, key []byte) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Opening a datastore with the wrong key did not cause a panic.")
+		}
+	}()
+
+	NewDiskStorageManagerWithOptions(DBDIR+"/encrypt1", false, false, false, true,
+		DiskStorageManagerOptions{EncryptionKey: key})
+}
+
+func TestDiskStorageManagerLogArchive(t *testing.T) {
+	var res string
+
+	name := DBDIR + "/archive1"
+	archive := DBDIR + "/archive1dir"
+	backup := DBDIR + "/archive1backup"
+
+	os.Mkdir(archive, 0770)
+	os.Mkdir(backup, 0770)
+
+	options := DiskStorageManagerOptions{Log: LogOptions{Archive: archive}}
+
+	dsm := NewDiskStorageManagerWithOptions(name, false, false, false, true, options)
+
+	loc, err := dsm.Insert("test1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm.Close()
+
+	// Copy all files of the storage manager
+
+	copyFiles := func(src, dst string) {
+		files, _ := filepath.Glob(filepath.Join(src, "archive1.*"))
+		for _, f := range files {
+			data, _ := ioutil.ReadFile(f)
+			ioutil.WriteFile(filepath.Join(dst, filepath.Base(f)), data, 0660)
+		}
+	}
+
+	copyFiles(DBDIR, backup)
+
+	dsm = NewDiskStorageManagerWithOptions(name, false, false, false, true, options)
+	dsm.Update(loc, "test2")
+	dsm.Close()
+
+	pointInTime := time.Now().UnixNano()
+
+	dsm = NewDiskStorageManagerWithOptions(name, false, false, false, true, options)
+	dsm.Update(loc, "test3")
+	dsm.Close()
+
+	checkReplay := func(until int64, expected string) {
+		copyFiles(backup, DBDIR)
+
+		if _, err := ReplayDiskStorageManager(name, nil, archive, 0, until); err != nil {
+			t.Error(err)
+			return
+		}
+
+		dsm := NewDiskStorageManagerWithOptions(name, true, false, false, true, options)
+		defer dsm.Close()
+
+		if err := dsm.Fetch(loc, &res); err != nil || res != expected {
+			t.Error("Unexpected result:", res, err)
+		}
+	}
+
+	checkReplay(0, "test1")
+	checkReplay(pointInTime, "test2")
+	checkReplay(time.Now().UnixNano(), "test3")
+
+	if _, err := ReplayDiskStorageManager(name, []byte("0123456789abcdef"),
+		archive, 0, pointInTime); err == nil {
+		t.Error("Replaying with the wrong key should fail")
+		return
+	}
+}
+
+func TestDiskStorageManagerChecksums(t *testing.T) {
+	var res string
+
+	name := DBDIR + "/checksums1"
+
+	dsm := NewDiskStorageManagerWithOptions(name, false, false, false, true,
+		DiskStorageManagerOptions{Format: FormatOptions{Checksums: true}})
+
+	loc, _ := dsm.Insert("test1")
+	loc2, _ := dsm.Insert("test2")
+	dsm.Free(loc2)
+
+	if err := dsm.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm.Close()
+
+	if res, _ := fileutil.PathExists(name + ".db." + file.ChecksumFileSuffix); !res {
+		t.Error("Checksum table should exist")
+		return
+	}
+
+	if res, _ := fileutil.PathExists(name + ".db.compact." + file.ChecksumFileSuffix); res {
+		t.Error("Temporary checksum table should not exist")
+		return
+	}
+
+	// Corrupt the last byte of the first data page
+
+	f, _ := os.OpenFile(name+".db.0", os.O_RDWR, 0660)
+	f.WriteAt([]byte{0x42}, 2*BlockSizePhysicalSlots-1)
+	f.Close()
+
+	dsm = NewDiskStorageManagerWithOptions(name, false, false, false, true,
+		DiskStorageManagerOptions{})
+
+	if err := dsm.Fetch(loc, &res); err == nil ||
+		!strings.Contains(err.Error(), "Record checksum mismatch") {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dsm.Close()
+}
+
+func TestDiskStorageManagerMemoryMapped(t *testing.T) {
+	var res string
+
+	dsm := NewDiskStorageManagerWithOptions(DBDIR+"/mmap1", false, false, false, true,
+		DiskStorageManagerOptions{Read: ReadOptions{MemoryMapped: true}})
+
+	if res := dsm.physicalSlotsSf.MemoryMapped(); res != file.MemoryMappingSupported {
+		t.Error("Unexpected memory mapping flag:", res)
+		return
+	}
+
+	loc, _ := dsm.Insert("test1")
+	dsm.Close()
+
+	dsm = NewDiskStorageManagerWithOptions(DBDIR+"/mmap1", false, false, false, true,
+		DiskStorageManagerOptions{Read: ReadOptions{MemoryMapped: true}})
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dsm.Close()
+}
+
+func TestDiskStorageManagerOptions(t *testing.T) {
+
+	for _, options := range []DiskStorageManagerOptions{
+		{},
+		{EncryptionKey: []byte("0123456789abcdef"), Format: FormatOptions{Checksums: true}},
+		{Read: ReadOptions{MemoryMapped: true}},
+		{Replica: true, Read: ReadOptions{CacheMaxBytes: 100}},
+	} {
+		if err := options.Validate(); err != nil {
+			t.Error("Unexpected result:", options, err)
+			return
+		}
+	}
+
+	key := []byte("0123456789abcdef")
+
+	for _, test := range []struct {
+		options  DiskStorageManagerOptions
+		expected string
+	}{
+		{DiskStorageManagerOptions{EncryptionKey: key, Read: ReadOptions{MemoryMapped: true}},
+			"Memory mapped files cannot be used with encryption"},
+		{DiskStorageManagerOptions{Replica: true, Read: ReadOptions{MemoryMapped: true}},
+			"Memory mapped files cannot be used by a replica"},
+		{DiskStorageManagerOptions{Replica: true, Log: LogOptions{Archive: DBDIR}},
+			"A replica cannot archive or sync transaction logs"},
+	} {
+		if err := test.options.Validate(); err == nil || err.Error() != test.expected {
+			t.Error("Unexpected result:", test.options, err)
+			return
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil || r != "Could not initialize DiskStorageManager: "+
+			"Memory mapped files cannot be used with encryption" {
+			t.Error("Unexpected result:", r)
+		}
+	}()
+
+	NewDiskStorageManagerWithOptions(DBDIR+"/options1", false, false, false, true,
+		DiskStorageManagerOptions{EncryptionKey: key, Read: ReadOptions{MemoryMapped: true}})
+}
+
+func TestDiskStorageManagerSerialize(t *testing.T) {
+	var res string
+
+	dsm := NewDiskStorageManager(DBDIR+"/serialize1", false, false, true, false)
+	defer dsm.Close()
+
+	b, err := dsm.Serialize("test1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := append([]byte(nil), b...)
+
+	// The returned byte slice must not be affected by buffers which are
+	// reused from the buffer pool
+
+	for i := 0; i < 100; i++ {
+		dsm.Serialize(strings.Repeat("x", i))
+		dsm.Insert(strings.Repeat("y", i))
+	}
+
+	if !bytes.Equal(b, expected) {
+		t.Error("Serialized data was modified:", b, expected)
+		return
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+/*
+Checksum related errors
+*/
+var (
+	ErrChecksum = errors.New("Record checksum mismatch")
+)
+
+/*
+ChecksumFileSuffix is the file suffix for checksum tables
+*/
+const ChecksumFileSuffix = "crc"
+
+/*
+ChecksumSize is the size of a single checksum in the checksum table
+*/
+const ChecksumSize = 4
+
+/*
+checksumTable is the CRC32C polynomial table which is used for all checksums
+*/
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+/*
+Checksums returns if the checksums of all records are stored and verified.
+*/
+func (s *StorageFile) Checksums() bool {
+	return s.checksums
+}
+
+/*
+RecordChecksum calculates the checksum of the data of a record. Checksums are
+calculated on the unencrypted data.
+*/
+func RecordChecksum(data []byte) uint32 {
+	return crc32.Checksum(data, checksumTable)
+}
+
+/*
+openChecksumTable enables checksums if the storage file has a checksum table.
+A new storage file gets a checksum table if the create flag is set.
+*/
+func (s *StorageFile) openChecksumTable(create bool) error {
+	name := fmt.Sprintf("%s.%s", s.name, ChecksumFileSuffix)
+
+	if _, err := os.Stat(name); err == nil {
+		s.checksums = true
+
+	} else if _, err := os.Stat(fmt.Sprintf("%s.0", s.name)); create && os.IsNotExist(err) {
+		s.checksums = true
+	}
+
+	if s.checksums {
+		_, err := s.getChecksumFile()
+		return err
+	}
+
+	return nil
+}
+
+/*
+getChecksumFile returns the checksum table of this storage file.
+*/
+func (s *StorageFile) getChecksumFile() (*os.File, error) {
+
+	if s.checksumFile == nil {
+		flag := os.O_CREATE | os.O_RDWR
+		if s.replica {
+			flag = os.O_RDONLY
+		}
+
+		file, err := os.OpenFile(fmt.Sprintf("%s.%s", s.name, ChecksumFileSuffix), flag, 0660)
+		if err != nil {
+			return nil, err
+		}
+
+		s.checksumFile = file
+	}
+
+	return s.checksumFile, nil
+}
+
+/*
+writeChecksum stores the checksum of the data of a given record.
+*/
+func (s *StorageFile) writeChecksum(id uint64, data []byte) error {
+	var buf [ChecksumSize]byte
+
+	file, err := s.getChecksumFile()
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(buf[:], RecordChecksum(data))
+
+	_, err = file.WriteAt(buf[:], int64(id*ChecksumSize))
+
+	return err
+}
+
+/*
+verifyChecksum verifies the data of a given record against its stored
+checksum. Records without a stored checksum (a stored value of 0) are not
+verified.
+*/
+func (s *StorageFile) verifyChecksum(id uint64, data []byte) error {
+	var buf [ChecksumSize]byte
+
+	file, err := s.getChecksumFile()
+	if err != nil {
+		return err
+	}
+
+	if n, err := file.ReadAt(buf[:], int64(id*ChecksumSize)); n != ChecksumSize {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+
+	if stored := binary.LittleEndian.Uint32(buf[:]); stored != 0 && stored != RecordChecksum(data) {
+		return NewStorageFileError(ErrChecksum, fmt.Sprintf("Record %v", id), s.name)
+	}
+
+	return nil
+}
+
+/*
+truncateChecksums removes all checksums of records with an id equal or greater
+than a given count.
+*/
+func (s *StorageFile) truncateChecksums(count uint64) error {
+
+	file, err := s.getChecksumFile()
+	if err != nil {
+		return err
+	}
+
+	return file.Truncate(int64(count * ChecksumSize))
+}
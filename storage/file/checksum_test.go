@@ -0,0 +1,132 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"os"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+
+	for _, key := range [][]byte{nil, testKey} {
+		name := DBDir + "/crc_test1"
+
+		if key != nil {
+			name = DBDir + "/crc_test2"
+		}
+
+		sf, err := NewStorageFileWithOptions(name, 10, false,
+			StorageFileOptions{EncryptionKey: key, Checksums: true})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if !sf.Checksums() {
+			t.Error("Checksums should be enabled for a new storage file")
+			return
+		}
+
+		for _, id := range []uint64{1, 3} {
+			record, _ := sf.Get(id)
+			record.WriteUInt64(0, id)
+			sf.ReleaseInUse(record)
+		}
+
+		sf.Flush()
+		sf.Close()
+
+		// Corrupt the data of record 1
+
+		f, _ := os.OpenFile(name+".0", os.O_RDWR, 0660)
+		f.WriteAt([]byte{0x42}, int64(sf.diskRecordSize()+sf.diskRecordSize()-1))
+		f.Close()
+
+		// Checksums are detected automatically for existing storage files
+
+		sf, _ = NewStorageFileWithKey(name, 10, false, key)
+
+		if !sf.Checksums() {
+			t.Error("Checksums should be enabled for an existing storage file")
+			return
+		}
+
+		record, err := sf.Get(1)
+		if sfe, ok := err.(*StorageFileError); !ok || sfe.Type != ErrChecksum &&
+			(key == nil || sfe.Type != ErrDecrypt) {
+			t.Error("Unexpected result:", record, err)
+			return
+		}
+
+		for _, id := range []uint64{0, 3, 5} {
+			record, err := sf.Get(id)
+			if err != nil || (id == 3 && record.ReadUInt64(0) != 3) {
+				t.Error("Unexpected result:", record, err)
+				return
+			}
+			sf.ReleaseInUse(record)
+		}
+
+		sf.Close()
+	}
+
+	sf, _ := NewStorageFile(DBDir+"/crc_test1", 10, true)
+
+	record, err := sf.Get(1)
+	if err == nil || err.Error() != "Record checksum mismatch (storagefiletest/crc_test1 - Record 1)" {
+		t.Error("Unexpected result:", record, err)
+		return
+	}
+
+	// Checksums of truncated records are removed
+
+	if err := sf.Truncate(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stat, _ := os.Stat(DBDir + "/crc_test1." + ChecksumFileSuffix); stat.Size() != 2*ChecksumSize {
+		t.Error("Unexpected checksum table size:", stat.Size())
+		return
+	}
+
+	// Overwriting the record fixes the checksum
+
+	record = NewRecord(1, make([]byte, 10))
+	record.WriteUInt64(0, 1)
+
+	if err := sf.writeRecord(record); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if record, err := sf.Get(1); err != nil || record.ReadUInt64(0) != 1 {
+		t.Error("Unexpected result:", record, err)
+		return
+	}
+
+	sf.Close()
+
+	// Existing storage files don't get a checksum table
+
+	sf, _ = NewStorageFile(DBDir+"/crc_test3", 10, true)
+	sf.Close()
+
+	sf, _ = NewStorageFileWithOptions(DBDir+"/crc_test3", 10, true, StorageFileOptions{Checksums: true})
+
+	if sf.Checksums() {
+		t.Error("Checksums should not be enabled for an existing storage file")
+		return
+	}
+
+	sf.Close()
+}
@@ -0,0 +1,519 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/krotik/common/fileutil"
+)
+
+/*
+Common encryption related errors.
+*/
+var (
+	ErrInvalidKey = errors.New("Invalid encryption key")
+	ErrDecrypt    = errors.New("Could not decrypt record")
+)
+
+/*
+EncryptionNonceSize is the size of the nonce which is stored with every encrypted record.
+*/
+const EncryptionNonceSize = 12
+
+/*
+EncryptionOverhead is the number of additional bytes which are needed on disk for
+an encrypted record (nonce and authentication tag).
+*/
+const EncryptionOverhead = EncryptionNonceSize + 16
+
+/*
+ParseEncryptionKey parses a hex encoded AES key. The key must be 16, 24 or 32 bytes
+long to select AES-128, AES-192 or AES-256. An empty string returns a nil key
+which disables encryption.
+*/
+func ParseEncryptionKey(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	ret, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidKey, err)
+	}
+
+	if _, err := newRecordCipher(ret); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+/*
+newRecordCipher creates an authenticated cipher (AES-GCM) for a given key.
+*/
+func newRecordCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidKey, err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+/*
+sealRecordData encrypts the data of a record. The record id is used as
+additional authenticated data so encrypted records cannot be swapped.
+*/
+func (s *StorageFile) sealRecordData(id uint64, data []byte) ([]byte, error) {
+	var ad [SizeLong]byte
+
+	ret := make([]byte, EncryptionNonceSize, EncryptionNonceSize+len(data)+s.cipher.Overhead())
+
+	if _, err := io.ReadFull(rand.Reader, ret); err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint64(ad[:], id)
+
+	return s.cipher.Seal(ret, ret, data, ad[:]), nil
+}
+
+/*
+openRecordData decrypts the encrypted data of a record and writes the result
+to a given byte slice.
+*/
+func (s *StorageFile) openRecordData(id uint64, sealed []byte, data []byte) error {
+	var ad [SizeLong]byte
+
+	binary.BigEndian.PutUint64(ad[:], id)
+
+	if len(sealed) < EncryptionOverhead {
+		return NewStorageFileError(ErrDecrypt, fmt.Sprintf("Record %v", id), s.name)
+	}
+
+	res, err := s.cipher.Open(data[:0], sealed[:EncryptionNonceSize],
+		sealed[EncryptionNonceSize:], ad[:])
+
+	if err != nil || len(res) != len(data) {
+		return NewStorageFileError(ErrDecrypt, fmt.Sprintf("Record %v", id), s.name)
+	}
+
+	return nil
+}
+
+/*
+EncryptData encrypts a given byte slice with a given key. The returned data
+starts with the random nonce which is needed for decryption.
+*/
+func EncryptData(key []byte, data []byte) ([]byte, error) {
+	c, err := newRecordCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]byte, EncryptionNonceSize, EncryptionNonceSize+len(data)+c.Overhead())
+
+	if _, err := io.ReadFull(rand.Reader, ret); err != nil {
+		return nil, err
+	}
+
+	return c.Seal(ret, ret, data, nil), nil
+}
+
+/*
+DecryptData decrypts data which was encrypted with EncryptData.
+*/
+func DecryptData(key []byte, sealed []byte) ([]byte, error) {
+	c, err := newRecordCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < EncryptionOverhead {
+		return nil, ErrDecrypt
+	}
+
+	ret, err := c.Open(nil, sealed[:EncryptionNonceSize], sealed[EncryptionNonceSize:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return ret, nil
+}
+
+/*
+RewriteSuffix is the file suffix for the temporary files of a storage file
+which is being rewritten. The rewritten files are only renamed over the
+original files once a marker file with the suffix RewriteMarkerSuffix was
+written.
+*/
+const RewriteSuffix = "rewrite"
+
+/*
+RewriteMarkerSuffix is the file suffix for the marker file of a storage file
+whose rewritten files are being renamed over the original files.
+*/
+const RewriteMarkerSuffix = "rewrite.done"
+
+/*
+RewriteStorageFile rewrites all records of a closed storage file using a new
+encryption key. The old key is used to read existing records. A nil key
+means that records are (or should be) stored unencrypted. Pending transactions
+are written to the storage file before any records are rewritten.
+
+All records are first written to temporary files. A marker file is written
+once the temporary files are complete and is only removed once all of them
+were renamed over the original files. An interrupted rewrite is therefore
+either discarded (the original files are unchanged) or finished by the next
+call (the storage file uses the new key).
+*/
+func RewriteStorageFile(name string, recordSize uint32, oldKey []byte, newKey []byte) error {
+	return rewriteStorageFile(name, recordSize, oldKey, newKey, nil)
+}
+
+/*
+rewriteStorageFile rewrites all records of a closed storage file using a new
+encryption key. The optional done function is called once the storage file
+uses the new key - before the marker file is removed.
+*/
+func rewriteStorageFile(name string, recordSize uint32, oldKey []byte, newKey []byte,
+	done func() error) error {
+
+	tmpName := fmt.Sprintf("%s.%s", name, RewriteSuffix)
+	markerName := fmt.Sprintf("%s.%s", name, RewriteMarkerSuffix)
+
+	// Finish an interrupted rewrite whose temporary files were complete
+
+	if ok, err := fileutil.PathExists(markerName); err != nil {
+		return err
+	} else if ok {
+		return finishRewrite(name, markerName, done)
+	}
+
+	// Remove temporary files of an interrupted rewrite which did not finish
+
+	if err := removeFiles(tmpName, 0); err != nil {
+		return err
+	}
+
+	// Recover any pending transactions with the old key and remove the log
+
+	sf, err := NewStorageFileWithKey(name, recordSize, false, oldKey)
+	if err != nil {
+		return err
+	}
+
+	if err := sf.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(fmt.Sprintf("%s.%s", name, LogFileSuffix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Open source and target without transaction management
+
+	src, err := NewStorageFileWithKey(name, recordSize, true, oldKey)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := NewStorageFileWithKey(tmpName, recordSize, true, newKey)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	record := NewRecord(0, make([]byte, recordSize))
+	recordsPerFile := src.maxFileSize / uint64(src.diskRecordSize())
+
+	for i := 0; ; i++ {
+		stat, err := os.Stat(fmt.Sprintf("%s.%d", name, i))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		count := uint64(stat.Size()) / uint64(src.diskRecordSize())
+
+		for j := uint64(0); j < count; j++ {
+			record.SetID(uint64(i)*recordsPerFile + j)
+
+			if err := src.readRecord(record); err != nil {
+				return err
+			}
+
+			// Empty records don't need to be written
+
+			if !isZero(record.Data()) {
+				if err := dst.writeRecord(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// All non-empty records are stored in the rewritten files up to the
+	// file of the last written record
+
+	count := len(dst.files)
+
+	src.Close()
+	dst.Sync()
+	dst.Close()
+
+	// Rewritten files which would only contain empty records are not
+	// created by the rewrite - they are created empty so every original
+	// file up to the last rewritten file is replaced
+
+	for i := 0; i < count; i++ {
+		f, err := os.OpenFile(fmt.Sprintf("%s.%d", tmpName, i), os.O_CREATE|os.O_WRONLY, 0660)
+		if err != nil {
+			return err
+		}
+
+		if err = f.Sync(); err == nil {
+			err = f.Close()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// The marker records the number of rewritten files - from now on the
+	// rewrite is finished even if it is interrupted
+
+	if err := writeSynced(markerName, []byte(fmt.Sprint(count))); err != nil {
+		return err
+	}
+
+	return finishRewrite(name, markerName, done)
+}
+
+/*
+finishRewrite renames all rewritten files of a storage file over the original
+files and removes trailing original files. The marker file is removed last.
+*/
+func finishRewrite(name string, markerName string, done func() error) error {
+	tmpName := fmt.Sprintf("%s.%s", name, RewriteSuffix)
+
+	data, err := ioutil.ReadFile(markerName)
+	if err != nil {
+		return err
+	}
+
+	count, err := strconv.Atoi(string(data))
+	if err != nil {
+		return fmt.Errorf("Invalid rewrite marker %v: %v", markerName, err)
+	}
+
+	// Every rewritten file is renamed over its original so the datastore
+	// is never without its data files - files which were already renamed
+	// by an interrupted rewrite are skipped
+
+	for i := 0; i < count; i++ {
+		err := os.Rename(fmt.Sprintf("%s.%d", tmpName, i), fmt.Sprintf("%s.%d", name, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Remove trailing original files - their records are either empty or
+	// stored in the rewritten files
+
+	if err := removeFiles(name, count); err != nil {
+		return err
+	}
+
+	if done != nil {
+		if err := done(); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(markerName)
+}
+
+/*
+removeFiles removes all consecutive numbered files of a storage file starting
+with a given number.
+*/
+func removeFiles(name string, start int) error {
+	for i := start; ; i++ {
+		err := os.Remove(fmt.Sprintf("%s.%d", name, i))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+writeSynced writes data to a file and syncs the file to disk.
+*/
+func writeSynced(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+/*
+RewriteManifest records which storage files were already rewritten with a new
+key. It allows resuming an interrupted rewrite of several storage files (e.g.
+all files of a datastore) since every storage file is only rewritten once.
+*/
+type RewriteManifest struct {
+	name string          // Name of the manifest file
+	done map[string]bool // Names of all rewritten storage files
+}
+
+/*
+OpenRewriteManifest opens a rewrite manifest. The entries of an existing
+manifest file are loaded. The manifest file is created with the first entry.
+*/
+func OpenRewriteManifest(name string) (*RewriteManifest, error) {
+	m := &RewriteManifest{name, make(map[string]bool)}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			m.done[line] = true
+		}
+	}
+
+	return m, nil
+}
+
+/*
+Exists returns if the manifest file exists, i.e. if a previous rewrite was
+interrupted.
+*/
+func (m *RewriteManifest) Exists() bool {
+	ok, _ := fileutil.PathExists(m.name)
+	return ok
+}
+
+/*
+Done returns if a given storage file was already rewritten.
+*/
+func (m *RewriteManifest) Done(name string) bool {
+	return m.done[name]
+}
+
+/*
+RewriteStorageFile rewrites a storage file with a new key unless it was
+already rewritten. The storage file is added to the manifest before its
+rewrite marker is removed.
+*/
+func (m *RewriteManifest) RewriteStorageFile(name string, recordSize uint32,
+	oldKey []byte, newKey []byte) error {
+
+	if m.done[name] {
+
+		// Remove a marker which may have been left behind
+
+		return removeRewriteMarker(name)
+	}
+
+	return rewriteStorageFile(name, recordSize, oldKey, newKey, func() error {
+		return m.add(name)
+	})
+}
+
+/*
+removeRewriteMarker removes the rewrite marker of a storage file which was
+already rewritten.
+*/
+func removeRewriteMarker(name string) error {
+	err := os.Remove(fmt.Sprintf("%s.%s", name, RewriteMarkerSuffix))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+/*
+add adds a storage file to the manifest and syncs the manifest file.
+*/
+func (m *RewriteManifest) add(name string) error {
+	f, err := os.OpenFile(m.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.WriteString(name + "\n"); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		m.done[name] = true
+	}
+
+	return err
+}
+
+/*
+Remove removes the manifest file once all storage files were rewritten.
+*/
+func (m *RewriteManifest) Remove() error {
+	err := os.Remove(m.name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+/*
+isZero checks if a given byte slice contains only 0 bytes.
+*/
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
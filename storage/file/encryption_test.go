@@ -0,0 +1,532 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var testKey, _ = ParseEncryptionKey("000102030405060708090a0b0c0d0e0f")
+
+var testKey2, _ = ParseEncryptionKey("0f0e0d0c0b0a09080706050403020100")
+
+func TestParseEncryptionKey(t *testing.T) {
+
+	if key, err := ParseEncryptionKey(""); key != nil || err != nil {
+		t.Error("Unexpected result:", key, err)
+		return
+	}
+
+	if len(testKey) != 16 {
+		t.Error("Unexpected result:", testKey)
+		return
+	}
+
+	if _, err := ParseEncryptionKey("xx"); err == nil {
+		t.Error("Invalid hex string should cause an error")
+		return
+	}
+
+	if _, err := ParseEncryptionKey("0001"); err == nil ||
+		err.Error() != "Invalid encryption key: crypto/aes: invalid key size 2" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewStorageFileWithKey(DBDir+"/enc_test0", 10, true, []byte{1}); err == nil {
+		t.Error("Invalid key should cause an error")
+		return
+	}
+}
+
+func TestEncryptedStorageFile(t *testing.T) {
+
+	sf, err := NewStorageFileWithKey(DBDir+"/enc_test1", 10, false, testKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !sf.Encrypted() {
+		t.Error("Storage file should be encrypted")
+		return
+	}
+
+	record, err := sf.Get(2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	record.WriteUInt64(0, 0x4242424242424242)
+	sf.ReleaseInUse(record)
+
+	if err := sf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Check that the transaction log does not contain the plain data
+
+	log, _ := ioutil.ReadFile(DBDir + "/enc_test1.tlg")
+	if !bytes.Equal(log[:2], TransactionLogHeaderEncrypted) ||
+		bytes.Contains(log, []byte{0x42, 0x42, 0x42, 0x42}) {
+		t.Error("Unexpected transaction log:", log)
+		return
+	}
+
+	// Simulate a crash by not closing the file and recover the log
+
+	sf.tm.logFile.Close()
+	for _, f := range sf.files {
+		f.Close()
+	}
+
+	sf, err = NewStorageFileWithKey(DBDir+"/enc_test1", 10, false, testKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	record, err = sf.Get(2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := record.ReadUInt64(0); res != 0x4242424242424242 {
+		t.Error("Unexpected record data:", record)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	// Unwritten records are empty
+
+	record, err = sf.Get(1)
+	if err != nil || !isZero(record.Data()) {
+		t.Error("Unexpected result:", record, err)
+		return
+	}
+	sf.ReleaseInUse(record)
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Check that the data file does not contain the plain data
+
+	data, _ := ioutil.ReadFile(DBDir + "/enc_test1.0")
+	if len(data) != 3*(10+EncryptionOverhead) || bytes.Contains(data, []byte{0x42, 0x42, 0x42, 0x42}) {
+		t.Error("Unexpected data file:", data)
+		return
+	}
+
+	// Open the file with the wrong key
+
+	sf, err = NewStorageFileWithKey(DBDir+"/enc_test1", 10, true, testKey2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err = sf.Get(2); err == nil || err.(*StorageFileError).Type != ErrDecrypt {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sf.Close()
+
+	// Opening an encrypted log without a key should fail
+
+	ioutil.WriteFile(DBDir+"/enc_test1.tlg", TransactionLogHeaderEncrypted, 0660)
+
+	if _, err = NewStorageFile(DBDir+"/enc_test1", 10, false); err == nil ||
+		err.(*StorageFileError).Type != ErrLogEncryption {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	os.Remove(DBDir + "/enc_test1.tlg")
+}
+
+func TestRewriteStorageFile(t *testing.T) {
+
+	sf, err := NewStorageFile(DBDir+"/enc_test2", 10, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, id := range []uint64{1, 5} {
+		record, _ := sf.Get(id)
+		record.WriteUInt64(0, id)
+		sf.ReleaseInUse(record)
+	}
+
+	sf.Flush()
+	sf.Close()
+
+	// Encrypt the plain file
+
+	if err := RewriteStorageFile(DBDir+"/enc_test2", 10, nil, testKey); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRewrittenFile := func(key []byte) {
+		sf, err = NewStorageFileWithKey(DBDir+"/enc_test2", 10, false, key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer sf.Close()
+
+		for _, id := range []uint64{0, 1, 2, 5} {
+			var expected uint64
+
+			if id == 1 || id == 5 {
+				expected = id
+			}
+
+			record, err := sf.Get(id)
+			if err != nil || record.ReadUInt64(0) != expected {
+				t.Error("Unexpected result:", record, err)
+			}
+			sf.ReleaseInUse(record)
+		}
+	}
+
+	checkRewrittenFile(testKey)
+
+	// Rotate the key
+
+	if err := RewriteStorageFile(DBDir+"/enc_test2", 10, testKey, testKey2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRewrittenFile(testKey2)
+
+	// Decrypt the file again
+
+	if err := RewriteStorageFile(DBDir+"/enc_test2", 10, testKey2, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRewrittenFile(nil)
+
+	// Trailing files which only contain empty records are removed
+
+	ioutil.WriteFile(DBDir+"/enc_test2.1", make([]byte, 100), 0660)
+
+	if err := RewriteStorageFile(DBDir+"/enc_test2", 10, nil, testKey); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkRewrittenFile(testKey)
+
+	for _, f := range []string{"enc_test2.1", "enc_test2.rewrite.0", "enc_test2.rewrite.1"} {
+		if _, err := os.Stat(DBDir + "/" + f); !os.IsNotExist(err) {
+			t.Error("File should not exist:", f, err)
+			return
+		}
+	}
+
+	if err := RewriteStorageFile(DBDir+"/enc_test2", 10, testKey, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := RewriteStorageFile(DBDir+"/enc_test2", 10, testKey, nil); err == nil {
+		t.Error("Rewriting with the wrong key should fail")
+		return
+	}
+}
+
+func TestRewriteStorageFileInterrupted(t *testing.T) {
+	name := DBDir + "/enc_test3"
+
+	sf, err := NewStorageFile(name, 10, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	record, _ := sf.Get(1)
+	record.WriteUInt64(0, 42)
+	sf.ReleaseInUse(record)
+
+	sf.Flush()
+	sf.Close()
+
+	checkFile := func(key []byte) {
+		sf, err := NewStorageFileWithKey(name, 10, false, key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer sf.Close()
+
+		record, err := sf.Get(1)
+		if err != nil || record.ReadUInt64(0) != 42 {
+			t.Error("Unexpected result:", record, err)
+		}
+		sf.ReleaseInUse(record)
+
+		for _, f := range []string{"enc_test3.rewrite.0", "enc_test3.rewrite.done"} {
+			if _, err := os.Stat(DBDir + "/" + f); !os.IsNotExist(err) {
+				t.Error("File should not exist:", f, err)
+			}
+		}
+	}
+
+	// Temporary files of a rewrite which was interrupted before the
+	// marker was written are discarded
+
+	ioutil.WriteFile(name+".rewrite.0", []byte("garbage"), 0660)
+
+	if err := RewriteStorageFile(name, 10, nil, testKey); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkFile(testKey)
+
+	// Simulate a rewrite which was interrupted after the marker was written
+	// but before the rewritten file was renamed
+
+	old, _ := ioutil.ReadFile(name + ".0")
+
+	if err := RewriteStorageFile(name, 10, testKey, testKey2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	os.Rename(name+".0", name+".rewrite.0")
+	ioutil.WriteFile(name+".0", old, 0660)
+	ioutil.WriteFile(name+".rewrite.done", []byte("1"), 0660)
+
+	// Resuming finishes the rewrite - the old key is not used anymore
+
+	if err := RewriteStorageFile(name, 10, testKey, testKey2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkFile(testKey2)
+
+	// Simulate a rewrite which was interrupted after the rewritten file
+	// was renamed but before the marker was removed
+
+	ioutil.WriteFile(name+".rewrite.done", []byte("1"), 0660)
+
+	if err := RewriteStorageFile(name, 10, testKey, testKey2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkFile(testKey2)
+
+	// An invalid marker is an error
+
+	ioutil.WriteFile(name+".rewrite.done", []byte("x"), 0660)
+
+	if err := RewriteStorageFile(name, 10, testKey2, nil); err == nil {
+		t.Error("Invalid marker should cause an error")
+		return
+	}
+
+	os.Remove(name + ".rewrite.done")
+
+	// The manifest skips files which were already rewritten
+
+	m, err := OpenRewriteManifest(name + ".manifest")
+	if err != nil || m.Exists() {
+		t.Error("Unexpected result:", m, err)
+		return
+	}
+
+	if err := m.RewriteStorageFile(name, 10, testKey2, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	m, err = OpenRewriteManifest(name + ".manifest")
+	if err != nil || !m.Exists() || !m.Done(name) {
+		t.Error("Unexpected result:", m, err)
+		return
+	}
+
+	ioutil.WriteFile(name+".rewrite.done", []byte("1"), 0660)
+
+	if err := m.RewriteStorageFile(name, 10, testKey2, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkFile(nil)
+
+	if err := m.Remove(); err != nil || m.Exists() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Removing a missing manifest is not an error
+
+	if err := m.Remove(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestRewriteStorageFileEmptyFile(t *testing.T) {
+	name := DBDir + "/enc_test4"
+
+	sf, err := NewStorageFileWithKey(name, 10, true, testKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The first physical file only contains empty records
+
+	if err := sf.writeRecord(NewRecord(0, make([]byte, 10))); err != nil {
+		t.Error(err)
+		return
+	}
+
+	id := sf.maxFileSize/uint64(sf.diskRecordSize()) + 2
+
+	record, _ := sf.Get(id)
+	record.WriteUInt64(0, 42)
+	sf.ReleaseInUse(record)
+
+	sf.Flush()
+	sf.Close()
+
+	if err := RewriteStorageFile(name, 10, testKey, testKey2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf, err = NewStorageFileWithKey(name, 10, true, testKey2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer sf.Close()
+
+	for _, i := range []uint64{0, id} {
+		var expected uint64
+
+		if i == id {
+			expected = 42
+		}
+
+		record, err := sf.Get(i)
+		if err != nil || record.ReadUInt64(0) != expected {
+			t.Error("Unexpected result:", i, record, err)
+			return
+		}
+		sf.ReleaseInUse(record)
+	}
+}
+
+func TestEncryptedStorageFileZeroRecord(t *testing.T) {
+	name := DBDir + "/enc_test5"
+
+	sf, err := NewStorageFileWithKey(name, 10, true, testKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	record, _ := sf.Get(3)
+	record.WriteUInt64(0, 3)
+	sf.ReleaseInUse(record)
+
+	sf.Flush()
+	sf.Close()
+
+	// Records before a written record are written as empty records
+
+	drs := int64(sf.diskRecordSize())
+
+	if stat, _ := os.Stat(name + ".0"); stat.Size() != 4*drs {
+		t.Error("Unexpected file size:", stat.Size())
+		return
+	}
+
+	// Zero out record 1 on disk
+
+	f, _ := os.OpenFile(name+".0", os.O_RDWR, 0660)
+	f.WriteAt(make([]byte, drs), drs)
+	f.Close()
+
+	sf, _ = NewStorageFileWithKey(name, 10, true, testKey)
+	defer sf.Close()
+
+	for _, id := range []uint64{0, 3, 4} {
+		record, err := sf.Get(id)
+		if err != nil || (id == 3 && record.ReadUInt64(0) != 3) {
+			t.Error("Unexpected result:", id, record, err)
+			return
+		}
+		sf.ReleaseInUse(record)
+	}
+
+	// A zeroed record is not accepted as an empty record
+
+	if _, err := sf.Get(1); err == nil || err.(*StorageFileError).Type != ErrDecrypt {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestEncryptData(t *testing.T) {
+
+	sealed, err := EncryptData(testKey, []byte("test"))
+	if err != nil || len(sealed) != len("test")+EncryptionOverhead {
+		t.Error("Unexpected result:", sealed, err)
+		return
+	}
+
+	if res, err := DecryptData(testKey, sealed); err != nil || string(res) != "test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := DecryptData(testKey2, sealed); err != ErrDecrypt {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := DecryptData(testKey, sealed[:EncryptionOverhead-1]); err != ErrDecrypt {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := EncryptData([]byte("foo"), nil); err == nil {
+		t.Error("Encrypting with an invalid key should fail")
+		return
+	}
+
+	if _, err := DecryptData([]byte("foo"), sealed); err == nil {
+		t.Error("Decrypting with an invalid key should fail")
+		return
+	}
+}
@@ -0,0 +1,334 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+LogArchiveSuffix is the file suffix for transaction log archives
+*/
+const LogArchiveSuffix = "tla"
+
+/*
+LogArchiveHeader is the magic number to identify transaction log archives
+*/
+var LogArchiveHeader = []byte{0x66, 0x41}
+
+/*
+LogArchiveHeaderEncrypted is the magic number to identify transaction log archives
+which contain encrypted records
+*/
+var LogArchiveHeaderEncrypted = []byte{0x66, 0x46}
+
+/*
+logArchiveEntryHeaderSize is the size of the header of an archived transaction
+(commit time and length of the transaction data)
+*/
+const logArchiveEntryHeaderSize = 16
+
+/*
+LogArchiveName returns the name of the transaction log archive of a storage
+file in a given archive directory.
+*/
+func LogArchiveName(name string, dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.%s", filepath.Base(name), LogArchiveSuffix))
+}
+
+/*
+EnableLogArchive archives all transactions of this storage file in a given
+directory. Every committed transaction is appended together with its commit
+time to an archive file which is never truncated. A copy of the storage file
+together with its archive can be used to reconstruct the storage file at a
+given point in time (see ReplayLogArchive).
+*/
+func (s *StorageFile) EnableLogArchive(dir string) error {
+
+	if s.transDisabled {
+		return NewStorageFileError(ErrTransDisabled, "", s.name)
+	}
+
+	file, err := os.OpenFile(LogArchiveName(s.name, dir), os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err == nil && stat.Size() == 0 {
+		_, err = file.Write(s.logArchiveHeader())
+	}
+
+	if err == nil {
+		err = s.checkLogArchiveHeader(file)
+	}
+
+	// Find the end of the last complete transaction - an incomplete
+	// transaction at the end of the archive is discarded
+
+	var offset, last int64
+
+	if err == nil {
+		offset, last, err = readLogArchive(file, nil)
+	}
+
+	if err == nil {
+		err = file.Truncate(offset)
+	}
+
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.tm.closeArchive()
+	s.tm.archive = file
+	s.tm.lastArchived = last
+
+	return nil
+}
+
+/*
+LogArchiveTransactions returns the commit times (Unix time in nanoseconds) of
+all transactions in a transaction log archive.
+*/
+func LogArchiveTransactions(archive string) ([]int64, error) {
+	var ret []int64
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(LogArchiveHeader))
+
+	if i, _ := file.Read(magic); i != len(magic) ||
+		(!bytes.Equal(magic, LogArchiveHeader) && !bytes.Equal(magic, LogArchiveHeaderEncrypted)) {
+		return nil, NewStorageFileError(ErrBadMagic, "", archive)
+	}
+
+	_, _, err = readLogArchive(file, func(ts int64, r io.Reader) (bool, error) {
+		ret = append(ret, ts)
+		return true, nil
+	})
+
+	return ret, err
+}
+
+/*
+ReplayLogArchive writes all archived transactions of a closed storage file
+which were committed after a given time and before or at another given time
+(Unix time in nanoseconds) to the storage file. Pending transactions of the
+storage file are recovered first. Archived transactions contain complete
+records which means that transactions which are already part of the storage
+file can be replayed again. The archive must however contain all transactions
+since the storage file was copied. Returns the number of replayed transactions.
+*/
+func ReplayLogArchive(name string, recordSize uint32, key []byte,
+	archive string, from int64, until int64) (int, error) {
+
+	// Recover any pending transactions
+
+	sf, err := NewStorageFileWithKey(name, recordSize, false, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := sf.Close(); err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// Write archived records directly to the storage file
+
+	if sf, err = NewStorageFileWithKey(name, recordSize, true, key); err != nil {
+		return 0, err
+	}
+	defer sf.Close()
+
+	if err := sf.checkLogArchiveHeader(file); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	_, _, err = readLogArchive(file, func(ts int64, r io.Reader) (bool, error) {
+		var numRecords int64
+
+		if ts > until {
+			return false, nil
+		} else if ts <= from {
+			return true, nil
+		}
+
+		if err := binary.Read(r, binary.LittleEndian, &numRecords); err != nil {
+			return false, err
+		}
+
+		recMap, err := sf.readTransactionRecords(r, numRecords)
+		if err != nil {
+			return false, err
+		}
+
+		for _, record := range recMap {
+			if err := sf.writeRecord(record); err != nil {
+				return false, err
+			}
+		}
+
+		count++
+
+		return true, nil
+	})
+
+	sf.Sync()
+
+	return count, err
+}
+
+/*
+logArchiveHeader returns the magic number for the transaction log archive.
+*/
+func (s *StorageFile) logArchiveHeader() []byte {
+	if s.cipher != nil {
+		return LogArchiveHeaderEncrypted
+	}
+	return LogArchiveHeader
+}
+
+/*
+checkLogArchiveHeader checks that a given transaction log archive belongs to
+this storage file.
+*/
+func (s *StorageFile) checkLogArchiveHeader(file *os.File) error {
+	magic := make([]byte, len(LogArchiveHeader))
+
+	i, _ := file.ReadAt(magic, 0)
+
+	if i == len(magic) && !bytes.Equal(magic, s.logArchiveHeader()) &&
+		(bytes.Equal(magic, LogArchiveHeader) || bytes.Equal(magic, LogArchiveHeaderEncrypted)) {
+		return NewStorageFileError(ErrLogEncryption, "", s.name)
+	}
+
+	if i != len(magic) || !bytes.Equal(magic, s.logArchiveHeader()) {
+		return NewStorageFileError(ErrBadMagic, "", s.name)
+	}
+
+	return nil
+}
+
+/*
+readLogArchive reads all complete transactions of a transaction log archive.
+The given handler function is called with the commit time and the data of each
+transaction. Reading stops if the handler returns false. Transactions are
+skipped if no handler is given. Returns the offset after the last read
+transaction and its commit time.
+*/
+func readLogArchive(file *os.File, handler func(int64, io.Reader) (bool, error)) (int64, int64, error) {
+	var last int64
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset := int64(len(LogArchiveHeader))
+	header := make([]byte, logArchiveEntryHeaderSize)
+
+	for offset+logArchiveEntryHeaderSize <= stat.Size() {
+
+		if _, err := file.ReadAt(header, offset); err != nil {
+			return 0, 0, err
+		}
+
+		ts := int64(binary.LittleEndian.Uint64(header))
+		length := int64(binary.LittleEndian.Uint64(header[8:]))
+
+		if length < 0 || offset+logArchiveEntryHeaderSize+length > stat.Size() {
+			break
+		}
+
+		if handler != nil {
+			r := bufio.NewReader(io.NewSectionReader(file,
+				offset+logArchiveEntryHeaderSize, length))
+
+			if cont, err := handler(ts, r); err != nil {
+				return 0, 0, err
+			} else if !cont {
+				break
+			}
+		}
+
+		offset += logArchiveEntryHeaderSize + length
+		last = ts
+	}
+
+	return offset, last, nil
+}
+
+/*
+archiveTransaction appends the data of a committed transaction to the
+transaction log archive.
+*/
+func (t *TransactionManager) archiveTransaction(data []byte) error {
+
+	// Commit times must be unique and increasing
+
+	ts := time.Now().UnixNano()
+	if ts <= t.lastArchived {
+		ts = t.lastArchived + 1
+	}
+
+	entry := make([]byte, logArchiveEntryHeaderSize, logArchiveEntryHeaderSize+len(data))
+
+	binary.LittleEndian.PutUint64(entry, uint64(ts))
+	binary.LittleEndian.PutUint64(entry[8:], uint64(len(data)))
+
+	if _, err := t.archive.Write(append(entry, data...)); err != nil {
+		return err
+	}
+
+	t.lastArchived = ts
+
+	if t.owner.syncer != nil {
+		return t.owner.syncer.add(t.archive, int64(len(entry)+len(data)))
+	}
+
+	return t.archive.Sync()
+}
+
+/*
+closeArchive closes the transaction log archive.
+*/
+func (t *TransactionManager) closeArchive() {
+	if t.archive != nil {
+		t.archive.Sync()
+		t.archive.Close()
+		t.archive = nil
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/krotik/common/testutil"
+)
+
+func TestLogArchive(t *testing.T) {
+	name := DBDir + "/arc_test1"
+	archive := LogArchiveName(name, DBDir)
+
+	if archive != DBDir+"/arc_test1."+LogArchiveSuffix {
+		t.Error("Unexpected archive name:", archive)
+		return
+	}
+
+	sf, err := NewStorageFileWithKey(name, 10, false, testKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := sf.EnableLogArchive(DBDir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := uint64(1); i < 4; i++ {
+		record, _ := sf.Get(1)
+		record.WriteUInt64(0, i)
+		sf.ReleaseInUse(record)
+
+		if err := sf.Flush(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ts, err := LogArchiveTransactions(archive)
+	if err != nil || len(ts) != 3 || ts[0] >= ts[1] || ts[1] >= ts[2] {
+		t.Error("Unexpected archived transactions:", ts, err)
+		return
+	}
+
+	// Appending to an existing archive keeps all previous transactions and
+	// discards incomplete transactions at the end of the archive
+
+	f, _ := os.OpenFile(archive, os.O_APPEND|os.O_WRONLY, 0660)
+	f.Write([]byte{0x01, 0x02, 0x03})
+	f.Close()
+
+	sf, _ = NewStorageFileWithKey(name, 10, false, testKey)
+
+	if err := sf.EnableLogArchive(DBDir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	record, _ := sf.Get(1)
+	record.WriteUInt64(0, 4)
+	sf.ReleaseInUse(record)
+	sf.Flush()
+	sf.Close()
+
+	if ts2, err := LogArchiveTransactions(archive); err != nil || len(ts2) != 4 || ts2[3] <= ts[2] {
+		t.Error("Unexpected archived transactions:", ts2, err)
+		return
+	}
+
+	checkReplay := func(from int64, until int64, expectedCount int, expected uint64) {
+
+		// Start with an empty storage file
+
+		os.Remove(name + ".0")
+		os.Remove(name + "." + LogFileSuffix)
+
+		count, err := ReplayLogArchive(name, 10, testKey, archive, from, until)
+		if err != nil || count != expectedCount {
+			t.Error("Unexpected replay result:", count, err)
+			return
+		}
+
+		sf, _ := NewStorageFileWithKey(name, 10, false, testKey)
+		defer sf.Close()
+
+		record, _ := sf.Get(1)
+		defer sf.ReleaseInUse(record)
+
+		if res := record.ReadUInt64(0); res != expected {
+			t.Error("Unexpected replayed value:", res)
+		}
+	}
+
+	checkReplay(0, ts[0]-1, 0, 0)
+	checkReplay(0, ts[1], 2, 2)
+	checkReplay(0, math.MaxInt64, 4, 4)
+
+	// Transactions before the start time are skipped
+
+	checkReplay(ts[2], math.MaxInt64, 1, 4)
+
+	// Test error cases
+
+	if _, err := ReplayLogArchive(name, 10, nil, archive, 0, math.MaxInt64); err == nil ||
+		err.Error() != "Transaction log encryption does not match storage file (storagefiletest/arc_test1 - )" {
+		t.Error("Unexpected replay result:", err)
+		return
+	}
+
+	if _, err := ReplayLogArchive(name, 10, testKey, DBDir+"/arc_test2", 0, 0); err == nil {
+		t.Error("Missing archive should cause an error")
+		return
+	}
+
+	if _, err := LogArchiveTransactions(name + ".0"); err == nil ||
+		err.Error() != "Bad magic for transaction log (storagefiletest/arc_test1.0 - )" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sf, _ = NewStorageFile(DBDir+"/arc_test2", 10, true)
+
+	if err, ok := sf.EnableLogArchive(DBDir).(*StorageFileError); !ok || err.Type != ErrTransDisabled {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sf.Close()
+
+	sf, _ = NewStorageFile(DBDir+"/arc_test2", 10, false)
+
+	if err := sf.EnableLogArchive(DBDir + "/" + InvalidFileName); err == nil {
+		t.Error("Invalid archive directory should cause an error")
+		return
+	}
+
+	sf.EnableLogArchive(DBDir)
+	sf.tm.archive = testutil.NewTestingFile(0)
+
+	record, _ = sf.Get(1)
+	record.WriteUInt64(0, 1)
+	sf.ReleaseInUse(record)
+
+	if err := sf.Flush(); err == nil {
+		t.Error("Failed archive write operations should be reported")
+		return
+	}
+
+	sf.Close()
+}
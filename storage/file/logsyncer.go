@@ -0,0 +1,351 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+Sync modes for transaction logs
+
+SyncAlways writes every committed transaction to disk before the commit
+returns. A committed transaction survives a crash of the operating system.
+
+SyncGroup writes committed transactions to disk together. A sync happens once
+the sync window time has passed since the first unsynced commit or once the
+sync window size was written. Callers which need durability wait for the next
+sync (see LogSyncer.Wait) - concurrent commits can share a single sync. Once
+the wait returns the same guarantees as for SyncAlways apply.
+
+SyncPeriodic writes committed transactions to disk at regular intervals (the
+sync window time). Commits never wait for a sync. A crash of the operating
+system may lose all transactions which were committed during the last interval.
+A crash of the process itself does not lose any committed transactions.
+
+In all modes a transaction log is always written to disk before any of its
+transactions are written to the storage file itself. A crash can therefore only
+lose complete transactions of a storage file but never corrupt it.
+*/
+const (
+	SyncAlways   = 0
+	SyncGroup    = 1
+	SyncPeriodic = 2
+)
+
+/*
+DefaultSyncWindow is the default time window for SyncGroup and SyncPeriodic
+*/
+const DefaultSyncWindow = 10 * time.Millisecond
+
+/*
+ErrUnknownSyncMode is returned if an unknown sync mode is requested.
+*/
+var ErrUnknownSyncMode = errors.New("Unknown sync mode")
+
+/*
+SyncModes maps sync mode names to sync modes.
+*/
+var SyncModes = map[string]int{
+	"always":   SyncAlways,
+	"group":    SyncGroup,
+	"periodic": SyncPeriodic,
+}
+
+/*
+SyncMode returns the sync mode for a given name.
+*/
+func SyncMode(name string) (int, error) {
+	mode, ok := SyncModes[name]
+	if !ok {
+		return 0, fmt.Errorf("%v: %v", ErrUnknownSyncMode, name)
+	}
+	return mode, nil
+}
+
+/*
+SyncPolicy describes when transaction logs are written to disk.
+*/
+type SyncPolicy struct {
+	Mode        int           // Sync mode (see SyncAlways, ...)
+	WindowTime  time.Duration // Max time between a commit and the next sync (0 for DefaultSyncWindow)
+	WindowBytes int64         // Approximate number of written bytes which cause a sync in SyncGroup mode (0 for no limit)
+}
+
+/*
+LogSyncer writes transaction logs to disk according to a sync policy. A
+LogSyncer can be shared by several storage files. Syncing all transaction logs
+together reduces the number of syncs if many small transactions are committed.
+*/
+type LogSyncer struct {
+	policy SyncPolicy // Sync policy
+
+	mutex        *sync.Mutex      // Mutex to protect the pending files
+	cond         *sync.Cond       // Condition to wait for a sync
+	syncMutex    *sync.Mutex      // Mutex to make sure only one sync runs at a time
+	pending      map[LogFile]bool // Files with unsynced data
+	pendingBytes int64            // Approximate number of unsynced bytes
+	written      uint64           // Number of registered writes
+	synced       uint64           // Number of registered writes which have been synced
+	err          error            // Error of a failed sync
+	done         chan struct{}    // Channel which is closed once the next sync has finished
+
+	trigger chan bool // Channel which signals pending data
+	full    chan bool // Channel which signals that the window size was reached
+	stop    chan bool // Channel which stops the background sync
+	stopped chan bool // Channel which signals that the background sync has stopped
+}
+
+/*
+NewLogSyncer creates a new LogSyncer with a given sync policy. A background
+goroutine syncs all transaction logs unless the mode is SyncAlways. The
+LogSyncer must be closed once all its storage files have been closed.
+*/
+func NewLogSyncer(policy SyncPolicy) (*LogSyncer, error) {
+
+	if _, ok := map[int]bool{SyncAlways: true, SyncGroup: true, SyncPeriodic: true}[policy.Mode]; !ok {
+		return nil, ErrUnknownSyncMode
+	}
+
+	if policy.WindowTime <= 0 {
+		policy.WindowTime = DefaultSyncWindow
+	}
+
+	mutex := &sync.Mutex{}
+
+	ls := &LogSyncer{policy, mutex, sync.NewCond(mutex), &sync.Mutex{},
+		make(map[LogFile]bool), 0, 0, 0, nil, make(chan struct{}),
+		make(chan bool, 1), make(chan bool, 1), make(chan bool), make(chan bool)}
+
+	if policy.Mode != SyncAlways {
+		go ls.run()
+	} else {
+		close(ls.stopped)
+	}
+
+	return ls, nil
+}
+
+/*
+Policy returns the sync policy of this LogSyncer.
+*/
+func (ls *LogSyncer) Policy() SyncPolicy {
+	return ls.policy
+}
+
+/*
+Wait blocks until all transactions which were committed before the call have
+been written to disk. Wait only blocks in SyncGroup mode. Returns an error if a
+sync has failed - once a sync has failed all following calls return the error
+since it is unknown which data was written.
+*/
+func (ls *LogSyncer) Wait() error {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if ls.policy.Mode == SyncGroup {
+		target := ls.written
+
+		for ls.synced < target && ls.err == nil {
+			ls.cond.Wait()
+		}
+	}
+
+	return ls.err
+}
+
+/*
+Mark returns a mark for all data which was written to the transaction logs
+before the call (see Synced).
+*/
+func (ls *LogSyncer) Mark() uint64 {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.written
+}
+
+/*
+Synced returns if all data which is covered by a given mark has been written
+to disk. Returns false once a sync has failed.
+*/
+func (ls *LogSyncer) Synced(mark uint64) bool {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.err == nil && ls.synced >= mark
+}
+
+/*
+SyncNotify returns a channel which is closed once the next sync has finished.
+*/
+func (ls *LogSyncer) SyncNotify() <-chan struct{} {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.done
+}
+
+/*
+Sync writes all pending data of all transaction logs to disk.
+*/
+func (ls *LogSyncer) Sync() error {
+	ls.syncMutex.Lock()
+	defer ls.syncMutex.Unlock()
+
+	ls.mutex.Lock()
+	files := ls.pending
+	target := ls.written
+	ls.pending = make(map[LogFile]bool)
+	ls.pendingBytes = 0
+	ls.mutex.Unlock()
+
+	var err error
+
+	for f := range files {
+		if serr := f.Sync(); serr != nil && !isClosedError(serr) {
+			err = serr
+		}
+	}
+
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if err != nil && ls.err == nil {
+		ls.err = err
+	}
+
+	ls.synced = target
+	ls.cond.Broadcast()
+
+	close(ls.done)
+	ls.done = make(chan struct{})
+
+	return err
+}
+
+/*
+Close syncs all pending data and stops the background sync.
+*/
+func (ls *LogSyncer) Close() error {
+
+	if ls.policy.Mode != SyncAlways {
+		close(ls.stop)
+		<-ls.stopped
+	}
+
+	ls.Sync()
+
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.err
+}
+
+/*
+add registers data which was written to a given transaction log. The data is
+written to disk immediately in SyncAlways mode.
+*/
+func (ls *LogSyncer) add(file LogFile, size int64) error {
+
+	if ls.policy.Mode == SyncAlways {
+		return file.Sync()
+	}
+
+	ls.mutex.Lock()
+
+	ls.pending[file] = true
+	ls.pendingBytes += size
+	ls.written++
+
+	full := ls.policy.WindowBytes > 0 && ls.pendingBytes >= ls.policy.WindowBytes
+
+	ls.mutex.Unlock()
+
+	// Signal the background sync without blocking
+
+	select {
+	case ls.trigger <- true:
+	default:
+	}
+
+	if full {
+		select {
+		case ls.full <- true:
+		default:
+		}
+	}
+
+	return nil
+}
+
+/*
+run is the background sync loop.
+*/
+func (ls *LogSyncer) run() {
+	defer close(ls.stopped)
+
+	if ls.policy.Mode == SyncPeriodic {
+		ticker := time.NewTicker(ls.policy.WindowTime)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ls.stop:
+				return
+			case <-ticker.C:
+				ls.Sync()
+			}
+		}
+	}
+
+	for {
+
+		// Wait for the first unsynced commit
+
+		select {
+		case <-ls.stop:
+			return
+		case <-ls.trigger:
+		}
+
+		// Give other commits the chance to join this sync
+
+		timer := time.NewTimer(ls.policy.WindowTime)
+
+		select {
+		case <-ls.stop:
+			timer.Stop()
+			return
+		case <-ls.full:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+
+		ls.Sync()
+	}
+}
+
+/*
+isClosedError checks if a given error was caused by syncing a closed file.
+Transaction logs are always synced before they are closed.
+*/
+func isClosedError(err error) bool {
+	if perr, ok := err.(*os.PathError); ok {
+		return perr.Err == os.ErrClosed
+	}
+	return err == os.ErrClosed
+}
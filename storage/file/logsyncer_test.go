@@ -0,0 +1,361 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+testLogFile is a log file which counts its syncs.
+*/
+type testLogFile struct {
+	mutex *sync.Mutex
+	syncs int
+	err   error
+}
+
+func newTestLogFile() *testLogFile {
+	return &testLogFile{&sync.Mutex{}, 0, nil}
+}
+
+func (tlf *testLogFile) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (tlf *testLogFile) Close() error {
+	return nil
+}
+
+func (tlf *testLogFile) Sync() error {
+	tlf.mutex.Lock()
+	defer tlf.mutex.Unlock()
+	tlf.syncs++
+	return tlf.err
+}
+
+func (tlf *testLogFile) Syncs() int {
+	tlf.mutex.Lock()
+	defer tlf.mutex.Unlock()
+	return tlf.syncs
+}
+
+func TestSyncMode(t *testing.T) {
+
+	if res, err := SyncMode("group"); res != SyncGroup || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := SyncMode("foo"); err == nil || err.Error() != "Unknown sync mode: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewLogSyncer(SyncPolicy{Mode: 5}); err != ErrUnknownSyncMode {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ls, _ := NewLogSyncer(SyncPolicy{Mode: SyncPeriodic})
+
+	if res := ls.Policy(); res.WindowTime != DefaultSyncWindow {
+		t.Error("Unexpected policy:", res)
+		return
+	}
+
+	ls.Close()
+}
+
+func TestLogSyncerAlways(t *testing.T) {
+	tlf := newTestLogFile()
+
+	ls, _ := NewLogSyncer(SyncPolicy{Mode: SyncAlways})
+
+	ls.add(tlf, 10)
+	ls.add(tlf, 10)
+
+	if res := tlf.Syncs(); res != 2 {
+		t.Error("Unexpected number of syncs:", res)
+		return
+	}
+
+	if err := ls.Wait(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ls.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestLogSyncerGroup(t *testing.T) {
+	tlf := newTestLogFile()
+
+	ls, _ := NewLogSyncer(SyncPolicy{Mode: SyncGroup, WindowTime: 50 * time.Millisecond})
+
+	// Concurrent commits share a single sync
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ls.add(tlf, 10)
+
+			if err := ls.Wait(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if res := tlf.Syncs(); res == 0 || res >= 10 {
+		t.Error("Unexpected number of syncs:", res)
+		return
+	}
+
+	// Waiting without pending data does not block
+
+	if err := ls.Wait(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ls.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A full window triggers a sync immediately
+
+	tlf = newTestLogFile()
+
+	ls, _ = NewLogSyncer(SyncPolicy{Mode: SyncGroup, WindowTime: time.Hour, WindowBytes: 100})
+
+	ls.add(tlf, 60)
+	ls.add(tlf, 60)
+
+	done := make(chan error)
+
+	go func() {
+		done <- ls.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Full window did not trigger a sync")
+		return
+	}
+
+	if res := tlf.Syncs(); res != 1 {
+		t.Error("Unexpected number of syncs:", res)
+		return
+	}
+
+	// Closing syncs all pending data
+
+	ls.add(tlf, 10)
+
+	if err := ls.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := tlf.Syncs(); res != 2 {
+		t.Error("Unexpected number of syncs:", res)
+		return
+	}
+}
+
+func TestLogSyncerPeriodic(t *testing.T) {
+	tlf := newTestLogFile()
+
+	ls, _ := NewLogSyncer(SyncPolicy{Mode: SyncPeriodic, WindowTime: time.Hour})
+
+	if !ls.Synced(ls.Mark()) {
+		t.Error("Nothing was written yet")
+		return
+	}
+
+	ch := ls.SyncNotify()
+
+	ls.add(tlf, 10)
+
+	// Commits never wait in periodic mode
+
+	if err := ls.Wait(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	mark := ls.Mark()
+
+	if ls.Synced(mark) {
+		t.Error("Data should not be synced yet")
+		return
+	}
+
+	if err := ls.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case <-ch:
+	default:
+		t.Error("Sync was not notified")
+		return
+	}
+
+	if res := tlf.Syncs(); res != 1 || !ls.Synced(mark) {
+		t.Error("Unexpected number of syncs:", res)
+		return
+	}
+
+	if err := ls.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestLogSyncerErrors(t *testing.T) {
+	tlf := newTestLogFile()
+	tlf.err = errors.New("TestError")
+
+	ls, _ := NewLogSyncer(SyncPolicy{Mode: SyncGroup, WindowTime: time.Millisecond})
+
+	ls.add(tlf, 10)
+
+	if err := ls.Wait(); err == nil || err.Error() != "TestError" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// A failed sync is reported to all later callers
+
+	tlf.err = nil
+	ls.add(tlf, 10)
+
+	if err := ls.Wait(); err == nil || err.Error() != "TestError" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if ls.Synced(ls.Mark()) || ls.Synced(0) {
+		t.Error("Data should never be synced after a failed sync")
+		return
+	}
+
+	if err := ls.Close(); err == nil || err.Error() != "TestError" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Closed files are ignored
+
+	f, _ := os.Create(DBDir + "/logsyncer_test1")
+	f.Close()
+
+	ls, _ = NewLogSyncer(SyncPolicy{Mode: SyncGroup, WindowTime: time.Millisecond})
+
+	ls.add(f, 10)
+
+	if err := ls.Wait(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ls.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestLogSyncerStorageFile(t *testing.T) {
+	name := DBDir + "/logsyncer_test2"
+
+	os.Remove(LogArchiveName(name, DBDir))
+
+	ls, _ := NewLogSyncer(SyncPolicy{Mode: SyncGroup, WindowTime: time.Millisecond})
+
+	sf, err := NewStorageFileWithOptions(name, 10, false, StorageFileOptions{Syncer: ls})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := sf.EnableLogArchive(DBDir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := uint64(1); i < 4; i++ {
+		record, _ := sf.Get(i)
+		record.WriteUInt64(0, i)
+		sf.ReleaseInUse(record)
+
+		if err := sf.Flush(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := ls.Wait(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ls.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ts, err := LogArchiveTransactions(LogArchiveName(name, DBDir)); err != nil || len(ts) != 3 {
+		t.Error("Unexpected archived transactions:", ts, err)
+		return
+	}
+
+	sf, _ = NewStorageFile(name, 10, false)
+
+	for i := uint64(1); i < 4; i++ {
+		record, _ := sf.Get(i)
+
+		if res := record.ReadUInt64(0); res != i {
+			t.Error("Unexpected record value:", res)
+			return
+		}
+
+		sf.ReleaseInUse(record)
+	}
+
+	sf.Close()
+}
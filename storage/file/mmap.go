@@ -0,0 +1,35 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import "os"
+
+/*
+MemoryMapped returns if records are read from memory mappings of the physical
+files.
+*/
+func (s *StorageFile) MemoryMapped() bool {
+	return s.memoryMapped
+}
+
+/*
+readAt reads the data of a record at a given offset. The data is copied from
+a memory mapping if possible. Records are always copied since other components
+are allowed to modify the data of a record.
+*/
+func (s *StorageFile) readAt(file *os.File, data []byte, offset uint64) (int, error) {
+
+	if s.memoryMapped && s.readMapped(file, data, offset) {
+		return len(data), nil
+	}
+
+	return file.ReadAt(data, int64(offset%s.maxFileSize))
+}
@@ -0,0 +1,76 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+MemoryMappingSupported is a flag if memory mapped reads are supported on this
+platform
+*/
+const MemoryMappingSupported = true
+
+/*
+readMapped copies the data of a record at a given offset from the memory
+mapping of a physical file. The mapping is extended if the file has grown
+since it was mapped. Returns false if the data is not inside the file.
+*/
+func (s *StorageFile) readMapped(file *os.File, data []byte, offset uint64) bool {
+	filenumber := int(offset / s.maxFileSize)
+	start := offset % s.maxFileSize
+	end := start + uint64(len(data))
+
+	for i := len(s.mappings); i <= filenumber; i++ {
+		s.mappings = append(s.mappings, nil)
+	}
+
+	if uint64(len(s.mappings[filenumber])) < end {
+
+		// Writes go directly to the file and are visible in a shared
+		// mapping - the mapping only needs to grow with the file
+
+		stat, err := file.Stat()
+		if err != nil || uint64(stat.Size()) < end {
+			return false
+		}
+
+		mapping, err := syscall.Mmap(int(file.Fd()), 0, int(stat.Size()),
+			syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return false
+		}
+
+		if s.mappings[filenumber] != nil {
+			syscall.Munmap(s.mappings[filenumber])
+		}
+
+		s.mappings[filenumber] = mapping
+	}
+
+	copy(data, s.mappings[filenumber][start:end])
+
+	return true
+}
+
+/*
+unmapFiles removes all memory mappings.
+*/
+func (s *StorageFile) unmapFiles() {
+	for _, mapping := range s.mappings {
+		if mapping != nil {
+			syscall.Munmap(mapping)
+		}
+	}
+	s.mappings = nil
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import "os"
+
+/*
+MemoryMappingSupported is a flag if memory mapped reads are supported on this
+platform
+*/
+const MemoryMappingSupported = false
+
+/*
+readMapped is not supported on this platform.
+*/
+func (s *StorageFile) readMapped(file *os.File, data []byte, offset uint64) bool {
+	return false
+}
+
+/*
+unmapFiles is not supported on this platform.
+*/
+func (s *StorageFile) unmapFiles() {
+}
@@ -0,0 +1,115 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"testing"
+)
+
+func TestMemoryMappedReads(t *testing.T) {
+
+	for _, key := range [][]byte{nil, testKey} {
+		name := DBDir + "/mmap_test1"
+
+		if key != nil {
+			name = DBDir + "/mmap_test2"
+		}
+
+		sf, err := NewStorageFileWithOptions(name, 10, true,
+			StorageFileOptions{EncryptionKey: key, MemoryMapped: true})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if sf.MemoryMapped() != MemoryMappingSupported {
+			t.Error("Unexpected memory mapping flag:", sf.MemoryMapped())
+			return
+		}
+
+		writeRecord := func(id uint64, val uint64) {
+			record, err := sf.Get(id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			record.WriteUInt64(0, val)
+			sf.ReleaseInUse(record)
+
+			if err := sf.Flush(); err != nil {
+				t.Error(err)
+			}
+
+			// Remove the record from the memory cache
+
+			record, _ = sf.Get(id)
+			sf.Discard(record)
+		}
+
+		checkRecord := func(id uint64, expected uint64) {
+			record, err := sf.Get(id)
+			if err != nil || record.ReadUInt64(0) != expected {
+				t.Error("Unexpected record:", id, record, err)
+				return
+			}
+			sf.Discard(record)
+		}
+
+		writeRecord(1, 1)
+		checkRecord(1, 1)
+		checkRecord(0, 0)
+
+		// The mapping grows with the file
+
+		writeRecord(5, 5)
+		checkRecord(5, 5)
+		checkRecord(1, 1)
+
+		// Changes are visible in the mapping
+
+		writeRecord(1, 42)
+		checkRecord(1, 42)
+
+		// Records beyond the end of the file are empty
+
+		checkRecord(10, 0)
+
+		if err := sf.Truncate(2); err != nil {
+			t.Error(err)
+			return
+		}
+
+		checkRecord(5, 0)
+		checkRecord(1, 42)
+
+		if err := sf.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Transactions are written to the files before they are read
+
+		sf, _ = NewStorageFileWithOptions(name, 10, false,
+			StorageFileOptions{EncryptionKey: key, MemoryMapped: true})
+
+		writeRecord(3, 3)
+
+		sf.Close()
+
+		sf, _ = NewStorageFileWithOptions(name, 10, false,
+			StorageFileOptions{EncryptionKey: key, MemoryMapped: true})
+
+		checkRecord(1, 42)
+		checkRecord(3, 3)
+
+		sf.Close()
+	}
+}
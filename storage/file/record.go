@@ -36,6 +36,13 @@ maxTrans is reached or the StorageFile is closed.
 
 Should the process crash during a transaction, then the transaction log is
 written to the StorageFile on the next startup using the recover() function.
+
+Encryption
+
+A StorageFile can optionally encrypt all records with an AES key. Records
+are sealed with AES-GCM using the record id as additional authenticated data.
+Encrypted records need a few more bytes on disk (see EncryptionOverhead). The
+transaction log of an encrypted StorageFile contains only encrypted records.
 */
 package file
 
@@ -0,0 +1,258 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+/*
+logRecordHeaderSize is the size of the header of a record in a transaction
+log (id, dirty flag, transaction count and data length).
+*/
+const logRecordHeaderSize = 25
+
+/*
+maxRefreshAttempts is the number of attempts to refresh a replica while the
+writing process changes the physical files.
+*/
+const maxRefreshAttempts = 20
+
+/*
+refreshRetryDelay is the time to wait before a refresh is attempted again.
+*/
+const refreshRetryDelay = 10 * time.Millisecond
+
+/*
+IsReplica returns if this storage file is a read-only replica of a storage
+file which is written by another process.
+*/
+func (s *StorageFile) IsReplica() bool {
+	return s.replica
+}
+
+/*
+Refresh discards all cached records of a replica and reads all transactions
+which were committed by the writing process. Committed transactions which
+have not yet been written to the physical files are read from the transaction
+log of the writing process. No records must be in use while the replica is
+refreshed.
+
+The writing process only changes the physical files and truncates the
+transaction log during a checkpoint. The transaction log is only used if the
+checkpoint counter of the writing process (see TransactionManager) did not
+change while it was read. Records which are read from the physical files after
+the next checkpoint of the writing process are rejected with an
+ErrReplicaStale error until the replica is refreshed again. A refresh
+therefore always provides a consistent view of the storage file.
+*/
+func (s *StorageFile) Refresh() error {
+
+	if !s.replica {
+		return NewStorageFileError(ErrNotReplica, "", s.name)
+	}
+
+	if len(s.inUse) > 0 {
+		return NewStorageFileError(ErrInUse, fmt.Sprintf("Records %v", len(s.inUse)), s.name)
+	}
+
+	// Physical files are opened again since the writing process may have
+	// replaced them (e.g. during compaction)
+
+	s.closeReplicaFiles()
+
+	for i := 0; i < maxRefreshAttempts; i++ {
+
+		before, err := s.readCheckpoint()
+		if err != nil {
+			return err
+		}
+
+		// The physical files are not changed while the counter is even
+
+		if before%2 == 0 {
+			replicaLog, err := s.readReplicaLog()
+			if err != nil {
+				return err
+			}
+
+			if after, err := s.readCheckpoint(); err != nil {
+				return err
+			} else if after == before {
+				s.replicaLog = replicaLog
+				s.replicaCheckpoint = before
+				s.free = make(map[uint64]*Record)
+
+				return nil
+			}
+		}
+
+		time.Sleep(refreshRetryDelay)
+	}
+
+	return NewStorageFileError(ErrReplicaStale, "Writing process is changing the files", s.name)
+}
+
+/*
+checkReplicaCheckpoint checks that the writing process did not start a
+checkpoint since the replica was refreshed.
+*/
+func (s *StorageFile) checkReplicaCheckpoint() error {
+
+	checkpoint, err := s.readCheckpoint()
+	if err != nil {
+		return err
+	} else if checkpoint != s.replicaCheckpoint {
+		return NewStorageFileError(ErrReplicaStale, "", s.name)
+	}
+
+	return nil
+}
+
+/*
+readCheckpoint reads the checkpoint counter of the writing process. Returns 0
+if the writing process has not yet written a checkpoint counter.
+*/
+func (s *StorageFile) readCheckpoint() (uint64, error) {
+	var buf [8]byte
+
+	if s.checkpointFile == nil {
+		file, err := os.Open(fmt.Sprintf("%s.%s", s.name, CheckpointFileSuffix))
+		if os.IsNotExist(err) {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		s.checkpointFile = file
+	}
+
+	if n, err := s.checkpointFile.ReadAt(buf[:], 0); n < len(buf) {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+/*
+closeReplicaFiles closes all files which a replica has opened.
+*/
+func (s *StorageFile) closeReplicaFiles() {
+
+	for _, file := range s.files {
+		if file != nil {
+			file.Close()
+		}
+	}
+
+	s.files = make([]*os.File, 0)
+
+	if s.checksumFile != nil {
+		s.checksumFile.Close()
+		s.checksumFile = nil
+	}
+
+	if s.checkpointFile != nil {
+		s.checkpointFile.Close()
+		s.checkpointFile = nil
+	}
+}
+
+/*
+readReplicaLog reads the records of all complete transactions from the
+transaction log of the writing process. An incomplete transaction at the end
+of the log is ignored.
+*/
+func (s *StorageFile) readReplicaLog() (map[uint64][]byte, error) {
+
+	data, err := ioutil.ReadFile(fmt.Sprintf("%s.%s", s.name, LogFileSuffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[uint64][]byte), nil
+		}
+		return nil, err
+	}
+
+	return s.parseReplicaLog(data)
+}
+
+/*
+parseReplicaLog parses the records of all complete transactions in a given
+transaction log. Records of later transactions replace records of earlier
+transactions.
+*/
+func (s *StorageFile) parseReplicaLog(data []byte) (map[uint64][]byte, error) {
+	ret := make(map[uint64][]byte)
+
+	header := TransactionLogHeader
+	if s.cipher != nil {
+		header = TransactionLogHeaderEncrypted
+	}
+
+	if len(data) < len(header) {
+		return ret, nil
+
+	} else if magic := data[:len(header)]; !bytes.Equal(magic, header) {
+
+		if bytes.Equal(magic, TransactionLogHeader) || bytes.Equal(magic, TransactionLogHeaderEncrypted) {
+			return nil, NewStorageFileError(ErrLogEncryption, "", s.name)
+		}
+
+		return nil, NewStorageFileError(ErrBadMagic, "", s.name)
+	}
+
+	recordSize := uint64(s.diskRecordSize())
+	pos := uint64(len(header))
+
+	for pos+8 <= uint64(len(data)) {
+		numRecords := binary.LittleEndian.Uint64(data[pos:])
+		start := pos + 8
+		end := start
+
+		// Check that the transaction is complete before it is read - the
+		// writing process may be in the middle of writing it
+
+		for i := uint64(0); i < numRecords; i++ {
+			if end+logRecordHeaderSize > uint64(len(data)) ||
+				binary.LittleEndian.Uint64(data[end+logRecordHeaderSize-8:]) != recordSize {
+				return ret, nil
+			}
+
+			end += logRecordHeaderSize + recordSize
+
+			if end > uint64(len(data)) {
+				return ret, nil
+			}
+		}
+
+		recMap, err := s.readTransactionRecords(bytes.NewReader(data[start:end]), int64(numRecords))
+		if err != nil {
+			return ret, nil
+		}
+
+		for id, record := range recMap {
+			ret[id] = record.Data()
+		}
+
+		pos = end
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,418 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func writeReplicaTestByte(t *testing.T, sf *StorageFile, id uint64, b byte) {
+	record, err := sf.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record.WriteSingleByte(5, b)
+	sf.ReleaseInUse(record)
+
+	if err := sf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readReplicaTestByte(t *testing.T, sf *StorageFile, id uint64) byte {
+	record, err := sf.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.ReleaseInUse(record)
+
+	return record.ReadSingleByte(5)
+}
+
+func TestReplica(t *testing.T) {
+	testReplica(t, DBDir+"/replica1", nil)
+}
+
+func TestReplicaEncrypted(t *testing.T) {
+	testReplica(t, DBDir+"/replica2", testKey)
+}
+
+func testReplica(t *testing.T, name string, key []byte) {
+
+	// A replica can be opened before anything was written
+
+	replica, err := NewStorageFileWithOptions(name, 10, false,
+		StorageFileOptions{EncryptionKey: key, MemoryMapped: true, Replica: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !replica.IsReplica() || replica.MemoryMapped() {
+		t.Error("Unexpected replica state")
+		return
+	}
+
+	if res := readReplicaTestByte(t, replica, 1); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	sf, err := NewStorageFileWithOptions(name, 10, false,
+		StorageFileOptions{EncryptionKey: key, Checksums: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if sf.IsReplica() {
+		t.Error("Unexpected replica state")
+		return
+	}
+
+	// Committed transactions are read from the transaction log
+
+	writeReplicaTestByte(t, sf, 1, 0x42)
+
+	if res := readReplicaTestByte(t, replica, 1); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := replica.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := readReplicaTestByte(t, replica, 1); res != 0x42 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Later transactions replace earlier ones
+
+	writeReplicaTestByte(t, sf, 1, 0x43)
+	writeReplicaTestByte(t, sf, 2, 0x44)
+
+	if err := replica.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := readReplicaTestByte(t, replica, 1); res != 0x43 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := readReplicaTestByte(t, replica, 2); res != 0x44 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Write enough transactions so the log is written to the physical files
+	// and truncated
+
+	for i := 0; i < DefaultTransInLog; i++ {
+		writeReplicaTestByte(t, sf, uint64(3+i), byte(i+1))
+	}
+
+	if err := replica.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := replica.replicaLog[1]; ok || len(replica.replicaLog) != 3 {
+		t.Error("Unexpected replica log:", len(replica.replicaLog))
+		return
+	}
+
+	for i := 0; i < DefaultTransInLog; i++ {
+		if res := readReplicaTestByte(t, replica, uint64(3+i)); res != byte(i+1) {
+			t.Error("Unexpected result:", i, res)
+			return
+		}
+	}
+
+	if res := readReplicaTestByte(t, replica, 1); res != 0x43 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Records cannot be read from the physical files once the writing
+	// process has changed them
+
+	for i := 0; i < DefaultTransInLog; i++ {
+		writeReplicaTestByte(t, sf, 20, byte(i+1))
+	}
+
+	if _, err := replica.Get(20); err == nil || err.Error() != fmt.Sprintf(
+		"Storage file was changed since the replica was refreshed (%v - )", name) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := replica.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := readReplicaTestByte(t, replica, 20); res != DefaultTransInLog {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A replica cannot be written to
+
+	record, _ := replica.Get(1)
+	record.WriteSingleByte(5, 0x01)
+
+	if err := replica.Refresh(); err == nil || err.Error() != fmt.Sprintf(
+		"Records are still in-use (%v - Records 1)", name) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	replica.ReleaseInUse(record)
+
+	if err := replica.Flush(); err == nil || err.Error() != fmt.Sprintf(
+		"Storage file is a read-only replica (%v - Records 1)", name) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := replica.Truncate(1); err == nil || err.Error() != fmt.Sprintf(
+		"Storage file is a read-only replica (%v - )", name) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := replica.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := sf.Refresh(); err == nil || err.Error() != fmt.Sprintf(
+		"Storage file is not a replica (%v - )", name) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestReplicaCheckpoint(t *testing.T) {
+	name := DBDir + "/replica4"
+
+	sf, err := NewStorageFile(name, 10, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	replica, err := NewStorageFileWithOptions(name, 10, false, StorageFileOptions{Replica: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Every transaction i of the writer writes i to one of 20 records and
+	// to a counter record - no record is ever greater than the counter. The
+	// writer checkpoints every DefaultTransInLog transactions.
+
+	const counter = 100
+	const transactions = 30 * DefaultTransInLog
+
+	done := make(chan bool)
+
+	go func() {
+		for i := 1; i <= transactions; i++ {
+			for _, id := range []uint64{uint64(i%20 + 1), counter} {
+				record, _ := sf.Get(id)
+				record.WriteUInt64(0, uint64(i))
+				sf.ReleaseInUse(record)
+			}
+			sf.Flush()
+		}
+		close(done)
+	}()
+
+	isStale := func(err error) bool {
+		sfe, ok := err.(*StorageFileError)
+		return ok && sfe.Type == ErrReplicaStale
+	}
+
+	var reads, stale int
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		if err := replica.Refresh(); err != nil {
+			if !isStale(err) {
+				t.Error(err)
+				return
+			}
+			continue
+		}
+
+		record, err := replica.Get(counter)
+		if err != nil {
+			if !isStale(err) {
+				t.Error(err)
+				return
+			}
+			stale++
+			continue
+		}
+
+		max := record.ReadUInt64(0)
+		replica.ReleaseInUse(record)
+
+		// Read the other records slowly while the writer continues
+
+		for id := uint64(1); id <= 20; id++ {
+			time.Sleep(100 * time.Microsecond)
+
+			record, err := replica.Get(id)
+			if err != nil {
+				if !isStale(err) {
+					t.Error(err)
+					return
+				}
+				stale++
+				break
+			}
+
+			res := record.ReadUInt64(0)
+			replica.ReleaseInUse(record)
+
+			if res > max {
+				t.Error("Inconsistent replica view:", id, res, max)
+				return
+			}
+		}
+
+		reads++
+	}
+
+	if reads == 0 || stale == 0 {
+		t.Error("Unexpected reads:", reads, stale)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The counter is even once the writer is closed
+
+	if res, _ := replica.readCheckpoint(); res == 0 || res%2 != 0 {
+		t.Error("Unexpected checkpoint counter:", res)
+		return
+	}
+
+	if err := replica.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if record, err := replica.Get(counter); err != nil || record.ReadUInt64(0) != transactions {
+		t.Error("Unexpected result:", record, err)
+		return
+	}
+
+	replica.Close()
+}
+
+func TestReplicaLog(t *testing.T) {
+	name := DBDir + "/replica3"
+
+	sf, err := NewStorageFile(name, 10, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	writeReplicaTestByte(t, sf, 1, 0x42)
+	writeReplicaTestByte(t, sf, 2, 0x43)
+
+	log, err := ioutil.ReadFile(name + "." + LogFileSuffix)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf.Close()
+
+	replica, err := NewStorageFileWithOptions(name, 10, false, StorageFileOptions{Replica: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer replica.Close()
+
+	// All complete transactions of a log are read
+
+	if res, err := replica.parseReplicaLog(log); err != nil || len(res) != 2 ||
+		res[1][5] != 0x42 || res[2][5] != 0x43 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Incomplete transactions at the end of the log are ignored
+
+	for _, l := range []int{len(log) - 1, len(log) - 10, len(log) - 30, len(log) - 40} {
+		if res, err := replica.parseReplicaLog(log[:l]); err != nil || len(res) != 1 || res[1][5] != 0x42 {
+			t.Error("Unexpected result:", l, res, err)
+			return
+		}
+	}
+
+	if res, err := replica.parseReplicaLog(log[:1]); err != nil || len(res) != 0 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Corrupted records end the log
+
+	corrupted := append([]byte(nil), log...)
+	corrupted[len(log)-15] = 0xFF
+
+	if res, err := replica.parseReplicaLog(corrupted); err != nil || len(res) != 1 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Logs of other files cannot be read
+
+	if _, err := replica.parseReplicaLog([]byte("xxxx")); err == nil || err.Error() != fmt.Sprintf(
+		"Bad magic for transaction log (%v - )", name) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := replica.parseReplicaLog(TransactionLogHeaderEncrypted); err == nil || err.Error() != fmt.Sprintf(
+		"Transaction log encryption does not match storage file (%v - )", name) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -12,6 +12,7 @@ package file
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"io"
@@ -30,6 +31,9 @@ var (
 	ErrTransDisabled = errors.New("Transactions are disabled")
 	ErrInTrans       = errors.New("Records are still in a transaction")
 	ErrNilData       = errors.New("Record has nil data")
+	ErrReplica       = errors.New("Storage file is a read-only replica")
+	ErrNotReplica    = errors.New("Storage file is not a replica")
+	ErrReplicaStale  = errors.New("Storage file was changed since the replica was refreshed")
 )
 
 /*
@@ -59,6 +63,33 @@ type StorageFile struct {
 	files []*os.File // List of storage files
 
 	tm *TransactionManager // Manager object for transactions
+
+	cipher       cipher.AEAD // Cipher for encrypted records (nil if records are not encrypted)
+	checksums    bool        // Flag if checksums of all records are stored
+	checksumFile *os.File    // Checksum table (opened on first use)
+
+	memoryMapped bool     // Flag if records are read from memory mappings
+	mappings     [][]byte // Memory mappings of the physical files
+
+	syncer *LogSyncer // Syncer for the transaction log (nil if every commit is synced)
+
+	replica           bool              // Flag if this is a read-only replica of a storage file which is written by another process
+	replicaLog        map[uint64][]byte // Records of committed transactions which were read from the transaction log of the writing process
+	replicaCheckpoint uint64            // Checkpoint counter of the writing process when the replica was refreshed
+	checkpointFile    *os.File          // Checkpoint counter file of the writing process (only used by a replica)
+}
+
+/*
+StorageFileOptions contains optional settings for a storage file.
+*/
+type StorageFileOptions struct {
+	EncryptionKey []byte // AES key to encrypt all records and the transaction log (nil for no encryption)
+	Checksums     bool   // Flag if a new storage file should store checksums of all records
+	MemoryMapped  bool   // Flag if records should be read from memory mappings (if supported)
+
+	Syncer *LogSyncer // Syncer which writes the transaction log to disk (nil to sync every commit)
+
+	Replica bool // Flag if the storage file should be opened as a read-only replica of a storage file which is written by another process
 }
 
 /*
@@ -73,11 +104,66 @@ func NewDefaultStorageFile(name string, transDisabled bool) (*StorageFile, error
 NewStorageFile creates a new storage file and returns a reference to it.
 */
 func NewStorageFile(name string, recordSize uint32, transDisabled bool) (*StorageFile, error) {
-	maxFileSize := DefaultFileSize - DefaultFileSize%uint64(recordSize)
+	return NewStorageFileWithKey(name, recordSize, transDisabled, nil)
+}
 
-	ret := &StorageFile{name, transDisabled, recordSize, maxFileSize,
+/*
+NewStorageFileWithKey creates a new storage file which encrypts all records
+and the transaction log with a given AES key. Encryption is disabled if the
+given key is nil.
+*/
+func NewStorageFileWithKey(name string, recordSize uint32, transDisabled bool,
+	key []byte) (*StorageFile, error) {
+
+	return NewStorageFileWithOptions(name, recordSize, transDisabled,
+		StorageFileOptions{EncryptionKey: key})
+}
+
+/*
+NewStorageFileWithOptions creates a new storage file with additional options.
+Checksums are only added to new storage files. Existing storage files keep
+their checksum table if they have one.
+*/
+func NewStorageFileWithOptions(name string, recordSize uint32, transDisabled bool,
+	options StorageFileOptions) (*StorageFile, error) {
+
+	var aead cipher.AEAD
+	var err error
+
+	if options.EncryptionKey != nil {
+		if aead, err = newRecordCipher(options.EncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	// A replica never writes to any file - the transaction log belongs to
+	// the writing process and memory mappings are not used since the
+	// writing process may truncate the files
+
+	if options.Replica {
+		transDisabled = true
+	}
+
+	ret := &StorageFile{name, transDisabled, recordSize, 0,
 		make(map[uint64]*Record), make(map[uint64]*Record), make(map[uint64]*Record),
-		make(map[uint64]*Record), make([]*os.File, 0), nil}
+		make(map[uint64]*Record), make([]*os.File, 0), nil, aead, false, nil,
+		options.MemoryMapped && MemoryMappingSupported && !options.Replica, nil, options.Syncer,
+		options.Replica, make(map[uint64][]byte), 0, nil}
+
+	ret.maxFileSize = DefaultFileSize - DefaultFileSize%uint64(ret.diskRecordSize())
+
+	// The checksum table must be available before any pending
+	// transactions are recovered
+
+	if err := ret.openChecksumTable(options.Checksums && !options.Replica); err != nil {
+		return nil, err
+	}
+
+	if options.Replica {
+		if err := ret.Refresh(); err != nil {
+			return nil, err
+		}
+	}
 
 	if !transDisabled {
 		tm, err := NewTransactionManager(ret, true)
@@ -87,7 +173,7 @@ func NewStorageFile(name string, recordSize uint32, transDisabled bool) (*Storag
 		ret.tm = tm
 	}
 
-	_, err := ret.getFile(0)
+	_, err = ret.getFile(0)
 
 	if err != nil {
 		return nil, err
@@ -110,6 +196,23 @@ func (s *StorageFile) RecordSize() uint32 {
 	return s.recordSize
 }
 
+/*
+Encrypted returns if the records of this storage file are encrypted.
+*/
+func (s *StorageFile) Encrypted() bool {
+	return s.cipher != nil
+}
+
+/*
+diskRecordSize returns the size of a record on disk.
+*/
+func (s *StorageFile) diskRecordSize() uint32 {
+	if s.cipher != nil {
+		return s.recordSize + EncryptionOverhead
+	}
+	return s.recordSize
+}
+
 /*
 Get returns a record from the file. Other components can write to this record.
 Any write operation should set the dirty flag on the record. Dirty records will
@@ -185,8 +288,20 @@ func (s *StorageFile) getFile(offset uint64) (*os.File, error) {
 
 		filename := fmt.Sprintf("%s.%d", s.name, filenumber)
 
-		file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0660)
+		flag := os.O_CREATE | os.O_RDWR
+		if s.replica {
+			flag = os.O_RDONLY
+		}
+
+		file, err := os.OpenFile(filename, flag, 0660)
 		if err != nil {
+
+			// A replica may read files which have not yet been written
+
+			if s.replica && os.IsNotExist(err) {
+				return nil, nil
+			}
+
 			return nil, err
 		}
 
@@ -234,13 +349,29 @@ func (s *StorageFile) writeRecord(record *Record) error {
 
 	if data != nil {
 
-		offset := record.ID() * uint64(s.recordSize)
+		offset := record.ID() * uint64(s.diskRecordSize())
 
 		file, err := s.getFile(offset)
 		if err != nil {
 			return err
 		}
 
+		if s.checksums {
+			if err := s.writeChecksum(record.ID(), data); err != nil {
+				return err
+			}
+		}
+
+		if s.cipher != nil {
+			if err = s.fillRecordGap(file, offset); err != nil {
+				return err
+			}
+
+			if data, err = s.sealRecordData(record.ID(), data); err != nil {
+				return err
+			}
+		}
+
 		file.WriteAt(data, int64(offset%s.maxFileSize))
 
 		return nil
@@ -249,6 +380,35 @@ func (s *StorageFile) writeRecord(record *Record) error {
 	return NewStorageFileError(ErrNilData, fmt.Sprintf("Record %v", record.ID()), s.name)
 }
 
+/*
+fillRecordGap writes sealed empty records for all records between the end of
+a physical file and a given offset. Every record of an encrypted storage file
+which lies inside a physical file is then authenticated on read.
+*/
+func (s *StorageFile) fillRecordGap(file *os.File, offset uint64) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	drs := uint64(s.diskRecordSize())
+	start := offset - offset%s.maxFileSize
+	empty := make([]byte, s.recordSize)
+
+	for pos := uint64(stat.Size()) / drs * drs; pos < offset%s.maxFileSize; pos += drs {
+		data, err := s.sealRecordData((start+pos)/drs, empty)
+		if err != nil {
+			return err
+		}
+
+		if _, err := file.WriteAt(data, int64(pos)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 /*
 readRecord fills a given record object with data.
 */
@@ -258,22 +418,73 @@ func (s *StorageFile) readRecord(record *Record) error {
 		return NewStorageFileError(ErrNilData, fmt.Sprintf("Record %v", record.ID()), s.name)
 	}
 
-	offset := record.ID() * uint64(s.recordSize)
+	// A replica reads committed records from the transaction log first
+
+	if data, ok := s.replicaLog[record.ID()]; ok {
+		copy(record.Data(), data)
+		return nil
+	}
+
+	err := s.readRecordFromFile(record)
+
+	// A replica must not use records which were read while or after the
+	// writing process changed the physical files
+
+	if s.replica {
+		if cerr := s.checkReplicaCheckpoint(); cerr != nil {
+			return cerr
+		}
+	}
+
+	return err
+}
+
+/*
+readRecordFromFile fills a given record object with data from the physical files.
+*/
+func (s *StorageFile) readRecordFromFile(record *Record) error {
+
+	offset := record.ID() * uint64(s.diskRecordSize())
 
 	file, err := s.getFile(offset)
 	if err != nil {
 		return err
+	} else if file == nil {
+		record.ClearData()
+		return nil
 	}
 
-	n, err := file.ReadAt(record.Data(), int64(offset%s.maxFileSize))
+	data := record.Data()
+
+	if s.cipher != nil {
+		data = make([]byte, s.diskRecordSize())
+	}
+
+	n, err := s.readAt(file, data, offset)
+
+	if n > 0 && uint32(n) != s.diskRecordSize() && s.replica {
 
-	if n > 0 && uint32(n) != s.recordSize {
+		// The writing process is extending the physical file
+
+		return NewStorageFileError(ErrReplicaStale, fmt.Sprintf("Record %v", record.ID()), s.name)
+
+	} else if n > 0 && uint32(n) != s.diskRecordSize() {
 		panic(fmt.Sprintf("File on disk returned unexpected length of data: %v "+
-			"expected length was: %v", n, s.recordSize))
+			"expected length was: %v", n, s.diskRecordSize()))
 	} else if n == 0 {
 		// We just allocate a new array here which seems to be the
 		// quickest way to get an empty array.
 		record.ClearData()
+	} else if s.cipher != nil {
+		if oerr := s.openRecordData(record.ID(), data, record.Data()); oerr != nil {
+			return oerr
+		}
+	}
+
+	if n > 0 && s.checksums {
+		if cerr := s.verifyChecksum(record.ID(), record.Data()); cerr != nil {
+			return cerr
+		}
 	}
 
 	if err == io.EOF {
@@ -376,6 +587,10 @@ func (s *StorageFile) Flush() error {
 		return nil
 	}
 
+	if s.replica {
+		return NewStorageFileError(ErrReplica, fmt.Sprintf("Records %v", len(s.dirty)), s.name)
+	}
+
 	if !s.transDisabled {
 		s.tm.start()
 	}
@@ -439,6 +654,87 @@ func (s *StorageFile) Sync() {
 			file.Sync()
 		}
 	}
+
+	if s.checksumFile != nil {
+		s.checksumFile.Sync()
+	}
+}
+
+/*
+Truncate removes all records with an id equal or greater than a given count.
+All transactions are written to disk before the physical files are truncated.
+Truncate fails if one of the removed records is still in-use. Pending changes
+of removed records are discarded.
+*/
+func (s *StorageFile) Truncate(count uint64) error {
+
+	if s.replica {
+		return NewStorageFileError(ErrReplica, "", s.name)
+	}
+
+	for id := range s.inUse {
+		if id >= count {
+			return NewStorageFileError(ErrAlreadyInUse, fmt.Sprintf("Record %v", id), s.name)
+		}
+	}
+
+	for id := range s.dirty {
+		if id >= count {
+			delete(s.dirty, id)
+		}
+	}
+
+	if !s.transDisabled {
+		if err := s.tm.syncLogFromMemory(); err != nil {
+			return err
+		}
+	}
+
+	for id := range s.free {
+		if id >= count {
+			delete(s.free, id)
+		}
+	}
+
+	size := count * uint64(s.diskRecordSize())
+	filenumber := int(size / s.maxFileSize)
+
+	file, err := s.getFile(size)
+	if err != nil {
+		return err
+	}
+
+	// Mappings must be removed before the files are truncated
+
+	s.unmapFiles()
+
+	if err := file.Truncate(int64(size % s.maxFileSize)); err != nil {
+		return err
+	}
+
+	// Remove all following files
+
+	for i := filenumber + 1; ; i++ {
+
+		if i < len(s.files) && s.files[i] != nil {
+			s.files[i].Close()
+		}
+
+		err := os.Remove(fmt.Sprintf("%s.%d", s.name, i))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	s.files = s.files[:filenumber+1]
+
+	if s.checksums {
+		return s.truncateChecksums(count)
+	}
+
+	return nil
 }
 
 /*
@@ -446,7 +742,9 @@ Close commits all data and closes all physical files.
 */
 func (s *StorageFile) Close() error {
 
-	if len(s.dirty) > 0 {
+	// Pending changes of a replica are discarded
+
+	if len(s.dirty) > 0 && !s.replica {
 		if err := s.Flush(); err != nil {
 			return err
 		}
@@ -459,6 +757,8 @@ func (s *StorageFile) Close() error {
 
 		s.tm.syncLogFromMemory()
 		s.tm.close()
+		s.tm.closeArchive()
+		s.tm.closeCheckpoint()
 	}
 
 	if len(s.inTrans) > 0 {
@@ -467,12 +767,24 @@ func (s *StorageFile) Close() error {
 		return NewStorageFileError(ErrInUse, fmt.Sprintf("Records %v", len(s.inUse)), s.name)
 	}
 
+	s.unmapFiles()
+
 	for _, file := range s.files {
 		if file != nil {
 			file.Close()
 		}
 	}
 
+	if s.checksumFile != nil {
+		s.checksumFile.Close()
+		s.checksumFile = nil
+	}
+
+	if s.checkpointFile != nil {
+		s.checkpointFile.Close()
+		s.checkpointFile = nil
+	}
+
 	s.free = make(map[uint64]*Record)
 	s.files = make([]*os.File, 0)
 
@@ -95,7 +95,7 @@ func TestStorageFileInitialisation(t *testing.T) {
 
 func TestGetFile(t *testing.T) {
 	sf := &StorageFile{DBDir + "/test2", true, 10, 10, nil, nil, nil, nil,
-		make([]*os.File, 0), nil}
+		make([]*os.File, 0), nil, nil, false, nil, false, nil, nil, false, nil, 0, nil}
 	defer sf.Close()
 
 	file, err := sf.getFile(0)
@@ -664,6 +664,86 @@ func TestFlushingClosing(t *testing.T) {
 	checkMap(t, &sf.dirty, 5, false, "Record1", "dirty")
 }
 
+func TestTruncate(t *testing.T) {
+	sf, err := NewStorageFile(DBDir+"/truncate_test", 10, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Store 3 records per file
+
+	sf.maxFileSize = 30
+
+	for i := uint64(0); i < 10; i++ {
+		record, err := sf.Get(i)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		record.WriteSingleByte(0, byte(i+1))
+
+		sf.ReleaseInUse(record)
+	}
+
+	record, _ := sf.Get(5)
+
+	err = sf.Truncate(4)
+	if sfe, ok := err.(*StorageFileError); !ok || sfe.Type != ErrAlreadyInUse {
+		t.Error("Truncating an in-use record should not be possible", err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if err := sf.Truncate(4); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(sf.files) != 2 || len(sf.dirty) != 4 {
+		t.Error("Unexpected state after truncate:", sf.files, sf.dirty)
+		return
+	}
+
+	if err := sf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stat, err := os.Stat(DBDir + "/truncate_test.1"); err != nil || stat.Size() != 10 {
+		t.Error("Unexpected file after truncate:", stat, err)
+		return
+	}
+
+	if res, _ := fileutil.PathExists(DBDir + "/truncate_test.2"); res {
+		t.Error("File should have been removed")
+		return
+	}
+
+	// Truncated records are empty
+
+	record, _ = sf.Get(3)
+	if record.ReadSingleByte(0) != 4 {
+		t.Error("Unexpected record data:", record)
+		return
+	}
+	sf.ReleaseInUse(record)
+
+	record, _ = sf.Get(5)
+	if record.ReadSingleByte(0) != 0 {
+		t.Error("Unexpected record data:", record)
+		return
+	}
+	sf.ReleaseInUse(record)
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
 func testReleasePanic(t *testing.T, sf *StorageFile, r *Record) {
 	defer func() {
 		if r := recover(); r == nil {
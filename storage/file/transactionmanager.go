@@ -22,7 +22,8 @@ import (
 Common TransactionManager related errors
 */
 var (
-	ErrBadMagic = fmt.Errorf("Bad magic for transaction log")
+	ErrBadMagic      = fmt.Errorf("Bad magic for transaction log")
+	ErrLogEncryption = fmt.Errorf("Transaction log encryption does not match storage file")
 )
 
 /*
@@ -30,6 +31,11 @@ LogFileSuffix is the file suffix for transaction log files
 */
 const LogFileSuffix = "tlg"
 
+/*
+CheckpointFileSuffix is the file suffix for checkpoint counter files
+*/
+const CheckpointFileSuffix = "ckp"
+
 /*
 DefaultTransInLog is the default number of transactions which should be kept in memory
 (affects how often we sync the log from memory)
@@ -48,6 +54,12 @@ TransactionLogHeader is the magic number to identify transaction log files
 */
 var TransactionLogHeader = []byte{0x66, 0x42}
 
+/*
+TransactionLogHeaderEncrypted is the magic number to identify transaction log files
+which contain encrypted records
+*/
+var TransactionLogHeaderEncrypted = []byte{0x66, 0x45}
+
 /*
 LogFile is the abstract interface for an transaction log file.
 */
@@ -67,6 +79,12 @@ type TransactionManager struct {
 	transList [][]*Record  // List of storage files
 	maxTrans  int          // Maximal number of transaction before log is written
 	owner     *StorageFile // Owner of this manager
+
+	archive      LogFile // Optional archive for all committed transactions
+	lastArchived int64   // Commit time of the last archived transaction
+
+	checkpointFile *os.File // Checkpoint counter file (see beginCheckpoint)
+	checkpoint     uint64   // Current value of the checkpoint counter
 }
 
 /*
@@ -104,7 +122,17 @@ func NewTransactionManager(owner *StorageFile, doRecover bool) (*TransactionMana
 	name := fmt.Sprintf("%s.%s", owner.Name(), LogFileSuffix)
 
 	ret := &TransactionManager{name, nil, -1, make([][]*Record, DefaultTransInLog),
-		DefaultTransInLog, owner}
+		DefaultTransInLog, owner, nil, 0, nil, 0}
+
+	if err := ret.openCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	// Recovered transactions are written to the physical files
+
+	if err := ret.beginCheckpoint(); err != nil {
+		return nil, err
+	}
 
 	if doRecover {
 		if err := ret.recover(); err != nil {
@@ -119,6 +147,10 @@ func NewTransactionManager(owner *StorageFile, doRecover bool) (*TransactionMana
 		return nil, err
 	}
 
+	if err := ret.endCheckpoint(); err != nil {
+		return nil, err
+	}
+
 	return ret, nil
 }
 
@@ -140,8 +172,12 @@ func (t *TransactionManager) recover() error {
 	magic := make([]byte, 2)
 	i, _ := file.Read(magic)
 
-	if i != 2 || magic[0] != TransactionLogHeader[0] ||
-		magic[1] != TransactionLogHeader[1] {
+	if i == 2 && !bytes.Equal(magic, t.logHeader()) &&
+		(bytes.Equal(magic, TransactionLogHeader) || bytes.Equal(magic, TransactionLogHeaderEncrypted)) {
+		return NewStorageFileError(ErrLogEncryption, "", t.owner.name)
+	}
+
+	if i != 2 || !bytes.Equal(magic, t.logHeader()) {
 		return NewStorageFileError(ErrBadMagic, "", t.owner.name)
 	}
 
@@ -154,18 +190,9 @@ func (t *TransactionManager) recover() error {
 			return err
 		}
 
-		recMap := make(map[uint64]*Record)
-
-		for i := int64(0); i < numRecords; i++ {
-			record, err := ReadRecord(file)
-			if err != nil {
-				return err
-			}
-
-			// Any duplicated records will only be synced once
-			// using the latest version
-
-			recMap[record.ID()] = record
+		recMap, err := t.owner.readTransactionRecords(file, numRecords)
+		if err != nil {
+			return err
 		}
 
 		// If something goes wrong here ignore and try to do the rest
@@ -176,6 +203,36 @@ func (t *TransactionManager) recover() error {
 	return nil
 }
 
+/*
+readTransactionRecords reads the records of a single transaction from a
+transaction log.
+*/
+func (s *StorageFile) readTransactionRecords(r io.Reader, numRecords int64) (map[uint64]*Record, error) {
+	recMap := make(map[uint64]*Record)
+
+	for i := int64(0); i < numRecords; i++ {
+		record, err := ReadRecord(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.cipher != nil {
+			data := make([]byte, s.recordSize)
+			if err := s.openRecordData(record.ID(), record.Data(), data); err != nil {
+				return nil, err
+			}
+			record.data = data
+		}
+
+		// Any duplicated records will only be synced once
+		// using the latest version
+
+		recMap[record.ID()] = record
+	}
+
+	return recMap, nil
+}
+
 /*
 Open opens the transaction log for writing.
 */
@@ -189,13 +246,23 @@ func (t *TransactionManager) open() error {
 	}
 	t.logFile = file
 
-	t.logFile.Write(TransactionLogHeader)
+	t.logFile.Write(t.logHeader())
 	t.logFile.Sync()
 	t.curTrans = -1
 
 	return nil
 }
 
+/*
+logHeader returns the magic number for the transaction log file.
+*/
+func (t *TransactionManager) logHeader() []byte {
+	if t.owner.cipher != nil {
+		return TransactionLogHeaderEncrypted
+	}
+	return TransactionLogHeader
+}
+
 /*
 Start starts a new transaction.
 */
@@ -220,10 +287,19 @@ func (t *TransactionManager) add(record *Record) {
 Commit commits the memory transaction log to the physical transaction log.
 */
 func (t *TransactionManager) commit() error {
+	var logFile io.Writer = t.logFile
+	var archiveBuf *bytes.Buffer
+
+	// Keep a copy of the written transaction if it should be archived
+
+	if t.archive != nil {
+		archiveBuf = new(bytes.Buffer)
+		logFile = io.MultiWriter(t.logFile, archiveBuf)
+	}
 
 	// Write how many records will be stored
 
-	if err := binary.Write(t.logFile, binary.LittleEndian,
+	if err := binary.Write(logFile, binary.LittleEndian,
 		int64(len(t.transList[t.curTrans]))); err != nil {
 
 		return err
@@ -232,12 +308,39 @@ func (t *TransactionManager) commit() error {
 	// Write records to log file
 
 	for _, record := range t.transList[t.curTrans] {
-		if err := record.WriteRecord(t.logFile); err != nil {
+
+		if t.owner.cipher != nil {
+
+			// Write an encrypted copy of the record to the log
+
+			sealed, err := t.owner.sealRecordData(record.ID(), record.Data())
+			if err != nil {
+				return err
+			}
+
+			record = &Record{record.id, sealed, record.dirty, record.transCount, nil}
+		}
+
+		if err := record.WriteRecord(logFile); err != nil {
 			return err
 		}
 	}
 
-	t.syncFile()
+	// Write the transaction to disk or leave it to the log syncer
+
+	if t.owner.syncer != nil {
+		if err := t.owner.syncer.add(t.logFile, logFileSize(t.transList[t.curTrans])); err != nil {
+			return err
+		}
+	} else {
+		t.syncFile()
+	}
+
+	if archiveBuf != nil {
+		if err := t.archiveTransaction(archiveBuf.Bytes()); err != nil {
+			return err
+		}
+	}
 
 	// Clear all dirty flags
 
@@ -248,6 +351,20 @@ func (t *TransactionManager) commit() error {
 	return nil
 }
 
+/*
+logFileSize returns the approximate number of bytes which a given transaction
+occupies in the transaction log.
+*/
+func logFileSize(records []*Record) int64 {
+	var size int64 = 8
+
+	for _, record := range records {
+		size += int64(len(record.Data())) + 32
+	}
+
+	return size
+}
+
 /*
 syncFile syncs the transaction log file with the disk.
 */
@@ -296,15 +413,30 @@ func (t *TransactionManager) syncLogFromMemory() error {
 		t.transList[i] = nil
 	}
 
+	// The physical files and the transaction log are not changed if there
+	// are no transactions
+
+	if len(recMap) == 0 {
+		return t.open()
+	}
+
 	// Write the records from the record list to disk
 
+	if err := t.beginCheckpoint(); err != nil {
+		return err
+	}
+
 	if err := t.syncRecords(recMap, true); err != nil {
 		return err
 	}
 
 	t.owner.Sync()
 
-	return t.open()
+	if err := t.open(); err != nil {
+		return err
+	}
+
+	return t.endCheckpoint()
 }
 
 /*
@@ -331,11 +463,93 @@ func (t *TransactionManager) syncLogFromDisk() error {
 		t.transList[i] = nil
 	}
 
+	if err := t.beginCheckpoint(); err != nil {
+		return err
+	}
+
 	if err := t.recover(); err != nil {
 		return err
 	}
 
-	return t.open()
+	if err := t.open(); err != nil {
+		return err
+	}
+
+	return t.endCheckpoint()
+}
+
+/*
+openCheckpoint opens the checkpoint counter file and reads the current value
+of the counter.
+
+The checkpoint counter allows a replica (see StorageFile.Refresh) to detect
+that the physical files were changed. The counter is odd while records are
+written to the physical files and the transaction log is truncated. It is even
+while the physical files are not changed and transactions are only appended
+to the transaction log.
+*/
+func (t *TransactionManager) openCheckpoint() error {
+	var buf [8]byte
+
+	file, err := os.OpenFile(fmt.Sprintf("%s.%s", t.owner.name, CheckpointFileSuffix),
+		os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := file.ReadAt(buf[:], 0); n == len(buf) {
+		t.checkpoint = binary.LittleEndian.Uint64(buf[:])
+	}
+
+	t.checkpointFile = file
+
+	return nil
+}
+
+/*
+beginCheckpoint marks the start of a checkpoint - the physical files are
+about to be changed. The counter might already be odd if a previous
+checkpoint did not finish.
+*/
+func (t *TransactionManager) beginCheckpoint() error {
+	if t.checkpoint%2 == 0 {
+		t.checkpoint++
+	} else {
+		t.checkpoint += 2
+	}
+	return t.writeCheckpoint()
+}
+
+/*
+endCheckpoint marks the end of a checkpoint.
+*/
+func (t *TransactionManager) endCheckpoint() error {
+	t.checkpoint++
+	return t.writeCheckpoint()
+}
+
+/*
+writeCheckpoint writes the checkpoint counter. The counter is not synced since
+it is only read by processes on the same machine.
+*/
+func (t *TransactionManager) writeCheckpoint() error {
+	var buf [8]byte
+
+	binary.LittleEndian.PutUint64(buf[:], t.checkpoint)
+
+	_, err := t.checkpointFile.WriteAt(buf[:], 0)
+
+	return err
+}
+
+/*
+closeCheckpoint closes the checkpoint counter file.
+*/
+func (t *TransactionManager) closeCheckpoint() {
+	if t.checkpointFile != nil {
+		t.checkpointFile.Close()
+		t.checkpointFile = nil
+	}
 }
 
 /*
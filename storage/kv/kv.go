@@ -0,0 +1,137 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package kv contains the interface for embedded ordered key-value stores which
+can be used as storage backend instead of EliasDB's own slotting storage.
+
+A Store keeps its keys in byte order and supports range scans. All changes
+are written in batches - a batch is either written completely or not at all.
+
+MemoryStore is a Store which keeps all its data in memory. A persistent Store
+which keeps its data in a B+tree is provided by the btree package.
+*/
+package kv
+
+import "errors"
+
+/*
+Store is an embedded ordered key-value store.
+*/
+type Store interface {
+
+	/*
+		Get returns the value of a given key. Returns nil if the key does
+		not exist. The returned value must not be modified.
+	*/
+	Get(key []byte) ([]byte, error)
+
+	/*
+		Scan calls a given function for all keys in the range [start, end)
+		in byte order. A nil end means that there is no upper limit. The
+		scan stops once the function returns false. Keys and values must
+		not be modified.
+	*/
+	Scan(start []byte, end []byte, fn func(key []byte, value []byte) bool) error
+
+	/*
+		Write atomically applies all operations of a given batch.
+	*/
+	Write(batch *Batch) error
+
+	/*
+		Close closes the store.
+	*/
+	Close() error
+}
+
+/*
+ErrClosed is returned if a closed store is accessed.
+*/
+var ErrClosed = errors.New("Store is closed")
+
+/*
+Batch is an ordered list of changes which are applied together.
+*/
+type Batch struct {
+	ops []*batchOp // Operations of this batch
+}
+
+/*
+batchOp is a single change in a batch.
+*/
+type batchOp struct {
+	key    []byte // Changed key
+	value  []byte // New value (nil for deletion)
+	delete bool   // Flag if the key should be deleted
+}
+
+/*
+NewBatch creates a new empty batch.
+*/
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+/*
+Put sets a given key to a given value.
+*/
+func (b *Batch) Put(key []byte, value []byte) {
+	if value == nil {
+		value = []byte{}
+	}
+	b.ops = append(b.ops, &batchOp{key, value, false})
+}
+
+/*
+Delete removes a given key.
+*/
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, &batchOp{key, nil, true})
+}
+
+/*
+Len returns the number of operations in this batch.
+*/
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+/*
+Apply calls a given function for all operations of this batch in the order in
+which they were added. The value is nil for deletions. Apply stops at the first
+error which is returned by the function.
+*/
+func (b *Batch) Apply(fn func(key []byte, value []byte) error) error {
+	for _, op := range b.ops {
+		if err := fn(op.key, op.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+PrefixEnd returns the smallest key which is greater than all keys with a given
+prefix. Returns nil if there is no such key.
+*/
+func PrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
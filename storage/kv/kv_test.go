@@ -0,0 +1,61 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import (
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	b := NewBatch()
+
+	b.Put([]byte("a"), nil)
+	b.Put([]byte("b"), []byte("1"))
+	b.Delete([]byte("a"))
+
+	if res := b.Len(); res != 3 {
+		t.Error("Unexpected length:", res)
+		return
+	}
+
+	if op := b.ops[0]; op.value == nil || op.delete {
+		t.Error("Unexpected operation:", op)
+		return
+	}
+
+	if op := b.ops[2]; op.value != nil || !op.delete {
+		t.Error("Unexpected operation:", op)
+		return
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+
+	if res := PrefixEnd([]byte("ab")); string(res) != "ac" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := PrefixEnd([]byte{1, 0xFF, 0xFF}); len(res) != 1 || res[0] != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := PrefixEnd([]byte{0xFF}); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := PrefixEnd(nil); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+/*
+MemoryStore is a Store which keeps all its data in memory.
+*/
+type MemoryStore struct {
+	keys   [][]byte          // Sorted keys
+	data   map[string][]byte // Stored values
+	closed bool              // Flag if the store was closed
+	mutex  *sync.RWMutex     // Mutex to protect the stored data
+}
+
+/*
+NewMemoryStore creates a new MemoryStore.
+*/
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nil, make(map[string][]byte), false, &sync.RWMutex{}}
+}
+
+/*
+Get returns the value of a given key. Returns nil if the key does not exist.
+*/
+func (ms *MemoryStore) Get(key []byte) ([]byte, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if ms.closed {
+		return nil, ErrClosed
+	}
+
+	return ms.data[string(key)], nil
+}
+
+/*
+Scan calls a given function for all keys in the range [start, end) in byte
+order. A nil end means that there is no upper limit. The function must not
+write to the store.
+*/
+func (ms *MemoryStore) Scan(start []byte, end []byte, fn func(key []byte, value []byte) bool) error {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if ms.closed {
+		return ErrClosed
+	}
+
+	for i := ms.search(start); i < len(ms.keys); i++ {
+		key := ms.keys[i]
+
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		} else if !fn(key, ms.data[string(key)]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+/*
+Write atomically applies all operations of a given batch.
+*/
+func (ms *MemoryStore) Write(batch *Batch) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.closed {
+		return ErrClosed
+	}
+
+	return batch.Apply(func(key []byte, value []byte) error {
+		i := ms.search(key)
+		exists := i < len(ms.keys) && bytes.Equal(ms.keys[i], key)
+
+		if value == nil {
+			if exists {
+				ms.keys = append(ms.keys[:i], ms.keys[i+1:]...)
+				delete(ms.data, string(key))
+			}
+			return nil
+		}
+
+		if !exists {
+			ms.keys = append(ms.keys, nil)
+			copy(ms.keys[i+1:], ms.keys[i:])
+			ms.keys[i] = append([]byte(nil), key...)
+		}
+
+		ms.data[string(key)] = append([]byte{}, value...)
+
+		return nil
+	})
+}
+
+/*
+search returns the position of the first key which is not smaller than a given key.
+*/
+func (ms *MemoryStore) search(key []byte) int {
+	return sort.Search(len(ms.keys), func(i int) bool {
+		return bytes.Compare(ms.keys[i], key) >= 0
+	})
+}
+
+/*
+Close closes the store.
+*/
+func (ms *MemoryStore) Close() error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.closed {
+		return ErrClosed
+	}
+
+	ms.closed = true
+
+	return nil
+}
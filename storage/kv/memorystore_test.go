@@ -0,0 +1,131 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func scanKeys(s Store, start []byte, end []byte) ([]string, error) {
+	var res []string
+
+	err := s.Scan(start, end, func(key []byte, value []byte) bool {
+		res = append(res, string(key))
+		return true
+	})
+
+	return res, err
+}
+
+func TestMemoryStore(t *testing.T) {
+	ms := NewMemoryStore()
+
+	var _ Store = ms
+
+	if res, err := ms.Get([]byte("foo")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	b := NewBatch()
+	for _, k := range []string{"d", "b", "a", "c", "e"} {
+		b.Put([]byte(k), []byte("val"+k))
+	}
+	b.Put([]byte("x"), []byte{})
+	b.Delete([]byte("x"))
+	b.Put([]byte("empty"), []byte{})
+
+	if err := ms.Write(b); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := ms.Get([]byte("c")); string(res) != "valc" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := ms.Get([]byte("empty")); res == nil || len(res) != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := scanKeys(ms, nil, nil); fmt.Sprint(res) != "[a b c d e empty]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := scanKeys(ms, []byte("b"), []byte("d")); fmt.Sprint(res) != "[b c]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	count := 0
+	ms.Scan(nil, nil, func(key []byte, value []byte) bool {
+		count++
+		return count < 2
+	})
+
+	if count != 2 {
+		t.Error("Unexpected count:", count)
+		return
+	}
+
+	// Update and delete keys
+
+	b = NewBatch()
+	b.Put([]byte("a"), []byte("newval"))
+	b.Delete([]byte("c"))
+	b.Delete([]byte("unknown"))
+
+	if err := ms.Write(b); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := ms.Get([]byte("a")); string(res) != "newval" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := scanKeys(ms, nil, nil); fmt.Sprint(res) != "[a b d e empty]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A closed store cannot be used
+
+	if err := ms.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ms.Close(); err != ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := ms.Get([]byte("a")); err != ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ms.Scan(nil, nil, nil); err != ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ms.Write(b); err != ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,383 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/krotik/eliasdb/storage/kv"
+)
+
+/*
+Key types of a KVStorageManager. All keys of a KVStorageManager start with
+its name followed by a zero byte and the key type.
+*/
+const (
+	kvKeyHeader  = 'h' // Header with the codec of the stored objects
+	kvKeyCounter = 'c' // Next free location
+	kvKeyRoot    = 'r' // Root values
+	kvKeyObject  = 'o' // Stored objects
+)
+
+/*
+KVStorageManager is a storage manager which stores objects in an ordered
+key-value store. Several KVStorageManagers can share the same store. Each
+object is stored under its own key - locations are never reused. Changes are
+kept in memory until they are written to the store as a single batch on Flush.
+*/
+type KVStorageManager struct {
+	name         string            // Name of the storage manager
+	store        kv.Store          // Underlying key-value store
+	readonly     bool              // Flag to make the storage readonly
+	codec        int               // Codec for stored objects
+	prefix       []byte            // Prefix of all keys of this storage manager
+	mutex        *sync.Mutex       // Mutex to protect pending changes
+	stored       bool              // Flag if the header has been written
+	locCount     uint64            // Next free location
+	pending      map[uint64][]byte // Pending objects (nil for freed locations)
+	pendingRoots map[int]uint64    // Pending root values
+}
+
+/*
+NewKVStorageManager creates a new KVStorageManager with a given name on a
+given store. The codec is only used if the storage manager does not exist yet
+- otherwise the codec which was used when it was created is used.
+*/
+func NewKVStorageManager(name string, store kv.Store, readonly bool, codec int) *KVStorageManager {
+	ksm := &KVStorageManager{name, store, readonly, codec, append([]byte(name), 0),
+		&sync.Mutex{}, false, 1, make(map[uint64][]byte), make(map[int]uint64)}
+
+	if header, err := store.Get(ksm.key(kvKeyHeader, nil)); err == nil && len(header) > 0 {
+		ksm.codec = int(header[0])
+		ksm.stored = true
+	}
+
+	ksm.locCount = ksm.storedLocCount()
+
+	return ksm
+}
+
+/*
+KVStorageManagerExists checks if a KVStorageManager with a given name exists
+in a given store.
+*/
+func KVStorageManagerExists(name string, store kv.Store) bool {
+	header, err := store.Get(append(append([]byte(name), 0), kvKeyHeader))
+	return err == nil && header != nil
+}
+
+/*
+key returns a key of this storage manager.
+*/
+func (ksm *KVStorageManager) key(keyType byte, id []byte) []byte {
+	key := make([]byte, 0, len(ksm.prefix)+1+len(id))
+	key = append(key, ksm.prefix...)
+	key = append(key, keyType)
+	return append(key, id...)
+}
+
+/*
+objectKey returns the key of a stored object.
+*/
+func (ksm *KVStorageManager) objectKey(loc uint64) []byte {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], loc)
+	return ksm.key(kvKeyObject, id[:])
+}
+
+/*
+rootKey returns the key of a root value.
+*/
+func (ksm *KVStorageManager) rootKey(root int) []byte {
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], uint32(root))
+	return ksm.key(kvKeyRoot, id[:])
+}
+
+/*
+storedLocCount returns the next free location which is stored in the store.
+*/
+func (ksm *KVStorageManager) storedLocCount() uint64 {
+	if val, err := ksm.store.Get(ksm.key(kvKeyCounter, nil)); err == nil && len(val) == 8 {
+		return binary.BigEndian.Uint64(val)
+	}
+	return 1
+}
+
+/*
+Name returns the name of the StorageManager instance.
+*/
+func (ksm *KVStorageManager) Name() string {
+	return ksm.name
+}
+
+/*
+Codec returns the codec of the stored objects.
+*/
+func (ksm *KVStorageManager) Codec() int {
+	return ksm.codec
+}
+
+/*
+Root returns a root value.
+*/
+func (ksm *KVStorageManager) Root(root int) uint64 {
+	ksm.mutex.Lock()
+	defer ksm.mutex.Unlock()
+
+	if val, ok := ksm.pendingRoots[root]; ok {
+		return val
+	}
+
+	if val, err := ksm.store.Get(ksm.rootKey(root)); err == nil && len(val) == 8 {
+		return binary.BigEndian.Uint64(val)
+	}
+
+	return 0
+}
+
+/*
+SetRoot writes a root value.
+*/
+func (ksm *KVStorageManager) SetRoot(root int, val uint64) {
+
+	// When readonly this operation becomes a NOP
+
+	if ksm.readonly {
+		return
+	}
+
+	ksm.mutex.Lock()
+	defer ksm.mutex.Unlock()
+
+	ksm.pendingRoots[root] = val
+}
+
+/*
+Insert inserts an object and return its storage location.
+*/
+func (ksm *KVStorageManager) Insert(o interface{}) (uint64, error) {
+
+	if ksm.readonly {
+		return 0, ErrReadonly
+	}
+
+	data, err := ksm.encode(o)
+	if err != nil {
+		return 0, err
+	}
+
+	ksm.mutex.Lock()
+	defer ksm.mutex.Unlock()
+
+	loc := ksm.locCount
+	ksm.locCount++
+	ksm.pending[loc] = data
+
+	return loc, nil
+}
+
+/*
+Update updates a storage location.
+*/
+func (ksm *KVStorageManager) Update(loc uint64, o interface{}) error {
+
+	if ksm.readonly {
+		return ErrReadonly
+	}
+
+	data, err := ksm.encode(o)
+	if err != nil {
+		return err
+	}
+
+	ksm.mutex.Lock()
+	defer ksm.mutex.Unlock()
+
+	if _, err := ksm.fetch(loc); err != nil {
+		return err
+	}
+
+	ksm.pending[loc] = data
+
+	return nil
+}
+
+/*
+Free frees a storage location.
+*/
+func (ksm *KVStorageManager) Free(loc uint64) error {
+
+	if ksm.readonly {
+		return ErrReadonly
+	}
+
+	ksm.mutex.Lock()
+	defer ksm.mutex.Unlock()
+
+	if _, err := ksm.fetch(loc); err != nil {
+		return err
+	}
+
+	ksm.pending[loc] = nil
+
+	return nil
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container.
+*/
+func (ksm *KVStorageManager) Fetch(loc uint64, o interface{}) error {
+	ksm.mutex.Lock()
+	data, err := ksm.fetch(loc)
+	ksm.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	codec, err := lookupCodec(ksm.codec)
+	if err != nil {
+		return err
+	}
+
+	return codec.Decode(bytes.NewReader(data), o)
+}
+
+/*
+fetch returns the serialized object of a given storage location.
+*/
+func (ksm *KVStorageManager) fetch(loc uint64) ([]byte, error) {
+
+	data, ok := ksm.pending[loc]
+
+	if !ok {
+		var err error
+
+		if data, err = ksm.store.Get(ksm.objectKey(loc)); err != nil {
+			return nil, err
+		}
+	}
+
+	if data == nil {
+		return nil, NewStorageManagerError(ErrSlotNotFound, fmt.Sprint("Location:", loc), ksm.Name())
+	}
+
+	return data, nil
+}
+
+/*
+encode serializes a given object with the codec of this storage manager.
+*/
+func (ksm *KVStorageManager) encode(o interface{}) ([]byte, error) {
+	codec, err := lookupCodec(ksm.codec)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	if err := codec.Encode(&buf, o); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/*
+FetchCached is not supported by a KVStorageManager. Always returns a
+storage.ErrNotInCache error.
+*/
+func (ksm *KVStorageManager) FetchCached(loc uint64) (interface{}, error) {
+	return nil, NewStorageManagerError(ErrNotInCache, "", ksm.Name())
+}
+
+/*
+Flush writes all pending changes as a single batch to the store.
+*/
+func (ksm *KVStorageManager) Flush() error {
+
+	// When readonly this operation becomes a NOP
+
+	if ksm.readonly {
+		return nil
+	}
+
+	ksm.mutex.Lock()
+	defer ksm.mutex.Unlock()
+
+	batch := kv.NewBatch()
+
+	if !ksm.stored {
+		batch.Put(ksm.key(kvKeyHeader, nil), []byte{byte(ksm.codec)})
+	}
+
+	for loc, data := range ksm.pending {
+		if data == nil {
+			batch.Delete(ksm.objectKey(loc))
+		} else {
+			batch.Put(ksm.objectKey(loc), data)
+		}
+	}
+
+	for root, val := range ksm.pendingRoots {
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], val)
+		batch.Put(ksm.rootKey(root), data[:])
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], ksm.locCount)
+	batch.Put(ksm.key(kvKeyCounter, nil), counter[:])
+
+	if err := ksm.store.Write(batch); err != nil {
+		return err
+	}
+
+	ksm.stored = true
+	ksm.pending = make(map[uint64][]byte)
+	ksm.pendingRoots = make(map[int]uint64)
+
+	return nil
+}
+
+/*
+Rollback cancels all pending changes which have not yet been written to the store.
+*/
+func (ksm *KVStorageManager) Rollback() error {
+
+	// When readonly this operation becomes a NOP
+
+	if ksm.readonly {
+		return nil
+	}
+
+	ksm.mutex.Lock()
+	defer ksm.mutex.Unlock()
+
+	ksm.pending = make(map[uint64][]byte)
+	ksm.pendingRoots = make(map[int]uint64)
+	ksm.locCount = ksm.storedLocCount()
+
+	return nil
+}
+
+/*
+Close writes all pending changes to the store. The store itself is not closed
+since it might be shared with other storage managers.
+*/
+func (ksm *KVStorageManager) Close() error {
+	return ksm.Flush()
+}
@@ -0,0 +1,230 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/krotik/eliasdb/storage/kv"
+)
+
+func TestKVStorageManager(t *testing.T) {
+	var res cachetestobj
+
+	store := kv.NewMemoryStore()
+
+	if KVStorageManagerExists("test", store) {
+		t.Error("Storage manager should not exist")
+		return
+	}
+
+	var ksm Manager = NewKVStorageManager("test", store, false, CodecBinary)
+
+	if ksm.Name() != "test" || ksm.Root(2) != 0 {
+		t.Error("Unexpected storage manager state")
+		return
+	}
+
+	loc1, _ := ksm.Insert(&cachetestobj{1, "test1"})
+	loc2, _ := ksm.Insert(&cachetestobj{2, "test2"})
+	ksm.SetRoot(2, loc2)
+
+	if loc1 != 1 || loc2 != 2 || ksm.Root(2) != loc2 {
+		t.Error("Unexpected locations:", loc1, loc2)
+		return
+	}
+
+	// Pending changes are visible before they are flushed
+
+	if err := ksm.Fetch(loc2, &res); err != nil || res.Val2 != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := ksm.FetchCached(loc2); err.(*ManagerError).Type != ErrNotInCache {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ksm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !KVStorageManagerExists("test", store) {
+		t.Error("Storage manager should exist")
+		return
+	}
+
+	// Objects are stored under the storage manager's own keys
+
+	count := 0
+	store.Scan([]byte("test\x00o"), kv.PrefixEnd([]byte("test\x00o")), func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+
+	if count != 2 {
+		t.Error("Unexpected number of stored objects:", count)
+		return
+	}
+
+	// Rolled back changes are discarded
+
+	ksm.Update(loc1, &cachetestobj{1, "test1a"})
+	ksm.Free(loc2)
+	ksm.SetRoot(2, 0)
+	loc3, _ := ksm.Insert(&cachetestobj{3, "test3"})
+
+	if err := ksm.Fetch(loc2, &res); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ksm.Rollback()
+
+	if err := ksm.Fetch(loc1, &res); err != nil || res.Val2 != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := ksm.Fetch(loc3, &res); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if ksm.Root(2) != loc2 {
+		t.Error("Unexpected root:", ksm.Root(2))
+		return
+	}
+
+	if loc, _ := ksm.Insert(&cachetestobj{3, "test3"}); loc != loc3 {
+		t.Error("Unexpected location:", loc)
+		return
+	}
+
+	// Flushed changes are persisted
+
+	ksm.Update(loc1, &cachetestobj{1, "test1a"})
+	ksm.Free(loc2)
+
+	if err := ksm.Update(loc2, &res); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ksm.Free(loc2); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ksm.Update(loc1, func() {}); err == nil {
+		t.Error("Update with an object which cannot be encoded should fail")
+		return
+	}
+
+	if _, err := ksm.Insert(func() {}); err == nil {
+		t.Error("Insert with an object which cannot be encoded should fail")
+		return
+	}
+
+	if err := ksm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The codec of an existing storage manager is kept
+
+	ksm2 := NewKVStorageManager("test", store, false, CodecGob)
+
+	if ksm2.Codec() != CodecBinary {
+		t.Error("Unexpected codec:", ksm2.Codec())
+		return
+	}
+
+	if err := ksm2.Fetch(loc1, &res); err != nil || res.Val2 != "test1a" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := ksm2.Fetch(loc2, &res); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if loc, _ := ksm2.Insert(&cachetestobj{4, "test4"}); loc != 4 {
+		t.Error("Unexpected location:", loc)
+		return
+	}
+
+	// Storage managers with different names do not interfere
+
+	ksm3 := NewKVStorageManager("test2", store, false, CodecGob)
+
+	if err := ksm3.Fetch(loc1, &res); err.(*ManagerError).Type != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Readonly storage managers cannot be changed
+
+	ro := NewKVStorageManager("test", store, true, CodecGob)
+
+	if _, err := ro.Insert(&res); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ro.Update(loc1, &res); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ro.Free(loc1); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ro.SetRoot(2, 5)
+
+	if ro.Root(2) != loc2 || ro.Flush() != nil || ro.Rollback() != nil {
+		t.Error("Unexpected readonly result")
+		return
+	}
+
+	// Errors of the store are reported
+
+	store.Close()
+
+	if err := ksm2.Fetch(loc1, &res); err != kv.ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ksm2.Flush(); err != kv.ErrClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ksm2.codec = -1
+
+	if _, err := ksm2.Insert(&res); err != ErrUnknownCodec {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ksm2.pending[loc1] = []byte{1}
+
+	if err := ksm2.Fetch(loc1, &res); err != ErrUnknownCodec {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,580 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+LargeObjectChunkSize is the maximum number of bytes which are stored in a
+single chunk of a large object.
+*/
+var LargeObjectChunkSize = 64 * 1024
+
+/*
+UncachedInserter is implemented by storage managers which can insert objects
+without keeping them in a cache. Chunks of large objects are inserted without
+caching if the storage manager supports it.
+*/
+type UncachedInserter interface {
+
+	/*
+		InsertUncached inserts an object without caching it and returns its
+		storage location.
+	*/
+	InsertUncached(o interface{}) (uint64, error)
+}
+
+/*
+Large object related errors
+*/
+var (
+	ErrInvalidLargeObject = errors.New("Invalid large object")
+	ErrLargeObjectClosed  = errors.New("Large object writer was closed")
+)
+
+/*
+LargeObjectHeader is the stored header of a large object. A large object is
+split into chunks which are stored as individual objects. Only the header needs
+to be read to open a large object - the chunks are fetched on demand.
+
+Chunks of an unfinished write are recorded as pending chunks if the writer
+flushes the storage manager before it is closed. Pending chunks of a write
+which was interrupted by a crash can be freed with ReclaimLargeObject.
+*/
+type LargeObjectHeader struct {
+	Size    uint64   // Size of the large object in bytes
+	Chunks  []uint64 // Storage locations of all chunks
+	Pending []uint64 // Storage locations of chunks of an unfinished write
+}
+
+/*
+largeObjectPins keeps track of all chunks which are read by open large object
+readers. Chunks which are freed while they are pinned are only freed once the
+last reader which pinned them was closed.
+
+Pins are only kept in memory. Pinned chunks which were freed are lost if the
+process ends before the readers were closed - they are no longer referenced
+by any large object but their storage locations are not freed. Such chunks
+can only be found by a consistency check which knows all objects of a storage
+manager (e.g. the check of the graph storage).
+*/
+var largeObjectPins = &chunkPins{make(map[Manager]map[uint64]int),
+	make(map[Manager]map[uint64]bool), &sync.Mutex{}}
+
+/*
+chunkPins data structure
+*/
+type chunkPins struct {
+	readers  map[Manager]map[uint64]int  // Number of open readers for each pinned chunk
+	released map[Manager]map[uint64]bool // Pinned chunks which should be freed
+	mutex    *sync.Mutex                 // Mutex to protect the maps
+}
+
+/*
+pin pins a list of chunks.
+*/
+func (cp *chunkPins) pin(sm Manager, chunks []uint64) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	readers, ok := cp.readers[sm]
+	if !ok {
+		readers = make(map[uint64]int)
+		cp.readers[sm] = readers
+	}
+
+	for _, chunk := range chunks {
+		readers[chunk]++
+	}
+}
+
+/*
+unpin unpins a list of chunks. Returns all chunks which were released while
+they were pinned and which are not pinned anymore.
+*/
+func (cp *chunkPins) unpin(sm Manager, chunks []uint64) []uint64 {
+	var ret []uint64
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	readers := cp.readers[sm]
+	released := cp.released[sm]
+
+	for _, chunk := range chunks {
+		if readers[chunk]--; readers[chunk] > 0 {
+			continue
+		}
+
+		delete(readers, chunk)
+
+		if released[chunk] {
+			delete(released, chunk)
+			ret = append(ret, chunk)
+		}
+	}
+
+	if len(readers) == 0 {
+		delete(cp.readers, sm)
+	}
+	if len(released) == 0 {
+		delete(cp.released, sm)
+	}
+
+	return ret
+}
+
+/*
+release marks a chunk as released. Returns false if the chunk is not pinned
+and can be freed immediately.
+*/
+func (cp *chunkPins) release(sm Manager, chunk uint64) bool {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if cp.readers[sm][chunk] == 0 {
+		return false
+	}
+
+	released, ok := cp.released[sm]
+	if !ok {
+		released = make(map[uint64]bool)
+		cp.released[sm] = released
+	}
+
+	released[chunk] = true
+
+	return true
+}
+
+/*
+freeChunk frees a chunk of a large object. The chunk is not freed if it is
+still read by an open reader.
+*/
+func freeChunk(sm Manager, chunk uint64) error {
+
+	if largeObjectPins.release(sm, chunk) {
+		return nil
+	}
+
+	return sm.Free(chunk)
+}
+
+/*
+fetchLargeObjectHeader fetches the header of a large object.
+*/
+func fetchLargeObjectHeader(sm Manager, loc uint64) (*LargeObjectHeader, error) {
+	var header LargeObjectHeader
+
+	if err := sm.Fetch(loc, &header); err != nil {
+		return nil, NewStorageManagerError(ErrInvalidLargeObject,
+			fmt.Sprintf("Location: %v - %v", loc, err), sm.Name())
+	}
+
+	return &header, nil
+}
+
+/*
+LargeObjectLocations returns the storage locations of the header and all
+chunks of a large object.
+*/
+func LargeObjectLocations(sm Manager, loc uint64) ([]uint64, error) {
+
+	header, err := fetchLargeObjectHeader(sm, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	locs := append([]uint64{loc}, header.Chunks...)
+
+	return append(locs, header.Pending...), nil
+}
+
+/*
+ReclaimLargeObject frees the pending chunks of a write which was interrupted
+(e.g. by a crash) after the writer flushed the storage manager. The object
+keeps the data it had before the interrupted write. Returns if any chunks were
+freed. Reclaiming must not happen concurrently with changes to the object. The
+caller is responsible for flushing the storage manager.
+*/
+func ReclaimLargeObject(sm Manager, loc uint64) (bool, error) {
+
+	header, err := fetchLargeObjectHeader(sm, loc)
+	if err != nil || len(header.Pending) == 0 {
+		return false, err
+	}
+
+	for _, chunk := range header.Pending {
+		if err := sm.Free(chunk); err != nil {
+			return false, err
+		}
+	}
+
+	header.Pending = nil
+
+	return true, sm.Update(loc, header)
+}
+
+/*
+FreeLargeObject frees the header and all chunks of a large object.
+*/
+func FreeLargeObject(sm Manager, loc uint64) error {
+
+	header, err := fetchLargeObjectHeader(sm, loc)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range header.Chunks {
+		if err := freeChunk(sm, chunk); err != nil {
+			return err
+		}
+	}
+
+	for _, chunk := range header.Pending {
+		if err := sm.Free(chunk); err != nil {
+			return err
+		}
+	}
+
+	return sm.Free(loc)
+}
+
+/*
+LargeObjectWriter writes a large object in chunks to a storage manager. The
+data of a large object is not complete before the writer has been closed.
+
+By default all chunks stay pending changes of the storage manager until the
+caller flushes it. A writer with a flush interval flushes the storage manager
+itself after the given number of chunks so the written data does not need to
+be kept in memory. Its chunks are recorded as pending chunks in the header of
+the object until the writer is closed.
+*/
+type LargeObjectWriter struct {
+	sm       Manager            // Storage manager which stores the object
+	loc      uint64             // Location of the object header
+	header   *LargeObjectHeader // Header of the object
+	old      *LargeObjectHeader // Header of the previous version of the object
+	buf      []byte             // Data which has not yet been written
+	interval int                // Number of chunks after which the storage manager is flushed
+	unsynced int                // Number of chunks which were written since the last flush
+	closed   bool               // Flag if the writer was closed
+}
+
+/*
+CreateLargeObject creates a new large object and returns a writer for its
+data. The location of the new object is available from the writer.
+*/
+func CreateLargeObject(sm Manager) (*LargeObjectWriter, error) {
+	header := &LargeObjectHeader{0, []uint64{}, nil}
+
+	loc, err := sm.Insert(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LargeObjectWriter{sm, loc, &LargeObjectHeader{0, []uint64{}, nil},
+		header, nil, 0, 0, false}, nil
+}
+
+/*
+RewriteLargeObject returns a writer which replaces the data of an existing
+large object. The chunks of the existing data are freed once the writer is
+closed.
+*/
+func RewriteLargeObject(sm Manager, loc uint64) (*LargeObjectWriter, error) {
+
+	header, err := fetchLargeObjectHeader(sm, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LargeObjectWriter{sm, loc, &LargeObjectHeader{0, []uint64{}, nil},
+		header, nil, 0, 0, false}, nil
+}
+
+/*
+Location returns the storage location of the large object.
+*/
+func (lw *LargeObjectWriter) Location() uint64 {
+	return lw.loc
+}
+
+/*
+SetFlushInterval sets the number of chunks after which the writer flushes the
+storage manager. A writer with a flush interval must be the only writer of the
+storage manager until it is closed or aborted. An interval of 0 disables the
+flushing.
+*/
+func (lw *LargeObjectWriter) SetFlushInterval(chunks int) {
+	lw.interval = chunks
+}
+
+/*
+Write writes data to the large object. Data is written to the storage manager
+as soon as a full chunk is available.
+*/
+func (lw *LargeObjectWriter) Write(p []byte) (int, error) {
+
+	if lw.closed {
+		return 0, ErrLargeObjectClosed
+	}
+
+	written := 0
+
+	for len(p) > 0 {
+		n := LargeObjectChunkSize - len(lw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		lw.buf = append(lw.buf, p[:n]...)
+		p = p[n:]
+
+		if len(lw.buf) == LargeObjectChunkSize {
+			if err := lw.writeChunk(); err != nil {
+				return written, err
+			}
+		}
+
+		written += n
+	}
+
+	return written, nil
+}
+
+/*
+writeChunk writes the current buffer as a new chunk.
+*/
+func (lw *LargeObjectWriter) writeChunk() error {
+	var loc uint64
+	var err error
+
+	if ui, ok := lw.sm.(UncachedInserter); ok {
+		loc, err = ui.InsertUncached(lw.buf)
+	} else {
+		loc, err = lw.sm.Insert(lw.buf)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lw.header.Chunks = append(lw.header.Chunks, loc)
+	lw.header.Size += uint64(len(lw.buf))
+
+	// Storage managers might keep a reference to the written buffer
+
+	lw.buf = nil
+
+	if lw.unsynced++; lw.interval > 0 && lw.unsynced >= lw.interval {
+		return lw.flush()
+	}
+
+	return nil
+}
+
+/*
+flush records all written chunks as pending chunks in the header of the
+object and flushes the storage manager.
+*/
+func (lw *LargeObjectWriter) flush() error {
+
+	pending := &LargeObjectHeader{lw.old.Size, lw.old.Chunks, lw.header.Chunks}
+
+	if err := lw.sm.Update(lw.loc, pending); err != nil {
+		return err
+	}
+
+	lw.unsynced = 0
+
+	return lw.sm.Flush()
+}
+
+/*
+Close writes all remaining data and the header of the large object. A writer
+which could not be closed can still be aborted.
+*/
+func (lw *LargeObjectWriter) Close() error {
+
+	if lw.closed {
+		return ErrLargeObjectClosed
+	}
+
+	if len(lw.buf) > 0 {
+		if err := lw.writeChunk(); err != nil {
+			return err
+		}
+	}
+
+	if err := lw.sm.Update(lw.loc, lw.header); err != nil {
+		return err
+	}
+
+	// The writer can no longer be aborted once the new header was written
+
+	lw.closed = true
+
+	for _, chunk := range lw.old.Chunks {
+		if err := freeChunk(lw.sm, chunk); err != nil {
+			return err
+		}
+	}
+
+	// Pending chunks of an earlier interrupted write are never read
+
+	for _, chunk := range lw.old.Pending {
+		if err := lw.sm.Free(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Abort discards all data which was written and frees all written chunks. The
+object keeps the data it had before the writer was created. Written chunks
+cannot be removed with a rollback of the storage manager once the writer has
+flushed it. The caller is responsible for flushing the storage manager.
+*/
+func (lw *LargeObjectWriter) Abort() error {
+
+	if lw.closed {
+		return ErrLargeObjectClosed
+	}
+
+	lw.closed = true
+	lw.buf = nil
+
+	for _, chunk := range lw.header.Chunks {
+		if err := lw.sm.Free(chunk); err != nil {
+			return err
+		}
+	}
+
+	return lw.sm.Update(lw.loc, lw.old)
+}
+
+/*
+LargeObjectReader reads a large object chunk by chunk from a storage manager.
+The chunks of the object are pinned while the reader is open. The object can
+be rewritten or freed while it is read - the reader always returns the data
+which the object had when the reader was opened.
+*/
+type LargeObjectReader struct {
+	sm     Manager            // Storage manager which stores the object
+	loc    uint64             // Location of the object header
+	header *LargeObjectHeader // Header of the object
+	chunk  int                // Index of the next chunk to read
+	buf    []byte             // Unread data of the current chunk
+	closed bool               // Flag if the reader was closed
+}
+
+/*
+OpenLargeObject opens a large object for reading. The reader must be closed
+once it is no longer needed. Opening a reader must not happen concurrently
+with changes to the object.
+*/
+func OpenLargeObject(sm Manager, loc uint64) (*LargeObjectReader, error) {
+
+	header, err := fetchLargeObjectHeader(sm, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	largeObjectPins.pin(sm, header.Chunks)
+
+	return &LargeObjectReader{sm, loc, header, 0, nil, false}, nil
+}
+
+/*
+Size returns the size of the large object in bytes.
+*/
+func (lr *LargeObjectReader) Size() uint64 {
+	return lr.header.Size
+}
+
+/*
+Read reads data from the large object. Returns io.EOF once all data has been read.
+*/
+func (lr *LargeObjectReader) Read(p []byte) (int, error) {
+
+	if lr.closed {
+		return 0, ErrLargeObjectClosed
+	}
+
+	for len(lr.buf) == 0 {
+
+		if lr.chunk >= len(lr.header.Chunks) {
+			return 0, io.EOF
+		}
+
+		var chunk []byte
+
+		if err := lr.sm.Fetch(lr.header.Chunks[lr.chunk], &chunk); err != nil {
+			return 0, err
+		}
+
+		lr.buf = chunk
+		lr.chunk++
+	}
+
+	n := copy(p, lr.buf)
+	lr.buf = lr.buf[n:]
+
+	return n, nil
+}
+
+/*
+Close closes the reader and unpins the chunks of the object. Chunks which were
+freed while the reader was open are freed now unless the object still refers
+to them (e.g. because their release was rolled back). Closing a reader must not
+happen concurrently with changes to the object. The caller is responsible for
+flushing the storage manager.
+*/
+func (lr *LargeObjectReader) Close() error {
+
+	if lr.closed {
+		return ErrLargeObjectClosed
+	}
+
+	lr.closed = true
+	lr.buf = nil
+
+	released := largeObjectPins.unpin(lr.sm, lr.header.Chunks)
+	if len(released) == 0 {
+		return nil
+	}
+
+	current := make(map[uint64]bool)
+
+	if header, err := fetchLargeObjectHeader(lr.sm, lr.loc); err == nil {
+		for _, chunk := range header.Chunks {
+			current[chunk] = true
+		}
+	}
+
+	for _, chunk := range released {
+		if !current[chunk] {
+			if err := lr.sm.Free(chunk); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,473 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLargeObject(t *testing.T) {
+
+	oldChunkSize := LargeObjectChunkSize
+	LargeObjectChunkSize = 10
+	defer func() {
+		LargeObjectChunkSize = oldChunkSize
+	}()
+
+	data := []byte("This is a large object which spans several chunks.")
+
+	for _, mode := range []int{CodecGob, CodecBinary} {
+
+		dsm := NewDiskStorageManagerWithOptions(fmt.Sprintf("%v/largeobject%v", DBDIR, mode),
+			false, false, false, true, DiskStorageManagerOptions{Format: FormatOptions{Codec: mode}})
+		sm := NewCachedDiskStorageManager(dsm, 10)
+
+		lw, err := CreateLargeObject(sm)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Write the data in uneven pieces
+
+		if n, err := lw.Write(data[:7]); n != 7 || err != nil {
+			t.Error("Unexpected result:", n, err)
+			return
+		}
+
+		if n, err := io.Copy(lw, bytes.NewReader(data[7:])); n != int64(len(data)-7) || err != nil {
+			t.Error("Unexpected result:", n, err)
+			return
+		}
+
+		if err := lw.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := lw.Write(data); err != ErrLargeObjectClosed {
+			t.Error("Unexpected result:", err)
+			return
+		}
+
+		if err := lw.Close(); err != ErrLargeObjectClosed {
+			t.Error("Unexpected result:", err)
+			return
+		}
+
+		loc := lw.Location()
+
+		locs, err := LargeObjectLocations(sm, loc)
+		if len(locs) != 1+(len(data)+9)/10 || locs[0] != loc || err != nil {
+			t.Error("Unexpected result:", locs, err)
+			return
+		}
+
+		if err := sm.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Read the object after reopening the datastore
+
+		dsm = NewDiskStorageManager(fmt.Sprintf("%v/largeobject%v", DBDIR, mode),
+			false, false, false, true)
+		sm = NewCachedDiskStorageManager(dsm, 10)
+
+		lr, err := OpenLargeObject(sm, loc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if lr.Size() != uint64(len(data)) {
+			t.Error("Unexpected size:", lr.Size())
+			return
+		}
+
+		if res, err := ioutil.ReadAll(lr); string(res) != string(data) || err != nil {
+			t.Error("Unexpected result:", string(res), err)
+			return
+		}
+
+		if err := lr.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := lr.Read(nil); err != ErrLargeObjectClosed {
+			t.Error("Unexpected result:", err)
+			return
+		}
+
+		if err := lr.Close(); err != ErrLargeObjectClosed {
+			t.Error("Unexpected result:", err)
+			return
+		}
+
+		// Rewrite the object
+
+		lw, err = RewriteLargeObject(sm, loc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		lw.Write([]byte("Short object"))
+
+		if err := lw.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if lw.Location() != loc {
+			t.Error("Unexpected location:", lw.Location())
+			return
+		}
+
+		lr, _ = OpenLargeObject(sm, loc)
+
+		if res, err := ioutil.ReadAll(lr); string(res) != "Short object" || err != nil {
+			t.Error("Unexpected result:", string(res), err)
+			return
+		}
+
+		lr.Close()
+
+		// Old chunks should have been freed
+
+		var res []byte
+		if err := sm.Fetch(locs[1], &res); err == nil {
+			t.Error("Old chunk should have been freed")
+			return
+		}
+
+		newlocs, _ := LargeObjectLocations(sm, loc)
+
+		if err := FreeLargeObject(sm, loc); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, l := range newlocs {
+			if err := sm.Fetch(l, &res); err == nil {
+				t.Error("Location should have been freed:", l)
+				return
+			}
+		}
+
+		if _, err := OpenLargeObject(sm, loc); err == nil ||
+			err.(*ManagerError).Type != ErrInvalidLargeObject {
+			t.Error("Unexpected result:", err)
+			return
+		}
+
+		if err := sm.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestLargeObjectErrors(t *testing.T) {
+
+	msm := NewMemoryStorageManager("test")
+
+	msm.AccessMap[1] = AccessInsertError
+
+	if _, err := CreateLargeObject(msm); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(msm.AccessMap, 1)
+
+	// An object which is not a large object cannot be opened
+
+	loc, _ := msm.Insert("test")
+
+	if _, err := OpenLargeObject(msm, loc); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	} else if _, err := RewriteLargeObject(msm, loc); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	} else if _, err := LargeObjectLocations(msm, loc); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	} else if err := FreeLargeObject(msm, loc); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Test a failing chunk fetch
+
+	lw, _ := CreateLargeObject(msm)
+	lw.Write([]byte("test"))
+	lw.Close()
+
+	lr, _ := OpenLargeObject(msm, lw.Location())
+
+	msm.AccessMap[lr.header.Chunks[0]] = AccessFetchError
+
+	if _, err := ioutil.ReadAll(lr); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	lr.Close()
+}
+
+func TestLargeObjectPinning(t *testing.T) {
+
+	oldChunkSize := LargeObjectChunkSize
+	LargeObjectChunkSize = 10
+	defer func() {
+		LargeObjectChunkSize = oldChunkSize
+	}()
+
+	data := []byte("This is a large object which spans several chunks.")
+
+	dsm := NewDiskStorageManager(DBDIR+"/largeobjectpins", false, false, false, true)
+	sm := NewCachedDiskStorageManager(dsm, 10)
+	defer sm.Close()
+
+	lw, _ := CreateLargeObject(sm)
+	lw.Write(data)
+	lw.Close()
+	sm.Flush()
+
+	loc := lw.Location()
+	locs, _ := LargeObjectLocations(sm, loc)
+
+	// Chunks of an open reader are not freed when the object is rewritten
+
+	lr, _ := OpenLargeObject(sm, loc)
+	lr2, _ := OpenLargeObject(sm, loc)
+
+	lw, _ = RewriteLargeObject(sm, loc)
+	lw.Write([]byte("Short object"))
+	lw.Close()
+	sm.Flush()
+
+	if res, err := ioutil.ReadAll(lr); string(res) != string(data) || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	lr.Close()
+
+	var res []byte
+	if err := sm.Fetch(locs[1], &res); err != nil {
+		t.Error("Chunk should still be pinned:", err)
+		return
+	}
+
+	// Old chunks are freed once the last reader was closed
+
+	lr2.Close()
+
+	for _, l := range locs[1:] {
+		if err := sm.Fetch(l, &res); err == nil {
+			t.Error("Location should have been freed:", l)
+			return
+		}
+	}
+
+	// Chunks are not freed if their release was rolled back
+
+	newlocs, _ := LargeObjectLocations(sm, loc)
+	lr, _ = OpenLargeObject(sm, loc)
+
+	if err := FreeLargeObject(sm, loc); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm.Rollback()
+
+	lr.Close()
+
+	if lr, err := OpenLargeObject(sm, loc); err != nil {
+		t.Error(err)
+		return
+	} else if res, err := ioutil.ReadAll(lr); string(res) != "Short object" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	} else {
+		lr.Close()
+	}
+
+	// Chunks of a freed object are freed once the reader was closed
+
+	lr, _ = OpenLargeObject(sm, loc)
+	FreeLargeObject(sm, loc)
+	sm.Flush()
+
+	if res, err := ioutil.ReadAll(lr); string(res) != "Short object" || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	if err := lr.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, l := range newlocs {
+		if err := sm.Fetch(l, &res); err == nil {
+			t.Error("Location should have been freed:", l)
+			return
+		}
+	}
+
+	if len(largeObjectPins.readers) != 0 || len(largeObjectPins.released) != 0 {
+		t.Error("Unexpected pins:", largeObjectPins.readers, largeObjectPins.released)
+		return
+	}
+}
+
+func TestLargeObjectFlushInterval(t *testing.T) {
+	var res []byte
+
+	oldChunkSize := LargeObjectChunkSize
+	LargeObjectChunkSize = 10
+	defer func() {
+		LargeObjectChunkSize = oldChunkSize
+	}()
+
+	data := []byte("This is a large object which spans several chunks.")
+
+	dsm := NewDiskStorageManager(DBDIR+"/largeobjectflush", false, false, false, true)
+	sm := NewCachedDiskStorageManager(dsm, 10)
+
+	lw, _ := CreateLargeObject(sm)
+	lw.SetFlushInterval(2)
+
+	loc := lw.Location()
+
+	// Write three chunks - the first two are flushed
+
+	if _, err := lw.Write(data[:30]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	chunks := lw.header.Chunks
+
+	for _, chunk := range chunks {
+		if _, err := sm.FetchCached(chunk); err == nil {
+			t.Error("Chunks should not be cached:", chunk)
+			return
+		}
+	}
+
+	// Simulate a crash - the unflushed chunk is lost
+
+	sm.Rollback()
+	sm.Close()
+
+	dsm = NewDiskStorageManager(DBDIR+"/largeobjectflush", false, false, false, true)
+	sm = NewCachedDiskStorageManager(dsm, 10)
+
+	if locs, err := LargeObjectLocations(sm, loc); len(locs) != 3 || err != nil {
+		t.Error("Unexpected result:", locs, err)
+		return
+	}
+
+	// The data of an interrupted write is not visible
+
+	lr, _ := OpenLargeObject(sm, loc)
+
+	if res, err := ioutil.ReadAll(lr); len(res) != 0 || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	lr.Close()
+
+	if ok, err := ReclaimLargeObject(sm, loc); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := ReclaimLargeObject(sm, loc); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	for _, chunk := range chunks[:2] {
+		if err := sm.Fetch(chunk, &res); err == nil {
+			t.Error("Location should have been freed:", chunk)
+			return
+		}
+	}
+
+	// Write the object completely
+
+	lw, _ = RewriteLargeObject(sm, loc)
+	lw.SetFlushInterval(2)
+	lw.Write(data)
+
+	if err := lw.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm.Flush()
+
+	// An aborted write keeps the previous data even if chunks were flushed
+
+	lw, _ = RewriteLargeObject(sm, loc)
+	lw.SetFlushInterval(2)
+	lw.Write([]byte("This data is discarded"))
+
+	chunks = lw.header.Chunks
+
+	if err := lw.Abort(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := lw.Abort(); err != ErrLargeObjectClosed {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sm.Flush()
+
+	for _, chunk := range chunks {
+		if err := sm.Fetch(chunk, &res); err == nil {
+			t.Error("Location should have been freed:", chunk)
+			return
+		}
+	}
+
+	lr, _ = OpenLargeObject(sm, loc)
+
+	if res, err := ioutil.ReadAll(lr); string(res) != string(data) || err != nil {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+
+	lr.Close()
+
+	if locs, err := LargeObjectLocations(sm, loc); len(locs) != 1+(len(data)+9)/10 || err != nil {
+		t.Error("Unexpected result:", locs, err)
+		return
+	}
+
+	sm.Close()
+}
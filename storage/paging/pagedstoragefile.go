@@ -289,6 +289,74 @@ func (psf *PagedStorageFile) Prev(id uint64) (uint64, error) {
 	return pageview.PrevPage(), nil
 }
 
+/*
+Truncate removes all free pages from the end of the file and shrinks the
+underlying StorageFile. All pending changes are written to disk. Returns the
+number of removed pages.
+*/
+func (psf *PagedStorageFile) Truncate() (uint64, error) {
+
+	// The last pointer of the free list points to the next new page
+
+	last := psf.header.LastListElement(view.TypeFreePage)
+	if last == 0 {
+		return 0, nil
+	}
+
+	var freePages []uint64
+
+	free := make(map[uint64]bool)
+
+	for page := psf.header.FirstListElement(view.TypeFreePage); page != 0; {
+		freePages = append(freePages, page)
+		free[page] = true
+
+		next, err := psf.Next(page)
+		if err != nil {
+			return 0, err
+		}
+
+		page = next
+	}
+
+	newLast := last
+	for newLast > 1 && free[newLast-1] {
+		newLast--
+	}
+
+	if newLast == last {
+		return 0, nil
+	}
+
+	// Relink the remaining free pages
+
+	next := uint64(0)
+
+	for i := len(freePages) - 1; i >= 0; i-- {
+		if page := freePages[i]; page < newLast {
+
+			record, err := psf.storagefile.Get(page)
+			if err != nil {
+				return 0, err
+			}
+
+			view.GetPageView(record).SetNextPage(next)
+			psf.storagefile.ReleaseInUse(record)
+
+			next = page
+		}
+	}
+
+	psf.header.SetFirstListElement(view.TypeFreePage, next)
+	psf.header.SetLastListElement(view.TypeFreePage, newLast)
+
+	if err := psf.Flush(); err != nil {
+		return 0, err
+	}
+
+	return last - newLast, psf.storagefile.Truncate(newLast)
+}
+
 /*
 Flush writes all pending data to disk.
 */
@@ -341,6 +409,38 @@ func (psf *PagedStorageFile) Rollback() error {
 	return nil
 }
 
+/*
+Refresh discards all cached data of a replica and reads the latest committed
+changes of the writing process.
+*/
+func (psf *PagedStorageFile) Refresh() error {
+
+	if !psf.storagefile.IsReplica() {
+		return file.NewStorageFileError(file.ErrNotReplica, "", psf.storagefile.Name())
+	}
+
+	psf.storagefile.Discard(psf.header.record)
+
+	if err := psf.storagefile.Refresh(); err != nil {
+
+		// If there is a problem try to get the header record back
+		// otherwise close operations may fail later
+
+		psf.header.record, _ = psf.storagefile.Get(0)
+
+		return err
+	}
+
+	record, err := psf.storagefile.Get(0)
+	if err != nil {
+		return err
+	}
+
+	psf.header = NewPagedStorageFileHeader(record, record.ReadInt16(0) == 0)
+
+	return nil
+}
+
 /*
 Close commits all data and closes all physical files.
 */
@@ -510,6 +510,86 @@ func TestPagedStorageFileTransactionPageManagement(t *testing.T) {
 	}
 }
 
+func TestPagedStorageFileRefresh(t *testing.T) {
+
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test10", false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rsf, err := file.NewStorageFileWithOptions(DBDIR+"/test10", file.DefaultRecordSize, false,
+		file.StorageFileOptions{Replica: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rpsf, err := NewPagedStorageFile(rsf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := psf.AllocatePage(view.TypeDataPage); err != nil {
+		t.Error(err)
+		return
+	}
+
+	psf.Header().SetRoot(1, 5)
+
+	if err := psf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if rpsf.First(view.TypeDataPage) != 0 || rpsf.Header().Root(1) != 0 {
+		t.Error("Unexpected replica state")
+		return
+	}
+
+	// The header of the replica is read again on refresh
+
+	if err := rpsf.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if rpsf.First(view.TypeDataPage) != 1 || rpsf.Header().Root(1) != 5 {
+		t.Error("Unexpected replica state")
+		return
+	}
+
+	// Only replicas can be refreshed - the header stays available
+
+	if err := psf.Refresh(); err == nil || err.Error() !=
+		"Storage file is not a replica (pagingtest/test10 - )" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if psf.Header().Root(1) != 5 {
+		t.Error("Unexpected header state")
+		return
+	}
+
+	if err := rpsf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
 func checkPrevAndNext(t *testing.T, psf *PagedStorageFile, rid uint64,
 	prev uint64, next uint64) {
 
@@ -534,3 +614,77 @@ func checkPrevAndNext(t *testing.T, psf *PagedStorageFile, rid uint64,
 	}
 
 }
+
+func TestPagedStorageFileTruncate(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test8", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := psf.Truncate(); n != 0 || err != nil {
+		t.Error("Unexpected truncate result:", n, err)
+		return
+	}
+
+	for i := 0; i < 6; i++ {
+		if _, err := psf.AllocatePage(view.TypeDataPage); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for _, p := range []uint64{5, 2, 6} {
+		if err := psf.FreePage(p); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if n, err := psf.Truncate(); n != 2 || err != nil {
+		t.Error("Unexpected truncate result:", n, err)
+		return
+	}
+
+	if n, err := psf.Truncate(); n != 0 || err != nil {
+		t.Error("Unexpected truncate result:", n, err)
+		return
+	}
+
+	if psf.First(view.TypeFreePage) != 2 || psf.Header().LastListElement(view.TypeFreePage) != 5 {
+		t.Error("Unexpected free list:", psf.First(view.TypeFreePage),
+			psf.Header().LastListElement(view.TypeFreePage))
+		return
+	}
+
+	if stat, err := os.Stat(DBDIR + "/test8.0"); err != nil ||
+		stat.Size() != 5*file.DefaultRecordSize {
+		t.Error("Unexpected file size:", stat, err)
+		return
+	}
+
+	// Free pages are reused before new pages are allocated
+
+	for _, expected := range []uint64{2, 5} {
+		if p, err := psf.AllocatePage(view.TypeDataPage); p != expected || err != nil {
+			t.Error("Unexpected allocated page:", p, err)
+			return
+		}
+	}
+
+	if problems, err := CheckPageLists(psf, false); len(problems) != 0 || err != nil {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -32,6 +32,14 @@ OffsetRoots is the number of lists which can be stored in this header
 */
 const OffsetRoots = OffsetLists + (2 * TotalLists * file.SizeLong)
 
+/*
+SizeFlags is the size of the flags value which is stored at the end of the header.
+Since roots are 8 byte values and the header starts with a 2 byte magic there is
+always some unused space at the end of a header record which has a size which is
+a multiple of 8.
+*/
+const SizeFlags = file.SizeUnsignedShort
+
 /*
 PagedStorageFileHeader data structure
 */
@@ -90,6 +98,43 @@ func (psfh *PagedStorageFileHeader) SetRoot(root int, val uint64) {
 	psfh.record.WriteUInt64(offsetRoot(root), val)
 }
 
+/*
+Flags returns the flags value of this header. Returns 0 if the header record has no
+space for flags.
+*/
+func (psfh *PagedStorageFileHeader) Flags() uint16 {
+	offset := psfh.offsetFlags()
+	if offset == -1 {
+		return 0
+	}
+	return psfh.record.ReadUInt16(offset)
+}
+
+/*
+SetFlags sets the flags value of this header. SetFlags panics if the header
+record has no space for flags.
+*/
+func (psfh *PagedStorageFileHeader) SetFlags(val uint16) {
+	offset := psfh.offsetFlags()
+	if offset == -1 {
+		panic("Cannot store flags - record is too small")
+	}
+	psfh.record.WriteUInt16(offset, val)
+}
+
+/*
+offsetFlags calculates the offset of the flags in the header record. The flags are
+stored in the last bytes of the header record after all roots. Returns -1 if
+there is not enough space.
+*/
+func (psfh *PagedStorageFileHeader) offsetFlags() int {
+	offset := len(psfh.record.Data()) - SizeFlags
+	if offset < offsetRoot(psfh.totalRoots) {
+		return -1
+	}
+	return offset
+}
+
 /*
 offsetRoot calculates the offset of a root in the header record.
 */
@@ -39,6 +39,21 @@ func TestPagedStorageFileHeader(t *testing.T) {
 		t.Error("Unexpected root value:", psfh.Root(0))
 	}
 
+	if psfh.Flags() != 0 {
+		t.Error("Unexpected flags value:", psfh.Flags())
+	}
+
+	psfh.SetFlags(0x1234)
+	if psfh.Flags() != 0x1234 {
+		t.Error("Unexpected flags value:", psfh.Flags())
+	}
+
+	psfh.SetRoot(1, 0xFFFFFFFFFFFFFFFF)
+	if psfh.Flags() != 0x1234 || psfh.Root(1) != 0xFFFFFFFFFFFFFFFF {
+		t.Error("Flags and roots should not overlap:", psfh.Flags(), psfh.Root(1))
+	}
+	psfh.SetRoot(1, 0x42)
+
 	psfh.SetFirstListElement(3, 5)
 	if psfh.FirstListElement(3) != 5 {
 		t.Error("Unexpected root value:", psfh.FirstListElement(3))
@@ -50,6 +65,26 @@ func TestPagedStorageFileHeader(t *testing.T) {
 	}
 }
 
+func TestPagedStorageFileHeaderFlags(t *testing.T) {
+
+	// Record with no space for flags
+
+	record := file.NewRecord(5, make([]byte, 98, 98))
+	psfh := NewPagedStorageFileHeader(record, true)
+
+	if psfh.Flags() != 0 {
+		t.Error("Unexpected flags value:", psfh.Flags())
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Setting flags on a record which is too small did not cause a panic.")
+		}
+	}()
+
+	psfh.SetFlags(1)
+}
+
 func testPagedStorageFileInitPanic1(t *testing.T, r *file.Record) {
 	defer func() {
 		if r := recover(); r == nil {
@@ -10,6 +10,12 @@
 
 package paging
 
+import (
+	"fmt"
+
+	"github.com/krotik/eliasdb/storage/paging/view"
+)
+
 /*
 CountPages counts the number of pages of a certain type of a given PagedStorageFile.
 */
@@ -33,3 +39,119 @@ func CountPages(pager *PagedStorageFile, pagetype int16) (int, error) {
 
 	return counter, nil
 }
+
+/*
+CheckPageLists checks the linked lists of all page types of a given
+PagedStorageFile. Each page must be part of exactly one list, the page type
+stored on a page must match its list and the previous and last page pointers
+must be correct. Returns a list of found problems. If the repair flag is set
+then wrong pointers are corrected and pages which are not part of any list
+are added to the free list.
+*/
+func CheckPageLists(pager *PagedStorageFile, repair bool) ([]string, error) {
+	var problems []string
+
+	sf := pager.StorageFile()
+	header := pager.Header()
+
+	// The last pointer of the free list points to the next new page
+
+	nextNew := header.LastListElement(view.TypeFreePage)
+	if nextNew == 0 {
+		nextNew = 1
+	}
+
+	seen := make(map[uint64]int16)
+
+	for ptype := int16(view.TypeFreePage); ptype <= view.TypeFreePhysicalSlotPage; ptype++ {
+		var prev uint64
+
+		page := header.FirstListElement(ptype)
+
+		for page != 0 {
+
+			if page >= nextNew {
+				problems = append(problems, fmt.Sprintf(
+					"Page %v in list of type %v was never allocated", page, ptype))
+				break
+			}
+
+			if otype, ok := seen[page]; ok {
+				problems = append(problems, fmt.Sprintf(
+					"Page %v in list of type %v is already in list of type %v", page, ptype, otype))
+				break
+			}
+
+			seen[page] = ptype
+
+			record, err := sf.Get(page)
+			if err != nil {
+				return problems, err
+			}
+
+			if record.ReadInt16(0) != view.ViewPageHeader+ptype {
+				problems = append(problems, fmt.Sprintf(
+					"Page %v in list of type %v has an unexpected header: %v", page, ptype,
+					record.ReadInt16(0)))
+
+				sf.ReleaseInUse(record)
+				break
+			}
+
+			// Free pages do not maintain the previous page pointer
+
+			if ptype != view.TypeFreePage && record.ReadUInt64(view.OffsetPrevPage) != prev {
+				problems = append(problems, fmt.Sprintf(
+					"Page %v in list of type %v has wrong previous page: %v (expected %v)",
+					page, ptype, record.ReadUInt64(view.OffsetPrevPage), prev))
+
+				if repair {
+					record.WriteUInt64(view.OffsetPrevPage, prev)
+				}
+			}
+
+			prev = page
+			page = record.ReadUInt64(view.OffsetNextPage)
+
+			sf.ReleaseInUse(record)
+		}
+
+		if ptype != view.TypeFreePage && header.LastListElement(ptype) != prev {
+			problems = append(problems, fmt.Sprintf(
+				"List of type %v has wrong last page: %v (expected %v)",
+				ptype, header.LastListElement(ptype), prev))
+
+			if repair {
+				header.SetLastListElement(ptype, prev)
+			}
+		}
+	}
+
+	// All allocated pages must be part of a list
+
+	for page := uint64(1); page < nextNew; page++ {
+		if _, ok := seen[page]; ok {
+			continue
+		}
+
+		problems = append(problems, fmt.Sprintf("Page %v is not part of any list", page))
+
+		if repair {
+			record, err := sf.Get(page)
+			if err != nil {
+				return problems, err
+			}
+
+			pv := view.NewPageView(record, view.TypeFreePage)
+			pv.SetNextPage(header.FirstListElement(view.TypeFreePage))
+			pv.SetPrevPage(0)
+			record.SetPageView(nil)
+
+			header.SetFirstListElement(view.TypeFreePage, page)
+
+			sf.ReleaseInUse(record)
+		}
+	}
+
+	return problems, nil
+}
@@ -11,6 +11,7 @@
 package paging
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/krotik/eliasdb/storage/file"
@@ -81,3 +82,86 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 }
+
+func TestCheckPageLists(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test6", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		psf.AllocatePage(view.TypeDataPage)
+		psf.AllocatePage(view.TypeTranslationPage)
+	}
+
+	psf.FreePage(3)
+
+	if problems, err := CheckPageLists(psf, false); len(problems) != 0 || err != nil {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	// Break the data page list (page 5 is removed from the list)
+
+	r, _ := sf.Get(1)
+	r.WriteUInt64(view.OffsetNextPage, 0)
+	sf.ReleaseInUse(r)
+
+	// Create a wrong previous pointer
+
+	r, _ = sf.Get(6)
+	r.WriteUInt64(view.OffsetPrevPage, 1)
+	sf.ReleaseInUse(r)
+
+	problems, err := CheckPageLists(psf, true)
+	if fmt.Sprint(problems) != "[List of type 1 has wrong last page: 5 (expected 1) "+
+		"Page 6 in list of type 2 has wrong previous page: 1 (expected 4) "+
+		"Page 5 is not part of any list]" || err != nil {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	if problems, err := CheckPageLists(psf, false); len(problems) != 0 || err != nil {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	if pc, err := CountPages(psf, view.TypeFreePage); pc != 2 || err != nil {
+		t.Error("Unexpected page count result:", pc, err)
+	}
+
+	// Put a page into two lists
+
+	psf.header.SetFirstListElement(view.TypeFreeLogicalSlotPage, 1)
+
+	problems, err = CheckPageLists(psf, false)
+	if fmt.Sprint(problems) != "[Page 1 in list of type 3 is already in list of type 1]" || err != nil {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	psf.header.SetFirstListElement(view.TypeFreeLogicalSlotPage, 0)
+
+	// Check error handling
+
+	r, _ = sf.Get(1)
+
+	if _, err := CheckPageLists(psf, false); err == nil {
+		t.Error("Unexpected check result:", err)
+		return
+	}
+
+	sf.ReleaseInUse(r)
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
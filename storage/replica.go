@@ -0,0 +1,81 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import "github.com/krotik/common/errorutil"
+
+/*
+Refresher is a storage manager which reads files that are written by another
+process (replica) and which can pick up the changes of that process.
+*/
+type Refresher interface {
+
+	/*
+		Refresh discards all cached data and reads all changes which were
+		committed by the writing process. No other operations must run
+		while the storage manager is refreshed.
+	*/
+	Refresh() error
+}
+
+/*
+Refresh discards all cached data and reads all changes which were committed
+by the process which writes the files. Only storage managers which were
+opened as a replica can be refreshed.
+*/
+func (bdsm *ByteDiskStorageManager) Refresh() error {
+	bdsm.checkFileOpen()
+
+	ce := errorutil.NewCompositeError()
+
+	// Continue single threaded from here on
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	if err := bdsm.physicalSlotsPager.Refresh(); err != nil {
+		ce.Add(err)
+	}
+
+	if err := bdsm.physicalFreeSlotsPager.Refresh(); err != nil {
+		ce.Add(err)
+	}
+
+	if err := bdsm.logicalSlotsPager.Refresh(); err != nil {
+		ce.Add(err)
+	}
+
+	if err := bdsm.logicalFreeSlotsPager.Refresh(); err != nil {
+		ce.Add(err)
+	}
+
+	// Return errors if there were any
+
+	if ce.HasErrors() {
+		return ce
+	}
+
+	return nil
+}
+
+/*
+Refresh empties the cache and reads all changes which were committed by the
+process which writes the files of the underlying disk storage manager.
+*/
+func (cdsm *CachedDiskStorageManager) Refresh() error {
+	err := cdsm.diskstoragemanager.Refresh()
+
+	// Cache is emptied in any case
+
+	cdsm.clearCache()
+
+	return err
+}
@@ -0,0 +1,150 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReplica(t *testing.T) {
+	var res string
+
+	name := DBDIR + "/replica1"
+
+	dsm := NewDiskStorageManagerWithOptions(name, false, false, false, false,
+		DiskStorageManagerOptions{Format: FormatOptions{Compression: CompressionFlate}})
+
+	loc, err := dsm.Insert("test1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A replica does not take over the lockfile of the writing process
+
+	replica := NewCachedDiskStorageManager(NewDiskStorageManagerWithOptions(name, false, false,
+		false, false, DiskStorageManagerOptions{Replica: true}), 10)
+
+	var r Refresher = replica
+
+	if replica.DiskStorageManager().Compression() != CompressionFlate {
+		t.Error("Unexpected compression:", replica.DiskStorageManager().Compression())
+		return
+	}
+
+	if err := replica.Fetch(loc, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := replica.Insert("test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Changes are only visible after a refresh
+
+	if err := dsm.Update(loc, "test2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc2, err := dsm.Insert("test3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm.SetRoot(RootIDVersion+1, loc2)
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := replica.Fetch(loc, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := replica.FetchCached(loc); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := r.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := replica.FetchCached(loc); err == nil {
+		t.Error("Cache should be empty after a refresh")
+		return
+	}
+
+	if err := replica.Fetch(loc, &res); err != nil || res != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := replica.Fetch(replica.Root(RootIDVersion+1), &res); err != nil || res != "test3" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Write enough transactions so the changes are written to the data files
+
+	for i := 0; i < 20; i++ {
+		if err := dsm.Update(loc, fmt.Sprint("test", i)); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := dsm.Flush(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := r.Refresh(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := replica.Fetch(loc, &res); err != nil || res != "test19" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := replica.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Only replicas can be refreshed
+
+	if err := dsm.Refresh(); err == nil || !strings.Contains(err.Error(), "Storage file is not a replica") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"bytes"
+
+	"github.com/krotik/eliasdb/storage/paging"
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting/pageview"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+/*
+RelocateSlots copies the data of all used logical slots from one physical slot
+manager to another. The data is written in the order of the logical slots. If
+the target manager is empty then the copied data is stored without any gaps.
+Neither the logical slots nor the source manager are modified. Returns a map
+from each logical slot to its new physical slot.
+*/
+func RelocateSlots(lsm *LogicalSlotManager, from *PhysicalSlotManager,
+	to *PhysicalSlotManager) (map[uint64]uint64, error) {
+
+	var buf bytes.Buffer
+
+	ret := make(map[uint64]uint64)
+	cursor := paging.NewPageCursor(lsm.pager, view.TypeTranslationPage, 0)
+
+	page, err := cursor.Next()
+
+	for page != 0 && err == nil {
+		var slots []uint64
+
+		// Read all slots of the translation page
+
+		record, err := lsm.storagefile.Get(page)
+		if err != nil {
+			return ret, err
+		}
+
+		for i := uint16(0); i < lsm.elementsPerPage; i++ {
+			slots = append(slots, record.ReadUInt64(int(pageview.OffsetTransData+i*util.LocationSize)))
+		}
+
+		lsm.storagefile.ReleaseInUse(record)
+
+		// Copy the data of all used slots
+
+		for i, ploc := range slots {
+
+			if ploc == 0 {
+				continue
+			}
+
+			buf.Reset()
+
+			if err := from.Fetch(ploc, &buf); err != nil {
+				return ret, err
+			}
+
+			length := uint32(buf.Len())
+			size := length
+
+			// Slots with no data still need to be allocated
+
+			if size == 0 {
+				size = 1
+			}
+
+			newLoc, err := to.allocate(size)
+			if err == nil {
+				err = to.write(newLoc, buf.Bytes(), 0, length)
+			}
+
+			if err != nil {
+				return ret, err
+			}
+
+			ret[util.PackLocation(page, uint16(pageview.OffsetTransData+i*util.LocationSize))] = newLoc
+		}
+
+		page, err = cursor.Next()
+	}
+
+	return ret, err
+}
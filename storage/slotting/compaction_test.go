@@ -0,0 +1,107 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRelocateSlots(t *testing.T) {
+	lsm, psm, pagers := createCheckManagers(t, "test10")
+	_, psm2, pagers2 := createCheckManagers(t, "test11")
+
+	var locs []uint64
+
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprint("test data ", i))
+
+		ploc, err := psm.Insert(data, 0, uint32(len(data)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		lloc, err := lsm.Insert(ploc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		locs = append(locs, lloc)
+	}
+
+	// Free every other slot
+
+	for i := 0; i < 10; i += 2 {
+		ploc, _ := lsm.Fetch(locs[i])
+		psm.Free(ploc)
+		lsm.Free(locs[i])
+	}
+
+	// Store a slot with no data
+
+	ploc, _ := lsm.Fetch(locs[9])
+	if _, err := psm.Update(ploc, nil, 0, 0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	newLocs, err := RelocateSlots(lsm, psm, psm2)
+	if err != nil || len(newLocs) != 5 {
+		t.Error("Unexpected relocate result:", newLocs, err)
+		return
+	}
+
+	var last uint64
+
+	for i := 1; i < 10; i += 2 {
+		var buf bytes.Buffer
+
+		// Logical slots are not changed
+
+		if ploc, _ := lsm.Fetch(locs[i]); ploc == newLocs[locs[i]] {
+			t.Error("Logical slot should not have been updated")
+			return
+		}
+
+		// Data is stored in order of the logical slots
+
+		if newLocs[locs[i]] <= last {
+			t.Error("Unexpected order of relocated slots:", newLocs)
+			return
+		}
+
+		last = newLocs[locs[i]]
+
+		if err := psm2.Fetch(newLocs[locs[i]], &buf); err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := fmt.Sprint("test data ", i)
+		if i == 9 {
+			expected = ""
+		}
+
+		if buf.String() != expected {
+			t.Error("Unexpected relocated data:", buf.String())
+			return
+		}
+	}
+
+	for _, pager := range append(pagers, pagers2...) {
+		if err := pager.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
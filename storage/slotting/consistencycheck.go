@@ -0,0 +1,358 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/krotik/eliasdb/storage/file"
+	"github.com/krotik/eliasdb/storage/paging"
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting/pageview"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+/*
+CheckSlots checks the consistency of the slot tables of a logical and a
+physical slot manager. Every used logical slot must point to an allocated
+physical slot which is not referenced by any other logical slot. Slots which
+are on the free lists must not be in use. Allocated physical slots which are
+not referenced by any logical slot are reported as orphaned. Returns all used
+logical slots and a list of found problems. Unused logical slots which are not
+on the free list are not reported since freed logical slots are not reused.
+
+If the repair flag is set then the free lists of both managers are rebuilt:
+invalid and duplicate entries are dropped, orphaned physical slots are freed
+and physical slots which were lost are added again. References to invalid or
+shared physical slots cannot be repaired.
+
+All pending changes of both managers are flushed before the check.
+*/
+func CheckSlots(lsm *LogicalSlotManager, psm *PhysicalSlotManager, repair bool) ([]uint64, []string, error) {
+	var problems []string
+
+	if err := psm.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := lsm.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	// Collect all physical slots with their current size
+
+	physicalSlots := make(map[uint64]uint32)
+
+	err := walkPages(psm.pager, view.TypeDataPage, addProblem, func(page uint64, record *file.Record) error {
+		offset := uint32(record.ReadUInt16(pageview.OffsetFirst))
+
+		if offset != 0 && offset < pageview.OffsetData {
+			addProblem("Data page %v has an invalid first slot offset: %v", page, offset)
+			return nil
+		}
+
+		// An offset of 0 means the page holds only data of a previous slot
+
+		for offset != 0 && offset <= psm.recordSize-util.SizeInfoSize {
+			available := util.AvailableSize(record, int(offset))
+
+			if available == 0 {
+				break
+			}
+
+			loc := util.PackLocation(page, uint16(offset))
+			current := util.CurrentSize(record, int(offset))
+
+			if current > available {
+				addProblem("Physical slot %v has invalid size: %v (available %v)",
+					locationString(loc), current, available)
+			}
+
+			physicalSlots[loc] = current
+
+			offset += util.SizeInfoSize + available
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, problems, err
+	}
+
+	// Collect all logical slots and check their physical slots
+
+	var used []uint64
+	var unused = make(map[uint64]bool)
+	var referenced = make(map[uint64]uint64)
+
+	err = walkPages(lsm.pager, view.TypeTranslationPage, addProblem, func(page uint64, record *file.Record) error {
+		for i := uint16(0); i < lsm.elementsPerPage; i++ {
+			offset := pageview.OffsetTransData + i*util.LocationSize
+
+			lloc := util.PackLocation(page, offset)
+			ploc := record.ReadUInt64(int(offset))
+
+			if ploc == 0 {
+				unused[lloc] = true
+				continue
+			}
+
+			used = append(used, lloc)
+
+			if current, ok := physicalSlots[ploc]; !ok {
+				addProblem("Logical slot %v points to an invalid physical slot %v",
+					locationString(lloc), locationString(ploc))
+			} else if current == 0 {
+				addProblem("Logical slot %v points to a free physical slot %v",
+					locationString(lloc), locationString(ploc))
+			}
+
+			if other, ok := referenced[ploc]; ok {
+				addProblem("Physical slot %v is referenced by logical slot %v and %v",
+					locationString(ploc), locationString(other), locationString(lloc))
+			} else {
+				referenced[ploc] = lloc
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, problems, err
+	}
+
+	// Check the free logical slots
+
+	var freeLogical []uint64
+	var freeLogicalPages []uint64
+	var seen = make(map[uint64]bool)
+
+	err = walkPages(lsm.freeManager.pager, view.TypeFreeLogicalSlotPage, addProblem, func(page uint64, record *file.Record) error {
+		freeLogicalPages = append(freeLogicalPages, page)
+
+		flsp := pageview.NewFreeLogicalSlotPage(record)
+
+		for i := uint16(0); i < flsp.MaxSlots(); i++ {
+			loc := flsp.SlotInfoLocation(i)
+
+			if loc == 0 {
+				continue
+			} else if seen[loc] {
+				addProblem("Logical slot %v is more than once on the free list",
+					locationString(loc))
+			} else if !unused[loc] {
+				addProblem("Logical slot %v is on the free list but is in use or does not exist",
+					locationString(loc))
+			} else {
+				freeLogical = append(freeLogical, loc)
+			}
+
+			seen[loc] = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, problems, err
+	}
+
+	// Check the free physical slots
+
+	var freePhysicalPages []uint64
+	seen = make(map[uint64]bool)
+
+	err = walkPages(psm.freeManager.pager, view.TypeFreePhysicalSlotPage, addProblem, func(page uint64, record *file.Record) error {
+		freePhysicalPages = append(freePhysicalPages, page)
+
+		fpsp := pageview.NewFreePhysicalSlotPage(record)
+
+		for i := uint16(0); i < fpsp.MaxSlots(); i++ {
+			offset := pageview.OffsetData + i*pageview.SlotInfoSize
+
+			if fpsp.FreeSlotSize(offset) == 0 {
+				continue
+			}
+
+			loc := fpsp.SlotInfoLocation(i)
+
+			if seen[loc] {
+				addProblem("Physical slot %v is more than once on the free list",
+					locationString(loc))
+			} else if current, ok := physicalSlots[loc]; !ok {
+				addProblem("Physical slot %v is on the free list but does not exist",
+					locationString(loc))
+			} else if _, ok := referenced[loc]; ok || current != 0 {
+				addProblem("Physical slot %v is on the free list but is in use",
+					locationString(loc))
+			}
+
+			seen[loc] = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, problems, err
+	}
+
+	// Check for orphaned and lost physical slots
+
+	var locs, freePhysical, orphaned []uint64
+
+	for loc := range physicalSlots {
+		locs = append(locs, loc)
+	}
+
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+
+	for _, loc := range locs {
+		if _, ok := referenced[loc]; ok {
+			continue
+		}
+
+		if physicalSlots[loc] != 0 {
+			orphaned = append(orphaned, loc)
+		} else {
+			if !seen[loc] {
+				addProblem("Physical slot %v is neither in use nor on the free list",
+					locationString(loc))
+			}
+			freePhysical = append(freePhysical, loc)
+		}
+	}
+
+	for _, loc := range orphaned {
+		addProblem("Physical slot %v is not referenced by any logical slot",
+			locationString(loc))
+	}
+
+	if repair && len(problems) > 0 {
+		err = rebuildFreeLists(lsm, psm, freeLogicalPages, freeLogical,
+			freePhysicalPages, append(freePhysical, orphaned...))
+	}
+
+	return used, problems, err
+}
+
+/*
+rebuildFreeLists replaces the free lists of a logical and a physical slot manager.
+*/
+func rebuildFreeLists(lsm *LogicalSlotManager, psm *PhysicalSlotManager,
+	freeLogicalPages []uint64, freeLogical []uint64,
+	freePhysicalPages []uint64, freePhysical []uint64) error {
+
+	for _, page := range freeLogicalPages {
+		if err := lsm.freeManager.pager.FreePage(page); err != nil {
+			return err
+		}
+	}
+
+	for _, page := range freePhysicalPages {
+		if err := psm.freeManager.pager.FreePage(page); err != nil {
+			return err
+		}
+	}
+
+	for _, loc := range freeLogical {
+		lsm.freeManager.Add(loc)
+	}
+
+	psm.freeManager.lastMaxSlotSize = 0
+
+	for _, loc := range freePhysical {
+		page := util.LocationRecord(loc)
+		offset := int(util.LocationOffset(loc))
+
+		record, err := psm.storagefile.Get(page)
+		if err != nil {
+			return err
+		}
+
+		if util.CurrentSize(record, offset) != 0 {
+			util.SetCurrentSize(record, offset, 0)
+		}
+
+		psm.freeManager.Add(loc, util.AvailableSize(record, offset))
+
+		psm.storagefile.ReleaseInUse(record)
+	}
+
+	if err := psm.Flush(); err != nil {
+		return err
+	}
+
+	return lsm.Flush()
+}
+
+/*
+walkPages calls a given function for all pages of a given type. The page record
+is only valid during the function call. Cycles in the page list and pages with
+an unexpected type are reported as problems.
+*/
+func walkPages(pager *paging.PagedStorageFile, ptype int16,
+	addProblem func(string, ...interface{}), f func(uint64, *file.Record) error) error {
+
+	sf := pager.StorageFile()
+	visited := make(map[uint64]bool)
+
+	page := pager.First(ptype)
+
+	for page != 0 {
+
+		if visited[page] {
+			addProblem("Page list of type %v contains a cycle at page %v", ptype, page)
+			break
+		}
+
+		visited[page] = true
+
+		record, err := sf.Get(page)
+		if err != nil {
+			return err
+		}
+
+		if record.ReadInt16(0) != view.ViewPageHeader+ptype {
+			addProblem("Page %v in list of type %v has an unexpected header: %v",
+				page, ptype, record.ReadInt16(0))
+
+			sf.ReleaseInUse(record)
+			break
+		}
+
+		err = f(page, record)
+
+		page = record.ReadUInt64(view.OffsetNextPage)
+
+		sf.ReleaseInUse(record)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+locationString returns a readable representation of a slot location.
+*/
+func locationString(loc uint64) string {
+	return fmt.Sprintf("%v:%v", util.LocationRecord(loc), util.LocationOffset(loc))
+}
@@ -0,0 +1,149 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/krotik/eliasdb/storage/file"
+	"github.com/krotik/eliasdb/storage/paging"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+func createCheckManagers(t *testing.T, name string) (*LogicalSlotManager, *PhysicalSlotManager, []*paging.PagedStorageFile) {
+	var pagers []*paging.PagedStorageFile
+
+	for _, suffix := range []string{"_ldata", "_lfree", "_pdata", "_pfree"} {
+		sf, err := file.NewDefaultStorageFile(DBDIR+"/"+name+suffix, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		psf, err := paging.NewPagedStorageFile(sf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pagers = append(pagers, psf)
+	}
+
+	return NewLogicalSlotManager(pagers[0], pagers[1]),
+		NewPhysicalSlotManager(pagers[2], pagers[3], false), pagers
+}
+
+func TestCheckSlots(t *testing.T) {
+	lsm, psm, pagers := createCheckManagers(t, "test9")
+
+	var locs []uint64
+
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprint("test data ", i))
+
+		ploc, err := psm.Insert(data, 0, uint32(len(data)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		lloc, err := lsm.Insert(ploc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		locs = append(locs, lloc)
+	}
+
+	// Free one slot in the same way the storage manager does
+
+	ploc, _ := lsm.Fetch(locs[4])
+	psm.Free(ploc)
+	lsm.Free(locs[4])
+
+	used, problems, err := CheckSlots(lsm, psm, false)
+	if err != nil || len(problems) != 0 || fmt.Sprint(used) != fmt.Sprint(locs[:4]) {
+		t.Error("Unexpected check result:", used, problems, err)
+		return
+	}
+
+	// Create an orphaned physical slot
+
+	data := []byte("orphan")
+	orphan, _ := psm.Insert(data, 0, uint32(len(data)))
+
+	// Let two logical slots point to the same physical slot
+
+	ploc, _ = lsm.Fetch(locs[0])
+	ploc1, _ := lsm.Fetch(locs[1])
+	lsm.Update(locs[1], ploc)
+
+	used, problems, err = CheckSlots(lsm, psm, false)
+	if err != nil || len(used) != 4 || fmt.Sprint(problems) != fmt.Sprintf("[Physical slot %v is referenced by logical slot %v and %v "+
+		"Physical slot %v is not referenced by any logical slot "+
+		"Physical slot %v is not referenced by any logical slot]",
+		locationString(ploc), locationString(locs[0]), locationString(locs[1]),
+		locationString(ploc1), locationString(orphan)) {
+		t.Error("Unexpected check result:", used, problems, err)
+		return
+	}
+
+	// Repair - orphaned physical slots are freed but the shared slot is still shared
+
+	_, problems, err = CheckSlots(lsm, psm, true)
+	if err != nil || len(problems) != 3 {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	_, problems, err = CheckSlots(lsm, psm, false)
+	if err != nil || len(problems) != 1 {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	// Undo the shared slot and put a used slot on the free list
+
+	lsm.Update(locs[1], 0)
+
+	psm.freeManager.Add(ploc, 10)
+	lsm.freeManager.Add(locs[2])
+	lsm.freeManager.Add(util.PackLocation(1, 30))
+
+	_, problems, err = CheckSlots(lsm, psm, true)
+	if err != nil || fmt.Sprint(problems) != fmt.Sprintf("[Logical slot %v is on the free list but is in use or does not exist "+
+		"Logical slot 1:30 is on the free list but is in use or does not exist "+
+		"Physical slot %v is on the free list but is in use]",
+		locationString(locs[2]), locationString(ploc)) {
+		t.Error("Unexpected check result:", problems, err)
+		return
+	}
+
+	used, problems, err = CheckSlots(lsm, psm, false)
+	if err != nil || len(problems) != 0 || len(used) != 3 {
+		t.Error("Unexpected check result:", used, problems, err)
+		return
+	}
+
+	// Freed slots can be reused
+
+	data = []byte("orphan")
+	if ploc, err := psm.Insert(data, 0, uint32(len(data))); err != nil || ploc != ploc1 {
+		t.Error("Unexpected insert result:", locationString(ploc), err)
+		return
+	}
+
+	for _, pager := range pagers {
+		if err := pager.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
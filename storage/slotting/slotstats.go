@@ -0,0 +1,106 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"github.com/krotik/eliasdb/storage/file"
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting/pageview"
+	"github.com/krotik/eliasdb/storage/util"
+)
+
+/*
+SlotStats are statistics of the slots of a logical and a physical slot manager.
+*/
+type SlotStats struct {
+	LogicalSlots      uint64 // Number of used logical slots
+	FreeLogicalSlots  uint64 // Number of logical slots on the free list
+	PhysicalSlots     uint64 // Number of used physical slots
+	FreePhysicalSlots uint64 // Number of free physical slots
+	DataBytes         uint64 // Bytes of data in used physical slots
+	AllocatedBytes    uint64 // Bytes which are allocated for used physical slots
+	FreeBytes         uint64 // Bytes of free physical slots
+}
+
+/*
+CollectSlotStats collects statistics of the slot tables of a logical and a
+physical slot manager. Pending changes are included but not written.
+*/
+func CollectSlotStats(lsm *LogicalSlotManager, psm *PhysicalSlotManager) (*SlotStats, error) {
+	stats := &SlotStats{}
+
+	ignoreProblem := func(string, ...interface{}) {}
+
+	// Count the physical slots on all data pages
+
+	err := walkPages(psm.pager, view.TypeDataPage, ignoreProblem, func(page uint64, record *file.Record) error {
+		offset := uint32(record.ReadUInt16(pageview.OffsetFirst))
+
+		if offset != 0 && offset < pageview.OffsetData {
+			return nil
+		}
+
+		for offset != 0 && offset <= psm.recordSize-util.SizeInfoSize {
+			available := util.AvailableSize(record, int(offset))
+
+			if available == 0 {
+				break
+			}
+
+			if current := util.CurrentSize(record, int(offset)); current == 0 {
+				stats.FreePhysicalSlots++
+				stats.FreeBytes += uint64(available)
+			} else {
+				stats.PhysicalSlots++
+				stats.DataBytes += uint64(current)
+				stats.AllocatedBytes += uint64(available)
+			}
+
+			offset += util.SizeInfoSize + available
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the used logical slots
+
+	err = walkPages(lsm.pager, view.TypeTranslationPage, ignoreProblem, func(page uint64, record *file.Record) error {
+		for i := uint16(0); i < lsm.elementsPerPage; i++ {
+			if record.ReadUInt64(int(pageview.OffsetTransData+i*util.LocationSize)) != 0 {
+				stats.LogicalSlots++
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the free logical slots which are stored and which are pending
+
+	stats.FreeLogicalSlots = uint64(len(lsm.freeManager.slots))
+
+	err = walkPages(lsm.freeManager.pager, view.TypeFreeLogicalSlotPage, ignoreProblem, func(page uint64, record *file.Record) error {
+		stats.FreeLogicalSlots += uint64(pageview.NewFreeLogicalSlotPage(record).FreeSlotCount())
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
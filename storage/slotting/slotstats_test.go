@@ -0,0 +1,87 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"testing"
+)
+
+func TestCollectSlotStats(t *testing.T) {
+	lsm, psm, pagers := createCheckManagers(t, "test12")
+
+	stats, err := CollectSlotStats(lsm, psm)
+	if err != nil || *stats != (SlotStats{}) {
+		t.Error("Unexpected stats:", stats, err)
+		return
+	}
+
+	var locs []uint64
+
+	for i := 0; i < 5; i++ {
+		data := []byte("0123456789")
+
+		ploc, err := psm.Insert(data, 0, uint32(len(data)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		lloc, err := lsm.Insert(ploc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		locs = append(locs, lloc)
+	}
+
+	// Free two slots in the same way the storage manager does - freed logical
+	// slots are not reused so only the unused slots of the translation page
+	// are on the free list
+
+	for _, loc := range locs[3:] {
+		ploc, _ := lsm.Fetch(loc)
+		psm.Free(ploc)
+		lsm.Free(loc)
+	}
+
+	stats, err = CollectSlotStats(lsm, psm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats.LogicalSlots != 3 || stats.FreeLogicalSlots != uint64(lsm.ElementsPerPage()-5) ||
+		stats.PhysicalSlots != 3 || stats.FreePhysicalSlots != 2 || stats.DataBytes != 30 ||
+		stats.AllocatedBytes < 30 || stats.FreeBytes < 20 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Free logical slots are counted the same once they have been written
+
+	if err := lsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := CollectSlotStats(lsm, psm); err != nil || *res != *stats {
+		t.Error("Unexpected stats:", res, err)
+		return
+	}
+
+	for _, pager := range pagers {
+		if err := pager.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
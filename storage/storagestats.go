@@ -0,0 +1,191 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/krotik/eliasdb/storage/paging"
+	"github.com/krotik/eliasdb/storage/paging/view"
+	"github.com/krotik/eliasdb/storage/slotting"
+)
+
+/*
+Page types which are reported in the statistics of a storage manager
+*/
+const (
+	PagesData         = "data"         // Pages which contain stored records
+	PagesTranslation  = "translation"  // Pages which translate storage locations
+	PagesFreeLogical  = "freelogical"  // Pages which list free storage locations
+	PagesFreePhysical = "freephysical" // Pages which list free space for records
+	PagesFree         = "free"         // Pages which are free to be reused
+)
+
+/*
+ManagerStats contains statistics of a storage manager.
+*/
+type ManagerStats struct {
+	Name           string            // Name of the storage manager
+	Records        uint64            // Number of stored records
+	FreeLocations  uint64            // Number of free storage locations which can be reused
+	FreeSlots      uint64            // Number of free space slots for records
+	DataBytes      uint64            // Bytes of all stored records
+	AllocatedBytes uint64            // Bytes which are allocated for all stored records
+	FreeBytes      uint64            // Bytes of all free space slots
+	FileBytes      int64             // Size of all files on disk in bytes
+	Pages          map[string]uint64 // Number of pages for each page type
+	Cache          CacheStats        // Statistics of the cache
+}
+
+/*
+NewManagerStats creates a new empty ManagerStats object.
+*/
+func NewManagerStats(name string) *ManagerStats {
+	return &ManagerStats{Name: name, Pages: make(map[string]uint64)}
+}
+
+/*
+Add adds the values of other storage manager statistics.
+*/
+func (ms *ManagerStats) Add(other *ManagerStats) {
+	ms.Records += other.Records
+	ms.FreeLocations += other.FreeLocations
+	ms.FreeSlots += other.FreeSlots
+	ms.DataBytes += other.DataBytes
+	ms.AllocatedBytes += other.AllocatedBytes
+	ms.FreeBytes += other.FreeBytes
+	ms.FileBytes += other.FileBytes
+
+	for k, v := range other.Pages {
+		ms.Pages[k] += v
+	}
+
+	ms.Cache.Add(other.Cache)
+}
+
+/*
+Fragmentation returns the fraction of the space for records which is free. A
+compaction of the storage removes all free space.
+*/
+func (ms *ManagerStats) Fragmentation() float64 {
+	if total := ms.AllocatedBytes + ms.FreeBytes; total > 0 {
+		return float64(ms.FreeBytes) / float64(total)
+	}
+	return 0
+}
+
+/*
+StatsReporter is a storage manager which can report statistics of its data.
+*/
+type StatsReporter interface {
+
+	/*
+		Stats returns the current statistics of this storage manager.
+	*/
+	Stats() (*ManagerStats, error)
+}
+
+/*
+Stats returns the current statistics of all managed files. The statistics
+include all pending changes. All slot tables are read so the call can take
+some time for large files.
+*/
+func (bdsm *ByteDiskStorageManager) Stats() (*ManagerStats, error) {
+	bdsm.checkFileOpen()
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	stats := NewManagerStats(bdsm.filename)
+
+	slots, err := slotting.CollectSlotStats(bdsm.logicalSlotManager, bdsm.physicalSlotManager)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Records = slots.LogicalSlots
+	stats.FreeLocations = slots.FreeLogicalSlots
+	stats.FreeSlots = slots.FreePhysicalSlots
+	stats.DataBytes = slots.DataBytes
+	stats.AllocatedBytes = slots.AllocatedBytes
+	stats.FreeBytes = slots.FreeBytes
+
+	// Count the pages of all files
+
+	pageTypes := []struct {
+		pager    *paging.PagedStorageFile
+		pagetype int16
+		name     string
+	}{
+		{bdsm.physicalSlotsPager, view.TypeDataPage, PagesData},
+		{bdsm.logicalSlotsPager, view.TypeTranslationPage, PagesTranslation},
+		{bdsm.logicalFreeSlotsPager, view.TypeFreeLogicalSlotPage, PagesFreeLogical},
+		{bdsm.physicalFreeSlotsPager, view.TypeFreePhysicalSlotPage, PagesFreePhysical},
+		{bdsm.physicalSlotsPager, view.TypeFreePage, PagesFree},
+		{bdsm.logicalSlotsPager, view.TypeFreePage, PagesFree},
+		{bdsm.logicalFreeSlotsPager, view.TypeFreePage, PagesFree},
+		{bdsm.physicalFreeSlotsPager, view.TypeFreePage, PagesFree},
+	}
+
+	for _, pt := range pageTypes {
+		count, err := paging.CountPages(pt.pager, pt.pagetype)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Pages[pt.name] += uint64(count)
+	}
+
+	// Sum up the sizes of all files on disk
+
+	files, err := filepath.Glob(fmt.Sprintf("%v.*", bdsm.filename))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			stats.FileBytes += info.Size()
+		}
+	}
+
+	return stats, nil
+}
+
+/*
+Stats returns the current statistics of the underlying disk storage manager
+and of the cache.
+*/
+func (cdsm *CachedDiskStorageManager) Stats() (*ManagerStats, error) {
+	stats, err := cdsm.diskstoragemanager.Stats()
+
+	if err == nil {
+		stats.Cache = cdsm.CacheStats()
+	}
+
+	return stats, err
+}
+
+/*
+Stats returns the current statistics of this storage manager. Only the number
+of stored records is reported.
+*/
+func (msm *MemoryStorageManager) Stats() (*ManagerStats, error) {
+	msm.mutex.Lock()
+	defer msm.mutex.Unlock()
+
+	stats := NewManagerStats(msm.name)
+	stats.Records = uint64(len(msm.Data))
+
+	return stats, nil
+}
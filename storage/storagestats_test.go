@@ -0,0 +1,128 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestManagerStats(t *testing.T) {
+	var locs []uint64
+
+	dsm := NewDiskStorageManager(DBDIR+"/stats1", false, false, false, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	var sr StatsReporter = cdsm
+
+	stats, err := sr.Stats()
+	if err != nil || stats.Records != 0 || stats.Fragmentation() != 0 {
+		t.Error("Unexpected stats:", stats, err)
+		return
+	}
+
+	data := strings.Repeat("x", 1000)
+
+	for i := 0; i < 100; i++ {
+		loc, err := cdsm.Insert(fmt.Sprint(i, data))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	for _, loc := range locs[:40] {
+		if err := cdsm.Free(loc); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	cdsm.FetchCached(locs[95])
+
+	if err := cdsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, err = sr.Stats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats.Name != DBDIR+"/stats1" || stats.Records != 60 || stats.FreeSlots != 40 ||
+		stats.DataBytes < 60000 || stats.AllocatedBytes < stats.DataBytes ||
+		stats.FreeBytes < 40000 || stats.FileBytes == 0 || stats.FreeLocations == 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if stats.Pages[PagesData] < 10 || stats.Pages[PagesTranslation] != 1 ||
+		stats.Pages[PagesFreePhysical] != 1 || stats.Pages[PagesFree] != 0 {
+		t.Error("Unexpected pages:", stats.Pages)
+		return
+	}
+
+	if stats.Cache.Objects != 10 || stats.Cache.Hits != 1 {
+		t.Error("Unexpected cache stats:", stats.Cache)
+		return
+	}
+
+	if f := stats.Fragmentation(); f < 0.35 || f > 0.45 {
+		t.Error("Unexpected fragmentation:", f)
+		return
+	}
+
+	// Statistics can be added up
+
+	total := NewManagerStats("total")
+	total.Add(stats)
+	total.Add(stats)
+
+	if total.Records != 120 || total.DataBytes != 2*stats.DataBytes ||
+		total.Pages[PagesData] != 2*stats.Pages[PagesData] || total.Cache.Objects != 20 ||
+		total.Fragmentation() != stats.Fragmentation() {
+		t.Error("Unexpected total stats:", total)
+		return
+	}
+
+	// Compaction removes all free space
+
+	if err := cdsm.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, err = cdsm.Stats()
+	if err != nil || stats.Records != 60 || stats.FreeSlots != 0 || stats.Fragmentation() != 0 {
+		t.Error("Unexpected stats:", stats, err)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Memory storage managers report the number of stored objects
+
+	msm := NewMemoryStorageManager("test")
+	msm.Insert("a")
+	msm.Insert("b")
+
+	if stats, err := msm.Stats(); err != nil || stats.Name != "test" || stats.Records != 2 {
+		t.Error("Unexpected stats:", stats, err)
+		return
+	}
+}
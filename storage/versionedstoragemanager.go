@@ -0,0 +1,397 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"sync"
+)
+
+/*
+versionEntry is the stored form of an object before it was changed in a
+given version.
+*/
+type versionEntry struct {
+	version uint64 // Version in which the object was changed
+	data    []byte // Serialized form of the object before the change
+}
+
+/*
+rootVersionEntry is the value of a root before it was changed in a given
+version.
+*/
+type rootVersionEntry struct {
+	version uint64 // Version in which the root was changed
+	value   uint64 // Value of the root before the change
+}
+
+/*
+VersionedStorageManager is a wrapper for a CachedDiskStorageManager which
+keeps old versions of changed objects as long as they are needed by open
+snapshots (copy-on-write). Readers of a snapshot see the stored objects as
+they were when the snapshot was opened while changes are being written.
+Old versions are only kept while snapshots are open.
+*/
+type VersionedStorageManager struct {
+	*CachedDiskStorageManager
+
+	tracker *VersionTracker // Tracker for versions and open snapshots
+	since   uint64          // Version in which the storage was created (0 if it existed before)
+	mutex   *sync.RWMutex   // Mutex to protect the old versions
+
+	entries       map[uint64][]*versionEntry  // Old versions of objects (ordered by version)
+	captured      map[uint64]uint64           // Last version in which an object was captured
+	rootEntries   map[int][]*rootVersionEntry // Old versions of roots (ordered by version)
+	capturedRoots map[int]uint64              // Last version in which a root was captured
+}
+
+/*
+NewVersionedStorageManager creates a new versioned wrapper for a
+CachedDiskStorageManager. The created flag indicates that the storage was
+newly created and did not exist for earlier snapshots.
+*/
+func NewVersionedStorageManager(cdsm *CachedDiskStorageManager, tracker *VersionTracker,
+	created bool) *VersionedStorageManager {
+
+	var since uint64
+
+	if created {
+		since = tracker.Version()
+	}
+
+	vsm := &VersionedStorageManager{cdsm, tracker, since, &sync.RWMutex{},
+		make(map[uint64][]*versionEntry), make(map[uint64]uint64),
+		make(map[int][]*rootVersionEntry), make(map[int]uint64)}
+
+	tracker.register(vsm)
+
+	return vsm
+}
+
+/*
+SetRoot writes a root value.
+*/
+func (vsm *VersionedStorageManager) SetRoot(root int, val uint64) {
+
+	if version, ok := vsm.tracker.writeVersion(); ok {
+		vsm.mutex.Lock()
+
+		if vsm.capturedRoots[root] != version {
+			vsm.rootEntries[root] = append(vsm.rootEntries[root],
+				&rootVersionEntry{version, vsm.CachedDiskStorageManager.Root(root)})
+			vsm.capturedRoots[root] = version
+		}
+
+		vsm.mutex.Unlock()
+	}
+
+	vsm.CachedDiskStorageManager.SetRoot(root, val)
+}
+
+/*
+Insert inserts an object and return its storage location.
+*/
+func (vsm *VersionedStorageManager) Insert(o interface{}) (uint64, error) {
+
+	loc, err := vsm.CachedDiskStorageManager.Insert(o)
+
+	return loc, vsm.inserted(loc, err)
+}
+
+/*
+InsertUncached inserts an object without adding it to the cache and returns
+its storage location.
+*/
+func (vsm *VersionedStorageManager) InsertUncached(o interface{}) (uint64, error) {
+
+	loc, err := vsm.CachedDiskStorageManager.InsertUncached(o)
+
+	return loc, vsm.inserted(loc, err)
+}
+
+/*
+inserted records that an object was inserted in the current version.
+*/
+func (vsm *VersionedStorageManager) inserted(loc uint64, err error) error {
+
+	// Open snapshots cannot see a new object - its state does not need
+	// to be kept if it is changed again in the current version
+
+	if version, ok := vsm.tracker.writeVersion(); ok && err == nil {
+		vsm.mutex.Lock()
+		vsm.captured[loc] = version
+		vsm.mutex.Unlock()
+	}
+
+	return err
+}
+
+/*
+Update updates a storage location.
+*/
+func (vsm *VersionedStorageManager) Update(loc uint64, o interface{}) error {
+
+	if err := vsm.capture(loc); err != nil {
+		return err
+	}
+
+	return vsm.CachedDiskStorageManager.Update(loc, o)
+}
+
+/*
+Free frees a storage location.
+*/
+func (vsm *VersionedStorageManager) Free(loc uint64) error {
+
+	if err := vsm.capture(loc); err != nil {
+		return err
+	}
+
+	return vsm.CachedDiskStorageManager.Free(loc)
+}
+
+/*
+Close the StorageManager and write all pending changes to disk.
+*/
+func (vsm *VersionedStorageManager) Close() error {
+	vsm.tracker.unregister(vsm)
+
+	return vsm.CachedDiskStorageManager.Close()
+}
+
+/*
+View returns a read-only StorageManager which shows the stored objects as they
+were in a given snapshot version. Returns nil if the storage did not exist in
+the given version.
+*/
+func (vsm *VersionedStorageManager) View(version uint64) Manager {
+	if vsm.since > version {
+		return nil
+	}
+	return &storageView{vsm, version}
+}
+
+/*
+OldVersions returns the number of old object versions which are currently kept.
+*/
+func (vsm *VersionedStorageManager) OldVersions() int {
+	vsm.mutex.RLock()
+	defer vsm.mutex.RUnlock()
+
+	var count int
+
+	for _, entries := range vsm.entries {
+		count += len(entries)
+	}
+
+	return count
+}
+
+/*
+capture keeps the current state of a given object as old version if it is
+changed for the first time in the current version and there are open snapshots.
+*/
+func (vsm *VersionedStorageManager) capture(loc uint64) error {
+
+	version, ok := vsm.tracker.writeVersion()
+	if !ok {
+		return nil
+	}
+
+	vsm.mutex.Lock()
+	defer vsm.mutex.Unlock()
+
+	if vsm.captured[loc] == version {
+		return nil
+	}
+
+	// Read the stored form of the object directly from disk - cached
+	// objects might have already been changed by the caller
+
+	var b bytes.Buffer
+
+	if err := vsm.diskstoragemanager.ByteDiskStorageManager.Fetch(loc, &b); err != nil {
+		return err
+	}
+
+	vsm.entries[loc] = append(vsm.entries[loc], &versionEntry{version, b.Bytes()})
+	vsm.captured[loc] = version
+
+	return nil
+}
+
+/*
+discard removes all old versions which are not needed by snapshots newer
+than a given version.
+*/
+func (vsm *VersionedStorageManager) discard(cutoff uint64) {
+	vsm.mutex.Lock()
+	defer vsm.mutex.Unlock()
+
+	for loc, entries := range vsm.entries {
+		i := 0
+		for i < len(entries) && entries[i].version <= cutoff {
+			i++
+		}
+
+		if i == len(entries) {
+			delete(vsm.entries, loc)
+		} else if i > 0 {
+			vsm.entries[loc] = entries[i:]
+		}
+	}
+
+	for loc, version := range vsm.captured {
+		if version <= cutoff {
+			delete(vsm.captured, loc)
+		}
+	}
+
+	for root, entries := range vsm.rootEntries {
+		i := 0
+		for i < len(entries) && entries[i].version <= cutoff {
+			i++
+		}
+
+		if i == len(entries) {
+			delete(vsm.rootEntries, root)
+		} else if i > 0 {
+			vsm.rootEntries[root] = entries[i:]
+		}
+	}
+
+	for root, version := range vsm.capturedRoots {
+		if version <= cutoff {
+			delete(vsm.capturedRoots, root)
+		}
+	}
+}
+
+/*
+storageView is a read-only StorageManager which shows the objects of a
+VersionedStorageManager as they were in a given snapshot version.
+*/
+type storageView struct {
+	vsm     *VersionedStorageManager // Versioned StorageManager
+	version uint64                   // Snapshot version
+}
+
+/*
+Name returns the name of the StorageManager instance.
+*/
+func (sv *storageView) Name() string {
+	return sv.vsm.Name()
+}
+
+/*
+Root returns a root value.
+*/
+func (sv *storageView) Root(root int) uint64 {
+	sv.vsm.mutex.RLock()
+	defer sv.vsm.mutex.RUnlock()
+
+	// The oldest change after the snapshot has the value of the snapshot
+
+	for _, entry := range sv.vsm.rootEntries[root] {
+		if entry.version > sv.version {
+			return entry.value
+		}
+	}
+
+	return sv.vsm.CachedDiskStorageManager.Root(root)
+}
+
+/*
+SetRoot is not supported by a snapshot view.
+*/
+func (sv *storageView) SetRoot(root int, val uint64) {
+}
+
+/*
+Insert is not supported by a snapshot view.
+*/
+func (sv *storageView) Insert(o interface{}) (uint64, error) {
+	return 0, ErrReadonly
+}
+
+/*
+Update is not supported by a snapshot view.
+*/
+func (sv *storageView) Update(loc uint64, o interface{}) error {
+	return ErrReadonly
+}
+
+/*
+Free is not supported by a snapshot view.
+*/
+func (sv *storageView) Free(loc uint64) error {
+	return ErrReadonly
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container.
+*/
+func (sv *storageView) Fetch(loc uint64, o interface{}) error {
+	sv.vsm.mutex.RLock()
+	defer sv.vsm.mutex.RUnlock()
+
+	dsm := sv.vsm.diskstoragemanager
+
+	// The oldest change after the snapshot has the state of the snapshot
+
+	for _, entry := range sv.vsm.entries[loc] {
+		if entry.version > sv.version {
+
+			codec, err := lookupCodec(dsm.Codec())
+			if err != nil {
+				return err
+			}
+
+			return codec.Decode(bytes.NewReader(entry.data), o)
+		}
+	}
+
+	// The object was not changed since the snapshot was opened - read it
+	// from disk since cached objects might be changed by a writer
+
+	return dsm.Fetch(loc, o)
+}
+
+/*
+FetchCached always returns a storage.ErrNotInCache error. Cached objects
+might be changed by a writer.
+*/
+func (sv *storageView) FetchCached(loc uint64) (interface{}, error) {
+	return nil, NewStorageManagerError(ErrNotInCache, "", sv.Name())
+}
+
+/*
+Flush does nothing for a snapshot view.
+*/
+func (sv *storageView) Flush() error {
+	return nil
+}
+
+/*
+Rollback does nothing for a snapshot view.
+*/
+func (sv *storageView) Rollback() error {
+	return nil
+}
+
+/*
+Close does nothing for a snapshot view. The snapshot must be released with
+its VersionTracker.
+*/
+func (sv *storageView) Close() error {
+	return nil
+}
@@ -0,0 +1,205 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"testing"
+)
+
+func TestVersionedStorageManager(t *testing.T) {
+	var res cachetestobj
+
+	vt := NewVersionTracker()
+
+	dsm := NewDiskStorageManager(DBDIR+"/vtest1", false, false, false, true)
+	vsm := NewVersionedStorageManager(NewCachedDiskStorageManager(dsm, 10), vt, true)
+
+	loc1, _ := vsm.Insert(&cachetestobj{1, "test1"})
+	loc2, _ := vsm.Insert(&cachetestobj{2, "test2"})
+	vsm.SetRoot(5, loc1)
+	vsm.Flush()
+
+	// Nothing is kept if there are no snapshots
+
+	vsm.Update(loc1, &cachetestobj{1, "test1a"})
+
+	if res := vsm.OldVersions(); res != 0 {
+		t.Error("Unexpected old versions:", res)
+		return
+	}
+
+	s1 := vt.OpenSnapshot()
+	view1 := vsm.View(s1)
+
+	// Change the cached object in place before updating it (this is what
+	// a HTree does)
+
+	obj, _ := vsm.FetchCached(loc1)
+	obj.(*cachetestobj).Val2 = "test1b"
+	vsm.Update(loc1, obj)
+	vsm.Update(loc1, &cachetestobj{1, "test1c"})
+	vsm.Free(loc2)
+	vsm.SetRoot(5, loc2)
+
+	loc3, _ := vsm.Insert(&cachetestobj{3, "test3"})
+	vsm.Update(loc3, &cachetestobj{3, "test3a"})
+
+	if res := vsm.OldVersions(); res != 2 {
+		t.Error("Unexpected old versions:", res)
+		return
+	}
+
+	if err := view1.Fetch(loc1, &res); err != nil || res.Val2 != "test1a" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := view1.Fetch(loc2, &res); err != nil || res.Val2 != "test2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := view1.Root(5); res != loc1 {
+		t.Error("Unexpected root:", res)
+		return
+	}
+
+	// The current state is unchanged
+
+	if err := vsm.Fetch(loc1, &res); err != nil || res.Val2 != "test1c" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := vsm.Root(5); res != loc2 {
+		t.Error("Unexpected root:", res)
+		return
+	}
+
+	// A second snapshot sees all changes up to the point when it was opened
+
+	s2 := vt.OpenSnapshot()
+	view2 := vsm.View(s2)
+
+	vsm.Update(loc1, &cachetestobj{1, "test1d"})
+
+	if err := view1.Fetch(loc1, &res); err != nil || res.Val2 != "test1a" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := view2.Fetch(loc1, &res); err != nil || res.Val2 != "test1c" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := view2.Fetch(loc3, &res); err != nil || res.Val2 != "test3a" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := vt.Snapshots(); res != 2 {
+		t.Error("Unexpected number of snapshots:", res)
+		return
+	}
+
+	// Releasing the first snapshot discards the versions which were only
+	// needed by it
+
+	vt.ReleaseSnapshot(s1)
+
+	if res := vsm.OldVersions(); res != 1 {
+		t.Error("Unexpected old versions:", res)
+		return
+	}
+
+	if err := view2.Fetch(loc1, &res); err != nil || res.Val2 != "test1c" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	vt.ReleaseSnapshot(s2)
+
+	if res := vsm.OldVersions(); res != 0 {
+		t.Error("Unexpected old versions:", res)
+		return
+	}
+
+	// Views are read-only
+
+	if _, err := view2.Insert(&res); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := view2.Update(loc1, &res); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := view2.Free(loc1); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	view2.SetRoot(5, 0)
+
+	if _, err := view2.FetchCached(loc1); err.(*ManagerError).Type != ErrNotInCache {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if view2.Name() != vsm.Name() || view2.Flush() != nil || view2.Rollback() != nil ||
+		view2.Close() != nil {
+		t.Error("Unexpected view result")
+		return
+	}
+
+	// Storages which were created after a snapshot was opened have no view
+
+	s3 := vt.OpenSnapshot()
+
+	dsm2 := NewDiskStorageManager(DBDIR+"/vtest2", false, false, false, true)
+	vsm2 := NewVersionedStorageManager(NewCachedDiskStorageManager(dsm2, 10), vt, true)
+
+	if vsm2.View(s3) != nil || vsm.View(s3) == nil {
+		t.Error("Unexpected views")
+		return
+	}
+
+	vt.ReleaseSnapshot(s3)
+
+	// Errors while reading the old version are reported
+
+	s4 := vt.OpenSnapshot()
+
+	if err := vsm.Update(loc2, &res); err == nil {
+		t.Error("Update of a free location should fail")
+		return
+	}
+
+	vt.ReleaseSnapshot(s4)
+
+	if err := vsm2.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := vsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(vt.managers) != 0 {
+		t.Error("Unexpected tracked managers:", vt.managers)
+		return
+	}
+}
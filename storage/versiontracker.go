@@ -0,0 +1,148 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import "sync"
+
+/*
+VersionTracker keeps track of the versions of a group of
+VersionedStorageManagers. Opening a snapshot starts a new version - all
+changes which are written after the snapshot was opened belong to the new
+version. A snapshot must only be opened while no changes are being written
+to any of the tracked StorageManagers.
+*/
+type VersionTracker struct {
+	mutex     *sync.Mutex                // Mutex to protect the tracker
+	version   uint64                     // Current write version
+	snapshots map[uint64]int             // Number of open snapshots for each version
+	managers  []*VersionedStorageManager // Tracked StorageManagers
+}
+
+/*
+NewVersionTracker creates a new VersionTracker.
+*/
+func NewVersionTracker() *VersionTracker {
+	return &VersionTracker{&sync.Mutex{}, 1, make(map[uint64]int), nil}
+}
+
+/*
+Version returns the current write version.
+*/
+func (vt *VersionTracker) Version() uint64 {
+	vt.mutex.Lock()
+	defer vt.mutex.Unlock()
+
+	return vt.version
+}
+
+/*
+Snapshots returns the number of open snapshots.
+*/
+func (vt *VersionTracker) Snapshots() int {
+	vt.mutex.Lock()
+	defer vt.mutex.Unlock()
+
+	var count int
+
+	for _, c := range vt.snapshots {
+		count += c
+	}
+
+	return count
+}
+
+/*
+OpenSnapshot opens a snapshot of the current state of all tracked
+StorageManagers and returns its version. The snapshot must be released
+once it is no longer needed.
+*/
+func (vt *VersionTracker) OpenSnapshot() uint64 {
+	vt.mutex.Lock()
+	defer vt.mutex.Unlock()
+
+	version := vt.version
+
+	vt.snapshots[version]++
+	vt.version++
+
+	return version
+}
+
+/*
+ReleaseSnapshot releases a snapshot of a given version. Old versions of stored
+objects which are no longer needed by any snapshot are discarded.
+*/
+func (vt *VersionTracker) ReleaseSnapshot(version uint64) {
+	vt.mutex.Lock()
+
+	if count, ok := vt.snapshots[version]; ok {
+		if count > 1 {
+			vt.snapshots[version]--
+		} else {
+			delete(vt.snapshots, version)
+		}
+	}
+
+	// Objects of versions up to the oldest open snapshot (or up to the
+	// current version) are no longer needed
+
+	cutoff := vt.version
+	for v := range vt.snapshots {
+		if v < cutoff {
+			cutoff = v
+		}
+	}
+
+	managers := make([]*VersionedStorageManager, len(vt.managers))
+	copy(managers, vt.managers)
+
+	vt.mutex.Unlock()
+
+	for _, vsm := range managers {
+		vsm.discard(cutoff)
+	}
+}
+
+/*
+writeVersion returns the current write version and if old versions of stored
+objects need to be kept because there are open snapshots.
+*/
+func (vt *VersionTracker) writeVersion() (uint64, bool) {
+	vt.mutex.Lock()
+	defer vt.mutex.Unlock()
+
+	return vt.version, len(vt.snapshots) > 0
+}
+
+/*
+register adds a VersionedStorageManager to this tracker.
+*/
+func (vt *VersionTracker) register(vsm *VersionedStorageManager) {
+	vt.mutex.Lock()
+	defer vt.mutex.Unlock()
+
+	vt.managers = append(vt.managers, vsm)
+}
+
+/*
+unregister removes a VersionedStorageManager from this tracker.
+*/
+func (vt *VersionTracker) unregister(vsm *VersionedStorageManager) {
+	vt.mutex.Lock()
+	defer vt.mutex.Unlock()
+
+	for i, m := range vt.managers {
+		if m == vsm {
+			vt.managers = append(vt.managers[:i], vt.managers[i+1:]...)
+			break
+		}
+	}
+}